@@ -0,0 +1,79 @@
+// ChainCore Full Node - Config file sections and the config command
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"chaincore/internal/config"
+)
+
+// envPrefix prefixes the environment variables that set flags
+const envPrefix = "CHAINCORE"
+
+const configUsage = `Usage: fullnode config init [path]
+
+Writes a config file with every setting at its default to path, or to
+stdout without one. Start the node with --config path to use it.
+`
+
+// configSchema assigns the node's flags to config file sections
+var configSchema = config.Schema{
+	{Name: "blockchain", Flags: []string{
+		"genesis", "block-time", "archive", "snapshot-interval", "share-retention", "dev",
+	}},
+	{Name: "consensus", Flags: []string{
+		"validator-key", "next-validator-key", "max-validators", "double-sign-slash", "unbonding-period",
+	}},
+	{Name: "mining", Flags: []string{
+		"mining", "settlement-epoch",
+	}},
+	{Name: "pool", Flags: []string{
+		"pool", "pool-fee", "pool-payout-scheme", "pool-min-payout", "pool-max-miners", "stratum",
+	}},
+	{Name: "network", Flags: []string{
+		"p2pport", "maxpeers", "p2p-allow-cidr", "p2p-deny-cidr", "p2p-allow-nodeid", "p2p-deny-nodeid",
+		"snapshot-sync", "stall-timeout", "snapshot-fallback",
+	}},
+	{Name: "rpc", Flags: []string{
+		"rpcport", "rpc-max-clients", "rpc-admin", "strict-checksum", "api-key-db", "rpc-require-key",
+		"rpc-restrict-heavy", "rpc-auth-namespaces", "rpc-jwt-secret", "ws-origins", "ws-token",
+		"rpc-tls-cert", "rpc-tls-key", "rpc-acme-domains", "rpc-client-ca", "rpc-no-http2",
+	}},
+	{Name: "storage", Flags: []string{
+		"datadir", "storage", "compact-window", "compact-interval", "db-slow-threshold",
+	}},
+	{Name: "token", Flags: []string{
+		"founder", "founder-key", "reserved-webhook", "reserved-webhook-secret",
+		"bridge-rpc", "bridge-network", "bridge-token", "bridge-deposit", "bridge-confirmations", "bridge-start-block",
+	}},
+	{Name: "logging", Flags: []string{
+		"log-level", "log-json", "log-file", "log-max-size", "log-max-backups", "otlp-endpoint", "trace-sample",
+	}},
+}
+
+// runConfigCommand handles "fullnode config ...". Flags must be defined
+// first, as the generated file is built from them.
+func runConfigCommand(args []string) int {
+	if len(args) == 0 || args[0] != "init" || len(args) > 2 {
+		fmt.Fprint(os.Stderr, configUsage)
+		return 2
+	}
+
+	out := os.Stdout
+	if len(args) == 2 {
+		file, err := os.OpenFile(args[1], os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create config file: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		out = file
+	}
+	if err := config.Generate(out, flag.CommandLine, configSchema, envPrefix); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write config file: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -1,25 +1,43 @@
-// ChainCore Full Node - Founder Only
+// ChainCore Full Node
 // Hybrid PoS + PoW Blockchain Implementation
 package main
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"math"
+	"math/big"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"chaincore/internal/blockchain"
+	"chaincore/internal/buildinfo"
+	"chaincore/internal/config"
+	"chaincore/internal/chainsync"
 	"chaincore/internal/consensus"
+	"chaincore/internal/genesis"
+	"chaincore/internal/logging"
 	"chaincore/internal/mining"
 	"chaincore/internal/network"
 	"chaincore/internal/rpc"
 	"chaincore/internal/storage"
+	"chaincore/internal/token"
+	"chaincore/internal/tracing/provider"
+	"chaincore/internal/wallet"
 )
 
 var (
-	version     = "1.0.0"
 	nodeType    = "fullnode"
 	defaultPort = 8545
 	rpcPort     = 8546
@@ -32,22 +50,126 @@ func main() {
 	rpcPortFlag := flag.Int("rpcport", rpcPort, "RPC server port for lite nodes")
 	p2pPort := flag.Int("p2pport", defaultPort, "P2P network port")
 	validatorKey := flag.String("validator-key", "", "Path to validator private key")
+	nextValidatorKey := flag.String("next-validator-key", "", "Path to a staged validator key that takes over after a scheduled key rotation")
 	enableMining := flag.Bool("mining", true, "Enable mining reward distribution")
 	maxPeers := flag.Int("maxpeers", 50, "Maximum number of peers")
-	founderMode := flag.Bool("founder", false, "Enable founder mode with full privileges")
+	founderMode := flag.Bool("founder", false, "Serve the founder's token_ mint, burn and pricing methods (needs --founder-key)")
+	founderKey := flag.String("founder-key", "", "File with the hex-encoded 32-byte founder authorization key that signs founder tokens, created if missing")
+	strictChecksum := flag.Bool("strict-checksum", false, "Require EIP-55 checksummed addresses in RPC requests")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "OTLP/HTTP collector URL for tracing, e.g. http://localhost:4318 (disabled if empty)")
+	logLevel := flag.String("log-level", "info", "Log level spec: a default level and module overrides, e.g. info,chainsync=debug,rpc=warn")
+	logJSON := flag.Bool("log-json", false, "Write logs as JSON lines")
+	logFile := flag.String("log-file", "", "Write logs to this file, rotated by size, instead of stderr")
+	logMaxSize := flag.Int("log-max-size", 100, "Rotate the log file once it grows past this many MB")
+	logMaxBackups := flag.Int("log-max-backups", 5, "Rotated log files to keep")
+	traceSample := flag.Float64("trace-sample", 1.0, "Fraction of traces to sample (0-1)")
+	allowCIDRs := flag.String("p2p-allow-cidr", "", "Comma-separated CIDRs or IPs allowed to peer (all if empty)")
+	denyCIDRs := flag.String("p2p-deny-cidr", "", "Comma-separated CIDRs or IPs refused as peers")
+	allowNodeIDs := flag.String("p2p-allow-nodeid", "", "Comma-separated node IDs allowed to peer (all if empty)")
+	denyNodeIDs := flag.String("p2p-deny-nodeid", "", "Comma-separated node IDs refused as peers")
+	genesisPath := flag.String("genesis", "", "Genesis config JSON (built-in mainnet genesis if empty)")
+	rpcMaxClients := flag.Int("rpc-max-clients", 100000, "Maximum distinct clients tracked by the RPC rate limiter")
+	shareRetention := flag.Uint64("share-retention", 50400, "Blocks of raw mining shares to keep before pruning")
+	settlementEpoch := flag.Uint64("settlement-epoch", blockchain.DefaultSettlementEpoch, "Blocks per mining reward settlement epoch, the same on every node (7200 is a day of 12 second blocks, 50400 a week)")
+	compactWindow := flag.String("compact-window", "", "Off-peak local hours for scheduled database compaction, e.g. 2-5 (disabled if empty)")
+	compactInterval := flag.Duration("compact-interval", 24*time.Hour, "Minimum time between scheduled database compactions")
+	dbSlowThreshold := flag.Duration("db-slow-threshold", 100*time.Millisecond, "Log database operations at least this slow, with their key prefix and size")
+	rpcAdmin := flag.Bool("rpc-admin", false, "Serve admin_ RPC methods such as admin_compactDb to founder tokens, JWTs and API keys granted admin")
+	doubleSignSlash := flag.Uint("double-sign-slash", blockchain.DefaultDoubleSignSlashPercent, "Percent of bonded stake burned when a validator is proven to have double-signed")
+	maxValidators := flag.Int("max-validators", 100, "Number of top-staked validators selected into the active set each epoch")
+	archive := flag.Bool("archive", false, "Keep account balance and nonce history so RPC queries can read past blocks")
+	unbondingPeriod := flag.Duration("unbonding-period", blockchain.DefaultUnbondingPeriod, "How long unstaked funds stay locked and slashable before release")
+	snapshotInterval := flag.Uint64("snapshot-interval", blockchain.DefaultSnapshotInterval, "Blocks between state snapshots served to fast-syncing peers (0 disables)")
+	snapshotSync := flag.Bool("snapshot-sync", false, "Start a new node from a peer's state snapshot instead of replaying blocks from genesis")
+	stallTimeout := flag.Duration("stall-timeout", 5*time.Minute, "Time without importing a block, while peers are ahead, before block sync recovers by switching peers")
+	snapshotFallback := flag.Bool("snapshot-fallback", false, "Let block sync recovery restore a peer's state snapshot if the node is stalled at genesis")
+	reservedWebhooks := flag.String("reserved-webhook", "", "Comma-separated URLs notified of every reserved wallet movement")
+	reservedWebhookSecret := flag.String("reserved-webhook-secret", "", "Secret for the HMAC-SHA256 signature sent with reserved wallet webhooks")
+	bridgeRPC := flag.String("bridge-rpc", "", "JSON-RPC endpoint of an Ethereum node, or a Tron node's /jsonrpc, whose USDT deposits the founder node mints for (bridge disabled if empty)")
+	bridgeNetwork := flag.String("bridge-network", token.NetworkEthereum, "Network of --bridge-rpc: ethereum or tron")
+	bridgeToken := flag.String("bridge-token", "", "USDT contract address on the bridge network")
+	bridgeDeposit := flag.String("bridge-deposit", "", "Address on the bridge network that deposits are sent to")
+	bridgeConfirmations := flag.Uint64("bridge-confirmations", 0, "Confirmations a deposit needs before it is minted (12 on Ethereum, 19 on Tron if 0)")
+	bridgeStartBlock := flag.Uint64("bridge-start-block", 0, "Bridge network block the first scan starts at (its confirmed head if 0)")
+	bridgeWallet := flag.String("bridge-wallet", "", "Keystore of the genesis bridge authority, which signs the bridge's mint transactions")
+	bridgePasswordFile := flag.String("bridge-password-file", "", "File holding the password of --bridge-wallet")
+	poolEnabled := flag.Bool("pool", false, "Run a mining pool that credits miners' shares to --pool-wallet and pays them from it (needs --mining)")
+	poolWallet := flag.String("pool-wallet", "", "Keystore of the pool's payout account")
+	poolPasswordFile := flag.String("pool-password-file", "", "File holding the password of --pool-wallet")
+	poolFee := flag.Float64("pool-fee", 1, "Percent of miners' rewards the pool withholds")
+	poolPayoutScheme := flag.String("pool-payout-scheme", mining.PayoutPPS, "How the pool splits rewards: pps or pplns")
+	poolMinPayout := flag.String("pool-min-payout", "100000000000000000", "Smallest balance, in wei, the pool pays a miner")
+	poolMaxMiners := flag.Int("pool-max-miners", 10000, "Payout addresses the pool accepts at once")
+	stratumListeners := flag.String("stratum", "", "Comma-separated Stratum listeners for pool miners as addr=algorithm, e.g. :3333=kheavyhash,:3334=randomx")
+	apiKeyDB := flag.String("api-key-db", "", "Database config JSON for RPC API keys and usage; enables API keys (disabled if empty)")
+	requireAPIKey := flag.Bool("rpc-require-key", false, "Reject RPC requests without an API key (needs --api-key-db)")
+	restrictHeavy := flag.Bool("rpc-restrict-heavy", true, "Serve heavy queries such as eth_getLogs only to API keys whose allowlist grants them")
+	wsOrigins := flag.String("ws-origins", "", "Comma-separated browser origins, besides this node's host, allowed to open WebSockets (* for any)")
+	wsToken := flag.String("ws-token", "", "Token WebSocket clients must present to use validator and mining channels (open if empty)")
+	tlsCert := flag.String("rpc-tls-cert", "", "PEM certificate chain for serving RPC over HTTPS (with --rpc-tls-key)")
+	tlsKey := flag.String("rpc-tls-key", "", "PEM private key for --rpc-tls-cert")
+	acmeDomains := flag.String("rpc-acme-domains", "", "Comma-separated domains to serve RPC over HTTPS with Let's Encrypt certificates (RPC port must be reachable as 443)")
+	clientCA := flag.String("rpc-client-ca", "", "PEM CA bundle; the validator API then requires client certificates it issued (needs HTTPS)")
+	noHTTP2 := flag.Bool("rpc-no-http2", false, "Serve HTTPS RPC over HTTP/1.1 only")
+	jwtSecretPath := flag.String("rpc-jwt-secret", "", "File with the hex-encoded 32-byte secret RPC JWTs are signed with, created if missing (JWT auth disabled if empty)")
+	authNamespaces := flag.String("rpc-auth-namespaces", "", "Comma-separated RPC namespaces, e.g. mining,pos, served only to requests with an API key or JWT; admin always is")
+	blockTime := flag.Duration("block-time", 12*time.Second, "Minimum time between blocks, in whole seconds")
+	devMode := flag.Bool("dev", false, "Development chain: this node is the only validator, seals a block for each transaction and serves evm_mine and evm_increaseTime")
+	showVersion := flag.Bool("version", false, "Print the build information and exit")
+	configPath := flag.String("config", "", "YAML config file; flags and "+envPrefix+"_* environment variables override it")
+
+	// The config subcommand documents the flags, so it runs once they exist
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfigCommand(os.Args[2:]))
+	}
 	flag.Parse()
+	if err := config.Load(flag.CommandLine, configSchema, *configPath, envPrefix); err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	build := buildinfo.Get()
+	if *showVersion {
+		fmt.Println(build.ClientVersion())
+		fmt.Printf("Commit: %s\nDate: %s\nFeatures: %s\n", build.Commit, build.Date, strings.Join(build.Features, ","))
+		return
+	}
+
+	if err := logging.Setup(logging.Config{
+		Level:      *logLevel,
+		JSON:       *logJSON,
+		File:       *logFile,
+		MaxSizeMB:  *logMaxSize,
+		MaxBackups: *logMaxBackups,
+	}); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+	defer logging.Close()
 
 	fmt.Printf(`
 ╔═══════════════════════════════════════════════════════════════╗
 ║           ChainCore Full Node v%s                         ║
-║        Hybrid PoS + PoW Blockchain - Founder Edition          ║
+║              Hybrid PoS + PoW Blockchain                      ║
 ╚═══════════════════════════════════════════════════════════════╝
-`, version)
+`, build.Version)
+	log.Printf("Build %s, committed %s", build.ClientVersion(), build.Date)
 
-	// Validate founder authentication
-	if !*founderMode {
-		log.Fatal("Full node requires founder authentication. Use --founder flag with valid credentials.")
+	// Anyone may sync, serve RPC and validate; only the founder's node
+	// serves the privileged token methods
+	if *founderMode && *founderKey == "" {
+		log.Fatal("--founder needs --founder-key")
+	}
+
+	// Initialize tracing
+	shutdownTracing, err := provider.Setup(context.Background(), provider.Config{
+		Endpoint:       *otlpEndpoint,
+		ServiceName:    nodeType,
+		ServiceVersion: build.VersionWithCommit(),
+		SampleRatio:    *traceSample,
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
 	}
+	defer shutdownTracing(context.Background())
 
 	// Initialize storage with size limit
 	storageConfig := storage.Config{
@@ -55,19 +177,68 @@ func main() {
 		MaxSizeGB:   *storageSize,
 		EnablePrune: true,
 	}
-	db, err := storage.NewLevelDB(storageConfig)
+	levelDB, err := storage.NewLevelDB(storageConfig)
 	if err != nil {
 		log.Fatalf("Failed to initialize storage: %v", err)
 	}
+	meteredDB := storage.NewMeteredDatabase(levelDB, storage.MetricsConfig{SlowThreshold: *dbSlowThreshold})
+	var db storage.Database = meteredDB
+	if *otlpEndpoint != "" {
+		db = storage.NewTracedDatabase(meteredDB)
+		log.Printf("Tracing enabled, exporting to %s", *otlpEndpoint)
+	}
 	defer db.Close()
 
+	// Compact the database during off-peak hours
+	compactionConfig, err := parseCompactWindow(*compactWindow)
+	if err != nil {
+		log.Fatalf("Invalid --compact-window: %v", err)
+	}
+	compactionConfig.Interval = *compactInterval
+	compactor, err := storage.NewCompactor(levelDB, compactionConfig)
+	if err != nil {
+		log.Fatalf("Failed to initialize compaction: %v", err)
+	}
+
+	// Load genesis configuration for reserved wallet vesting
+	genesisConfig := genesis.DefaultGenesisConfig()
+	if *genesisPath != "" {
+		genesisConfig, err = genesis.LoadFromFile(*genesisPath)
+		if err != nil {
+			log.Fatalf("Failed to load genesis config: %v", err)
+		}
+	}
+
 	// Initialize blockchain
+	if *doubleSignSlash > 100 {
+		log.Fatalf("--double-sign-slash must be a percentage, got %d", *doubleSignSlash)
+	}
+	burnRate := genesisConfig.Tokenomics.BurnRateOnTransfer
+	if burnRate < 0 || burnRate > 1 {
+		log.Fatalf("Genesis burn_rate_on_transfer must be between 0 and 1, got %g", burnRate)
+	}
+	if genesisConfig.Tokenomics.TreasuryFeePercent > 100 {
+		log.Fatalf("Genesis treasury_fee_percent must be a percentage, got %d", genesisConfig.Tokenomics.TreasuryFeePercent)
+	}
 	chainConfig := blockchain.Config{
 		ChainID:           13370, // GYDS Mainnet Chain ID
-		BlockTime:         12,    // 12 seconds
+		BlockTime:         uint64(*blockTime / time.Second),
 		MaxBlockSize:      2 * 1024 * 1024, // 2MB
 		MinGasPrice:       1000000000, // 1 Gwei
-		ValidatorMinStake: 32000000000000000000, // 32 ETH equivalent
+		ValidatorMinStake: new(big.Int).Mul(big.NewInt(32), big.NewInt(1e18)), // 32 ETH equivalent
+		UnbondingPeriod:      *unbondingPeriod,
+		ShareRetentionBlocks: *shareRetention,
+		Vesting:              token.NewVesting(genesisConfig),
+		DoubleSignSlashPercent: uint8(*doubleSignSlash),
+		Archive:                *archive,
+		SnapshotInterval:       *snapshotInterval,
+		SettlementEpochBlocks:  *settlementEpoch,
+		BlockReward:            genesisConfig.Tokenomics.BlockReward,
+		HalvingInterval:        genesisConfig.Tokenomics.HalvingInterval,
+		TransferBurnRate:       uint64(math.Round(burnRate * blockchain.BurnRateDenominator)),
+		TreasuryFeePercent:     genesisConfig.Tokenomics.TreasuryFeePercent,
+		BridgeAuthority:        genesisConfig.BridgeAuthority,
+		MiningAddressCap:       new(big.Int).Mul(big.NewInt(10), big.NewInt(1e18)), // Matches the distributor's daily cap
 	}
 	chain, err := blockchain.NewBlockchain(db, chainConfig)
 	if err != nil {
@@ -80,51 +251,303 @@ func main() {
 		MinValidators:      4,
 		BlockFinality:      2, // 2 blocks for finality
 		SlashingEnabled:    true,
-		RewardPerBlock:     2000000000000000000, // 2 tokens
+		RewardPerBlock:     new(big.Int).Mul(big.NewInt(2), big.NewInt(1e18)), // 2 tokens
+		UnbondingPeriod:    *unbondingPeriod,
+		NextValidatorKeyPath: *nextValidatorKey,
+		MaxValidators:        *maxValidators,
+		BlockTime:            *blockTime,
+		DevMode:              *devMode,
 	}
 	posEngine, err := consensus.NewPoSEngine(chain, posConfig)
 	if err != nil {
 		log.Fatalf("Failed to initialize PoS engine: %v", err)
 	}
 
+	// A dev chain needs a validator; without a key, seal with a throwaway one
+	if *devMode && posEngine.LocalAddress() == ([20]byte{}) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			log.Fatalf("Failed to generate dev validator key: %v", err)
+		}
+		posEngine.AddSigner(consensus.NewLocalSigner(key))
+	}
+
 	// Initialize mining reward distributor (PoW for rewards only)
 	miningConfig := mining.Config{
 		Enabled:              *enableMining,
 		TargetShareTime:      10, // 10 seconds
 		MaxSharesPerMinute:   100,
-		SessionRewardCap:     1000000000000000000, // 1 token per session
-		DailyAddressCap:      10000000000000000000, // 10 tokens per day
+		SessionRewardCap:     big.NewInt(1e18), // 1 token per session
+		DailyAddressCap:      new(big.Int).Mul(big.NewInt(10), big.NewInt(1e18)), // 10 tokens per day
 		AntiBotEnabled:       true,
 		DifficultyAdjustment: true,
+		MinDifficulty:        big.NewInt(1 << 16),
+		MaxDifficulty:        big.NewInt(1 << 40),
 	}
 	miningDistributor := mining.NewDistributor(chain, miningConfig)
 
-	// Initialize P2P network
+	// Initialize P2P network with a node ID that survives restarts, so
+	// other operators can allowlist it
+	nodeID, err := network.LoadOrCreateNodeID(filepath.Join(*dataDir, "nodeid"))
+	if err != nil {
+		log.Fatalf("Failed to load node ID: %v", err)
+	}
+	capabilities := network.CapServesHistory
+	if *snapshotInterval > 0 {
+		capabilities |= network.CapServesStateSnapshots
+	}
+	if *enableMining {
+		capabilities |= network.CapMiningPool
+	}
 	networkConfig := network.Config{
 		Port:           *p2pPort,
 		MaxPeers:       *maxPeers,
 		NodeType:       network.FullNode,
 		EnableRelay:    true,
 		EnableRPCProxy: true,
+		NodeID:         nodeID,
+		Access: network.AccessConfig{
+			AllowCIDRs:   splitList(*allowCIDRs),
+			DenyCIDRs:    splitList(*denyCIDRs),
+			AllowNodeIDs: splitList(*allowNodeIDs),
+			DenyNodeIDs:  splitList(*denyNodeIDs),
+		},
+		Capabilities: capabilities,
 	}
 	p2pNetwork, err := network.NewP2PNetwork(networkConfig)
 	if err != nil {
 		log.Fatalf("Failed to initialize P2P network: %v", err)
 	}
+	log.Printf("P2P node ID: %s (protocol v%d, capabilities %s)", p2pNetwork.NodeID(),
+		network.ProtocolVersion, p2pNetwork.Capabilities())
+
+	// Proposed blocks carry the distributor's credited shares
+	posEngine.SetShareSource(miningDistributor.TakeBlockShares)
+
+	// Validators gossip votes so blocks can reach 2/3 agreement across the
+	// network; received votes are relayed by the engine
+	posEngine.SetVoteBroadcaster(func(vote *consensus.Vote) {
+		if err := p2pNetwork.BroadcastVote(consensus.EncodeVote(vote)); err != nil {
+			log.Printf("Failed to gossip vote for block %d: %v", vote.Height, err)
+		}
+	})
+	if err := p2pNetwork.RegisterHandler(network.MsgValidatorVote, func(msg *network.Message) error {
+		vote, err := consensus.DecodeVote(msg.Payload)
+		if err != nil {
+			return err
+		}
+		return posEngine.ReceiveVote(vote)
+	}); err != nil {
+		log.Fatalf("Failed to register vote handler: %v", err)
+	}
+
+	// Download the chain from peers and serve it to them
+	syncer, err := chainsync.NewSyncer(chain, p2pNetwork, chainsync.Config{
+		SnapshotSync:     *snapshotSync,
+		StallTimeout:     *stallTimeout,
+		SnapshotFallback: *snapshotFallback,
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize block sync: %v", err)
+	}
+
+	// Proposed blocks are announced to peers as compact blocks, which they
+	// rebuild from the transactions the syncer gossips
+	posEngine.SetBlockBroadcaster(syncer.AnnounceBlock)
+
+	// Watch the vesting reserved wallets and publish their movements
+	reservedMonitor, err := token.NewReservedWalletMonitor(chain, genesisConfig, token.MonitorConfig{
+		WebhookURLs:   splitList(*reservedWebhooks),
+		WebhookSecret: *reservedWebhookSecret,
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize reserved wallet monitor: %v", err)
+	}
 
 	// Initialize RPC server for lite nodes
 	rpcConfig := rpc.Config{
-		Port:               *rpcPortFlag,
-		MaxConnections:     1000,
-		EnableWebSocket:    true,
-		EnableMiningAPI:    true,
-		EnableValidatorAPI: true,
-		RateLimitPerSecond: 100,
+		Port:                    *rpcPortFlag,
+		MaxConnections:          1000,
+		EnableWebSocket:         true,
+		EnableMiningAPI:         true,
+		EnableValidatorAPI:      true,
+		RateLimitPerSecond:      100,
+		RateLimitClients:        *rpcMaxClients,
+		StrictChecksum:          *strictChecksum,
+		EnableAdminAPI:          *rpcAdmin,
+		RestrictOperatorMethods: *restrictHeavy,
+		EnableDevAPI:            *devMode,
+		WSAllowedOrigins:        splitList(*wsOrigins),
+		WSAuthToken:             *wsToken,
+		TLS: rpc.TLSConfig{
+			CertFile:         *tlsCert,
+			KeyFile:          *tlsKey,
+			AutocertDomains:  splitList(*acmeDomains),
+			AutocertCacheDir: filepath.Join(*dataDir, "acme"),
+			ClientCAFile:     *clientCA,
+			DisableHTTP2:     *noHTTP2,
+		},
+		AuthNamespaces: splitList(*authNamespaces),
+	}
+	if *clientCA != "" && !rpcConfig.TLS.Enabled() {
+		log.Fatal("--rpc-client-ca needs HTTPS: set --rpc-tls-cert and --rpc-tls-key, or --rpc-acme-domains")
 	}
 	rpcServer, err := rpc.NewServer(chain, posEngine, miningDistributor, rpcConfig)
 	if err != nil {
 		log.Fatalf("Failed to initialize RPC server: %v", err)
 	}
+	rpcServer.SetCompactor(compactor)
+	rpcServer.SetStorageMetrics(meteredDB)
+	rpcServer.SetNetwork(p2pNetwork)
+
+	// Settings operators change at runtime through admin_setSetting; the
+	// stored values take precedence over the defaults given here
+	settings, err := storage.NewSettings(db)
+	if err != nil {
+		log.Fatalf("Failed to load settings: %v", err)
+	}
+	if err := defineSettings(settings, rpcServer, meteredDB, rpcConfig.RateLimitPerSecond, *dbSlowThreshold, *logLevel); err != nil {
+		log.Fatalf("Failed to apply settings: %v", err)
+	}
+	rpcServer.SetSettings(settings)
+	rpcServer.SetSyncProgress(syncer.Progress)
+	rpcServer.SetHealth(syncer.Health)
+	rpcServer.SetReservedMonitor(reservedMonitor)
+
+	// Founder token methods, authorized by the founder key alone
+	var bridge *token.Bridge
+	if *founderMode {
+		key, err := rpc.LoadJWTSecret(*founderKey)
+		if err != nil {
+			log.Fatalf("Failed to load founder key: %v", err)
+		}
+		founder := genesisConfig.GetFounderWallet()
+		if founder == nil {
+			log.Fatal("Founder mode needs a founder wallet in the genesis config")
+		}
+		tokenManager, err := token.NewTokenManager(db, genesisConfig)
+		if err != nil {
+			log.Fatalf("Failed to load token operations: %v", err)
+		}
+		rpcServer.SetFounder(tokenManager, key, founder.Address)
+		log.Printf("Founder mode: serving token_ methods to requests signed with the founder key")
+
+		// Mints for USDT deposits only once verified on the foreign chain
+		if *bridgeRPC != "" {
+			if *bridgeWallet == "" {
+				log.Fatal("--bridge-rpc needs --bridge-wallet to sign mint transactions")
+			}
+			var password []byte
+			if *bridgePasswordFile != "" {
+				if password, err = os.ReadFile(*bridgePasswordFile); err != nil {
+					log.Fatalf("Failed to read bridge wallet password: %v", err)
+				}
+			}
+			signer, err := wallet.Load(*bridgeWallet, strings.TrimSpace(string(password)))
+			if err != nil {
+				log.Fatalf("Failed to load bridge wallet: %v", err)
+			}
+			bridge, err = token.NewBridge(db, tokenManager, chain, signer, token.BridgeConfig{
+				Network:        *bridgeNetwork,
+				RPCURL:         *bridgeRPC,
+				Token:          *bridgeToken,
+				DepositAddress: *bridgeDeposit,
+				Confirmations:  *bridgeConfirmations,
+				StartBlock:     *bridgeStartBlock,
+			})
+			if err != nil {
+				log.Fatalf("Failed to initialize bridge: %v", err)
+			}
+			rpcServer.SetBridge(bridge)
+		}
+	} else if *bridgeRPC != "" {
+		log.Fatal("--bridge-rpc needs --founder: only the founder node mints")
+	} else {
+		log.Println("Running as a community full node; founder token methods are not served")
+	}
+
+	// Mining pool, serving miners over Stratum and paying them from its wallet
+	var pool *mining.Pool
+	var stratumServers []*mining.StratumServer
+	if *poolEnabled {
+		if !*enableMining {
+			log.Fatal("--pool needs --mining")
+		}
+		if *poolWallet == "" {
+			log.Fatal("--pool needs --pool-wallet to pay miners")
+		}
+		minPayout, ok := new(big.Int).SetString(*poolMinPayout, 10)
+		if !ok || minPayout.Sign() < 0 {
+			log.Fatalf("Invalid --pool-min-payout %q", *poolMinPayout)
+		}
+		var password []byte
+		if *poolPasswordFile != "" {
+			if password, err = os.ReadFile(*poolPasswordFile); err != nil {
+				log.Fatalf("Failed to read pool wallet password: %v", err)
+			}
+		}
+		payer, err := wallet.Load(*poolWallet, strings.TrimSpace(string(password)))
+		if err != nil {
+			log.Fatalf("Failed to load pool wallet: %v", err)
+		}
+		pool = mining.NewPool(chain, miningDistributor, mining.PoolConfig{
+			Name:         "ChainCore Pool",
+			Fee:          *poolFee,
+			MinPayout:    minPayout,
+			MaxMiners:    *poolMaxMiners,
+			Enabled:      true,
+			PayoutScheme: *poolPayoutScheme,
+			Wallet:       payer,
+		})
+		for _, listener := range splitList(*stratumListeners) {
+			addr, algorithm, ok := strings.Cut(listener, "=")
+			if !ok || !mining.ValidAlgorithm(algorithm) {
+				log.Fatalf("Invalid --stratum listener %q: want addr=algorithm", listener)
+			}
+			stratumServers = append(stratumServers, mining.NewStratumServer(pool, mining.StratumConfig{
+				Addr:      addr,
+				Algorithm: algorithm,
+			}))
+		}
+		rpcServer.SetPool(pool)
+	} else if *stratumListeners != "" {
+		log.Fatal("--stratum needs --pool")
+	}
+
+	// API keys for serving public RPC
+	var apiKeys *rpc.APIKeyManager
+	var apiKeyDBManager *rpc.DatabaseManager
+	if *apiKeyDB != "" {
+		data, err := os.ReadFile(*apiKeyDB)
+		if err != nil {
+			log.Fatalf("Failed to read API key database config: %v", err)
+		}
+		apiKeyDBManager = rpc.NewDatabaseManager()
+		if err := apiKeyDBManager.FromJSON(data); err != nil {
+			log.Fatalf("Failed to connect API key database: %v", err)
+		}
+		apiKeys = rpc.NewAPIKeyManager(apiKeyDBManager, rpc.APIKeyConfig{Required: *requireAPIKey})
+		rpcServer.SetAPIKeys(apiKeys)
+	} else if *requireAPIKey {
+		log.Fatalf("--rpc-require-key needs --api-key-db")
+	} else if *restrictHeavy && *jwtSecretPath == "" {
+		log.Println("Heavy RPC queries need an operator API key, and API keys are disabled; pass --rpc-restrict-heavy=false to serve them to everyone")
+	}
+
+	// JWTs for the operator's own software
+	if *jwtSecretPath != "" {
+		secret, err := rpc.LoadJWTSecret(*jwtSecretPath)
+		if err != nil {
+			log.Fatalf("Failed to load JWT secret: %v", err)
+		}
+		rpcServer.SetJWTSecret(secret)
+	}
+	if len(rpcConfig.AuthNamespaces) > 0 && apiKeys == nil && *jwtSecretPath == "" {
+		log.Fatal("--rpc-auth-namespaces needs --api-key-db or --rpc-jwt-secret")
+	}
+	if *rpcAdmin && *jwtSecretPath == "" && !*founderMode {
+		log.Fatal("--rpc-admin needs --rpc-jwt-secret or --founder to authenticate admin_ calls")
+	}
 
 	// Start all services
 	log.Println("Starting ChainCore Full Node...")
@@ -133,6 +556,7 @@ func main() {
 		log.Fatalf("Failed to start P2P network: %v", err)
 	}
 	log.Printf("P2P network listening on port %d", *p2pPort)
+	syncer.Start()
 
 	if err := posEngine.Start(); err != nil {
 		log.Fatalf("Failed to start PoS engine: %v", err)
@@ -143,11 +567,42 @@ func main() {
 		log.Fatalf("Failed to start mining distributor: %v", err)
 	}
 	log.Println("Mining reward distributor started")
+	if pool != nil {
+		if err := pool.Start(); err != nil {
+			log.Fatalf("Failed to start mining pool: %v", err)
+		}
+		for _, stratum := range stratumServers {
+			if err := stratum.Start(); err != nil {
+				log.Fatalf("Failed to start Stratum server: %v", err)
+			}
+			log.Printf("Stratum server listening on %s", stratum.Addr())
+		}
+	}
 
+	if apiKeys != nil {
+		if err := apiKeys.Start(); err != nil {
+			log.Fatalf("Failed to start API keys: %v", err)
+		}
+		log.Printf("RPC API keys enabled (required: %v)", *requireAPIKey)
+	}
 	if err := rpcServer.Start(); err != nil {
 		log.Fatalf("Failed to start RPC server: %v", err)
 	}
-	log.Printf("RPC server listening on port %d", *rpcPortFlag)
+	if rpcConfig.TLS.Enabled() {
+		log.Printf("RPC server listening on port %d (HTTPS)", *rpcPortFlag)
+	} else {
+		log.Printf("RPC server listening on port %d", *rpcPortFlag)
+	}
+	reservedMonitor.Start()
+	if bridge != nil {
+		bridge.Start()
+		log.Printf("Bridge following %s deposits to %s", *bridgeNetwork, *bridgeDeposit)
+	}
+
+	compactor.Start()
+	if compactionConfig.Enabled {
+		log.Printf("Database compaction scheduled between %02d:00 and %02d:00", compactionConfig.WindowStart, compactionConfig.WindowEnd)
+	}
 
 	log.Printf(`
 ╔═══════════════════════════════════════════════════════════════╗
@@ -164,8 +619,112 @@ func main() {
 
 	log.Println("Shutting down ChainCore Full Node...")
 	rpcServer.Stop()
+	if apiKeys != nil {
+		apiKeys.Stop()
+		apiKeyDBManager.Disconnect()
+	}
+	reservedMonitor.Stop()
+	if bridge != nil {
+		bridge.Stop()
+	}
+	compactor.Stop()
+	for _, stratum := range stratumServers {
+		stratum.Stop()
+	}
+	if pool != nil {
+		pool.Stop()
+	}
 	miningDistributor.Stop()
 	posEngine.Stop()
+	syncer.Stop()
 	p2pNetwork.Stop()
 	log.Println("Goodbye!")
 }
+
+// parseCompactWindow parses an "start-end" hour range for scheduled
+// compaction. An empty value leaves scheduling disabled.
+func parseCompactWindow(value string) (storage.CompactionConfig, error) {
+	if value == "" {
+		return storage.CompactionConfig{}, nil
+	}
+	start, end, found := strings.Cut(value, "-")
+	if !found {
+		return storage.CompactionConfig{}, fmt.Errorf("expected start-end hours, got %q", value)
+	}
+	startHour, err := strconv.Atoi(strings.TrimSpace(start))
+	if err != nil {
+		return storage.CompactionConfig{}, err
+	}
+	endHour, err := strconv.Atoi(strings.TrimSpace(end))
+	if err != nil {
+		return storage.CompactionConfig{}, err
+	}
+	return storage.CompactionConfig{Enabled: true, WindowStart: startHour, WindowEnd: endHour}, nil
+}
+
+// defineSettings defines the runtime settings of the node, applying any
+// stored values
+func defineSettings(settings *storage.Settings, rpcServer *rpc.Server, db *storage.MeteredDatabase, rateLimit int, slowThreshold time.Duration, logLevel string) error {
+	definitions := []storage.Setting{
+		{
+			Key:         "rpc.rateLimit",
+			Description: "Requests per second allowed to each RPC client without an API key",
+			Default:     strconv.Itoa(rateLimit),
+			Validate: func(value string) error {
+				if n, err := strconv.Atoi(value); err != nil || n <= 0 {
+					return errors.New("must be a positive integer")
+				}
+				return nil
+			},
+			Apply: func(value string) {
+				n, _ := strconv.Atoi(value)
+				rpcServer.SetRateLimit(n)
+			},
+		},
+		{
+			Key:         "db.slowThreshold",
+			Description: "Database operations at least this slow are logged, e.g. 250ms",
+			Default:     slowThreshold.String(),
+			Validate: func(value string) error {
+				if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+					return errors.New("must be a positive duration")
+				}
+				return nil
+			},
+			Apply: func(value string) {
+				d, _ := time.ParseDuration(value)
+				db.SetSlowThreshold(d)
+			},
+		},
+		{
+			Key:         "log.level",
+			Description: "Log level spec: a default level and module overrides, e.g. info,chainsync=debug",
+			Default:     logLevel,
+			Validate: func(value string) error {
+				_, err := logging.ParseLevels(value)
+				return err
+			},
+			Apply: func(value string) {
+				levels, _ := logging.ParseLevels(value)
+				logging.SetLevels(levels)
+			},
+		},
+	}
+	for _, setting := range definitions {
+		if err := settings.Define(setting); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
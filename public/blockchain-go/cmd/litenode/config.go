@@ -0,0 +1,66 @@
+// ChainCore Lite Node - Config file sections and the config command
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"chaincore/internal/config"
+)
+
+// envPrefix prefixes the environment variables that set flags; it differs
+// from the full node's so both can run on one host
+const envPrefix = "CHAINCORE_LITE"
+
+const configUsage = `Usage: litenode config init [path]
+
+Writes a config file with every setting at its default to path, or to
+stdout without one. Start the node with --config path to use it.
+`
+
+// configSchema assigns the node's flags to config file sections
+var configSchema = config.Schema{
+	{Name: "rpc", Flags: []string{
+		"rpc", "rpc-timeout", "rpc-retries", "rpc-keepalive", "rpc-idle-timeout", "rpc-ca", "rpc-pin",
+		"api", "strict-checksum",
+	}},
+	{Name: "mining", Flags: []string{
+		"mining", "threads", "solo", "browser-mining", "browser-origins",
+		"cpu-target", "battery-cpu-target", "pause-on-battery", "min-battery", "max-temperature",
+	}},
+	{Name: "wallet", Flags: []string{
+		"wallet", "new-wallet", "password-file", "hardware", "hardware-account", "tx-stuck-after",
+	}},
+	{Name: "storage", Flags: []string{
+		"datadir", "storage",
+	}},
+	{Name: "logging", Flags: []string{
+		"headless", "log-level", "log-json", "log-file", "log-max-size", "log-max-backups", "otlp-endpoint",
+	}},
+}
+
+// runConfigCommand handles "litenode config ...". Flags must be defined
+// first, as the generated file is built from them.
+func runConfigCommand(args []string) int {
+	if len(args) == 0 || args[0] != "init" || len(args) > 2 {
+		fmt.Fprint(os.Stderr, configUsage)
+		return exitUsage
+	}
+
+	out := os.Stdout
+	if len(args) == 2 {
+		file, err := os.OpenFile(args[1], os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create config file: %v\n", err)
+			return exitFailure
+		}
+		defer file.Close()
+		out = file
+	}
+	if err := config.Generate(out, flag.CommandLine, configSchema, envPrefix); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write config file: %v\n", err)
+		return exitFailure
+	}
+	return exitOK
+}
@@ -0,0 +1,24 @@
+// ChainCore Lite Node - Hardware wallets
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"chaincore/internal/wallet"
+)
+
+// openHardware connects to a hardware wallet and has the user confirm the
+// address of account on the device before it is used
+func openHardware(kind string, account uint) (*wallet.Wallet, error) {
+	if account >= 1<<31 {
+		return nil, fmt.Errorf("account index %d is out of range", account)
+	}
+	fmt.Fprintf(os.Stderr, "Confirm the address of account %d on your %s...\n", account, kind)
+	w, err := wallet.OpenHardware(kind, uint32(account), true)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "Transactions will be shown on the device for approval before they are signed\n")
+	return w, nil
+}
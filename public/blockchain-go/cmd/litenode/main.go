@@ -3,6 +3,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -10,36 +11,108 @@ import (
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
+	"chaincore/internal/buildinfo"
+	"chaincore/internal/config"
 	"chaincore/internal/liteclient"
+	"chaincore/internal/logging"
 	"chaincore/internal/mining"
 	"chaincore/internal/storage"
+	"chaincore/internal/tracing/provider"
 	"chaincore/internal/wallet"
 )
 
 var (
-	version  = "1.0.0"
 	nodeType = "litenode"
 )
 
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 && os.Args[1] == "wallet" {
+		os.Exit(runWalletCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "pool" {
+		os.Exit(runPoolCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "tx" {
+		os.Exit(runTxCommand(os.Args[2:]))
+	}
+
 	// Command line flags
 	dataDir := flag.String("datadir", "~/.chaincore-lite", "Data directory for wallet and cache")
 	storageSize := flag.Int64("storage", 10, "Maximum storage size in GB (for caching)")
 	rpcEndpoints := flag.String("rpc", "", "Comma-separated list of full node RPC endpoints")
 	enableMining := flag.Bool("mining", false, "Enable browser/CPU mining for rewards")
 	miningThreads := flag.Int("threads", 2, "Number of mining threads (CPU mining)")
+	soloMining := flag.Bool("solo", false, "Mine solo on block templates from the full node instead of submitting shares")
+	browserMining := flag.Bool("browser-mining", false, "Let browser tabs mine over the local API's /api/mining/ws")
+	browserOrigins := flag.String("browser-origins", "", "Comma-separated origins besides the API's own whose pages may mine, * for any")
+	cpuTarget := flag.Int("cpu-target", 100, "Percent of a core each mining thread may use on external power")
+	batteryCPUTarget := flag.Int("battery-cpu-target", 50, "Percent of a core each mining thread may use on battery")
+	pauseOnBattery := flag.Bool("pause-on-battery", false, "Pause mining while the device is not charging")
+	minBattery := flag.Int("min-battery", 20, "Pause mining on battery below this charge percent")
+	maxTemperature := flag.Float64("max-temperature", 0, "Pause mining at this device temperature in Celsius (0 for no limit)")
 	walletPath := flag.String("wallet", "", "Path to wallet file")
 	createWallet := flag.Bool("new-wallet", false, "Create a new wallet")
+	passwordFile := flag.String("password-file", "", "Read the wallet password from this file instead of prompting")
+	hardware := flag.String("hardware", "", "Sign with a hardware wallet instead of a wallet file: ledger or trezor")
+	hardwareAccount := flag.Uint("hardware-account", 0, "Account index of the hardware wallet, at m/44'/60'/0'/0/<index>")
 	apiPort := flag.Int("api", 3000, "Local API port for web interface")
+	strictChecksum := flag.Bool("strict-checksum", false, "Require EIP-55 checksummed addresses in API requests")
+	txStuckAfter := flag.Duration("tx-stuck-after", liteclient.DefaultStuckAfter, "How long a sent transaction may wait to be included before it is reported stuck")
+	rpcTimeout := flag.Int("rpc-timeout", 30, "Per-request RPC timeout in seconds")
+	rpcRetries := flag.Int("rpc-retries", 3, "Retry rounds for failed RPC calls (with jittered backoff)")
+	rpcKeepAlive := flag.Duration("rpc-keepalive", 30*time.Second, "TCP keep-alive interval for RPC connections")
+	rpcIdleTimeout := flag.Duration("rpc-idle-timeout", 90*time.Second, "How long idle RPC connections are kept open")
+	rpcCA := flag.String("rpc-ca", "", "PEM CA bundle used to verify https RPC endpoints")
+	rpcPins := flag.String("rpc-pin", "", "Comma-separated endpoint pins, endpoint=cert:<sha256> or endpoint=key:<sha256>; unpinned endpoints are then not used")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "OTLP/HTTP collector URL for tracing, e.g. http://localhost:4318 (disabled if empty)")
+	logLevel := flag.String("log-level", "info", "Log level spec: a default level and module overrides, e.g. info,chainsync=debug,rpc=warn")
+	logJSON := flag.Bool("log-json", false, "Write logs as JSON lines")
+	logFile := flag.String("log-file", "", "Write logs to this file, rotated by size, instead of stderr")
+	logMaxSize := flag.Int("log-max-size", 100, "Rotate the log file once it grows past this many MB")
+	logMaxBackups := flag.Int("log-max-backups", 5, "Rotated log files to keep")
+	headless := flag.Bool("headless", false, "Run without the local API server; status is written to stdout as JSON lines")
+	showVersion := flag.Bool("version", false, "Print the build information and exit")
+	configPath := flag.String("config", "", "YAML config file; flags and "+envPrefix+"_* environment variables override it")
+
+	// The config subcommand documents the flags, so it runs once they exist
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfigCommand(os.Args[2:]))
+	}
 	flag.Parse()
+	if err := config.Load(flag.CommandLine, configSchema, *configPath, envPrefix); err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	build := buildinfo.Get()
+	if *showVersion {
+		fmt.Println(build.ClientVersion())
+		fmt.Printf("Commit: %s\nDate: %s\nFeatures: %s\n", build.Commit, build.Date, strings.Join(build.Features, ","))
+		return
+	}
 
-	fmt.Printf(`
+	if err := logging.Setup(logging.Config{
+		Level:      *logLevel,
+		JSON:       *logJSON,
+		File:       *logFile,
+		MaxSizeMB:  *logMaxSize,
+		MaxBackups: *logMaxBackups,
+	}); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+	defer logging.Close()
+
+	if !*headless {
+		fmt.Printf(`
 ╔═══════════════════════════════════════════════════════════════╗
 ║           ChainCore Lite Node v%s                         ║
 ║        Hybrid PoS + PoW Blockchain - Public Edition            ║
 ╚═══════════════════════════════════════════════════════════════╝
-`, version)
+`, build.Version)
+	}
+	log.Printf("Build %s, committed %s", build.ClientVersion(), build.Date)
 
 	// Validate RPC endpoints
 	if *rpcEndpoints == "" {
@@ -50,6 +123,18 @@ func main() {
 		endpoints[i] = strings.TrimSpace(ep)
 	}
 
+	// Initialize tracing
+	shutdownTracing, err := provider.Setup(context.Background(), provider.Config{
+		Endpoint:       *otlpEndpoint,
+		ServiceName:    nodeType,
+		ServiceVersion: build.VersionWithCommit(),
+		SampleRatio:    1.0,
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	// Initialize storage with size limit
 	storageConfig := storage.LiteConfig{
 		DataDir:      *dataDir,
@@ -67,33 +152,79 @@ func main() {
 	// Initialize or load wallet
 	var w *wallet.Wallet
 	if *createWallet {
-		w, err = wallet.CreateNew(*dataDir)
+		password, err := newPassword(*passwordFile)
+		if err != nil {
+			log.Fatalf("Failed to create wallet: %v", err)
+		}
+		w, err = wallet.CreateHD(*dataDir, password, wallet.DefaultMnemonicWords)
 		if err != nil {
 			log.Fatalf("Failed to create wallet: %v", err)
 		}
 		log.Printf("New wallet created: %s", w.Address())
+		printMnemonic(w)
+	} else if *hardware != "" {
+		w, err = openHardware(*hardware, *hardwareAccount)
+		if err != nil {
+			log.Fatalf("Failed to open hardware wallet: %v", err)
+		}
+		defer w.Close()
+		log.Printf("Hardware wallet connected: %s", w.Address())
 	} else if *walletPath != "" {
-		w, err = wallet.Load(*walletPath)
+		w, err = loadWallet(*walletPath, *passwordFile)
 		if err != nil {
 			log.Fatalf("Failed to load wallet: %v", err)
 		}
 		log.Printf("Wallet loaded: %s", w.Address())
+		if w.KeyType() == wallet.KeyTypeP256 {
+			log.Printf("Warning: legacy P-256 wallet; run \"litenode wallet migrate\" to move to a secp256k1 address")
+		}
 	}
 
 	// Initialize lite client (connects to full nodes)
 	clientConfig := liteclient.Config{
 		RPCEndpoints:    endpoints,
-		MaxRetries:      3,
-		TimeoutSeconds:  30,
+		MaxRetries:      *rpcRetries,
+		TimeoutSeconds:  *rpcTimeout,
 		EnableFailover:  true,
 		SyncHeaders:     true,
 		ValidateProofs:  true, // SPV validation
+		Transport: liteclient.TransportConfig{
+			KeepAlive:       *rpcKeepAlive,
+			IdleConnTimeout: *rpcIdleTimeout,
+		},
+	}
+	if *rpcCA != "" || *rpcPins != "" {
+		clientConfig.TLS = make(map[string]liteclient.EndpointTLS)
+		for _, ep := range endpoints {
+			if strings.HasPrefix(ep, "https://") {
+				clientConfig.TLS[ep] = liteclient.EndpointTLS{CAFile: *rpcCA}
+			}
+		}
+	}
+	if *rpcPins != "" {
+		if err := addEndpointPins(clientConfig.TLS, *rpcPins); err != nil {
+			log.Fatalf("Invalid --rpc-pin: %v", err)
+		}
 	}
 	client, err := liteclient.NewClient(clientConfig, cache)
 	if err != nil {
 		log.Fatalf("Failed to initialize lite client: %v", err)
 	}
 
+	// Verified headers persist across restarts
+	headerStore, err := liteclient.OpenHeaderStore(*dataDir, liteclient.DefaultCheckpointInterval)
+	if err != nil {
+		log.Fatalf("Failed to open header store: %v", err)
+	}
+	defer headerStore.Close()
+	client.SetHeaderStore(headerStore)
+	if w != nil {
+		w.SetTxParamsSource(client)
+	}
+	if head := headerStore.Head(); head != nil {
+		log.Printf("Resuming header sync from block %d", head.Height)
+	}
+
 	// Initialize mining client (optional)
 	var miner *mining.LiteMiner
 	if *enableMining && w != nil {
@@ -101,8 +232,20 @@ func main() {
 			Threads:            *miningThreads,
 			MinerAddress:       w.Address(),
 			EnableCPU:          true,
-			EnableBrowser:      false, // CLI mode
+			EnableBrowser:      *browserMining,
 			ShareSubmitTimeout: 5,
+			SignShare:          w.Sign,
+			Solo:               *soloMining,
+			CPUTarget:          float64(*cpuTarget) / 100,
+			BatteryCPUTarget:   float64(*batteryCPUTarget) / 100,
+			PauseOnBattery:     *pauseOnBattery,
+			MinBatteryLevel:    *minBattery,
+			MaxTemperature:     *maxTemperature,
+		}
+		for _, origin := range strings.Split(*browserOrigins, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				minerConfig.BrowserOrigins = append(minerConfig.BrowserOrigins, origin)
+			}
 		}
 		miner, err = mining.NewLiteMiner(client, minerConfig)
 		if err != nil {
@@ -129,23 +272,39 @@ func main() {
 		if err := miner.Start(); err != nil {
 			log.Fatalf("Failed to start miner: %v", err)
 		}
-		log.Printf("Mining started with %d threads", *miningThreads)
+		log.Printf("Mining started with %d threads (solo: %v)", *miningThreads, *soloMining)
 	}
 
 	// Start local API server
-	apiServer := liteclient.NewAPIServer(client, w, miner, *apiPort)
-	if err := apiServer.Start(); err != nil {
-		log.Fatalf("Failed to start API server: %v", err)
-	}
-	log.Printf("Local API server running on http://localhost:%d", *apiPort)
+	var apiServer *liteclient.APIServer
+	if !*headless {
+		apiServer = liteclient.NewAPIServer(client, w, miner, *apiPort)
+		apiServer.SetStrictChecksum(*strictChecksum)
+		apiServer.SetStuckAfter(*txStuckAfter)
+		if err := apiServer.Start(); err != nil {
+			log.Fatalf("Failed to start API server: %v", err)
+		}
+		log.Printf("Local API server running on http://localhost:%d", *apiPort)
 
-	log.Printf(`
+		log.Printf(`
 ╔═══════════════════════════════════════════════════════════════╗
 ║  Lite Node Started Successfully!                               ║
 ║  RPC Endpoints: %d | Storage: %dGB                           ║
 ║  Mining: %v | API Port: %d                                   ║
 ╚═══════════════════════════════════════════════════════════════╝
 `, len(endpoints), *storageSize, *enableMining, *apiPort)
+	} else {
+		status := map[string]interface{}{
+			"event":       "started",
+			"endpoints":   len(endpoints),
+			"latestBlock": client.GetLatestHeight(),
+			"mining":      miner != nil,
+		}
+		if w != nil {
+			status["address"] = w.Address()
+		}
+		writeJSON(status)
+	}
 
 	// Wait for shutdown signal
 	sigChan := make(chan os.Signal, 1)
@@ -156,7 +315,41 @@ func main() {
 	if miner != nil {
 		miner.Stop()
 	}
-	apiServer.Stop()
+	if apiServer != nil {
+		apiServer.Stop()
+	}
 	client.Stop()
+	if *headless {
+		writeJSON(map[string]interface{}{"event": "stopped"})
+	}
 	log.Println("Goodbye!")
 }
+
+// addEndpointPins parses --rpc-pin entries of the form endpoint=cert:<hex>
+// or endpoint=key:<hex> into the endpoints' TLS settings
+func addEndpointPins(settings map[string]liteclient.EndpointTLS, spec string) error {
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		sep := strings.LastIndex(entry, "=")
+		if sep < 0 {
+			return fmt.Errorf("%q: want endpoint=cert:<sha256> or endpoint=key:<sha256>", entry)
+		}
+		endpoint, pin := entry[:sep], entry[sep+1:]
+		endpointTLS, ok := settings[endpoint]
+		if !ok {
+			return fmt.Errorf("%q is not an https endpoint given with --rpc", endpoint)
+		}
+
+		kind, fingerprint, _ := strings.Cut(pin, ":")
+		switch kind {
+		case "cert":
+			endpointTLS.CertPins = append(endpointTLS.CertPins, fingerprint)
+		case "key":
+			endpointTLS.KeyPins = append(endpointTLS.KeyPins, fingerprint)
+		default:
+			return fmt.Errorf("%q: pin type must be cert or key", entry)
+		}
+		settings[endpoint] = endpointTLS
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+// ChainCore Lite Node - Wallet passwords
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"chaincore/internal/wallet"
+)
+
+// stdin is shared by every prompt so lines piped in are not lost to an
+// earlier reader's buffer
+var stdin = bufio.NewReader(os.Stdin)
+
+// loadWallet loads a wallet file, asking for its password only if it is
+// encrypted. The password is read from passwordFile if one is given.
+func loadWallet(path, passwordFile string) (*wallet.Wallet, error) {
+	w, err := wallet.Load(path, "")
+	if !errors.Is(err, wallet.ErrPasswordRequired) {
+		return w, err
+	}
+	password, err := readPassword("Wallet password: ", passwordFile)
+	if err != nil {
+		return nil, err
+	}
+	return wallet.Load(path, password)
+}
+
+// newPassword gets the password for a new wallet file from passwordFile, or
+// asks for it twice on the terminal
+func newPassword(passwordFile string) (string, error) {
+	return confirmPassword("New wallet password: ", passwordFile)
+}
+
+// confirmPassword gets a new password from passwordFile, or prompts for it
+// twice on the terminal
+func confirmPassword(prompt, passwordFile string) (string, error) {
+	password, err := readPassword(prompt, passwordFile)
+	if err != nil {
+		return "", err
+	}
+	if password == "" {
+		return "", errors.New("password must not be empty")
+	}
+	if passwordFile != "" {
+		return password, nil
+	}
+
+	confirm, err := readPassword("Repeat password: ", "")
+	if err != nil {
+		return "", err
+	}
+	if confirm != password {
+		return "", errors.New("passwords do not match")
+	}
+	return password, nil
+}
+
+// readPassword returns the first line of passwordFile, or prompts for a
+// password on stderr and reads it from stdin with echo turned off
+func readPassword(prompt, passwordFile string) (string, error) {
+	if passwordFile != "" {
+		data, err := os.ReadFile(passwordFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password file: %w", err)
+		}
+		return strings.TrimRight(strings.SplitN(string(data), "\n", 2)[0], "\r"), nil
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	if setEcho(false) {
+		defer func() {
+			setEcho(true)
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Helper functions
+
+// setEcho turns terminal echo on or off with stty, and reports whether it
+// could. It cannot when stdin is not a terminal, e.g. a password piped in
+// by a script.
+func setEcho(on bool) bool {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	arg := "-echo"
+	if on {
+		arg = "echo"
+	}
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run() == nil
+}
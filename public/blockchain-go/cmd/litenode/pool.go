@@ -0,0 +1,99 @@
+// ChainCore Lite Node - Mining pool subcommands
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const poolUsage = `Usage: litenode pool <command> [flags]
+
+Commands:
+  payouts       Export the pool payout ledger as CSV or JSON
+`
+
+// runPoolCommand dispatches "litenode pool ..." subcommands
+func runPoolCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, poolUsage)
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "payouts":
+		err = poolPayouts(args[1:])
+	default:
+		fmt.Fprint(os.Stderr, poolUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// poolPayouts downloads the payout ledger from a pool server
+func poolPayouts(args []string) error {
+	fs := flag.NewFlagSet("pool payouts", flag.ExitOnError)
+	poolURL := fs.String("pool", "", "Pool server URL, e.g. https://pool.example.com")
+	miner := fs.String("miner", "", "Only export payouts to this address")
+	from := fs.String("from", "", "Start time (unix seconds or RFC 3339)")
+	to := fs.String("to", "", "End time, exclusive (unix seconds or RFC 3339)")
+	format := fs.String("format", "csv", "Output format: csv or json")
+	out := fs.String("out", "", "Write to this file instead of stdout")
+	fs.Parse(args)
+
+	if *poolURL == "" {
+		return fmt.Errorf("--pool is required")
+	}
+	if *format != "csv" && *format != "json" {
+		return fmt.Errorf("--format must be csv or json")
+	}
+
+	query := url.Values{}
+	query.Set("format", *format)
+	if *miner != "" {
+		query.Set("miner", *miner)
+	}
+	if *from != "" {
+		query.Set("from", *from)
+	}
+	if *to != "" {
+		query.Set("to", *to)
+	}
+
+	endpoint := strings.TrimRight(*poolURL, "/") + "/pool/payouts?" + query.Encode()
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("pool returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var dst io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		dst = f
+	}
+
+	_, err = io.Copy(dst, resp.Body)
+	return err
+}
@@ -0,0 +1,280 @@
+// ChainCore Lite Node - One-shot transaction commands for scripts
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strconv"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/liteclient"
+	"chaincore/internal/wallet"
+)
+
+// Exit codes for one-shot commands
+const (
+	exitOK       = 0
+	exitFailure  = 1 // Local failure, e.g. wallet could not be loaded
+	exitUsage    = 2 // Bad flags or arguments
+	exitNetwork  = 3 // No full node could be reached
+	exitRejected = 4 // The node rejected the request
+	exitNotFound = 5 // Transaction unknown to the node
+)
+
+const txUsage = `Usage: litenode tx <command> [flags]
+
+Commands:
+  send          Sign and broadcast a transfer from a wallet
+  balance       Print the balance and nonce of an address
+  status        Print the status of a transaction
+
+Output is a single JSON object on stdout. Exit codes:
+  0 success, 1 local failure, 2 usage error, 3 network error,
+  4 rejected by the node, 5 transaction not found
+`
+
+// cliError carries the exit code a one-shot command should terminate with
+type cliError struct {
+	code int
+	err  error
+}
+
+func (e *cliError) Error() string {
+	return e.err.Error()
+}
+
+// runTxCommand dispatches "litenode tx ..." subcommands
+func runTxCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, txUsage)
+		return exitUsage
+	}
+
+	var result interface{}
+	var err error
+	switch args[0] {
+	case "send":
+		result, err = txSend(args[1:])
+	case "balance":
+		result, err = txBalance(args[1:])
+	case "status":
+		result, err = txStatus(args[1:])
+	default:
+		fmt.Fprint(os.Stderr, txUsage)
+		return exitUsage
+	}
+
+	if err != nil {
+		code := exitFailure
+		var cliErr *cliError
+		if errors.As(err, &cliErr) {
+			code = cliErr.code
+		}
+		writeJSON(map[string]interface{}{
+			"ok":       false,
+			"error":    err.Error(),
+			"exitCode": code,
+		})
+		return code
+	}
+
+	writeJSON(result)
+	return exitOK
+}
+
+// txSend signs a transfer with the wallet's next nonce and broadcasts it
+func txSend(args []string) (interface{}, error) {
+	fs := newTxFlagSet("tx send")
+	rpcEndpoints := fs.String("rpc", "", "Comma-separated list of full node RPC endpoints")
+	walletPath := fs.String("wallet", "", "Path to wallet file")
+	hardware := fs.String("hardware", "", "Sign with a hardware wallet instead of a wallet file: ledger or trezor")
+	hardwareAccount := fs.Uint("hardware-account", 0, "Account index of the hardware wallet")
+	to := fs.String("to", "", "Recipient address")
+	amount := fs.String("amount", "", "Amount in wei")
+	memo := fs.String("memo", "", "UTF-8 memo carried as transaction data")
+	nonce := fs.Int64("nonce", -1, "Sender nonce (the pending nonce from the network if omitted)")
+	chainID := fs.Uint64("chainid", 13370, "Chain ID the transaction is intended for")
+	passwordFile := fs.String("password-file", "", "Read the wallet password from this file instead of prompting")
+	if err := fs.Parse(args); err != nil {
+		return nil, usageError(err)
+	}
+	if (*walletPath == "") == (*hardware == "") || *to == "" || *amount == "" {
+		return nil, usageError(errors.New("--to, --amount and one of --wallet or --hardware are required"))
+	}
+
+	var w *wallet.Wallet
+	var err error
+	if *hardware != "" {
+		w, err = openHardware(*hardware, *hardwareAccount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open hardware wallet: %w", err)
+		}
+		defer w.Close()
+	} else if w, err = loadWallet(*walletPath, *passwordFile); err != nil {
+		return nil, fmt.Errorf("failed to load wallet: %w", err)
+	}
+	client, err := newOneShotClient(*rpcEndpoints)
+	if err != nil {
+		return nil, usageError(err)
+	}
+
+	txNonce := uint64(*nonce)
+	if *nonce < 0 {
+		txNonce, err = client.GetPendingNonce(w.Address())
+		if err != nil {
+			return nil, rpcFailure(err)
+		}
+	}
+
+	payload, err := wallet.NewUnsignedPayload(*chainID, w.Address(), *to, *amount, txNonce)
+	if err != nil {
+		return nil, usageError(err)
+	}
+	if *memo != "" {
+		if err := payload.SetMemo(*memo); err != nil {
+			return nil, usageError(err)
+		}
+	}
+
+	// Price at the node's suggestion, which is never below the pool's
+	// advisory minimum, so a busy pool does not reject the transaction
+	suggested, err := client.SuggestGasPrice()
+	if err != nil {
+		return nil, rpcFailure(err)
+	}
+	if current, ok := new(big.Int).SetString(payload.GasPrice, 10); ok && current.Cmp(new(big.Int).SetUint64(suggested)) < 0 {
+		payload.GasPrice = strconv.FormatUint(suggested, 10)
+	}
+	signed, err := w.SignOffline(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	txHash, err := client.SendTransaction(signed.Transaction)
+	if err != nil {
+		return nil, rpcFailure(err)
+	}
+
+	result := map[string]interface{}{
+		"ok":     true,
+		"txHash": txHash,
+		"from":   payload.From,
+		"to":     payload.To,
+		"value":  payload.Value,
+		"nonce":  payload.Nonce,
+	}
+	if *memo != "" {
+		result["memo"] = *memo
+	}
+	return result, nil
+}
+
+// txBalance reports the balance and nonce of an address
+func txBalance(args []string) (interface{}, error) {
+	fs := newTxFlagSet("tx balance")
+	rpcEndpoints := fs.String("rpc", "", "Comma-separated list of full node RPC endpoints")
+	address := fs.String("address", "", "Account address")
+	walletPath := fs.String("wallet", "", "Use the address of this wallet file")
+	passwordFile := fs.String("password-file", "", "Read the wallet password from this file instead of prompting")
+	if err := fs.Parse(args); err != nil {
+		return nil, usageError(err)
+	}
+
+	if *address == "" && *walletPath != "" {
+		w, err := loadWallet(*walletPath, *passwordFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load wallet: %w", err)
+		}
+		*address = w.Address()
+	}
+	if *address == "" {
+		return nil, usageError(errors.New("--address or --wallet is required"))
+	}
+	addr, err := blockchain.ParseAddress(*address, false)
+	if err != nil {
+		return nil, usageError(err)
+	}
+
+	client, err := newOneShotClient(*rpcEndpoints)
+	if err != nil {
+		return nil, usageError(err)
+	}
+
+	checksummed := blockchain.ChecksumAddress(addr)
+	balance, err := client.GetBalance(checksummed)
+	if err != nil {
+		return nil, rpcFailure(err)
+	}
+	nonce, err := client.GetNonce(checksummed)
+	if err != nil {
+		return nil, rpcFailure(err)
+	}
+
+	return map[string]interface{}{
+		"ok":      true,
+		"address": checksummed,
+		"balance": balance,
+		"nonce":   nonce,
+	}, nil
+}
+
+// txStatus reports whether a transaction is known to the node
+func txStatus(args []string) (interface{}, error) {
+	fs := newTxFlagSet("tx status")
+	rpcEndpoints := fs.String("rpc", "", "Comma-separated list of full node RPC endpoints")
+	txHash := fs.String("hash", "", "Transaction hash")
+	if err := fs.Parse(args); err != nil {
+		return nil, usageError(err)
+	}
+	if *txHash == "" {
+		return nil, usageError(errors.New("--hash is required"))
+	}
+
+	client, err := newOneShotClient(*rpcEndpoints)
+	if err != nil {
+		return nil, usageError(err)
+	}
+
+	tx, err := client.GetTransaction(*txHash)
+	if err != nil {
+		return nil, rpcFailure(err)
+	}
+	if len(tx) == 0 || string(tx) == "null" {
+		return nil, &cliError{code: exitNotFound, err: fmt.Errorf("transaction %s not found", *txHash)}
+	}
+
+	return map[string]interface{}{
+		"ok":          true,
+		"txHash":      *txHash,
+		"transaction": tx,
+	}, nil
+}
+
+// Helper functions
+func newTxFlagSet(name string) *flag.FlagSet {
+	// Parse errors are reported as JSON by runTxCommand, not printed
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+func usageError(err error) error {
+	return &cliError{code: exitUsage, err: err}
+}
+
+func rpcFailure(err error) error {
+	var rpcErr *liteclient.RPCError
+	if errors.As(err, &rpcErr) {
+		return &cliError{code: exitRejected, err: err}
+	}
+	return &cliError{code: exitNetwork, err: err}
+}
+
+func writeJSON(v interface{}) {
+	json.NewEncoder(os.Stdout).Encode(v)
+}
@@ -0,0 +1,493 @@
+// ChainCore Lite Node - Cold wallet subcommands
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"chaincore/internal/liteclient"
+	"chaincore/internal/wallet"
+)
+
+const walletUsage = `Usage: litenode wallet <command> [flags]
+
+Commands:
+  prepare       Build an unsigned transaction on an online machine
+  sign-offline  Sign an unsigned transaction on an air-gapped machine
+  broadcast     Broadcast a signed transaction from an online machine
+  migrate       Replace a legacy P-256 wallet with a secp256k1 wallet
+  encrypt       Encrypt an unencrypted wallet file with a password
+  restore       Restore an HD wallet from its mnemonic
+  mnemonic      Show the mnemonic of an HD wallet for backup
+  backup        Bundle keystores, address book, history and settings into an encrypted file
+  restore-backup
+                Restore the files of a backup bundle into a data directory
+`
+
+// runWalletCommand dispatches "litenode wallet ..." subcommands
+func runWalletCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, walletUsage)
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "prepare":
+		err = walletPrepare(args[1:])
+	case "sign-offline":
+		err = walletSignOffline(args[1:])
+	case "broadcast":
+		err = walletBroadcast(args[1:])
+	case "migrate":
+		err = walletMigrate(args[1:])
+	case "encrypt":
+		err = walletEncrypt(args[1:])
+	case "restore":
+		err = walletRestore(args[1:])
+	case "mnemonic":
+		err = walletMnemonic(args[1:])
+	case "backup":
+		err = walletBackup(args[1:])
+	case "restore-backup":
+		err = walletRestoreBackup(args[1:])
+	default:
+		fmt.Fprint(os.Stderr, walletUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// walletPrepare builds an unsigned payload, fetching the nonce if needed
+func walletPrepare(args []string) error {
+	fs := flag.NewFlagSet("wallet prepare", flag.ExitOnError)
+	rpcEndpoints := fs.String("rpc", "", "Comma-separated list of full node RPC endpoints")
+	from := fs.String("from", "", "Sender address (the cold wallet)")
+	to := fs.String("to", "", "Recipient address")
+	amount := fs.String("amount", "", "Amount in wei")
+	memo := fs.String("memo", "", "UTF-8 memo carried as transaction data (e.g. an exchange deposit tag)")
+	nonce := fs.Int64("nonce", -1, "Sender nonce (fetched from the network if omitted)")
+	chainID := fs.Uint64("chainid", 13370, "Chain ID the transaction is intended for")
+	out := fs.String("out", "", "Write the payload to this file")
+	qr := fs.String("qr", "", "Write the payload as a PNG QR code to this file")
+	fs.Parse(args)
+
+	txNonce := uint64(*nonce)
+	if *nonce < 0 {
+		client, err := newOneShotClient(*rpcEndpoints)
+		if err != nil {
+			return err
+		}
+		txNonce, err = client.GetNonce(*from)
+		if err != nil {
+			return fmt.Errorf("failed to fetch nonce: %w", err)
+		}
+	}
+
+	payload, err := wallet.NewUnsignedPayload(*chainID, *from, *to, *amount, txNonce)
+	if err != nil {
+		return err
+	}
+	if *memo != "" {
+		if err := payload.SetMemo(*memo); err != nil {
+			return err
+		}
+	}
+
+	encoded, err := wallet.EncodePayload(wallet.UnsignedPayloadPrefix, payload)
+	if err != nil {
+		return err
+	}
+	return emitPayload(encoded, *out, *qr)
+}
+
+// walletSignOffline signs an unsigned payload without any network access
+func walletSignOffline(args []string) error {
+	fs := flag.NewFlagSet("wallet sign-offline", flag.ExitOnError)
+	walletPath := fs.String("wallet", "", "Path to wallet file")
+	in := fs.String("in", "", "Read the unsigned payload from this file")
+	payloadStr := fs.String("payload", "", "Unsigned payload string (e.g. scanned from a QR code)")
+	out := fs.String("out", "", "Write the signed payload to this file")
+	qr := fs.String("qr", "", "Write the signed payload as a PNG QR code to this file")
+	passwordFile := fs.String("password-file", "", "Read the wallet password from this file instead of prompting")
+	fs.Parse(args)
+
+	if *walletPath == "" {
+		return fmt.Errorf("--wallet is required")
+	}
+
+	encoded, err := readPayload(*in, *payloadStr)
+	if err != nil {
+		return err
+	}
+	payload, err := wallet.DecodeUnsignedPayload(encoded)
+	if err != nil {
+		return err
+	}
+
+	w, err := loadWallet(*walletPath, *passwordFile)
+	if err != nil {
+		return fmt.Errorf("failed to load wallet: %w", err)
+	}
+
+	// Show what is being signed so the operator can check it on the cold machine
+	fmt.Fprintf(os.Stderr, "Signing on chain %d: %s -> %s, value %s wei, nonce %d\n",
+		payload.ChainID, payload.From, payload.To, payload.Value, payload.Nonce)
+	if payload.Data != "" {
+		fmt.Fprintf(os.Stderr, "Data: %s\n", payload.Data)
+	}
+
+	signed, err := w.SignOffline(payload)
+	if err != nil {
+		return err
+	}
+
+	encoded, err = wallet.EncodePayload(wallet.SignedPayloadPrefix, signed)
+	if err != nil {
+		return err
+	}
+	return emitPayload(encoded, *out, *qr)
+}
+
+// walletBroadcast submits a signed payload to the network
+func walletBroadcast(args []string) error {
+	fs := flag.NewFlagSet("wallet broadcast", flag.ExitOnError)
+	rpcEndpoints := fs.String("rpc", "", "Comma-separated list of full node RPC endpoints")
+	in := fs.String("in", "", "Read the signed payload from this file")
+	payloadStr := fs.String("payload", "", "Signed payload string (e.g. scanned from a QR code)")
+	fs.Parse(args)
+
+	encoded, err := readPayload(*in, *payloadStr)
+	if err != nil {
+		return err
+	}
+	signed, err := wallet.DecodeSignedPayload(encoded)
+	if err != nil {
+		return err
+	}
+
+	client, err := newOneShotClient(*rpcEndpoints)
+	if err != nil {
+		return err
+	}
+
+	txHash, err := client.SendTransaction(signed.Transaction)
+	if err != nil {
+		return fmt.Errorf("broadcast failed: %w", err)
+	}
+
+	fmt.Println(txHash)
+	return nil
+}
+
+// walletMigrate creates a secp256k1 wallet for a legacy P-256 wallet and
+// optionally sweeps the legacy balance to the new address
+func walletMigrate(args []string) error {
+	fs := flag.NewFlagSet("wallet migrate", flag.ExitOnError)
+	walletPath := fs.String("wallet", "", "Path to the legacy P-256 wallet file")
+	out := fs.String("out", "", "Path for the new secp256k1 wallet file")
+	sweep := fs.Bool("sweep", false, "Transfer the legacy balance to the new address")
+	rpcEndpoints := fs.String("rpc", "", "Comma-separated list of full node RPC endpoints (with --sweep)")
+	chainID := fs.Uint64("chainid", 13370, "Chain ID the sweep transaction is intended for")
+	passwordFile := fs.String("password-file", "", "Read the new wallet's password from this file instead of prompting")
+	fs.Parse(args)
+
+	if *walletPath == "" || *out == "" {
+		return fmt.Errorf("--wallet and --out are required")
+	}
+
+	password, err := newPassword(*passwordFile)
+	if err != nil {
+		return err
+	}
+	legacy, migrated, err := wallet.Migrate(*walletPath, *out, password)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Legacy address: %s\n", legacy.Address())
+	fmt.Printf("New address:    %s\n", migrated.Address())
+	fmt.Printf("New wallet saved to %s; keep %s until its balance has been moved\n", *out, *walletPath)
+
+	if !*sweep {
+		return nil
+	}
+
+	client, err := newOneShotClient(*rpcEndpoints)
+	if err != nil {
+		return err
+	}
+	balanceStr, err := client.GetBalance(legacy.Address())
+	if err != nil {
+		return fmt.Errorf("failed to fetch balance: %w", err)
+	}
+	nonce, err := client.GetNonce(legacy.Address())
+	if err != nil {
+		return fmt.Errorf("failed to fetch nonce: %w", err)
+	}
+	gasPrice, err := client.GetMinGasPrice()
+	if err != nil {
+		return fmt.Errorf("failed to fetch gas price: %w", err)
+	}
+
+	// Send everything except the fee, which is charged at the gas limit
+	balance, ok := new(big.Int).SetString(balanceStr, 0)
+	if !ok {
+		return fmt.Errorf("invalid balance %q", balanceStr)
+	}
+	fee := new(big.Int).Mul(big.NewInt(21000), new(big.Int).SetUint64(gasPrice))
+	amount := new(big.Int).Sub(balance, fee)
+	if amount.Sign() <= 0 {
+		return fmt.Errorf("balance %s wei does not cover the sweep fee of %s wei", balance, fee)
+	}
+
+	payload, err := wallet.NewUnsignedPayload(*chainID, legacy.Address(), migrated.Address(), amount.String(), nonce)
+	if err != nil {
+		return err
+	}
+	payload.GasPrice = strconv.FormatUint(gasPrice, 10)
+	signed, err := legacy.SignOffline(payload)
+	if err != nil {
+		return err
+	}
+
+	txHash, err := client.SendTransaction(signed.Transaction)
+	if err != nil {
+		return fmt.Errorf("sweep failed: %w", err)
+	}
+	fmt.Printf("Swept %s wei to %s: %s\n", amount, migrated.Address(), txHash)
+	return nil
+}
+
+// walletEncrypt replaces an unencrypted secp256k1 key file with a
+// password-encrypted keystore file
+func walletEncrypt(args []string) error {
+	fs := flag.NewFlagSet("wallet encrypt", flag.ExitOnError)
+	walletPath := fs.String("wallet", "", "Path to the unencrypted wallet file")
+	passwordFile := fs.String("password-file", "", "Read the new password from this file instead of prompting")
+	fs.Parse(args)
+
+	if *walletPath == "" {
+		return fmt.Errorf("--wallet is required")
+	}
+
+	password, err := newPassword(*passwordFile)
+	if err != nil {
+		return err
+	}
+	w, err := wallet.Encrypt(*walletPath, password)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wallet %s encrypted: %s\n", w.Address(), *walletPath)
+	return nil
+}
+
+// walletRestore recreates an HD wallet from its mnemonic
+func walletRestore(args []string) error {
+	fs := flag.NewFlagSet("wallet restore", flag.ExitOnError)
+	dataDir := fs.String("datadir", "", "Directory to write wallet.key to")
+	accounts := fs.Uint("accounts", 1, "Number of accounts to derive again, from index 0")
+	passwordFile := fs.String("password-file", "", "Read the new wallet's password from this file instead of prompting")
+	fs.Parse(args)
+
+	if *dataDir == "" {
+		return fmt.Errorf("--datadir is required")
+	}
+
+	mnemonic, err := readPassword("Mnemonic: ", "")
+	if err != nil {
+		return err
+	}
+	mnemonic, err = wallet.NormalizeMnemonic(mnemonic)
+	if err != nil {
+		return err
+	}
+	password, err := newPassword(*passwordFile)
+	if err != nil {
+		return err
+	}
+	w, err := wallet.RestoreHD(*dataDir, mnemonic, password)
+	if err != nil {
+		return err
+	}
+	for index := uint32(1); index < uint32(*accounts); index++ {
+		if _, err := w.AddAccount(index); err != nil {
+			return err
+		}
+	}
+
+	restored, err := w.Accounts()
+	if err != nil {
+		return err
+	}
+	for _, account := range restored {
+		fmt.Printf("%s  %s\n", account.Path, account.Address)
+	}
+	return nil
+}
+
+// walletMnemonic prints the mnemonic of an HD wallet
+func walletMnemonic(args []string) error {
+	fs := flag.NewFlagSet("wallet mnemonic", flag.ExitOnError)
+	walletPath := fs.String("wallet", "", "Path to wallet file")
+	passwordFile := fs.String("password-file", "", "Read the wallet password from this file instead of prompting")
+	fs.Parse(args)
+
+	if *walletPath == "" {
+		return fmt.Errorf("--wallet is required")
+	}
+
+	w, err := loadWallet(*walletPath, *passwordFile)
+	if err != nil {
+		return fmt.Errorf("failed to load wallet: %w", err)
+	}
+	mnemonic, err := w.Mnemonic()
+	if err != nil {
+		return err
+	}
+	fmt.Println(mnemonic)
+	return nil
+}
+
+// walletBackup writes an encrypted backup bundle of a data directory
+func walletBackup(args []string) error {
+	fs := flag.NewFlagSet("wallet backup", flag.ExitOnError)
+	dataDir := fs.String("datadir", "", "Data directory to back up")
+	out := fs.String("out", "", "Write the bundle to this file")
+	keystores := fs.String("keystore", "", "Comma-separated keystore files kept outside the data directory to include")
+	passwordFile := fs.String("password-file", "", "Read the backup password from this file instead of prompting")
+	fs.Parse(args)
+
+	if *dataDir == "" || *out == "" {
+		return fmt.Errorf("--datadir and --out are required")
+	}
+
+	var extra []string
+	if *keystores != "" {
+		for _, file := range strings.Split(*keystores, ",") {
+			extra = append(extra, strings.TrimSpace(file))
+		}
+	}
+	backup, err := wallet.CollectBackup(*dataDir, extra)
+	if err != nil {
+		return err
+	}
+	password, err := confirmPassword("New backup password: ", *passwordFile)
+	if err != nil {
+		return err
+	}
+	if err := backup.Write(*out, password); err != nil {
+		return err
+	}
+
+	for _, entry := range backup.Manifest.Entries {
+		fmt.Printf("%-12s %s  %s\n", entry.Kind, entry.Path, entry.Address)
+	}
+	fmt.Printf("Backup of %d files written to %s\n", len(backup.Manifest.Entries), *out)
+	return nil
+}
+
+// walletRestoreBackup restores the files of a backup bundle
+func walletRestoreBackup(args []string) error {
+	fs := flag.NewFlagSet("wallet restore-backup", flag.ExitOnError)
+	in := fs.String("in", "", "Read the bundle from this file")
+	dataDir := fs.String("datadir", "", "Data directory to restore into")
+	conflict := fs.String("conflict", string(wallet.ConflictKeep), "What to do with existing files that differ: keep, replace (moving the existing file aside) or rename (restoring under a new name)")
+	list := fs.Bool("list", false, "Only list the bundle's files")
+	passwordFile := fs.String("password-file", "", "Read the backup password from this file instead of prompting")
+	fs.Parse(args)
+
+	if *in == "" || (*dataDir == "" && !*list) {
+		return fmt.Errorf("--in and one of --datadir or --list are required")
+	}
+
+	password, err := readPassword("Backup password: ", *passwordFile)
+	if err != nil {
+		return err
+	}
+	backup, err := wallet.OpenBackup(*in, password)
+	if err != nil {
+		return err
+	}
+	if *list {
+		fmt.Printf("Backup created %s\n", backup.Manifest.CreatedAt.Format(time.RFC3339))
+		for _, entry := range backup.Manifest.Entries {
+			fmt.Printf("%-12s %s  %d bytes  %s\n", entry.Kind, entry.Path, entry.Size, entry.Address)
+		}
+		return nil
+	}
+
+	results, err := backup.Restore(*dataDir, wallet.ConflictPolicy(*conflict))
+	for _, r := range results {
+		switch r.Action {
+		case wallet.RestoreReplaced:
+			fmt.Printf("%-10s %s (previous file moved to %s)\n", r.Action, r.Path, r.Previous)
+		default:
+			fmt.Printf("%-10s %s\n", r.Action, r.Path)
+		}
+	}
+	return err
+}
+
+// Helper functions
+func newOneShotClient(rpcEndpoints string) (*liteclient.Client, error) {
+	if rpcEndpoints == "" {
+		return nil, fmt.Errorf("--rpc is required")
+	}
+	endpoints := strings.Split(rpcEndpoints, ",")
+	for i, ep := range endpoints {
+		endpoints[i] = strings.TrimSpace(ep)
+	}
+
+	return liteclient.NewClient(liteclient.Config{
+		RPCEndpoints:   endpoints,
+		MaxRetries:     3,
+		TimeoutSeconds: 30,
+		EnableFailover: true,
+	}, nil)
+}
+
+// printMnemonic shows the mnemonic of a new HD wallet once, on stderr so it
+// stays out of logs collected from stdout
+func printMnemonic(w *wallet.Wallet) {
+	mnemonic, err := w.Mnemonic()
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\nWrite down this mnemonic and keep it offline. It restores the wallet\nand all its accounts; anyone who has it controls the funds.\n\n  %s\n\n", mnemonic)
+}
+
+func readPayload(path, inline string) (string, error) {
+	if inline != "" {
+		return inline, nil
+	}
+	if path == "" {
+		return "", fmt.Errorf("either --in or --payload is required")
+	}
+	return wallet.ReadPayloadFile(path)
+}
+
+func emitPayload(encoded, out, qr string) error {
+	if out != "" {
+		if err := wallet.WritePayloadFile(out, encoded); err != nil {
+			return err
+		}
+	}
+	if qr != "" {
+		if err := wallet.WritePayloadQR(qr, encoded); err != nil {
+			return err
+		}
+	}
+	fmt.Println(encoded)
+	return nil
+}
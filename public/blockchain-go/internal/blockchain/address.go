@@ -0,0 +1,76 @@
+// Package blockchain - Address encoding and EIP-55 checksum validation
+package blockchain
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// Address parsing errors
+var (
+	ErrInvalidAddress  = errors.New("invalid address: expected 0x-prefixed 20-byte hex string")
+	ErrBadChecksum     = errors.New("invalid address checksum (EIP-55): address may be corrupted")
+	ErrMissingChecksum = errors.New("address must be EIP-55 checksummed (mixed case)")
+)
+
+// ChecksumAddress returns the EIP-55 mixed-case hex encoding of an address
+func ChecksumAddress(addr [20]byte) string {
+	lower := hex.EncodeToString(addr[:])
+
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write([]byte(lower))
+	hash := hasher.Sum(nil)
+
+	result := []byte(lower)
+	for i, c := range result {
+		if c < 'a' || c > 'f' {
+			continue
+		}
+		// Uppercase the letter when the matching hash nibble is >= 8
+		nibble := hash[i/2]
+		if i%2 == 0 {
+			nibble >>= 4
+		}
+		if nibble&0x0f >= 8 {
+			result[i] = c - 'a' + 'A'
+		}
+	}
+
+	return "0x" + string(result)
+}
+
+// ParseAddress parses a hex address. Mixed-case input must carry a valid
+// EIP-55 checksum; in strict mode all-lowercase or all-uppercase input is
+// rejected as well.
+func ParseAddress(s string, strict bool) ([20]byte, error) {
+	var addr [20]byte
+
+	body := strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	if len(body) != 40 {
+		return addr, ErrInvalidAddress
+	}
+
+	decoded, err := hex.DecodeString(body)
+	if err != nil {
+		return addr, ErrInvalidAddress
+	}
+	copy(addr[:], decoded)
+
+	lower := strings.ToLower(body)
+	upper := strings.ToUpper(body)
+	if body == lower || body == upper {
+		// Digits-only addresses have no letters to checksum
+		if strict && lower != upper {
+			return addr, ErrMissingChecksum
+		}
+		return addr, nil
+	}
+
+	if "0x"+body != ChecksumAddress(addr) {
+		return addr, ErrBadChecksum
+	}
+	return addr, nil
+}
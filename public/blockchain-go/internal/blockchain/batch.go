@@ -0,0 +1,101 @@
+// Package blockchain - Multi-output batch transfers
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+)
+
+// BatchAddress is the system account batch transfers are sent to. It never
+// holds funds: the value of a batch transfer goes to the batch's outputs.
+var BatchAddress = [20]byte{18: 0x01, 19: 0x04}
+
+// batchMagic prefixes the data field of a batch transfer, which is
+//
+//	"BTCH" ‖ (to ‖ amount)*
+//
+// with each amount 32 bytes big-endian, positive, and summing to the value
+var batchMagic = []byte("BTCH")
+
+// Batch transfer limits and gas
+const (
+	MaxBatchOutputs   = 256
+	BatchOutputGas    = 5000 // Gas per output, on top of the intrinsic gas
+	batchOutputLength = 20 + 32
+)
+
+// BatchOutput is one payment of a batch transfer
+type BatchOutput struct {
+	To     [20]byte
+	Amount *big.Int
+}
+
+// EncodeBatchTransfer builds the data of a batch transfer
+func EncodeBatchTransfer(outputs []BatchOutput) []byte {
+	data := make([]byte, 0, len(batchMagic)+len(outputs)*batchOutputLength)
+	data = append(data, batchMagic...)
+	for _, out := range outputs {
+		data = append(data, out.To[:]...)
+		data = append(data, bigToBytes32(out.Amount)...)
+	}
+	return data
+}
+
+// BatchTransferValue returns the value a batch transfer of outputs carries
+func BatchTransferValue(outputs []BatchOutput) *big.Int {
+	total := big.NewInt(0)
+	for _, out := range outputs {
+		total.Add(total, out.Amount)
+	}
+	return total
+}
+
+// BatchTransferGas returns the least gas a batch transfer with data paying
+// outputs accounts needs
+func BatchTransferGas(data []byte, outputs int) uint64 {
+	return IntrinsicGas(data) + uint64(outputs)*BatchOutputGas
+}
+
+// DecodeBatchTx decodes a transaction sent to BatchAddress
+func DecodeBatchTx(tx *Transaction) ([]BatchOutput, error) {
+	if tx.To != BatchAddress {
+		return nil, errors.New("not a batch transfer")
+	}
+	if !bytes.HasPrefix(tx.Data, batchMagic) {
+		return nil, errors.New("invalid batch payload")
+	}
+	payload := tx.Data[len(batchMagic):]
+	if len(payload) == 0 || len(payload)%batchOutputLength != 0 {
+		return nil, errors.New("invalid batch payload length")
+	}
+	count := len(payload) / batchOutputLength
+	if count > MaxBatchOutputs {
+		return nil, errors.New("too many batch outputs")
+	}
+
+	outputs := make([]BatchOutput, count)
+	for i := range outputs {
+		entry := payload[i*batchOutputLength:]
+		copy(outputs[i].To[:], entry[:20])
+		outputs[i].Amount = new(big.Int).SetBytes(entry[20:batchOutputLength])
+		if outputs[i].Amount.Sign() == 0 {
+			return nil, errors.New("zero batch output")
+		}
+		if outputs[i].To == BatchAddress {
+			return nil, errors.New("batch output to the batch address")
+		}
+	}
+
+	value := big.NewInt(0)
+	if tx.Value != nil {
+		value = tx.Value
+	}
+	if BatchTransferValue(outputs).Cmp(value) != 0 {
+		return nil, errors.New("batch outputs do not sum to the value")
+	}
+	if tx.GasLimit < BatchTransferGas(tx.Data, count) {
+		return nil, errors.New("gas limit below batch transfer gas")
+	}
+	return outputs, nil
+}
@@ -2,23 +2,53 @@
 package blockchain
 
 import (
+	"context"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"math/big"
 	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+
 	"chaincore/internal/storage"
+	"chaincore/internal/tracing"
 )
 
 // Config holds blockchain configuration
 type Config struct {
-	ChainID           uint64
-	BlockTime         uint64 // Target block time in seconds
-	MaxBlockSize      uint64 // Max block size in bytes
-	MinGasPrice       uint64 // Minimum gas price
-	ValidatorMinStake *big.Int
+	ChainID                uint64
+	BlockTime              uint64 // Target block time in seconds
+	MaxBlockSize           uint64 // Max block size in bytes
+	MinGasPrice            uint64 // Minimum gas price
+	ValidatorMinStake      *big.Int
+	UnbondingPeriod        time.Duration // How long withdrawn stake stays locked (default 21 days)
+	ShareRetentionBlocks   uint64        // Blocks of raw mining shares to keep (default one week)
+	Vesting                VestingPolicy // Locks unvested reserved balances (nil disables)
+	DoubleSignSlashPercent uint8         // Share of bonded stake burned for double-signing (default 5)
+	Archive                bool          // Keep account history for queries at past heights
+	SnapshotInterval       uint64        // Blocks between state snapshots served for fast sync (0 disables)
+	SettlementEpochBlocks  uint64        // Blocks per mining reward settlement epoch (default a day)
+	BlockReward            *big.Int      // Credited to each block's proposer before halvings (nil disables)
+	HalvingInterval        uint64        // Blocks between block reward halvings (0 never halves)
+	TransferBurnRate       uint64        // Millionths of each transfer's value burned (0 disables)
+	GovernanceVotingPeriod uint64        // Blocks a governance proposal is open for votes (default a week)
+	GovernanceQuorum       uint8         // Percent of bonded stake that must vote on a proposal (default 33)
+	GovernanceThreshold    uint8         // Percent of yes and no votes yes votes must exceed to pass (default 50)
+	TreasuryFeePercent     uint8         // Percent of each transaction fee paid to the treasury (0 disables)
+	BridgeAuthority        [20]byte      // Account that sends bridge mints (zero disables them)
+	ValidatorEpochBlocks   uint64        // Blocks per validator set epoch (default 100)
+	KeyRotationDelay       uint64        // Validator epochs before a rotated consensus key takes effect (default 1)
+	MinShareDifficulty     *big.Int      // Lowest mining share difficulty, paid MiningShareBaseReward (default 2^16)
+	MaxBlockMiningReward   *big.Int      // Most one block's mining shares may accrue (default 100 tokens)
+	MiningAddressCap       *big.Int      // Most one address may accrue per settlement epoch, besides the governed caps (nil for none)
+
+	// Balances credited in the genesis state when a new chain is created
+	GenesisAlloc map[[20]byte]*big.Int
 }
 
 // Block represents a block in the blockchain
@@ -26,26 +56,30 @@ type Block struct {
 	Header       BlockHeader
 	Transactions []Transaction
 	Validators   []ValidatorVote
-	MiningShares []MiningShare
+	Mining       MiningSummary
+	MiningShares []MiningShare `json:",omitempty"` // Raw shares, sent with the block and stored apart in the prunable share store
+	Signature    []byte        // Proposer's signature over the block hash
 }
 
 // BlockHeader contains block metadata
 type BlockHeader struct {
-	Version        uint32
-	Height         uint64
-	Timestamp      uint64
-	PrevHash       [32]byte
-	StateRoot      [32]byte
-	TxRoot         [32]byte
-	ReceiptsRoot   [32]byte
-	ValidatorRoot  [32]byte
-	MiningRoot     [32]byte
-	ProposerAddr   [20]byte
-	Difficulty     *big.Int // For mining shares only
-	Nonce          uint64
-	GasLimit       uint64
-	GasUsed        uint64
-	ExtraData      []byte
+	Version          uint32
+	Height           uint64
+	Timestamp        uint64
+	PrevHash         [32]byte
+	StateRoot        [32]byte
+	TxRoot           [32]byte
+	ReceiptsRoot     [32]byte
+	ValidatorRoot    [32]byte
+	MiningRoot       [32]byte
+	ProposerAddr     [20]byte
+	Difficulty       *big.Int // For mining shares only
+	Nonce            uint64
+	GasLimit         uint64
+	GasUsed          uint64
+	ExtraData        []byte
+	ValidatorSetHash [32]byte // Hash of the validator set taking over; set only in an epoch's first block
+	LogsBloom        Bloom    // Bloom of the receipts' log addresses and topics
 }
 
 // Transaction represents a blockchain transaction
@@ -60,6 +94,14 @@ type Transaction struct {
 	Data      []byte
 	Signature [65]byte
 	Hash      [32]byte
+	ChainID   uint64 // Chain the signature commits to (EIP-155)
+	Create    bool   // Deploys Data as a contract; To must be zero
+
+	// Signed fields of dynamic fee (EIP-1559) transactions, whose GasPrice
+	// is the price these give; nil or empty for legacy transactions
+	GasTipCap  *big.Int
+	GasFeeCap  *big.Int
+	AccessList []AccessTuple
 }
 
 // ValidatorVote represents a validator's vote for PoS consensus
@@ -80,6 +122,10 @@ type MiningShare struct {
 	HumanScore   uint8  // Anti-bot score 0-100
 	SessionID    [32]byte
 	PoolID       [20]byte // Zero if solo mining
+	Reward       *big.Int // Reward credited for the share
+	JobHeight    uint64   // Height of the block the work was for
+	Signature    []byte   // Miner's signature over the share; nil for solo shares
+	TemplateRoot [32]byte // Mining root in the solo template hashed; zero for signed shares
 }
 
 // Blockchain manages the blockchain state
@@ -89,9 +135,25 @@ type Blockchain struct {
 	currentBlock *Block
 	stateDB      *StateDB
 	txPool       *TxPool
-	mu           sync.RWMutex
+	finalized    uint64 // Highest block consensus has finalized
+
+	stakingChanges   [][20]byte // Validators changed by the block being executed
+	txLogs           []*Log     // Logs of the transaction being executed
+	stakingListeners []func(validator [20]byte)
+	headChanges      []*Block // Blocks made head since head listeners were last notified
+	headListeners    []func(block *Block)
+	txListeners      []func(tx *Transaction)
+	contentValidator func(block *Block) error
+	voteWeigher      func(block *Block) *big.Int
+	mu               sync.RWMutex
 }
 
+// Block storage keys
+var (
+	headBlockKey   = []byte("chain:head")
+	blockKeyPrefix = []byte("block:")
+)
+
 // NewBlockchain creates a new blockchain instance
 func NewBlockchain(db storage.Database, config Config) (*Blockchain, error) {
 	bc := &Blockchain{
@@ -112,15 +174,32 @@ func NewBlockchain(db storage.Database, config Config) (*Blockchain, error) {
 	// Load or create genesis block
 	currentBlock, err := bc.loadCurrentBlock()
 	if err != nil {
-		// Create genesis block
+		// Create genesis block, crediting the genesis balances first so its
+		// state root commits to them
+		for addr, balance := range config.GenesisAlloc {
+			bc.stateDB.SetBalance(addr, balance)
+			bc.addSupply(supplyMintedSlot, balance)
+		}
 		genesis := bc.createGenesisBlock()
-		if err := bc.saveBlock(genesis); err != nil {
+		if err := bc.saveBlock(genesis, nil); err != nil {
 			return nil, err
 		}
 		currentBlock = genesis
 	}
 	bc.currentBlock = currentBlock
 
+	// A chain restored from a snapshot never reorganizes below it
+	bc.finalized = bc.chainBase()
+
+	if config.Archive {
+		if err := bc.initHistory(); err != nil {
+			return nil, err
+		}
+	}
+	if err := bc.initTxIndex(); err != nil {
+		return nil, err
+	}
+
 	return bc, nil
 }
 
@@ -132,6 +211,7 @@ func (bc *Blockchain) createGenesisBlock() *Block {
 		Timestamp:  uint64(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Unix()),
 		Difficulty: big.NewInt(1000000),
 		GasLimit:   30000000,
+		StateRoot:  bc.stateDB.Root(),
 	}
 
 	return &Block{
@@ -153,8 +233,20 @@ func (b *Block) Hash() [32]byte {
 	data = append(data, b.Header.PrevHash[:]...)
 	data = append(data, b.Header.StateRoot[:]...)
 	data = append(data, b.Header.TxRoot[:]...)
+	data = append(data, b.Header.ReceiptsRoot[:]...)
 	data = append(data, b.Header.ValidatorRoot[:]...)
+	data = append(data, b.Header.MiningRoot[:]...)
 	data = append(data, b.Header.ProposerAddr[:]...)
+
+	// Only epoch boundary blocks commit to a validator set, and only blocks
+	// with logs to a bloom; leaving the fields out elsewhere keeps the
+	// hashes of earlier blocks unchanged
+	if b.Header.ValidatorSetHash != ([32]byte{}) {
+		data = append(data, b.Header.ValidatorSetHash[:]...)
+	}
+	if b.Header.LogsBloom != (Bloom{}) {
+		data = append(data, b.Header.LogsBloom[:]...)
+	}
 	
 	return sha256.Sum256(data)
 }
@@ -165,48 +257,222 @@ func (b *Block) HashHex() string {
 	return hex.EncodeToString(hash[:])
 }
 
+// ComputeHash calculates the transaction hash over its fields and signature
+func (tx *Transaction) ComputeHash() [32]byte {
+	var value []byte
+	if tx.Value != nil {
+		value = tx.Value.Bytes()
+	}
+
+	data := make([]byte, 0, 128+len(value)+len(tx.Data))
+	data = append(data, tx.Version)
+	data = append(data, uint64ToBytes(tx.Nonce)...)
+	data = append(data, tx.From[:]...)
+	data = append(data, tx.To[:]...)
+	data = append(data, uint64ToBytes(uint64(len(value)))...)
+	data = append(data, value...)
+	data = append(data, uint64ToBytes(tx.GasLimit)...)
+	data = append(data, uint64ToBytes(tx.GasPrice)...)
+	data = append(data, uint64ToBytes(uint64(len(tx.Data)))...)
+	data = append(data, tx.Data...)
+	data = append(data, tx.Signature[:]...)
+	if tx.Create {
+		data = append(data, 1)
+	}
+
+	return sha256.Sum256(data)
+}
+
 // AddTransaction adds a transaction to the pool
-func (bc *Blockchain) AddTransaction(tx *Transaction) error {
+func (bc *Blockchain) AddTransaction(ctx context.Context, tx *Transaction) (err error) {
+	ctx, span := tracing.StartSpan(ctx, "blockchain.AddTransaction",
+		attribute.String("tx.hash", hex.EncodeToString(tx.Hash[:])),
+		attribute.Int64("tx.nonce", int64(tx.Nonce)),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	bc.mu.Lock()
+	span.AddEvent("chain lock acquired")
+	err = bc.addTransaction(ctx, tx)
+	listeners := bc.txListeners
+	bc.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	for _, fn := range listeners {
+		fn(tx)
+	}
+	return nil
+}
+
+// OnPendingTransaction registers a callback for transactions added to the
+// pool by AddTransaction. It is called after the chain lock is released.
+func (bc *Blockchain) OnPendingTransaction(fn func(tx *Transaction)) {
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
 
+	bc.txListeners = append(bc.txListeners, fn)
+}
+
+// addTransaction validates a transaction and adds it to the pool. Callers
+// must hold bc.mu.
+func (bc *Blockchain) addTransaction(ctx context.Context, tx *Transaction) error {
+	if tx.Hash == ([32]byte{}) {
+		tx.Hash = tx.ComputeHash()
+	}
+	if bc.txPool.Get(tx.Hash) != nil {
+		return ErrTxKnown
+	}
+
 	// Validate transaction
 	if err := bc.validateTransaction(tx); err != nil {
 		return err
 	}
 
 	// Add to pool
-	return bc.txPool.Add(tx)
+	return bc.txPool.Add(ctx, tx)
 }
 
-// validateTransaction validates a transaction
+// validateTransaction validates a transaction for admission to the pool.
+// Nonces are checked against the pending state: a transaction may follow
+// the sender's pooled transactions but not reuse or skip a nonce.
 func (bc *Blockchain) validateTransaction(tx *Transaction) error {
+	// Check the chain ID first: a transaction signed for another network
+	// must be refused however valid it would otherwise be here
+	if tx.ChainID != bc.config.ChainID {
+		return fmt.Errorf("%w: signed for chain ID %d, this chain is %d", ErrInvalidChainID, tx.ChainID, bc.config.ChainID)
+	}
+
 	// Check nonce
 	account := bc.stateDB.GetAccount(tx.From)
-	if tx.Nonce != account.Nonce {
-		return errors.New("invalid nonce: transaction nonce must match account nonce")
+	if err := bc.txPool.DetectDoubleSpend(tx, account.Nonce); err != nil {
+		return err
+	}
+	if err := bc.txPool.ValidateNonceSequence(tx, account.Nonce); err != nil {
+		return err
 	}
 
 	// Check balance
 	totalCost := new(big.Int).Mul(big.NewInt(int64(tx.GasLimit)), big.NewInt(int64(tx.GasPrice)))
-	totalCost.Add(totalCost, tx.Value)
+	if tx.Value != nil {
+		totalCost.Add(totalCost, tx.Value)
+	}
 	if account.Balance.Cmp(totalCost) < 0 {
-		return errors.New("insufficient balance for transaction")
+		return ErrInsufficientBalance
+	}
+
+	// Check vesting; the transaction executes no earlier than the head
+	if err := bc.checkVesting(tx.From, account.Balance, totalCost, bc.currentBlock.Header.Timestamp); err != nil {
+		return err
+	}
+
+	if tx.Create && tx.To != ([20]byte{}) {
+		return ErrCreateWithRecipient
+	}
+
+	// Check data payload and the gas it costs
+	if len(tx.Data) > MaxTxDataSize {
+		return ErrTxDataTooLarge
+	}
+	if tx.GasLimit < IntrinsicGas(tx.Data) {
+		return ErrIntrinsicGas
+	}
+
+	// Evidence and settlements are added by block proposers, not submitted
+	// to the pool
+	if tx.To == EvidenceAddress {
+		return fmt.Errorf("%w: evidence is included by block proposers", ErrInvalidEvidence)
+	}
+	if tx.To == SettlementAddress {
+		return fmt.Errorf("%w: settlements are included by block proposers", ErrInvalidSettlement)
+	}
+
+	// Check staking operations against current stakes
+	if tx.To == StakingAddress {
+		op, err := DecodeStakingTx(tx)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidStaking, err)
+		}
+		if err := bc.checkStaking(op); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidStaking, err)
+		}
+	}
+
+	// Check batch transfers' outputs and the gas they cost
+	if tx.To == BatchAddress {
+		if _, err := DecodeBatchTx(tx); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidBatch, err)
+		}
+	}
+
+	// Check token operations against current balances and allowances.
+	// Nothing sent to a token address could be moved again.
+	if tx.To == TokenRegistryAddress {
+		op, err := DecodeTokenTx(tx)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+		}
+		if err := bc.checkToken(op); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+		}
+	}
+	if bc.isToken(tx.To) {
+		return fmt.Errorf("%w: token operations are sent to the token registry", ErrInvalidToken)
+	}
+
+	// Check governance operations against the stake and open proposals
+	// of the next block
+	if tx.To == GovernanceAddress {
+		op, err := DecodeGovernanceTx(tx)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidGovernance, err)
+		}
+		if err := bc.checkGovernance(op, bc.currentBlock.Header.Height+1); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidGovernance, err)
+		}
+	}
+
+	// Check bridge mints against the authority and the deposits minted
+	if tx.To == BridgeMintAddress {
+		m, err := DecodeBridgeMintTx(tx)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidBridgeMint, err)
+		}
+		if err := bc.checkBridgeMint(tx.From, m); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidBridgeMint, err)
+		}
+	}
+
+	// Check key rotations against the validator's key in the next block
+	if tx.To == KeyRotationAddress {
+		kr, err := DecodeKeyRotationTx(tx)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidKeyRotation, err)
+		}
+		if err := bc.checkKeyRotation(kr, bc.currentBlock.Header.Height+1); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidKeyRotation, err)
+		}
 	}
 
 	// Check gas price
 	if tx.GasPrice < bc.config.MinGasPrice {
-		return errors.New("gas price below minimum")
+		return fmt.Errorf("%w: below minimum %d", ErrGasPriceTooLow, bc.config.MinGasPrice)
 	}
 
 	// Verify signature
-	if !verifySignature(tx) {
-		return errors.New("invalid transaction signature")
+	if err := tx.Verify(); err != nil {
+		return err
 	}
 
 	return nil
 }
 
+// ChainID returns the chain ID transactions must be signed for
+func (bc *Blockchain) ChainID() uint64 {
+	return bc.config.ChainID
+}
+
 // GetBlock retrieves a block by height
 func (bc *Blockchain) GetBlock(height uint64) (*Block, error) {
 	bc.mu.RLock()
@@ -231,7 +497,49 @@ func (bc *Blockchain) GetBalance(addr [20]byte) *big.Int {
 	return account.Balance
 }
 
+// Config returns the chain's configuration
+func (bc *Blockchain) Config() Config {
+	return bc.config
+}
+
+// PoolContent returns the transactions waiting in the pool by sender, each
+// sender's in nonce order
+func (bc *Blockchain) PoolContent() map[[20]byte][]*Transaction {
+	return bc.txPool.Content()
+}
+
+// GasPriceAdvisory returns the transaction pool's current minimum gas price
+func (bc *Blockchain) GasPriceAdvisory() GasPriceAdvisory {
+	return bc.txPool.Advisory()
+}
+
+// Database returns the underlying storage
+func (bc *Blockchain) Database() storage.Database {
+	return bc.db
+}
+
+// GetPendingNonce returns the next nonce for an address, counting its
+// transactions waiting in the pool
+func (bc *Blockchain) GetPendingNonce(addr [20]byte) uint64 {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.txPool.PendingNonce(addr, bc.stateDB.GetNonce(addr))
+}
+
+// GetNonce returns the account nonce of an address
+func (bc *Blockchain) GetNonce(addr [20]byte) uint64 {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.stateDB.GetNonce(addr)
+}
+
 // Helper functions
+func blockKey(height uint64) []byte {
+	return append(append([]byte(nil), blockKeyPrefix...), uint64ToBytes(height)...)
+}
+
 func uint64ToBytes(n uint64) []byte {
 	b := make([]byte, 8)
 	for i := 0; i < 8; i++ {
@@ -240,23 +548,85 @@ func uint64ToBytes(n uint64) []byte {
 	return b
 }
 
-func verifySignature(tx *Transaction) bool {
-	// Implement ECDSA signature verification
-	// For now, return true (implement full verification in production)
-	return len(tx.Signature) == 65
-}
-
 func (bc *Blockchain) loadCurrentBlock() (*Block, error) {
-	// Load from database
-	return nil, errors.New("no current block")
+	data, err := bc.db.Get(headBlockKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != 8 {
+		return nil, errors.New("corrupt head block pointer")
+	}
+	return bc.loadBlockByHeight(binary.BigEndian.Uint64(data))
 }
 
 func (bc *Blockchain) loadBlockByHeight(height uint64) (*Block, error) {
-	// Load from database
-	return nil, errors.New("block not found")
+	data, err := bc.db.Get(blockKey(height))
+	if err != nil {
+		return nil, errors.New("block not found")
+	}
+
+	var block Block
+	if err := json.Unmarshal(data, &block); err != nil {
+		return nil, err
+	}
+	return &block, nil
 }
 
-func (bc *Blockchain) saveBlock(block *Block) error {
-	// Save to database
-	return nil
+func (bc *Blockchain) saveBlock(block *Block, receipts []*Receipt) error {
+	if err := block.VerifyRoots(nil); err != nil {
+		return err
+	}
+	if err := block.VerifyMining(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(block.withoutShares())
+	if err != nil {
+		return err
+	}
+
+	// The block, head pointer, receipts, transaction index, undo record and
+	// state changes land in one batch so a crash cannot leave the head ahead
+	// of the state it was built on
+	batch := bc.db.NewBatch()
+	if err := batch.Put(blockKey(block.Header.Height), data); err != nil {
+		return err
+	}
+	if err := batch.Put(headBlockKey, uint64ToBytes(block.Header.Height)); err != nil {
+		return err
+	}
+	if err := writeReceipts(batch, receipts); err != nil {
+		return err
+	}
+	if err := writeTxIndex(batch, block); err != nil {
+		return err
+	}
+	if err := bc.writeShares(batch, block); err != nil {
+		return err
+	}
+	if err := bc.writeUndo(batch, block.Header.Height); err != nil {
+		return err
+	}
+	if bc.config.Archive {
+		if err := bc.stateDB.writeBalanceHistory(batch, block.Header.Height, false); err != nil {
+			return err
+		}
+	}
+	if err := bc.stateDB.commitTo(batch); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+
+	if err := bc.maybeWriteSnapshot(block); err != nil {
+		return err
+	}
+
+	// Record chart metrics against the block being extended
+	parent := bc.currentBlock
+	if parent != nil && parent.Header.Height+1 != block.Header.Height {
+		parent = nil
+	}
+	return bc.recordBlockMetrics(block, parent, receipts)
 }
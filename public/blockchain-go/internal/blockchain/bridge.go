@@ -0,0 +1,131 @@
+// Package blockchain - Bridge mints for deposits on foreign chains
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+// BridgeMintAddress is the system account bridge mints are sent to. Only
+// Config.BridgeAuthority, the bridge operator's account, may send to it:
+// each mint credits a foreign deposit's value to its recipient as new
+// supply on every node that executes the block. Its storage maps the ID of
+// each minted deposit to the foreign block hash it was minted for.
+//
+// Nodes cannot see the foreign chain, so they trust the authority's key to
+// mint only deposits it verified there. What the chain does enforce is that
+// every mint names its foreign transaction, log and block, and that a
+// foreign deposit log is minted at most once. Anyone can audit the mints
+// against the foreign chain; a leaked authority key can still mint
+// invented deposits until the genesis authority is replaced.
+var BridgeMintAddress = [20]byte{18: 0x01, 19: 0x0a}
+
+// bridgeMintMagic prefixes the data field of a bridge mint, which is
+//
+//	"BMNT" ‖ foreign tx ‖ log index ‖ foreign block ‖ recipient ‖ amount
+//
+// with the foreign transaction and block hashes 32 bytes, the log index 8
+// bytes and the amount 32 bytes, all big-endian
+var bridgeMintMagic = []byte("BMNT")
+
+const bridgeMintLength = 32 + 8 + 32 + 20 + 32
+
+// ErrInvalidBridgeMint is returned for bridge mints not sent by the bridge
+// authority, malformed, or for a deposit already minted
+var ErrInvalidBridgeMint = errors.New("invalid bridge mint")
+
+// BridgeMint credits a foreign deposit to its recipient
+type BridgeMint struct {
+	ForeignTx    [32]byte // Foreign transaction holding the deposit
+	LogIndex     uint64   // Deposit's transfer log
+	ForeignBlock [32]byte // Foreign block the deposit was confirmed in
+	Recipient    [20]byte
+	Amount       *big.Int // Wei minted
+}
+
+// Deposit returns the ID the chain records the mint's deposit under
+func (m *BridgeMint) Deposit() [32]byte {
+	return BridgeDepositID(m.ForeignTx, m.LogIndex)
+}
+
+// BridgeDepositID identifies the deposit in a foreign transaction's log,
+// whichever block the transaction ends up in
+func BridgeDepositID(foreignTx [32]byte, logIndex uint64) [32]byte {
+	data := make([]byte, 0, 40)
+	data = append(data, foreignTx[:]...)
+	data = binary.BigEndian.AppendUint64(data, logIndex)
+	return sha256.Sum256(data)
+}
+
+// EncodeBridgeMint builds the data of a bridge mint
+func EncodeBridgeMint(m *BridgeMint) []byte {
+	data := make([]byte, 0, len(bridgeMintMagic)+bridgeMintLength)
+	data = append(data, bridgeMintMagic...)
+	data = append(data, m.ForeignTx[:]...)
+	data = binary.BigEndian.AppendUint64(data, m.LogIndex)
+	data = append(data, m.ForeignBlock[:]...)
+	data = append(data, m.Recipient[:]...)
+	return append(data, bigToBytes32(m.Amount)...)
+}
+
+// DecodeBridgeMintTx decodes a transaction sent to BridgeMintAddress
+func DecodeBridgeMintTx(tx *Transaction) (*BridgeMint, error) {
+	if tx.To != BridgeMintAddress {
+		return nil, errors.New("not a bridge mint")
+	}
+	if tx.Value != nil && tx.Value.Sign() != 0 {
+		return nil, errors.New("bridge mints must not carry value")
+	}
+	if !bytes.HasPrefix(tx.Data, bridgeMintMagic) || len(tx.Data) != len(bridgeMintMagic)+bridgeMintLength {
+		return nil, errors.New("invalid bridge mint payload")
+	}
+
+	payload := tx.Data[len(bridgeMintMagic):]
+	m := &BridgeMint{LogIndex: binary.BigEndian.Uint64(payload[32:40]), Amount: new(big.Int).SetBytes(payload[92:])}
+	copy(m.ForeignTx[:], payload[:32])
+	copy(m.ForeignBlock[:], payload[40:72])
+	copy(m.Recipient[:], payload[72:92])
+	if m.ForeignTx == ([32]byte{}) || m.ForeignBlock == ([32]byte{}) {
+		return nil, errors.New("bridge mints must name the foreign transaction and block")
+	}
+	if m.Amount.Sign() == 0 {
+		return nil, errors.New("bridge mint of nothing")
+	}
+	if m.Recipient == ([20]byte{}) || isSystemAddress(m.Recipient) {
+		return nil, errors.New("bridge mints must go to a user account")
+	}
+	return m, nil
+}
+
+// BridgeMinted reports whether a deposit has been minted at the head
+func (bc *Blockchain) BridgeMinted(deposit [32]byte) bool {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.stateDB.GetState(BridgeMintAddress, deposit) != [32]byte{}
+}
+
+// Helper functions
+
+// checkBridgeMint checks that a mint is the bridge authority's and that
+// its deposit is not minted yet. Callers must hold bc.mu.
+func (bc *Blockchain) checkBridgeMint(from [20]byte, m *BridgeMint) error {
+	if bc.config.BridgeAuthority == ([20]byte{}) || from != bc.config.BridgeAuthority {
+		return errors.New("only the bridge authority mints")
+	}
+	if bc.stateDB.GetState(BridgeMintAddress, m.Deposit()) != [32]byte{} {
+		return errors.New("deposit already minted")
+	}
+	return nil
+}
+
+// applyBridgeMint credits a checked mint and records its deposit as minted
+// for its foreign block. Callers must hold bc.mu.
+func (bc *Blockchain) applyBridgeMint(m *BridgeMint) {
+	bc.stateDB.SetState(BridgeMintAddress, m.Deposit(), m.ForeignBlock)
+	bc.stateDB.AddBalance(m.Recipient, m.Amount)
+	bc.addSupply(supplyMintedSlot, m.Amount)
+	bc.emitAmountLog(TopicMint, m.Recipient, m.Amount)
+}
@@ -0,0 +1,100 @@
+// Package blockchain - Gas estimation by dry-running transactions
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Gas estimation errors
+var (
+	ErrInsufficientFundsForGas = errors.New("insufficient funds for gas * price + value")
+	ErrGasAllowanceExceeded    = errors.New("gas required exceeds allowance")
+	ErrExecutionFailed         = errors.New("execution failed")
+)
+
+// EstimateGas returns the lowest gas limit tx succeeds with on top of the
+// head, ignoring its nonce and signature. If it fails at its allowance the
+// error says why, such as ErrExecutionFailed with the failure reason.
+func (bc *Blockchain) EstimateGas(tx *Transaction) (uint64, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	defer bc.takeStakingChanges()
+
+	header := bc.pendingHeader()
+	if tx.To == EvidenceAddress || tx.To == SettlementAddress {
+		return 0, errors.New("evidence and settlements are included by block proposers")
+	}
+
+	allowance := header.GasLimit
+	if tx.GasLimit > 0 && tx.GasLimit < allowance {
+		allowance = tx.GasLimit
+	}
+	funded := true
+	if tx.GasPrice > 0 {
+		available := new(big.Int).Set(bc.stateDB.GetAccount(tx.From).Balance)
+		if tx.Value != nil {
+			available.Sub(available, tx.Value)
+		}
+		if available.Sign() < 0 {
+			return 0, ErrInsufficientFundsForGas
+		}
+		available.Div(available, new(big.Int).SetUint64(tx.GasPrice))
+		if available.IsUint64() && available.Uint64() < allowance {
+			allowance, funded = available.Uint64(), false
+		}
+	}
+
+	low := IntrinsicGas(tx.Data)
+	if allowance < low {
+		if !funded {
+			return 0, ErrInsufficientFundsForGas
+		}
+		return 0, fmt.Errorf("%w (%d below intrinsic gas %d)", ErrGasAllowanceExceeded, allowance, low)
+	}
+
+	// A transaction that fails with all the gas it may have fails with any
+	if reason, err := bc.dryRun(tx, header, allowance); err != nil {
+		return 0, err
+	} else if reason != "" {
+		if reason == FailureInsufficientFunds || reason == FailureInsufficientBalance {
+			return 0, ErrInsufficientFundsForGas
+		}
+		return 0, fmt.Errorf("%w: %s", ErrExecutionFailed, reason)
+	}
+
+	// Search for the lowest passing limit; low fails or is intrinsic
+	high := allowance
+	if reason, err := bc.dryRun(tx, header, low); err == nil && reason == "" {
+		return low, nil
+	}
+	for low+1 < high {
+		mid := low + (high-low)/2
+		if reason, err := bc.dryRun(tx, header, mid); err == nil && reason == "" {
+			high = mid
+		} else {
+			low = mid
+		}
+	}
+	return high, nil
+}
+
+// Helper functions
+
+// dryRun executes tx with the given gas limit and the sender's current
+// nonce, then reverts it, returning the failure reason code. The
+// transaction is unsigned, so its sender is taken as given. Callers must
+// hold bc.mu.
+func (bc *Blockchain) dryRun(tx *Transaction, header *BlockHeader, gas uint64) (string, error) {
+	run := *tx
+	run.GasLimit = gas
+	run.Nonce = bc.stateDB.GetNonce(tx.From)
+
+	snapshot := bc.stateDB.Snapshot()
+	defer bc.stateDB.RevertToSnapshot(snapshot)
+	defer bc.takeLogs()
+
+	_, reason, err := bc.executeTransaction(&run, header)
+	return reason, err
+}
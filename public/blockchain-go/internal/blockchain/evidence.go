@@ -0,0 +1,242 @@
+// Package blockchain - Double-signing evidence and on-chain slashing
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// EvidenceAddress is the system account evidence transactions are sent to.
+// Evidence transactions are added by block proposers: they come from
+// EvidenceAddress itself, pay no fee and use no nonce, and a block carrying
+// invalid evidence is invalid.
+var EvidenceAddress = [20]byte{18: 0x01, 19: 0x03}
+
+// evidenceMagic prefixes the data field of an evidence transaction
+var evidenceMagic = []byte("EVID")
+
+// DefaultDoubleSignSlashPercent is used when Config.DoubleSignSlashPercent
+// is zero
+const DefaultDoubleSignSlashPercent = 5
+
+// evidenceDataLength is the payload size: validator, height and two block
+// hash and signature pairs
+const evidenceDataLength = 20 + 8 + 2*(32+65)
+
+// voteDomain separates vote digests from other consensus signatures
+var voteDomain = []byte("VOTE")
+
+// ErrInvalidEvidence is returned for evidence that does not prove
+// double-signing
+var ErrInvalidEvidence = errors.New("invalid double-sign evidence")
+
+// DoubleSignEvidence proves a validator signed votes for two different
+// blocks at the same height
+type DoubleSignEvidence struct {
+	Validator  [20]byte
+	Height     uint64
+	FirstHash  [32]byte
+	FirstSig   [65]byte
+	SecondHash [32]byte
+	SecondSig  [65]byte
+}
+
+// VoteDigest returns the message a validator signs to vote for a block
+func VoteDigest(height uint64, blockHash [32]byte) [32]byte {
+	data := make([]byte, 0, len(voteDomain)+8+32)
+	data = append(data, voteDomain...)
+	data = binary.BigEndian.AppendUint64(data, height)
+	data = append(data, blockHash[:]...)
+	return sha256.Sum256(data)
+}
+
+// VerifyVoteSignature checks a vote signature: r || s of an ECDSA signature
+// over VoteDigest, with the last byte unused
+func VerifyVoteSignature(pub *ecdsa.PublicKey, height uint64, blockHash [32]byte, signature [65]byte) bool {
+	if pub == nil {
+		return false
+	}
+	digest := VoteDigest(height, blockHash)
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:64])
+	return ecdsa.Verify(pub, digest[:], r, s)
+}
+
+// NewEvidenceTx packages evidence as a transaction for a block proposal.
+// The votes are put in block hash order so the same evidence always
+// encodes the same way.
+func NewEvidenceTx(ev *DoubleSignEvidence) Transaction {
+	first, second := ev.FirstHash, ev.SecondHash
+	firstSig, secondSig := ev.FirstSig, ev.SecondSig
+	if bytes.Compare(first[:], second[:]) > 0 {
+		first, second = second, first
+		firstSig, secondSig = secondSig, firstSig
+	}
+
+	data := make([]byte, 0, len(evidenceMagic)+evidenceDataLength)
+	data = append(data, evidenceMagic...)
+	data = append(data, ev.Validator[:]...)
+	data = binary.BigEndian.AppendUint64(data, ev.Height)
+	data = append(data, first[:]...)
+	data = append(data, firstSig[:]...)
+	data = append(data, second[:]...)
+	data = append(data, secondSig[:]...)
+
+	tx := Transaction{
+		From: EvidenceAddress,
+		To:   EvidenceAddress,
+		Data: data,
+	}
+	tx.Hash = tx.ComputeHash()
+	return tx
+}
+
+// DecodeEvidenceTx decodes a transaction sent to EvidenceAddress
+func DecodeEvidenceTx(tx *Transaction) (*DoubleSignEvidence, error) {
+	if tx.To != EvidenceAddress || tx.From != EvidenceAddress {
+		return nil, errors.New("not an evidence transaction")
+	}
+	if (tx.Value != nil && tx.Value.Sign() != 0) || tx.GasLimit != 0 || tx.GasPrice != 0 || tx.Nonce != 0 {
+		return nil, errors.New("evidence must not carry value, gas or a nonce")
+	}
+	if !bytes.HasPrefix(tx.Data, evidenceMagic) || len(tx.Data) != len(evidenceMagic)+evidenceDataLength {
+		return nil, errors.New("invalid evidence payload")
+	}
+
+	payload := tx.Data[len(evidenceMagic):]
+	ev := &DoubleSignEvidence{Height: binary.BigEndian.Uint64(payload[20:28])}
+	copy(ev.Validator[:], payload[:20])
+	copy(ev.FirstHash[:], payload[28:60])
+	copy(ev.FirstSig[:], payload[60:125])
+	copy(ev.SecondHash[:], payload[125:157])
+	copy(ev.SecondSig[:], payload[157:])
+	return ev, nil
+}
+
+// EvidenceApplied reports whether a validator has already been slashed for
+// double-signing at a height
+func (bc *Blockchain) EvidenceApplied(validator [20]byte, height uint64) bool {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.evidenceApplied(validator, height)
+}
+
+// Helper functions
+
+// applyEvidenceTx checks evidence and slashes the validator. Unlike other
+// transactions, evidence that fails makes the block invalid, since a
+// proposer can check it before including it. Callers must hold bc.mu.
+func (bc *Blockchain) applyEvidenceTx(tx *Transaction) error {
+	ev, err := DecodeEvidenceTx(tx)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEvidence, err)
+	}
+	if err := bc.checkEvidence(ev); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEvidence, err)
+	}
+
+	bc.stateDB.SetState(StakingAddress, evidenceSlot(ev.Validator, ev.Height), uint256Word(big.NewInt(1)))
+	bc.slashValidator(ev.Validator, bc.doubleSignSlashPercent())
+	return nil
+}
+
+// checkEvidence verifies both votes against the consensus key registered on
+// chain. Votes signed with a key rotated in after registration cannot be
+// proven on chain. Callers must hold bc.mu.
+func (bc *Blockchain) checkEvidence(ev *DoubleSignEvidence) error {
+	if ev.FirstHash == ev.SecondHash {
+		return errors.New("votes are for the same block")
+	}
+	staked, err := bc.stakedValidator(ev.Validator)
+	if err != nil {
+		return err
+	}
+	if bc.evidenceApplied(ev.Validator, ev.Height) {
+		return errors.New("already slashed for this height")
+	}
+
+	x, y := elliptic.Unmarshal(elliptic.P256(), staked.PubKey)
+	if x == nil {
+		return errors.New("invalid consensus key on chain")
+	}
+	pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	if !VerifyVoteSignature(pub, ev.Height, ev.FirstHash, ev.FirstSig) ||
+		!VerifyVoteSignature(pub, ev.Height, ev.SecondHash, ev.SecondSig) {
+		return errors.New("bad vote signature")
+	}
+	return nil
+}
+
+// slashValidator burns percent of everything bonded to a validator,
+// including stake still unbonding from it, and jails the validator.
+// Callers must hold bc.mu.
+func (bc *Blockchain) slashValidator(validator [20]byte, percent uint64) {
+	burned := big.NewInt(0)
+	slash := func(amount *big.Int) *big.Int {
+		cut := new(big.Int).Mul(amount, new(big.Int).SetUint64(percent))
+		cut.Div(cut, big.NewInt(100))
+		burned.Add(burned, cut)
+		return new(big.Int).Sub(amount, cut)
+	}
+
+	// Bonded stake: the validator's own and each delegator's
+	delegators := [][20]byte{validator}
+	count := wordToUint64(bc.stateDB.GetState(StakingAddress, stakingSlot("delegator-count", validator[:])))
+	for i := uint64(0); i < count; i++ {
+		word := bc.stateDB.GetState(StakingAddress, stakingSlot("delegator", validator[:], uint64ToBytes(i)))
+		var delegator [20]byte
+		copy(delegator[:], word[12:])
+		delegators = append(delegators, delegator)
+	}
+	total := big.NewInt(0)
+	for _, delegator := range delegators {
+		stake := slash(bc.stakeAmount(validator, delegator))
+		bc.stateDB.SetState(StakingAddress, stakingSlot("stake", validator[:], delegator[:]), uint256Word(stake))
+		total.Add(total, stake)
+	}
+	bc.stateDB.SetState(StakingAddress, stakingSlot("total", validator[:]), uint256Word(total))
+
+	// Stake withdrawn but not yet released
+	unbonding := big.NewInt(0)
+	head, tail := bc.unbondingBounds()
+	for i := head; i < tail; i++ {
+		entry := bc.unbondingEntry(i)
+		if entry.Validator != validator || entry.Amount.Sign() == 0 {
+			continue
+		}
+		entry.Amount = slash(entry.Amount)
+		bc.setUnbondingEntry(i, entry)
+		unbonding.Add(unbonding, entry.Amount)
+	}
+	bc.stateDB.SetState(StakingAddress, stakingSlot("unbonding", validator[:]), uint256Word(unbonding))
+
+	bc.stateDB.SubBalance(StakingAddress, burned)
+	bc.addSupply(supplyBurnedSlot, burned)
+	word := uint256Word(burned)
+	bc.emitLog(StakingAddress, word[:], TopicSlash, AddressTopic(validator))
+	bc.emitAmountLog(TopicBurn, StakingAddress, burned)
+	bc.stateDB.SetState(StakingAddress, stakingSlot("jailed", validator[:]), uint256Word(big.NewInt(1)))
+	bc.stakingChanges = append(bc.stakingChanges, validator)
+}
+
+func (bc *Blockchain) doubleSignSlashPercent() uint64 {
+	if percent := bc.config.DoubleSignSlashPercent; percent > 0 && percent <= 100 {
+		return uint64(percent)
+	}
+	return DefaultDoubleSignSlashPercent
+}
+
+func (bc *Blockchain) evidenceApplied(validator [20]byte, height uint64) bool {
+	return bc.stateDB.GetState(StakingAddress, evidenceSlot(validator, height)) != ([32]byte{})
+}
+
+func evidenceSlot(validator [20]byte, height uint64) [32]byte {
+	return stakingSlot("slashed", validator[:], uint64ToBytes(height))
+}
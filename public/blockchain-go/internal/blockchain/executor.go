@@ -0,0 +1,465 @@
+// Package blockchain - Block execution and import
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// maxBlockTxs caps the pool transactions considered for one block proposal
+const maxBlockTxs = 5000
+
+// InsertBlock executes a block on top of the current head, checks the
+// header against the execution results and persists the block together
+// with its receipts. A block on another branch is kept as a side block and
+// the chain reorganizes onto its branch if that comes to outweigh the
+// current one. Staking and head listeners are notified once the chain lock
+// is released.
+func (bc *Blockchain) InsertBlock(block *Block) error {
+	// Content rules are checked outside the chain lock so the validator
+	// may read the chain
+	bc.mu.RLock()
+	validate := bc.contentValidator
+	bc.mu.RUnlock()
+	if validate != nil {
+		if err := validate(block); err != nil {
+			return err
+		}
+	}
+
+	bc.mu.Lock()
+	err := bc.importBlock(block)
+	changes := bc.takeStakingChanges()
+	listeners := bc.stakingListeners
+	heads := bc.takeHeadChanges()
+	headListeners := bc.headListeners
+	bc.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	for _, validator := range changes {
+		for _, fn := range listeners {
+			fn(validator)
+		}
+	}
+	for _, head := range heads {
+		for _, fn := range headListeners {
+			fn(head)
+		}
+	}
+	return nil
+}
+
+// OnNewHead registers a callback for blocks that become the head of the
+// chain, in the order they do: each inserted block, and each block of a
+// branch the chain reorganizes onto. A failed reorg restores the old head
+// and reports nothing. It is called after the chain lock is released.
+func (bc *Blockchain) OnNewHead(fn func(block *Block)) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	bc.headListeners = append(bc.headListeners, fn)
+}
+
+// SetContentValidator installs consensus rules on block contents, such as
+// which votes a proposer must include. InsertBlock rejects blocks the
+// validator returns an error for.
+func (bc *Blockchain) SetContentValidator(fn func(block *Block) error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	bc.contentValidator = fn
+}
+
+// insertBlock executes and persists a block. Callers must hold bc.mu.
+func (bc *Blockchain) insertBlock(block *Block) error {
+	parent := bc.currentBlock
+	if block.Header.Height != parent.Header.Height+1 || block.Header.PrevHash != parent.Hash() {
+		return fmt.Errorf("block %d does not extend head %d", block.Header.Height, parent.Header.Height)
+	}
+
+	snapshot := bc.stateDB.Snapshot()
+	receipts, err := bc.executeBlock(block)
+	if err == nil {
+		err = bc.verifyExecution(block, receipts)
+	}
+	if err != nil {
+		bc.stateDB.RevertToSnapshot(snapshot)
+		return err
+	}
+
+	if err := bc.saveBlock(block, receipts); err != nil {
+		return err
+	}
+	bc.currentBlock = block
+
+	// Drop the included transactions and any pooled ones they conflict with
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		bc.txPool.Remove(tx.Hash)
+		bc.txPool.RemoveStale(tx.From, bc.stateDB.GetNonce(tx.From))
+	}
+	bc.headChanges = append(bc.headChanges, block)
+	return nil
+}
+
+// takeHeadChanges returns and clears the blocks made head since the last
+// call. Callers must hold bc.mu.
+func (bc *Blockchain) takeHeadChanges() []*Block {
+	heads := bc.headChanges
+	bc.headChanges = nil
+	return heads
+}
+
+// StateRootAfter executes a block on top of the current head without
+// committing it and returns the resulting state root, so block producers
+// can fill in StateRoot before sealing the header
+func (bc *Blockchain) StateRootAfter(block *Block) ([32]byte, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	snapshot := bc.stateDB.Snapshot()
+	defer bc.stateDB.RevertToSnapshot(snapshot)
+	defer bc.takeStakingChanges()
+
+	if _, err := bc.executeBlock(block); err != nil {
+		return [32]byte{}, err
+	}
+	return bc.stateDB.Root(), nil
+}
+
+// FillBlock assembles a block proposal on top of the current head. It takes
+// pending transactions from the pool up to the block's gas limit, drops
+// those that could not pay for themselves, and executes the rest without
+// committing to fill in GasUsed, StateRoot and the header roots. The caller
+// sets the height, parent, timestamp, proposer and votes beforehand and
+// signs the header afterwards. Transactions already in the block, such as
+// evidence, go first and are dropped if they fail.
+func (bc *Blockchain) FillBlock(block *Block) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	parent := bc.currentBlock
+	if block.Header.Height != parent.Header.Height+1 || block.Header.PrevHash != parent.Hash() {
+		return fmt.Errorf("block %d does not extend head %d", block.Header.Height, parent.Header.Height)
+	}
+	defer bc.takeStakingChanges()
+
+	// Trial-apply candidates to find the ones that execute, then replay
+	// exactly those so the header matches what importers will compute
+	snapshot := bc.stateDB.Snapshot()
+	candidates := make([]*Transaction, 0, len(block.Transactions))
+	for i := range block.Transactions {
+		candidates = append(candidates, &block.Transactions[i])
+	}
+	candidates = append(candidates, orderByNonce(bc.txPool.GetPending(maxBlockTxs, block.Header.GasLimit))...)
+	block.Transactions = make([]Transaction, 0, len(candidates))
+	for _, tx := range candidates {
+		gasUsed, reason, err := bc.applyTransaction(tx, &block.Header)
+		if err != nil || (reason != "" && gasUsed == 0) {
+			continue
+		}
+		block.Transactions = append(block.Transactions, *tx)
+	}
+	bc.stateDB.RevertToSnapshot(snapshot)
+
+	snapshot = bc.stateDB.Snapshot()
+	defer bc.stateDB.RevertToSnapshot(snapshot)
+
+	receipts, err := bc.executeBlock(block)
+	if err != nil {
+		return err
+	}
+	block.Header.GasUsed = 0
+	if len(receipts) > 0 {
+		block.Header.GasUsed = receipts[len(receipts)-1].CumulativeGasUsed
+	}
+	if err := block.SetRoots(receipts); err != nil {
+		return err
+	}
+	block.Header.StateRoot = bc.stateDB.Root()
+	return nil
+}
+
+// executeBlock applies the block's transactions to state, credits the block
+// reward, settles governance and returns the transactions' receipts.
+// Transactions that fail at execution get a failed receipt; only malformed
+// transactions, or a gas limit other than the governed one, make the block
+// invalid. Callers must hold bc.mu and revert state on error.
+func (bc *Blockchain) executeBlock(block *Block) ([]*Receipt, error) {
+	if limit, governed := bc.governanceParam(ParamBlockGasLimit); governed && block.Header.GasLimit != limit.Uint64() {
+		return nil, fmt.Errorf("block gas limit %d differs from the governed limit %s", block.Header.GasLimit, limit)
+	}
+	blockHash := block.Hash()
+	receipts := make([]*Receipt, 0, len(block.Transactions))
+
+	// Drop logs left over from trial runs
+	bc.takeLogs()
+
+	var cumulativeGas uint64
+	var logIndex uint64
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		gasUsed, reason, err := bc.applyTransaction(tx, &block.Header)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %d: %w", i, err)
+		}
+		cumulativeGas += gasUsed
+
+		status := ReceiptStatusSuccessful
+		logs := bc.takeLogs()
+		if reason != "" {
+			status, logs = ReceiptStatusFailed, nil
+		}
+		if logs == nil {
+			logs = []*Log{}
+		}
+		for _, l := range logs {
+			l.LogIndex = logIndex
+			logIndex++
+		}
+		var contract [20]byte
+		if status == ReceiptStatusSuccessful && tx.IsContractCreation() {
+			contract = ContractAddress(tx.From, tx.Nonce)
+		}
+		receipts = append(receipts, &Receipt{
+			TxHash:            txHash(tx),
+			TxIndex:           uint64(i),
+			BlockHash:         blockHash,
+			BlockNumber:       block.Header.Height,
+			From:              tx.From,
+			To:                tx.To,
+			Create:            tx.Create,
+			Status:            status,
+			FailureReason:     reason,
+			GasUsed:           gasUsed,
+			CumulativeGasUsed: cumulativeGas,
+			EffectiveGasPrice: tx.GasPrice,
+			ContractAddress:   contract,
+			Logs:              logs,
+		})
+	}
+
+	// Pay the proposer the block reward
+	bc.creditBlockReward(&block.Header)
+
+	// Accrue the block's mining rewards for settlement
+	if err := bc.verifyMiningShares(block); err != nil {
+		return nil, err
+	}
+	if err := bc.accrueMiningRewards(block); err != nil {
+		return nil, err
+	}
+
+	// Release stake whose unbonding period has passed
+	bc.releaseUnbonding(block.Header.Timestamp)
+
+	// Close voting and apply parameter changes due at this height
+	bc.processGovernance(block.Header.Height)
+
+	return receipts, nil
+}
+
+// applyTransaction pays the fee to the proposer and the treasury, transfers
+// value less the transfer burn to the recipient or a batch's outputs, and
+// records staking, token, governance and bridge operations, running
+// contract calls on the VM; evidence and settlements are applied free of
+// charge. A transaction that cannot pay its fee or has the wrong nonce uses
+// no gas, later failures charge the gas limit, and both return a failure
+// reason for the receipt. An error means the block is invalid.
+func (bc *Blockchain) applyTransaction(tx *Transaction, header *BlockHeader) (uint64, string, error) {
+	if len(tx.Data) > MaxTxDataSize {
+		return 0, "", errors.New("transaction data too large")
+	}
+	if tx.Create && tx.To != ([20]byte{}) {
+		return 0, "", ErrCreateWithRecipient
+	}
+	if tx.To == EvidenceAddress {
+		return 0, "", bc.applyEvidenceTx(tx)
+	}
+	if tx.To == SettlementAddress {
+		return 0, "", bc.applySettlementTx(tx, header.Height)
+	}
+
+	// Everything else is checked as the pool checks it, since blocks from
+	// other nodes may carry transactions it never saw
+	if tx.ChainID != bc.config.ChainID {
+		return 0, "", fmt.Errorf("%w: signed for chain ID %d", ErrInvalidChainID, tx.ChainID)
+	}
+	if err := tx.Verify(); err != nil {
+		return 0, "", err
+	}
+	return bc.executeTransaction(tx, header)
+}
+
+// executeTransaction applies a transaction whose sender has been verified,
+// or one being dry-run
+func (bc *Blockchain) executeTransaction(tx *Transaction, header *BlockHeader) (uint64, string, error) {
+	coinbase, timestamp := header.ProposerAddr, header.Timestamp
+	if tx.GasLimit < IntrinsicGas(tx.Data) {
+		return 0, "", errors.New("gas limit below intrinsic gas")
+	}
+	if err := bc.stateDB.ValidateNonce(tx.From, tx.Nonce); err != nil {
+		return 0, FailureInvalidNonce, nil
+	}
+
+	// Gas is charged at the limit; refunds are not implemented
+	fee := new(big.Int).Mul(new(big.Int).SetUint64(tx.GasLimit), new(big.Int).SetUint64(tx.GasPrice))
+	balance := bc.stateDB.GetAccount(tx.From).Balance
+	if balance.Cmp(fee) < 0 {
+		return 0, FailureInsufficientFunds, nil
+	}
+	if err := bc.checkVesting(tx.From, balance, fee, timestamp); err != nil {
+		return 0, FailureUnvestedFunds, nil
+	}
+	if err := bc.stateDB.SubBalance(tx.From, fee); err != nil {
+		return 0, FailureInsufficientFunds, nil
+	}
+	bc.payFee(coinbase, fee, header.Height)
+	bc.stateDB.IncrementNonce(tx.From)
+
+	var staking *StakingOp
+	if tx.To == StakingAddress {
+		op, err := DecodeStakingTx(tx)
+		if err != nil {
+			return tx.GasLimit, FailureStakingRejected, nil
+		}
+		if err := bc.checkStaking(op); err != nil {
+			return tx.GasLimit, FailureStakingRejected, nil
+		}
+		staking = op
+	}
+
+	var tokenOp *TokenOp
+	if tx.To == TokenRegistryAddress {
+		op, err := DecodeTokenTx(tx)
+		if err != nil {
+			return tx.GasLimit, FailureTokenRejected, nil
+		}
+		if err := bc.checkToken(op); err != nil {
+			return tx.GasLimit, FailureTokenRejected, nil
+		}
+		tokenOp = op
+	}
+
+	var governance *GovernanceOp
+	if tx.To == GovernanceAddress {
+		op, err := DecodeGovernanceTx(tx)
+		if err != nil {
+			return tx.GasLimit, FailureGovernanceRejected, nil
+		}
+		if err := bc.checkGovernance(op, header.Height); err != nil {
+			return tx.GasLimit, FailureGovernanceRejected, nil
+		}
+		governance = op
+	}
+
+	var bridgeMint *BridgeMint
+	if tx.To == BridgeMintAddress {
+		m, err := DecodeBridgeMintTx(tx)
+		if err != nil {
+			return tx.GasLimit, FailureBridgeMintRejected, nil
+		}
+		if err := bc.checkBridgeMint(tx.From, m); err != nil {
+			return tx.GasLimit, FailureBridgeMintRejected, nil
+		}
+		bridgeMint = m
+	}
+
+	var rotation *KeyRotation
+	if tx.To == KeyRotationAddress {
+		kr, err := DecodeKeyRotationTx(tx)
+		if err != nil {
+			return tx.GasLimit, FailureKeyRotationRejected, nil
+		}
+		if err := bc.checkKeyRotation(kr, header.Height); err != nil {
+			return tx.GasLimit, FailureKeyRotationRejected, nil
+		}
+		rotation = kr
+	}
+
+	var outputs []BatchOutput
+	if tx.To == BatchAddress {
+		decoded, err := DecodeBatchTx(tx)
+		if err != nil {
+			return tx.GasLimit, FailureBatchRejected, nil
+		}
+		outputs = decoded
+	}
+
+	value := big.NewInt(0)
+	if tx.Value != nil {
+		value = tx.Value
+	}
+	balance = bc.stateDB.GetAccount(tx.From).Balance
+	if balance.Cmp(value) < 0 {
+		return tx.GasLimit, FailureInsufficientBalance, nil
+	}
+	if err := bc.checkVesting(tx.From, balance, value, timestamp); err != nil {
+		return tx.GasLimit, FailureUnvestedFunds, nil
+	}
+	if staking == nil && outputs == nil && (tx.IsContractCreation() || len(bc.stateDB.GetCode(tx.To)) > 0) {
+		return tx.GasLimit, bc.applyContractTx(tx, header, value), nil
+	}
+	if err := bc.stateDB.SubBalance(tx.From, value); err != nil {
+		return tx.GasLimit, FailureInsufficientBalance, nil
+	}
+	if outputs != nil {
+		for _, out := range outputs {
+			bc.creditTransfer(tx.From, out.To, out.Amount, header.Height)
+		}
+	} else {
+		bc.creditTransfer(tx.From, tx.To, value, header.Height)
+		if staking == nil && tokenOp == nil && governance == nil && bridgeMint == nil && rotation == nil && len(tx.Data) > 0 {
+			bc.emitMemoLog(tx)
+		}
+	}
+
+	if staking != nil {
+		bc.applyStaking(staking, timestamp)
+	}
+	if tokenOp != nil {
+		bc.applyToken(tokenOp)
+	}
+	if governance != nil {
+		bc.applyGovernance(governance, header.Height)
+	}
+	if bridgeMint != nil {
+		bc.applyBridgeMint(bridgeMint)
+	}
+	if rotation != nil {
+		bc.applyKeyRotation(rotation, header.Height)
+	}
+	return tx.GasLimit, "", nil
+}
+
+// verifyExecution checks the header against execution results
+func (bc *Blockchain) verifyExecution(block *Block, receipts []*Receipt) error {
+	var gasUsed uint64
+	if len(receipts) > 0 {
+		gasUsed = receipts[len(receipts)-1].CumulativeGasUsed
+	}
+	if gasUsed != block.Header.GasUsed {
+		return fmt.Errorf("gas used mismatch: header %d, executed %d", block.Header.GasUsed, gasUsed)
+	}
+	if gasUsed > block.Header.GasLimit {
+		return errors.New("block exceeds gas limit")
+	}
+	if err := block.VerifyRoots(receipts); err != nil {
+		return err
+	}
+	if bc.stateDB.Root() != block.Header.StateRoot {
+		return errors.New("state root mismatch")
+	}
+	return nil
+}
+
+// Helper functions
+func txHash(tx *Transaction) [32]byte {
+	if tx.Hash != ([32]byte{}) {
+		return tx.Hash
+	}
+	return tx.ComputeHash()
+}
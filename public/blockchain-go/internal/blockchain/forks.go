@@ -0,0 +1,489 @@
+// Package blockchain - Fork choice, side branches and chain reorganization
+package blockchain
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"chaincore/internal/storage"
+)
+
+// maxReorgDepth bounds how far back the chain can reorganize when no block
+// has been finalized recently, and so how long undo records are kept
+const maxReorgDepth = 1024
+
+// Fork storage keys. Side blocks are keyed by height and hash, undo records
+// by the height of the block they undo.
+var (
+	sideBlockKeyPrefix = []byte("side:")
+	undoKeyPrefix      = []byte("undo:")
+)
+
+// Fork errors
+var (
+	ErrKnownBlock    = errors.New("block already known")
+	ErrUnknownParent = errors.New("unknown parent block")
+	ErrBelowFinality = errors.New("block conflicts with the finalized chain")
+)
+
+// SetFinalizedHeight records the height consensus has finalized. Blocks at
+// or below it are never reverted, and side blocks and undo records there
+// are deleted.
+func (bc *Blockchain) SetFinalizedHeight(height uint64) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if height <= bc.finalized {
+		return nil
+	}
+	bc.finalized = height
+
+	batch := bc.db.NewBatch()
+	for _, prefix := range [][]byte{sideBlockKeyPrefix, undoKeyPrefix} {
+		if err := deleteThroughHeight(bc.db, batch, prefix, height); err != nil {
+			return err
+		}
+	}
+	return batch.Write()
+}
+
+// SetVoteWeigher installs the fork choice weight of a block: the stake of
+// the validators whose verified votes it carries. Without one, only branch
+// length counts.
+func (bc *Blockchain) SetVoteWeigher(fn func(block *Block) *big.Int) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	bc.voteWeigher = fn
+}
+
+// GetParent returns the parent of a block from the canonical chain or a
+// side branch
+func (bc *Blockchain) GetParent(block *Block) (*Block, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	if block.Header.Height == 0 {
+		return nil, errors.New("genesis block has no parent")
+	}
+	return bc.loadBlock(block.Header.Height-1, block.Header.PrevHash)
+}
+
+// Helper functions
+
+// importBlock inserts a block that extends the head, and otherwise stores it
+// on a side branch above the reorg floor, reorganizing if the branch
+// becomes the heaviest. Callers must hold bc.mu.
+func (bc *Blockchain) importBlock(block *Block) error {
+	head := bc.currentBlock
+	if block.Header.Height == head.Header.Height+1 && block.Header.PrevHash == head.Hash() {
+		return bc.insertBlock(block)
+	}
+
+	if _, err := bc.loadBlock(block.Header.Height, block.Hash()); err == nil {
+		return ErrKnownBlock
+	}
+	floor := bc.reorgFloor()
+	if block.Header.Height <= floor {
+		return fmt.Errorf("%w: block %d is at or below block %d", ErrBelowFinality, block.Header.Height, floor)
+	}
+
+	branch, err := bc.sideBranch(block, floor)
+	if err != nil {
+		return err
+	}
+	if err := bc.storeSideBlock(block, block.MiningShares); err != nil {
+		return err
+	}
+
+	fork := branch[0].Header.Height - 1
+	old := make([]*Block, 0, head.Header.Height-fork)
+	for h := fork + 1; h <= head.Header.Height; h++ {
+		b, err := bc.loadBlockByHeight(h)
+		if err != nil {
+			return err
+		}
+		old = append(old, b)
+	}
+	if !bc.outweighs(branch, old) {
+		return nil
+	}
+	return bc.reorganize(fork, old, branch)
+}
+
+// sideBranch walks back from a side block to the canonical chain and returns
+// the branch in height order, ending with the block. Callers must hold bc.mu.
+func (bc *Blockchain) sideBranch(block *Block, floor uint64) ([]*Block, error) {
+	branch := []*Block{block}
+	for {
+		first := branch[0]
+		parentHeight := first.Header.Height - 1
+		if canonical, err := bc.loadBlockByHeight(parentHeight); err == nil && canonical.Hash() == first.Header.PrevHash {
+			return branch, nil
+		}
+		if parentHeight <= floor {
+			return nil, fmt.Errorf("%w: branch of block %d forks at or below block %d", ErrBelowFinality, block.Header.Height, floor)
+		}
+		parent, err := bc.loadSideBlock(parentHeight, first.Header.PrevHash)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %x at height %d", ErrUnknownParent, first.Header.PrevHash, parentHeight)
+		}
+		branch = append([]*Block{parent}, branch...)
+	}
+}
+
+// reorganize replaces the canonical blocks above fork with branch. If a
+// branch block fails to execute, the branch from that block on is dropped
+// and the old blocks are restored. Callers must hold bc.mu.
+func (bc *Blockchain) reorganize(fork uint64, old, branch []*Block) error {
+	if err := bc.revertTo(fork); err != nil {
+		return err
+	}
+	for i, block := range branch {
+		if err := bc.insertBlock(block); err != nil {
+			if dropErr := bc.deleteSideBlocks(branch[i:]); dropErr != nil {
+				return dropErr
+			}
+			if restoreErr := bc.switchTo(fork, old); restoreErr != nil {
+				return fmt.Errorf("restoring chain after failed reorg: %w", restoreErr)
+			}
+			return fmt.Errorf("reorg to block %d: %w", branch[len(branch)-1].Header.Height, err)
+		}
+	}
+	if err := bc.deleteSideBlocks(branch); err != nil {
+		return err
+	}
+	bc.reinjectTransactions(old, branch)
+	return nil
+}
+
+// switchTo reverts to fork and reinserts blocks, which revertTo moved to the
+// side store, on top of it. Callers must hold bc.mu.
+func (bc *Blockchain) switchTo(fork uint64, blocks []*Block) error {
+	if err := bc.revertTo(fork); err != nil {
+		return err
+	}
+	for _, block := range blocks {
+		// The stored copy carries the block's shares
+		stored, err := bc.loadSideBlock(block.Header.Height, block.Hash())
+		if err != nil {
+			return err
+		}
+		if err := bc.insertBlock(stored); err != nil {
+			return err
+		}
+	}
+	return bc.deleteSideBlocks(blocks)
+}
+
+// revertTo unwinds canonical blocks until height is the head, then reloads
+// state from storage. Each block is unwound in its own batch, so a crash
+// leaves a consistent, shorter chain. Callers must hold bc.mu.
+func (bc *Blockchain) revertTo(height uint64) error {
+	var err error
+	for err == nil && bc.currentBlock.Header.Height > height {
+		block := bc.currentBlock
+		if err = bc.revertBlock(block); err != nil {
+			break
+		}
+		var parent *Block
+		if parent, err = bc.loadBlockByHeight(block.Header.Height - 1); err == nil {
+			bc.currentBlock = parent
+		}
+	}
+
+	stateDB, loadErr := NewStateDB(bc.db)
+	if loadErr != nil {
+		return loadErr
+	}
+	bc.stateDB = stateDB
+	return err
+}
+
+// revertBlock restores the accounts the head block changed and moves the
+// block to the side store, along with its shares. Callers must hold bc.mu.
+func (bc *Blockchain) revertBlock(block *Block) error {
+	height := block.Header.Height
+	data, err := bc.db.Get(undoKey(height))
+	if err != nil {
+		return fmt.Errorf("no undo record for block %d", height)
+	}
+	var undo blockUndo
+	if err := json.Unmarshal(data, &undo); err != nil {
+		return err
+	}
+	shares, err := bc.GetMiningShares(height)
+	if err != nil && !errors.Is(err, ErrSharesPruned) {
+		return err
+	}
+
+	batch := bc.db.NewBatch()
+	for addrHex, record := range undo {
+		raw, err := hex.DecodeString(addrHex)
+		if err != nil || len(raw) != 20 {
+			return errors.New("corrupt undo record")
+		}
+		var addr [20]byte
+		copy(addr[:], raw)
+
+		if record == nil {
+			err = batch.Delete(accountKey(addr))
+		} else {
+			var encoded []byte
+			if encoded, err = json.Marshal(record); err == nil {
+				err = batch.Put(accountKey(addr), encoded)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if bc.config.Archive {
+			if err := batch.Delete(balanceHistoryKey(addr, height)); err != nil {
+				return err
+			}
+		}
+	}
+	for i := range block.Transactions {
+		if err := batch.Delete(receiptKey(txHash(&block.Transactions[i]))); err != nil {
+			return err
+		}
+	}
+	if err := deleteTxIndex(batch, block); err != nil {
+		return err
+	}
+	for _, key := range [][]byte{blockKey(height), sharesKey(height), undoKey(height)} {
+		if err := batch.Delete(key); err != nil {
+			return err
+		}
+	}
+	if err := putSideBlock(batch, block, shares); err != nil {
+		return err
+	}
+	if err := batch.Put(headBlockKey, uint64ToBytes(height-1)); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+
+	// Stake moved by the reverted block moves back
+	bc.stakingChanges = append(bc.stakingChanges, stakingValidators(block)...)
+	return nil
+}
+
+// reinjectTransactions returns transactions of reverted blocks that the new
+// branch did not include to the pool. Those no longer valid on the new
+// chain, and evidence, which proposers add themselves, are dropped.
+// Callers must hold bc.mu.
+func (bc *Blockchain) reinjectTransactions(reverted, branch []*Block) {
+	included := make(map[[32]byte]bool)
+	for _, block := range branch {
+		for i := range block.Transactions {
+			included[txHash(&block.Transactions[i])] = true
+		}
+	}
+
+	for _, block := range reverted {
+		for i := range block.Transactions {
+			tx := block.Transactions[i]
+			tx.Hash = txHash(&tx)
+			if tx.To == EvidenceAddress || tx.To == SettlementAddress || included[tx.Hash] {
+				continue
+			}
+			if bc.validateTransaction(&tx) != nil {
+				continue
+			}
+			_ = bc.txPool.Add(context.Background(), &tx)
+		}
+	}
+}
+
+// writeUndo adds the undo record of the block being saved at height to
+// batch and drops the record that has fallen below the reorg depth
+func (bc *Blockchain) writeUndo(batch storage.Batch, height uint64) error {
+	undo, err := bc.stateDB.undoRecord()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(undo)
+	if err != nil {
+		return err
+	}
+	if err := batch.Put(undoKey(height), data); err != nil {
+		return err
+	}
+	if height > maxReorgDepth {
+		return batch.Delete(undoKey(height - maxReorgDepth))
+	}
+	return nil
+}
+
+// reorgFloor returns the highest block that cannot be reverted. Callers must
+// hold bc.mu.
+func (bc *Blockchain) reorgFloor() uint64 {
+	floor := bc.finalized
+	if head := bc.currentBlock.Header.Height; head > maxReorgDepth && head-maxReorgDepth > floor {
+		floor = head - maxReorgDepth
+	}
+	return floor
+}
+
+// loadBlock returns the block with hash at height, canonical or side.
+// Callers must hold bc.mu.
+func (bc *Blockchain) loadBlock(height uint64, hash [32]byte) (*Block, error) {
+	if block, err := bc.loadBlockByHeight(height); err == nil && block.Hash() == hash {
+		return block, nil
+	}
+	return bc.loadSideBlock(height, hash)
+}
+
+// sideBlock is the stored form of a side block. Shares are kept with it
+// since the share store is indexed by canonical height.
+type sideBlock struct {
+	Block  *Block
+	Shares []MiningShare
+}
+
+func (bc *Blockchain) loadSideBlock(height uint64, hash [32]byte) (*Block, error) {
+	data, err := bc.db.Get(sideBlockKey(height, hash))
+	if err != nil {
+		return nil, errors.New("block not found")
+	}
+	var stored sideBlock
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	stored.Block.MiningShares = stored.Shares
+	return stored.Block, nil
+}
+
+func (bc *Blockchain) storeSideBlock(block *Block, shares []MiningShare) error {
+	batch := bc.db.NewBatch()
+	if err := putSideBlock(batch, block, shares); err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
+func (bc *Blockchain) deleteSideBlocks(blocks []*Block) error {
+	batch := bc.db.NewBatch()
+	for _, block := range blocks {
+		if err := batch.Delete(sideBlockKey(block.Header.Height, block.Hash())); err != nil {
+			return err
+		}
+	}
+	return batch.Write()
+}
+
+func putSideBlock(batch storage.Batch, block *Block, shares []MiningShare) error {
+	data, err := json.Marshal(sideBlock{Block: block.withoutShares(), Shares: shares})
+	if err != nil {
+		return err
+	}
+	return batch.Put(sideBlockKey(block.Header.Height, block.Hash()), data)
+}
+
+// outweighs reports whether branch carries votes for more stake than old,
+// or as much and more blocks; ties keep old. Callers must hold bc.mu.
+func (bc *Blockchain) outweighs(branch, old []*Block) bool {
+	if c := bc.attestedStake(branch).Cmp(bc.attestedStake(old)); c != 0 {
+		return c > 0
+	}
+	return len(branch) > len(old)
+}
+
+// attestedStake sums the vote weight of blocks. Callers must hold bc.mu.
+func (bc *Blockchain) attestedStake(blocks []*Block) *big.Int {
+	total := big.NewInt(0)
+	if bc.voteWeigher == nil {
+		return total
+	}
+	for _, block := range blocks {
+		total.Add(total, bc.voteWeigher(block))
+	}
+	return total
+}
+
+// stakingValidators returns the validators whose stake a block's staking
+// and evidence transactions changed
+func stakingValidators(block *Block) [][20]byte {
+	validators := make([][20]byte, 0)
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		switch tx.To {
+		case StakingAddress:
+			if op, err := DecodeStakingTx(tx); err == nil {
+				validators = append(validators, op.Validator)
+			}
+		case EvidenceAddress:
+			if ev, err := DecodeEvidenceTx(tx); err == nil {
+				validators = append(validators, ev.Validator)
+			}
+		}
+	}
+	return validators
+}
+
+// deleteThroughHeight adds deletes for every key under prefix whose height,
+// the eight bytes after the prefix, is at most height
+func deleteThroughHeight(db storage.Database, batch storage.Batch, prefix []byte, height uint64) error {
+	it := db.NewIterator(prefix)
+	defer it.Release()
+
+	for it.Next() {
+		key := it.Key()
+		if len(key) < len(prefix)+8 {
+			continue
+		}
+		if binary.BigEndian.Uint64(key[len(prefix):]) > height {
+			break
+		}
+		if err := batch.Delete(append([]byte(nil), key...)); err != nil {
+			return err
+		}
+	}
+	return it.Error()
+}
+
+// blockUndo holds the stored accounts a block changed as they were before
+// it, keyed by hex address. A nil record marks an account the block created.
+type blockUndo map[string]*accountRecord
+
+// undoRecord reads the stored pre-images of the dirty accounts. It must run
+// before the dirty set is committed.
+func (s *StateDB) undoRecord() (blockUndo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	undo := make(blockUndo, len(s.dirty))
+	for addr := range s.dirty {
+		data, err := s.db.Get(accountKey(addr))
+		if errors.Is(err, storage.ErrNotFound) {
+			undo[hex.EncodeToString(addr[:])] = nil
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var record accountRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, err
+		}
+		undo[hex.EncodeToString(addr[:])] = &record
+	}
+	return undo, nil
+}
+
+func sideBlockKey(height uint64, hash [32]byte) []byte {
+	key := append(append([]byte(nil), sideBlockKeyPrefix...), uint64ToBytes(height)...)
+	return append(key, hash[:]...)
+}
+
+func undoKey(height uint64) []byte {
+	return append(append([]byte(nil), undoKeyPrefix...), uint64ToBytes(height)...)
+}
@@ -0,0 +1,81 @@
+package blockchain_test
+
+import (
+	"math/big"
+	"testing"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/storage"
+)
+
+func newEmptyChain(t *testing.T) *blockchain.Blockchain {
+	t.Helper()
+	db, err := storage.NewMemoryLevelDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	chain, err := blockchain.NewBlockchain(db, blockchain.Config{ChainID: 1, MinGasPrice: 1, ValidatorMinStake: big.NewInt(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chain
+}
+
+// extend fills and inserts an empty block on the head carrying votes for it
+// by voters
+func extend(t *testing.T, chain *blockchain.Blockchain, delay uint64, voters ...[20]byte) *blockchain.Block {
+	t.Helper()
+	head := chain.GetCurrentBlock()
+	block := &blockchain.Block{Header: blockchain.BlockHeader{
+		Height:    head.Header.Height + 1,
+		PrevHash:  head.Hash(),
+		Timestamp: head.Header.Timestamp + delay,
+		GasLimit:  head.Header.GasLimit,
+	}}
+	for _, voter := range voters {
+		block.Validators = append(block.Validators, blockchain.ValidatorVote{ValidatorAddr: voter, BlockHash: head.Hash()})
+	}
+	if err := chain.FillBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.InsertBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestForkChoiceWeighsAttestedStake(t *testing.T) {
+	small, large := [20]byte{1}, [20]byte{2}
+	stakes := map[[20]byte]int64{small: 1, large: 5}
+	weigh := func(block *blockchain.Block) *big.Int {
+		stake := big.NewInt(0)
+		for _, vote := range block.Validators {
+			stake.Add(stake, big.NewInt(stakes[vote.ValidatorAddr]))
+		}
+		return stake
+	}
+	chain, rival := newEmptyChain(t), newEmptyChain(t)
+	chain.SetVoteWeigher(weigh)
+
+	// The canonical branch is longer, with votes from the small validator
+	extend(t, chain, 1)
+	extend(t, chain, 1, small)
+	head := extend(t, chain, 1, small)
+
+	// A shorter branch wins once the large validator's votes outweigh them
+	fork := extend(t, rival, 2)
+	attested := extend(t, rival, 2, large)
+	if err := chain.InsertBlock(fork); err != nil {
+		t.Fatal(err)
+	}
+	if chain.GetCurrentBlock().Hash() != head.Hash() {
+		t.Fatal("switched to a branch without votes")
+	}
+	if err := chain.InsertBlock(attested); err != nil {
+		t.Fatal(err)
+	}
+	if chain.GetCurrentBlock().Hash() != attested.Hash() {
+		t.Fatalf("head is block %d, not the branch with more attested stake", chain.GetCurrentBlock().Header.Height)
+	}
+}
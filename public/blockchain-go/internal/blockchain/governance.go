@@ -0,0 +1,626 @@
+// Package blockchain - On-chain governance proposals and stake-weighted voting
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// GovernanceAddress is the system account proposals and votes are sent to.
+// It never holds funds; its storage records proposals, votes and the
+// parameters governance has set.
+var GovernanceAddress = [20]byte{18: 0x01, 19: 0x08}
+
+// governanceMagic prefixes the data field of a governance operation, which is
+//
+//	"GOVN" ‖ 1 ‖ 1 ‖ param ‖ value ‖ activation     parameter change
+//	"GOVN" ‖ 1 ‖ 2 ‖ recipient ‖ amount              treasury spend
+//	"GOVN" ‖ 2 ‖ proposal ‖ choice                   vote
+//
+// with the value and amount 32 bytes and the activation and proposal ID 8
+// bytes big-endian
+var governanceMagic = []byte("GOVN")
+
+// GovernanceOpType identifies a governance operation
+type GovernanceOpType uint8
+
+const (
+	GovernanceOpPropose GovernanceOpType = iota + 1 // Submit a proposal
+	GovernanceOpVote                                // Vote on an open proposal
+)
+
+// ProposalKind identifies what a proposal does once it passes
+type ProposalKind uint8
+
+const (
+	ProposalParamChange   ProposalKind = iota + 1 // Set a chain parameter from an activation height
+	ProposalTreasurySpend                         // Pay an amount out of the treasury
+)
+
+// ProposalStatus is the stage a proposal has reached
+type ProposalStatus uint8
+
+const (
+	ProposalVoting   ProposalStatus = iota + 1 // Open for votes
+	ProposalRejected                           // Missed the quorum or the threshold
+	ProposalPassed                             // Passed; a parameter change waits for its activation height
+	ProposalExecuted                           // Parameter set, or treasury spend paid
+	ProposalFailed                             // Passed, but the treasury could not pay
+)
+
+// VoteChoice is a voter's answer to a proposal
+type VoteChoice uint8
+
+const (
+	VoteYes VoteChoice = iota + 1
+	VoteNo
+	VoteAbstain // Counts towards the quorum only
+)
+
+// GovernanceParam identifies a chain parameter governance can set
+type GovernanceParam uint8
+
+const (
+	ParamBlockTime        GovernanceParam = iota + 1 // Seconds between blocks
+	ParamBlockGasLimit                               // Gas limit of every block
+	ParamSessionRewardCap                            // Mining rewards one miner session may earn
+	ParamDailyAddressCap                             // Mining rewards one address may earn a day
+)
+
+// Governance defaults and gas
+const (
+	DefaultVotingPeriod        = 50400 // Blocks a proposal is open when Config.GovernanceVotingPeriod is zero, a week of 12 second blocks
+	DefaultGovernanceQuorum    = 33    // Percent of bonded stake that must vote
+	DefaultGovernanceThreshold = 50    // Percent of yes and no votes that must be exceeded by yes votes
+	GovernanceProposeGas       = 50000 // Submitting a proposal, on top of the intrinsic gas
+	GovernanceVoteGas          = 30000 // Voting, on top of the intrinsic gas
+	governanceAmountLength     = 32
+)
+
+// Topics of the governance events
+var (
+	TopicProposalSubmitted = EventTopic("ProposalSubmitted(uint256,address)")
+	TopicVoteCast          = EventTopic("VoteCast(uint256,address,uint8,uint256)")
+)
+
+// governanceParams holds each parameter's name and the range proposals may
+// set it in. A nil max leaves the range open.
+var governanceParams = map[GovernanceParam]struct {
+	name     string
+	min, max *big.Int
+}{
+	ParamBlockTime:        {"blockTime", big.NewInt(1), big.NewInt(60)},
+	ParamBlockGasLimit:    {"blockGasLimit", big.NewInt(1000000), big.NewInt(1000000000)},
+	ParamSessionRewardCap: {"sessionRewardCap", big.NewInt(1), nil},
+	ParamDailyAddressCap:  {"dailyAddressCap", big.NewInt(1), nil},
+}
+
+// GovernanceOp is a decoded governance operation
+type GovernanceOp struct {
+	Type       GovernanceOpType
+	Sender     [20]byte
+	Kind       ProposalKind
+	Param      GovernanceParam
+	Value      *big.Int // New parameter value, or amount spent
+	Activation uint64   // Height a parameter change is applied at
+	Recipient  [20]byte // Payee of a treasury spend
+	ProposalID uint64   // Proposal voted on
+	Choice     VoteChoice
+}
+
+// Proposal is a governance proposal and its tally
+type Proposal struct {
+	ID          uint64
+	Kind        ProposalKind
+	Proposer    [20]byte
+	Param       GovernanceParam
+	Value       *big.Int // New parameter value, or amount spent
+	Activation  uint64   // Height a parameter change is applied at
+	Recipient   [20]byte // Payee of a treasury spend
+	StartHeight uint64   // Block that included the proposal
+	EndHeight   uint64   // Last block votes are accepted in
+	BondedStake *big.Int // Stake bonded at submission, the base of the quorum
+	Yes         *big.Int
+	No          *big.Int
+	Abstain     *big.Int
+	Status      ProposalStatus
+}
+
+// Vote is a voter's recorded vote on a proposal
+type Vote struct {
+	Voter  [20]byte
+	Choice VoteChoice
+	Weight *big.Int
+}
+
+// EncodeParamChangeProposal builds the data of a proposal to set param to
+// value from the activation height
+func EncodeParamChangeProposal(param GovernanceParam, value *big.Int, activation uint64) []byte {
+	data := append(append([]byte(nil), governanceMagic...), byte(GovernanceOpPropose), byte(ProposalParamChange), byte(param))
+	data = append(data, bigToBytes32(value)...)
+	return append(data, uint64ToBytes(activation)...)
+}
+
+// EncodeTreasurySpendProposal builds the data of a proposal to pay amount
+// from the treasury to recipient
+func EncodeTreasurySpendProposal(recipient [20]byte, amount *big.Int) []byte {
+	data := append(append([]byte(nil), governanceMagic...), byte(GovernanceOpPropose), byte(ProposalTreasurySpend))
+	data = append(data, recipient[:]...)
+	return append(data, bigToBytes32(amount)...)
+}
+
+// EncodeVote builds the data of a vote
+func EncodeVote(proposal uint64, choice VoteChoice) []byte {
+	data := append(append([]byte(nil), governanceMagic...), byte(GovernanceOpVote))
+	data = append(data, uint64ToBytes(proposal)...)
+	return append(data, byte(choice))
+}
+
+// GovernanceOpGasLimit returns the least gas a governance operation with
+// data needs
+func GovernanceOpGasLimit(data []byte) uint64 {
+	if len(data) > len(governanceMagic) && GovernanceOpType(data[len(governanceMagic)]) == GovernanceOpPropose {
+		return IntrinsicGas(data) + GovernanceProposeGas
+	}
+	return IntrinsicGas(data) + GovernanceVoteGas
+}
+
+// DecodeGovernanceTx decodes a transaction sent to GovernanceAddress
+func DecodeGovernanceTx(tx *Transaction) (*GovernanceOp, error) {
+	if tx.To != GovernanceAddress {
+		return nil, errors.New("not a governance operation")
+	}
+	if !bytes.HasPrefix(tx.Data, governanceMagic) || len(tx.Data) < len(governanceMagic)+1 {
+		return nil, errors.New("invalid governance payload")
+	}
+	if tx.Value != nil && tx.Value.Sign() != 0 {
+		return nil, errors.New("governance operations must not carry value")
+	}
+	if tx.GasLimit < GovernanceOpGasLimit(tx.Data) {
+		return nil, errors.New("gas limit below governance operation gas")
+	}
+
+	op := &GovernanceOp{
+		Type:   GovernanceOpType(tx.Data[len(governanceMagic)]),
+		Sender: tx.From,
+		Value:  big.NewInt(0),
+	}
+	payload := tx.Data[len(governanceMagic)+1:]
+
+	switch op.Type {
+	case GovernanceOpPropose:
+		if len(payload) < 1 {
+			return nil, errors.New("invalid proposal payload")
+		}
+		op.Kind = ProposalKind(payload[0])
+		payload = payload[1:]
+		switch op.Kind {
+		case ProposalParamChange:
+			if len(payload) != 1+governanceAmountLength+8 {
+				return nil, errors.New("invalid parameter change payload")
+			}
+			op.Param = GovernanceParam(payload[0])
+			op.Value.SetBytes(payload[1 : 1+governanceAmountLength])
+			op.Activation = binary.BigEndian.Uint64(payload[1+governanceAmountLength:])
+			spec, known := governanceParams[op.Param]
+			if !known {
+				return nil, errors.New("unknown governance parameter")
+			}
+			if op.Value.Cmp(spec.min) < 0 || (spec.max != nil && op.Value.Cmp(spec.max) > 0) {
+				return nil, fmt.Errorf("%s out of range", spec.name)
+			}
+		case ProposalTreasurySpend:
+			if len(payload) != 20+governanceAmountLength {
+				return nil, errors.New("invalid treasury spend payload")
+			}
+			copy(op.Recipient[:], payload[:20])
+			op.Value.SetBytes(payload[20:])
+			if op.Value.Sign() <= 0 {
+				return nil, errors.New("treasury spend must be positive")
+			}
+		default:
+			return nil, errors.New("unknown proposal kind")
+		}
+	case GovernanceOpVote:
+		if len(payload) != 8+1 {
+			return nil, errors.New("invalid vote payload")
+		}
+		op.ProposalID = binary.BigEndian.Uint64(payload[:8])
+		op.Choice = VoteChoice(payload[8])
+		if op.Choice < VoteYes || op.Choice > VoteAbstain {
+			return nil, errors.New("unknown vote choice")
+		}
+	default:
+		return nil, errors.New("unknown governance operation")
+	}
+	return op, nil
+}
+
+// GetProposal returns a governance proposal
+func (bc *Blockchain) GetProposal(id uint64) (*Proposal, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.proposal(id)
+}
+
+// GetProposals returns up to limit proposals from offset, in submission
+// order, and the number of proposals
+func (bc *Blockchain) GetProposals(offset, limit uint64) ([]*Proposal, uint64) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	count := bc.proposalCount()
+	proposals := make([]*Proposal, 0)
+	for id := offset; id < count && uint64(len(proposals)) < limit; id++ {
+		if p, err := bc.proposal(id); err == nil {
+			proposals = append(proposals, p)
+		}
+	}
+	return proposals, count
+}
+
+// GetVote returns voter's vote on a proposal, or nil if it has not voted
+func (bc *Blockchain) GetVote(id uint64, voter [20]byte) (*Vote, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	if id >= bc.proposalCount() {
+		return nil, errors.New("proposal not found")
+	}
+	choice := bc.governanceWord(governanceSlot("vote-choice", uint64ToBytes(id), voter[:]))
+	if choice.Sign() == 0 {
+		return nil, nil
+	}
+	return &Vote{
+		Voter:  voter,
+		Choice: VoteChoice(choice.Uint64()),
+		Weight: bc.governanceWord(governanceSlot("vote-weight", uint64ToBytes(id), voter[:])),
+	}, nil
+}
+
+// GetVotingWeight returns the stake addr has bonded across all validators,
+// the weight its votes would carry now
+func (bc *Blockchain) GetVotingWeight(addr [20]byte) *big.Int {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.votingWeight(addr)
+}
+
+// GetGovernanceParam returns the value governance has set a parameter to,
+// and false if no change to it has been applied, leaving it to node
+// configuration
+func (bc *Blockchain) GetGovernanceParam(param GovernanceParam) (*big.Int, bool) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.governanceParam(param)
+}
+
+// GovernanceParams returns the parameters governance can set
+func GovernanceParams() []GovernanceParam {
+	return []GovernanceParam{ParamBlockTime, ParamBlockGasLimit, ParamSessionRewardCap, ParamDailyAddressCap}
+}
+
+// String returns the parameter's name
+func (p GovernanceParam) String() string {
+	if spec, known := governanceParams[p]; known {
+		return spec.name
+	}
+	return fmt.Sprintf("param%d", uint8(p))
+}
+
+// String returns the kind's name
+func (k ProposalKind) String() string {
+	switch k {
+	case ProposalParamChange:
+		return "paramChange"
+	case ProposalTreasurySpend:
+		return "treasurySpend"
+	}
+	return fmt.Sprintf("kind%d", uint8(k))
+}
+
+// String returns the status's name
+func (s ProposalStatus) String() string {
+	switch s {
+	case ProposalVoting:
+		return "voting"
+	case ProposalRejected:
+		return "rejected"
+	case ProposalPassed:
+		return "passed"
+	case ProposalExecuted:
+		return "executed"
+	case ProposalFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("status%d", uint8(s))
+}
+
+// String returns the choice's name
+func (c VoteChoice) String() string {
+	switch c {
+	case VoteYes:
+		return "yes"
+	case VoteNo:
+		return "no"
+	case VoteAbstain:
+		return "abstain"
+	}
+	return fmt.Sprintf("choice%d", uint8(c))
+}
+
+// Helper functions
+
+// checkGovernance validates a governance operation for a block at height
+// against current state without changing it. Callers must hold bc.mu.
+func (bc *Blockchain) checkGovernance(op *GovernanceOp, height uint64) error {
+	if bc.votingWeight(op.Sender).Sign() == 0 {
+		return errors.New("sender has no bonded stake")
+	}
+
+	switch op.Type {
+	case GovernanceOpPropose:
+		if op.Kind == ProposalParamChange && op.Activation <= height+bc.votingPeriod() {
+			return errors.New("activation height must be after the voting period")
+		}
+	case GovernanceOpVote:
+		p, err := bc.proposal(op.ProposalID)
+		if err != nil {
+			return err
+		}
+		if p.Status != ProposalVoting || height > p.EndHeight {
+			return errors.New("proposal is closed for voting")
+		}
+	}
+	return nil
+}
+
+// applyGovernance records a checked governance operation in a block at
+// height and logs it. Callers must hold bc.mu.
+func (bc *Blockchain) applyGovernance(op *GovernanceOp, height uint64) {
+	weight := bc.votingWeight(op.Sender)
+
+	switch op.Type {
+	case GovernanceOpPropose:
+		p := &Proposal{
+			ID:          bc.proposalCount(),
+			Kind:        op.Kind,
+			Proposer:    op.Sender,
+			Param:       op.Param,
+			Value:       op.Value,
+			Activation:  op.Activation,
+			Recipient:   op.Recipient,
+			StartHeight: height,
+			EndHeight:   height + bc.votingPeriod(),
+			BondedStake: bc.bondedStake(),
+			Yes:         big.NewInt(0),
+			No:          big.NewInt(0),
+			Abstain:     big.NewInt(0),
+			Status:      ProposalVoting,
+		}
+		bc.setProposal(p)
+		bc.setGovernanceWord(governanceSlot("count"), new(big.Int).SetUint64(p.ID+1))
+		id := uint256Word(new(big.Int).SetUint64(p.ID))
+		bc.emitLog(GovernanceAddress, nil, TopicProposalSubmitted, id, AddressTopic(op.Sender))
+	case GovernanceOpVote:
+		p, _ := bc.proposal(op.ProposalID)
+		choiceSlot := governanceSlot("vote-choice", uint64ToBytes(p.ID), op.Sender[:])
+		weightSlot := governanceSlot("vote-weight", uint64ToBytes(p.ID), op.Sender[:])
+
+		// A repeated vote takes back the earlier one first
+		if previous := bc.governanceWord(choiceSlot); previous.Sign() != 0 {
+			tally := p.tally(VoteChoice(previous.Uint64()))
+			tally.Sub(tally, bc.governanceWord(weightSlot))
+		}
+		tally := p.tally(op.Choice)
+		tally.Add(tally, weight)
+		bc.setGovernanceWord(choiceSlot, big.NewInt(int64(op.Choice)))
+		bc.setGovernanceWord(weightSlot, weight)
+		bc.setProposal(p)
+
+		id := uint256Word(new(big.Int).SetUint64(p.ID))
+		data := make([]byte, 0, 64)
+		choice := uint256Word(big.NewInt(int64(op.Choice)))
+		amount := uint256Word(weight)
+		data = append(append(data, choice[:]...), amount[:]...)
+		bc.emitLog(GovernanceAddress, data, TopicVoteCast, id, AddressTopic(op.Sender))
+	}
+}
+
+// processGovernance tallies the proposals whose voting closes at height and
+// applies the parameter changes activating at it. Callers must hold bc.mu.
+func (bc *Blockchain) processGovernance(height uint64) {
+	cursorSlot := governanceSlot("tally-cursor")
+	cursor := wordToUint64(bc.stateDB.GetState(GovernanceAddress, cursorSlot))
+	count := bc.proposalCount()
+	for ; cursor < count; cursor++ {
+		p, err := bc.proposal(cursor)
+		if err != nil || p.EndHeight > height {
+			break
+		}
+		bc.tallyProposal(p)
+	}
+	bc.setGovernanceWord(cursorSlot, new(big.Int).SetUint64(cursor))
+
+	pending := wordToUint64(bc.stateDB.GetState(GovernanceAddress, governanceSlot("activation-count", uint64ToBytes(height))))
+	for i := uint64(0); i < pending; i++ {
+		id := wordToUint64(bc.stateDB.GetState(GovernanceAddress, governanceSlot("activation", uint64ToBytes(height), uint64ToBytes(i))))
+		p, err := bc.proposal(id)
+		if err != nil {
+			continue
+		}
+		bc.setGovernanceWord(governanceSlot("param", []byte{byte(p.Param)}), p.Value)
+		p.Status = ProposalExecuted
+		bc.setProposal(p)
+	}
+}
+
+// tallyProposal decides a proposal whose voting has closed, paying a
+// passed treasury spend and scheduling a passed parameter change. It passes
+// if the votes reach the quorum of the stake bonded at submission and yes
+// votes exceed the threshold of yes and no votes.
+func (bc *Blockchain) tallyProposal(p *Proposal) {
+	voted := new(big.Int).Add(p.Yes, p.No)
+	voted.Add(voted, p.Abstain)
+	quorum := new(big.Int).Mul(p.BondedStake, big.NewInt(int64(bc.governanceQuorum())))
+	quorum.Div(quorum, big.NewInt(100))
+
+	// yes / (yes + no) > threshold / 100
+	decided := new(big.Int).Add(p.Yes, p.No)
+	support := new(big.Int).Mul(p.Yes, big.NewInt(100))
+	needed := new(big.Int).Mul(decided, big.NewInt(int64(bc.governanceThreshold())))
+
+	switch {
+	case voted.Sign() == 0 || voted.Cmp(quorum) < 0 || support.Cmp(needed) <= 0:
+		p.Status = ProposalRejected
+	case p.Kind == ProposalTreasurySpend:
+		p.Status = ProposalFailed
+		if bc.spendTreasury(p, p.EndHeight) {
+			p.Status = ProposalExecuted
+		}
+	default:
+		countSlot := governanceSlot("activation-count", uint64ToBytes(p.Activation))
+		n := wordToUint64(bc.stateDB.GetState(GovernanceAddress, countSlot))
+		bc.setGovernanceWord(governanceSlot("activation", uint64ToBytes(p.Activation), uint64ToBytes(n)), new(big.Int).SetUint64(p.ID))
+		bc.setGovernanceWord(countSlot, new(big.Int).SetUint64(n+1))
+		p.Status = ProposalPassed
+	}
+	bc.setProposal(p)
+}
+
+// tally returns the running total of votes for a choice
+func (p *Proposal) tally(choice VoteChoice) *big.Int {
+	switch choice {
+	case VoteYes:
+		return p.Yes
+	case VoteNo:
+		return p.No
+	}
+	return p.Abstain
+}
+
+func (bc *Blockchain) proposal(id uint64) (*Proposal, error) {
+	if id >= bc.proposalCount() {
+		return nil, errors.New("proposal not found")
+	}
+	key := uint64ToBytes(id)
+	proposer := bc.stateDB.GetState(GovernanceAddress, governanceSlot("proposer", key))
+	recipient := bc.stateDB.GetState(GovernanceAddress, governanceSlot("recipient", key))
+	word := func(kind string) *big.Int {
+		return bc.governanceWord(governanceSlot(kind, key))
+	}
+
+	p := &Proposal{
+		ID:          id,
+		Kind:        ProposalKind(word("kind").Uint64()),
+		Param:       GovernanceParam(word("param").Uint64()),
+		Value:       word("value"),
+		Activation:  word("activation").Uint64(),
+		StartHeight: word("start").Uint64(),
+		EndHeight:   word("end").Uint64(),
+		BondedStake: word("stake"),
+		Yes:         word("yes"),
+		No:          word("no"),
+		Abstain:     word("abstain"),
+		Status:      ProposalStatus(word("status").Uint64()),
+	}
+	copy(p.Proposer[:], proposer[12:])
+	copy(p.Recipient[:], recipient[12:])
+	return p, nil
+}
+
+// setProposal stores every field of a proposal
+func (bc *Blockchain) setProposal(p *Proposal) {
+	key := uint64ToBytes(p.ID)
+	set := func(kind string, n *big.Int) {
+		bc.setGovernanceWord(governanceSlot(kind, key), n)
+	}
+	bc.stateDB.SetState(GovernanceAddress, governanceSlot("proposer", key), AddressTopic(p.Proposer))
+	bc.stateDB.SetState(GovernanceAddress, governanceSlot("recipient", key), AddressTopic(p.Recipient))
+	set("kind", big.NewInt(int64(p.Kind)))
+	set("param", big.NewInt(int64(p.Param)))
+	set("value", p.Value)
+	set("activation", new(big.Int).SetUint64(p.Activation))
+	set("start", new(big.Int).SetUint64(p.StartHeight))
+	set("end", new(big.Int).SetUint64(p.EndHeight))
+	set("stake", p.BondedStake)
+	set("yes", p.Yes)
+	set("no", p.No)
+	set("abstain", p.Abstain)
+	set("status", big.NewInt(int64(p.Status)))
+}
+
+func (bc *Blockchain) proposalCount() uint64 {
+	return wordToUint64(bc.stateDB.GetState(GovernanceAddress, governanceSlot("count")))
+}
+
+func (bc *Blockchain) governanceParam(param GovernanceParam) (*big.Int, bool) {
+	value := bc.governanceWord(governanceSlot("param", []byte{byte(param)}))
+	return value, value.Sign() != 0
+}
+
+// votingWeight sums the stake addr has bonded with every validator,
+// including its own if it is one
+func (bc *Blockchain) votingWeight(addr [20]byte) *big.Int {
+	weight := big.NewInt(0)
+	count := wordToUint64(bc.stateDB.GetState(StakingAddress, stakingSlot("count")))
+	for i := uint64(0); i < count; i++ {
+		word := bc.stateDB.GetState(StakingAddress, stakingSlot("index", uint64ToBytes(i)))
+		var validator [20]byte
+		copy(validator[:], word[12:])
+		weight.Add(weight, bc.stakeAmount(validator, addr))
+	}
+	return weight
+}
+
+// bondedStake sums the stake bonded with every validator
+func (bc *Blockchain) bondedStake() *big.Int {
+	total := big.NewInt(0)
+	count := wordToUint64(bc.stateDB.GetState(StakingAddress, stakingSlot("count")))
+	for i := uint64(0); i < count; i++ {
+		word := bc.stateDB.GetState(StakingAddress, stakingSlot("index", uint64ToBytes(i)))
+		total.Add(total, bc.stateWord(stakingSlot("total", word[12:])))
+	}
+	return total
+}
+
+func (bc *Blockchain) votingPeriod() uint64 {
+	if bc.config.GovernanceVotingPeriod > 0 {
+		return bc.config.GovernanceVotingPeriod
+	}
+	return DefaultVotingPeriod
+}
+
+func (bc *Blockchain) governanceQuorum() uint8 {
+	if bc.config.GovernanceQuorum > 0 {
+		return min(bc.config.GovernanceQuorum, 100)
+	}
+	return DefaultGovernanceQuorum
+}
+
+func (bc *Blockchain) governanceThreshold() uint8 {
+	if bc.config.GovernanceThreshold > 0 {
+		return min(bc.config.GovernanceThreshold, 99)
+	}
+	return DefaultGovernanceThreshold
+}
+
+func (bc *Blockchain) governanceWord(slot [32]byte) *big.Int {
+	word := bc.stateDB.GetState(GovernanceAddress, slot)
+	return new(big.Int).SetBytes(word[:])
+}
+
+func (bc *Blockchain) setGovernanceWord(slot [32]byte, n *big.Int) {
+	bc.stateDB.SetState(GovernanceAddress, slot, uint256Word(n))
+}
+
+// governanceSlot derives a storage slot of GovernanceAddress
+func governanceSlot(kind string, parts ...[]byte) [32]byte {
+	return stakingSlot("gov:"+kind, parts...)
+}
@@ -0,0 +1,159 @@
+// Package blockchain - Account history for archive nodes
+package blockchain
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"chaincore/internal/storage"
+)
+
+// Account history storage keys. Each block stores the new nonce and balance
+// of every account it changed under the account and height, so the account
+// at a height is the newest entry at or below it.
+var (
+	balanceHistoryPrefix = []byte("hist:bal:")
+	historyStartKey      = []byte("hist:start")
+)
+
+// ErrStatePruned is returned for state queries at heights whose state this
+// node no longer has
+var ErrStatePruned = errors.New("state not available for requested block")
+
+// GetBalanceAt returns the balance of an address after the block at height.
+// The head state is always available; older heights need an archive node
+// that was already keeping history at that height.
+func (bc *Blockchain) GetBalanceAt(addr [20]byte, height uint64) (*big.Int, error) {
+	_, balance, err := bc.accountAt(addr, height)
+	return balance, err
+}
+
+// GetNonceAt returns the account nonce of an address after the block at
+// height, with the same availability as GetBalanceAt
+func (bc *Blockchain) GetNonceAt(addr [20]byte, height uint64) (uint64, error) {
+	nonce, _, err := bc.accountAt(addr, height)
+	return nonce, err
+}
+
+// Helper functions
+
+func (bc *Blockchain) accountAt(addr [20]byte, height uint64) (uint64, *big.Int, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	head := bc.currentBlock.Header.Height
+	if height > head {
+		return 0, nil, fmt.Errorf("block %d not found", height)
+	}
+	if height == head {
+		acc := bc.stateDB.GetAccount(addr)
+		return acc.Nonce, new(big.Int).Set(acc.Balance), nil
+	}
+
+	if !bc.config.Archive {
+		return 0, nil, fmt.Errorf("%w: block %d is pruned, only the head state is kept (run an archive node for history)", ErrStatePruned, height)
+	}
+	start, err := bc.historyStart()
+	if err != nil {
+		return 0, nil, err
+	}
+	if height < start {
+		return 0, nil, fmt.Errorf("%w: block %d is pruned, history starts at block %d", ErrStatePruned, height, start)
+	}
+	return bc.historicalAccount(addr, height)
+}
+
+// initHistory starts balance history at the head the first time a node runs
+// in archive mode, recording every existing account so later lookups need
+// not look further back
+func (bc *Blockchain) initHistory() error {
+	if _, err := bc.db.Get(historyStartKey); err == nil {
+		return nil
+	}
+
+	height := bc.currentBlock.Header.Height
+	batch := bc.db.NewBatch()
+	if err := bc.stateDB.writeBalanceHistory(batch, height, true); err != nil {
+		return err
+	}
+	if err := batch.Put(historyStartKey, uint64ToBytes(height)); err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
+func (bc *Blockchain) historyStart() (uint64, error) {
+	data, err := bc.db.Get(historyStartKey)
+	if err != nil || len(data) != 8 {
+		return 0, fmt.Errorf("%w: no balance history recorded", ErrStatePruned)
+	}
+	return binary.BigEndian.Uint64(data), nil
+}
+
+// historicalAccount finds the newest history entry at or below height.
+// Entries are stored in height order, so the scan stops at the first one
+// above it.
+func (bc *Blockchain) historicalAccount(addr [20]byte, height uint64) (uint64, *big.Int, error) {
+	prefix := balanceHistoryAccountPrefix(addr)
+	it := bc.db.NewIterator(prefix)
+	defer it.Release()
+
+	nonce, balance := uint64(0), big.NewInt(0)
+	for it.Next() {
+		key, value := it.Key(), it.Value()
+		if len(key) != len(prefix)+8 || len(value) < 8 {
+			return 0, nil, errors.New("corrupt account history entry")
+		}
+		if binary.BigEndian.Uint64(key[len(prefix):]) > height {
+			break
+		}
+		nonce = binary.BigEndian.Uint64(value[:8])
+		balance = new(big.Int).SetBytes(value[8:])
+	}
+	if err := it.Error(); err != nil {
+		return 0, nil, err
+	}
+	return nonce, balance, nil
+}
+
+// writeBalanceHistory adds the nonces and balances of dirty accounts, or of
+// all accounts if all is set, to batch as history for height. It must run
+// before the dirty set is committed.
+func (s *StateDB) writeBalanceHistory(batch storage.Batch, height uint64, all bool) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	write := func(addr [20]byte) error {
+		entry := make([]byte, 8)
+		if acc, exists := s.accounts[addr]; exists {
+			binary.BigEndian.PutUint64(entry, acc.Nonce)
+			entry = append(entry, acc.Balance.Bytes()...)
+		}
+		return batch.Put(balanceHistoryKey(addr, height), entry)
+	}
+
+	if all {
+		for addr := range s.accounts {
+			if err := write(addr); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for addr := range s.dirty {
+		if err := write(addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func balanceHistoryAccountPrefix(addr [20]byte) []byte {
+	return append(append([]byte(nil), balanceHistoryPrefix...), addr[:]...)
+}
+
+func balanceHistoryKey(addr [20]byte, height uint64) []byte {
+	return binary.BigEndian.AppendUint64(balanceHistoryAccountPrefix(addr), height)
+}
@@ -0,0 +1,876 @@
+// Package blockchain - EVM bytecode interpreter
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Word bounds: stack values are kept in [0, 2^256)
+var (
+	tt255   = new(big.Int).Lsh(big.NewInt(1), 255)
+	tt256   = new(big.Int).Lsh(big.NewInt(1), 256)
+	tt256m1 = new(big.Int).Sub(tt256, big.NewInt(1))
+)
+
+// maxMemorySize bounds a frame's memory; the gas for anything near it is
+// far above any block gas limit
+const maxMemorySize = 1 << 32
+
+// Opcodes the interpreter dispatches on by name
+const (
+	opSTOP           = 0x00
+	opADD            = 0x01
+	opMUL            = 0x02
+	opSUB            = 0x03
+	opDIV            = 0x04
+	opSDIV           = 0x05
+	opMOD            = 0x06
+	opSMOD           = 0x07
+	opADDMOD         = 0x08
+	opMULMOD         = 0x09
+	opEXP            = 0x0a
+	opSIGNEXTEND     = 0x0b
+	opLT             = 0x10
+	opGT             = 0x11
+	opSLT            = 0x12
+	opSGT            = 0x13
+	opEQ             = 0x14
+	opISZERO         = 0x15
+	opAND            = 0x16
+	opOR             = 0x17
+	opXOR            = 0x18
+	opNOT            = 0x19
+	opBYTE           = 0x1a
+	opSHL            = 0x1b
+	opSHR            = 0x1c
+	opSAR            = 0x1d
+	opKECCAK256      = 0x20
+	opADDRESS        = 0x30
+	opBALANCE        = 0x31
+	opORIGIN         = 0x32
+	opCALLER         = 0x33
+	opCALLVALUE      = 0x34
+	opCALLDATALOAD   = 0x35
+	opCALLDATASIZE   = 0x36
+	opCALLDATACOPY   = 0x37
+	opCODESIZE       = 0x38
+	opCODECOPY       = 0x39
+	opGASPRICE       = 0x3a
+	opEXTCODESIZE    = 0x3b
+	opEXTCODECOPY    = 0x3c
+	opRETURNDATASIZE = 0x3d
+	opRETURNDATACOPY = 0x3e
+	opEXTCODEHASH    = 0x3f
+	opBLOCKHASH      = 0x40
+	opCOINBASE       = 0x41
+	opTIMESTAMP      = 0x42
+	opNUMBER         = 0x43
+	opPREVRANDAO     = 0x44
+	opGASLIMIT       = 0x45
+	opCHAINID        = 0x46
+	opSELFBALANCE    = 0x47
+	opBASEFEE        = 0x48
+	opPOP            = 0x50
+	opMLOAD          = 0x51
+	opMSTORE         = 0x52
+	opMSTORE8        = 0x53
+	opSLOAD          = 0x54
+	opSSTORE         = 0x55
+	opJUMP           = 0x56
+	opJUMPI          = 0x57
+	opPC             = 0x58
+	opMSIZE          = 0x59
+	opGAS            = 0x5a
+	opJUMPDEST       = 0x5b
+	opMCOPY          = 0x5e
+	opPUSH0          = 0x5f
+	opPUSH1          = 0x60
+	opPUSH32         = 0x7f
+	opDUP1           = 0x80
+	opDUP16          = 0x8f
+	opSWAP1          = 0x90
+	opSWAP16         = 0x9f
+	opLOG0           = 0xa0
+	opLOG4           = 0xa4
+	opCREATE         = 0xf0
+	opCALL           = 0xf1
+	opCALLCODE       = 0xf2
+	opRETURN         = 0xf3
+	opDELEGATECALL   = 0xf4
+	opCREATE2        = 0xf5
+	opSTATICCALL     = 0xfa
+	opREVERT         = 0xfd
+	opSELFDESTRUCT   = 0xff
+)
+
+// opSpec is an instruction's constant gas and stack effect. Gas that
+// depends on operands or memory is charged as the instruction runs.
+type opSpec struct {
+	valid  bool
+	gas    uint64
+	pops   int
+	pushes int
+}
+
+// opSpecs holds every instruction the interpreter runs; the rest are
+// invalid
+var opSpecs = func() [256]opSpec {
+	var specs [256]opSpec
+	set := func(gas uint64, pops, pushes int, ops ...byte) {
+		for _, op := range ops {
+			specs[op] = opSpec{valid: true, gas: gas, pops: pops, pushes: pushes}
+		}
+	}
+
+	set(0, 0, 0, opSTOP)
+	set(0, 2, 0, opRETURN, opREVERT)
+	set(1, 0, 0, opJUMPDEST)
+	set(2, 0, 1, opADDRESS, opORIGIN, opCALLER, opCALLVALUE, opCALLDATASIZE, opCODESIZE, opGASPRICE,
+		opRETURNDATASIZE, opCOINBASE, opTIMESTAMP, opNUMBER, opPREVRANDAO, opGASLIMIT, opCHAINID,
+		opBASEFEE, opPC, opMSIZE, opGAS, opPUSH0)
+	set(2, 1, 0, opPOP)
+	set(3, 2, 1, opADD, opSUB, opLT, opGT, opSLT, opSGT, opEQ, opAND, opOR, opXOR, opBYTE, opSHL, opSHR, opSAR)
+	set(3, 1, 1, opNOT, opISZERO, opCALLDATALOAD, opMLOAD)
+	set(3, 2, 0, opMSTORE, opMSTORE8)
+	set(3, 3, 0, opCALLDATACOPY, opCODECOPY, opRETURNDATACOPY, opMCOPY)
+	set(5, 2, 1, opMUL, opDIV, opSDIV, opMOD, opSMOD, opSIGNEXTEND)
+	set(5, 0, 1, opSELFBALANCE)
+	set(8, 3, 1, opADDMOD, opMULMOD)
+	set(8, 1, 0, opJUMP)
+	set(10, 2, 0, opJUMPI)
+	set(10, 2, 1, opEXP)
+	set(30, 2, 1, opKECCAK256)
+	set(blockhashGas, 1, 1, opBLOCKHASH)
+	set(accountAccessGas, 1, 1, opBALANCE, opEXTCODESIZE, opEXTCODEHASH)
+	set(accountAccessGas, 4, 0, opEXTCODECOPY)
+	set(sloadGas, 1, 1, opSLOAD)
+	set(0, 2, 0, opSSTORE)
+	set(CreateGas, 3, 1, opCREATE)
+	set(CreateGas, 4, 1, opCREATE2)
+	set(callGas, 7, 1, opCALL, opCALLCODE)
+	set(callGas, 6, 1, opDELEGATECALL, opSTATICCALL)
+	set(selfdestructGas, 1, 0, opSELFDESTRUCT)
+	for i := 0; i < 32; i++ {
+		set(3, 0, 1, byte(opPUSH1+i))
+	}
+	for i := 0; i < 16; i++ {
+		set(3, i+1, i+2, byte(opDUP1+i))
+		set(3, i+2, i+2, byte(opSWAP1+i))
+	}
+	for i := 0; i <= 4; i++ {
+		set(logGas+uint64(i)*logTopicGas, i+2, 0, byte(opLOG0+i))
+	}
+	return specs
+}()
+
+// frame is the state of one running call
+type frame struct {
+	vm         *vm
+	code       []byte
+	jumpdests  []bool
+	address    [20]byte // Account whose storage and balance the code uses
+	caller     [20]byte
+	value      *big.Int
+	input      []byte
+	gas        uint64
+	static     bool
+	stack      []*big.Int
+	memory     []byte
+	returnData []byte // Output of the last call this frame made
+}
+
+func newFrame(v *vm, code []byte, address, caller [20]byte, value *big.Int, input []byte, gas uint64, static bool) *frame {
+	return &frame{
+		vm:        v,
+		code:      code,
+		jumpdests: analyzeJumpdests(code),
+		address:   address,
+		caller:    caller,
+		value:     value,
+		input:     input,
+		gas:       gas,
+		static:    static,
+		stack:     make([]*big.Int, 0, 16),
+	}
+}
+
+// run executes the frame's code and returns its output. The gas left is
+// in f.gas.
+func (f *frame) run() ([]byte, error) {
+	v, state := f.vm, f.vm.bc.stateDB
+	var pc uint64
+	for {
+		var op byte = opSTOP
+		if pc < uint64(len(f.code)) {
+			op = f.code[pc]
+		}
+		spec := opSpecs[op]
+		if !spec.valid {
+			return nil, fmt.Errorf("%w 0x%02x", ErrInvalidOpcode, op)
+		}
+		if len(f.stack) < spec.pops {
+			return nil, ErrStackUnderflow
+		}
+		if len(f.stack)-spec.pops+spec.pushes > maxStackSize {
+			return nil, ErrStackOverflow
+		}
+		if err := f.useGas(spec.gas); err != nil {
+			return nil, err
+		}
+
+		switch {
+		case op >= opPUSH1 && op <= opPUSH32:
+			n := uint64(op - opPUSH1 + 1)
+			word := make([]byte, n)
+			if pc+1 < uint64(len(f.code)) {
+				copy(word, f.code[pc+1:])
+			}
+			f.push(new(big.Int).SetBytes(word))
+			pc += n + 1
+			continue
+		case op >= opDUP1 && op <= opDUP16:
+			f.push(new(big.Int).Set(f.stack[len(f.stack)-int(op-opDUP1)-1]))
+			pc++
+			continue
+		case op >= opSWAP1 && op <= opSWAP16:
+			top, other := len(f.stack)-1, len(f.stack)-int(op-opSWAP1)-2
+			f.stack[top], f.stack[other] = f.stack[other], f.stack[top]
+			pc++
+			continue
+		case op >= opLOG0 && op <= opLOG4:
+			if f.static {
+				return nil, ErrWriteProtection
+			}
+			offset, size := f.pop(), f.pop()
+			topics := make([][32]byte, op-opLOG0)
+			for i := range topics {
+				topics[i] = toWord(f.pop())
+			}
+			data, err := f.readMemory(offset, size)
+			if err != nil {
+				return nil, err
+			}
+			if err := f.useGas(logDataGas * uint64(len(data))); err != nil {
+				return nil, err
+			}
+			v.bc.emitLog(f.address, data, topics...)
+			pc++
+			continue
+		}
+
+		switch op {
+		case opSTOP:
+			return nil, nil
+
+		// Arithmetic
+		case opADD:
+			a, b := f.pop(), f.pop()
+			f.push(wrap(a.Add(a, b)))
+		case opMUL:
+			a, b := f.pop(), f.pop()
+			f.push(wrap(a.Mul(a, b)))
+		case opSUB:
+			a, b := f.pop(), f.pop()
+			f.push(wrap(a.Sub(a, b)))
+		case opDIV:
+			a, b := f.pop(), f.pop()
+			if b.Sign() == 0 {
+				f.push(b)
+			} else {
+				f.push(a.Div(a, b))
+			}
+		case opSDIV:
+			a, b := toSigned(f.pop()), toSigned(f.pop())
+			if b.Sign() == 0 {
+				f.push(b)
+			} else {
+				f.push(wrap(a.Quo(a, b)))
+			}
+		case opMOD:
+			a, b := f.pop(), f.pop()
+			if b.Sign() == 0 {
+				f.push(b)
+			} else {
+				f.push(a.Mod(a, b))
+			}
+		case opSMOD:
+			a, b := toSigned(f.pop()), toSigned(f.pop())
+			if b.Sign() == 0 {
+				f.push(b)
+			} else {
+				f.push(wrap(a.Rem(a, b)))
+			}
+		case opADDMOD:
+			a, b, n := f.pop(), f.pop(), f.pop()
+			if n.Sign() == 0 {
+				f.push(n)
+			} else {
+				f.push(a.Mod(a.Add(a, b), n))
+			}
+		case opMULMOD:
+			a, b, n := f.pop(), f.pop(), f.pop()
+			if n.Sign() == 0 {
+				f.push(n)
+			} else {
+				f.push(a.Mod(a.Mul(a, b), n))
+			}
+		case opEXP:
+			base, exponent := f.pop(), f.pop()
+			if err := f.useGas(expByteGas * uint64((exponent.BitLen()+7)/8)); err != nil {
+				return nil, err
+			}
+			f.push(base.Exp(base, exponent, tt256))
+		case opSIGNEXTEND:
+			back, x := f.pop(), f.pop()
+			if back.Cmp(big.NewInt(31)) < 0 {
+				bit := uint(back.Uint64()*8 + 7)
+				mask := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), bit+1), big.NewInt(1))
+				if x.Bit(int(bit)) == 1 {
+					x.Or(x, new(big.Int).Xor(tt256m1, mask))
+				} else {
+					x.And(x, mask)
+				}
+			}
+			f.push(x)
+
+		// Comparison and bitwise logic
+		case opLT:
+			a, b := f.pop(), f.pop()
+			f.push(boolWord(a.Cmp(b) < 0))
+		case opGT:
+			a, b := f.pop(), f.pop()
+			f.push(boolWord(a.Cmp(b) > 0))
+		case opSLT:
+			a, b := toSigned(f.pop()), toSigned(f.pop())
+			f.push(boolWord(a.Cmp(b) < 0))
+		case opSGT:
+			a, b := toSigned(f.pop()), toSigned(f.pop())
+			f.push(boolWord(a.Cmp(b) > 0))
+		case opEQ:
+			a, b := f.pop(), f.pop()
+			f.push(boolWord(a.Cmp(b) == 0))
+		case opISZERO:
+			f.push(boolWord(f.pop().Sign() == 0))
+		case opAND:
+			a, b := f.pop(), f.pop()
+			f.push(a.And(a, b))
+		case opOR:
+			a, b := f.pop(), f.pop()
+			f.push(a.Or(a, b))
+		case opXOR:
+			a, b := f.pop(), f.pop()
+			f.push(a.Xor(a, b))
+		case opNOT:
+			a := f.pop()
+			f.push(a.Xor(a, tt256m1))
+		case opBYTE:
+			i, x := f.pop(), f.pop()
+			if i.Cmp(big.NewInt(32)) >= 0 {
+				f.push(new(big.Int))
+			} else {
+				word := toWord(x)
+				f.push(new(big.Int).SetUint64(uint64(word[i.Uint64()])))
+			}
+		case opSHL:
+			shift, x := f.pop(), f.pop()
+			if shift.Cmp(big.NewInt(256)) >= 0 {
+				f.push(new(big.Int))
+			} else {
+				f.push(wrap(x.Lsh(x, uint(shift.Uint64()))))
+			}
+		case opSHR:
+			shift, x := f.pop(), f.pop()
+			if shift.Cmp(big.NewInt(256)) >= 0 {
+				f.push(new(big.Int))
+			} else {
+				f.push(x.Rsh(x, uint(shift.Uint64())))
+			}
+		case opSAR:
+			shift, x := f.pop(), toSigned(f.pop())
+			if shift.Cmp(big.NewInt(256)) >= 0 {
+				shift.SetUint64(256)
+			}
+			f.push(wrap(x.Rsh(x, uint(shift.Uint64()))))
+
+		case opKECCAK256:
+			offset, size := f.pop(), f.pop()
+			data, err := f.readMemory(offset, size)
+			if err != nil {
+				return nil, err
+			}
+			if err := f.useGas(keccakWordGas * words(len(data))); err != nil {
+				return nil, err
+			}
+			hash := keccak256Hash(data)
+			f.push(new(big.Int).SetBytes(hash[:]))
+
+		// Environment
+		case opADDRESS:
+			f.push(addressWord(f.address))
+		case opBALANCE:
+			f.push(new(big.Int).Set(state.GetAccount(wordAddress(f.pop())).Balance))
+		case opORIGIN:
+			f.push(addressWord(v.origin))
+		case opCALLER:
+			f.push(addressWord(f.caller))
+		case opCALLVALUE:
+			f.push(new(big.Int).Set(f.value))
+		case opCALLDATALOAD:
+			f.push(new(big.Int).SetBytes(sliceData(f.input, f.pop(), big.NewInt(32))))
+		case opCALLDATASIZE:
+			f.push(new(big.Int).SetUint64(uint64(len(f.input))))
+		case opCALLDATACOPY:
+			if err := f.copyToMemory(f.input, f.pop(), f.pop(), f.pop()); err != nil {
+				return nil, err
+			}
+		case opCODESIZE:
+			f.push(new(big.Int).SetUint64(uint64(len(f.code))))
+		case opCODECOPY:
+			if err := f.copyToMemory(f.code, f.pop(), f.pop(), f.pop()); err != nil {
+				return nil, err
+			}
+		case opGASPRICE:
+			f.push(new(big.Int).SetUint64(v.gasPrice))
+		case opEXTCODESIZE:
+			f.push(new(big.Int).SetUint64(uint64(len(state.GetCode(wordAddress(f.pop()))))))
+		case opEXTCODECOPY:
+			code := state.GetCode(wordAddress(f.pop()))
+			if err := f.copyToMemory(code, f.pop(), f.pop(), f.pop()); err != nil {
+				return nil, err
+			}
+		case opRETURNDATASIZE:
+			f.push(new(big.Int).SetUint64(uint64(len(f.returnData))))
+		case opRETURNDATACOPY:
+			memOffset, dataOffset, size := f.pop(), f.pop(), f.pop()
+			end := new(big.Int).Add(dataOffset, size)
+			if !end.IsUint64() || end.Uint64() > uint64(len(f.returnData)) {
+				return nil, ErrReturnDataOutOfBounds
+			}
+			if err := f.copyToMemory(f.returnData, memOffset, dataOffset, size); err != nil {
+				return nil, err
+			}
+		case opEXTCODEHASH:
+			acc := state.GetAccount(wordAddress(f.pop()))
+			switch {
+			case acc.Nonce == 0 && acc.Balance.Sign() == 0 && len(acc.Code) == 0:
+				f.push(new(big.Int))
+			case len(acc.Code) == 0:
+				hash := keccak256Hash(nil)
+				f.push(new(big.Int).SetBytes(hash[:]))
+			default:
+				f.push(new(big.Int).SetBytes(acc.CodeHash[:]))
+			}
+
+		// Block
+		case opBLOCKHASH:
+			height := f.pop()
+			var hash [32]byte
+			if height.IsUint64() {
+				hash = v.blockHash(height.Uint64())
+			}
+			f.push(new(big.Int).SetBytes(hash[:]))
+		case opCOINBASE:
+			f.push(addressWord(v.header.ProposerAddr))
+		case opTIMESTAMP:
+			f.push(new(big.Int).SetUint64(v.header.Timestamp))
+		case opNUMBER:
+			f.push(new(big.Int).SetUint64(v.header.Height))
+		case opPREVRANDAO:
+			f.push(new(big.Int).SetBytes(v.header.PrevHash[:]))
+		case opGASLIMIT:
+			f.push(new(big.Int).SetUint64(v.header.GasLimit))
+		case opCHAINID:
+			f.push(new(big.Int).SetUint64(v.bc.config.ChainID))
+		case opSELFBALANCE:
+			f.push(new(big.Int).Set(state.GetAccount(f.address).Balance))
+		case opBASEFEE:
+			f.push(new(big.Int).SetUint64(v.bc.config.MinGasPrice))
+
+		// Stack, memory, storage and flow
+		case opPOP:
+			f.pop()
+		case opMLOAD:
+			data, err := f.readMemory(f.pop(), big.NewInt(32))
+			if err != nil {
+				return nil, err
+			}
+			f.push(new(big.Int).SetBytes(data))
+		case opMSTORE:
+			offset, value := f.pop(), f.pop()
+			start, err := f.expandMemory(offset, big.NewInt(32))
+			if err != nil {
+				return nil, err
+			}
+			word := toWord(value)
+			copy(f.memory[start:], word[:])
+		case opMSTORE8:
+			offset, value := f.pop(), f.pop()
+			start, err := f.expandMemory(offset, big.NewInt(1))
+			if err != nil {
+				return nil, err
+			}
+			f.memory[start] = toWord(value)[31]
+		case opSLOAD:
+			value := state.GetState(f.address, toWord(f.pop()))
+			f.push(new(big.Int).SetBytes(value[:]))
+		case opSSTORE:
+			if f.static {
+				return nil, ErrWriteProtection
+			}
+			if f.gas <= sstoreSentryGas {
+				return nil, ErrOutOfGas
+			}
+			key, value := toWord(f.pop()), toWord(f.pop())
+			cost := uint64(sstoreResetGas)
+			if state.GetState(f.address, key) == ([32]byte{}) && value != ([32]byte{}) {
+				cost = sstoreSetGas
+			}
+			if err := f.useGas(cost); err != nil {
+				return nil, err
+			}
+			state.SetState(f.address, key, value)
+		case opJUMP:
+			dest := f.pop()
+			if !f.validJump(dest) {
+				return nil, ErrInvalidJump
+			}
+			pc = dest.Uint64()
+			continue
+		case opJUMPI:
+			dest, cond := f.pop(), f.pop()
+			if cond.Sign() != 0 {
+				if !f.validJump(dest) {
+					return nil, ErrInvalidJump
+				}
+				pc = dest.Uint64()
+				continue
+			}
+		case opPC:
+			f.push(new(big.Int).SetUint64(pc))
+		case opMSIZE:
+			f.push(new(big.Int).SetUint64(uint64(len(f.memory))))
+		case opGAS:
+			f.push(new(big.Int).SetUint64(f.gas))
+		case opJUMPDEST:
+		case opMCOPY:
+			dst, src, size := f.pop(), f.pop(), f.pop()
+			data, err := f.readMemory(src, size)
+			if err != nil {
+				return nil, err
+			}
+			if err := f.useGas(copyWordGas * words(len(data))); err != nil {
+				return nil, err
+			}
+			start, err := f.expandMemory(dst, size)
+			if err != nil {
+				return nil, err
+			}
+			copy(f.memory[start:], data)
+		case opPUSH0:
+			f.push(new(big.Int))
+
+		// Calls and creation
+		case opCREATE, opCREATE2:
+			if err := f.opCreate(op); err != nil {
+				return nil, err
+			}
+		case opCALL, opCALLCODE, opDELEGATECALL, opSTATICCALL:
+			if err := f.opCall(op); err != nil {
+				return nil, err
+			}
+		case opRETURN:
+			return f.readMemory(f.pop(), f.pop())
+		case opREVERT:
+			data, err := f.readMemory(f.pop(), f.pop())
+			if err != nil {
+				return nil, err
+			}
+			return data, &RevertError{Data: data}
+		case opSELFDESTRUCT:
+			if f.static {
+				return nil, ErrWriteProtection
+			}
+			beneficiary := wordAddress(f.pop())
+			balance := new(big.Int).Set(state.GetAccount(f.address).Balance)
+			if balance.Sign() > 0 && beneficiary != f.address {
+				if isSystemAddress(beneficiary) {
+					return nil, ErrSystemAddress
+				}
+				if accountEmpty(state, beneficiary) {
+					if err := f.useGas(callNewAccountGas); err != nil {
+						return nil, err
+					}
+				}
+				state.SubBalance(f.address, balance)
+				state.AddBalance(beneficiary, balance)
+			}
+			return nil, nil
+		}
+		pc++
+	}
+}
+
+// opCreate runs CREATE or CREATE2: stack value, offset, size and, for
+// CREATE2, salt
+func (f *frame) opCreate(op byte) error {
+	if f.static {
+		return ErrWriteProtection
+	}
+	v, state := f.vm, f.vm.bc.stateDB
+	value, offset, size := f.pop(), f.pop(), f.pop()
+	var salt [32]byte
+	if op == opCREATE2 {
+		salt = toWord(f.pop())
+	}
+	if size.Cmp(big.NewInt(maxInitCodeSize)) > 0 {
+		return ErrOutOfGas
+	}
+	initCode, err := f.readMemory(offset, size)
+	if err != nil {
+		return err
+	}
+	perWord := uint64(initCodeWordGas)
+	if op == opCREATE2 {
+		perWord += create2HashWordGas
+	}
+	if err := f.useGas(perWord * words(len(initCode))); err != nil {
+		return err
+	}
+
+	f.returnData = nil
+	if v.depth >= maxCallDepth || state.GetAccount(f.address).Balance.Cmp(value) < 0 {
+		f.push(new(big.Int))
+		return nil
+	}
+	nonce := state.GetNonce(f.address)
+	state.IncrementNonce(f.address)
+	addr := ContractAddress(f.address, nonce)
+	if op == opCREATE2 {
+		addr = ContractAddress2(f.address, salt, initCode)
+	}
+
+	gas := f.gas - f.gas/64
+	f.gas -= gas
+	ret, left, err := v.create(f.address, addr, initCode, gas, value, true)
+	f.gas += left
+	if err != nil {
+		if errors.Is(err, ErrExecutionReverted) {
+			f.returnData = ret
+		}
+		f.push(new(big.Int))
+		return nil
+	}
+	f.push(addressWord(addr))
+	return nil
+}
+
+// opCall runs CALL, CALLCODE, DELEGATECALL or STATICCALL: stack gas,
+// address, value for CALL and CALLCODE, then the input and output memory
+// ranges
+func (f *frame) opCall(op byte) error {
+	v, state := f.vm, f.vm.bc.stateDB
+	requested, to := f.pop(), wordAddress(f.pop())
+	value := new(big.Int)
+	if op == opCALL || op == opCALLCODE {
+		value = f.pop()
+	}
+	inOffset, inSize, outOffset, outSize := f.pop(), f.pop(), f.pop(), f.pop()
+	if op == opCALL && f.static && value.Sign() != 0 {
+		return ErrWriteProtection
+	}
+
+	input, err := f.readMemory(inOffset, inSize)
+	if err != nil {
+		return err
+	}
+	outStart, err := f.expandMemory(outOffset, outSize)
+	if err != nil {
+		return err
+	}
+	if value.Sign() != 0 {
+		extra := uint64(callValueGas)
+		if op == opCALL && accountEmpty(state, to) {
+			extra += callNewAccountGas
+		}
+		if err := f.useGas(extra); err != nil {
+			return err
+		}
+	}
+
+	// All but one 64th of the remaining gas may be passed on
+	gas := f.gas - f.gas/64
+	if requested.IsUint64() && requested.Uint64() < gas {
+		gas = requested.Uint64()
+	}
+	f.gas -= gas
+	if value.Sign() != 0 {
+		gas += callStipend
+	}
+
+	var ret []byte
+	var left uint64
+	switch op {
+	case opCALL:
+		ret, left, err = v.call(callPlain, f.address, to, to, input, gas, value, f.static, true)
+	case opCALLCODE:
+		ret, left, err = v.call(callCode, f.address, f.address, to, input, gas, value, f.static, true)
+	case opDELEGATECALL:
+		ret, left, err = v.call(callDelegate, f.caller, f.address, to, input, gas, f.value, f.static, false)
+	case opSTATICCALL:
+		ret, left, err = v.call(callStatic, f.address, to, to, input, gas, value, true, false)
+	}
+	f.gas += left
+	f.returnData = ret
+	if outSize.Sign() > 0 {
+		copy(f.memory[outStart:outStart+outSize.Uint64()], ret)
+	}
+	f.push(boolWord(err == nil))
+	return nil
+}
+
+// Helper functions
+
+func (f *frame) push(x *big.Int) {
+	f.stack = append(f.stack, x)
+}
+
+func (f *frame) pop() *big.Int {
+	x := f.stack[len(f.stack)-1]
+	f.stack = f.stack[:len(f.stack)-1]
+	return x
+}
+
+func (f *frame) useGas(gas uint64) error {
+	if f.gas < gas {
+		f.gas = 0
+		return ErrOutOfGas
+	}
+	f.gas -= gas
+	return nil
+}
+
+// expandMemory grows memory to cover size bytes at offset, charging for
+// the new words, and returns the offset. An empty range changes nothing.
+func (f *frame) expandMemory(offset, size *big.Int) (uint64, error) {
+	if size.Sign() == 0 {
+		return 0, nil
+	}
+	end := new(big.Int).Add(offset, size)
+	if !end.IsUint64() || end.Uint64() > maxMemorySize {
+		return 0, ErrOutOfGas
+	}
+	words := (end.Uint64() + 31) / 32
+	if current := uint64(len(f.memory)) / 32; words > current {
+		if err := f.useGas(memoryCost(words) - memoryCost(current)); err != nil {
+			return 0, err
+		}
+		f.memory = append(f.memory, make([]byte, (words-current)*32)...)
+	}
+	return offset.Uint64(), nil
+}
+
+// readMemory returns a copy of size bytes at offset
+func (f *frame) readMemory(offset, size *big.Int) ([]byte, error) {
+	start, err := f.expandMemory(offset, size)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), f.memory[start:start+size.Uint64()]...), nil
+}
+
+// copyToMemory copies size bytes of src at srcOffset to memory at
+// memOffset, as the copy instructions do. Bytes past the end of src copy as
+// zeros.
+func (f *frame) copyToMemory(src []byte, memOffset, srcOffset, size *big.Int) error {
+	start, err := f.expandMemory(memOffset, size)
+	if err != nil {
+		return err
+	}
+	if err := f.useGas(copyWordGas * words(int(size.Uint64()))); err != nil {
+		return err
+	}
+	if size.Sign() > 0 {
+		copy(f.memory[start:start+size.Uint64()], sliceData(src, srcOffset, size))
+	}
+	return nil
+}
+
+func (f *frame) validJump(dest *big.Int) bool {
+	return dest.IsUint64() && dest.Uint64() < uint64(len(f.jumpdests)) && f.jumpdests[dest.Uint64()]
+}
+
+// analyzeJumpdests marks the JUMPDEST instructions of code, skipping push
+// data
+func analyzeJumpdests(code []byte) []bool {
+	dests := make([]bool, len(code))
+	for pc := 0; pc < len(code); pc++ {
+		op := code[pc]
+		if op == opJUMPDEST {
+			dests[pc] = true
+		} else if op >= opPUSH1 && op <= opPUSH32 {
+			pc += int(op - opPUSH1 + 1)
+		}
+	}
+	return dests
+}
+
+func memoryCost(words uint64) uint64 {
+	return words*memoryGas + words*words/quadCoeffDiv
+}
+
+// words returns the number of 32-byte words size bytes take
+func words(size int) uint64 {
+	return (uint64(size) + 31) / 32
+}
+
+// sliceData returns size bytes of data at offset, zero-padded
+func sliceData(data []byte, offset, size *big.Int) []byte {
+	out := make([]byte, size.Uint64())
+	if offset.IsUint64() && offset.Uint64() < uint64(len(data)) {
+		copy(out, data[offset.Uint64():])
+	}
+	return out
+}
+
+// accountEmpty reports whether an account has no nonce, balance or code
+func accountEmpty(state *StateDB, addr [20]byte) bool {
+	acc := state.GetAccount(addr)
+	return acc.Nonce == 0 && acc.Balance.Sign() == 0 && len(acc.Code) == 0
+}
+
+func wrap(x *big.Int) *big.Int {
+	return x.And(x, tt256m1)
+}
+
+func toSigned(x *big.Int) *big.Int {
+	if x.Cmp(tt255) >= 0 {
+		x.Sub(x, tt256)
+	}
+	return x
+}
+
+func boolWord(b bool) *big.Int {
+	if b {
+		return big.NewInt(1)
+	}
+	return new(big.Int)
+}
+
+func toWord(x *big.Int) [32]byte {
+	var word [32]byte
+	x.FillBytes(word[:])
+	return word
+}
+
+func addressWord(addr [20]byte) *big.Int {
+	return new(big.Int).SetBytes(addr[:])
+}
+
+func wordAddress(x *big.Int) [20]byte {
+	word := toWord(x)
+	var addr [20]byte
+	copy(addr[:], word[12:])
+	return addr
+}
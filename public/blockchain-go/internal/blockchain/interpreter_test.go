@@ -0,0 +1,341 @@
+package blockchain_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"chaincore/internal/blockchain"
+)
+
+// Opcodes the tests assemble
+const (
+	opADD            byte = 0x01
+	opMUL            byte = 0x02
+	opSUB            byte = 0x03
+	opDIV            byte = 0x04
+	opSDIV           byte = 0x05
+	opMOD            byte = 0x06
+	opSMOD           byte = 0x07
+	opADDMOD         byte = 0x08
+	opMULMOD         byte = 0x09
+	opEXP            byte = 0x0a
+	opSIGNEXTEND     byte = 0x0b
+	opLT             byte = 0x10
+	opSLT            byte = 0x12
+	opSGT            byte = 0x13
+	opBYTE           byte = 0x1a
+	opSHL            byte = 0x1b
+	opSHR            byte = 0x1c
+	opSAR            byte = 0x1d
+	opADDRESS        byte = 0x30
+	opCALLER         byte = 0x33
+	opCALLVALUE      byte = 0x34
+	opRETURNDATASIZE byte = 0x3d
+	opPOP            byte = 0x50
+	opMLOAD          byte = 0x51
+	opMSTORE         byte = 0x52
+	opSLOAD          byte = 0x54
+	opSSTORE         byte = 0x55
+	opJUMP           byte = 0x56
+	opGAS            byte = 0x5a
+	opJUMPDEST       byte = 0x5b
+	opSWAP1          byte = 0x90
+	opCALL           byte = 0xf1
+	opRETURN         byte = 0xf3
+	opDELEGATECALL   byte = 0xf4
+	opCREATE2        byte = 0xf5
+	opSTATICCALL     byte = 0xfa
+	opREVERT         byte = 0xfd
+	opINVALID        byte = 0xfe
+)
+
+// asm assembles opcodes, and pushes of ints, words and addresses with the
+// shortest PUSH
+func asm(parts ...interface{}) []byte {
+	var code []byte
+	for _, part := range parts {
+		switch p := part.(type) {
+		case byte:
+			code = append(code, p)
+		case int:
+			code = append(code, push(big.NewInt(int64(p)))...)
+		case *big.Int:
+			code = append(code, push(p)...)
+		case [20]byte:
+			code = append(append(code, 0x73), p[:]...)
+		case []byte:
+			code = append(code, p...)
+		default:
+			panic("cannot assemble " + fmt.Sprint(part))
+		}
+	}
+	return code
+}
+
+func push(x *big.Int) []byte {
+	if x.Sign() == 0 {
+		return []byte{0x5f}
+	}
+	b := x.Bytes()
+	return append([]byte{byte(0x5f + len(b))}, b...)
+}
+
+// returnTop returns the word on top of the stack
+var returnTop = asm(0, opMSTORE, 32, 0, opRETURN)
+
+// deployCode wraps runtime code in init code that returns it
+func deployCode(runtime []byte) []byte {
+	n := len(runtime)
+	return append([]byte{0x61, byte(n >> 8), byte(n), 0x60, 12, 0x5f, 0x39, 0x61, byte(n >> 8), byte(n), 0x5f, 0xf3}, runtime...)
+}
+
+var (
+	maxWord = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	minWord = new(big.Int).Lsh(big.NewInt(1), 255) // Most negative signed word
+)
+
+// word returns x as a 256-bit two's complement word
+func word(x int64) *big.Int {
+	return new(big.Int).And(big.NewInt(x), maxWord)
+}
+
+// run executes code as the init code of a contract created by from, with
+// value, and returns what it returns
+func run(chain *blockchain.Blockchain, from [20]byte, value int64, gas uint64, code []byte) ([]byte, error) {
+	return chain.Call(&blockchain.Transaction{From: from, Create: true, Value: big.NewInt(value), GasLimit: gas, Data: code})
+}
+
+// send signs tx, includes it in a new block and returns its receipt
+func send(t *testing.T, chain *blockchain.Blockchain, key testKey, tx *blockchain.Transaction) *blockchain.Receipt {
+	t.Helper()
+	tx.ChainID, tx.Nonce, tx.GasPrice = 1, chain.GetNonce(key.addr), 1
+	if tx.Value == nil {
+		tx.Value = big.NewInt(0)
+	}
+	sign(t, tx, key)
+	if err := chain.AddTransaction(context.Background(), tx); err != nil {
+		t.Fatal(err)
+	}
+	extend(t, chain, 12)
+	receipt, err := chain.GetReceipt(tx.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return receipt
+}
+
+// deploy deploys runtime code and returns its address
+func deploy(t *testing.T, chain *blockchain.Blockchain, key testKey, runtime []byte) [20]byte {
+	t.Helper()
+	receipt := send(t, chain, key, &blockchain.Transaction{Create: true, GasLimit: 500000, Data: deployCode(runtime)})
+	if receipt.Status != 1 {
+		t.Fatalf("deployment failed: %s", receipt.FailureReason)
+	}
+	return receipt.ContractAddress
+}
+
+func TestInterpreterArithmetic(t *testing.T) {
+	chain, keys := newTestChain(t, 1)
+	binary := func(op byte, a, b interface{}) []byte { return asm(b, a, op) }
+	ternary := func(op byte, a, b, n interface{}) []byte { return asm(n, b, a, op) }
+	mod := func(x, n *big.Int) *big.Int { return new(big.Int).Mod(x, n) }
+
+	for _, tc := range []struct {
+		name string
+		code []byte
+		want *big.Int
+	}{
+		{"ADD wraps", binary(opADD, maxWord, 1), big.NewInt(0)},
+		{"SUB wraps", binary(opSUB, 0, 1), maxWord},
+		{"MUL wraps", binary(opMUL, minWord, 2), big.NewInt(0)},
+		{"DIV", binary(opDIV, 7, 2), big.NewInt(3)},
+		{"DIV by zero", binary(opDIV, 7, 0), big.NewInt(0)},
+		{"SDIV truncates", binary(opSDIV, word(-7), 2), word(-3)},
+		{"SDIV of the most negative by -1", binary(opSDIV, minWord, word(-1)), minWord},
+		{"SDIV by zero", binary(opSDIV, word(-7), 0), big.NewInt(0)},
+		{"MOD by zero", binary(opMOD, 7, 0), big.NewInt(0)},
+		{"SMOD takes the dividend's sign", binary(opSMOD, word(-7), 2), word(-1)},
+		{"SMOD of a negative divisor", binary(opSMOD, 7, word(-2)), big.NewInt(1)},
+		{"ADDMOD does not wrap", ternary(opADDMOD, maxWord, 2, 3), mod(new(big.Int).Add(maxWord, big.NewInt(2)), big.NewInt(3))},
+		{"ADDMOD by zero", ternary(opADDMOD, 1, 2, 0), big.NewInt(0)},
+		{"MULMOD does not wrap", ternary(opMULMOD, maxWord, maxWord, 12), mod(new(big.Int).Mul(maxWord, maxWord), big.NewInt(12))},
+		{"EXP", binary(opEXP, 3, 5), big.NewInt(243)},
+		{"EXP wraps", binary(opEXP, 2, 256), big.NewInt(0)},
+		{"SIGNEXTEND a negative byte", binary(opSIGNEXTEND, 0, 0xff), maxWord},
+		{"SIGNEXTEND a positive byte", binary(opSIGNEXTEND, 0, 0x17f), big.NewInt(0x7f)},
+		{"LT is unsigned", binary(opLT, word(-1), 1), big.NewInt(0)},
+		{"SLT is signed", binary(opSLT, word(-1), 1), big.NewInt(1)},
+		{"SGT is signed", binary(opSGT, 1, word(-1)), big.NewInt(1)},
+		{"BYTE", binary(opBYTE, 31, 0x1234), big.NewInt(0x34)},
+		{"BYTE past the word", binary(opBYTE, 32, maxWord), big.NewInt(0)},
+		{"SHL drops high bits", binary(opSHL, 4, maxWord), new(big.Int).Sub(maxWord, big.NewInt(15))},
+		{"SHR", binary(opSHR, 4, 0xff), big.NewInt(0xf)},
+		{"SHR past the width", binary(opSHR, 256, maxWord), big.NewInt(0)},
+		{"SAR keeps the sign", binary(opSAR, 2, word(-16)), word(-4)},
+		{"SAR past the width", binary(opSAR, 300, word(-16)), maxWord},
+	} {
+		out, err := run(chain, keys[0].addr, 0, 0, asm(tc.code, returnTop))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got := new(big.Int).SetBytes(out); got.Cmp(tc.want) != 0 {
+			t.Fatalf("%s: %x, want %x", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestInterpreterMemoryExpansionGas(t *testing.T) {
+	chain, keys := newTestChain(t, 1)
+	memoryCost := func(words uint64) uint64 { return 3*words + words*words/512 }
+
+	for _, offset := range []int{1, 992, 32000} {
+		// PUSH0, PUSHn, MSTORE and the second GAS cost 2+3+3+2 besides memory
+		code := asm(opGAS, 0, offset, opMSTORE, opGAS, opSWAP1, opSUB, returnTop)
+		out, err := run(chain, keys[0].addr, 0, 0, code)
+		if err != nil {
+			t.Fatalf("store at %d: %v", offset, err)
+		}
+		want := 10 + memoryCost(uint64(offset+32+31)/32)
+		if got := new(big.Int).SetBytes(out).Uint64(); got != want {
+			t.Fatalf("store at %d used %d gas, want %d", offset, got, want)
+		}
+	}
+
+	// Memory already paid for is free
+	code := asm(0, 32000, opMSTORE, opGAS, 0, 64, opMSTORE, opGAS, opSWAP1, opSUB, returnTop)
+	if out, err := run(chain, keys[0].addr, 0, 0, code); err != nil || new(big.Int).SetBytes(out).Uint64() != 10 {
+		t.Fatalf("store in expanded memory used %x gas: %v", out, err)
+	}
+
+	if _, err := run(chain, keys[0].addr, 0, 0, asm(0, 1<<40, opMSTORE)); !errors.Is(err, blockchain.ErrOutOfGas) {
+		t.Fatalf("terabyte of memory: %v", err)
+	}
+}
+
+func TestInterpreterCalls(t *testing.T) {
+	chain, keys := newTestChain(t, 1)
+	from := keys[0].addr
+
+	// The reporter returns its caller, its address and the value it got;
+	// the writer stores 1 in slot 0
+	reporter := deploy(t, chain, keys[0], asm(opCALLER, 0, opMSTORE, opADDRESS, 32, opMSTORE, opCALLVALUE, 64, opMSTORE, 96, 0, opRETURN))
+	writer := deploy(t, chain, keys[0], asm(1, 0, opSSTORE))
+	self := blockchain.ContractAddress(from, chain.GetNonce(from))
+
+	// Each call's output is followed by whether it succeeded
+	report := func(call ...interface{}) []byte {
+		return asm(96, 0, 0, 0, asm(call...), 96, opMSTORE, 128, 0, opRETURN)
+	}
+	address := func(a [20]byte) *big.Int { return new(big.Int).SetBytes(a[:]) }
+	for _, tc := range []struct {
+		name string
+		code []byte
+		want []*big.Int
+	}{
+		{"CALL", report(7, reporter, opGAS, opCALL), []*big.Int{address(self), address(reporter), big.NewInt(7), big.NewInt(1)}},
+		{"DELEGATECALL", report(reporter, opGAS, opDELEGATECALL), []*big.Int{address(from), address(self), big.NewInt(1000), big.NewInt(1)}},
+		{"STATICCALL", report(reporter, opGAS, opSTATICCALL), []*big.Int{address(self), address(reporter), big.NewInt(0), big.NewInt(1)}},
+		{"CALL to a writer", asm(0, 0, 0, 0, 0, writer, opGAS, opCALL, returnTop), []*big.Int{big.NewInt(1)}},
+		{"STATICCALL to a writer", asm(0, 0, 0, 0, writer, opGAS, opSTATICCALL, returnTop), []*big.Int{big.NewInt(0)}},
+		{"DELEGATECALL writes the caller's storage", asm(0, 0, 0, 0, writer, opGAS, opDELEGATECALL, opPOP, 0, opSLOAD, returnTop), []*big.Int{big.NewInt(1)}},
+	} {
+		out, err := run(chain, from, 1000, 0, tc.code)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if len(out) != 32*len(tc.want) {
+			t.Fatalf("%s returned %x", tc.name, out)
+		}
+		for i, want := range tc.want {
+			if got := new(big.Int).SetBytes(out[32*i : 32*i+32]); got.Cmp(want) != 0 {
+				t.Fatalf("%s word %d: %x, want %x", tc.name, i, got, want)
+			}
+		}
+	}
+}
+
+func TestInterpreterCreate2(t *testing.T) {
+	chain, keys := newTestChain(t, 1)
+	from := keys[0].addr
+	self := blockchain.ContractAddress(from, chain.GetNonce(from))
+
+	// The child reports its caller and address. Its init code fits in the
+	// word at memory 0; the second CREATE2 collides with the first.
+	child := deployCode(asm(opCALLER, 0, opMSTORE, opADDRESS, 32, opMSTORE, 64, 0, opRETURN))
+	initWord := new(big.Int).SetBytes(append(append([]byte(nil), child...), make([]byte, 32-len(child))...))
+	salt := [32]byte{31: 9}
+	code := asm(initWord, 0, opMSTORE,
+		new(big.Int).SetBytes(salt[:]), len(child), 0, 0, opCREATE2, 32, opMSTORE,
+		new(big.Int).SetBytes(salt[:]), len(child), 0, 0, opCREATE2, 64, opMSTORE,
+		64, 96, 0, 0, 32, opMLOAD, opGAS, opSTATICCALL, opPOP,
+		160, 0, opRETURN)
+	out, err := run(chain, from, 0, 0, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := blockchain.ContractAddress2(self, salt, child)
+	var got [4][20]byte
+	for i := range got {
+		copy(got[i][:], out[32+32*i+12:64+32*i])
+	}
+	if got[0] != created {
+		t.Fatalf("CREATE2 deployed at %x, want %x", got[0], created)
+	}
+	if got[1] != ([20]byte{}) {
+		t.Fatalf("second CREATE2 with the same salt deployed at %x", got[1])
+	}
+	if got[2] != self || got[3] != created {
+		t.Fatalf("child reported caller %x and address %x", got[2], got[3])
+	}
+}
+
+func TestInterpreterFailures(t *testing.T) {
+	chain, keys := newTestChain(t, 1)
+	from := keys[0].addr
+
+	var revert *blockchain.RevertError
+	_, err := run(chain, from, 0, 0, asm(42, 0, opMSTORE, 32, 0, opREVERT))
+	if !errors.As(err, &revert) || new(big.Int).SetBytes(revert.Data).Int64() != 42 || !errors.Is(err, blockchain.ErrExecutionReverted) {
+		t.Fatalf("revert: %v", err)
+	}
+	for _, tc := range []struct {
+		name string
+		code []byte
+		want error
+	}{
+		{"endless loop", asm(opJUMPDEST, 0, opJUMP), blockchain.ErrOutOfGas},
+		{"invalid opcode", asm(opINVALID), blockchain.ErrInvalidOpcode},
+		{"jump into push data", asm(4, opJUMP, byte(0x60), opJUMPDEST), blockchain.ErrInvalidJump},
+		{"stack underflow", asm(1, opADD), blockchain.ErrStackUnderflow},
+	} {
+		if _, err := run(chain, from, 0, 100000, tc.code); !errors.Is(err, tc.want) {
+			t.Fatalf("%s: %v, want %v", tc.name, err, tc.want)
+		}
+	}
+
+	// A reverting callee fails the call but not the caller, which sees the
+	// revert data
+	reverter := deploy(t, chain, keys[0], asm(1, 0, opSSTORE, 7, 0, opREVERT))
+	if out, err := run(chain, from, 0, 0, asm(0, 0, 0, 0, 0, reverter, opGAS, opCALL, 0, opMSTORE, opRETURNDATASIZE, 32, opMSTORE, 64, 0, opRETURN)); err != nil ||
+		new(big.Int).SetBytes(out[:32]).Sign() != 0 || new(big.Int).SetBytes(out[32:]).Int64() != 7 {
+		t.Fatalf("call to a reverting contract returned %x: %v", out, err)
+	}
+
+	// In a block, a reverted transaction keeps none of its writes, and
+	// failed transactions are charged their gas limit
+	receipt := send(t, chain, keys[0], &blockchain.Transaction{To: reverter, GasLimit: 100000})
+	if receipt.FailureReason != blockchain.FailureReverted || receipt.GasUsed != 100000 {
+		t.Fatalf("reverted transaction: %+v", receipt)
+	}
+	if slot, _ := chain.GetStorageAt(reverter, [32]byte{}, chain.GetCurrentBlock().Header.Height); slot != ([32]byte{}) {
+		t.Fatalf("reverted write kept: %x", slot)
+	}
+	spinner := deploy(t, chain, keys[0], asm(opJUMPDEST, 0, opJUMP))
+	receipt = send(t, chain, keys[0], &blockchain.Transaction{To: spinner, GasLimit: 100000})
+	if receipt.FailureReason != blockchain.FailureOutOfGas || receipt.GasUsed != 100000 {
+		t.Fatalf("transaction out of gas: %+v", receipt)
+	}
+}
@@ -0,0 +1,65 @@
+// Package blockchain - Block reward schedule and halving
+package blockchain
+
+import "math/big"
+
+// blockRewardsIssuedSlot holds the block rewards credited so far. It lives
+// under SettlementAddress with the other newly issued rewards.
+var blockRewardsIssuedSlot = stakingSlot("rewards:issued")
+
+// BlockReward returns the reward credited to the proposer of the block at
+// height, on top of its fees. It starts at Config.BlockReward and halves
+// every Config.HalvingInterval blocks.
+func (bc *Blockchain) BlockReward(height uint64) *big.Int {
+	reward := bc.config.BlockReward
+	if height == 0 || reward == nil || reward.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+	return new(big.Int).Rsh(reward, uint(bc.Halvings(height)))
+}
+
+// Halvings returns how many times the reward has halved by the block at
+// height
+func (bc *Blockchain) Halvings(height uint64) uint64 {
+	interval := bc.config.HalvingInterval
+	if height == 0 || interval == 0 {
+		return 0
+	}
+	return (height - 1) / interval
+}
+
+// NextHalving returns the first height after height at which the reward
+// halves, or 0 if it never will
+func (bc *Blockchain) NextHalving(height uint64) uint64 {
+	interval := bc.config.HalvingInterval
+	if interval == 0 || bc.BlockReward(height).Sign() == 0 {
+		return 0
+	}
+	return (bc.Halvings(height)+1)*interval + 1
+}
+
+// GetBlockRewardsIssued returns the block rewards credited up to the head
+func (bc *Blockchain) GetBlockRewardsIssued() *big.Int {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	word := bc.stateDB.GetState(SettlementAddress, blockRewardsIssuedSlot)
+	return new(big.Int).SetBytes(word[:])
+}
+
+// Helper functions
+
+// creditBlockReward pays the block reward to the block's proposer.
+// Callers must hold bc.mu.
+func (bc *Blockchain) creditBlockReward(header *BlockHeader) {
+	reward := bc.BlockReward(header.Height)
+	if reward.Sign() == 0 {
+		return
+	}
+	bc.stateDB.AddBalance(header.ProposerAddr, reward)
+	bc.addSupply(supplyMintedSlot, reward)
+
+	word := bc.stateDB.GetState(SettlementAddress, blockRewardsIssuedSlot)
+	issued := new(big.Int).SetBytes(word[:])
+	bc.stateDB.SetState(SettlementAddress, blockRewardsIssuedSlot, uint256Word(issued.Add(issued, reward)))
+}
@@ -0,0 +1,212 @@
+// Package blockchain - Validator consensus key rotation
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// KeyRotationAddress is the system address key-rotation transactions are
+// sent to. A rotation schedules a new consensus key for the sending
+// validator; the staking records at StakingAddress hold the validator's
+// active key and the pending one until its epoch starts.
+var KeyRotationAddress = [20]byte{18: 0x01, 19: 0x01}
+
+// keyRotationMagic prefixes the data field of a key rotation, which is
+//
+//	"KROT" ‖ effective epoch ‖ key length ‖ new key ‖ signature
+//
+// with the epoch 8 bytes big-endian, the key an uncompressed P-256 point
+// and the signature an ASN.1 ECDSA signature of Digest by the current key
+var keyRotationMagic = []byte("KROT")
+
+// Validator epoch defaults
+const (
+	DefaultValidatorEpochBlocks = 100 // Blocks per validator epoch when Config.ValidatorEpochBlocks is zero
+	DefaultKeyRotationDelay     = 1   // Epochs before a rotation takes effect when Config.KeyRotationDelay is zero
+)
+
+// ErrInvalidKeyRotation is returned for key rotations that are malformed,
+// not signed by the validator's current key or that reuse another
+// validator's key
+var ErrInvalidKeyRotation = errors.New("invalid key rotation")
+
+// KeyRotation registers a new consensus key for a validator. The request is
+// signed with the validator's current consensus key.
+type KeyRotation struct {
+	Validator      [20]byte // Transaction sender
+	NewKey         []byte   // Uncompressed P-256 consensus key
+	EffectiveEpoch uint64   // First validator epoch signed with the new key
+	Signature      []byte   // ASN.1 ECDSA signature by the current key
+}
+
+// Digest returns the message signed by the current key
+func (kr *KeyRotation) Digest() [32]byte {
+	data := make([]byte, 0, len(keyRotationMagic)+20+len(kr.NewKey)+8)
+	data = append(data, keyRotationMagic...)
+	data = append(data, kr.Validator[:]...)
+	data = append(data, kr.NewKey...)
+	data = binary.BigEndian.AppendUint64(data, kr.EffectiveEpoch)
+	return sha256.Sum256(data)
+}
+
+// EncodeKeyRotation builds the data of a key-rotation transaction
+func EncodeKeyRotation(kr *KeyRotation) []byte {
+	data := make([]byte, 0, len(keyRotationMagic)+8+1+len(kr.NewKey)+len(kr.Signature))
+	data = append(data, keyRotationMagic...)
+	data = binary.BigEndian.AppendUint64(data, kr.EffectiveEpoch)
+	data = append(data, byte(len(kr.NewKey)))
+	data = append(data, kr.NewKey...)
+	return append(data, kr.Signature...)
+}
+
+// DecodeKeyRotationTx decodes a transaction sent to KeyRotationAddress. The
+// validator is the transaction sender.
+func DecodeKeyRotationTx(tx *Transaction) (*KeyRotation, error) {
+	if tx.To != KeyRotationAddress {
+		return nil, errors.New("not a key rotation transaction")
+	}
+	if tx.Value != nil && tx.Value.Sign() != 0 {
+		return nil, errors.New("key rotations must not carry value")
+	}
+	if !bytes.HasPrefix(tx.Data, keyRotationMagic) || len(tx.Data) < len(keyRotationMagic)+9 {
+		return nil, errors.New("invalid key rotation payload")
+	}
+
+	payload := tx.Data[len(keyRotationMagic):]
+	if int(payload[8]) != stakingPubKeyLength || len(payload) <= 9+stakingPubKeyLength {
+		return nil, errors.New("invalid key rotation payload")
+	}
+	newKey := payload[9 : 9+stakingPubKeyLength]
+	if x, _ := elliptic.Unmarshal(elliptic.P256(), newKey); x == nil {
+		return nil, errors.New("invalid consensus key")
+	}
+
+	return &KeyRotation{
+		Validator:      tx.From,
+		NewKey:         append([]byte(nil), newKey...),
+		EffectiveEpoch: binary.BigEndian.Uint64(payload[:8]),
+		Signature:      append([]byte(nil), payload[9+stakingPubKeyLength:]...),
+	}, nil
+}
+
+// ConsensusKey returns the key the validator signs with in a validator
+// epoch: the pending key once its epoch has started, otherwise the active
+// one
+func (v *StakedValidator) ConsensusKey(epoch uint64) []byte {
+	if v.PendingKey != nil && epoch >= v.PendingKeyEpoch {
+		return v.PendingKey
+	}
+	return v.PubKey
+}
+
+// ValidatorEpochBlocks returns the number of blocks in a validator epoch.
+// Each epoch's validator set and consensus keys are fixed when it starts.
+func (bc *Blockchain) ValidatorEpochBlocks() uint64 {
+	if bc.config.ValidatorEpochBlocks > 0 {
+		return bc.config.ValidatorEpochBlocks
+	}
+	return DefaultValidatorEpochBlocks
+}
+
+// Helper functions
+
+// checkKeyRotation validates a key rotation included at height against
+// current state without changing it. Callers must hold bc.mu.
+func (bc *Blockchain) checkKeyRotation(kr *KeyRotation, height uint64) error {
+	v, err := bc.stakedValidator(kr.Validator)
+	if err != nil {
+		return err
+	}
+	if v.Jailed {
+		return errors.New("jailed validators cannot rotate keys")
+	}
+
+	epoch := height / bc.ValidatorEpochBlocks()
+	if earliest := epoch + bc.keyRotationDelay(); kr.EffectiveEpoch < earliest {
+		return fmt.Errorf("key rotation must take effect at epoch %d or later", earliest)
+	}
+
+	// Only the key signing this epoch may authorize its successor
+	x, y := elliptic.Unmarshal(elliptic.P256(), v.ConsensusKey(epoch))
+	if x == nil {
+		return errors.New("validator has no valid consensus key")
+	}
+	digest := kr.Digest()
+	if !ecdsa.VerifyASN1(&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, digest[:], kr.Signature) {
+		return errors.New("invalid key rotation signature")
+	}
+
+	count := wordToUint64(bc.stateDB.GetState(StakingAddress, stakingSlot("count")))
+	for i := uint64(0); i < count; i++ {
+		word := bc.stateDB.GetState(StakingAddress, stakingSlot("index", uint64ToBytes(i)))
+		var addr [20]byte
+		copy(addr[:], word[12:])
+		if addr == kr.Validator {
+			continue
+		}
+		other, err := bc.stakedValidator(addr)
+		if err != nil {
+			continue
+		}
+		if bytes.Equal(other.PubKey, kr.NewKey) || bytes.Equal(other.PendingKey, kr.NewKey) {
+			return errors.New("consensus key already in use")
+		}
+	}
+	return nil
+}
+
+// applyKeyRotation records a checked rotation as the validator's pending
+// key. A pending key whose epoch has started becomes the active key first;
+// one not yet due is replaced. Callers must hold bc.mu.
+func (bc *Blockchain) applyKeyRotation(kr *KeyRotation, height uint64) {
+	addr := kr.Validator
+	epoch := height / bc.ValidatorEpochBlocks()
+	if pending, due := bc.pendingKey(addr); pending != nil && epoch >= due {
+		bc.setKey("keyx", "keyy", addr, pending)
+	}
+	bc.setKey("pendkeyx", "pendkeyy", addr, kr.NewKey)
+	bc.stateDB.SetState(StakingAddress, stakingSlot("pendepoch", addr[:]), uint256Word(new(big.Int).SetUint64(kr.EffectiveEpoch)))
+}
+
+// pendingKey returns a validator's pending consensus key and the epoch it
+// takes effect, or nil if none was registered
+func (bc *Blockchain) pendingKey(addr [20]byte) ([]byte, uint64) {
+	if bc.stateDB.GetState(StakingAddress, stakingSlot("pendkeyx", addr[:])) == ([32]byte{}) {
+		return nil, 0
+	}
+	epoch := wordToUint64(bc.stateDB.GetState(StakingAddress, stakingSlot("pendepoch", addr[:])))
+	return bc.key("pendkeyx", "pendkeyy", addr), epoch
+}
+
+// key loads an uncompressed key stored as its X and Y coordinates
+func (bc *Blockchain) key(xKind, yKind string, addr [20]byte) []byte {
+	x := bc.stateDB.GetState(StakingAddress, stakingSlot(xKind, addr[:]))
+	y := bc.stateDB.GetState(StakingAddress, stakingSlot(yKind, addr[:]))
+	key := make([]byte, 0, stakingPubKeyLength)
+	key = append(key, 0x04)
+	key = append(key, x[:]...)
+	return append(key, y[:]...)
+}
+
+// setKey stores an uncompressed key, 0x04 ‖ X ‖ Y, as its coordinates
+func (bc *Blockchain) setKey(xKind, yKind string, addr [20]byte, key []byte) {
+	var x, y [32]byte
+	copy(x[:], key[1:33])
+	copy(y[:], key[33:65])
+	bc.stateDB.SetState(StakingAddress, stakingSlot(xKind, addr[:]), x)
+	bc.stateDB.SetState(StakingAddress, stakingSlot(yKind, addr[:]), y)
+}
+
+func (bc *Blockchain) keyRotationDelay() uint64 {
+	if bc.config.KeyRotationDelay > 0 {
+		return bc.config.KeyRotationDelay
+	}
+	return DefaultKeyRotationDelay
+}
@@ -0,0 +1,122 @@
+package blockchain_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"testing"
+
+	"chaincore/internal/blockchain"
+)
+
+func newConsensusKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func marshalKey(key *ecdsa.PrivateKey) []byte {
+	return elliptic.Marshal(elliptic.P256(), key.X, key.Y)
+}
+
+// rotationTx builds a signed transaction rotating from's consensus key to
+// newKey at epoch, authorized by current
+func rotationTx(t *testing.T, chain *blockchain.Blockchain, from testKey, current, newKey *ecdsa.PrivateKey, epoch uint64) *blockchain.Transaction {
+	t.Helper()
+	kr := &blockchain.KeyRotation{Validator: from.addr, NewKey: marshalKey(newKey), EffectiveEpoch: epoch}
+	digest := kr.Digest()
+	sig, err := ecdsa.SignASN1(rand.Reader, current, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr.Signature = sig
+	tx := &blockchain.Transaction{
+		ChainID:  1,
+		Nonce:    chain.GetNonce(from.addr),
+		To:       blockchain.KeyRotationAddress,
+		Value:    big.NewInt(0),
+		GasLimit: 100000,
+		GasPrice: 1,
+		Data:     blockchain.EncodeKeyRotation(kr),
+	}
+	sign(t, tx, from)
+	return tx
+}
+
+func TestKeyRotationTakesEffectAtItsEpoch(t *testing.T) {
+	chain, keys := newTestChain(t, 2)
+	validator, other := keys[0], keys[1]
+	first, second, otherKey := newConsensusKey(t), newConsensusKey(t), newConsensusKey(t)
+	for _, stake := range []struct {
+		from testKey
+		key  *ecdsa.PrivateKey
+	}{{validator, first}, {other, otherKey}} {
+		receipt := send(t, chain, stake.from, &blockchain.Transaction{
+			To: blockchain.StakingAddress, Value: big.NewInt(1e18), GasLimit: 100000, Data: blockchain.EncodeStake(marshalKey(stake.key)),
+		})
+		if receipt.Status != 1 {
+			t.Fatalf("stake failed: %s", receipt.FailureReason)
+		}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		current *ecdsa.PrivateKey
+		newKey  *ecdsa.PrivateKey
+		epoch   uint64
+	}{
+		{"signed by the new key", second, second, 1},
+		{"reusing another validator's key", first, otherKey, 1},
+		{"in the current epoch", first, second, 0},
+	} {
+		err := chain.AddTransaction(context.Background(), rotationTx(t, chain, validator, tc.current, tc.newKey, tc.epoch))
+		if !errors.Is(err, blockchain.ErrInvalidKeyRotation) {
+			t.Fatalf("rotation %s: %v", tc.name, err)
+		}
+	}
+
+	// A rotation included in a block stays pending until its epoch
+	tx := rotationTx(t, chain, validator, first, second, 1)
+	if err := chain.AddTransaction(context.Background(), tx); err != nil {
+		t.Fatal(err)
+	}
+	extend(t, chain, 12)
+	if receipt, err := chain.GetReceipt(tx.Hash); err != nil || receipt.Status != 1 {
+		t.Fatalf("rotation receipt %+v, %v", receipt, err)
+	}
+	staked, err := chain.GetStakedValidator(validator.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(staked.ConsensusKey(0), marshalKey(first)) || !bytes.Equal(staked.ConsensusKey(1), marshalKey(second)) {
+		t.Fatal("rotated key is not pending for epoch 1")
+	}
+
+	// In epoch 1 only the new key authorizes the next rotation, which makes
+	// it the active key
+	for chain.GetCurrentBlock().Header.Height+1 < chain.ValidatorEpochBlocks() {
+		extend(t, chain, 12)
+	}
+	third := newConsensusKey(t)
+	if err := chain.AddTransaction(context.Background(), rotationTx(t, chain, validator, first, third, 2)); !errors.Is(err, blockchain.ErrInvalidKeyRotation) {
+		t.Fatalf("rotation signed by the retired key: %v", err)
+	}
+	receipt := send(t, chain, validator, rotationTx(t, chain, validator, second, third, 2))
+	if receipt.Status != 1 {
+		t.Fatalf("rotation failed: %s", receipt.FailureReason)
+	}
+	staked, err = chain.GetStakedValidator(validator.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(staked.PubKey, marshalKey(second)) || !bytes.Equal(staked.ConsensusKey(2), marshalKey(third)) {
+		t.Fatal("due rotation not activated")
+	}
+}
@@ -0,0 +1,157 @@
+// Package blockchain - Event logs of native operations and log blooms
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+)
+
+// NativeTokenAddress is the address token events are logged under. No
+// account lives there; it stands in for the token contract Ethereum tools
+// expect logs to come from.
+var NativeTokenAddress = [20]byte{18: 0x01, 19: 0x06}
+
+// Event topics: the Keccak-256 hash of each event signature, as Solidity
+// computes them, so ABI-aware tools decode the logs
+var (
+	TopicTransfer     = EventTopic("Transfer(address,address,uint256)")
+	TopicMemo         = EventTopic("Memo(address,address,bytes32,bytes)")
+	TopicMint         = EventTopic("Mint(address,uint256)")
+	TopicBurn         = EventTopic("Burn(address,uint256)")
+	TopicStake        = EventTopic("Stake(address,uint256)")
+	TopicUnstake      = EventTopic("Unstake(address,uint256)")
+	TopicDelegate     = EventTopic("Delegate(address,address,uint256)")
+	TopicUndelegate   = EventTopic("Undelegate(address,address,uint256)")
+	TopicSlash        = EventTopic("Slash(address,uint256)")
+	TopicMiningReward = EventTopic("MiningReward(address,uint256,uint256)")
+)
+
+// BloomLength is the size of a log bloom in bytes
+const BloomLength = 256
+
+// EventTopic returns the topic of an event signature
+func EventTopic(signature string) [32]byte {
+	return keccak256Hash([]byte(signature))
+}
+
+// AddressTopic returns an address as an indexed event parameter
+func AddressTopic(addr [20]byte) [32]byte {
+	var topic [32]byte
+	copy(topic[12:], addr[:])
+	return topic
+}
+
+// Bloom is a 2048-bit bloom filter over log addresses and topics, built as
+// Ethereum builds header blooms
+type Bloom [BloomLength]byte
+
+// Add adds an address or topic to the bloom
+func (b *Bloom) Add(data []byte) {
+	for _, bit := range bloomBits(data) {
+		b[BloomLength-1-bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// Test reports whether the bloom may contain an address or topic
+func (b *Bloom) Test(data []byte) bool {
+	for _, bit := range bloomBits(data) {
+		if b[BloomLength-1-bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// MarshalText encodes the bloom as hex
+func (b Bloom) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(b[:])), nil
+}
+
+// UnmarshalText decodes a hex bloom
+func (b *Bloom) UnmarshalText(text []byte) error {
+	if len(text) != 2*BloomLength {
+		return fmt.Errorf("invalid bloom length %d", len(text))
+	}
+	_, err := hex.Decode(b[:], text)
+	return err
+}
+
+// CreateBloom returns the bloom of the receipts' logs
+func CreateBloom(receipts []*Receipt) Bloom {
+	var bloom Bloom
+	for _, r := range receipts {
+		for _, l := range r.Logs {
+			bloom.Add(l.Address[:])
+			for _, topic := range l.Topics {
+				bloom.Add(topic[:])
+			}
+		}
+	}
+	return bloom
+}
+
+// Helper functions
+
+// emitLog records a log of the transaction being executed. Callers must
+// hold bc.mu.
+func (bc *Blockchain) emitLog(address [20]byte, data []byte, topics ...[32]byte) {
+	bc.txLogs = append(bc.txLogs, &Log{Address: address, Topics: topics, Data: data})
+}
+
+// takeLogs returns and clears the logs of the transaction just executed.
+// Callers must hold bc.mu.
+func (bc *Blockchain) takeLogs() []*Log {
+	logs := bc.txLogs
+	bc.txLogs = nil
+	return logs
+}
+
+// emitStakingLog logs a checked staking operation. Callers must hold bc.mu.
+func (bc *Blockchain) emitStakingLog(op *StakingOp) {
+	amount := uint256Word(op.Amount)
+	switch op.Type {
+	case StakingOpStake:
+		bc.emitLog(StakingAddress, amount[:], TopicStake, AddressTopic(op.Validator))
+	case StakingOpUnstake:
+		bc.emitLog(StakingAddress, amount[:], TopicUnstake, AddressTopic(op.Validator))
+	case StakingOpDelegate:
+		bc.emitLog(StakingAddress, amount[:], TopicDelegate, AddressTopic(op.Delegator), AddressTopic(op.Validator))
+	case StakingOpUndelegate:
+		bc.emitLog(StakingAddress, amount[:], TopicUndelegate, AddressTopic(op.Delegator), AddressTopic(op.Validator))
+	}
+}
+
+// emitMemoLog logs the data of a plain transfer. Callers must hold bc.mu.
+func (bc *Blockchain) emitMemoLog(tx *Transaction) {
+	hash := keccak256Hash(tx.Data)
+
+	// ABI encoding of a single bytes value: offset, length, padded data
+	data := make([]byte, 0, 64+(len(tx.Data)+31)/32*32)
+	offset, length := uint256Word(big.NewInt(32)), uint256Word(big.NewInt(int64(len(tx.Data))))
+	data = append(data, offset[:]...)
+	data = append(data, length[:]...)
+	data = append(data, tx.Data...)
+	data = append(data, make([]byte, (32-len(tx.Data)%32)%32)...)
+
+	bc.emitLog(NativeTokenAddress, data, TopicMemo, AddressTopic(tx.From), AddressTopic(tx.To), hash)
+}
+
+// emitAmountLog logs a token event with one indexed account and an amount.
+// Callers must hold bc.mu.
+func (bc *Blockchain) emitAmountLog(topic [32]byte, account [20]byte, amount *big.Int) {
+	word := uint256Word(amount)
+	bc.emitLog(NativeTokenAddress, word[:], topic, AddressTopic(account))
+}
+
+// bloomBits returns the three bloom bits set for data: the low 11 bits of
+// the first three 16-bit words of its Keccak-256 hash
+func bloomBits(data []byte) [3]uint {
+	hash := keccak256Hash(data)
+
+	var bits [3]uint
+	for i := range bits {
+		bits[i] = (uint(hash[2*i])<<8 | uint(hash[2*i+1])) & 2047
+	}
+	return bits
+}
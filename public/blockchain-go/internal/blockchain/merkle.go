@@ -0,0 +1,121 @@
+// Package blockchain - Binary Merkle tree construction and proofs
+package blockchain
+
+import (
+	"crypto/sha256"
+	"errors"
+)
+
+// MerkleProof is an inclusion proof for a single leaf
+type MerkleProof struct {
+	Index    uint64     // Position of the leaf in the tree
+	Siblings [][32]byte // Sibling hashes from leaf level to root
+}
+
+// MerkleTree keeps every level of a binary Merkle tree so proofs for any
+// number of leaves can be built without rehashing
+type MerkleTree struct {
+	levels [][][32]byte // levels[0] are the leaves, the last level is the root
+}
+
+// NewMerkleTree builds a tree over the given leaves. Odd nodes are paired
+// with themselves.
+func NewMerkleTree(leaves [][32]byte) *MerkleTree {
+	level := make([][32]byte, len(leaves))
+	copy(level, leaves)
+
+	tree := &MerkleTree{levels: [][][32]byte{level}}
+	for len(level) > 1 {
+		level = merkleParentLevel(level)
+		tree.levels = append(tree.levels, level)
+	}
+	return tree
+}
+
+// Root returns the tree root. An empty tree has a zero root.
+func (t *MerkleTree) Root() [32]byte {
+	top := t.levels[len(t.levels)-1]
+	if len(top) == 0 {
+		return [32]byte{}
+	}
+	return top[0]
+}
+
+// Len returns the number of leaves
+func (t *MerkleTree) Len() int {
+	return len(t.levels[0])
+}
+
+// Proof builds an inclusion proof for the leaf at index
+func (t *MerkleTree) Proof(index int) (*MerkleProof, error) {
+	if index < 0 || index >= t.Len() {
+		return nil, errors.New("merkle leaf index out of range")
+	}
+
+	proof := &MerkleProof{
+		Index:    uint64(index),
+		Siblings: make([][32]byte, 0, len(t.levels)-1),
+	}
+
+	pos := index
+	for _, level := range t.levels[:len(t.levels)-1] {
+		sibling := pos ^ 1
+		if sibling >= len(level) {
+			sibling = pos
+		}
+		proof.Siblings = append(proof.Siblings, level[sibling])
+		pos /= 2
+	}
+
+	return proof, nil
+}
+
+// MerkleRoot computes the root of a binary Merkle tree over the given leaves
+func MerkleRoot(leaves [][32]byte) [32]byte {
+	return NewMerkleTree(leaves).Root()
+}
+
+// BuildMerkleProof builds an inclusion proof for the leaf at index
+func BuildMerkleProof(leaves [][32]byte, index int) (*MerkleProof, error) {
+	return NewMerkleTree(leaves).Proof(index)
+}
+
+// VerifyMerkleProof checks that leaf is included under root
+func VerifyMerkleProof(root [32]byte, leaf [32]byte, proof *MerkleProof) bool {
+	if proof == nil {
+		return false
+	}
+
+	hash := leaf
+	pos := proof.Index
+	for _, sibling := range proof.Siblings {
+		if pos%2 == 0 {
+			hash = hashMerklePair(hash, sibling)
+		} else {
+			hash = hashMerklePair(sibling, hash)
+		}
+		pos /= 2
+	}
+
+	return pos == 0 && hash == root
+}
+
+// Helper functions
+func merkleParentLevel(level [][32]byte) [][32]byte {
+	parents := make([][32]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		right := level[i]
+		if i+1 < len(level) {
+			right = level[i+1]
+		}
+		parents = append(parents, hashMerklePair(level[i], right))
+	}
+	return parents
+}
+
+func hashMerklePair(left, right [32]byte) [32]byte {
+	data := make([]byte, 64)
+	copy(data[:32], left[:])
+	copy(data[32:], right[:])
+	return sha256.Sum256(data)
+}
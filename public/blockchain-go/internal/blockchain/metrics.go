@@ -0,0 +1,187 @@
+// Package blockchain - Chain metrics history (block time, tx count, fees)
+package blockchain
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"time"
+)
+
+// Metrics bucket resolutions
+const (
+	MetricsHourly = "hour"
+	MetricsDaily  = "day"
+
+	maxMetricsBuckets = 2000 // Upper bound on buckets returned per query
+)
+
+// Metrics keyspace: prefix | resolution | bucket start (unix seconds, big-endian)
+var (
+	metricsHourPrefix = []byte("metrics:h:")
+	metricsDayPrefix  = []byte("metrics:d:")
+)
+
+// MetricsBucket aggregates per-block metrics over an hour or a day
+type MetricsBucket struct {
+	Start       uint64   `json:"start"` // Unix seconds
+	Blocks      uint64   `json:"blocks"`
+	TxCount     uint64   `json:"txCount"`
+	GasUsed     uint64   `json:"gasUsed"`
+	Fees        *big.Int `json:"fees"`
+	Burned      *big.Int `json:"burned"`
+	IntervalSum uint64   `json:"intervalSum"` // Sum of block intervals in seconds
+	MinInterval uint64   `json:"minInterval"`
+	MaxInterval uint64   `json:"maxInterval"`
+	FirstHeight uint64   `json:"firstHeight"`
+	LastHeight  uint64   `json:"lastHeight"`
+}
+
+// AvgBlockTime returns the mean block interval in seconds
+func (b *MetricsBucket) AvgBlockTime() float64 {
+	if b.Blocks == 0 {
+		return 0
+	}
+	return float64(b.IntervalSum) / float64(b.Blocks)
+}
+
+// GetMetrics returns aggregated buckets covering [from, to). Buckets with no
+// blocks are returned with zero values so charts have a continuous axis.
+func (bc *Blockchain) GetMetrics(resolution string, from, to time.Time) ([]*MetricsBucket, error) {
+	prefix, step, err := metricsResolution(resolution)
+	if err != nil {
+		return nil, err
+	}
+	if !to.After(from) {
+		return nil, errors.New("invalid time range")
+	}
+
+	start := bucketStart(uint64(from.Unix()), step)
+	end := uint64(to.Unix())
+	if (end-start)/step > maxMetricsBuckets {
+		return nil, errors.New("time range too large for resolution")
+	}
+
+	buckets := make([]*MetricsBucket, 0)
+	for t := start; t < end; t += step {
+		bucket, err := bc.loadMetricsBucket(prefix, t)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+// recordBlockMetrics adds a block to its hourly and daily buckets. Burns
+// are counted from the transfers the receipts show succeeded.
+func (bc *Blockchain) recordBlockMetrics(block, parent *Block, receipts []*Receipt) error {
+	interval := uint64(0)
+	if parent != nil && block.Header.Timestamp > parent.Header.Timestamp {
+		interval = block.Header.Timestamp - parent.Header.Timestamp
+	}
+
+	fees := big.NewInt(0)
+	burned := big.NewInt(0)
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		// Gas is charged at the limit; refunds are not implemented
+		fee := new(big.Int).Mul(new(big.Int).SetUint64(tx.GasLimit), new(big.Int).SetUint64(tx.GasPrice))
+		fees.Add(fees, fee)
+		if i >= len(receipts) || receipts[i].Status != ReceiptStatusSuccessful {
+			continue
+		}
+		if tx.To != BatchAddress {
+			burned.Add(burned, bc.TransferBurn(tx.To, tx.Value))
+			continue
+		}
+		outputs, err := DecodeBatchTx(tx)
+		if err != nil {
+			continue
+		}
+		for _, out := range outputs {
+			burned.Add(burned, bc.TransferBurn(out.To, out.Amount))
+		}
+	}
+
+	for _, res := range []struct {
+		prefix []byte
+		step   uint64
+	}{
+		{metricsHourPrefix, uint64(time.Hour / time.Second)},
+		{metricsDayPrefix, uint64(24 * time.Hour / time.Second)},
+	} {
+		start := bucketStart(block.Header.Timestamp, res.step)
+		bucket, err := bc.loadMetricsBucket(res.prefix, start)
+		if err != nil {
+			return err
+		}
+
+		if bucket.Blocks == 0 {
+			bucket.FirstHeight = block.Header.Height
+			bucket.MinInterval = interval
+		}
+		bucket.Blocks++
+		bucket.TxCount += uint64(len(block.Transactions))
+		bucket.GasUsed += block.Header.GasUsed
+		bucket.Fees.Add(bucket.Fees, fees)
+		bucket.Burned.Add(bucket.Burned, burned)
+		bucket.IntervalSum += interval
+		if interval < bucket.MinInterval {
+			bucket.MinInterval = interval
+		}
+		if interval > bucket.MaxInterval {
+			bucket.MaxInterval = interval
+		}
+		bucket.LastHeight = block.Header.Height
+
+		data, err := json.Marshal(bucket)
+		if err != nil {
+			return err
+		}
+		if err := bc.db.Put(metricsKey(res.prefix, start), data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Helper functions
+func (bc *Blockchain) loadMetricsBucket(prefix []byte, start uint64) (*MetricsBucket, error) {
+	bucket := &MetricsBucket{
+		Start:  start,
+		Fees:   big.NewInt(0),
+		Burned: big.NewInt(0),
+	}
+
+	data, err := bc.db.Get(metricsKey(prefix, start))
+	if err != nil {
+		// Missing bucket: no blocks in this period
+		return bucket, nil
+	}
+	if err := json.Unmarshal(data, bucket); err != nil {
+		return nil, err
+	}
+	return bucket, nil
+}
+
+func metricsResolution(resolution string) ([]byte, uint64, error) {
+	switch resolution {
+	case MetricsHourly:
+		return metricsHourPrefix, uint64(time.Hour / time.Second), nil
+	case MetricsDaily:
+		return metricsDayPrefix, uint64(24 * time.Hour / time.Second), nil
+	default:
+		return nil, 0, errors.New("resolution must be \"hour\" or \"day\"")
+	}
+}
+
+func bucketStart(timestamp, step uint64) uint64 {
+	return timestamp - timestamp%step
+}
+
+func metricsKey(prefix []byte, start uint64) []byte {
+	return binary.BigEndian.AppendUint64(append([]byte(nil), prefix...), start)
+}
@@ -0,0 +1,89 @@
+// Package blockchain - Precompiled contracts
+package blockchain
+
+import (
+	"crypto/sha256"
+	"math/big"
+
+	"golang.org/x/crypto/ripemd160"
+
+	"chaincore/internal/secp256k1"
+)
+
+// precompile is a contract implemented natively: its gas cost for an input
+// and its output
+type precompile struct {
+	gas func(input []byte) uint64
+	run func(input []byte) []byte
+}
+
+// precompiles are the precompiled contracts by address, at the addresses
+// Ethereum uses
+var precompiles = map[[20]byte]*precompile{
+	{19: 0x01}: {gas: fixedGas(3000), run: ecrecover},
+	{19: 0x02}: {gas: wordGas(60, 12), run: sha256Hash},
+	{19: 0x03}: {gas: wordGas(600, 120), run: ripemd160Hash},
+	{19: 0x04}: {gas: wordGas(15, 3), run: identity},
+}
+
+// Helper functions
+
+// runPrecompile runs a precompiled contract and returns its output and the
+// gas left
+func runPrecompile(p *precompile, input []byte, gas uint64) ([]byte, uint64, error) {
+	cost := p.gas(input)
+	if cost > gas {
+		return nil, 0, ErrOutOfGas
+	}
+	return p.run(input), gas - cost, nil
+}
+
+func fixedGas(gas uint64) func([]byte) uint64 {
+	return func([]byte) uint64 { return gas }
+}
+
+func wordGas(base, perWord uint64) func([]byte) uint64 {
+	return func(input []byte) uint64 {
+		return base + perWord*((uint64(len(input))+31)/32)
+	}
+}
+
+// ecrecover takes a hash, v (27 or 28), r and s as 32-byte words and
+// returns the signer's address as a word, or nothing if the signature is
+// invalid. As for transactions, high-s signatures are invalid.
+func ecrecover(input []byte) []byte {
+	in := make([]byte, 128)
+	copy(in, input)
+	v := new(big.Int).SetBytes(in[32:64])
+	if !v.IsUint64() || (v.Uint64() != 27 && v.Uint64() != 28) {
+		return nil
+	}
+
+	sig := make([]byte, 65)
+	copy(sig, in[64:128])
+	sig[64] = byte(v.Uint64() - 27)
+	addr, err := secp256k1.RecoverAddress(in[:32], sig)
+	if err != nil {
+		return nil
+	}
+	out := make([]byte, 32)
+	copy(out[12:], addr[:])
+	return out
+}
+
+func sha256Hash(input []byte) []byte {
+	hash := sha256.Sum256(input)
+	return hash[:]
+}
+
+func ripemd160Hash(input []byte) []byte {
+	hasher := ripemd160.New()
+	hasher.Write(input)
+	out := make([]byte, 32)
+	copy(out[12:], hasher.Sum(nil))
+	return out
+}
+
+func identity(input []byte) []byte {
+	return append([]byte(nil), input...)
+}
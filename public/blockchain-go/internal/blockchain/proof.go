@@ -0,0 +1,188 @@
+// Package blockchain - Account and storage proofs for light clients
+package blockchain
+
+import (
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// AccountProof proves an account's state against a state root
+type AccountProof struct {
+	Address      [20]byte
+	Nonce        uint64
+	Balance      *big.Int
+	CodeHash     [32]byte
+	StorageRoot  [32]byte
+	StateRoot    [32]byte
+	Height       uint64
+	Proof        *TrieProof // Proves absence if the account does not exist
+	StorageProof []StorageProof
+}
+
+// StorageProof proves a single storage slot against an account's storage root
+type StorageProof struct {
+	Key   [32]byte
+	Value [32]byte
+	Proof *TrieProof // Proves absence if the slot is empty
+}
+
+// Root computes the state root over all accounts
+func (s *StateDB) Root() [32]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.updateTrie()
+	return s.trie.Root()
+}
+
+// GetProof builds an account proof and storage proofs for the given keys
+func (s *StateDB) GetProof(addr [20]byte, keys [][32]byte) (*AccountProof, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.updateTrie()
+	result := &AccountProof{
+		Address:      addr,
+		Balance:      big.NewInt(0),
+		StateRoot:    s.trie.Root(),
+		Proof:        s.trie.Prove(accountTrieKey(addr)),
+		StorageProof: make([]StorageProof, 0, len(keys)),
+	}
+
+	acc, exists := s.accounts[addr]
+	if !exists {
+		// Absent accounts are reported with empty values and an absence proof
+		empty := NewStateTrie()
+		for _, key := range keys {
+			result.StorageProof = append(result.StorageProof, StorageProof{Key: key, Proof: empty.Prove(storageTrieKey(key))})
+		}
+		return result, nil
+	}
+
+	storage := storageTrie(acc.Storage)
+	result.Nonce = acc.Nonce
+	result.Balance = new(big.Int).Set(acc.Balance)
+	result.CodeHash = acc.CodeHash
+	result.StorageRoot = storage.Root()
+
+	for _, key := range keys {
+		result.StorageProof = append(result.StorageProof, StorageProof{
+			Key:   key,
+			Value: acc.Storage[key],
+			Proof: storage.Prove(storageTrieKey(key)),
+		})
+	}
+
+	return result, nil
+}
+
+// VerifyAccountProof verifies an account proof and all its storage proofs
+// against the given state root
+func VerifyAccountProof(stateRoot [32]byte, proof *AccountProof) error {
+	if proof == nil {
+		return errors.New("missing account proof")
+	}
+	if proof.StateRoot != stateRoot {
+		return errors.New("proof state root mismatch")
+	}
+
+	key := accountTrieKey(proof.Address)
+	leaf := accountLeaf(proof.Address, proof.Nonce, proof.Balance, proof.CodeHash, proof.StorageRoot)
+	if err := VerifyTrieProof(stateRoot, key, leaf, proof.Proof); err != nil {
+		// An absent account is reported with empty values
+		empty := proof.Nonce == 0 && proof.Balance.Sign() == 0 &&
+			proof.CodeHash == ([32]byte{}) && proof.StorageRoot == ([32]byte{})
+		if !empty || VerifyTrieProof(stateRoot, key, [32]byte{}, proof.Proof) != nil {
+			return errors.New("invalid account proof")
+		}
+	}
+
+	for _, sp := range proof.StorageProof {
+		var value [32]byte
+		if sp.Value != ([32]byte{}) {
+			value = storageLeaf(sp.Key, sp.Value)
+		}
+		if err := VerifyTrieProof(proof.StorageRoot, storageTrieKey(sp.Key), value, sp.Proof); err != nil {
+			return errors.New("invalid storage proof")
+		}
+	}
+
+	return nil
+}
+
+// GetProof returns an account proof against the current state root
+func (bc *Blockchain) GetProof(addr [20]byte, keys [][32]byte, height uint64) (*AccountProof, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	// Only the head state is kept in memory; historical roots are unavailable
+	if height != bc.currentBlock.Header.Height {
+		return nil, ErrStatePruned
+	}
+
+	proof, err := bc.stateDB.GetProof(addr, keys)
+	if err != nil {
+		return nil, err
+	}
+	proof.Height = height
+	return proof, nil
+}
+
+// Helper functions
+
+// updateTrie applies stale accounts to the account trie. Callers must hold
+// s.mu.
+func (s *StateDB) updateTrie() {
+	for addr := range s.stale {
+		key := accountTrieKey(addr)
+		acc, exists := s.accounts[addr]
+		if !exists {
+			s.trie.Delete(key)
+			continue
+		}
+		storageRoot := storageTrie(acc.Storage).Root()
+		s.trie.Update(key, accountLeaf(addr, acc.Nonce, acc.Balance, acc.CodeHash, storageRoot))
+	}
+	s.stale = make(map[[20]byte]bool)
+}
+
+// storageTrie builds the trie over an account's non-empty storage slots
+func storageTrie(storage map[[32]byte][32]byte) *StateTrie {
+	trie := NewStateTrie()
+	for key, value := range storage {
+		if value != ([32]byte{}) {
+			trie.Update(storageTrieKey(key), storageLeaf(key, value))
+		}
+	}
+	return trie
+}
+
+// accountTrieKey hashes addresses so trie paths are evenly distributed
+func accountTrieKey(addr [20]byte) [32]byte {
+	return sha256.Sum256(addr[:])
+}
+
+func storageTrieKey(key [32]byte) [32]byte {
+	return sha256.Sum256(key[:])
+}
+
+func accountLeaf(addr [20]byte, nonce uint64, balance *big.Int, codeHash, storageRoot [32]byte) [32]byte {
+	data := make([]byte, 0, 20+8+32+32+32)
+	data = append(data, addr[:]...)
+	data = append(data, uint64ToBytes(nonce)...)
+
+	var balanceBytes [32]byte
+	balance.FillBytes(balanceBytes[:])
+	data = append(data, balanceBytes[:]...)
+	data = append(data, codeHash[:]...)
+	data = append(data, storageRoot[:]...)
+	return sha256.Sum256(data)
+}
+
+func storageLeaf(key, value [32]byte) [32]byte {
+	data := make([]byte, 64)
+	copy(data[:32], key[:])
+	copy(data[32:], value[:])
+	return sha256.Sum256(data)
+}
@@ -0,0 +1,141 @@
+// Package blockchain - Transaction execution receipts
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+
+	"chaincore/internal/storage"
+)
+
+// Receipt status codes
+const (
+	ReceiptStatusFailed     uint8 = 0
+	ReceiptStatusSuccessful uint8 = 1
+)
+
+// Failure reason codes recorded in failed receipts
+const (
+	FailureInvalidNonce        = "invalid_nonce"         // Nonce did not match the account at execution
+	FailureInsufficientFunds   = "insufficient_funds"    // Balance could not cover the fee
+	FailureInsufficientBalance = "insufficient_balance"  // Balance could not cover the value after the fee
+	FailureUnvestedFunds       = "unvested_funds"        // Transfer would spend locked vesting funds
+	FailureStakingRejected     = "staking_rejected"      // Staking operation failed validation
+	FailureBatchRejected       = "batch_rejected"        // Batch transfer payload was invalid
+	FailureTokenRejected       = "token_rejected"        // Token operation failed validation
+	FailureGovernanceRejected  = "governance_rejected"   // Proposal or vote failed validation
+	FailureBridgeMintRejected  = "bridge_mint_rejected"  // Bridge mint failed validation
+	FailureKeyRotationRejected = "key_rotation_rejected" // Key rotation failed validation
+)
+
+// receiptKeyPrefix is the storage keyspace for receipts, keyed by tx hash
+var receiptKeyPrefix = []byte("receipt:")
+
+// ErrReceiptNotFound is returned for transactions not yet included in a block
+var ErrReceiptNotFound = errors.New("receipt not found")
+
+// Receipt records the outcome of executing a transaction
+type Receipt struct {
+	TxHash            [32]byte
+	TxIndex           uint64
+	BlockHash         [32]byte
+	BlockNumber       uint64
+	From              [20]byte
+	To                [20]byte
+	Create            bool // Transaction was a contract creation
+	Status            uint8
+	FailureReason     string // Failure reason code, empty on success
+	GasUsed           uint64
+	CumulativeGasUsed uint64 // Gas used in the block up to and including this transaction
+	EffectiveGasPrice uint64
+	ContractAddress   [20]byte // Contract deployed by a successful creation
+	Logs              []*Log
+}
+
+// Log is an event emitted during transaction execution
+type Log struct {
+	Address  [20]byte
+	Topics   [][32]byte
+	Data     []byte
+	LogIndex uint64 // Position among all logs in the block
+}
+
+// Leaf returns the receipt's leaf in the block's receipts tree. Block
+// placement is excluded since the block hash commits to the tree.
+func (r *Receipt) Leaf() [32]byte {
+	data := make([]byte, 0, 32+1+8+len(r.FailureReason)+8+8+32)
+	data = append(data, r.TxHash[:]...)
+	data = append(data, r.Status)
+	data = binary.BigEndian.AppendUint64(data, uint64(len(r.FailureReason)))
+	data = append(data, r.FailureReason...)
+	data = binary.BigEndian.AppendUint64(data, r.GasUsed)
+	data = binary.BigEndian.AppendUint64(data, r.CumulativeGasUsed)
+	logsHash := hashLogs(r.Logs)
+	data = append(data, logsHash[:]...)
+	return sha256.Sum256(data)
+}
+
+// GetReceipt returns the receipt of an included transaction
+func (bc *Blockchain) GetReceipt(txHash [32]byte) (*Receipt, error) {
+	data, err := bc.db.Get(receiptKey(txHash))
+	if err != nil {
+		return nil, ErrReceiptNotFound
+	}
+
+	var receipt Receipt
+	if err := json.Unmarshal(data, &receipt); err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
+
+// GetBlockReceipts returns the receipts of a canonical block's
+// transactions, in block order
+func (bc *Blockchain) GetBlockReceipts(block *Block) ([]*Receipt, error) {
+	receipts := make([]*Receipt, 0, len(block.Transactions))
+	for i := range block.Transactions {
+		receipt, err := bc.GetReceipt(txHash(&block.Transactions[i]))
+		if err != nil {
+			return nil, err
+		}
+		if receipt.BlockNumber != block.Header.Height {
+			return nil, ErrReceiptNotFound
+		}
+		receipts = append(receipts, receipt)
+	}
+	return receipts, nil
+}
+
+// Helper functions
+func writeReceipts(batch storage.Batch, receipts []*Receipt) error {
+	for _, r := range receipts {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if err := batch.Put(receiptKey(r.TxHash), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func receiptKey(txHash [32]byte) []byte {
+	return append(append([]byte(nil), receiptKeyPrefix...), txHash[:]...)
+}
+
+func hashLogs(logs []*Log) [32]byte {
+	data := make([]byte, 0)
+	for _, l := range logs {
+		data = append(data, l.Address[:]...)
+		data = binary.BigEndian.AppendUint64(data, uint64(len(l.Topics)))
+		for _, topic := range l.Topics {
+			data = append(data, topic[:]...)
+		}
+		data = binary.BigEndian.AppendUint64(data, uint64(len(l.Data)))
+		data = append(data, l.Data...)
+	}
+	return sha256.Sum256(data)
+}
@@ -0,0 +1,138 @@
+// Package blockchain - Transaction and receipt roots and inclusion proofs
+package blockchain
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// TxInclusionProof proves that a transaction is part of a block. The block
+// hash commits to TxRoot, so a client holding a trusted header can verify
+// the proof without downloading the block.
+type TxInclusionProof struct {
+	BlockHeight uint64
+	BlockHash   [32]byte
+	TxRoot      [32]byte
+	TxHash      [32]byte
+	Proof       *MerkleProof
+}
+
+// ComputeTxRoot returns the Merkle root over a list of transactions
+func ComputeTxRoot(txs []Transaction) [32]byte {
+	return NewMerkleTree(txLeaves(txs)).Root()
+}
+
+// ComputeReceiptsRoot returns the Merkle root over a list of receipts
+func ComputeReceiptsRoot(receipts []*Receipt) [32]byte {
+	return NewMerkleTree(receiptLeaves(receipts)).Root()
+}
+
+// ComputeValidatorRoot returns the Merkle root over a block's validator votes
+func ComputeValidatorRoot(votes []ValidatorVote) [32]byte {
+	return NewMerkleTree(voteLeaves(votes)).Root()
+}
+
+// TxTree returns the Merkle tree over the block's transactions
+func (b *Block) TxTree() *MerkleTree {
+	return NewMerkleTree(txLeaves(b.Transactions))
+}
+
+// SetRoots fills in TxRoot, ReceiptsRoot, ValidatorRoot and LogsBloom
+// during block assembly. There must be one receipt per transaction, in block order.
+func (b *Block) SetRoots(receipts []*Receipt) error {
+	if len(receipts) != len(b.Transactions) {
+		return fmt.Errorf("have %d receipts for %d transactions", len(receipts), len(b.Transactions))
+	}
+	b.Header.TxRoot = ComputeTxRoot(b.Transactions)
+	b.Header.ReceiptsRoot = ComputeReceiptsRoot(receipts)
+	b.Header.ValidatorRoot = ComputeValidatorRoot(b.Validators)
+	b.Header.LogsBloom = CreateBloom(receipts)
+	return nil
+}
+
+// VerifyRoots checks the header roots against the block body. Receipts come
+// from re-executing the block; pass nil to check only the transaction root.
+func (b *Block) VerifyRoots(receipts []*Receipt) error {
+	if ComputeTxRoot(b.Transactions) != b.Header.TxRoot {
+		return errors.New("transaction root mismatch")
+	}
+	if ComputeValidatorRoot(b.Validators) != b.Header.ValidatorRoot {
+		return errors.New("validator root mismatch")
+	}
+	if receipts == nil {
+		return nil
+	}
+	if len(receipts) != len(b.Transactions) {
+		return fmt.Errorf("have %d receipts for %d transactions", len(receipts), len(b.Transactions))
+	}
+	if ComputeReceiptsRoot(receipts) != b.Header.ReceiptsRoot {
+		return errors.New("receipts root mismatch")
+	}
+	if CreateBloom(receipts) != b.Header.LogsBloom {
+		return errors.New("logs bloom mismatch")
+	}
+	return nil
+}
+
+// GetTransactionProof builds an inclusion proof for the transaction at index
+// in the block at height
+func (bc *Blockchain) GetTransactionProof(height uint64, index int) (*TxInclusionProof, error) {
+	block, err := bc.GetBlock(height)
+	if err != nil {
+		return nil, err
+	}
+
+	proof, err := block.TxTree().Proof(index)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TxInclusionProof{
+		BlockHeight: height,
+		BlockHash:   block.Hash(),
+		TxRoot:      block.Header.TxRoot,
+		TxHash:      block.Transactions[index].ComputeHash(),
+		Proof:       proof,
+	}, nil
+}
+
+// VerifyTxInclusion checks a transaction inclusion proof against its root
+func VerifyTxInclusion(p *TxInclusionProof) bool {
+	return VerifyMerkleProof(p.TxRoot, p.TxHash, p.Proof)
+}
+
+// BuildReceiptProof builds an inclusion proof for the receipt at index
+func BuildReceiptProof(receipts []*Receipt, index int) (*MerkleProof, error) {
+	return NewMerkleTree(receiptLeaves(receipts)).Proof(index)
+}
+
+// Helper functions
+func txLeaves(txs []Transaction) [][32]byte {
+	leaves := make([][32]byte, len(txs))
+	for i := range txs {
+		leaves[i] = txs[i].ComputeHash()
+	}
+	return leaves
+}
+
+func voteLeaves(votes []ValidatorVote) [][32]byte {
+	leaves := make([][32]byte, len(votes))
+	for i, v := range votes {
+		data := make([]byte, 0, 20+32+65+8)
+		data = append(data, v.ValidatorAddr[:]...)
+		data = append(data, v.BlockHash[:]...)
+		data = append(data, v.Signature[:]...)
+		data = append(data, uint64ToBytes(v.Timestamp)...)
+		leaves[i] = sha256.Sum256(data)
+	}
+	return leaves
+}
+
+func receiptLeaves(receipts []*Receipt) [][32]byte {
+	leaves := make([][32]byte, len(receipts))
+	for i, r := range receipts {
+		leaves[i] = r.Leaf()
+	}
+	return leaves
+}
@@ -0,0 +1,325 @@
+// Package blockchain - Mining reward accrual and epoch settlement
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// SettlementAddress is the system account settlement transactions are sent
+// to and accrued mining rewards are recorded under. Like evidence,
+// settlements are added by block proposers: they come from
+// SettlementAddress itself, pay no fee and use no nonce, and a block
+// carrying an invalid settlement is invalid.
+var SettlementAddress = [20]byte{18: 0x01, 19: 0x05}
+
+// settlementMagic prefixes the data field of a settlement transaction,
+// which is
+//
+//	"SETL" ‖ epoch ‖ start ‖ (miner ‖ amount)*
+//
+// with each amount 32 bytes big-endian
+var settlementMagic = []byte("SETL")
+
+// settlementCursorSlot holds the oldest closed epoch not yet fully paid
+var settlementCursorSlot = stakingSlot("settlement:cursor")
+
+// Settlement epochs and limits
+const (
+	DefaultSettlementEpoch = 7200 // Blocks per epoch when Config.SettlementEpochBlocks is zero, a day of 12 second blocks
+	MaxSettlementEntries   = 256  // Miners paid per settlement transaction
+	settlementEntryLength  = 20 + 32
+)
+
+// ErrInvalidSettlement is returned for settlements that do not match the
+// rewards accrued on chain
+var ErrInvalidSettlement = errors.New("invalid mining reward settlement")
+
+// SettlementEntry is one miner's payment in a settlement
+type SettlementEntry struct {
+	Miner  [20]byte
+	Amount *big.Int
+}
+
+// Settlement pays part of an epoch's accrued mining rewards: the epoch's
+// miners in order from Start, each exactly what it accrued
+type Settlement struct {
+	Epoch   uint64
+	Start   uint64 // Index of the first miner paid among the epoch's
+	Entries []SettlementEntry
+}
+
+// EpochRewards is the on-chain account of a settlement epoch, covering
+// heights e×N+1 to (e+1)×N with N SettlementEpochBlocks
+type EpochRewards struct {
+	Epoch       uint64
+	FirstHeight uint64
+	LastHeight  uint64
+	Miners      uint64   // Addresses that earned rewards in the epoch
+	Paid        uint64   // Miners settled so far
+	Total       *big.Int // Rewards accrued in the epoch
+	Closed      bool     // The epoch is over and may be settled
+}
+
+// Settled reports whether every miner of the epoch has been paid
+func (r *EpochRewards) Settled() bool {
+	return r.Closed && r.Paid == r.Miners
+}
+
+// NewSettlementTx packages a settlement as a transaction for a block
+// proposal
+func NewSettlementTx(s *Settlement) Transaction {
+	data := make([]byte, 0, len(settlementMagic)+16+len(s.Entries)*settlementEntryLength)
+	data = append(data, settlementMagic...)
+	data = binary.BigEndian.AppendUint64(data, s.Epoch)
+	data = binary.BigEndian.AppendUint64(data, s.Start)
+	for _, entry := range s.Entries {
+		data = append(data, entry.Miner[:]...)
+		data = append(data, bigToBytes32(entry.Amount)...)
+	}
+
+	tx := Transaction{
+		From: SettlementAddress,
+		To:   SettlementAddress,
+		Data: data,
+	}
+	tx.Hash = tx.ComputeHash()
+	return tx
+}
+
+// DecodeSettlementTx decodes a transaction sent to SettlementAddress
+func DecodeSettlementTx(tx *Transaction) (*Settlement, error) {
+	if tx.To != SettlementAddress || tx.From != SettlementAddress {
+		return nil, errors.New("not a settlement transaction")
+	}
+	if (tx.Value != nil && tx.Value.Sign() != 0) || tx.GasLimit != 0 || tx.GasPrice != 0 || tx.Nonce != 0 {
+		return nil, errors.New("settlement must not carry value, gas or a nonce")
+	}
+	if !bytes.HasPrefix(tx.Data, settlementMagic) || len(tx.Data) < len(settlementMagic)+16 {
+		return nil, errors.New("invalid settlement payload")
+	}
+
+	payload := tx.Data[len(settlementMagic):]
+	s := &Settlement{
+		Epoch: binary.BigEndian.Uint64(payload[:8]),
+		Start: binary.BigEndian.Uint64(payload[8:16]),
+	}
+	payload = payload[16:]
+	if len(payload) == 0 || len(payload)%settlementEntryLength != 0 {
+		return nil, errors.New("settlement entries are malformed")
+	}
+	if len(payload)/settlementEntryLength > MaxSettlementEntries {
+		return nil, fmt.Errorf("settlement has more than %d entries", MaxSettlementEntries)
+	}
+	for ; len(payload) > 0; payload = payload[settlementEntryLength:] {
+		var entry SettlementEntry
+		copy(entry.Miner[:], payload[:20])
+		entry.Amount = new(big.Int).SetBytes(payload[20:settlementEntryLength])
+		s.Entries = append(s.Entries, entry)
+	}
+	return s, nil
+}
+
+// SettlementEpoch returns the settlement epoch of a block height. The
+// genesis block, which has no shares, counts towards epoch 0.
+func (bc *Blockchain) SettlementEpoch(height uint64) uint64 {
+	if height == 0 {
+		return 0
+	}
+	return (height - 1) / bc.settlementEpochBlocks()
+}
+
+// GetEpochRewards returns the account of a settlement epoch as of the head
+func (bc *Blockchain) GetEpochRewards(epoch uint64) *EpochRewards {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	blocks := bc.settlementEpochBlocks()
+	return &EpochRewards{
+		Epoch:       epoch,
+		FirstHeight: epoch*blocks + 1,
+		LastHeight:  (epoch + 1) * blocks,
+		Miners:      bc.settlementCounter("miners", epoch),
+		Paid:        bc.settlementCounter("paid", epoch),
+		Total:       bc.settlementAmount(settlementSlot("total", epoch)),
+		Closed:      bc.SettlementEpoch(bc.currentBlock.Header.Height+1) > epoch,
+	}
+}
+
+// GetMiningAccrual returns the rewards a miner has accrued in an epoch and
+// not yet been paid
+func (bc *Blockchain) GetMiningAccrual(epoch uint64, miner [20]byte) *big.Int {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.settlementAmount(settlementSlot("accrued", epoch, miner[:]))
+}
+
+// PendingSettlement returns the settlement a block on top of the head
+// should carry: the next miners of the oldest closed epoch not yet fully
+// paid, or nil if there is none
+func (bc *Blockchain) PendingSettlement() *Settlement {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	current := bc.SettlementEpoch(bc.currentBlock.Header.Height + 1)
+	for epoch := bc.settlementCursor(); epoch < current; epoch++ {
+		miners := bc.settlementCounter("miners", epoch)
+		paid := bc.settlementCounter("paid", epoch)
+		if paid >= miners {
+			continue
+		}
+
+		s := &Settlement{Epoch: epoch, Start: paid}
+		for i := paid; i < miners && len(s.Entries) < MaxSettlementEntries; i++ {
+			miner := bc.settlementMiner(epoch, i)
+			s.Entries = append(s.Entries, SettlementEntry{
+				Miner:  miner,
+				Amount: bc.settlementAmount(settlementSlot("accrued", epoch, miner[:])),
+			})
+		}
+		return s
+	}
+	return nil
+}
+
+// Helper functions
+
+func (bc *Blockchain) settlementEpochBlocks() uint64 {
+	if bc.config.SettlementEpochBlocks > 0 {
+		return bc.config.SettlementEpochBlocks
+	}
+	return DefaultSettlementEpoch
+}
+
+// accrueMiningRewards records the rewards of a block's verified mining
+// summary in state. Callers must hold bc.mu.
+func (bc *Blockchain) accrueMiningRewards(block *Block) error {
+	if len(block.Mining.Rewards) == 0 {
+		return nil
+	}
+	if err := block.VerifyMining(); err != nil {
+		return err
+	}
+
+	epoch := bc.SettlementEpoch(block.Header.Height)
+	if err := bc.checkMiningIssuance(block, epoch); err != nil {
+		return err
+	}
+	totalSlot := settlementSlot("total", epoch)
+	total := bc.settlementAmount(totalSlot)
+	for _, reward := range block.Mining.Rewards {
+		if reward.Reward == nil || reward.Reward.Sign() == 0 {
+			continue
+		}
+		if reward.Reward.Sign() < 0 {
+			return fmt.Errorf("negative mining reward for %x", reward.Address)
+		}
+
+		slot := settlementSlot("accrued", epoch, reward.Address[:])
+		accrued := bc.settlementAmount(slot)
+		if accrued.Sign() == 0 {
+			miners := bc.settlementCounter("miners", epoch)
+			var word [32]byte
+			copy(word[12:], reward.Address[:])
+			bc.stateDB.SetState(SettlementAddress, settlementSlot("miner", epoch, uint64ToBytes(miners)), word)
+			bc.setSettlementCounter("miners", epoch, miners+1)
+		}
+		bc.stateDB.SetState(SettlementAddress, slot, uint256Word(accrued.Add(accrued, reward.Reward)))
+		total.Add(total, reward.Reward)
+	}
+	bc.stateDB.SetState(SettlementAddress, totalSlot, uint256Word(total))
+	return nil
+}
+
+// applySettlementTx checks a settlement against the accrued rewards and
+// pays it. A settlement that fails makes the block invalid, since the
+// proposer builds it from state. Callers must hold bc.mu.
+func (bc *Blockchain) applySettlementTx(tx *Transaction, height uint64) error {
+	s, err := DecodeSettlementTx(tx)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSettlement, err)
+	}
+	if s.Epoch >= bc.SettlementEpoch(height) {
+		return fmt.Errorf("%w: epoch %d is not over", ErrInvalidSettlement, s.Epoch)
+	}
+	paid := bc.settlementCounter("paid", s.Epoch)
+	miners := bc.settlementCounter("miners", s.Epoch)
+	if s.Start != paid {
+		return fmt.Errorf("%w: epoch %d is paid up to miner %d, not %d", ErrInvalidSettlement, s.Epoch, paid, s.Start)
+	}
+	if s.Start+uint64(len(s.Entries)) > miners {
+		return fmt.Errorf("%w: epoch %d has %d miners", ErrInvalidSettlement, s.Epoch, miners)
+	}
+
+	for i, entry := range s.Entries {
+		index := s.Start + uint64(i)
+		if miner := bc.settlementMiner(s.Epoch, index); miner != entry.Miner {
+			return fmt.Errorf("%w: miner %d is %x, not %x", ErrInvalidSettlement, index, miner, entry.Miner)
+		}
+		slot := settlementSlot("accrued", s.Epoch, entry.Miner[:])
+		if accrued := bc.settlementAmount(slot); accrued.Cmp(entry.Amount) != 0 {
+			return fmt.Errorf("%w: %x accrued %s, not %s", ErrInvalidSettlement, entry.Miner, accrued, entry.Amount)
+		}
+		bc.stateDB.AddBalance(entry.Miner, entry.Amount)
+		bc.addSupply(supplyMintedSlot, entry.Amount)
+		bc.emitAmountLog(TopicMint, entry.Miner, entry.Amount)
+		amount := uint256Word(entry.Amount)
+		bc.emitLog(SettlementAddress, amount[:], TopicMiningReward, AddressTopic(entry.Miner), uint256Word(new(big.Int).SetUint64(s.Epoch)))
+		bc.stateDB.SetState(SettlementAddress, slot, [32]byte{})
+		bc.stateDB.SetState(SettlementAddress, settlementSlot("miner", s.Epoch, uint64ToBytes(index)), [32]byte{})
+	}
+
+	bc.setSettlementCounter("paid", s.Epoch, paid+uint64(len(s.Entries)))
+
+	// Move the cursor past the epochs now fully paid
+	cursor := bc.settlementCursor()
+	for current := bc.SettlementEpoch(height); cursor < current; cursor++ {
+		if bc.settlementCounter("paid", cursor) < bc.settlementCounter("miners", cursor) {
+			break
+		}
+	}
+	bc.stateDB.SetState(SettlementAddress, settlementCursorSlot, uint256Word(new(big.Int).SetUint64(cursor)))
+	return nil
+}
+
+// settlementSlot returns the SettlementAddress storage slot of a kind of
+// value in an epoch
+func settlementSlot(kind string, epoch uint64, parts ...[]byte) [32]byte {
+	return stakingSlot("settlement:"+kind, append([][]byte{uint64ToBytes(epoch)}, parts...)...)
+}
+
+// settlementCounter reads a counter of an epoch. Callers must hold bc.mu.
+func (bc *Blockchain) settlementCounter(kind string, epoch uint64) uint64 {
+	return wordToUint64(bc.stateDB.GetState(SettlementAddress, settlementSlot(kind, epoch)))
+}
+
+// setSettlementCounter writes a counter of an epoch. Callers must hold
+// bc.mu.
+func (bc *Blockchain) setSettlementCounter(kind string, epoch, value uint64) {
+	bc.stateDB.SetState(SettlementAddress, settlementSlot(kind, epoch), uint256Word(new(big.Int).SetUint64(value)))
+}
+
+// settlementCursor reads the oldest closed epoch that may not be fully
+// paid. Callers must hold bc.mu.
+func (bc *Blockchain) settlementCursor() uint64 {
+	return wordToUint64(bc.stateDB.GetState(SettlementAddress, settlementCursorSlot))
+}
+
+// settlementAmount reads an amount slot. Callers must hold bc.mu.
+func (bc *Blockchain) settlementAmount(slot [32]byte) *big.Int {
+	word := bc.stateDB.GetState(SettlementAddress, slot)
+	return new(big.Int).SetBytes(word[:])
+}
+
+// settlementMiner reads the address of an epoch's miner by the order it
+// first earned in. Callers must hold bc.mu.
+func (bc *Blockchain) settlementMiner(epoch, index uint64) [20]byte {
+	word := bc.stateDB.GetState(SettlementAddress, settlementSlot("miner", epoch, uint64ToBytes(index)))
+	var miner [20]byte
+	copy(miner[:], word[12:])
+	return miner
+}
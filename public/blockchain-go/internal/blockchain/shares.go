@@ -0,0 +1,412 @@
+// Package blockchain - Mining share aggregation and the prunable share store
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"chaincore/internal/storage"
+)
+
+// defaultShareRetention is how many blocks of raw shares are kept when
+// Config.ShareRetentionBlocks is zero (one week of 12 second blocks)
+const defaultShareRetention = 50400
+
+// sharesKeyPrefix is the storage keyspace for raw shares, by block height
+var sharesKeyPrefix = []byte("shares:")
+
+// ErrSharesPruned is returned for shares no longer in the share store
+var ErrSharesPruned = errors.New("mining shares pruned or not available")
+
+// MiningReward is the total credited to one address by a block's shares
+type MiningReward struct {
+	Address [20]byte
+	Shares  uint64
+	Reward  *big.Int
+}
+
+// MiningSummary is what a block carries for its mining shares. The raw
+// shares live in the share store and may be pruned; the summary and the
+// header's MiningRoot remain verifiable without them.
+type MiningSummary struct {
+	SharesRoot [32]byte // Merkle root over the raw shares
+	ShareCount uint64
+	Rewards    []MiningReward // Sorted by address
+}
+
+// MiningShareProof proves that a share was included in a block. The block
+// hash commits to MiningRoot, which commits to the shares root, so a miner
+// holding a trusted header can verify the proof without the block.
+type MiningShareProof struct {
+	BlockHeight uint64
+	BlockHash   [32]byte
+	MiningRoot  [32]byte
+	SharesRoot  [32]byte
+	ShareCount  uint64
+	RewardsRoot [32]byte
+	Share       MiningShare
+	Proof       *MerkleProof // Against SharesRoot
+}
+
+// MiningRewardProof proves what a block credited an address, against the
+// same commitment as MiningShareProof
+type MiningRewardProof struct {
+	BlockHeight uint64
+	BlockHash   [32]byte
+	MiningRoot  [32]byte
+	SharesRoot  [32]byte
+	ShareCount  uint64
+	RewardsRoot [32]byte
+	Reward      MiningReward
+	Proof       *MerkleProof // Against RewardsRoot
+}
+
+// Root returns the commitment stored in BlockHeader.MiningRoot. A block
+// without shares has a zero root.
+func (s *MiningSummary) Root() [32]byte {
+	if s.ShareCount == 0 && len(s.Rewards) == 0 {
+		return [32]byte{}
+	}
+	return miningRoot(s.SharesRoot, s.ShareCount, s.RewardsRoot())
+}
+
+// RewardsRoot returns the Merkle root over the per-address reward totals
+func (s *MiningSummary) RewardsRoot() [32]byte {
+	return NewMerkleTree(s.rewardLeaves()).Root()
+}
+
+// SummarizeShares aggregates shares into per-address reward totals
+func SummarizeShares(shares []MiningShare) MiningSummary {
+	leaves := make([][32]byte, len(shares))
+	totals := make(map[[20]byte]*MiningReward)
+	for i := range shares {
+		share := &shares[i]
+		leaves[i] = share.Leaf()
+
+		total, exists := totals[share.MinerAddr]
+		if !exists {
+			total = &MiningReward{Address: share.MinerAddr, Reward: big.NewInt(0)}
+			totals[share.MinerAddr] = total
+		}
+		total.Shares++
+		if share.Reward != nil {
+			total.Reward.Add(total.Reward, share.Reward)
+		}
+	}
+
+	summary := MiningSummary{
+		SharesRoot: MerkleRoot(leaves),
+		ShareCount: uint64(len(shares)),
+		Rewards:    make([]MiningReward, 0, len(totals)),
+	}
+	for _, total := range totals {
+		summary.Rewards = append(summary.Rewards, *total)
+	}
+	sort.Slice(summary.Rewards, func(i, j int) bool {
+		return bytes.Compare(summary.Rewards[i].Address[:], summary.Rewards[j].Address[:]) < 0
+	})
+	return summary
+}
+
+// Leaf returns the share's Merkle leaf
+func (s *MiningShare) Leaf() [32]byte {
+	data := make([]byte, 0, 20+32+32+8+8+1+32+20+32+8+32+32)
+	data = append(data, s.MinerAddr[:]...)
+	data = append(data, s.ShareHash[:]...)
+	data = append(data, bigToBytes32(s.Difficulty)...)
+	data = append(data, uint64ToBytes(s.Nonce)...)
+	data = append(data, uint64ToBytes(s.Timestamp)...)
+	data = append(data, s.HumanScore)
+	data = append(data, s.SessionID[:]...)
+	data = append(data, s.PoolID[:]...)
+	data = append(data, bigToBytes32(s.Reward)...)
+	data = append(data, uint64ToBytes(s.JobHeight)...)
+	signature := sha256.Sum256(s.Signature)
+	data = append(data, signature[:]...)
+	data = append(data, s.TemplateRoot[:]...)
+	return sha256.Sum256(data)
+}
+
+// SetMiningShares attaches raw shares to a block under assembly, filling in
+// the mining summary and MiningRoot
+func (b *Block) SetMiningShares(shares []MiningShare) {
+	b.MiningShares = shares
+	b.Mining = SummarizeShares(shares)
+	b.Header.MiningRoot = b.Mining.Root()
+}
+
+// withoutShares returns a shallow copy of the block without its raw shares,
+// as blocks are stored; the shares go to the share store
+func (b *Block) withoutShares() *Block {
+	stored := *b
+	stored.MiningShares = nil
+	return &stored
+}
+
+// VerifyMining checks the mining summary against the header and, if the raw
+// shares are attached, the shares against the summary. A summary must list
+// each address once, in order, with share counts adding up to ShareCount.
+func (b *Block) VerifyMining() error {
+	if b.Mining.Root() != b.Header.MiningRoot {
+		return errors.New("mining root mismatch")
+	}
+	if err := b.Mining.validate(); err != nil {
+		return err
+	}
+	if b.MiningShares == nil {
+		return nil
+	}
+
+	expected := SummarizeShares(b.MiningShares)
+	if expected.SharesRoot != b.Mining.SharesRoot || expected.Root() != b.Mining.Root() {
+		return errors.New("mining shares do not match summary")
+	}
+	return nil
+}
+
+// GetMiningShares returns the raw shares of the block at height
+func (bc *Blockchain) GetMiningShares(height uint64) ([]MiningShare, error) {
+	data, err := bc.db.Get(sharesKey(height))
+	if err != nil {
+		return nil, ErrSharesPruned
+	}
+
+	var shares []MiningShare
+	if err := json.Unmarshal(data, &shares); err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+// GetMiningShareProof builds an inclusion proof for the share at index in
+// the block at height. It needs the raw shares, so it fails once they are
+// pruned.
+func (bc *Blockchain) GetMiningShareProof(height uint64, index int) (*MiningShareProof, error) {
+	block, err := bc.GetBlock(height)
+	if err != nil {
+		return nil, err
+	}
+	shares, err := bc.GetMiningShares(height)
+	if err != nil {
+		return nil, err
+	}
+
+	leaves := make([][32]byte, len(shares))
+	for i := range shares {
+		leaves[i] = shares[i].Leaf()
+	}
+	proof, err := NewMerkleTree(leaves).Proof(index)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MiningShareProof{
+		BlockHeight: height,
+		BlockHash:   block.Hash(),
+		MiningRoot:  block.Header.MiningRoot,
+		SharesRoot:  block.Mining.SharesRoot,
+		ShareCount:  block.Mining.ShareCount,
+		RewardsRoot: block.Mining.RewardsRoot(),
+		Share:       shares[index],
+		Proof:       proof,
+	}, nil
+}
+
+// FindMiningShare returns the index of the share with the given hash among
+// the raw shares of the block at height
+func (bc *Blockchain) FindMiningShare(height uint64, shareHash [32]byte) (int, error) {
+	shares, err := bc.GetMiningShares(height)
+	if err != nil {
+		return 0, err
+	}
+	for i := range shares {
+		if shares[i].ShareHash == shareHash {
+			return i, nil
+		}
+	}
+	return 0, errors.New("share not found in block")
+}
+
+// GetMiningRewardProof builds an inclusion proof for what a miner was
+// credited by the block at height. It needs only the block's summary, so
+// it outlives the raw shares.
+func (bc *Blockchain) GetMiningRewardProof(height uint64, miner [20]byte) (*MiningRewardProof, error) {
+	block, err := bc.GetBlock(height)
+	if err != nil {
+		return nil, err
+	}
+
+	index := sort.Search(len(block.Mining.Rewards), func(i int) bool {
+		return bytes.Compare(block.Mining.Rewards[i].Address[:], miner[:]) >= 0
+	})
+	if index == len(block.Mining.Rewards) || block.Mining.Rewards[index].Address != miner {
+		return nil, errors.New("miner has no rewards in block")
+	}
+	proof, err := NewMerkleTree(block.Mining.rewardLeaves()).Proof(index)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MiningRewardProof{
+		BlockHeight: height,
+		BlockHash:   block.Hash(),
+		MiningRoot:  block.Header.MiningRoot,
+		SharesRoot:  block.Mining.SharesRoot,
+		ShareCount:  block.Mining.ShareCount,
+		RewardsRoot: block.Mining.RewardsRoot(),
+		Reward:      block.Mining.Rewards[index],
+		Proof:       proof,
+	}, nil
+}
+
+// VerifyMiningShareProof checks a share inclusion proof against the
+// MiningRoot of a trusted header
+func VerifyMiningShareProof(root [32]byte, p *MiningShareProof) error {
+	if p.Proof == nil || p.Proof.Index >= p.ShareCount {
+		return errors.New("share index out of range")
+	}
+	if miningRoot(p.SharesRoot, p.ShareCount, p.RewardsRoot) != root {
+		return errors.New("mining root mismatch")
+	}
+	if !VerifyMerkleProof(p.SharesRoot, p.Share.Leaf(), p.Proof) {
+		return errors.New("share not included under shares root")
+	}
+	return nil
+}
+
+// VerifyMiningRewardProof checks a reward inclusion proof against the
+// MiningRoot of a trusted header
+func VerifyMiningRewardProof(root [32]byte, p *MiningRewardProof) error {
+	if p.Proof == nil {
+		return errors.New("missing reward proof")
+	}
+	if miningRoot(p.SharesRoot, p.ShareCount, p.RewardsRoot) != root {
+		return errors.New("mining root mismatch")
+	}
+	if !VerifyMerkleProof(p.RewardsRoot, p.Reward.leaf(), p.Proof) {
+		return errors.New("reward not included under rewards root")
+	}
+	return nil
+}
+
+// PruneMiningShares deletes raw shares for blocks below height and returns
+// the number of blocks pruned
+func (bc *Blockchain) PruneMiningShares(below uint64) (int, error) {
+	batch := bc.db.NewBatch()
+	pruned := 0
+
+	it := bc.db.NewIterator(sharesKeyPrefix)
+	for it.Next() {
+		key := it.Key()
+		if len(key) != len(sharesKeyPrefix)+8 {
+			continue
+		}
+		if binary.BigEndian.Uint64(key[len(sharesKeyPrefix):]) >= below {
+			break
+		}
+		if err := batch.Delete(append([]byte(nil), key...)); err != nil {
+			it.Release()
+			return 0, err
+		}
+		pruned++
+	}
+	it.Release()
+	if err := it.Error(); err != nil {
+		return 0, err
+	}
+
+	if pruned == 0 {
+		return 0, nil
+	}
+	return pruned, batch.Write()
+}
+
+// Helper functions
+
+// miningRoot commits to a block's shares root, share count and rewards root
+func miningRoot(sharesRoot [32]byte, shareCount uint64, rewardsRoot [32]byte) [32]byte {
+	data := make([]byte, 0, 32+8+32)
+	data = append(data, sharesRoot[:]...)
+	data = append(data, uint64ToBytes(shareCount)...)
+	data = append(data, rewardsRoot[:]...)
+	return sha256.Sum256(data)
+}
+
+func (s *MiningSummary) rewardLeaves() [][32]byte {
+	leaves := make([][32]byte, len(s.Rewards))
+	for i, r := range s.Rewards {
+		leaves[i] = r.leaf()
+	}
+	return leaves
+}
+
+// validate checks that the summary is one SummarizeShares could have
+// produced
+func (s *MiningSummary) validate() error {
+	var shares uint64
+	for i, r := range s.Rewards {
+		if i > 0 && bytes.Compare(s.Rewards[i-1].Address[:], r.Address[:]) >= 0 {
+			return errors.New("mining rewards not sorted by address")
+		}
+		if r.Shares == 0 || r.Reward == nil || r.Reward.Sign() < 0 {
+			return errors.New("invalid mining reward")
+		}
+		shares += r.Shares
+	}
+	if shares != s.ShareCount {
+		return fmt.Errorf("mining rewards count %d shares, summary has %d", shares, s.ShareCount)
+	}
+	if s.ShareCount == 0 && s.SharesRoot != ([32]byte{}) {
+		return errors.New("mining shares root set without shares")
+	}
+	return nil
+}
+
+func (r MiningReward) leaf() [32]byte {
+	data := make([]byte, 0, 20+8+32)
+	data = append(data, r.Address[:]...)
+	data = append(data, uint64ToBytes(r.Shares)...)
+	data = append(data, bigToBytes32(r.Reward)...)
+	return sha256.Sum256(data)
+}
+
+// writeShares stores a block's raw shares and drops those that have fallen
+// out of the retention window
+func (bc *Blockchain) writeShares(batch storage.Batch, block *Block) error {
+	if len(block.MiningShares) > 0 {
+		data, err := json.Marshal(block.MiningShares)
+		if err != nil {
+			return err
+		}
+		if err := batch.Put(sharesKey(block.Header.Height), data); err != nil {
+			return err
+		}
+	}
+
+	retention := bc.config.ShareRetentionBlocks
+	if retention == 0 {
+		retention = defaultShareRetention
+	}
+	if block.Header.Height >= retention {
+		return batch.Delete(sharesKey(block.Header.Height - retention))
+	}
+	return nil
+}
+
+func sharesKey(height uint64) []byte {
+	return binary.BigEndian.AppendUint64(append([]byte(nil), sharesKeyPrefix...), height)
+}
+
+func bigToBytes32(n *big.Int) []byte {
+	var b [32]byte
+	if n != nil {
+		n.FillBytes(b[:])
+	}
+	return b[:]
+}
@@ -0,0 +1,401 @@
+// Package blockchain - Mining share verification and rewards
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/sha3"
+
+	"chaincore/internal/secp256k1"
+)
+
+// shareSigningDomain separates share signatures from transaction signatures
+var shareSigningDomain = []byte("chaincore-share-v1")
+
+// shareWorkDomain prefixes the data hashed for a signed share's work, which
+// is
+//
+//	"chaincore-share-work-v1" ‖ job ID ‖ miner ‖ nonce
+//
+// with the miner the address the share is credited to
+var shareWorkDomain = []byte("chaincore-share-work-v1")
+
+// soloHeaderDomain prefixes the solo template header, which is
+//
+//	"chaincore-solo-v1" ‖ parent hash ‖ height ‖ parent timestamp ‖
+//	template root ‖ coinbase ‖ reward ‖ difficulty
+//
+// with the reward and difficulty 32 bytes big-endian. The share hash is the
+// SHA-256 of the header followed by the nonce.
+var soloHeaderDomain = []byte("chaincore-solo-v1")
+
+// Mining share defaults and limits
+const (
+	DefaultMinShareDifficulty = 1 << 16 // Share difficulty floor when Config.MinShareDifficulty is nil
+	MaxBlockShares            = 1000    // Shares one block may carry, which keeps it within a network message
+)
+
+var (
+	// MiningShareBaseReward is the reward of a share at the minimum
+	// difficulty with a full human score: 0.1 token
+	MiningShareBaseReward = big.NewInt(1e17)
+
+	// DefaultMaxBlockMiningReward is the most one block's shares may accrue
+	// when Config.MaxBlockMiningReward is nil: 100 tokens
+	DefaultMaxBlockMiningReward = new(big.Int).Mul(big.NewInt(100), big.NewInt(1e18))
+)
+
+// ErrInvalidMiningShare is returned for blocks carrying a share the chain
+// cannot verify or whose reward breaks the issuance rules
+var ErrInvalidMiningShare = errors.New("invalid mining share")
+
+// MiningJobID derives the job ID for work on the block at height on top of
+// parentHash. It depends only on chain data, so every pool front-end issues
+// and accepts the same IDs.
+func MiningJobID(height uint64, parentHash [32]byte) string {
+	data := make([]byte, 0, 8+32)
+	data = append(data, uint64ToBytes(height)...)
+	data = append(data, parentHash[:]...)
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:8])
+}
+
+// MiningSharePayload returns the message a miner signs for a share: the
+// domain tag, job ID, nonce, share hash and job height. Wallets sign its
+// Keccak-256 hash, MiningShareDigest.
+func MiningSharePayload(jobID string, height, nonce uint64, hash [32]byte) []byte {
+	data := make([]byte, 0, len(shareSigningDomain)+len(jobID)+8+32+8)
+	data = append(data, shareSigningDomain...)
+	data = append(data, jobID...)
+	data = append(data, uint64ToBytes(nonce)...)
+	data = append(data, hash[:]...)
+	return append(data, uint64ToBytes(height)...)
+}
+
+// MiningShareDigest returns the Keccak-256 hash of a share payload
+func MiningShareDigest(payload []byte) [32]byte {
+	var digest [32]byte
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write(payload)
+	hasher.Sum(digest[:0])
+	return digest
+}
+
+// RecoverShareSigner returns the address that signed a share digest. Both
+// raw recovery IDs and the 27/28 form wallets produce are accepted.
+func RecoverShareSigner(digest [32]byte, signature []byte) ([20]byte, error) {
+	if len(signature) != 65 {
+		return [20]byte{}, errors.New("share signature must be 65 bytes")
+	}
+	sig := append([]byte(nil), signature...)
+	if sig[64] >= 27 {
+		sig[64] -= 27
+	}
+	return secp256k1.RecoverAddress(digest[:], sig)
+}
+
+// MiningShareWork returns the hash a signed share's nonce must bring below
+// the share's target. It binds the work to the job and to the address the
+// share is credited to, so work cannot be replayed or redirected.
+func MiningShareWork(jobID string, miner [20]byte, nonce uint64) [32]byte {
+	return sha256.Sum256(append(MiningWorkHeader(jobID, miner), uint64ToBytes(nonce)...))
+}
+
+// MiningWorkHeader returns the bytes a signed share's work hashes before the
+// nonce
+func MiningWorkHeader(jobID string, miner [20]byte) []byte {
+	header := make([]byte, 0, len(shareWorkDomain)+len(jobID)+20+8)
+	header = append(header, shareWorkDomain...)
+	header = append(header, jobID...)
+	return append(header, miner[:]...)
+}
+
+// SoloHeader returns the template header solo miners hash, followed by the
+// nonce
+func SoloHeader(parentHash [32]byte, height, parentTime uint64, templateRoot [32]byte, coinbase [20]byte, reward, difficulty *big.Int) []byte {
+	header := make([]byte, 0, len(soloHeaderDomain)+32+8+8+32+20+32+32)
+	header = append(header, soloHeaderDomain...)
+	header = append(header, parentHash[:]...)
+	header = append(header, uint64ToBytes(height)...)
+	header = append(header, uint64ToBytes(parentTime)...)
+	header = append(header, templateRoot[:]...)
+	header = append(header, coinbase[:]...)
+	header = append(header, bigToBytes32(reward)...)
+	return append(header, bigToBytes32(difficulty)...)
+}
+
+// MeetsShareDifficulty reports whether hash, read big-endian, is below
+// 2^256 / difficulty
+func MeetsShareDifficulty(hash [32]byte, difficulty *big.Int) bool {
+	if difficulty == nil || difficulty.Sign() <= 0 {
+		return false
+	}
+	target := new(big.Int).Lsh(big.NewInt(1), 256)
+	target.Div(target, difficulty)
+	return new(big.Int).SetBytes(hash[:]).Cmp(target) < 0
+}
+
+// MinShareDifficulty returns the lowest difficulty a share may claim, which
+// is also the difficulty MiningShareBaseReward is paid for
+func (bc *Blockchain) MinShareDifficulty() *big.Int {
+	if bc.config.MinShareDifficulty != nil && bc.config.MinShareDifficulty.Sign() > 0 {
+		return new(big.Int).Set(bc.config.MinShareDifficulty)
+	}
+	return big.NewInt(DefaultMinShareDifficulty)
+}
+
+// MiningShareReward returns the reward of a share:
+// R(d,H) = MiningShareBaseReward × (d / MinShareDifficulty) × (H / 100)
+func (bc *Blockchain) MiningShareReward(difficulty *big.Int, humanScore uint8) *big.Int {
+	if difficulty == nil {
+		return big.NewInt(0)
+	}
+	reward := new(big.Int).Div(difficulty, bc.MinShareDifficulty())
+	reward.Mul(reward, MiningShareBaseReward)
+	reward.Mul(reward, big.NewInt(int64(humanScore)))
+	return reward.Div(reward, big.NewInt(100))
+}
+
+// EligibleMiningShares returns the shares, in order, that a block on top of
+// the head may carry: each verified, not seen before and within the block
+// and per-address caps. Block producers filter their shares with it before
+// Block.SetMiningShares.
+func (bc *Blockchain) EligibleMiningShares(shares []MiningShare) []MiningShare {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	parent := bc.currentBlock
+	verifier, err := bc.newShareVerifier(parent.Header.Height+1, parent)
+	if err != nil {
+		return []MiningShare{}
+	}
+	eligible := make([]MiningShare, 0, len(shares))
+	for i := range shares {
+		if verifier.add(&shares[i]) == nil {
+			eligible = append(eligible, shares[i])
+		}
+	}
+	return eligible
+}
+
+// Helper functions
+
+// shareJob is the chain data a job's shares are checked against
+type shareJob struct {
+	id         string
+	parentHash [32]byte
+	parentTime uint64
+}
+
+// shareVerifier checks the shares of the block at a height in order
+type shareVerifier struct {
+	bc         *Blockchain
+	epoch      uint64
+	jobs       map[uint64]shareJob // By job height: the block's own and its parent's
+	seen       map[[32]byte]bool
+	shares     int
+	credited   map[[20]byte]*big.Int
+	total      *big.Int
+	addressCap *big.Int // Nil if uncapped
+	blockCap   *big.Int
+}
+
+// newShareVerifier prepares to check the shares of the block at height on
+// top of parent. Shares may be for work on the block itself or, submitted
+// late, on its parent; the parent's shares cannot be repeated. Callers must
+// hold bc.mu.
+func (bc *Blockchain) newShareVerifier(height uint64, parent *Block) (*shareVerifier, error) {
+	v := &shareVerifier{
+		bc:         bc,
+		epoch:      bc.SettlementEpoch(height),
+		jobs:       make(map[uint64]shareJob, 2),
+		seen:       make(map[[32]byte]bool),
+		credited:   make(map[[20]byte]*big.Int),
+		total:      big.NewInt(0),
+		addressCap: bc.miningAddressCap(),
+		blockCap:   bc.maxBlockMiningReward(),
+	}
+	parentHash := parent.Hash()
+	v.jobs[height] = shareJob{MiningJobID(height, parentHash), parentHash, parent.Header.Timestamp}
+	if parent.Header.Height == 0 {
+		return v, nil
+	}
+
+	grandparent, err := bc.loadBlock(parent.Header.Height-1, parent.Header.PrevHash)
+	if err != nil {
+		return nil, err
+	}
+	v.jobs[parent.Header.Height] = shareJob{MiningJobID(parent.Header.Height, parent.Header.PrevHash), parent.Header.PrevHash, grandparent.Header.Timestamp}
+
+	// Parent shares pruned away, as after a snapshot sync, cannot be
+	// replayed into this block by anyone who saw them either
+	previous, err := bc.GetMiningShares(parent.Header.Height)
+	if err != nil && !errors.Is(err, ErrSharesPruned) {
+		return nil, err
+	}
+	for i := range previous {
+		v.seen[previous[i].ShareHash] = true
+	}
+	return v, nil
+}
+
+// add checks a share and counts its reward against the caps
+func (v *shareVerifier) add(share *MiningShare) error {
+	if v.shares >= MaxBlockShares {
+		return fmt.Errorf("block carries more than %d shares", MaxBlockShares)
+	}
+	if err := v.bc.checkShareWork(share, v.jobs); err != nil {
+		return err
+	}
+	if v.seen[share.ShareHash] {
+		return errors.New("duplicate share")
+	}
+
+	total := new(big.Int).Add(v.total, share.Reward)
+	if total.Cmp(v.blockCap) > 0 {
+		return fmt.Errorf("block mining rewards exceed %s", v.blockCap)
+	}
+	credited, exists := v.credited[share.MinerAddr]
+	if !exists {
+		credited = v.bc.settlementAmount(settlementSlot("accrued", v.epoch, share.MinerAddr[:]))
+	}
+	credited = new(big.Int).Add(credited, share.Reward)
+	if v.addressCap != nil && credited.Cmp(v.addressCap) > 0 {
+		return fmt.Errorf("mining rewards of %x exceed %s this epoch", share.MinerAddr, v.addressCap)
+	}
+
+	v.seen[share.ShareHash] = true
+	v.shares++
+	v.credited[share.MinerAddr] = credited
+	v.total = total
+	return nil
+}
+
+// checkShareWork verifies a share on its own: its job, its proof of work
+// and who it credits, and that its reward is the chain's for its difficulty
+// and human score. Signed shares carry the miner's signature; solo shares
+// instead hash a template header that commits to their coinbase and reward.
+func (bc *Blockchain) checkShareWork(share *MiningShare, jobs map[uint64]shareJob) error {
+	job, live := jobs[share.JobHeight]
+	if !live {
+		return fmt.Errorf("share is for job height %d", share.JobHeight)
+	}
+	if share.Difficulty == nil || share.Difficulty.Cmp(bc.MinShareDifficulty()) < 0 {
+		return errors.New("share difficulty below the minimum")
+	}
+	if share.HumanScore > 100 {
+		return errors.New("human score above 100")
+	}
+	if share.Reward == nil || share.Reward.Cmp(bc.MiningShareReward(share.Difficulty, share.HumanScore)) != 0 {
+		return errors.New("share reward differs from the chain's")
+	}
+
+	var work [32]byte
+	if share.Signature == nil {
+		if share.PoolID != ([20]byte{}) {
+			return errors.New("solo share names a pool")
+		}
+		header := SoloHeader(job.parentHash, share.JobHeight, job.parentTime, share.TemplateRoot, share.MinerAddr, share.Reward, share.Difficulty)
+		work = sha256.Sum256(append(header, uint64ToBytes(share.Nonce)...))
+	} else {
+		if share.TemplateRoot != ([32]byte{}) {
+			return errors.New("signed share names a template")
+		}
+		digest := MiningShareDigest(MiningSharePayload(job.id, share.JobHeight, share.Nonce, share.ShareHash))
+		signer, err := RecoverShareSigner(digest, share.Signature)
+		if err != nil {
+			return fmt.Errorf("invalid share signature: %v", err)
+		}
+		switch {
+		case share.PoolID == ([20]byte{}) && share.MinerAddr != signer:
+			return errors.New("share not signed by its miner")
+		case share.PoolID != ([20]byte{}) && share.MinerAddr != share.PoolID:
+			return errors.New("pool share not credited to its pool")
+		}
+		work = MiningShareWork(job.id, share.MinerAddr, share.Nonce)
+	}
+	if work != share.ShareHash {
+		return errors.New("share hash does not match its work")
+	}
+	if !MeetsShareDifficulty(work, share.Difficulty) {
+		return errors.New("share hash does not meet its difficulty")
+	}
+	return nil
+}
+
+// verifyMiningShares checks a block's raw shares against the chain rules.
+// A block with a mining summary must carry the shares it summarizes.
+// Callers must hold bc.mu with the block's parent as head.
+func (bc *Blockchain) verifyMiningShares(block *Block) error {
+	if len(block.MiningShares) == 0 {
+		if block.Mining.ShareCount > 0 || len(block.Mining.Rewards) > 0 {
+			return fmt.Errorf("%w: mining summary without its shares", ErrInvalidMiningShare)
+		}
+		return nil
+	}
+	verifier, err := bc.newShareVerifier(block.Header.Height, bc.currentBlock)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidMiningShare, err)
+	}
+	for i := range block.MiningShares {
+		if err := verifier.add(&block.MiningShares[i]); err != nil {
+			return fmt.Errorf("%w: share %d: %v", ErrInvalidMiningShare, i, err)
+		}
+	}
+	return nil
+}
+
+// checkMiningIssuance bounds what a block's mining summary accrues: the
+// block total and each address's total for the settlement epoch. Callers
+// must hold bc.mu.
+func (bc *Blockchain) checkMiningIssuance(block *Block, epoch uint64) error {
+	addressCap, blockCap := bc.miningAddressCap(), bc.maxBlockMiningReward()
+	total := big.NewInt(0)
+	for _, reward := range block.Mining.Rewards {
+		if reward.Reward == nil {
+			continue
+		}
+		total.Add(total, reward.Reward)
+		if addressCap == nil {
+			continue
+		}
+		accrued := bc.settlementAmount(settlementSlot("accrued", epoch, reward.Address[:]))
+		if accrued.Add(accrued, reward.Reward).Cmp(addressCap) > 0 {
+			return fmt.Errorf("%w: mining rewards of %x exceed %s this epoch", ErrInvalidMiningShare, reward.Address, addressCap)
+		}
+	}
+	if total.Cmp(blockCap) > 0 {
+		return fmt.Errorf("%w: block mining rewards exceed %s", ErrInvalidMiningShare, blockCap)
+	}
+	return nil
+}
+
+// miningAddressCap returns what one address may accrue in a settlement
+// epoch: the lowest of the governed session and daily caps and
+// Config.MiningAddressCap, or nil if none is set. Callers must hold bc.mu.
+func (bc *Blockchain) miningAddressCap() *big.Int {
+	var limit *big.Int
+	lower := func(value *big.Int) {
+		if value != nil && (limit == nil || value.Cmp(limit) < 0) {
+			limit = value
+		}
+	}
+	lower(bc.config.MiningAddressCap)
+	for _, param := range []GovernanceParam{ParamSessionRewardCap, ParamDailyAddressCap} {
+		if value, governed := bc.governanceParam(param); governed {
+			lower(value)
+		}
+	}
+	return limit
+}
+
+func (bc *Blockchain) maxBlockMiningReward() *big.Int {
+	if bc.config.MaxBlockMiningReward != nil {
+		return bc.config.MaxBlockMiningReward
+	}
+	return DefaultMaxBlockMiningReward
+}
@@ -0,0 +1,146 @@
+package blockchain_test
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/secp256k1"
+)
+
+// minedShare finds work on the next block for key at the minimum share
+// difficulty and returns the signed share
+func minedShare(t *testing.T, chain *blockchain.Blockchain, key testKey) blockchain.MiningShare {
+	t.Helper()
+	head := chain.GetCurrentBlock()
+	height := head.Header.Height + 1
+	jobID := blockchain.MiningJobID(height, head.Hash())
+	difficulty := chain.MinShareDifficulty()
+	share := blockchain.MiningShare{
+		MinerAddr:  key.addr,
+		Difficulty: difficulty,
+		Timestamp:  head.Header.Timestamp,
+		HumanScore: 100,
+		Reward:     chain.MiningShareReward(difficulty, 100),
+		JobHeight:  height,
+	}
+	for ; ; share.Nonce++ {
+		share.ShareHash = blockchain.MiningShareWork(jobID, key.addr, share.Nonce)
+		if blockchain.MeetsShareDifficulty(share.ShareHash, difficulty) {
+			break
+		}
+	}
+	digest := blockchain.MiningShareDigest(blockchain.MiningSharePayload(jobID, height, share.Nonce, share.ShareHash))
+	sig, err := secp256k1.Sign(digest[:], key.priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	share.Signature = sig
+	return share
+}
+
+// proposeMining builds and inserts the next block with the given mining
+// summary and raw shares
+func proposeMining(chain *blockchain.Blockchain, summary blockchain.MiningSummary, shares []blockchain.MiningShare) error {
+	head := chain.GetCurrentBlock()
+	block := &blockchain.Block{
+		Header: blockchain.BlockHeader{
+			Height:     head.Header.Height + 1,
+			PrevHash:   head.Hash(),
+			Timestamp:  head.Header.Timestamp + 12,
+			GasLimit:   head.Header.GasLimit,
+			MiningRoot: summary.Root(),
+		},
+		Mining:       summary,
+		MiningShares: shares,
+	}
+	if err := chain.FillBlock(block); err != nil {
+		return err
+	}
+	return chain.InsertBlock(block)
+}
+
+func TestChainComputesMiningRewards(t *testing.T) {
+	chain, keys := newTestChain(t, 2)
+	miner, other := keys[0], keys[1]
+	minted := new(big.Int).Mul(big.NewInt(1e9), big.NewInt(1e18))
+
+	forged := minedShare(t, chain, miner)
+	forged.Reward = minted
+	redirected := minedShare(t, chain, miner)
+	redirected.MinerAddr = other.addr
+	for name, share := range map[string]blockchain.MiningShare{"with a forged reward": forged, "credited to another miner": redirected} {
+		shares := []blockchain.MiningShare{share}
+		if err := proposeMining(chain, blockchain.SummarizeShares(shares), shares); !errors.Is(err, blockchain.ErrInvalidMiningShare) {
+			t.Fatalf("share %s: %v", name, err)
+		}
+	}
+
+	// A summary without its shares cannot accrue more than a block may
+	bare := blockchain.MiningSummary{ShareCount: 1, Rewards: []blockchain.MiningReward{{Address: miner.addr, Shares: 1, Reward: minted}}}
+	if err := proposeMining(chain, bare, nil); !errors.Is(err, blockchain.ErrInvalidMiningShare) {
+		t.Fatalf("bare summary minting %s: %v", minted, err)
+	}
+
+	// A verified share accrues the chain's reward, once
+	shares := []blockchain.MiningShare{minedShare(t, chain, miner)}
+	if err := proposeMining(chain, blockchain.SummarizeShares(shares), shares); err != nil {
+		t.Fatal(err)
+	}
+	if total := chain.GetEpochRewards(0).Total; total.Cmp(shares[0].Reward) != 0 {
+		t.Fatalf("accrued %s, want %s", total, shares[0].Reward)
+	}
+	if err := proposeMining(chain, blockchain.SummarizeShares(shares), shares); !errors.Is(err, blockchain.ErrInvalidMiningShare) {
+		t.Fatalf("share repeated in the next block: %v", err)
+	}
+}
+
+func TestBlocksCarryTheirShares(t *testing.T) {
+	proposer, importer := newEmptyChain(t), newEmptyChain(t)
+	priv, err := secp256k1.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _ := secp256k1.PublicKey(priv)
+	miner := testKey{priv: priv, addr: secp256k1.PubkeyToAddress(pub)}
+
+	shares := []blockchain.MiningShare{minedShare(t, proposer, miner)}
+	if err := proposeMining(proposer, blockchain.SummarizeShares(shares), shares); err != nil {
+		t.Fatal(err)
+	}
+
+	// The stored block leaves its shares to the share store
+	stored, err := proposer.GetBlock(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.MiningShares != nil {
+		t.Fatal("stored block carries its shares")
+	}
+	if err := importer.InsertBlock(stored); !errors.Is(err, blockchain.ErrInvalidMiningShare) {
+		t.Fatalf("block imported without its shares: %v", err)
+	}
+
+	// Sent with its shares, as peers serve it, the block imports and
+	// accrues the same rewards
+	stored.MiningShares, err = proposer.GetMiningShares(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received blockchain.Block
+	if err := json.Unmarshal(data, &received); err != nil {
+		t.Fatal(err)
+	}
+	if err := importer.InsertBlock(&received); err != nil {
+		t.Fatal(err)
+	}
+	if total := importer.GetEpochRewards(0).Total; total.Cmp(shares[0].Reward) != 0 {
+		t.Fatalf("importer accrued %s, want %s", total, shares[0].Reward)
+	}
+}
@@ -0,0 +1,396 @@
+// Package blockchain - State snapshots for fast sync
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"chaincore/internal/storage"
+)
+
+// Snapshot limits
+const (
+	DefaultSnapshotInterval = 10000 // Blocks between snapshots
+	snapshotChunkSize       = 256 * 1024
+	snapshotEntryOverhead   = 96 // Encoded address and field names per account
+	snapshotsKept           = 2
+)
+
+// Snapshot storage keys. Manifests are keyed by height, chunks by height
+// and index. chainBaseKey holds the height a snapshot-synced chain starts
+// at.
+var (
+	snapshotManifestPrefix = []byte("snap:m:")
+	snapshotChunkPrefix    = []byte("snap:c:")
+	chainBaseKey           = []byte("chain:base")
+)
+
+// Snapshot errors
+var (
+	ErrSnapshotNotFound = errors.New("state snapshot not found")
+	ErrInvalidSnapshot  = errors.New("invalid state snapshot")
+	ErrChainNotEmpty    = errors.New("chain already has blocks beyond genesis")
+)
+
+// SnapshotManifest describes a state snapshot at a block. Restored chunks
+// must match their hashes and rebuild the block's state root.
+type SnapshotManifest struct {
+	Height    uint64     `json:"height"`
+	BlockHash [32]byte   `json:"blockHash"`
+	StateRoot [32]byte   `json:"stateRoot"`
+	Accounts  uint64     `json:"accounts"`
+	Chunks    [][32]byte `json:"chunks"` // SHA-256 of each chunk
+}
+
+// snapshotEntry is one account in a chunk, in its stored encoding
+type snapshotEntry struct {
+	Address [20]byte        `json:"address"`
+	Account json.RawMessage `json:"account"`
+}
+
+// LatestSnapshot returns the manifest of the newest snapshot this node
+// serves: at or below the finalized height, of a block still canonical
+func (bc *Blockchain) LatestSnapshot() (*SnapshotManifest, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	it := bc.db.NewIterator(snapshotManifestPrefix)
+	defer it.Release()
+
+	var latest *SnapshotManifest
+	for it.Next() {
+		var manifest SnapshotManifest
+		if err := json.Unmarshal(it.Value(), &manifest); err != nil {
+			return nil, err
+		}
+		if manifest.Height > bc.finalized {
+			break
+		}
+		block, err := bc.loadBlockByHeight(manifest.Height)
+		if err != nil || block.Hash() != manifest.BlockHash {
+			continue
+		}
+		latest = &manifest
+	}
+	if err := it.Error(); err != nil {
+		return nil, err
+	}
+	if latest == nil {
+		return nil, ErrSnapshotNotFound
+	}
+	return latest, nil
+}
+
+// GetSnapshotChunk returns a chunk of the snapshot at height
+func (bc *Blockchain) GetSnapshotChunk(height uint64, index uint32) ([]byte, error) {
+	data, err := bc.db.Get(snapshotChunkKey(height, index))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrSnapshotNotFound
+	}
+	return data, err
+}
+
+// BaseHeight returns the height of the oldest block stored besides genesis:
+// the snapshot block if the chain was restored from a snapshot, otherwise
+// zero
+func (bc *Blockchain) BaseHeight() uint64 {
+	return bc.chainBase()
+}
+
+// RestoreSnapshot replaces the state of a chain still at genesis with a
+// snapshot and makes its block the head. The chunks must be the snapshot's,
+// in manifest order; they are checked against the manifest and the block's
+// state root before anything is written.
+func (bc *Blockchain) RestoreSnapshot(block *Block, manifest *SnapshotManifest, chunks [][]byte) error {
+	bc.mu.Lock()
+	err := bc.restoreSnapshot(block, manifest, chunks)
+	changes := bc.takeStakingChanges()
+	listeners := bc.stakingListeners
+	bc.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	for _, validator := range changes {
+		for _, fn := range listeners {
+			fn(validator)
+		}
+	}
+	return nil
+}
+
+// Helper functions
+
+// restoreSnapshot verifies and writes a snapshot. Callers must hold bc.mu.
+func (bc *Blockchain) restoreSnapshot(block *Block, manifest *SnapshotManifest, chunks [][]byte) error {
+	if bc.currentBlock.Header.Height != 0 {
+		return ErrChainNotEmpty
+	}
+	if manifest.Height == 0 || block.Header.Height != manifest.Height || block.Hash() != manifest.BlockHash {
+		return fmt.Errorf("%w: block does not match manifest", ErrInvalidSnapshot)
+	}
+	if block.Header.StateRoot != manifest.StateRoot {
+		return fmt.Errorf("%w: state root does not match block %d", ErrInvalidSnapshot, block.Header.Height)
+	}
+	if err := block.VerifyRoots(nil); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+
+	state, err := decodeSnapshot(bc.db, manifest, chunks)
+	if err != nil {
+		return err
+	}
+	if state.Root() != block.Header.StateRoot {
+		return fmt.Errorf("%w: accounts do not match state root of block %d", ErrInvalidSnapshot, block.Header.Height)
+	}
+
+	data, err := json.Marshal(block.withoutShares())
+	if err != nil {
+		return err
+	}
+	height := block.Header.Height
+	batch := bc.db.NewBatch()
+	if err := deletePrefix(bc.db, batch, accountKeyPrefix); err != nil {
+		return err
+	}
+	if err := state.commitTo(batch); err != nil {
+		return err
+	}
+	if err := batch.Put(blockKey(height), data); err != nil {
+		return err
+	}
+	if err := batch.Put(headBlockKey, uint64ToBytes(height)); err != nil {
+		return err
+	}
+	if err := batch.Put(chainBaseKey, uint64ToBytes(height)); err != nil {
+		return err
+	}
+	if err := writeSnapshotData(batch, manifest, chunks); err != nil {
+		return err
+	}
+	if err := writeTxIndex(batch, block); err != nil {
+		return err
+	}
+	// Archive history starts over at the snapshot
+	if bc.config.Archive {
+		if err := state.writeBalanceHistory(batch, height, true); err != nil {
+			return err
+		}
+		if err := batch.Put(historyStartKey, uint64ToBytes(height)); err != nil {
+			return err
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+
+	bc.stateDB = state
+	bc.currentBlock = block
+	if height > bc.finalized {
+		bc.finalized = height
+	}
+	bc.stakingChanges = append(bc.stakingChanges, bc.stakedAddresses()...)
+	return nil
+}
+
+// maybeWriteSnapshot writes a snapshot of the committed state if block is
+// at a snapshot height. Callers must hold bc.mu.
+func (bc *Blockchain) maybeWriteSnapshot(block *Block) error {
+	interval := bc.config.SnapshotInterval
+	height := block.Header.Height
+	if interval == 0 || height == 0 || height%interval != 0 {
+		return nil
+	}
+
+	manifest := &SnapshotManifest{
+		Height:    height,
+		BlockHash: block.Hash(),
+		StateRoot: block.Header.StateRoot,
+		Chunks:    make([][32]byte, 0),
+	}
+	chunks, err := bc.snapshotChunks(manifest)
+	if err != nil {
+		return err
+	}
+
+	batch := bc.db.NewBatch()
+	if height > interval*snapshotsKept {
+		cutoff := height - interval*snapshotsKept
+		for _, prefix := range [][]byte{snapshotManifestPrefix, snapshotChunkPrefix} {
+			if err := deleteThroughHeight(bc.db, batch, prefix, cutoff); err != nil {
+				return err
+			}
+		}
+	}
+	// A reorganization may have written a snapshot at this height before
+	if err := deletePrefix(bc.db, batch, snapshotChunkKey(height, 0)[:len(snapshotChunkPrefix)+8]); err != nil {
+		return err
+	}
+	if err := writeSnapshotData(batch, manifest, chunks); err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
+// snapshotChunks splits the committed accounts into chunks, filling in the
+// manifest's account count and chunk hashes. An account larger than the
+// chunk size gets a chunk of its own.
+func (bc *Blockchain) snapshotChunks(manifest *SnapshotManifest) ([][]byte, error) {
+	it := bc.db.NewIterator(accountKeyPrefix)
+	defer it.Release()
+
+	chunks := make([][]byte, 0)
+	entries, size := make([]snapshotEntry, 0), 0
+	flush := func() error {
+		data, err := json.Marshal(entries)
+		if err != nil {
+			return err
+		}
+		chunks = append(chunks, data)
+		manifest.Chunks = append(manifest.Chunks, sha256.Sum256(data))
+		entries, size = make([]snapshotEntry, 0), 0
+		return nil
+	}
+
+	for it.Next() {
+		if len(it.Key()) != len(accountKeyPrefix)+20 {
+			return nil, errors.New("corrupt account key")
+		}
+		var entry snapshotEntry
+		copy(entry.Address[:], it.Key()[len(accountKeyPrefix):])
+		entry.Account = append(json.RawMessage(nil), it.Value()...)
+
+		entrySize := len(entry.Account) + snapshotEntryOverhead
+		if size > 0 && size+entrySize > snapshotChunkSize {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, entry)
+		size += entrySize
+		manifest.Accounts++
+	}
+	if err := it.Error(); err != nil {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		if err := flush(); err != nil {
+			return nil, err
+		}
+	}
+	return chunks, nil
+}
+
+// decodeSnapshot checks chunks against a manifest and loads their accounts
+// into a new state, every account marked dirty so committing it writes
+// them all
+func decodeSnapshot(db storage.Database, manifest *SnapshotManifest, chunks [][]byte) (*StateDB, error) {
+	if len(chunks) != len(manifest.Chunks) {
+		return nil, fmt.Errorf("%w: have %d chunks, manifest lists %d", ErrInvalidSnapshot, len(chunks), len(manifest.Chunks))
+	}
+
+	state := &StateDB{
+		db:       db,
+		accounts: make(map[[20]byte]*Account),
+		dirty:    make(map[[20]byte]bool),
+		trie:     NewStateTrie(),
+		stale:    make(map[[20]byte]bool),
+	}
+	var last [20]byte
+	for i, chunk := range chunks {
+		if sha256.Sum256(chunk) != manifest.Chunks[i] {
+			return nil, fmt.Errorf("%w: chunk %d does not match its hash", ErrInvalidSnapshot, i)
+		}
+		var entries []snapshotEntry
+		if err := json.Unmarshal(chunk, &entries); err != nil {
+			return nil, fmt.Errorf("%w: chunk %d: %v", ErrInvalidSnapshot, i, err)
+		}
+		for _, entry := range entries {
+			// Strict address order rules out duplicates
+			if len(state.accounts) > 0 && bytes.Compare(entry.Address[:], last[:]) <= 0 {
+				return nil, fmt.Errorf("%w: chunk %d is out of address order", ErrInvalidSnapshot, i)
+			}
+			last = entry.Address
+
+			var record accountRecord
+			if err := json.Unmarshal(entry.Account, &record); err != nil {
+				return nil, fmt.Errorf("%w: account %x: %v", ErrInvalidSnapshot, entry.Address, err)
+			}
+			acc, err := record.toAccount(entry.Address)
+			if err != nil {
+				return nil, fmt.Errorf("%w: account %x: %v", ErrInvalidSnapshot, entry.Address, err)
+			}
+			state.accounts[entry.Address] = acc
+			state.dirty[entry.Address] = true
+			state.stale[entry.Address] = true
+		}
+	}
+	if uint64(len(state.accounts)) != manifest.Accounts {
+		return nil, fmt.Errorf("%w: have %d accounts, manifest lists %d", ErrInvalidSnapshot, len(state.accounts), manifest.Accounts)
+	}
+	return state, nil
+}
+
+// stakedAddresses returns every registered validator. Callers must hold
+// bc.mu.
+func (bc *Blockchain) stakedAddresses() [][20]byte {
+	count := wordToUint64(bc.stateDB.GetState(StakingAddress, stakingSlot("count")))
+	addresses := make([][20]byte, 0, count)
+	for i := uint64(0); i < count; i++ {
+		word := bc.stateDB.GetState(StakingAddress, stakingSlot("index", uint64ToBytes(i)))
+		var addr [20]byte
+		copy(addr[:], word[12:])
+		addresses = append(addresses, addr)
+	}
+	return addresses
+}
+
+// chainBase returns the height the chain was restored from a snapshot at,
+// or zero if it was synced from genesis
+func (bc *Blockchain) chainBase() uint64 {
+	data, err := bc.db.Get(chainBaseKey)
+	if err != nil || len(data) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data)
+}
+
+// writeSnapshotData adds a snapshot's manifest and chunks to batch
+func writeSnapshotData(batch storage.Batch, manifest *SnapshotManifest, chunks [][]byte) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	for i, chunk := range chunks {
+		if err := batch.Put(snapshotChunkKey(manifest.Height, uint32(i)), chunk); err != nil {
+			return err
+		}
+	}
+	return batch.Put(snapshotManifestKey(manifest.Height), data)
+}
+
+// deletePrefix adds deletes for every key under prefix
+func deletePrefix(db storage.Database, batch storage.Batch, prefix []byte) error {
+	it := db.NewIterator(prefix)
+	defer it.Release()
+
+	for it.Next() {
+		if err := batch.Delete(append([]byte(nil), it.Key()...)); err != nil {
+			return err
+		}
+	}
+	return it.Error()
+}
+
+func snapshotManifestKey(height uint64) []byte {
+	return append(append([]byte(nil), snapshotManifestPrefix...), uint64ToBytes(height)...)
+}
+
+func snapshotChunkKey(height uint64, index uint32) []byte {
+	key := append(append([]byte(nil), snapshotChunkPrefix...), uint64ToBytes(height)...)
+	return binary.BigEndian.AppendUint32(key, index)
+}
@@ -0,0 +1,393 @@
+// Package blockchain - Staking and delegation transactions
+package blockchain
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+// StakingAddress is the system account staking transactions are sent to. It
+// holds all staked deposits, and its storage records stakes and validators.
+var StakingAddress = [20]byte{18: 0x01, 19: 0x02}
+
+// stakingMagic prefixes the data field of a staking transaction
+var stakingMagic = []byte("STAK")
+
+// StakingOpType identifies a staking operation
+type StakingOpType uint8
+
+const (
+	StakingOpStake      StakingOpType = iota + 1 // Register or top up a validator's own stake
+	StakingOpUnstake                             // Withdraw own stake
+	StakingOpDelegate                            // Delegate to a validator
+	StakingOpUndelegate                          // Withdraw a delegation
+)
+
+// Staking payload sizes
+const (
+	stakingPubKeyLength = 65 // Uncompressed P-256 consensus key
+	stakingAmountLength = 32
+)
+
+// StakingOp is a decoded staking transaction. Deposits are the transaction
+// value; withdrawals carry their amount in the payload.
+type StakingOp struct {
+	Type      StakingOpType
+	Delegator [20]byte // Transaction sender
+	Validator [20]byte
+	Amount    *big.Int
+	PubKey    []byte // Consensus key, required when first staking
+}
+
+// Delegation is stake a delegator has bonded to a validator
+type Delegation struct {
+	Delegator [20]byte
+	Amount    *big.Int
+}
+
+// StakedValidator is a validator's staking record
+type StakedValidator struct {
+	Address    [20]byte
+	PubKey     []byte
+	SelfStake  *big.Int
+	TotalStake *big.Int // Own stake plus delegations
+	Unbonding  *big.Int // Withdrawn stake not yet released
+	Jailed     bool     // Slashed for double-signing
+
+	PendingKey      []byte // Rotated consensus key not yet in effect, if any
+	PendingKeyEpoch uint64 // Validator epoch the pending key takes effect
+}
+
+// EncodeStake builds the data of a stake transaction. pubKey is the
+// validator's consensus key on first registration and nil for top-ups.
+func EncodeStake(pubKey []byte) []byte {
+	data := append(append([]byte(nil), stakingMagic...), byte(StakingOpStake))
+	return append(data, pubKey...)
+}
+
+// EncodeUnstake builds the data of an unstake transaction
+func EncodeUnstake(amount *big.Int) []byte {
+	data := append(append([]byte(nil), stakingMagic...), byte(StakingOpUnstake))
+	return append(data, bigToBytes32(amount)...)
+}
+
+// EncodeDelegate builds the data of a delegate transaction
+func EncodeDelegate(validator [20]byte) []byte {
+	data := append(append([]byte(nil), stakingMagic...), byte(StakingOpDelegate))
+	return append(data, validator[:]...)
+}
+
+// EncodeUndelegate builds the data of an undelegate transaction
+func EncodeUndelegate(validator [20]byte, amount *big.Int) []byte {
+	data := append(append([]byte(nil), stakingMagic...), byte(StakingOpUndelegate))
+	data = append(data, validator[:]...)
+	return append(data, bigToBytes32(amount)...)
+}
+
+// DecodeStakingTx decodes a transaction sent to StakingAddress
+func DecodeStakingTx(tx *Transaction) (*StakingOp, error) {
+	if tx.To != StakingAddress {
+		return nil, errors.New("not a staking transaction")
+	}
+	if !bytes.HasPrefix(tx.Data, stakingMagic) || len(tx.Data) < len(stakingMagic)+1 {
+		return nil, errors.New("invalid staking payload")
+	}
+
+	op := &StakingOp{
+		Type:      StakingOpType(tx.Data[len(stakingMagic)]),
+		Delegator: tx.From,
+		Validator: tx.From,
+		Amount:    big.NewInt(0),
+	}
+	if tx.Value != nil {
+		op.Amount.Set(tx.Value)
+	}
+	payload := tx.Data[len(stakingMagic)+1:]
+
+	switch op.Type {
+	case StakingOpStake:
+		if len(payload) != 0 && len(payload) != stakingPubKeyLength {
+			return nil, errors.New("invalid consensus key length")
+		}
+		if len(payload) > 0 {
+			op.PubKey = append([]byte(nil), payload...)
+		}
+	case StakingOpUnstake:
+		if len(payload) != stakingAmountLength {
+			return nil, errors.New("invalid unstake payload")
+		}
+		if op.Amount.Sign() != 0 {
+			return nil, errors.New("unstake must not carry value")
+		}
+		op.Amount.SetBytes(payload)
+	case StakingOpDelegate:
+		if len(payload) != 20 {
+			return nil, errors.New("invalid delegate payload")
+		}
+		copy(op.Validator[:], payload)
+	case StakingOpUndelegate:
+		if len(payload) != 20+stakingAmountLength {
+			return nil, errors.New("invalid undelegate payload")
+		}
+		if op.Amount.Sign() != 0 {
+			return nil, errors.New("undelegate must not carry value")
+		}
+		copy(op.Validator[:], payload[:20])
+		op.Amount.SetBytes(payload[20:])
+	default:
+		return nil, errors.New("unknown staking operation")
+	}
+	return op, nil
+}
+
+// GetStakedValidator returns a validator's staking record
+func (bc *Blockchain) GetStakedValidator(addr [20]byte) (*StakedValidator, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.stakedValidator(addr)
+}
+
+// GetStakedValidators returns every address that has registered as a
+// validator, in registration order
+func (bc *Blockchain) GetStakedValidators() []*StakedValidator {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	count := wordToUint64(bc.stateDB.GetState(StakingAddress, stakingSlot("count")))
+	validators := make([]*StakedValidator, 0, count)
+	for i := uint64(0); i < count; i++ {
+		word := bc.stateDB.GetState(StakingAddress, stakingSlot("index", uint64ToBytes(i)))
+		var addr [20]byte
+		copy(addr[:], word[12:])
+		if v, err := bc.stakedValidator(addr); err == nil {
+			validators = append(validators, v)
+		}
+	}
+	return validators
+}
+
+// GetDelegation returns the amount delegator has staked with validator
+func (bc *Blockchain) GetDelegation(validator, delegator [20]byte) *big.Int {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.stakeAmount(validator, delegator)
+}
+
+// GetDelegations returns the delegators of a validator and their current
+// delegations, in the order they first delegated. The validator's own stake
+// is not included.
+func (bc *Blockchain) GetDelegations(validator [20]byte) []*Delegation {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	count := wordToUint64(bc.stateDB.GetState(StakingAddress, stakingSlot("delegator-count", validator[:])))
+	delegations := make([]*Delegation, 0, count)
+	for i := uint64(0); i < count; i++ {
+		word := bc.stateDB.GetState(StakingAddress, stakingSlot("delegator", validator[:], uint64ToBytes(i)))
+		var delegator [20]byte
+		copy(delegator[:], word[12:])
+		amount := bc.stakeAmount(validator, delegator)
+		if amount.Sign() == 0 {
+			continue
+		}
+		delegations = append(delegations, &Delegation{Delegator: delegator, Amount: amount})
+	}
+	return delegations
+}
+
+// OnStakingChange registers a callback for validators whose stake changed in
+// an inserted block. It is called after the chain lock is released.
+func (bc *Blockchain) OnStakingChange(fn func(validator [20]byte)) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	bc.stakingListeners = append(bc.stakingListeners, fn)
+}
+
+// Helper functions
+
+// checkStaking validates a staking operation against current state without
+// changing it. Callers must hold bc.mu.
+func (bc *Blockchain) checkStaking(op *StakingOp) error {
+	registered := bc.isValidator(op.Validator)
+	minStake := bc.config.ValidatorMinStake
+
+	switch op.Type {
+	case StakingOpStake:
+		if op.Amount.Sign() <= 0 {
+			return errors.New("stake must be positive")
+		}
+		if registered {
+			if op.PubKey != nil {
+				return errors.New("validator already registered")
+			}
+			return nil
+		}
+		if op.PubKey == nil {
+			return errors.New("consensus key required to register a validator")
+		}
+		if x, _ := elliptic.Unmarshal(elliptic.P256(), op.PubKey); x == nil {
+			return errors.New("invalid consensus key")
+		}
+		if minStake != nil && op.Amount.Cmp(minStake) < 0 {
+			return errors.New("stake below minimum requirement")
+		}
+	case StakingOpUnstake:
+		if op.Amount.Sign() <= 0 {
+			return errors.New("unstake amount must be positive")
+		}
+		own := bc.stakeAmount(op.Validator, op.Validator)
+		if op.Amount.Cmp(own) > 0 {
+			return errors.New("unstake exceeds stake")
+		}
+		// A validator either stays above the minimum or exits completely
+		remaining := new(big.Int).Sub(own, op.Amount)
+		if remaining.Sign() > 0 && minStake != nil && remaining.Cmp(minStake) < 0 {
+			return errors.New("remaining stake below minimum requirement")
+		}
+	case StakingOpDelegate:
+		if op.Amount.Sign() <= 0 {
+			return errors.New("delegation must be positive")
+		}
+		if op.Validator == op.Delegator {
+			return errors.New("validators stake rather than delegate to themselves")
+		}
+		if !registered {
+			return errors.New("validator not found")
+		}
+	case StakingOpUndelegate:
+		if op.Amount.Sign() <= 0 {
+			return errors.New("undelegate amount must be positive")
+		}
+		if op.Amount.Cmp(bc.stakeAmount(op.Validator, op.Delegator)) > 0 {
+			return errors.New("undelegate exceeds delegation")
+		}
+	}
+	return nil
+}
+
+// applyStaking records a checked staking operation. Deposits have already
+// been transferred to StakingAddress with the transaction value;
+// withdrawals enter the unbonding queue. Callers must hold bc.mu.
+func (bc *Blockchain) applyStaking(op *StakingOp, timestamp uint64) {
+	if op.Type == StakingOpStake && op.PubKey != nil {
+		bc.registerValidator(op.Validator, op.PubKey)
+	}
+
+	if op.Type == StakingOpDelegate {
+		bc.indexDelegator(op.Validator, op.Delegator)
+	}
+
+	delta := new(big.Int).Set(op.Amount)
+	if op.Type == StakingOpUnstake || op.Type == StakingOpUndelegate {
+		delta.Neg(delta)
+		bc.queueUnbonding(op.Validator, op.Delegator, op.Amount, timestamp)
+	}
+
+	stake := new(big.Int).Add(bc.stakeAmount(op.Validator, op.Delegator), delta)
+	bc.stateDB.SetState(StakingAddress, stakingSlot("stake", op.Validator[:], op.Delegator[:]), uint256Word(stake))
+
+	total := new(big.Int).Add(bc.stateWord(stakingSlot("total", op.Validator[:])), delta)
+	bc.stateDB.SetState(StakingAddress, stakingSlot("total", op.Validator[:]), uint256Word(total))
+
+	bc.stakingChanges = append(bc.stakingChanges, op.Validator)
+	bc.emitStakingLog(op)
+}
+
+// registerValidator stores a new validator's consensus key and appends it
+// to the validator index
+func (bc *Blockchain) registerValidator(addr [20]byte, pubKey []byte) {
+	bc.setKey("keyx", "keyy", addr, pubKey)
+
+	count := wordToUint64(bc.stateDB.GetState(StakingAddress, stakingSlot("count")))
+	var word [32]byte
+	copy(word[12:], addr[:])
+	bc.stateDB.SetState(StakingAddress, stakingSlot("index", uint64ToBytes(count)), word)
+	bc.stateDB.SetState(StakingAddress, stakingSlot("count"), uint256Word(new(big.Int).SetUint64(count+1)))
+}
+
+// indexDelegator adds a delegator to the validator's delegator list the
+// first time it delegates
+func (bc *Blockchain) indexDelegator(validator, delegator [20]byte) {
+	known := stakingSlot("delegator-known", validator[:], delegator[:])
+	if bc.stateDB.GetState(StakingAddress, known) != ([32]byte{}) {
+		return
+	}
+	bc.stateDB.SetState(StakingAddress, known, uint256Word(big.NewInt(1)))
+
+	countSlot := stakingSlot("delegator-count", validator[:])
+	count := wordToUint64(bc.stateDB.GetState(StakingAddress, countSlot))
+	var word [32]byte
+	copy(word[12:], delegator[:])
+	bc.stateDB.SetState(StakingAddress, stakingSlot("delegator", validator[:], uint64ToBytes(count)), word)
+	bc.stateDB.SetState(StakingAddress, countSlot, uint256Word(new(big.Int).SetUint64(count+1)))
+}
+
+func (bc *Blockchain) stakedValidator(addr [20]byte) (*StakedValidator, error) {
+	if !bc.isValidator(addr) {
+		return nil, errors.New("validator not found")
+	}
+	pending, epoch := bc.pendingKey(addr)
+
+	return &StakedValidator{
+		Address:         addr,
+		PubKey:          bc.key("keyx", "keyy", addr),
+		SelfStake:       bc.stakeAmount(addr, addr),
+		TotalStake:      bc.stateWord(stakingSlot("total", addr[:])),
+		Unbonding:       bc.stateWord(stakingSlot("unbonding", addr[:])),
+		Jailed:          bc.stateWord(stakingSlot("jailed", addr[:])).Sign() != 0,
+		PendingKey:      pending,
+		PendingKeyEpoch: epoch,
+	}, nil
+}
+
+// isValidator reports whether addr has registered a consensus key. A key
+// coordinate is never zero for a valid P-256 point.
+func (bc *Blockchain) isValidator(addr [20]byte) bool {
+	return bc.stateDB.GetState(StakingAddress, stakingSlot("keyx", addr[:])) != ([32]byte{})
+}
+
+func (bc *Blockchain) stakeAmount(validator, delegator [20]byte) *big.Int {
+	return bc.stateWord(stakingSlot("stake", validator[:], delegator[:]))
+}
+
+func (bc *Blockchain) stateWord(slot [32]byte) *big.Int {
+	word := bc.stateDB.GetState(StakingAddress, slot)
+	return new(big.Int).SetBytes(word[:])
+}
+
+// takeStakingChanges returns and clears the validators changed since the
+// last call. Callers must hold bc.mu.
+func (bc *Blockchain) takeStakingChanges() [][20]byte {
+	changes := bc.stakingChanges
+	bc.stakingChanges = nil
+	return changes
+}
+
+// stakingSlot derives a storage slot of StakingAddress
+func stakingSlot(kind string, parts ...[]byte) [32]byte {
+	h := sha256.New()
+	h.Write([]byte(kind))
+	for _, part := range parts {
+		h.Write(part)
+	}
+	var slot [32]byte
+	h.Sum(slot[:0])
+	return slot
+}
+
+func uint256Word(n *big.Int) [32]byte {
+	var word [32]byte
+	n.FillBytes(word[:])
+	return word
+}
+
+func wordToUint64(word [32]byte) uint64 {
+	return binary.BigEndian.Uint64(word[24:])
+}
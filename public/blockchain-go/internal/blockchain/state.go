@@ -2,6 +2,8 @@
 package blockchain
 
 import (
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"math/big"
 	"sync"
@@ -15,47 +17,57 @@ type Account struct {
 	Nonce    uint64
 	Balance  *big.Int
 	CodeHash [32]byte // For contracts
+	Code     []byte   // Contract code; never changed once set
 	Storage  map[[32]byte][32]byte
 }
 
 // StateDB manages the blockchain state
 type StateDB struct {
-	db       storage.Database
-	accounts map[[20]byte]*Account
-	dirty    map[[20]byte]bool
-	mu       sync.RWMutex
+	db        storage.Database
+	accounts  map[[20]byte]*Account
+	dirty     map[[20]byte]bool
+	snapshots []map[[20]byte]*Account // Pre-images since each snapshot; nil marks a created account
+	trie      *StateTrie              // Account trie, updated lazily from stale
+	stale     map[[20]byte]bool       // Accounts changed since the trie was last updated
+	mu        sync.RWMutex
 }
 
-// NewStateDB creates a new state database
+// accountKeyPrefix is the storage keyspace for committed accounts
+var accountKeyPrefix = []byte("acct:")
+
+// NewStateDB creates a new state database, loading committed accounts
 func NewStateDB(db storage.Database) (*StateDB, error) {
-	return &StateDB{
+	s := &StateDB{
 		db:       db,
 		accounts: make(map[[20]byte]*Account),
 		dirty:    make(map[[20]byte]bool),
-	}, nil
+		trie:     NewStateTrie(),
+		stale:    make(map[[20]byte]bool),
+	}
+	if err := s.loadAccounts(); err != nil {
+		return nil, err
+	}
+	for addr := range s.accounts {
+		s.stale[addr] = true
+	}
+	return s, nil
 }
 
-// GetAccount retrieves an account, creating if not exists
+// GetAccount retrieves an account. Unknown addresses yield an empty account
+// that is not added to state, so reads never change the state root.
 func (s *StateDB) GetAccount(addr [20]byte) *Account {
 	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	if acc, exists := s.accounts[addr]; exists {
-		s.mu.RUnlock()
 		return acc
 	}
-	s.mu.RUnlock()
-
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	// Load from database or create new
-	acc := &Account{
+	return &Account{
 		Address: addr,
 		Nonce:   0,
 		Balance: big.NewInt(0),
 		Storage: make(map[[32]byte][32]byte),
 	}
-	s.accounts[addr] = acc
-	return acc
 }
 
 // SetBalance sets the balance of an account
@@ -132,34 +144,100 @@ func (s *StateDB) ValidateNonce(addr [20]byte, nonce uint64) error {
 	return nil
 }
 
-// Commit persists all dirty accounts to the database
-func (s *StateDB) Commit() error {
+// GetCode returns the contract code of an account, nil if it has none
+func (s *StateDB) GetCode(addr [20]byte) []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if acc, exists := s.accounts[addr]; exists {
+		return acc.Code
+	}
+	return nil
+}
+
+// SetCode sets the contract code of an account and its code hash
+func (s *StateDB) SetCode(addr [20]byte, code []byte) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	for addr := range s.dirty {
-		acc := s.accounts[addr]
-		if err := s.persistAccount(acc); err != nil {
-			return err
-		}
+	acc := s.getOrCreateAccount(addr)
+	acc.Code = append([]byte(nil), code...)
+	acc.CodeHash = keccak256Hash(code)
+	s.dirty[addr] = true
+}
+
+// GetState returns a storage slot of an account, zero if unset
+func (s *StateDB) GetState(addr [20]byte, key [32]byte) [32]byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if acc, exists := s.accounts[addr]; exists {
+		return acc.Storage[key]
 	}
-	s.dirty = make(map[[20]byte]bool)
-	return nil
+	return [32]byte{}
+}
+
+// SetState sets a storage slot of an account. A zero value clears the slot.
+func (s *StateDB) SetState(addr [20]byte, key, value [32]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acc := s.getOrCreateAccount(addr)
+	if value == ([32]byte{}) {
+		delete(acc.Storage, key)
+	} else {
+		acc.Storage[key] = value
+	}
+	s.dirty[addr] = true
 }
 
-// Snapshot creates a state snapshot for rollback
+// Commit persists all dirty accounts to the database
+func (s *StateDB) Commit() error {
+	batch := s.db.NewBatch()
+	if err := s.commitTo(batch); err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
+// Snapshot creates a state snapshot for rollback. Snapshots stay valid
+// until the next commit.
 func (s *StateDB) Snapshot() int {
-	// Implement snapshot for transaction rollback
-	return 0
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.snapshots = append(s.snapshots, make(map[[20]byte]*Account))
+	return len(s.snapshots) - 1
 }
 
 // RevertToSnapshot reverts to a previous snapshot
 func (s *StateDB) RevertToSnapshot(id int) {
-	// Implement rollback
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id < 0 || id >= len(s.snapshots) {
+		return
+	}
+
+	// Undo newest changes first so older pre-images win
+	for i := len(s.snapshots) - 1; i >= id; i-- {
+		for addr, prev := range s.snapshots[i] {
+			s.stale[addr] = true
+			if prev == nil {
+				delete(s.accounts, addr)
+				delete(s.dirty, addr)
+			} else {
+				s.accounts[addr] = prev
+			}
+		}
+	}
+	s.snapshots = s.snapshots[:id]
 }
 
 // Helper functions
 func (s *StateDB) getOrCreateAccount(addr [20]byte) *Account {
+	s.journal(addr)
+	s.stale[addr] = true
 	if acc, exists := s.accounts[addr]; exists {
 		return acc
 	}
@@ -173,7 +251,150 @@ func (s *StateDB) getOrCreateAccount(addr [20]byte) *Account {
 	return acc
 }
 
-func (s *StateDB) persistAccount(acc *Account) error {
-	// Serialize and save to database
+// commitTo adds dirty accounts to batch so they are written atomically with
+// whatever else the caller puts in it
+func (s *StateDB) commitTo(batch storage.Batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for addr := range s.dirty {
+		acc := s.accounts[addr]
+		if err := s.persistAccount(batch, acc); err != nil {
+			return err
+		}
+	}
+	s.dirty = make(map[[20]byte]bool)
+	s.snapshots = nil
+	return nil
+}
+
+// journal records the pre-image of an account about to be modified in the
+// newest snapshot. Callers must hold s.mu.
+func (s *StateDB) journal(addr [20]byte) {
+	if len(s.snapshots) == 0 {
+		return
+	}
+	latest := s.snapshots[len(s.snapshots)-1]
+	if _, seen := latest[addr]; seen {
+		return
+	}
+
+	acc, exists := s.accounts[addr]
+	if !exists {
+		latest[addr] = nil
+		return
+	}
+	copied := *acc
+	copied.Balance = new(big.Int).Set(acc.Balance)
+	copied.Storage = make(map[[32]byte][32]byte, len(acc.Storage))
+	for k, v := range acc.Storage {
+		copied.Storage[k] = v
+	}
+	latest[addr] = &copied
+}
+
+// accountRecord is the stored encoding of an account
+type accountRecord struct {
+	Nonce    uint64            `json:"nonce"`
+	Balance  string            `json:"balance"`
+	CodeHash string            `json:"codeHash,omitempty"`
+	Code     string            `json:"code,omitempty"`
+	Storage  map[string]string `json:"storage,omitempty"`
+}
+
+func (s *StateDB) persistAccount(batch storage.Batch, acc *Account) error {
+	record := accountRecord{
+		Nonce:   acc.Nonce,
+		Balance: acc.Balance.String(),
+	}
+	if acc.CodeHash != ([32]byte{}) {
+		record.CodeHash = hex.EncodeToString(acc.CodeHash[:])
+		record.Code = hex.EncodeToString(acc.Code)
+	}
+	if len(acc.Storage) > 0 {
+		record.Storage = make(map[string]string, len(acc.Storage))
+		for k, v := range acc.Storage {
+			record.Storage[hex.EncodeToString(k[:])] = hex.EncodeToString(v[:])
+		}
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return batch.Put(accountKey(acc.Address), data)
+}
+
+func (s *StateDB) loadAccounts() error {
+	it := s.db.NewIterator(accountKeyPrefix)
+	defer it.Release()
+
+	for it.Next() {
+		var addr [20]byte
+		if len(it.Key()) != len(accountKeyPrefix)+20 {
+			return errors.New("corrupt account key")
+		}
+		copy(addr[:], it.Key()[len(accountKeyPrefix):])
+
+		var record accountRecord
+		if err := json.Unmarshal(it.Value(), &record); err != nil {
+			return err
+		}
+		acc, err := record.toAccount(addr)
+		if err != nil {
+			return err
+		}
+		s.accounts[addr] = acc
+	}
+	return it.Error()
+}
+
+func (r *accountRecord) toAccount(addr [20]byte) (*Account, error) {
+	balance, ok := new(big.Int).SetString(r.Balance, 10)
+	if !ok {
+		return nil, errors.New("corrupt account balance")
+	}
+	acc := &Account{
+		Address: addr,
+		Nonce:   r.Nonce,
+		Balance: balance,
+		Storage: make(map[[32]byte][32]byte, len(r.Storage)),
+	}
+	if err := decodeHex32(r.CodeHash, &acc.CodeHash); err != nil {
+		return nil, err
+	}
+	if acc.CodeHash != ([32]byte{}) {
+		code, err := hex.DecodeString(r.Code)
+		if err != nil || keccak256Hash(code) != acc.CodeHash {
+			return nil, errors.New("corrupt account code")
+		}
+		acc.Code = code
+	}
+	for k, v := range r.Storage {
+		var key, value [32]byte
+		if err := decodeHex32(k, &key); err != nil {
+			return nil, err
+		}
+		if err := decodeHex32(v, &value); err != nil {
+			return nil, err
+		}
+		acc.Storage[key] = value
+	}
+	return acc, nil
+}
+
+func accountKey(addr [20]byte) []byte {
+	return append(append([]byte(nil), accountKeyPrefix...), addr[:]...)
+}
+
+func decodeHex32(s string, out *[32]byte) error {
+	if s == "" {
+		return nil
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 32 {
+		return errors.New("corrupt account encoding")
+	}
+	copy(out[:], b)
 	return nil
 }
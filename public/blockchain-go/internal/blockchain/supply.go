@@ -0,0 +1,100 @@
+// Package blockchain - Transfer burns and supply accounting
+package blockchain
+
+import (
+	"math/big"
+
+	"chaincore/internal/genesis"
+)
+
+// Supply counters, kept under SettlementAddress with the other issuance
+// records
+var (
+	supplyMintedSlot = stakingSlot("supply:minted")
+	supplyBurnedSlot = stakingSlot("supply:burned")
+)
+
+// BurnRateDenominator is the unit of Config.TransferBurnRate: a rate of
+// 1000 burns a thousandth of each transfer
+const BurnRateDenominator = 1_000_000
+
+// Supply describes the token supply at the head
+type Supply struct {
+	Total       *big.Int // Minted less burned
+	Circulating *big.Int // Total less staked, treasury and unvested amounts
+	Burned      *big.Int
+}
+
+// GetSupply returns the token supply at the head
+func (bc *Blockchain) GetSupply() *Supply {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	burned := bc.supplyCounter(supplyBurnedSlot)
+	total := bc.supplyCounter(supplyMintedSlot)
+	total.Sub(total, burned)
+
+	circulating := new(big.Int).Sub(total, bc.stateDB.GetAccount(StakingAddress).Balance)
+	circulating.Sub(circulating, bc.stateDB.GetAccount(TreasuryAddress).Balance)
+	if bc.config.Vesting != nil {
+		circulating.Sub(circulating, bc.config.Vesting.TotalLocked(bc.currentBlock.Header.Timestamp))
+	}
+	if circulating.Sign() < 0 {
+		circulating.SetInt64(0)
+	}
+
+	return &Supply{Total: total, Circulating: circulating, Burned: burned}
+}
+
+// TransferBurn returns the part of a transfer of value to the address that
+// is burned: Config.TransferBurnRate millionths of it, rounded down, and
+// all of it sent to the burn address
+func (bc *Blockchain) TransferBurn(to [20]byte, value *big.Int) *big.Int {
+	if value == nil || value.Sign() <= 0 || to == StakingAddress {
+		return big.NewInt(0)
+	}
+	if to == genesis.BurnAddress() {
+		return new(big.Int).Set(value)
+	}
+	burn := new(big.Int).Mul(value, new(big.Int).SetUint64(bc.config.TransferBurnRate))
+	return burn.Div(burn, big.NewInt(BurnRateDenominator))
+}
+
+// Helper functions
+
+// creditTransfer credits a transfer in a block at height from the sender
+// to its recipient less the burn, and the burn to the burn address, logging
+// both and recording deposits into the treasury. Callers must hold bc.mu.
+func (bc *Blockchain) creditTransfer(from, to [20]byte, value *big.Int, height uint64) {
+	burn := bc.TransferBurn(to, value)
+	received := new(big.Int).Sub(value, burn)
+	if burn.Sign() > 0 {
+		bc.addSupply(supplyBurnedSlot, burn)
+		bc.emitAmountLog(TopicBurn, from, burn)
+	}
+	if burn.Sign() == 0 || to == genesis.BurnAddress() {
+		bc.stateDB.AddBalance(to, value)
+	} else {
+		bc.stateDB.AddBalance(genesis.BurnAddress(), burn)
+		bc.stateDB.AddBalance(to, received)
+	}
+	if received.Sign() > 0 {
+		word := uint256Word(received)
+		bc.emitLog(NativeTokenAddress, word[:], TopicTransfer, AddressTopic(from), AddressTopic(to))
+		if to == TreasuryAddress {
+			bc.recordTreasuryDeposit(from, received, height)
+		}
+	}
+}
+
+// addSupply adds to a supply counter. Callers must hold bc.mu.
+func (bc *Blockchain) addSupply(slot [32]byte, amount *big.Int) {
+	counter := bc.supplyCounter(slot)
+	bc.stateDB.SetState(SettlementAddress, slot, uint256Word(counter.Add(counter, amount)))
+}
+
+// supplyCounter reads a supply counter. Callers must hold bc.mu.
+func (bc *Blockchain) supplyCounter(slot [32]byte) *big.Int {
+	word := bc.stateDB.GetState(SettlementAddress, slot)
+	return new(big.Int).SetBytes(word[:])
+}
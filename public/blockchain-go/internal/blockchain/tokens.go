@@ -0,0 +1,428 @@
+// Package blockchain - Native token registry
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+)
+
+// TokenRegistryAddress is the system account token operations are sent to.
+// It never holds funds; its storage records every token, balance and
+// allowance.
+var TokenRegistryAddress = [20]byte{18: 0x01, 19: 0x07}
+
+// tokenMagic prefixes the data field of a token operation, which is
+//
+//	"TOKN" ‖ 1 ‖ decimals ‖ supply ‖ len(name) ‖ name ‖ symbol   create
+//	"TOKN" ‖ 2 ‖ token ‖ to ‖ amount                             transfer
+//	"TOKN" ‖ 3 ‖ token ‖ spender ‖ amount                        approve
+//	"TOKN" ‖ 4 ‖ token ‖ from ‖ to ‖ amount                      transferFrom
+//
+// with the supply and amounts 32 bytes big-endian
+var tokenMagic = []byte("TOKN")
+
+// TokenOpType identifies a token operation
+type TokenOpType uint8
+
+const (
+	TokenOpCreate       TokenOpType = iota + 1 // Create a token, its supply going to the sender
+	TokenOpTransfer                            // Send the sender's tokens
+	TokenOpApprove                             // Set a spender's allowance over the sender's tokens
+	TokenOpTransferFrom                        // Send tokens out of an allowance
+)
+
+// Token limits and gas
+const (
+	MaxTokenNameLength   = 32
+	MaxTokenSymbolLength = 11
+	MaxTokenDecimals     = 18
+	TokenCreateGas       = 50000 // Creating a token, on top of the intrinsic gas
+	TokenOpGas           = 30000 // Any other token operation, on top of the intrinsic gas
+	tokenAmountLength    = 32
+)
+
+// Topics of the token events
+var (
+	TopicApproval     = EventTopic("Approval(address,address,uint256)")
+	TopicTokenCreated = EventTopic("TokenCreated(address,address)")
+)
+
+// Selectors of the ERC-20 reads calls to a token serve
+var (
+	selectorName        = methodSelector("name()")
+	selectorSymbol      = methodSelector("symbol()")
+	selectorDecimals    = methodSelector("decimals()")
+	selectorTotalSupply = methodSelector("totalSupply()")
+	selectorBalanceOf   = methodSelector("balanceOf(address)")
+	selectorAllowance   = methodSelector("allowance(address,address)")
+)
+
+// TokenOp is a decoded token operation
+type TokenOp struct {
+	Type     TokenOpType
+	Sender   [20]byte
+	Token    [20]byte // For a creation, the address the token is created at
+	From     [20]byte // Whose tokens move: the sender, or the owner of an allowance
+	To       [20]byte // Recipient, or the spender of an approval
+	Amount   *big.Int // Supply of a creation
+	Name     string
+	Symbol   string
+	Decimals uint8
+}
+
+// TokenInfo describes a registered token
+type TokenInfo struct {
+	Address     [20]byte
+	Creator     [20]byte
+	Name        string
+	Symbol      string
+	Decimals    uint8
+	TotalSupply *big.Int
+}
+
+// TokenAddress returns the address a token created by creator with nonce
+// is registered at
+func TokenAddress(creator [20]byte, nonce uint64) [20]byte {
+	return ContractAddress(creator, nonce)
+}
+
+// EncodeCreateToken builds the data of a token creation
+func EncodeCreateToken(name, symbol string, decimals uint8, supply *big.Int) []byte {
+	data := append(append([]byte(nil), tokenMagic...), byte(TokenOpCreate), decimals)
+	data = append(data, bigToBytes32(supply)...)
+	data = append(data, byte(len(name)))
+	data = append(data, name...)
+	return append(data, symbol...)
+}
+
+// EncodeTokenTransfer builds the data of a token transfer
+func EncodeTokenTransfer(token, to [20]byte, amount *big.Int) []byte {
+	data := append(append([]byte(nil), tokenMagic...), byte(TokenOpTransfer))
+	data = append(append(data, token[:]...), to[:]...)
+	return append(data, bigToBytes32(amount)...)
+}
+
+// EncodeTokenApprove builds the data of a token approval
+func EncodeTokenApprove(token, spender [20]byte, amount *big.Int) []byte {
+	data := append(append([]byte(nil), tokenMagic...), byte(TokenOpApprove))
+	data = append(append(data, token[:]...), spender[:]...)
+	return append(data, bigToBytes32(amount)...)
+}
+
+// EncodeTokenTransferFrom builds the data of a transfer out of an allowance
+func EncodeTokenTransferFrom(token, from, to [20]byte, amount *big.Int) []byte {
+	data := append(append([]byte(nil), tokenMagic...), byte(TokenOpTransferFrom))
+	data = append(append(append(data, token[:]...), from[:]...), to[:]...)
+	return append(data, bigToBytes32(amount)...)
+}
+
+// TokenOpGasLimit returns the least gas a token operation with data needs
+func TokenOpGasLimit(data []byte) uint64 {
+	if len(data) > len(tokenMagic) && TokenOpType(data[len(tokenMagic)]) == TokenOpCreate {
+		return IntrinsicGas(data) + TokenCreateGas
+	}
+	return IntrinsicGas(data) + TokenOpGas
+}
+
+// DecodeTokenTx decodes a transaction sent to TokenRegistryAddress
+func DecodeTokenTx(tx *Transaction) (*TokenOp, error) {
+	if tx.To != TokenRegistryAddress {
+		return nil, errors.New("not a token operation")
+	}
+	if !bytes.HasPrefix(tx.Data, tokenMagic) || len(tx.Data) < len(tokenMagic)+1 {
+		return nil, errors.New("invalid token payload")
+	}
+	if tx.Value != nil && tx.Value.Sign() != 0 {
+		return nil, errors.New("token operations must not carry value")
+	}
+	if tx.GasLimit < TokenOpGasLimit(tx.Data) {
+		return nil, errors.New("gas limit below token operation gas")
+	}
+
+	op := &TokenOp{
+		Type:   TokenOpType(tx.Data[len(tokenMagic)]),
+		Sender: tx.From,
+		From:   tx.From,
+		Amount: big.NewInt(0),
+	}
+	payload := tx.Data[len(tokenMagic)+1:]
+
+	switch op.Type {
+	case TokenOpCreate:
+		if len(payload) < 1+tokenAmountLength+1 {
+			return nil, errors.New("invalid token creation payload")
+		}
+		op.Decimals = payload[0]
+		op.Amount.SetBytes(payload[1 : 1+tokenAmountLength])
+		rest := payload[1+tokenAmountLength:]
+		nameLength := int(rest[0])
+		if len(rest) < 1+nameLength {
+			return nil, errors.New("invalid token creation payload")
+		}
+		op.Name = string(rest[1 : 1+nameLength])
+		op.Symbol = string(rest[1+nameLength:])
+		op.Token = TokenAddress(tx.From, tx.Nonce)
+		op.To = tx.From
+		if op.Decimals > MaxTokenDecimals {
+			return nil, errors.New("too many token decimals")
+		}
+		if !validTokenString(op.Name, MaxTokenNameLength) {
+			return nil, errors.New("invalid token name")
+		}
+		if !validTokenString(op.Symbol, MaxTokenSymbolLength) {
+			return nil, errors.New("invalid token symbol")
+		}
+	case TokenOpTransfer, TokenOpApprove:
+		if len(payload) != 20+20+tokenAmountLength {
+			return nil, errors.New("invalid token operation payload")
+		}
+		copy(op.Token[:], payload[:20])
+		copy(op.To[:], payload[20:40])
+		op.Amount.SetBytes(payload[40:])
+	case TokenOpTransferFrom:
+		if len(payload) != 20+20+20+tokenAmountLength {
+			return nil, errors.New("invalid token operation payload")
+		}
+		copy(op.Token[:], payload[:20])
+		copy(op.From[:], payload[20:40])
+		copy(op.To[:], payload[40:60])
+		op.Amount.SetBytes(payload[60:])
+	default:
+		return nil, errors.New("unknown token operation")
+	}
+	return op, nil
+}
+
+// GetToken returns a registered token
+func (bc *Blockchain) GetToken(token [20]byte) (*TokenInfo, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.tokenInfo(token)
+}
+
+// GetTokens returns up to limit registered tokens from offset, in creation
+// order, and the number of tokens
+func (bc *Blockchain) GetTokens(offset, limit uint64) ([]*TokenInfo, uint64) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	count := wordToUint64(bc.stateDB.GetState(TokenRegistryAddress, tokenSlot("count")))
+	tokens := make([]*TokenInfo, 0)
+	for i := offset; i < count && uint64(len(tokens)) < limit; i++ {
+		word := bc.stateDB.GetState(TokenRegistryAddress, tokenSlot("index", uint64ToBytes(i)))
+		var addr [20]byte
+		copy(addr[:], word[12:])
+		if info, err := bc.tokenInfo(addr); err == nil {
+			tokens = append(tokens, info)
+		}
+	}
+	return tokens, count
+}
+
+// GetTokenBalance returns an account's balance of a token
+func (bc *Blockchain) GetTokenBalance(token, owner [20]byte) (*big.Int, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	if !bc.isToken(token) {
+		return nil, errors.New("token not found")
+	}
+	return bc.tokenWord(tokenSlot("balance", token[:], owner[:])), nil
+}
+
+// GetTokenAllowance returns what spender may transfer of owner's tokens
+func (bc *Blockchain) GetTokenAllowance(token, owner, spender [20]byte) (*big.Int, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	if !bc.isToken(token) {
+		return nil, errors.New("token not found")
+	}
+	return bc.tokenWord(tokenSlot("allowance", token[:], owner[:], spender[:])), nil
+}
+
+// Helper functions
+
+// checkToken validates a token operation against current state without
+// changing it. Callers must hold bc.mu.
+func (bc *Blockchain) checkToken(op *TokenOp) error {
+	if op.Type == TokenOpCreate {
+		if bc.isToken(op.Token) || len(bc.stateDB.GetCode(op.Token)) > 0 {
+			return errors.New("token address already in use")
+		}
+		return nil
+	}
+
+	if !bc.isToken(op.Token) {
+		return errors.New("token not found")
+	}
+	if op.Type == TokenOpApprove {
+		return nil
+	}
+	if op.Amount.Cmp(bc.tokenWord(tokenSlot("balance", op.Token[:], op.From[:]))) > 0 {
+		return errors.New("token amount exceeds balance")
+	}
+	if op.Type == TokenOpTransferFrom {
+		allowance := bc.tokenWord(tokenSlot("allowance", op.Token[:], op.From[:], op.Sender[:]))
+		if op.Amount.Cmp(allowance) > 0 {
+			return errors.New("token amount exceeds allowance")
+		}
+	}
+	return nil
+}
+
+// applyToken records a checked token operation and logs it. Callers must
+// hold bc.mu.
+func (bc *Blockchain) applyToken(op *TokenOp) {
+	amount := uint256Word(op.Amount)
+	switch op.Type {
+	case TokenOpCreate:
+		bc.registerToken(op)
+		bc.setTokenWord(tokenSlot("balance", op.Token[:], op.To[:]), op.Amount)
+		bc.emitLog(TokenRegistryAddress, nil, TopicTokenCreated, AddressTopic(op.Token), AddressTopic(op.Sender))
+		bc.emitLog(op.Token, amount[:], TopicTransfer, AddressTopic([20]byte{}), AddressTopic(op.To))
+	case TokenOpApprove:
+		bc.setTokenWord(tokenSlot("allowance", op.Token[:], op.From[:], op.To[:]), op.Amount)
+		bc.emitLog(op.Token, amount[:], TopicApproval, AddressTopic(op.From), AddressTopic(op.To))
+	case TokenOpTransfer, TokenOpTransferFrom:
+		if op.Type == TokenOpTransferFrom {
+			slot := tokenSlot("allowance", op.Token[:], op.From[:], op.Sender[:])
+			bc.setTokenWord(slot, new(big.Int).Sub(bc.tokenWord(slot), op.Amount))
+		}
+		from := tokenSlot("balance", op.Token[:], op.From[:])
+		bc.setTokenWord(from, new(big.Int).Sub(bc.tokenWord(from), op.Amount))
+		to := tokenSlot("balance", op.Token[:], op.To[:])
+		bc.setTokenWord(to, new(big.Int).Add(bc.tokenWord(to), op.Amount))
+		bc.emitLog(op.Token, amount[:], TopicTransfer, AddressTopic(op.From), AddressTopic(op.To))
+	}
+}
+
+// registerToken stores a new token's description and appends it to the
+// token index
+func (bc *Blockchain) registerToken(op *TokenOp) {
+	var creator, name, symbol [32]byte
+	copy(creator[12:], op.Sender[:])
+	copy(name[:], op.Name)
+	copy(symbol[:], op.Symbol)
+	bc.stateDB.SetState(TokenRegistryAddress, tokenSlot("creator", op.Token[:]), creator)
+	bc.stateDB.SetState(TokenRegistryAddress, tokenSlot("name", op.Token[:]), name)
+	bc.stateDB.SetState(TokenRegistryAddress, tokenSlot("symbol", op.Token[:]), symbol)
+	bc.setTokenWord(tokenSlot("decimals", op.Token[:]), big.NewInt(int64(op.Decimals)))
+	bc.setTokenWord(tokenSlot("supply", op.Token[:]), op.Amount)
+
+	count := wordToUint64(bc.stateDB.GetState(TokenRegistryAddress, tokenSlot("count")))
+	bc.stateDB.SetState(TokenRegistryAddress, tokenSlot("index", uint64ToBytes(count)), AddressTopic(op.Token))
+	bc.setTokenWord(tokenSlot("count"), new(big.Int).SetUint64(count+1))
+}
+
+func (bc *Blockchain) tokenInfo(token [20]byte) (*TokenInfo, error) {
+	if !bc.isToken(token) {
+		return nil, errors.New("token not found")
+	}
+	creator := bc.stateDB.GetState(TokenRegistryAddress, tokenSlot("creator", token[:]))
+	name := bc.stateDB.GetState(TokenRegistryAddress, tokenSlot("name", token[:]))
+	symbol := bc.stateDB.GetState(TokenRegistryAddress, tokenSlot("symbol", token[:]))
+
+	info := &TokenInfo{
+		Address:     token,
+		Name:        string(bytes.TrimRight(name[:], "\x00")),
+		Symbol:      string(bytes.TrimRight(symbol[:], "\x00")),
+		Decimals:    uint8(bc.tokenWord(tokenSlot("decimals", token[:])).Uint64()),
+		TotalSupply: bc.tokenWord(tokenSlot("supply", token[:])),
+	}
+	copy(info.Creator[:], creator[12:])
+	return info, nil
+}
+
+// callToken serves an ERC-20 read of a token for the VM, charging a
+// storage read, and reverts anything else
+func (v *vm) callToken(token [20]byte, input []byte, gas uint64) ([]byte, uint64, error) {
+	if gas < sloadGas {
+		return nil, 0, ErrOutOfGas
+	}
+	gas -= sloadGas
+
+	info, err := v.bc.tokenInfo(token)
+	if err != nil || len(input) < 4 {
+		return nil, gas, &RevertError{}
+	}
+	// Address arguments, missing ones read as zero
+	var owner, spender [20]byte
+	args := input[4:]
+	if len(args) >= 32 {
+		copy(owner[:], args[12:32])
+	}
+	if len(args) >= 64 {
+		copy(spender[:], args[44:64])
+	}
+
+	var out [32]byte
+	switch [4]byte(input[:4]) {
+	case selectorName:
+		return abiString(info.Name), gas, nil
+	case selectorSymbol:
+		return abiString(info.Symbol), gas, nil
+	case selectorDecimals:
+		out = uint256Word(big.NewInt(int64(info.Decimals)))
+	case selectorTotalSupply:
+		out = uint256Word(info.TotalSupply)
+	case selectorBalanceOf:
+		out = v.bc.stateDB.GetState(TokenRegistryAddress, tokenSlot("balance", token[:], owner[:]))
+	case selectorAllowance:
+		out = v.bc.stateDB.GetState(TokenRegistryAddress, tokenSlot("allowance", token[:], owner[:], spender[:]))
+	default:
+		return nil, gas, &RevertError{}
+	}
+	return out[:], gas, nil
+}
+
+// isToken reports whether a token is registered at addr. Every token has a
+// creator, and no transaction comes from the zero address.
+func (bc *Blockchain) isToken(addr [20]byte) bool {
+	return bc.stateDB.GetState(TokenRegistryAddress, tokenSlot("creator", addr[:])) != ([32]byte{})
+}
+
+func (bc *Blockchain) tokenWord(slot [32]byte) *big.Int {
+	word := bc.stateDB.GetState(TokenRegistryAddress, slot)
+	return new(big.Int).SetBytes(word[:])
+}
+
+func (bc *Blockchain) setTokenWord(slot [32]byte, n *big.Int) {
+	bc.stateDB.SetState(TokenRegistryAddress, slot, uint256Word(n))
+}
+
+// tokenSlot derives a storage slot of TokenRegistryAddress
+func tokenSlot(kind string, parts ...[]byte) [32]byte {
+	return stakingSlot("token:"+kind, parts...)
+}
+
+func methodSelector(signature string) [4]byte {
+	hash := keccak256Hash([]byte(signature))
+	return [4]byte(hash[:4])
+}
+
+// abiString ABI-encodes a string return value: offset, length, padded data
+func abiString(s string) []byte {
+	offset, length := uint256Word(big.NewInt(32)), uint256Word(big.NewInt(int64(len(s))))
+	out := make([]byte, 0, 64+words(len(s))*32)
+	out = append(out, offset[:]...)
+	out = append(out, length[:]...)
+	out = append(out, s...)
+	return append(out, make([]byte, (32-len(s)%32)%32)...)
+}
+
+// validTokenString reports whether s is a non-empty printable ASCII string
+// of at most max bytes
+func validTokenString(s string, max int) bool {
+	if len(s) == 0 || len(s) > max {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,179 @@
+// Package blockchain - Protocol treasury funded by transaction fees
+package blockchain
+
+import (
+	"math/big"
+)
+
+// TreasuryAddress is the protocol treasury's account. It receives a share
+// of transaction fees and any transfer sent to it, and only passed
+// governance proposals pay out of it; its storage records its accounts.
+var TreasuryAddress = [20]byte{18: 0x01, 19: 0x09}
+
+// TreasuryEntryKind identifies a movement of treasury funds
+type TreasuryEntryKind uint8
+
+const (
+	TreasuryFees    TreasuryEntryKind = iota + 1 // Fee shares of one settlement epoch
+	TreasuryDeposit                              // Transfer into the treasury, such as a mining pool's fee share
+	TreasurySpend                                // Payment of a passed proposal
+)
+
+// Treasury describes the treasury's funds at the head, which are not part
+// of the circulating supply
+type Treasury struct {
+	Balance  *big.Int
+	Fees     *big.Int // Fee shares received
+	Deposits *big.Int // Transfers received, less transfer burns
+	Spent    *big.Int // Paid to passed proposals
+	Entries  uint64   // Ledger entries
+}
+
+// TreasuryEntry is a movement of treasury funds
+type TreasuryEntry struct {
+	Index    uint64
+	Kind     TreasuryEntryKind
+	Height   uint64   // Block of the movement; for fees, the epoch's first block paying any
+	Account  [20]byte // Depositor or payee; zero for fees
+	Amount   *big.Int // Received or paid; for fees, the epoch's total so far
+	Proposal uint64   // Proposal a spend pays
+}
+
+// GetTreasury returns the treasury's balance and totals
+func (bc *Blockchain) GetTreasury() *Treasury {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return &Treasury{
+		Balance:  bc.stateDB.GetAccount(TreasuryAddress).Balance,
+		Fees:     bc.treasuryWord(treasurySlot("fees")),
+		Deposits: bc.treasuryWord(treasurySlot("deposits")),
+		Spent:    bc.treasuryWord(treasurySlot("spent")),
+		Entries:  bc.treasuryWord(treasurySlot("entries")).Uint64(),
+	}
+}
+
+// GetTreasuryEntries returns up to limit ledger entries from offset, oldest
+// first, and the number of entries
+func (bc *Blockchain) GetTreasuryEntries(offset, limit uint64) ([]*TreasuryEntry, uint64) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	count := bc.treasuryWord(treasurySlot("entries")).Uint64()
+	entries := make([]*TreasuryEntry, 0)
+	for i := offset; i < count && uint64(len(entries)) < limit; i++ {
+		entries = append(entries, bc.treasuryEntry(i))
+	}
+	return entries, count
+}
+
+// TreasuryFeePercent returns the percent of each transaction fee paid to
+// the treasury
+func (bc *Blockchain) TreasuryFeePercent() uint8 {
+	return min(bc.config.TreasuryFeePercent, 100)
+}
+
+// String returns the kind's name
+func (k TreasuryEntryKind) String() string {
+	switch k {
+	case TreasuryFees:
+		return "fees"
+	case TreasuryDeposit:
+		return "deposit"
+	case TreasurySpend:
+		return "spend"
+	}
+	return "unknown"
+}
+
+// Helper functions
+
+// payFee credits a transaction fee to the proposer and the treasury's
+// share of it to the treasury. Callers must hold bc.mu.
+func (bc *Blockchain) payFee(coinbase [20]byte, fee *big.Int, height uint64) {
+	share := new(big.Int).Mul(fee, big.NewInt(int64(bc.TreasuryFeePercent())))
+	share.Div(share, big.NewInt(100))
+	bc.stateDB.AddBalance(coinbase, new(big.Int).Sub(fee, share))
+	if share.Sign() == 0 {
+		return
+	}
+	bc.stateDB.AddBalance(TreasuryAddress, share)
+	bc.addTreasuryWord(treasurySlot("fees"), share)
+
+	// Fee shares of an epoch add up in one entry, opened by its first
+	epoch := bc.SettlementEpoch(height) + 1
+	if bc.treasuryWord(treasurySlot("fee-epoch")).Uint64() != epoch {
+		index := bc.appendTreasuryEntry(&TreasuryEntry{Kind: TreasuryFees, Height: height, Amount: big.NewInt(0)})
+		bc.setTreasuryWord(treasurySlot("fee-epoch"), new(big.Int).SetUint64(epoch))
+		bc.setTreasuryWord(treasurySlot("fee-entry"), new(big.Int).SetUint64(index))
+	}
+	index := uint64ToBytes(bc.treasuryWord(treasurySlot("fee-entry")).Uint64())
+	bc.addTreasuryWord(treasurySlot("entry-amount", index), share)
+}
+
+// recordTreasuryDeposit records value received by the treasury in a
+// transfer. Callers must hold bc.mu.
+func (bc *Blockchain) recordTreasuryDeposit(from [20]byte, received *big.Int, height uint64) {
+	bc.addTreasuryWord(treasurySlot("deposits"), received)
+	bc.appendTreasuryEntry(&TreasuryEntry{Kind: TreasuryDeposit, Height: height, Account: from, Amount: received})
+}
+
+// spendTreasury pays a passed treasury spend at height, reporting whether
+// the treasury could cover it. Callers must hold bc.mu.
+func (bc *Blockchain) spendTreasury(p *Proposal, height uint64) bool {
+	if bc.stateDB.GetAccount(TreasuryAddress).Balance.Cmp(p.Value) < 0 {
+		return false
+	}
+	if err := bc.stateDB.SubBalance(TreasuryAddress, p.Value); err != nil {
+		return false
+	}
+	bc.stateDB.AddBalance(p.Recipient, p.Value)
+	bc.addTreasuryWord(treasurySlot("spent"), p.Value)
+	bc.appendTreasuryEntry(&TreasuryEntry{Kind: TreasurySpend, Height: height, Account: p.Recipient, Amount: p.Value, Proposal: p.ID})
+	return true
+}
+
+// appendTreasuryEntry adds an entry to the ledger and returns its index
+func (bc *Blockchain) appendTreasuryEntry(entry *TreasuryEntry) uint64 {
+	count := bc.treasuryWord(treasurySlot("entries")).Uint64()
+	index := uint64ToBytes(count)
+	bc.setTreasuryWord(treasurySlot("entry-kind", index), big.NewInt(int64(entry.Kind)))
+	bc.setTreasuryWord(treasurySlot("entry-height", index), new(big.Int).SetUint64(entry.Height))
+	bc.stateDB.SetState(TreasuryAddress, treasurySlot("entry-account", index), AddressTopic(entry.Account))
+	bc.setTreasuryWord(treasurySlot("entry-amount", index), entry.Amount)
+	bc.setTreasuryWord(treasurySlot("entry-proposal", index), new(big.Int).SetUint64(entry.Proposal))
+	bc.setTreasuryWord(treasurySlot("entries"), new(big.Int).SetUint64(count+1))
+	return count
+}
+
+func (bc *Blockchain) treasuryEntry(i uint64) *TreasuryEntry {
+	index := uint64ToBytes(i)
+	account := bc.stateDB.GetState(TreasuryAddress, treasurySlot("entry-account", index))
+	entry := &TreasuryEntry{
+		Index:    i,
+		Kind:     TreasuryEntryKind(bc.treasuryWord(treasurySlot("entry-kind", index)).Uint64()),
+		Height:   bc.treasuryWord(treasurySlot("entry-height", index)).Uint64(),
+		Amount:   bc.treasuryWord(treasurySlot("entry-amount", index)),
+		Proposal: bc.treasuryWord(treasurySlot("entry-proposal", index)).Uint64(),
+	}
+	copy(entry.Account[:], account[12:])
+	return entry
+}
+
+func (bc *Blockchain) treasuryWord(slot [32]byte) *big.Int {
+	word := bc.stateDB.GetState(TreasuryAddress, slot)
+	return new(big.Int).SetBytes(word[:])
+}
+
+func (bc *Blockchain) setTreasuryWord(slot [32]byte, n *big.Int) {
+	bc.stateDB.SetState(TreasuryAddress, slot, uint256Word(n))
+}
+
+func (bc *Blockchain) addTreasuryWord(slot [32]byte, n *big.Int) {
+	bc.setTreasuryWord(slot, new(big.Int).Add(bc.treasuryWord(slot), n))
+}
+
+// treasurySlot derives a storage slot of TreasuryAddress
+func treasurySlot(kind string, parts ...[]byte) [32]byte {
+	return stakingSlot("treasury:"+kind, parts...)
+}
@@ -0,0 +1,281 @@
+// Package blockchain - Sparse Merkle trie for verifiable state roots
+package blockchain
+
+import (
+	"crypto/sha256"
+	"errors"
+)
+
+// Node hash domain separators
+const (
+	trieLeafPrefix     = 0x00
+	trieInternalPrefix = 0x01
+)
+
+// StateTrie is a binary sparse Merkle trie over 256-bit keys. A subtree
+// holding a single entry is stored as one leaf at the depth where its key
+// first diverges from its neighbours, so depth grows with log(entries)
+// rather than key length. Empty subtrees hash to zero.
+type StateTrie struct {
+	root *trieNode
+	size int
+}
+
+// trieNode is a leaf when left and right are both nil
+type trieNode struct {
+	left, right *trieNode
+	key         [32]byte
+	value       [32]byte
+	hash        [32]byte
+	hashed      bool
+}
+
+// TrieProof proves the presence or absence of a key. The path from the
+// root ends either in an empty subtree or in a leaf; for an absence proof
+// that leaf holds a different key sharing the path prefix.
+type TrieProof struct {
+	Siblings  [][32]byte // Sibling hashes from the root down
+	LeafKey   [32]byte   // Terminal leaf; zero if the path ends in an empty subtree
+	LeafValue [32]byte
+}
+
+// NewStateTrie creates an empty trie
+func NewStateTrie() *StateTrie {
+	return &StateTrie{}
+}
+
+// Root returns the trie root hash
+func (t *StateTrie) Root() [32]byte {
+	return t.root.nodeHash()
+}
+
+// Len returns the number of entries
+func (t *StateTrie) Len() int {
+	return t.size
+}
+
+// Update sets the value for a key. A zero value deletes the key.
+func (t *StateTrie) Update(key, value [32]byte) {
+	if value == ([32]byte{}) {
+		t.Delete(key)
+		return
+	}
+	var added bool
+	t.root, added = insertTrie(t.root, key, value, 0)
+	if added {
+		t.size++
+	}
+}
+
+// Delete removes a key
+func (t *StateTrie) Delete(key [32]byte) {
+	var removed bool
+	t.root, removed = deleteTrie(t.root, key, 0)
+	if removed {
+		t.size--
+	}
+}
+
+// Get returns the value for a key, or zero if absent
+func (t *StateTrie) Get(key [32]byte) [32]byte {
+	n := t.root
+	for depth := 0; n != nil; depth++ {
+		if n.isLeaf() {
+			if n.key == key {
+				return n.value
+			}
+			break
+		}
+		n = n.child(keyBit(key, depth))
+	}
+	return [32]byte{}
+}
+
+// Prove builds a proof for a key, proving absence if it is not present
+func (t *StateTrie) Prove(key [32]byte) *TrieProof {
+	proof := &TrieProof{Siblings: make([][32]byte, 0)}
+	n := t.root
+	for depth := 0; n != nil; depth++ {
+		if n.isLeaf() {
+			proof.LeafKey = n.key
+			proof.LeafValue = n.value
+			break
+		}
+		bit := keyBit(key, depth)
+		proof.Siblings = append(proof.Siblings, n.child(1-bit).nodeHash())
+		n = n.child(bit)
+	}
+	return proof
+}
+
+// VerifyTrieProof checks a proof against a root. A zero value verifies that
+// the key is absent.
+func VerifyTrieProof(root, key, value [32]byte, proof *TrieProof) error {
+	if proof == nil {
+		return errors.New("missing trie proof")
+	}
+	depth := len(proof.Siblings)
+	if depth > 256 {
+		return errors.New("trie proof too long")
+	}
+
+	var h [32]byte
+	hasLeaf := proof.LeafValue != ([32]byte{})
+	switch {
+	case value != ([32]byte{}):
+		if !hasLeaf || proof.LeafKey != key || proof.LeafValue != value {
+			return errors.New("trie proof does not match value")
+		}
+		h = leafHash(key, value)
+	case hasLeaf:
+		// The leaf must sit on the key's path and hold a different key
+		if proof.LeafKey == key || commonPrefixBits(proof.LeafKey, key) < depth {
+			return errors.New("invalid trie absence proof")
+		}
+		h = leafHash(proof.LeafKey, proof.LeafValue)
+	}
+
+	for i := depth - 1; i >= 0; i-- {
+		if keyBit(key, i) == 0 {
+			h = internalHash(h, proof.Siblings[i])
+		} else {
+			h = internalHash(proof.Siblings[i], h)
+		}
+	}
+	if h != root {
+		return errors.New("trie proof root mismatch")
+	}
+	return nil
+}
+
+// Helper functions
+func (n *trieNode) isLeaf() bool {
+	return n.left == nil && n.right == nil
+}
+
+func (n *trieNode) child(bit int) *trieNode {
+	if bit == 0 {
+		return n.left
+	}
+	return n.right
+}
+
+func (n *trieNode) nodeHash() [32]byte {
+	if n == nil {
+		return [32]byte{}
+	}
+	if !n.hashed {
+		if n.isLeaf() {
+			n.hash = leafHash(n.key, n.value)
+		} else {
+			n.hash = internalHash(n.left.nodeHash(), n.right.nodeHash())
+		}
+		n.hashed = true
+	}
+	return n.hash
+}
+
+// insertTrie returns the updated subtree and whether a new key was added
+func insertTrie(n *trieNode, key, value [32]byte, depth int) (*trieNode, bool) {
+	if n == nil {
+		return &trieNode{key: key, value: value}, true
+	}
+
+	if n.isLeaf() {
+		if n.key == key {
+			return &trieNode{key: key, value: value}, false
+		}
+		// Push the existing leaf down until the two keys diverge
+		return splitLeaf(n, &trieNode{key: key, value: value}, depth), true
+	}
+
+	updated := &trieNode{left: n.left, right: n.right}
+	var added bool
+	if keyBit(key, depth) == 0 {
+		updated.left, added = insertTrie(n.left, key, value, depth+1)
+	} else {
+		updated.right, added = insertTrie(n.right, key, value, depth+1)
+	}
+	return updated, added
+}
+
+func splitLeaf(a, b *trieNode, depth int) *trieNode {
+	bitA, bitB := keyBit(a.key, depth), keyBit(b.key, depth)
+	if bitA == bitB {
+		child := splitLeaf(a, b, depth+1)
+		if bitA == 0 {
+			return &trieNode{left: child}
+		}
+		return &trieNode{right: child}
+	}
+	if bitA == 0 {
+		return &trieNode{left: a, right: b}
+	}
+	return &trieNode{left: b, right: a}
+}
+
+// deleteTrie returns the updated subtree and whether the key was removed.
+// Internal nodes left with a single leaf collapse into that leaf so the
+// trie shape, and therefore the root, depends only on its contents.
+func deleteTrie(n *trieNode, key [32]byte, depth int) (*trieNode, bool) {
+	if n == nil {
+		return nil, false
+	}
+	if n.isLeaf() {
+		if n.key == key {
+			return nil, true
+		}
+		return n, false
+	}
+
+	left, right := n.left, n.right
+	var removed bool
+	if keyBit(key, depth) == 0 {
+		left, removed = deleteTrie(n.left, key, depth+1)
+	} else {
+		right, removed = deleteTrie(n.right, key, depth+1)
+	}
+	if !removed {
+		return n, false
+	}
+
+	switch {
+	case left == nil && right == nil:
+		return nil, true
+	case left == nil && right.isLeaf():
+		return right, true
+	case right == nil && left.isLeaf():
+		return left, true
+	}
+	return &trieNode{left: left, right: right}, true
+}
+
+func leafHash(key, value [32]byte) [32]byte {
+	data := make([]byte, 0, 1+32+32)
+	data = append(data, trieLeafPrefix)
+	data = append(data, key[:]...)
+	data = append(data, value[:]...)
+	return sha256.Sum256(data)
+}
+
+func internalHash(left, right [32]byte) [32]byte {
+	data := make([]byte, 0, 1+32+32)
+	data = append(data, trieInternalPrefix)
+	data = append(data, left[:]...)
+	data = append(data, right[:]...)
+	return sha256.Sum256(data)
+}
+
+// keyBit returns bit i of key, most significant first
+func keyBit(key [32]byte, i int) int {
+	return int(key[i/8]>>(7-uint(i%8))) & 1
+}
+
+func commonPrefixBits(a, b [32]byte) int {
+	for i := 0; i < 256; i++ {
+		if keyBit(a, i) != keyBit(b, i) {
+			return i
+		}
+	}
+	return 256
+}
@@ -0,0 +1,49 @@
+// Package blockchain - Transaction data payloads and memos
+package blockchain
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Transaction data limits and gas schedule
+const (
+	MaxTxDataSize    = 32 * 1024 // Maximum data payload in bytes
+	TxGas            = 21000     // Base gas for any transaction
+	TxDataZeroGas    = 4         // Gas per zero byte of data
+	TxDataNonZeroGas = 16        // Gas per non-zero byte of data
+)
+
+// IntrinsicGas returns the gas a transaction consumes before execution: the
+// base cost plus the per-byte cost of its data
+func IntrinsicGas(data []byte) uint64 {
+	gas := uint64(TxGas)
+	for _, b := range data {
+		if b == 0 {
+			gas += TxDataZeroGas
+		} else {
+			gas += TxDataNonZeroGas
+		}
+	}
+	return gas
+}
+
+// Memo returns the data payload as text if it is printable UTF-8, as used
+// for exchange deposit memos
+func (tx *Transaction) Memo() (string, bool) {
+	return DecodeMemo(tx.Data)
+}
+
+// DecodeMemo interprets a data payload as a printable UTF-8 memo
+func DecodeMemo(data []byte) (string, bool) {
+	if len(data) == 0 || !utf8.Valid(data) {
+		return "", false
+	}
+	memo := string(data)
+	for _, r := range memo {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return "", false
+		}
+	}
+	return memo, true
+}
@@ -0,0 +1,205 @@
+// Package blockchain - Transaction lookup by hash and by address
+package blockchain
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"chaincore/internal/storage"
+)
+
+// Transaction index storage keys. The lookup entry of a transaction maps
+// its hash to the height and index of the block position holding it. Each
+// address has an entry per transaction it sent or received, keyed by the
+// inverted height and index so iteration returns the newest first. Blocks
+// are indexed in the batch that commits them and unindexed on reorgs.
+var (
+	txLookupPrefix  = []byte("txidx:h:")
+	txAddressPrefix = []byte("txidx:a:")
+	txIndexNextKey  = []byte("txidx:next") // Height of the first block not yet indexed
+)
+
+// ErrTransactionNotFound is returned for transactions not included in the
+// chain
+var ErrTransactionNotFound = errors.New("transaction not found")
+
+// TxLocation is the position of an included transaction
+type TxLocation struct {
+	Hash        [32]byte
+	BlockNumber uint64
+	Index       uint64
+}
+
+// GetTransaction returns an included transaction with its block and its
+// index in the block
+func (bc *Blockchain) GetTransaction(hash [32]byte) (*Transaction, *Block, uint64, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	data, err := bc.db.Get(txLookupKey(hash))
+	if err != nil {
+		return nil, nil, 0, ErrTransactionNotFound
+	}
+	if len(data) != 16 {
+		return nil, nil, 0, errors.New("corrupt transaction lookup entry")
+	}
+	height, index := binary.BigEndian.Uint64(data), binary.BigEndian.Uint64(data[8:])
+
+	block, err := bc.loadBlockByHeight(height)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if index >= uint64(len(block.Transactions)) {
+		return nil, nil, 0, errors.New("corrupt transaction lookup entry")
+	}
+	tx := &block.Transactions[index]
+	tx.Hash = hash
+	return tx, block, index, nil
+}
+
+// GetPendingTransaction returns a transaction waiting in the pool, or nil
+func (bc *Blockchain) GetPendingTransaction(hash [32]byte) *Transaction {
+	return bc.txPool.Get(hash)
+}
+
+// GetTransactionsByAddress returns up to limit transactions sent or
+// received by addr, newest first, skipping the first offset. more reports
+// whether older transactions follow.
+func (bc *Blockchain) GetTransactionsByAddress(addr [20]byte, offset, limit int) (locations []TxLocation, more bool, err error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	prefix := txAddressAccountPrefix(addr)
+	it := bc.db.NewIterator(prefix)
+	defer it.Release()
+
+	skipped := 0
+	for it.Next() {
+		if skipped < offset {
+			skipped++
+			continue
+		}
+		if len(locations) == limit {
+			more = true
+			break
+		}
+		key, value := it.Key(), it.Value()
+		if len(key) != len(prefix)+16 || len(value) != 32 {
+			return nil, false, errors.New("corrupt address transaction entry")
+		}
+		loc := TxLocation{
+			BlockNumber: math.MaxUint64 - binary.BigEndian.Uint64(key[len(prefix):]),
+			Index:       math.MaxUint64 - binary.BigEndian.Uint64(key[len(prefix)+8:]),
+		}
+		copy(loc.Hash[:], value)
+		locations = append(locations, loc)
+	}
+	return locations, more, it.Error()
+}
+
+// Helper functions
+
+// initTxIndex indexes the blocks committed before the index existed, from
+// the first unindexed block to the head
+func (bc *Blockchain) initTxIndex() error {
+	next := bc.chainBase()
+	if data, err := bc.db.Get(txIndexNextKey); err == nil && len(data) == 8 {
+		next = binary.BigEndian.Uint64(data)
+	}
+
+	head := bc.currentBlock.Header.Height
+	for height := next; height <= head; height++ {
+		block, err := bc.loadBlockByHeight(height)
+		if err != nil {
+			return err
+		}
+		batch := bc.db.NewBatch()
+		if err := writeTxIndex(batch, block); err != nil {
+			return err
+		}
+		if err := batch.Write(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTxIndex adds the index entries of a block's transactions and marks
+// the block indexed
+func writeTxIndex(batch storage.Batch, block *Block) error {
+	height := block.Header.Height
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		hash := txHash(tx)
+		location := binary.BigEndian.AppendUint64(uint64ToBytes(height), uint64(i))
+		if err := batch.Put(txLookupKey(hash), location); err != nil {
+			return err
+		}
+		for _, addr := range txAddresses(tx) {
+			if err := batch.Put(txAddressKey(addr, height, uint64(i)), hash[:]); err != nil {
+				return err
+			}
+		}
+	}
+	return batch.Put(txIndexNextKey, uint64ToBytes(height+1))
+}
+
+// deleteTxIndex removes the index entries of a reverted head block
+func deleteTxIndex(batch storage.Batch, block *Block) error {
+	height := block.Header.Height
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		if err := batch.Delete(txLookupKey(txHash(tx))); err != nil {
+			return err
+		}
+		for _, addr := range txAddresses(tx) {
+			if err := batch.Delete(txAddressKey(addr, height, uint64(i))); err != nil {
+				return err
+			}
+		}
+	}
+	return batch.Put(txIndexNextKey, uint64ToBytes(height))
+}
+
+// txAddresses returns the addresses a transaction is listed under: its
+// sender and recipient, and a batch transfer's outputs
+func txAddresses(tx *Transaction) [][20]byte {
+	addrs := [][20]byte{tx.From}
+	if tx.To != tx.From {
+		addrs = append(addrs, tx.To)
+	}
+	if tx.To != BatchAddress {
+		return addrs
+	}
+	outputs, err := DecodeBatchTx(tx)
+	if err != nil {
+		return addrs
+	}
+	for _, out := range outputs {
+		listed := false
+		for _, addr := range addrs {
+			if addr == out.To {
+				listed = true
+				break
+			}
+		}
+		if !listed {
+			addrs = append(addrs, out.To)
+		}
+	}
+	return addrs
+}
+
+func txLookupKey(hash [32]byte) []byte {
+	return append(append([]byte(nil), txLookupPrefix...), hash[:]...)
+}
+
+func txAddressAccountPrefix(addr [20]byte) []byte {
+	return append(append([]byte(nil), txAddressPrefix...), addr[:]...)
+}
+
+func txAddressKey(addr [20]byte, height, index uint64) []byte {
+	key := binary.BigEndian.AppendUint64(txAddressAccountPrefix(addr), math.MaxUint64-height)
+	return binary.BigEndian.AppendUint64(key, math.MaxUint64-index)
+}
@@ -2,10 +2,37 @@
 package blockchain
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"math"
 	"math/big"
 	"sort"
 	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"chaincore/internal/tracing"
+)
+
+// Transaction admission errors. RPC servers map each to its own error code.
+var (
+	ErrTxKnown             = errors.New("transaction already in pool")
+	ErrNonceTooLow         = errors.New("nonce too low: nonce already used")
+	ErrNonceGap            = errors.New("nonce too high: missing transaction in sequence")
+	ErrConflictingTx       = errors.New("double-spend attempt: conflicting transaction with same nonce")
+	ErrInsufficientBalance = errors.New("insufficient balance for transaction")
+	ErrGasPriceTooLow      = errors.New("gas price too low")
+	ErrIntrinsicGas        = errors.New("gas limit below intrinsic gas")
+	ErrTxDataTooLarge      = errors.New("transaction data too large")
+	ErrInvalidSignature    = errors.New("invalid transaction signature")
+	ErrInvalidChainID      = errors.New("transaction is for a different chain")
+	ErrInvalidStaking      = errors.New("invalid staking transaction")
+	ErrInvalidBatch        = errors.New("invalid batch transfer")
+	ErrInvalidToken        = errors.New("invalid token operation")
+	ErrInvalidGovernance   = errors.New("invalid governance operation")
+	ErrPoolFull            = errors.New("transaction pool full")
+	ErrTooManyFromAddress  = errors.New("too many pending transactions from address")
 )
 
 // TxPool manages pending transactions
@@ -19,6 +46,21 @@ type TxPool struct {
 	maxPerAddr int
 }
 
+// Advisory gas price parameters
+const (
+	advisoryThreshold     = 0.75 // Pool saturation at which the advisory price starts rising
+	advisoryMaxMultiplier = 16   // Multiple of the minimum gas price at full saturation
+	priceBumpPercent      = 10   // Required premium over the cheapest pooled tx when saturated, and over a replaced tx
+)
+
+// GasPriceAdvisory is the pool's current minimum acceptable gas price
+type GasPriceAdvisory struct {
+	MinGasPrice uint64  // Transactions priced below this are rejected
+	Saturation  float64 // Pending transactions as a fraction of pool capacity
+	Pending     int
+	MaxSize     int
+}
+
 // NewTxPool creates a new transaction pool
 func NewTxPool(config Config) *TxPool {
 	return &TxPool{
@@ -32,28 +74,48 @@ func NewTxPool(config Config) *TxPool {
 }
 
 // Add adds a transaction to the pool
-func (tp *TxPool) Add(tx *Transaction) error {
+func (tp *TxPool) Add(ctx context.Context, tx *Transaction) (err error) {
+	_, span := tracing.StartSpan(ctx, "txpool.Add")
+	defer func() { tracing.End(span, err) }()
+
 	tp.mu.Lock()
 	defer tp.mu.Unlock()
+	span.SetAttributes(attribute.Int("txpool.pending", len(tp.pending)))
 
 	// Check if transaction already exists
 	if _, exists := tp.pending[tx.Hash]; exists {
-		return errors.New("transaction already in pool")
+		return ErrTxKnown
 	}
 
-	// Check pool size
-	if len(tp.pending) >= tp.maxSize {
-		// Remove lowest gas price transaction
-		if len(tp.priceHeap) > 0 && tx.GasPrice > tp.priceHeap[0].GasPrice {
-			tp.removeLowPriceTx()
-		} else {
-			return errors.New("transaction pool full")
-		}
+	// Reject transactions priced below the advisory minimum
+	if minPrice := tp.advisoryPrice(); tx.GasPrice < minPrice {
+		return fmt.Errorf("%w: %d below pool minimum %d", ErrGasPriceTooLow, tx.GasPrice, minPrice)
 	}
 
-	// Check per-address limit
-	if len(tp.queued[tx.From]) >= tp.maxPerAddr {
-		return errors.New("too many pending transactions from address")
+	// A transaction reusing a pooled nonce replaces it if it pays enough
+	// more; it takes the old one's place, so the limits below do not apply
+	if replaced := tp.sameNonce(tx); replaced != nil {
+		if minPrice := replacementPrice(replaced.GasPrice); tx.GasPrice < minPrice {
+			return fmt.Errorf("%w: replacement gas price must be at least %d", ErrConflictingTx, minPrice)
+		}
+		delete(tp.pending, replaced.Hash)
+		tp.removeFromQueued(replaced)
+		tp.removeFromPriceHeap(replaced)
+	} else {
+		// Check pool size
+		if len(tp.pending) >= tp.maxSize {
+			// Remove lowest gas price transaction
+			if len(tp.priceHeap) > 0 && tx.GasPrice > tp.priceHeap[0].GasPrice {
+				tp.removeLowPriceTx()
+			} else {
+				return ErrPoolFull
+			}
+		}
+
+		// Check per-address limit
+		if len(tp.queued[tx.From]) >= tp.maxPerAddr {
+			return ErrTooManyFromAddress
+		}
 	}
 
 	// Add to pending
@@ -94,6 +156,20 @@ func (tp *TxPool) GetPending(maxCount int, maxGas uint64) []*Transaction {
 	return result
 }
 
+// Advisory returns the current minimum gas price. It rises as the pool fills
+// and falls back to the configured minimum as the pool drains.
+func (tp *TxPool) Advisory() GasPriceAdvisory {
+	tp.mu.RLock()
+	defer tp.mu.RUnlock()
+
+	return GasPriceAdvisory{
+		MinGasPrice: tp.advisoryPrice(),
+		Saturation:  tp.saturation(),
+		Pending:     len(tp.pending),
+		MaxSize:     tp.maxSize,
+	}
+}
+
 // Remove removes a transaction from the pool
 func (tp *TxPool) Remove(hash [32]byte) {
 	tp.mu.Lock()
@@ -109,53 +185,65 @@ func (tp *TxPool) Remove(hash [32]byte) {
 	tp.removeFromPriceHeap(tx)
 }
 
-// ValidateNonceSequence validates nonce ordering for an address
-func (tp *TxPool) ValidateNonceSequence(addr [20]byte, expectedNonce uint64) error {
+// ValidateNonceSequence checks that a transaction keeps the address's
+// pooled transactions a gapless sequence starting at the state nonce
+func (tp *TxPool) ValidateNonceSequence(tx *Transaction, stateNonce uint64) error {
 	tp.mu.RLock()
 	defer tp.mu.RUnlock()
 
-	txs := tp.queued[addr]
-	if len(txs) == 0 {
-		return nil
+	if tx.Nonce > tp.pendingNonce(tx.From, stateNonce) {
+		return ErrNonceGap
 	}
-
-	// Sort by nonce
-	sort.Slice(txs, func(i, j int) bool {
-		return txs[i].Nonce < txs[j].Nonce
-	})
-
-	// Validate sequence
-	nonce := expectedNonce
-	for _, tx := range txs {
-		if tx.Nonce != nonce {
-			return errors.New("nonce gap detected: missing transaction in sequence")
-		}
-		nonce++
-	}
-
 	return nil
 }
 
-// DetectDoubleSpend checks for double-spend attempts
+// DetectDoubleSpend checks for double-spend attempts. A transaction with the
+// nonce of a pooled one is a replacement, accepted only if its gas price is
+// priceBumpPercent above the pooled one's, so a stuck transaction can be
+// resent with a higher fee but the pool cannot be churned for free.
 func (tp *TxPool) DetectDoubleSpend(tx *Transaction, stateNonce uint64) error {
 	tp.mu.RLock()
 	defer tp.mu.RUnlock()
 
 	// Check if nonce already used
 	if tx.Nonce < stateNonce {
-		return errors.New("double-spend attempt: nonce already used")
+		return ErrNonceTooLow
 	}
 
 	// Check for conflicting transaction with same nonce
-	for _, existing := range tp.queued[tx.From] {
-		if existing.Nonce == tx.Nonce && existing.Hash != tx.Hash {
-			return errors.New("double-spend attempt: conflicting transaction with same nonce")
+	if existing := tp.sameNonce(tx); existing != nil {
+		if minPrice := replacementPrice(existing.GasPrice); tx.GasPrice < minPrice {
+			return fmt.Errorf("%w: replacement gas price must be at least %d", ErrConflictingTx, minPrice)
 		}
 	}
 
 	return nil
 }
 
+// PendingNonce returns the next nonce for an address after its pooled
+// transactions
+func (tp *TxPool) PendingNonce(addr [20]byte, stateNonce uint64) uint64 {
+	tp.mu.RLock()
+	defer tp.mu.RUnlock()
+
+	return tp.pendingNonce(addr, stateNonce)
+}
+
+// RemoveStale drops an address's pooled transactions whose nonce has been
+// used on chain, including conflicting ones included by other nodes
+func (tp *TxPool) RemoveStale(addr [20]byte, stateNonce uint64) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	for _, tx := range append([]*Transaction(nil), tp.queued[addr]...) {
+		if tx.Nonce < stateNonce {
+			delete(tp.pending, tx.Hash)
+			tp.removeFromQueued(tx)
+			tp.removeFromPriceHeap(tx)
+		}
+	}
+}
+
 // Stats returns pool statistics
 func (tp *TxPool) Stats() (pending int, queued int) {
 	tp.mu.RLock()
@@ -169,6 +257,24 @@ func (tp *TxPool) Stats() (pending int, queued int) {
 	return
 }
 
+// Content returns the pooled transactions by sender, each sender's in
+// nonce order
+func (tp *TxPool) Content() map[[20]byte][]*Transaction {
+	tp.mu.RLock()
+	defer tp.mu.RUnlock()
+
+	content := make(map[[20]byte][]*Transaction, len(tp.queued))
+	for from, txs := range tp.queued {
+		if len(txs) == 0 {
+			continue
+		}
+		sorted := append([]*Transaction(nil), txs...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].Nonce < sorted[j].Nonce })
+		content[from] = sorted
+	}
+	return content
+}
+
 // Clear removes all transactions
 func (tp *TxPool) Clear() {
 	tp.mu.Lock()
@@ -180,6 +286,76 @@ func (tp *TxPool) Clear() {
 }
 
 // Helper functions
+func (tp *TxPool) saturation() float64 {
+	if tp.maxSize == 0 {
+		return 1
+	}
+	return float64(len(tp.pending)) / float64(tp.maxSize)
+}
+
+// advisoryPrice grows exponentially from the configured minimum at the
+// saturation threshold to advisoryMaxMultiplier times it when full. Once
+// saturated, new transactions must also outbid the cheapest pooled one.
+// Callers must hold tp.mu.
+func (tp *TxPool) advisoryPrice() uint64 {
+	base := tp.config.MinGasPrice
+	sat := tp.saturation()
+	if sat < advisoryThreshold {
+		return base
+	}
+
+	progress := math.Min((sat-advisoryThreshold)/(1-advisoryThreshold), 1)
+	price := uint64(float64(base) * math.Pow(advisoryMaxMultiplier, progress))
+
+	if len(tp.priceHeap) > 0 {
+		cheapest := tp.priceHeap[0].GasPrice
+		bumped := cheapest + cheapest*priceBumpPercent/100
+		if bumped > price {
+			price = bumped
+		}
+	}
+	return price
+}
+
+// pendingNonce counts up from the state nonce through the address's
+// consecutive pooled nonces. Callers must hold tp.mu.
+func (tp *TxPool) pendingNonce(addr [20]byte, stateNonce uint64) uint64 {
+	nonces := make(map[uint64]bool, len(tp.queued[addr]))
+	for _, tx := range tp.queued[addr] {
+		nonces[tx.Nonce] = true
+	}
+	nonce := stateNonce
+	for nonces[nonce] {
+		nonce++
+	}
+	return nonce
+}
+
+// orderByNonce puts each sender's transactions into nonce order within the
+// positions that sender holds, so a price-ordered selection stays
+// executable
+func orderByNonce(txs []*Transaction) []*Transaction {
+	positions := make(map[[20]byte][]int)
+	for i, tx := range txs {
+		positions[tx.From] = append(positions[tx.From], i)
+	}
+
+	ordered := make([]*Transaction, len(txs))
+	for _, slots := range positions {
+		senderTxs := make([]*Transaction, len(slots))
+		for i, slot := range slots {
+			senderTxs[i] = txs[slot]
+		}
+		sort.Slice(senderTxs, func(i, j int) bool {
+			return senderTxs[i].Nonce < senderTxs[j].Nonce
+		})
+		for i, slot := range slots {
+			ordered[slot] = senderTxs[i]
+		}
+	}
+	return ordered
+}
+
 func (tp *TxPool) insertByPrice(tx *Transaction) {
 	// Binary insert by gas price
 	i := sort.Search(len(tp.priceHeap), func(i int) bool {
@@ -200,6 +376,27 @@ func (tp *TxPool) removeLowPriceTx() {
 	tp.removeFromQueued(tx)
 }
 
+// sameNonce returns the pooled transaction, other than tx, from the same
+// sender with the same nonce. Callers must hold tp.mu.
+func (tp *TxPool) sameNonce(tx *Transaction) *Transaction {
+	for _, existing := range tp.queued[tx.From] {
+		if existing.Nonce == tx.Nonce && existing.Hash != tx.Hash {
+			return existing
+		}
+	}
+	return nil
+}
+
+// replacementPrice is the lowest gas price that replaces a pooled
+// transaction priced at price
+func replacementPrice(price uint64) uint64 {
+	bumped := price + price*priceBumpPercent/100
+	if bumped == price {
+		bumped++
+	}
+	return bumped
+}
+
 func (tp *TxPool) removeFromQueued(tx *Transaction) {
 	txs := tp.queued[tx.From]
 	for i, t := range txs {
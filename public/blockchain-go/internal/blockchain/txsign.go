@@ -0,0 +1,177 @@
+// Package blockchain - Transaction envelopes and sender signatures
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"chaincore/internal/rlp"
+	"chaincore/internal/secp256k1"
+)
+
+// Transaction envelope types (EIP-2718). Legacy transactions are signed as
+// in EIP-155.
+const (
+	LegacyTxType     = 0x00
+	DynamicFeeTxType = 0x02
+)
+
+// BaseFee is the fixed base fee of dynamic fee transactions (1 Gwei)
+var BaseFee = big.NewInt(1000000000)
+
+// AccessTuple is an entry of an EIP-2930 access list
+type AccessTuple struct {
+	Address     [20]byte
+	StorageKeys [][32]byte
+}
+
+// DynamicFeeGasPrice returns the gas price a dynamic fee transaction pays:
+// the base fee plus its tip, capped at its fee cap
+func DynamicFeeGasPrice(tip, feeCap *big.Int) *big.Int {
+	price := new(big.Int).Add(BaseFee, tip)
+	if price.Cmp(feeCap) > 0 {
+		price.Set(feeCap)
+	}
+	return price
+}
+
+// SigningPayload returns what the sender signs the Keccak-256 hash of:
+// rlp([nonce, gasPrice, gas, to, value, data, chainId, 0, 0]) for a legacy
+// transaction, and 0x02 || rlp([chainId, nonce, tip, feeCap, gas, to,
+// value, data, accessList]) for a dynamic fee transaction
+func (tx *Transaction) SigningPayload() ([]byte, error) {
+	switch tx.Version {
+	case LegacyTxType:
+		return rlp.EncodeList(append(tx.legacyFields(), rlp.EncodeUint(tx.ChainID), rlp.EncodeUint(0), rlp.EncodeUint(0))...), nil
+	case DynamicFeeTxType:
+		if tx.GasTipCap == nil || tx.GasFeeCap == nil {
+			return nil, errors.New("dynamic fee transaction without fee caps")
+		}
+		return append([]byte{DynamicFeeTxType}, rlp.EncodeList(tx.dynamicFeeFields()...)...), nil
+	}
+	return nil, fmt.Errorf("unsupported transaction type 0x%02x", tx.Version)
+}
+
+// SigningHash returns the hash the sender signs
+func (tx *Transaction) SigningHash() ([]byte, error) {
+	payload, err := tx.SigningPayload()
+	if err != nil {
+		return nil, err
+	}
+	hash := keccak256Hash(payload)
+	return hash[:], nil
+}
+
+// EncodeRaw returns the signed envelope as sent with eth_sendRawTransaction.
+// Its Keccak-256 hash is the transaction's hash.
+func (tx *Transaction) EncodeRaw() ([]byte, error) {
+	r, s := trimLeadingZeros(tx.Signature[:32]), trimLeadingZeros(tx.Signature[32:64])
+	switch tx.Version {
+	case LegacyTxType:
+		v := tx.ChainID*2 + 35 + uint64(tx.Signature[64])
+		return rlp.EncodeList(append(tx.legacyFields(), rlp.EncodeUint(v), rlp.EncodeBytes(r), rlp.EncodeBytes(s))...), nil
+	case DynamicFeeTxType:
+		if tx.GasTipCap == nil || tx.GasFeeCap == nil {
+			return nil, errors.New("dynamic fee transaction without fee caps")
+		}
+		fields := append(tx.dynamicFeeFields(), rlp.EncodeUint(uint64(tx.Signature[64])), rlp.EncodeBytes(r), rlp.EncodeBytes(s))
+		return append([]byte{DynamicFeeTxType}, rlp.EncodeList(fields...)...), nil
+	}
+	return nil, fmt.Errorf("unsupported transaction type 0x%02x", tx.Version)
+}
+
+// Sender recovers the address that signed the transaction
+func (tx *Transaction) Sender() ([20]byte, error) {
+	hash, err := tx.SigningHash()
+	if err != nil {
+		return [20]byte{}, err
+	}
+	return secp256k1.RecoverAddress(hash, tx.Signature[:])
+}
+
+// Verify checks that the transaction is signed by From and that its hash
+// is that of its signed envelope, as it is for one decoded from
+// eth_sendRawTransaction. Transactions relayed or included by other nodes
+// are checked with it.
+func (tx *Transaction) Verify() error {
+	if !verifySignature(tx) {
+		return ErrInvalidSignature
+	}
+	raw, err := tx.EncodeRaw()
+	if err != nil || keccak256Hash(raw) != tx.Hash {
+		return fmt.Errorf("%w: hash does not match the signed transaction", ErrInvalidSignature)
+	}
+	return nil
+}
+
+// Helper functions
+
+// verifySignature reports whether tx is signed by its sender, and for a
+// dynamic fee transaction, whether its gas price is the one its signed fee
+// caps give
+func verifySignature(tx *Transaction) bool {
+	if tx.Version == DynamicFeeTxType {
+		if tx.GasTipCap == nil || tx.GasFeeCap == nil || tx.GasTipCap.Cmp(tx.GasFeeCap) > 0 {
+			return false
+		}
+		price := DynamicFeeGasPrice(tx.GasTipCap, tx.GasFeeCap)
+		if !price.IsUint64() || price.Uint64() != tx.GasPrice {
+			return false
+		}
+	}
+	from, err := tx.Sender()
+	return err == nil && from == tx.From
+}
+
+// legacyFields encodes [nonce, gasPrice, gas, to, value, data]
+func (tx *Transaction) legacyFields() [][]byte {
+	return [][]byte{
+		rlp.EncodeUint(tx.Nonce),
+		rlp.EncodeUint(tx.GasPrice),
+		rlp.EncodeUint(tx.GasLimit),
+		rlp.EncodeBytes(tx.recipient()),
+		rlp.EncodeBigInt(tx.Value),
+		rlp.EncodeBytes(tx.Data),
+	}
+}
+
+// dynamicFeeFields encodes [chainId, nonce, tip, feeCap, gas, to, value,
+// data, accessList]
+func (tx *Transaction) dynamicFeeFields() [][]byte {
+	tuples := make([][]byte, len(tx.AccessList))
+	for i, tuple := range tx.AccessList {
+		keys := make([][]byte, len(tuple.StorageKeys))
+		for j, key := range tuple.StorageKeys {
+			keys[j] = rlp.EncodeBytes(key[:])
+		}
+		tuples[i] = rlp.EncodeList(rlp.EncodeBytes(tuple.Address[:]), rlp.EncodeList(keys...))
+	}
+	return [][]byte{
+		rlp.EncodeUint(tx.ChainID),
+		rlp.EncodeUint(tx.Nonce),
+		rlp.EncodeBigInt(tx.GasTipCap),
+		rlp.EncodeBigInt(tx.GasFeeCap),
+		rlp.EncodeUint(tx.GasLimit),
+		rlp.EncodeBytes(tx.recipient()),
+		rlp.EncodeBigInt(tx.Value),
+		rlp.EncodeBytes(tx.Data),
+		rlp.EncodeList(tuples...),
+	}
+}
+
+// recipient returns the encoded recipient, empty for a contract creation
+// as on Ethereum
+func (tx *Transaction) recipient() []byte {
+	if tx.IsContractCreation() {
+		return nil
+	}
+	return tx.To[:]
+}
+
+func trimLeadingZeros(b []byte) []byte {
+	for len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+	}
+	return b
+}
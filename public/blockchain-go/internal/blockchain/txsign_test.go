@@ -0,0 +1,178 @@
+package blockchain_test
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/secp256k1"
+	"chaincore/internal/storage"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// testKey is a funded secp256k1 account of a test chain
+type testKey struct {
+	priv []byte
+	addr [20]byte
+}
+
+func newTestChain(t *testing.T, accounts int) (*blockchain.Blockchain, []testKey) {
+	t.Helper()
+	keys := make([]testKey, accounts)
+	alloc := make(map[[20]byte]*big.Int)
+	for i := range keys {
+		priv, err := secp256k1.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub, _ := secp256k1.PublicKey(priv)
+		keys[i] = testKey{priv: priv, addr: secp256k1.PubkeyToAddress(pub)}
+		alloc[keys[i].addr] = new(big.Int).Mul(big.NewInt(1000), big.NewInt(1e18))
+	}
+	db, err := storage.NewMemoryLevelDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	chain, err := blockchain.NewBlockchain(db, blockchain.Config{
+		ChainID:           1,
+		MinGasPrice:       1,
+		ValidatorMinStake: big.NewInt(1),
+		GenesisAlloc:      alloc,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chain, keys
+}
+
+// sign signs tx with key and sets its sender and hash, the hash of its raw
+// envelope
+func sign(t *testing.T, tx *blockchain.Transaction, key testKey) {
+	t.Helper()
+	hash, err := tx.SigningHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := secp256k1.Sign(hash, key.priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(tx.Signature[:], sig)
+	tx.From = key.addr
+	tx.Hash = envelopeHash(t, tx)
+}
+
+func envelopeHash(t *testing.T, tx *blockchain.Transaction) [32]byte {
+	t.Helper()
+	raw, err := tx.EncodeRaw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hash [32]byte
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write(raw)
+	copy(hash[:], hasher.Sum(nil))
+	return hash
+}
+
+func TestPoolRejectsForgedSender(t *testing.T) {
+	chain, keys := newTestChain(t, 2)
+	tx := &blockchain.Transaction{ChainID: 1, To: [20]byte{1}, Value: big.NewInt(5), GasLimit: blockchain.TxGas, GasPrice: 1}
+	sign(t, tx, keys[0])
+
+	forged := *tx
+	forged.From = keys[1].addr
+	forged.Hash = envelopeHash(t, &forged)
+	if err := chain.AddTransaction(context.Background(), &forged); !errors.Is(err, blockchain.ErrInvalidSignature) {
+		t.Fatalf("spend from another account: %v", err)
+	}
+	if err := chain.AddTransaction(context.Background(), tx); err != nil {
+		t.Fatalf("signed transaction: %v", err)
+	}
+}
+
+func TestDynamicFeeSignature(t *testing.T) {
+	chain, keys := newTestChain(t, 1)
+	tip, feeCap := big.NewInt(2), big.NewInt(3000000000)
+	tx := &blockchain.Transaction{
+		Version:    blockchain.DynamicFeeTxType,
+		ChainID:    1,
+		To:         [20]byte{1},
+		Value:      big.NewInt(5),
+		GasLimit:   blockchain.TxGas,
+		GasPrice:   blockchain.DynamicFeeGasPrice(tip, feeCap).Uint64(),
+		GasTipCap:  tip,
+		GasFeeCap:  feeCap,
+		AccessList: []blockchain.AccessTuple{{Address: [20]byte{2}, StorageKeys: [][32]byte{{3}}}},
+	}
+	sign(t, tx, keys[0])
+
+	// The gas price is not signed as such, so it must follow from the caps
+	overpriced := *tx
+	overpriced.GasPrice = feeCap.Uint64()
+	overpriced.Hash = envelopeHash(t, &overpriced)
+	if err := chain.AddTransaction(context.Background(), &overpriced); !errors.Is(err, blockchain.ErrInvalidSignature) {
+		t.Fatalf("gas price above the caps' price: %v", err)
+	}
+
+	// The access list is signed too
+	altered := *tx
+	altered.AccessList = nil
+	altered.Hash = envelopeHash(t, &altered)
+	if err := chain.AddTransaction(context.Background(), &altered); !errors.Is(err, blockchain.ErrInvalidSignature) {
+		t.Fatalf("altered access list: %v", err)
+	}
+
+	if err := chain.AddTransaction(context.Background(), tx); err != nil {
+		t.Fatalf("signed transaction: %v", err)
+	}
+}
+
+func TestPoolRejectsCreationWithRecipient(t *testing.T) {
+	chain, keys := newTestChain(t, 1)
+	tx := &blockchain.Transaction{ChainID: 1, Create: true, To: [20]byte{1}, Value: big.NewInt(0), GasLimit: 100000, GasPrice: 1}
+	sign(t, tx, keys[0])
+	if err := chain.AddTransaction(context.Background(), tx); !errors.Is(err, blockchain.ErrCreateWithRecipient) {
+		t.Fatalf("creation with a recipient: %v", err)
+	}
+}
+
+func TestPoolRejectsMismatchedHash(t *testing.T) {
+	chain, keys := newTestChain(t, 1)
+	tx := &blockchain.Transaction{ChainID: 1, To: [20]byte{1}, Value: big.NewInt(5), GasLimit: blockchain.TxGas, GasPrice: 1}
+	sign(t, tx, keys[0])
+
+	// A relay may not resubmit a transaction under another hash
+	renamed := *tx
+	renamed.Hash[0] ^= 1
+	if err := chain.AddTransaction(context.Background(), &renamed); !errors.Is(err, blockchain.ErrInvalidSignature) {
+		t.Fatalf("hash of another transaction: %v", err)
+	}
+	if err := tx.Verify(); err != nil {
+		t.Fatalf("signed transaction: %v", err)
+	}
+}
+
+func TestBlockRejectsForgedSender(t *testing.T) {
+	chain, keys := newTestChain(t, 2)
+	tx := &blockchain.Transaction{ChainID: 1, To: [20]byte{1}, Value: big.NewInt(5), GasLimit: blockchain.TxGas, GasPrice: 1}
+	sign(t, tx, keys[0])
+	forged := *tx
+	forged.From = keys[1].addr
+	forged.Hash = envelopeHash(t, &forged)
+
+	head := chain.GetCurrentBlock()
+	block := &blockchain.Block{Header: blockchain.BlockHeader{Height: head.Header.Height + 1, PrevHash: head.Hash(), GasLimit: head.Header.GasLimit}}
+	block.Transactions = []blockchain.Transaction{*tx}
+	if _, err := chain.StateRootAfter(block); err != nil {
+		t.Fatalf("signed transaction: %v", err)
+	}
+	block.Transactions = []blockchain.Transaction{forged}
+	if _, err := chain.StateRootAfter(block); !errors.Is(err, blockchain.ErrInvalidSignature) {
+		t.Fatalf("spend from another account: %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+// Package blockchain - Unbonding queue for withdrawn stake
+package blockchain
+
+import (
+	"math/big"
+	"time"
+)
+
+// DefaultUnbondingPeriod is used when Config.UnbondingPeriod is zero
+const DefaultUnbondingPeriod = 21 * 24 * time.Hour
+
+// UnbondingEntry is withdrawn stake waiting to be released. Until then the
+// funds stay in StakingAddress and are still slashable for misbehaviour of
+// the validator they were bonded to.
+type UnbondingEntry struct {
+	Validator   [20]byte
+	Delegator   [20]byte
+	Amount      *big.Int
+	ReleaseTime uint64 // Unix seconds; released by the first block at or after it
+}
+
+// GetUnbonding returns the pending unbonding entries of a delegator, oldest
+// first. Validators unstaking their own stake are their own delegator.
+func (bc *Blockchain) GetUnbonding(delegator [20]byte) []*UnbondingEntry {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	entries := make([]*UnbondingEntry, 0)
+	head, tail := bc.unbondingBounds()
+	for i := head; i < tail; i++ {
+		if entry := bc.unbondingEntry(i); entry.Delegator == delegator {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Helper functions
+
+// unbondingPeriod returns the configured unbonding period in seconds
+func (bc *Blockchain) unbondingPeriod() uint64 {
+	period := bc.config.UnbondingPeriod
+	if period == 0 {
+		period = DefaultUnbondingPeriod
+	}
+	return uint64(period / time.Second)
+}
+
+// queueUnbonding appends withdrawn stake to the unbonding queue. The period
+// is the same for every entry and block timestamps only increase, so the
+// queue stays ordered by release time. Callers must hold bc.mu.
+func (bc *Blockchain) queueUnbonding(validator, delegator [20]byte, amount *big.Int, timestamp uint64) {
+	_, tail := bc.unbondingBounds()
+	bc.setUnbondingEntry(tail, &UnbondingEntry{
+		Validator:   validator,
+		Delegator:   delegator,
+		Amount:      amount,
+		ReleaseTime: timestamp + bc.unbondingPeriod(),
+	})
+	bc.stateDB.SetState(StakingAddress, stakingSlot("unbond-tail"), uint256Word(new(big.Int).SetUint64(tail+1)))
+
+	unbonding := new(big.Int).Add(bc.stateWord(stakingSlot("unbonding", validator[:])), amount)
+	bc.stateDB.SetState(StakingAddress, stakingSlot("unbonding", validator[:]), uint256Word(unbonding))
+}
+
+// releaseUnbonding pays out every entry whose release time has passed. It
+// runs at the end of each block. Callers must hold bc.mu.
+func (bc *Blockchain) releaseUnbonding(timestamp uint64) {
+	head, tail := bc.unbondingBounds()
+	released := head
+	for ; released < tail; released++ {
+		entry := bc.unbondingEntry(released)
+		if entry.ReleaseTime > timestamp {
+			break
+		}
+
+		if err := bc.stateDB.SubBalance(StakingAddress, entry.Amount); err == nil {
+			bc.stateDB.AddBalance(entry.Delegator, entry.Amount)
+		}
+		unbonding := new(big.Int).Sub(bc.stateWord(stakingSlot("unbonding", entry.Validator[:])), entry.Amount)
+		bc.stateDB.SetState(StakingAddress, stakingSlot("unbonding", entry.Validator[:]), uint256Word(unbonding))
+		bc.setUnbondingEntry(released, &UnbondingEntry{Amount: big.NewInt(0)})
+	}
+	if released != head {
+		bc.stateDB.SetState(StakingAddress, stakingSlot("unbond-head"), uint256Word(new(big.Int).SetUint64(released)))
+	}
+}
+
+// unbondingBounds returns the queue's head and tail positions
+func (bc *Blockchain) unbondingBounds() (uint64, uint64) {
+	head := wordToUint64(bc.stateDB.GetState(StakingAddress, stakingSlot("unbond-head")))
+	tail := wordToUint64(bc.stateDB.GetState(StakingAddress, stakingSlot("unbond-tail")))
+	return head, tail
+}
+
+func (bc *Blockchain) unbondingEntry(i uint64) *UnbondingEntry {
+	pos := uint64ToBytes(i)
+	validator := bc.stateDB.GetState(StakingAddress, stakingSlot("unbond-validator", pos))
+	delegator := bc.stateDB.GetState(StakingAddress, stakingSlot("unbond-delegator", pos))
+	entry := &UnbondingEntry{
+		Amount:      bc.stateWord(stakingSlot("unbond-amount", pos)),
+		ReleaseTime: wordToUint64(bc.stateDB.GetState(StakingAddress, stakingSlot("unbond-release", pos))),
+	}
+	copy(entry.Validator[:], validator[12:])
+	copy(entry.Delegator[:], delegator[12:])
+	return entry
+}
+
+// setUnbondingEntry writes an entry; a zero entry clears its slots
+func (bc *Blockchain) setUnbondingEntry(i uint64, entry *UnbondingEntry) {
+	pos := uint64ToBytes(i)
+	var validator, delegator [32]byte
+	copy(validator[12:], entry.Validator[:])
+	copy(delegator[12:], entry.Delegator[:])
+	bc.stateDB.SetState(StakingAddress, stakingSlot("unbond-validator", pos), validator)
+	bc.stateDB.SetState(StakingAddress, stakingSlot("unbond-delegator", pos), delegator)
+	bc.stateDB.SetState(StakingAddress, stakingSlot("unbond-amount", pos), uint256Word(entry.Amount))
+	bc.stateDB.SetState(StakingAddress, stakingSlot("unbond-release", pos), uint256Word(new(big.Int).SetUint64(entry.ReleaseTime)))
+}
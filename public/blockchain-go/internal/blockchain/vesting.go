@@ -0,0 +1,36 @@
+// Package blockchain - Spending limits for vesting balances
+package blockchain
+
+import (
+	"errors"
+	"math/big"
+)
+
+// ErrUnvestedFunds is returned for transactions that would spend a locked
+// balance
+var ErrUnvestedFunds = errors.New("transaction spends unvested funds")
+
+// VestingPolicy reports how much of an address's balance is locked at a
+// block timestamp, and how much is locked across all addresses
+type VestingPolicy interface {
+	Locked(addr [20]byte, timestamp uint64) *big.Int
+	TotalLocked(timestamp uint64) *big.Int
+}
+
+// Helper functions
+
+// checkVesting rejects spending cost from balance if what remains would be
+// below the address's locked amount
+func (bc *Blockchain) checkVesting(addr [20]byte, balance, cost *big.Int, timestamp uint64) error {
+	if bc.config.Vesting == nil {
+		return nil
+	}
+	locked := bc.config.Vesting.Locked(addr, timestamp)
+	if locked.Sign() == 0 {
+		return nil
+	}
+	if new(big.Int).Sub(balance, cost).Cmp(locked) < 0 {
+		return ErrUnvestedFunds
+	}
+	return nil
+}
@@ -0,0 +1,440 @@
+// Package blockchain - EVM-compatible contract execution
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"golang.org/x/crypto/sha3"
+
+	"chaincore/internal/rlp"
+)
+
+// VM limits. Contracts have the Shanghai instruction set plus MCOPY, and
+// the precompiles at 0x01 to 0x04.
+const (
+	MaxCodeSize  = 24576 // Largest deployed contract code (EIP-170)
+	maxCallDepth = 1024
+	maxStackSize = 1024
+	callStipend  = 2300 // Gas given to the callee of a call that sends value
+)
+
+// VM gas schedule. Account and storage access costs are flat, as before
+// access lists; gas is not refunded.
+const (
+	CreateGas          = 32000 // Contract creation, on top of the intrinsic gas
+	CodeDepositGas     = 200   // Per byte of deployed code
+	sstoreSetGas       = 20000 // Writing a non-zero value to an empty slot
+	sstoreResetGas     = 5000  // Any other storage write
+	sloadGas           = 800
+	accountAccessGas   = 700 // BALANCE, EXTCODESIZE, EXTCODECOPY, EXTCODEHASH
+	callGas            = 700
+	callValueGas       = 9000
+	callNewAccountGas  = 25000
+	selfdestructGas    = 5000
+	logGas             = 375
+	logTopicGas        = 375
+	logDataGas         = 8
+	keccakWordGas      = 6
+	copyWordGas        = 3
+	expByteGas         = 50
+	memoryGas          = 3
+	quadCoeffDiv       = 512
+	blockhashGas       = 20
+	blockhashWindow    = 256
+	sstoreSentryGas    = 2300 // SSTORE needs more than this left (EIP-2200)
+	maxInitCodeSize    = 2 * MaxCodeSize
+	initCodeWordGas    = 2 // Per word of CREATE and CREATE2 init code
+	create2HashWordGas = 6
+)
+
+// Failure reason codes of contract transactions
+const (
+	FailureReverted       = "reverted"        // The contract reverted
+	FailureOutOfGas       = "out_of_gas"      // Execution ran out of gas
+	FailureContractFailed = "contract_failed" // Any other execution error
+)
+
+// VM errors
+var (
+	ErrExecutionReverted        = errors.New("execution reverted")
+	ErrOutOfGas                 = errors.New("out of gas")
+	ErrInvalidOpcode            = errors.New("invalid opcode")
+	ErrInvalidJump              = errors.New("invalid jump destination")
+	ErrStackUnderflow           = errors.New("stack underflow")
+	ErrStackOverflow            = errors.New("stack overflow")
+	ErrWriteProtection          = errors.New("write protection")
+	ErrReturnDataOutOfBounds    = errors.New("return data out of bounds")
+	ErrCallDepth                = errors.New("max call depth exceeded")
+	ErrInsufficientCallBalance  = errors.New("insufficient balance for transfer")
+	ErrContractAddressCollision = errors.New("contract address collision")
+	ErrMaxCodeSize              = errors.New("max code size exceeded")
+	ErrInvalidCode              = errors.New("invalid code: must not begin with 0xef")
+	ErrSystemAddress            = errors.New("contracts cannot send value to system addresses")
+	ErrCreateWithRecipient      = errors.New("contract creation must not have a recipient")
+)
+
+// ContractAddress returns the address a contract created by sender with
+// nonce is deployed at
+func ContractAddress(sender [20]byte, nonce uint64) [20]byte {
+	hash := keccak256Hash(rlp.EncodeList(rlp.EncodeBytes(sender[:]), rlp.EncodeUint(nonce)))
+	var addr [20]byte
+	copy(addr[:], hash[12:])
+	return addr
+}
+
+// ContractAddress2 returns the address CREATE2 deploys init code to
+func ContractAddress2(sender [20]byte, salt [32]byte, initCode []byte) [20]byte {
+	codeHash := keccak256Hash(initCode)
+	hash := keccak256Hash([]byte{0xff}, sender[:], salt[:], codeHash[:])
+	var addr [20]byte
+	copy(addr[:], hash[12:])
+	return addr
+}
+
+// IsContractCreation reports whether a transaction deploys a contract
+func (tx *Transaction) IsContractCreation() bool {
+	return tx.Create
+}
+
+// RevertError is returned when a call reverts, with the data it reverted
+// with
+type RevertError struct {
+	Data []byte
+}
+
+func (e *RevertError) Error() string {
+	if reason, ok := revertReason(e.Data); ok {
+		return "execution reverted: " + reason
+	}
+	return ErrExecutionReverted.Error()
+}
+
+func (e *RevertError) Unwrap() error {
+	return ErrExecutionReverted
+}
+
+// GetCode returns the contract code of an address after the block at
+// height. Code and storage history is not kept, so only the head is
+// available.
+func (bc *Blockchain) GetCode(addr [20]byte, height uint64) ([]byte, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	if err := bc.checkHeadState(height); err != nil {
+		return nil, err
+	}
+	return bc.stateDB.GetCode(addr), nil
+}
+
+// GetStorageAt returns a storage slot of an address after the block at
+// height, with the same availability as GetCode
+func (bc *Blockchain) GetStorageAt(addr [20]byte, key [32]byte, height uint64) ([32]byte, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	if err := bc.checkHeadState(height); err != nil {
+		return [32]byte{}, err
+	}
+	return bc.stateDB.GetState(addr, key), nil
+}
+
+// Call executes a message against the head state, as the next block
+// would, without changing it and returns its output. No fee is charged and
+// the nonce and signature are ignored; a zero gas limit runs with the
+// block gas limit. A call that reverts returns a *RevertError.
+func (bc *Blockchain) Call(msg *Transaction) ([]byte, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	defer bc.takeStakingChanges()
+	defer bc.takeLogs()
+
+	snapshot := bc.stateDB.Snapshot()
+	defer bc.stateDB.RevertToSnapshot(snapshot)
+
+	header := bc.pendingHeader()
+	gas := header.GasLimit
+	if msg.GasLimit > 0 && msg.GasLimit < gas {
+		gas = msg.GasLimit
+	}
+	if gas < IntrinsicGas(msg.Data) {
+		return nil, ErrIntrinsicGas
+	}
+
+	run := *msg
+	run.Nonce = bc.stateDB.GetNonce(msg.From)
+	value := big.NewInt(0)
+	if msg.Value != nil {
+		value = msg.Value
+	}
+	if err := bc.stateDB.SubBalance(msg.From, value); err != nil {
+		return nil, ErrInsufficientBalance
+	}
+
+	vm := bc.newVM(header, msg.From, msg.GasPrice)
+	ret, _, err := vm.execute(&run, value, gas-IntrinsicGas(msg.Data))
+	return ret, err
+}
+
+// Helper functions
+
+// vm executes the contract calls of one transaction
+type vm struct {
+	bc       *Blockchain
+	header   *BlockHeader
+	origin   [20]byte
+	gasPrice uint64
+	depth    int
+}
+
+// newVM creates a VM for a transaction from origin. Callers must hold
+// bc.mu.
+func (bc *Blockchain) newVM(header *BlockHeader, origin [20]byte, gasPrice uint64) *vm {
+	return &vm{bc: bc, header: header, origin: origin, gasPrice: gasPrice}
+}
+
+// applyContractTx runs a contract transaction whose fee is paid and value
+// checked, undoing all but the fee and nonce if it fails, and returns the
+// failure reason code. Callers must hold bc.mu.
+func (bc *Blockchain) applyContractTx(tx *Transaction, header *BlockHeader, value *big.Int) string {
+	snapshot := bc.stateDB.Snapshot()
+	logs := len(bc.txLogs)
+
+	bc.stateDB.SubBalance(tx.From, value)
+	vm := bc.newVM(header, tx.From, tx.GasPrice)
+	_, _, err := vm.execute(tx, value, tx.GasLimit-IntrinsicGas(tx.Data))
+	if err == nil {
+		return ""
+	}
+
+	bc.stateDB.RevertToSnapshot(snapshot)
+	bc.txLogs = bc.txLogs[:logs]
+	switch {
+	case errors.Is(err, ErrExecutionReverted):
+		return FailureReverted
+	case errors.Is(err, ErrOutOfGas):
+		return FailureOutOfGas
+	default:
+		return FailureContractFailed
+	}
+}
+
+// execute runs a transaction's call or contract creation with gas left
+// after the intrinsic gas. The value has already been taken from the
+// sender; it is credited to the recipient less the transfer burn.
+func (v *vm) execute(tx *Transaction, value *big.Int, gas uint64) ([]byte, uint64, error) {
+	if !tx.IsContractCreation() {
+		v.bc.creditTransfer(tx.From, tx.To, value, v.header.Height)
+		received := new(big.Int).Sub(value, v.bc.TransferBurn(tx.To, value))
+		return v.call(callPlain, tx.From, tx.To, tx.To, tx.Data, gas, received, false, false)
+	}
+
+	if gas < CreateGas {
+		return nil, 0, ErrOutOfGas
+	}
+	addr := ContractAddress(tx.From, tx.Nonce)
+	if v.bc.stateDB.GetNonce(addr) != 0 || len(v.bc.stateDB.GetCode(addr)) > 0 || v.bc.isToken(addr) {
+		return nil, 0, ErrContractAddressCollision
+	}
+	v.bc.creditTransfer(tx.From, addr, value, v.header.Height)
+	received := new(big.Int).Sub(value, v.bc.TransferBurn(addr, value))
+	return v.create(tx.From, addr, tx.Data, gas-CreateGas, received, false)
+}
+
+// callKind distinguishes the call instructions
+type callKind int
+
+const (
+	callPlain callKind = iota
+	callCode
+	callDelegate
+	callStatic
+)
+
+// call runs the code at codeAddr for address, sending value from caller
+// to address first if transfer is set, and returns the output and the gas
+// left. A failed call undoes its changes; only a revert leaves the gas.
+func (v *vm) call(kind callKind, caller, address, codeAddr [20]byte, input []byte, gas uint64, value *big.Int, static, transfer bool) ([]byte, uint64, error) {
+	if v.depth >= maxCallDepth {
+		return nil, gas, ErrCallDepth
+	}
+	if transfer && value.Sign() > 0 {
+		if isSystemAddress(address) {
+			return nil, gas, ErrSystemAddress
+		}
+		if v.bc.stateDB.GetAccount(caller).Balance.Cmp(value) < 0 {
+			return nil, gas, ErrInsufficientCallBalance
+		}
+	}
+
+	snapshot := v.bc.stateDB.Snapshot()
+	logs := len(v.bc.txLogs)
+	if transfer && value.Sign() > 0 {
+		v.bc.stateDB.SubBalance(caller, value)
+		v.bc.stateDB.AddBalance(address, value)
+	}
+
+	var ret []byte
+	var err error
+	if precompile, ok := precompiles[codeAddr]; ok {
+		ret, gas, err = runPrecompile(precompile, input, gas)
+	} else if v.bc.isToken(codeAddr) {
+		ret, gas, err = v.callToken(codeAddr, input, gas)
+	} else if code := v.bc.stateDB.GetCode(codeAddr); len(code) > 0 {
+		f := newFrame(v, code, address, caller, value, input, gas, static)
+		v.depth++
+		ret, err = f.run()
+		v.depth--
+		gas = f.gas
+	}
+
+	if err != nil {
+		v.bc.stateDB.RevertToSnapshot(snapshot)
+		v.bc.txLogs = v.bc.txLogs[:logs]
+		if !errors.Is(err, ErrExecutionReverted) {
+			gas = 0
+		}
+	}
+	return ret, gas, err
+}
+
+// create runs init code for a new contract at addr and stores the code it
+// returns, sending value from caller first if transfer is set
+func (v *vm) create(caller, addr [20]byte, initCode []byte, gas uint64, value *big.Int, transfer bool) ([]byte, uint64, error) {
+	if v.depth >= maxCallDepth {
+		return nil, gas, ErrCallDepth
+	}
+	if transfer && value.Sign() > 0 && v.bc.stateDB.GetAccount(caller).Balance.Cmp(value) < 0 {
+		return nil, gas, ErrInsufficientCallBalance
+	}
+	if v.bc.stateDB.GetNonce(addr) != 0 || len(v.bc.stateDB.GetCode(addr)) > 0 || v.bc.isToken(addr) {
+		return nil, 0, ErrContractAddressCollision
+	}
+
+	snapshot := v.bc.stateDB.Snapshot()
+	logs := len(v.bc.txLogs)
+	v.bc.stateDB.IncrementNonce(addr)
+	if transfer && value.Sign() > 0 {
+		v.bc.stateDB.SubBalance(caller, value)
+		v.bc.stateDB.AddBalance(addr, value)
+	}
+
+	f := newFrame(v, initCode, addr, caller, value, nil, gas, false)
+	v.depth++
+	ret, err := f.run()
+	v.depth--
+	gas = f.gas
+
+	if err == nil {
+		deposit := uint64(len(ret)) * CodeDepositGas
+		switch {
+		case len(ret) > MaxCodeSize:
+			err = ErrMaxCodeSize
+		case len(ret) > 0 && ret[0] == 0xef:
+			err = ErrInvalidCode
+		case deposit > gas:
+			err = ErrOutOfGas
+		default:
+			gas -= deposit
+			if len(ret) > 0 {
+				v.bc.stateDB.SetCode(addr, ret)
+			}
+		}
+	}
+
+	if err != nil {
+		v.bc.stateDB.RevertToSnapshot(snapshot)
+		v.bc.txLogs = v.bc.txLogs[:logs]
+		if !errors.Is(err, ErrExecutionReverted) {
+			gas = 0
+		}
+	}
+	return ret, gas, err
+}
+
+// blockHash returns the hash of one of the 256 blocks before the one being
+// executed, or zero
+func (v *vm) blockHash(height uint64) [32]byte {
+	current := v.header.Height
+	if height >= current || current-height > blockhashWindow {
+		return [32]byte{}
+	}
+	if height == current-1 {
+		return v.header.PrevHash
+	}
+	block, err := v.bc.loadBlockByHeight(height)
+	if err != nil {
+		return [32]byte{}
+	}
+	return block.Hash()
+}
+
+// checkHeadState fails unless height is the head, the only height contract
+// state is available at. Callers must hold bc.mu.
+func (bc *Blockchain) checkHeadState(height uint64) error {
+	head := bc.currentBlock.Header.Height
+	if height > head {
+		return fmt.Errorf("block %d not found", height)
+	}
+	if height < head {
+		return fmt.Errorf("%w: block %d is pruned, contract state is only kept for the head", ErrStatePruned, height)
+	}
+	return nil
+}
+
+// pendingHeader returns the header of a block built on the head now, for
+// executing calls and estimates. Callers must hold bc.mu.
+func (bc *Blockchain) pendingHeader() *BlockHeader {
+	head := &bc.currentBlock.Header
+	header := &BlockHeader{
+		Height:       head.Height + 1,
+		Timestamp:    uint64(time.Now().Unix()),
+		PrevHash:     bc.currentBlock.Hash(),
+		ProposerAddr: head.ProposerAddr,
+		GasLimit:     head.GasLimit,
+	}
+	if header.Timestamp < head.Timestamp {
+		header.Timestamp = head.Timestamp
+	}
+	return header
+}
+
+// isSystemAddress reports whether an address is one of the system accounts
+// at 0x0101 and up, whose balances the chain accounts for itself
+func isSystemAddress(addr [20]byte) bool {
+	for _, b := range addr[:18] {
+		if b != 0 {
+			return false
+		}
+	}
+	return addr[18] == 0x01
+}
+
+// revertReason decodes the message of a Solidity Error(string) revert
+func revertReason(data []byte) (string, bool) {
+	if len(data) < 4+64 || [4]byte(data[:4]) != [4]byte{0x08, 0xc3, 0x79, 0xa0} {
+		return "", false
+	}
+	offset := new(big.Int).SetBytes(data[4:36])
+	if !offset.IsUint64() || offset.Uint64()+32 > uint64(len(data)-4) {
+		return "", false
+	}
+	start := 4 + offset.Uint64()
+	length := new(big.Int).SetBytes(data[start : start+32])
+	if !length.IsUint64() || start+32+length.Uint64() > uint64(len(data)) {
+		return "", false
+	}
+	return string(data[start+32 : start+32+length.Uint64()]), true
+}
+
+func keccak256Hash(data ...[]byte) [32]byte {
+	var hash [32]byte
+	hasher := sha3.NewLegacyKeccak256()
+	for _, d := range data {
+		hasher.Write(d)
+	}
+	hasher.Sum(hash[:0])
+	return hash
+}
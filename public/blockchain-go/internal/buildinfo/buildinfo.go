@@ -0,0 +1,138 @@
+// Package buildinfo identifies the build a node runs: its release version,
+// the git commit it was built from, when that commit was made, the Go
+// toolchain and the features compiled in.
+//
+// Builds are reproducible: nothing depends on the time or machine of the
+// build. The commit and its date are read from the VCS stamp the Go
+// toolchain embeds when building inside a git checkout, and can be set
+// instead with -ldflags for builds from a source archive:
+//
+//	go build -trimpath -ldflags "-X chaincore/internal/buildinfo.Commit=$(git rev-parse HEAD) \
+//	    -X chaincore/internal/buildinfo.Date=$(git log -1 --format=%cI)" ./cmd/fullnode
+//
+// The date is the commit's, not the build's, so building the same commit
+// twice gives the same binary.
+package buildinfo
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Version is the release version
+const Version = "1.0.0"
+
+// clientName prefixes client version strings
+const clientName = "GYDS"
+
+// Set with -ldflags -X when the toolchain's VCS stamp is unavailable
+var (
+	Commit   string // Full commit hash
+	Date     string // Commit date, RFC 3339
+	Features string // Comma-separated features beyond those the build settings show
+)
+
+// Info describes a build
+type Info struct {
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit,omitempty"`
+	Date      string   `json:"date,omitempty"`
+	Dirty     bool     `json:"dirty"` // Built with uncommitted changes
+	GoVersion string   `json:"goVersion"`
+	OS        string   `json:"os"`
+	Arch      string   `json:"arch"`
+	Features  []string `json:"features"`
+}
+
+var (
+	info     Info
+	infoOnce sync.Once
+)
+
+// Get returns the running build's information
+func Get() Info {
+	infoOnce.Do(func() {
+		info = read()
+	})
+	return info
+}
+
+// ClientVersion returns the build as a web3_clientVersion string, such as
+// GYDS/v1.0.0-1a2b3c4d/linux-amd64/go1.21.5
+func (i Info) ClientVersion() string {
+	return fmt.Sprintf("%s/v%s/%s-%s/%s", clientName, i.VersionWithCommit(), i.OS, i.Arch, i.GoVersion)
+}
+
+// VersionWithCommit returns the version followed by the first 8 characters
+// of the commit, and -dirty for a build with uncommitted changes
+func (i Info) VersionWithCommit() string {
+	v := i.Version
+	if len(i.Commit) >= 8 {
+		v += "-" + i.Commit[:8]
+	}
+	if i.Dirty {
+		v += "-dirty"
+	}
+	return v
+}
+
+// Helper functions
+
+// read gathers the build information from the toolchain's stamp and the
+// linker-set variables, which take precedence
+func read() Info {
+	i := Info{
+		Version:   Version,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+
+	features := make(map[string]bool)
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				i.Commit = s.Value
+			case "vcs.time":
+				i.Date = s.Value
+			case "vcs.modified":
+				i.Dirty = s.Value == "true"
+			case "-tags":
+				for _, tag := range strings.Split(s.Value, ",") {
+					if tag = strings.TrimSpace(tag); tag != "" {
+						features[tag] = true
+					}
+				}
+			case "-race":
+				features["race"] = s.Value == "true"
+			case "CGO_ENABLED":
+				features["cgo"] = s.Value == "1"
+			}
+		}
+	}
+	if Commit != "" {
+		i.Commit, i.Dirty = Commit, false
+	}
+	if Date != "" {
+		i.Date = Date
+	}
+	for _, f := range strings.Split(Features, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			features[f] = true
+		}
+	}
+
+	i.Features = make([]string, 0, len(features))
+	for f, enabled := range features {
+		if enabled {
+			i.Features = append(i.Features, f)
+		}
+	}
+	sort.Strings(i.Features)
+	return i
+}
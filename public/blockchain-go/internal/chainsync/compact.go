@@ -0,0 +1,281 @@
+// Package chainsync - Compact block relay and transaction gossip
+package chainsync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/network"
+)
+
+// shortIDSize is the length of a transaction's short ID in a compact block
+const shortIDSize = 6
+
+// maxBlockTxsRounds bounds how many times missing transactions are
+// requested before a compact block is left to block sync
+const maxBlockTxsRounds = 4
+
+// compactBlock is a block without its transactions, which are given by
+// short IDs salted with the block hash so they cannot be made to collide
+type compactBlock struct {
+	Block    *blockchain.Block `json:"block"`
+	ShortIDs []byte            `json:"shortIds"` // shortIDSize bytes per transaction, in block order
+}
+
+// blockTxsRequest asks the announcing peer for a compact block's
+// transactions by index
+type blockTxsRequest struct {
+	ID      uint64   `json:"id"`
+	Height  uint64   `json:"height"`
+	Hash    [32]byte `json:"hash"`
+	Indexes []int    `json:"indexes"`
+}
+
+// AnnounceBlock tells peers about a block this node imported: a compact
+// block to peers that understand one and its hash to the rest
+func (s *Syncer) AnnounceBlock(block *blockchain.Block) {
+	s.announce(block, "")
+}
+
+// announce sends a block's announcement to every peer but except
+func (s *Syncer) announce(block *blockchain.Block, except string) {
+	hash := block.Hash()
+	header := *block
+	header.Transactions = nil
+	payload, err := json.Marshal(compactBlock{Block: &header, ShortIDs: txShortIDs(hash, block.Transactions)})
+	if err != nil {
+		logger.Error("Failed to encode compact block", "height", block.Header.Height, "err", err)
+		return
+	}
+
+	for _, peer := range s.net.GetPeers() {
+		if peer.ID == except {
+			continue
+		}
+		msg := &network.Message{Type: network.MsgCompactBlock, Payload: payload}
+		if peer.Version < network.CompactBlocksVersion {
+			msg = &network.Message{Type: network.MsgBlockAnnounce, Payload: hash[:]}
+		}
+		s.net.SendTo(peer.ID, msg)
+	}
+}
+
+// handleCompactBlock starts reconstructing an announced compact block. It
+// returns at once, as reconstruction waits on the network's own handlers.
+func (s *Syncer) handleCompactBlock(msg *network.Message) error {
+	var compact compactBlock
+	if err := json.Unmarshal(msg.Payload, &compact); err != nil || compact.Block == nil {
+		return fmt.Errorf("%w: malformed compact block", ErrInvalidMessage)
+	}
+	if len(compact.ShortIDs)%shortIDSize != 0 {
+		return fmt.Errorf("%w: compact block short IDs are not %d bytes each", ErrInvalidMessage, shortIDSize)
+	}
+
+	hash := compact.Block.Hash()
+	s.mu.Lock()
+	busy := s.reconstructing[hash]
+	s.reconstructing[hash] = true
+	s.mu.Unlock()
+	if busy {
+		return nil
+	}
+
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.reconstructing, hash)
+			s.mu.Unlock()
+		}()
+		if err := s.reconstruct(msg.From, &compact); err != nil {
+			if !errors.Is(err, errStopped) {
+				logger.Debug("Failed to reconstruct compact block", "height", compact.Block.Header.Height, "peer", shortID(msg.From), "err", err)
+			}
+			s.wake()
+		}
+	}()
+	return nil
+}
+
+// reconstruct rebuilds a compact block from the pool and the announcing
+// peer, imports it and relays it
+func (s *Syncer) reconstruct(peerID string, compact *compactBlock) error {
+	block := compact.Block
+	hash := block.Hash()
+	head := s.chain.GetCurrentBlock()
+	if block.Header.Height <= head.Header.Height {
+		return nil
+	}
+	if block.Header.Height != head.Header.Height+1 || block.Header.PrevHash != head.Hash() {
+		s.wake()
+		return nil
+	}
+
+	// Short IDs two pooled transactions share are left for the peer
+	pooled := make(map[string]*blockchain.Transaction)
+	ambiguous := make(map[string]bool)
+	for _, txs := range s.chain.PoolContent() {
+		for _, tx := range txs {
+			id := string(txShortID(hash, tx))
+			if _, exists := pooled[id]; exists {
+				ambiguous[id] = true
+			}
+			pooled[id] = tx
+		}
+	}
+
+	count := len(compact.ShortIDs) / shortIDSize
+	txs := make([]*blockchain.Transaction, count)
+	var missing []int
+	for i := 0; i < count; i++ {
+		id := string(compact.ShortIDs[i*shortIDSize : (i+1)*shortIDSize])
+		if tx, ok := pooled[id]; ok && !ambiguous[id] {
+			txs[i] = tx
+		} else {
+			missing = append(missing, i)
+		}
+	}
+
+	for round := 0; len(missing) > 0; round++ {
+		if round == maxBlockTxsRounds {
+			return fmt.Errorf("%d transactions still missing", len(missing))
+		}
+		fetched, err := s.requestBlockTxs(peerID, block, missing)
+		if err != nil {
+			return err
+		}
+		for i, tx := range fetched {
+			index := missing[i]
+			if string(txShortID(hash, tx)) != string(compact.ShortIDs[index*shortIDSize:(index+1)*shortIDSize]) {
+				return fmt.Errorf("peer %s returned the wrong transaction %d", shortID(peerID), index)
+			}
+			txs[index] = tx
+		}
+		missing = missing[len(fetched):]
+	}
+
+	full := *block
+	full.Transactions = make([]blockchain.Transaction, count)
+	for i, tx := range txs {
+		full.Transactions[i] = *tx
+	}
+	if err := full.VerifyRoots(nil); err != nil {
+		return err
+	}
+	if err := s.chain.InsertBlock(&full); err != nil {
+		if errors.Is(err, blockchain.ErrKnownBlock) {
+			return nil
+		}
+		return err
+	}
+	s.noteHead(s.chain.GetCurrentBlock().Header.Height)
+	s.announce(&full, peerID)
+	return nil
+}
+
+// requestBlockTxs fetches the transactions at indexes of a block from the
+// peer that announced it. The peer may return fewer than asked for, but
+// none out of order.
+func (s *Syncer) requestBlockTxs(peerID string, block *blockchain.Block, indexes []int) ([]*blockchain.Transaction, error) {
+	body, err := s.roundTrip(peerID, requestBlockTxs, func(id uint64) *network.Message {
+		payload, _ := json.Marshal(blockTxsRequest{ID: id, Height: block.Header.Height, Hash: block.Hash(), Indexes: indexes})
+		return &network.Message{Type: network.MsgTxRequest, Payload: payload}
+	})
+	if err != nil {
+		return nil, err
+	}
+	var txs []*blockchain.Transaction
+	if err := json.Unmarshal(body, &txs); err != nil {
+		return nil, err
+	}
+	if len(txs) == 0 || len(txs) > len(indexes) {
+		return nil, fmt.Errorf("peer %s returned %d of %d transactions", shortID(peerID), len(txs), len(indexes))
+	}
+	for _, tx := range txs {
+		if tx == nil {
+			return nil, fmt.Errorf("peer %s returned a null transaction", shortID(peerID))
+		}
+	}
+	return txs, nil
+}
+
+// handleBlockTxsRequest serves transactions of a canonical block by index
+func (s *Syncer) handleBlockTxsRequest(msg *network.Message) error {
+	var req blockTxsRequest
+	if err := json.Unmarshal(msg.Payload, &req); err != nil {
+		return fmt.Errorf("%w: malformed transaction request", ErrInvalidMessage)
+	}
+
+	items, size := make([]json.RawMessage, 0, len(req.Indexes)), 0
+	if block, err := s.chain.GetBlock(req.Height); err == nil && block.Hash() == req.Hash {
+		for _, index := range req.Indexes {
+			if index < 0 || index >= len(block.Transactions) {
+				break
+			}
+			var added bool
+			if items, added = appendWithinLimit(items, &size, &block.Transactions[index]); !added {
+				break
+			}
+		}
+	}
+
+	payload, err := encodeResponse(requestBlockTxs, req.ID, items)
+	if err != nil {
+		return err
+	}
+	return s.net.SendTo(msg.From, &network.Message{Type: network.MsgTxResponse, Payload: payload})
+}
+
+// relayTransaction gossips a transaction that entered the pool. Peers
+// that already have it drop it, so gossip ends once everyone does.
+func (s *Syncer) relayTransaction(tx *blockchain.Transaction) {
+	encoded, err := json.Marshal(tx)
+	if err != nil {
+		return
+	}
+	s.net.BroadcastTx(encoded)
+}
+
+// handleTxAnnounce adds a gossiped transaction to the pool, which relays
+// it on if it is new
+func (s *Syncer) handleTxAnnounce(msg *network.Message) error {
+	var tx blockchain.Transaction
+	if err := json.Unmarshal(msg.Payload, &tx); err != nil {
+		return fmt.Errorf("%w: malformed transaction", ErrInvalidMessage)
+	}
+	// The sender and hash come from the peer; recover them from the
+	// signature before the transaction is pooled or relayed further
+	if err := tx.Verify(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+	err := s.chain.AddTransaction(context.Background(), &tx)
+	if err != nil && !errors.Is(err, blockchain.ErrTxKnown) {
+		return err
+	}
+	return nil
+}
+
+// txShortIDs returns the short IDs of a block's transactions, in order
+func txShortIDs(blockHash [32]byte, txs []blockchain.Transaction) []byte {
+	ids := make([]byte, 0, len(txs)*shortIDSize)
+	for i := range txs {
+		ids = append(ids, txShortID(blockHash, &txs[i])...)
+	}
+	return ids
+}
+
+// txShortID returns a transaction's short ID within a block. It covers the
+// hash the transaction was submitted with, which receipts record, as well
+// as its contents.
+func txShortID(blockHash [32]byte, tx *blockchain.Transaction) []byte {
+	content := tx.ComputeHash()
+	data := make([]byte, 0, 96)
+	data = append(data, blockHash[:]...)
+	data = append(data, content[:]...)
+	data = append(data, tx.Hash[:]...)
+	sum := sha256.Sum256(data)
+	return sum[:shortIDSize]
+}
@@ -0,0 +1,100 @@
+// Package chainsync - Block request and response messages
+package chainsync
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Request kinds, carried by MsgBlockRequest and echoed by the matching
+// MsgBlockResponse, except for compact block transactions
+const (
+	requestStatus        byte = iota // The peer's head height and hash
+	requestHeaders                   // Headers of a height range
+	requestBodies                    // Full blocks of a height range
+	requestSnapshot                  // Manifest of the newest state snapshot served
+	requestSnapshotChunk             // Chunk Count of the snapshot at height Start
+	requestBlockTxs                  // Transactions of a compact block, sent as MsgTxRequest
+)
+
+// Wire limits. Responses stay under the network's 1 MiB frame limit; a
+// peer returns fewer items than asked for rather than exceed it.
+const (
+	requestSize          = 1 + 8 + 8 + 4 // Kind, request ID, start height, count
+	responseHeaderSize   = 1 + 8         // Kind, request ID
+	maxResponseSize      = 768 * 1024
+	maxHeadersPerRequest = 192
+	maxBodiesPerRequest  = 32
+)
+
+// ErrInvalidMessage is returned for block requests and responses that
+// cannot be decoded
+var ErrInvalidMessage = errors.New("invalid block sync message")
+
+// blockRequest asks a peer for its status or for Count items from height
+// Start. The ID matches the response to the request.
+type blockRequest struct {
+	Kind  byte
+	ID    uint64
+	Start uint64
+	Count uint32
+}
+
+// headStatus is the body of a status response
+type headStatus struct {
+	Height uint64   `json:"height"`
+	Hash   [32]byte `json:"hash"`
+}
+
+func encodeRequest(r blockRequest) []byte {
+	data := make([]byte, 0, requestSize)
+	data = append(data, r.Kind)
+	data = binary.BigEndian.AppendUint64(data, r.ID)
+	data = binary.BigEndian.AppendUint64(data, r.Start)
+	data = binary.BigEndian.AppendUint32(data, r.Count)
+	return data
+}
+
+func decodeRequest(data []byte) (*blockRequest, error) {
+	if len(data) != requestSize {
+		return nil, fmt.Errorf("%w: request is %d bytes, want %d", ErrInvalidMessage, len(data), requestSize)
+	}
+	return &blockRequest{
+		Kind:  data[0],
+		ID:    binary.BigEndian.Uint64(data[1:9]),
+		Start: binary.BigEndian.Uint64(data[9:17]),
+		Count: binary.BigEndian.Uint32(data[17:21]),
+	}, nil
+}
+
+// encodeResponse frames a JSON body with the request's kind and ID
+func encodeResponse(kind byte, id uint64, body interface{}) ([]byte, error) {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, 0, responseHeaderSize+len(encoded))
+	data = append(data, kind)
+	data = binary.BigEndian.AppendUint64(data, id)
+	return append(data, encoded...), nil
+}
+
+func decodeResponse(data []byte) (byte, uint64, []byte, error) {
+	if len(data) < responseHeaderSize {
+		return 0, 0, nil, fmt.Errorf("%w: response is %d bytes", ErrInvalidMessage, len(data))
+	}
+	return data[0], binary.BigEndian.Uint64(data[1:9]), data[responseHeaderSize:], nil
+}
+
+// appendWithinLimit adds an item's encoding to items unless that would take
+// the response past maxResponseSize. It reports whether the item was added.
+func appendWithinLimit(items []json.RawMessage, size *int, item interface{}) ([]json.RawMessage, bool) {
+	encoded, err := json.Marshal(item)
+	if err != nil || *size+len(encoded)+1 > maxResponseSize {
+		return items, false
+	}
+	*size += len(encoded) + 1
+	return append(items, encoded), true
+}
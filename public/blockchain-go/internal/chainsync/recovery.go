@@ -0,0 +1,193 @@
+// Package chainsync - Stalled sync detection and recovery
+package chainsync
+
+import (
+	"time"
+)
+
+// Recovery limits
+const (
+	// recoveryInterval is the time between recovery attempts while stalled
+	recoveryInterval = time.Minute
+
+	// snapshotFallbackAttempts is how many peer rotations a chain stalled at
+	// genesis goes through before falling back to a snapshot
+	snapshotFallbackAttempts = 2
+)
+
+// Health statuses
+const (
+	HealthOK      = "ok"
+	HealthSyncing = "syncing"
+	HealthStalled = "stalled"
+)
+
+// Recovery actions
+const (
+	RecoveryRotatePeer = "rotate-peer"
+	RecoverySnapshot   = "snapshot"
+)
+
+// Recovery describes the syncer's stall recovery, which sets the followed
+// peer aside when the head has not moved for StallTimeout with peers ahead
+type Recovery struct {
+	Stalled      bool      `json:"stalled"`
+	Since        time.Time `json:"since,omitempty"` // When the stall was detected
+	Attempts     int       `json:"attempts"`
+	LastAttempt  time.Time `json:"lastAttempt,omitempty"`
+	LastAction   string    `json:"lastAction,omitempty"`
+	AvoidedPeers int       `json:"avoidedPeers"` // Peers currently set aside
+}
+
+// Health describes the state of block sync, as reported on /health
+type Health struct {
+	Status       string    `json:"status"`
+	CurrentBlock uint64    `json:"currentBlock"`
+	HighestBlock uint64    `json:"highestBlock"` // Highest head reported by a peer
+	Peers        int       `json:"peers"`
+	LastImport   time.Time `json:"lastImport"` // When the local head last moved
+	Recovery     Recovery  `json:"recovery"`
+}
+
+// Health reports whether the node is keeping up with its peers
+func (s *Syncer) Health() Health {
+	current := s.chain.GetCurrentBlock().Header.Height
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	health := Health{
+		Status:       HealthOK,
+		CurrentBlock: current,
+		HighestBlock: s.peerHeight,
+		Peers:        s.peerCount,
+		LastImport:   s.lastImport,
+		Recovery:     s.recovery,
+	}
+	health.Recovery.AvoidedPeers = s.avoidedPeers(time.Now())
+	if health.HighestBlock < current {
+		health.HighestBlock = current
+	}
+	switch {
+	case s.recovery.Stalled:
+		health.Status = HealthStalled
+	case s.syncing:
+		health.Status = HealthSyncing
+	}
+	return health
+}
+
+// Helper functions
+
+// noteHead records the local head, ending a stall if it moved
+func (s *Syncer) noteHead(height uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if height == s.lastHeight {
+		return
+	}
+	s.lastHeight, s.lastImport = height, time.Now()
+	if s.recovery.Stalled {
+		logger.Info("Block sync recovered", "height", height, "attempts", s.recovery.Attempts)
+		s.recovery = Recovery{}
+	}
+}
+
+// checkStall records the peers' heads and, if the node is stalled and a
+// recovery attempt is due, sets the followed peer aside. It reports whether
+// the attempt should fall back to a state snapshot.
+func (s *Syncer) checkStall(heads []peerHead, local uint64) bool {
+	var highest uint64
+	for _, head := range heads {
+		if head.height > highest {
+			highest = head.height
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.peerHeight, s.peerCount = highest, len(heads)
+	if highest <= local {
+		if s.recovery.Stalled {
+			logger.Info("Block sync no longer stalled: no peer is ahead", "height", local)
+			s.recovery = Recovery{}
+		}
+		return false
+	}
+	if now.Sub(s.lastImport) < s.config.StallTimeout {
+		return false
+	}
+
+	if !s.recovery.Stalled {
+		s.recovery = Recovery{Stalled: true, Since: now}
+		logger.Warn("Block sync stalled; recovering", "since", now.Sub(s.lastImport).Round(time.Second),
+			"peerHeight", highest, "height", local)
+	}
+	if now.Sub(s.recovery.LastAttempt) < recoveryInterval {
+		return false
+	}
+	s.recovery.Attempts++
+	s.recovery.LastAttempt = now
+
+	if local == 0 && s.config.SnapshotFallback && s.recovery.Attempts > snapshotFallbackAttempts {
+		s.recovery.LastAction = RecoverySnapshot
+		logger.Warn("Block sync recovery: restoring a state snapshot", "attempt", s.recovery.Attempts)
+		return true
+	}
+
+	s.recovery.LastAction = RecoveryRotatePeer
+	if s.following != "" {
+		s.avoid[s.following] = now.Add(s.config.StallTimeout)
+		logger.Warn("Block sync recovery: setting peer aside", "attempt", s.recovery.Attempts, "peer", shortID(s.following))
+		s.following = ""
+	}
+	return false
+}
+
+// bestPeer picks the most advanced peer not set aside by a recovery, or the
+// most advanced of all if every peer ahead is set aside, and remembers it
+// as the followed peer
+func (s *Syncer) bestPeer(heads []peerHead) peerHead {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.avoidedPeers(now)
+
+	best, found := heads[0], false
+	for _, head := range heads {
+		if _, avoided := s.avoid[head.id]; avoided {
+			continue
+		}
+		if !found || head.height > best.height {
+			best, found = head, true
+		}
+	}
+	if !found || (best.height <= s.lastHeight && s.peerHeight > s.lastHeight) {
+		// Every peer ahead has been set aside; try them all again
+		for id := range s.avoid {
+			delete(s.avoid, id)
+		}
+		for _, head := range heads {
+			if head.height > best.height {
+				best = head
+			}
+		}
+	}
+	s.following = best.id
+	return best
+}
+
+// avoidedPeers drops the peers whose time aside is over and returns how
+// many remain. The caller must hold mu.
+func (s *Syncer) avoidedPeers(now time.Time) int {
+	for id, until := range s.avoid {
+		if !now.Before(until) {
+			delete(s.avoid, id)
+		}
+	}
+	return len(s.avoid)
+}
@@ -0,0 +1,210 @@
+// Package chainsync - State snapshot download for fast sync
+package chainsync
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/network"
+)
+
+// snapshotConfirmations is how many peers not offering a snapshot are asked
+// for its block header
+const snapshotConfirmations = 3
+
+// snapshotOffer is a snapshot and the peers serving it
+type snapshotOffer struct {
+	manifest *blockchain.SnapshotManifest
+	peers    []string
+}
+
+// syncSnapshot restores the newest snapshot offered by peers. The snapshot
+// block's header must be confirmed by a majority of the peers asked, those
+// offering the snapshot included, and the chain checks the chunks against
+// the header's state root. It reports false if no peer offers a snapshot,
+// in which case the chain is synced from genesis.
+func (s *Syncer) syncSnapshot(heads []peerHead) (bool, error) {
+	offer := s.bestSnapshot()
+	if offer == nil {
+		return false, nil
+	}
+	manifest := offer.manifest
+
+	s.mu.Lock()
+	s.syncing, s.startingBlock, s.highestBlock = true, 0, manifest.Height
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.syncing = false
+		s.mu.Unlock()
+	}()
+	logger.Info("Restoring state snapshot", "height", manifest.Height, "accounts", manifest.Accounts,
+		"chunks", len(manifest.Chunks), "peers", len(offer.peers))
+
+	headers, err := s.requestHeaders(offer.peers[0], manifest.Height, 1)
+	if err != nil {
+		return false, err
+	}
+	if headerHash(headers[0]) != manifest.BlockHash {
+		return false, fmt.Errorf("peer %s offered a snapshot of a block it does not have", shortID(offer.peers[0]))
+	}
+	if err := s.confirmHeader(heads, offer, headers[0]); err != nil {
+		return false, err
+	}
+
+	blocks := make([]*blockchain.Block, 1)
+	if err := s.fetchChunk(offer.peers, 0, headers, blocks); err != nil {
+		return false, err
+	}
+	chunks, err := s.fetchSnapshotChunks(offer)
+	if err != nil {
+		return false, err
+	}
+	if err := s.chain.RestoreSnapshot(blocks[0], manifest, chunks); err != nil {
+		return false, err
+	}
+	logger.Info("Restored state snapshot", "height", manifest.Height)
+	return true, nil
+}
+
+// bestSnapshot asks the peers serving snapshots for their newest and picks
+// the highest, preferring the one most peers offer at equal heights
+func (s *Syncer) bestSnapshot() *snapshotOffer {
+	peers := s.net.PeersWithCapability(network.CapServesStateSnapshots)
+	type reply struct {
+		id       string
+		manifest *blockchain.SnapshotManifest
+		key      string
+	}
+	results := make(chan reply, len(peers))
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			body, err := s.request(id, requestSnapshot, 0, 0)
+			if err != nil {
+				return
+			}
+			var manifest *blockchain.SnapshotManifest
+			if json.Unmarshal(body, &manifest) != nil || manifest == nil || manifest.Height == 0 {
+				return
+			}
+			key, err := json.Marshal(manifest)
+			if err == nil {
+				results <- reply{id: id, manifest: manifest, key: string(key)}
+			}
+		}(peer.ID)
+	}
+	wg.Wait()
+	close(results)
+
+	offers := make(map[string]*snapshotOffer)
+	var best *snapshotOffer
+	for r := range results {
+		offer, exists := offers[r.key]
+		if !exists {
+			offer = &snapshotOffer{manifest: r.manifest}
+			offers[r.key] = offer
+		}
+		offer.peers = append(offer.peers, r.id)
+	}
+	for _, offer := range offers {
+		if best == nil || offer.manifest.Height > best.manifest.Height ||
+			(offer.manifest.Height == best.manifest.Height && len(offer.peers) > len(best.peers)) {
+			best = offer
+		}
+	}
+	return best
+}
+
+// confirmHeader asks peers that do not offer the snapshot for the header at
+// its height. The peers offering the snapshot count as agreeing; the header
+// is rejected unless more peers agree than disagree.
+func (s *Syncer) confirmHeader(heads []peerHead, offer *snapshotOffer, header blockchain.BlockHeader) error {
+	offering := make(map[string]bool, len(offer.peers))
+	for _, id := range offer.peers {
+		offering[id] = true
+	}
+
+	agree, disagree, asked := len(offer.peers), 0, 0
+	for _, head := range heads {
+		if asked == snapshotConfirmations {
+			break
+		}
+		if offering[head.id] || head.height < header.Height {
+			continue
+		}
+		asked++
+		headers, err := s.requestHeaders(head.id, header.Height, 1)
+		if err != nil {
+			continue
+		}
+		if headerHash(headers[0]) == headerHash(header) {
+			agree++
+		} else {
+			disagree++
+		}
+	}
+	if disagree >= agree {
+		return fmt.Errorf("%d of %d peers dispute snapshot block %d", disagree, agree+disagree, header.Height)
+	}
+	return nil
+}
+
+// fetchSnapshotChunks downloads the chunks of a snapshot in parallel from
+// the peers offering it, checking each against its hash in the manifest
+func (s *Syncer) fetchSnapshotChunks(offer *snapshotOffer) ([][]byte, error) {
+	manifest := offer.manifest
+	chunks := make([][]byte, len(manifest.Chunks))
+	errs := make(chan error, len(manifest.Chunks))
+	slots := make(chan struct{}, s.config.MaxParallel)
+	var wg sync.WaitGroup
+
+	for i := range manifest.Chunks {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(index int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			var lastErr error
+			for attempt := 0; attempt < len(offer.peers); attempt++ {
+				peerID := offer.peers[(index+attempt)%len(offer.peers)]
+				chunk, err := s.requestSnapshotChunk(peerID, manifest, uint32(index))
+				if err != nil {
+					lastErr = err
+					continue
+				}
+				chunks[index] = chunk
+				errs <- nil
+				return
+			}
+			errs <- fmt.Errorf("snapshot chunk %d: %w", index, lastErr)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return chunks, nil
+}
+
+// requestSnapshotChunk fetches one chunk from a peer and checks its hash
+func (s *Syncer) requestSnapshotChunk(peerID string, manifest *blockchain.SnapshotManifest, index uint32) ([]byte, error) {
+	body, err := s.request(peerID, requestSnapshotChunk, manifest.Height, index)
+	if err != nil {
+		return nil, err
+	}
+	if sha256.Sum256(body) != manifest.Chunks[index] {
+		return nil, fmt.Errorf("peer %s returned a bad chunk %d of snapshot %d", shortID(peerID), index, manifest.Height)
+	}
+	return body, nil
+}
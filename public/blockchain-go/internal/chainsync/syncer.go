@@ -0,0 +1,591 @@
+// Package chainsync downloads the chain from peers and serves it to them
+package chainsync
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/logging"
+	"chaincore/internal/network"
+)
+
+// logger is the chainsync module's logger
+var logger = logging.New("chainsync")
+
+// Syncer defaults, used when the config leaves them zero
+const (
+	defaultPollInterval   = 10 * time.Second
+	defaultRequestTimeout = 10 * time.Second
+	defaultMaxParallel    = 4
+	defaultStallTimeout   = 5 * time.Minute
+
+	// maxAncestorSearch bounds how many header batches the syncer steps back
+	// looking for the block where a peer's chain joins ours
+	maxAncestorSearch = 8
+)
+
+// errStopped is returned for requests cut short by Stop
+var errStopped = errors.New("syncer stopped")
+
+// Config holds syncer configuration
+type Config struct {
+	PollInterval   time.Duration // How often peers are asked for their head
+	RequestTimeout time.Duration // How long to wait for a peer's response
+	MaxParallel    int           // Body and snapshot chunk requests in flight at once
+	SnapshotSync   bool          // Start a chain still at genesis from a peer's state snapshot
+
+	StallTimeout     time.Duration // Time without an import, with peers ahead, before recovering
+	SnapshotFallback bool          // Let a recovery restore a snapshot if the chain is stalled at genesis
+}
+
+// Progress describes a sync in progress, as reported by eth_syncing
+type Progress struct {
+	Syncing       bool
+	StartingBlock uint64 // Local head when the sync started
+	CurrentBlock  uint64
+	HighestBlock  uint64 // Highest head reported by a peer
+}
+
+// Syncer downloads blocks from peers and answers their block requests. It
+// follows the most advanced peer's headers and fetches bodies in parallel.
+type Syncer struct {
+	chain         *blockchain.Blockchain
+	net           *network.P2PNetwork
+	config        Config
+	pending       map[uint64]*pendingRequest // By request ID
+	nextID        uint64
+	syncing       bool
+	startingBlock uint64
+	highestBlock  uint64
+	wakeCh        chan struct{}
+	stopCh        chan struct{}
+
+	lastHeight uint64               // Local head when last seen to move
+	lastImport time.Time            // When the local head last moved
+	peerHeight uint64               // Highest head in the last poll
+	peerCount  int                  // Peers that answered the last poll
+	following  string               // Peer headers were last fetched from
+	avoid      map[string]time.Time // Peers rotated out by a recovery, until when
+	recovery   Recovery
+
+	reconstructing map[[32]byte]bool // Compact blocks being reconstructed, by hash
+	mu             sync.Mutex
+}
+
+// pendingRequest is a request waiting for its response
+type pendingRequest struct {
+	peer  string
+	kind  byte
+	reply chan []byte
+}
+
+// peerHead is a peer's reported head
+type peerHead struct {
+	id     string
+	height uint64
+}
+
+// NewSyncer creates a syncer and registers its message handlers, so it must
+// be called before the network starts
+func NewSyncer(chain *blockchain.Blockchain, net *network.P2PNetwork, config Config) (*Syncer, error) {
+	if config.PollInterval <= 0 {
+		config.PollInterval = defaultPollInterval
+	}
+	if config.RequestTimeout <= 0 {
+		config.RequestTimeout = defaultRequestTimeout
+	}
+	if config.MaxParallel <= 0 {
+		config.MaxParallel = defaultMaxParallel
+	}
+	if config.StallTimeout <= 0 {
+		config.StallTimeout = defaultStallTimeout
+	}
+
+	s := &Syncer{
+		chain:   chain,
+		net:     net,
+		config:  config,
+		pending: make(map[uint64]*pendingRequest),
+		wakeCh:  make(chan struct{}, 1),
+		stopCh:  make(chan struct{}),
+
+		lastHeight: chain.GetCurrentBlock().Header.Height,
+		lastImport: time.Now(),
+		avoid:      make(map[string]time.Time),
+
+		reconstructing: make(map[[32]byte]bool),
+	}
+	handlers := map[network.MessageType]network.MessageHandler{
+		network.MsgBlockRequest:  s.handleRequest,
+		network.MsgBlockResponse: s.handleResponse,
+		network.MsgBlockAnnounce: s.handleAnnounce,
+		network.MsgCompactBlock:  s.handleCompactBlock,
+		network.MsgTxRequest:     s.handleBlockTxsRequest,
+		network.MsgTxResponse:    s.handleResponse,
+		network.MsgTxAnnounce:    s.handleTxAnnounce,
+	}
+	for msgType, handler := range handlers {
+		if err := net.RegisterHandler(msgType, handler); err != nil {
+			return nil, err
+		}
+	}
+	chain.OnPendingTransaction(s.relayTransaction)
+	return s, nil
+}
+
+// Start begins following peers
+func (s *Syncer) Start() {
+	go s.run()
+}
+
+// Stop ends syncing; requests in flight are abandoned
+func (s *Syncer) Stop() {
+	select {
+	case <-s.stopCh:
+	default:
+		close(s.stopCh)
+	}
+}
+
+// Progress returns the sync state
+func (s *Syncer) Progress() Progress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return Progress{
+		Syncing:       s.syncing,
+		StartingBlock: s.startingBlock,
+		CurrentBlock:  s.chain.GetCurrentBlock().Header.Height,
+		HighestBlock:  s.highestBlock,
+	}
+}
+
+// Helper functions
+
+func (s *Syncer) run() {
+	ticker := time.NewTicker(s.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.sync()
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+		case <-s.wakeCh:
+		}
+	}
+}
+
+// sync downloads batches from the most advanced peer until its head is
+// reached
+func (s *Syncer) sync() {
+	heads := s.peerHeads()
+	local := s.chain.GetCurrentBlock().Header.Height
+	s.noteHead(local)
+	fallback := s.checkStall(heads, local)
+	if len(heads) == 0 {
+		return
+	}
+	best := s.bestPeer(heads)
+
+	if local == 0 && (s.config.SnapshotSync || fallback) {
+		restored, err := s.syncSnapshot(heads)
+		if err != nil {
+			if !errors.Is(err, errStopped) {
+				logger.Warn("Snapshot sync failed", "err", err)
+			}
+			return
+		}
+		if restored {
+			local = s.chain.GetCurrentBlock().Header.Height
+		}
+	}
+	if best.height <= local {
+		return
+	}
+	s.mu.Lock()
+	s.syncing, s.startingBlock, s.highestBlock = true, local, best.height
+	s.mu.Unlock()
+	logger.Info("Syncing blocks", "from", local+1, "to", best.height, "peers", len(heads))
+
+	defer func() {
+		s.mu.Lock()
+		s.syncing = false
+		s.mu.Unlock()
+	}()
+
+	for next := local + 1; next <= best.height; {
+		headers, err := s.fetchLinkedHeaders(best, next)
+		if err == nil {
+			err = s.importBatch(heads, headers)
+		}
+		if err != nil {
+			if !errors.Is(err, errStopped) {
+				logger.Warn("Block sync stopped", "height", s.chain.GetCurrentBlock().Header.Height, "err", err)
+			}
+			return
+		}
+		next = headers[len(headers)-1].Height + 1
+	}
+	logger.Info("Block sync caught up", "height", s.chain.GetCurrentBlock().Header.Height)
+}
+
+// importBatch downloads the bodies of headers and imports them in order
+func (s *Syncer) importBatch(heads []peerHead, headers []blockchain.BlockHeader) error {
+	blocks, err := s.fetchBodies(heads, headers)
+	if err != nil {
+		return err
+	}
+	for _, block := range blocks {
+		err := s.chain.InsertBlock(block)
+		if err != nil && !errors.Is(err, blockchain.ErrKnownBlock) {
+			return fmt.Errorf("block %d: %w", block.Header.Height, err)
+		}
+		s.noteHead(s.chain.GetCurrentBlock().Header.Height)
+	}
+	return nil
+}
+
+// fetchLinkedHeaders gets a batch of headers from start. Headers must follow
+// each other, and the first must extend a block we have, canonical or on a
+// side branch; if it does not, the peer is on another fork and earlier
+// headers are fetched until they join our chain.
+func (s *Syncer) fetchLinkedHeaders(peer peerHead, start uint64) ([]blockchain.BlockHeader, error) {
+	for step := 0; step < maxAncestorSearch; step++ {
+		count := uint64(maxHeadersPerRequest)
+		if peer.height-start+1 < count {
+			count = peer.height - start + 1
+		}
+		headers, err := s.requestHeaders(peer.id, start, count)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := s.chain.GetParent(&blockchain.Block{Header: headers[0]}); err == nil {
+			return headers, nil
+		}
+
+		if start <= 1 {
+			break
+		}
+		if start > maxHeadersPerRequest {
+			start -= maxHeadersPerRequest
+		} else {
+			start = 1
+		}
+	}
+	return nil, fmt.Errorf("peer %s: chain does not join ours within %d blocks", shortID(peer.id), maxAncestorSearch*maxHeadersPerRequest)
+}
+
+// requestHeaders fetches count headers from start and checks that they are
+// consecutive and linked
+func (s *Syncer) requestHeaders(peerID string, start, count uint64) ([]blockchain.BlockHeader, error) {
+	body, err := s.request(peerID, requestHeaders, start, uint32(count))
+	if err != nil {
+		return nil, err
+	}
+	var headers []blockchain.BlockHeader
+	if err := json.Unmarshal(body, &headers); err != nil {
+		return nil, err
+	}
+	if len(headers) == 0 {
+		return nil, fmt.Errorf("peer %s returned no headers from %d", shortID(peerID), start)
+	}
+
+	var prevHash [32]byte
+	for i := range headers {
+		if headers[i].Height != start+uint64(i) {
+			return nil, fmt.Errorf("peer %s returned header %d out of order", shortID(peerID), headers[i].Height)
+		}
+		if i > 0 && headers[i].PrevHash != prevHash {
+			return nil, fmt.Errorf("peer %s returned unlinked header %d", shortID(peerID), headers[i].Height)
+		}
+		prevHash = headerHash(headers[i])
+	}
+	return headers, nil
+}
+
+// fetchBodies downloads the blocks of headers in chunks, spreading the
+// chunks over the peers that have them, and returns them in height order
+func (s *Syncer) fetchBodies(heads []peerHead, headers []blockchain.BlockHeader) ([]*blockchain.Block, error) {
+	blocks := make([]*blockchain.Block, len(headers))
+	errs := make(chan error, (len(headers)+maxBodiesPerRequest-1)/maxBodiesPerRequest)
+	slots := make(chan struct{}, s.config.MaxParallel)
+	var wg sync.WaitGroup
+
+	for chunk, first := 0, 0; first < len(headers); chunk, first = chunk+1, first+maxBodiesPerRequest {
+		last := first + maxBodiesPerRequest
+		if last > len(headers) {
+			last = len(headers)
+		}
+		peers := make([]string, 0, len(heads))
+		for _, head := range heads {
+			if head.height >= headers[last-1].Height {
+				peers = append(peers, head.id)
+			}
+		}
+
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(chunk, first, last int, peers []string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			errs <- s.fetchChunk(peers, chunk, headers[first:last], blocks[first:last])
+		}(chunk, first, last, peers)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return blocks, nil
+}
+
+// fetchChunk fills blocks with the bodies of headers, trying the peers in
+// turn starting from one picked by chunk. A peer may return fewer bodies
+// than asked for; the rest are requested again.
+func (s *Syncer) fetchChunk(peers []string, chunk int, headers []blockchain.BlockHeader, blocks []*blockchain.Block) error {
+	if len(peers) == 0 {
+		return fmt.Errorf("no peer has block %d", headers[len(headers)-1].Height)
+	}
+
+	got := 0
+	var lastErr error
+	for attempt := 0; attempt < len(peers) && got < len(headers); attempt++ {
+		peerID := peers[(chunk+attempt)%len(peers)]
+		for got < len(headers) {
+			n, err := s.requestBodies(peerID, headers[got:], blocks[got:])
+			if err != nil {
+				lastErr = err
+				break
+			}
+			got += n
+		}
+	}
+	if got < len(headers) {
+		return fmt.Errorf("bodies from block %d: %w", headers[got].Height, lastErr)
+	}
+	return nil
+}
+
+// requestBodies fetches bodies for headers from one peer, checks each
+// against its header and returns how many it stored in blocks
+func (s *Syncer) requestBodies(peerID string, headers []blockchain.BlockHeader, blocks []*blockchain.Block) (int, error) {
+	body, err := s.request(peerID, requestBodies, headers[0].Height, uint32(len(headers)))
+	if err != nil {
+		return 0, err
+	}
+	var received []*blockchain.Block
+	if err := json.Unmarshal(body, &received); err != nil {
+		return 0, err
+	}
+	if len(received) == 0 || len(received) > len(headers) {
+		return 0, fmt.Errorf("peer %s returned %d bodies for %d headers", shortID(peerID), len(received), len(headers))
+	}
+
+	for i, block := range received {
+		if block == nil || block.Hash() != headerHash(headers[i]) {
+			return 0, fmt.Errorf("peer %s returned a body that does not match header %d", shortID(peerID), headers[i].Height)
+		}
+		if err := block.VerifyRoots(nil); err != nil {
+			return 0, fmt.Errorf("peer %s body %d: %w", shortID(peerID), headers[i].Height, err)
+		}
+		blocks[i] = block
+	}
+	return len(received), nil
+}
+
+// peerHeads asks every peer serving history for its head
+func (s *Syncer) peerHeads() []peerHead {
+	peers := s.net.PeersWithCapability(network.CapServesHistory)
+	results := make(chan peerHead, len(peers))
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			body, err := s.request(id, requestStatus, 0, 0)
+			if err != nil {
+				return
+			}
+			var status headStatus
+			if json.Unmarshal(body, &status) == nil {
+				results <- peerHead{id: id, height: status.Height}
+			}
+		}(peer.ID)
+	}
+	wg.Wait()
+	close(results)
+
+	heads := make([]peerHead, 0, len(peers))
+	for head := range results {
+		heads = append(heads, head)
+	}
+	return heads
+}
+
+// request sends a block request to a peer and waits for the response body
+func (s *Syncer) request(peerID string, kind byte, start uint64, count uint32) ([]byte, error) {
+	return s.roundTrip(peerID, kind, func(id uint64) *network.Message {
+		return &network.Message{
+			Type:    network.MsgBlockRequest,
+			Payload: encodeRequest(blockRequest{Kind: kind, ID: id, Start: start, Count: count}),
+		}
+	})
+}
+
+// roundTrip sends the request message built for a new request ID to a peer
+// and waits for the response body
+func (s *Syncer) roundTrip(peerID string, kind byte, build func(id uint64) *network.Message) ([]byte, error) {
+	s.mu.Lock()
+	s.nextID++
+	id := s.nextID
+	req := &pendingRequest{peer: peerID, kind: kind, reply: make(chan []byte, 1)}
+	s.pending[id] = req
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, id)
+		s.mu.Unlock()
+	}()
+
+	if err := s.net.SendTo(peerID, build(id)); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(s.config.RequestTimeout)
+	defer timer.Stop()
+	select {
+	case body := <-req.reply:
+		return body, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("peer %s did not respond", shortID(peerID))
+	case <-s.stopCh:
+		return nil, errStopped
+	}
+}
+
+// handleRequest serves a peer's status, header, body or snapshot request
+// from the canonical chain
+func (s *Syncer) handleRequest(msg *network.Message) error {
+	req, err := decodeRequest(msg.Payload)
+	if err != nil {
+		return err
+	}
+
+	var body interface{}
+	switch req.Kind {
+	case requestStatus:
+		head := s.chain.GetCurrentBlock()
+		body = headStatus{Height: head.Header.Height, Hash: head.Hash()}
+	case requestHeaders, requestBodies:
+		limit := uint32(maxHeadersPerRequest)
+		if req.Kind == requestBodies {
+			limit = maxBodiesPerRequest
+		}
+		if req.Count < limit {
+			limit = req.Count
+		}
+
+		items, size := make([]json.RawMessage, 0, limit), 0
+		for h := req.Start; h < req.Start+uint64(limit); h++ {
+			block, err := s.chain.GetBlock(h)
+			if err != nil {
+				break
+			}
+			var item interface{} = block
+			if req.Kind == requestHeaders {
+				item = block.Header
+			} else if shares, err := s.chain.GetMiningShares(h); err == nil {
+				// Importers verify the shares a block's mining summary covers
+				block.MiningShares = shares
+			}
+			var added bool
+			if items, added = appendWithinLimit(items, &size, item); !added {
+				break
+			}
+		}
+		body = items
+	case requestSnapshot:
+		// A node with nothing to serve answers with a null manifest
+		manifest, err := s.chain.LatestSnapshot()
+		if err != nil && !errors.Is(err, blockchain.ErrSnapshotNotFound) {
+			return err
+		}
+		body = manifest
+	case requestSnapshotChunk:
+		chunk, err := s.chain.GetSnapshotChunk(req.Start, req.Count)
+		if err != nil && !errors.Is(err, blockchain.ErrSnapshotNotFound) {
+			return err
+		}
+		if chunk != nil {
+			body = json.RawMessage(chunk)
+		}
+	default:
+		return fmt.Errorf("%w: unknown request kind %d", ErrInvalidMessage, req.Kind)
+	}
+
+	payload, err := encodeResponse(req.Kind, req.ID, body)
+	if err != nil {
+		return err
+	}
+	return s.net.SendTo(msg.From, &network.Message{Type: network.MsgBlockResponse, Payload: payload})
+}
+
+// handleResponse hands a response to the request waiting for it. Responses
+// nobody asked the peer for are dropped.
+func (s *Syncer) handleResponse(msg *network.Message) error {
+	kind, id, body, err := decodeResponse(msg.Payload)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	req, exists := s.pending[id]
+	s.mu.Unlock()
+	if !exists || req.peer != msg.From || req.kind != kind {
+		return fmt.Errorf("%w: unsolicited response from %s", ErrInvalidMessage, shortID(msg.From))
+	}
+	select {
+	case req.reply <- body:
+	default:
+	}
+	return nil
+}
+
+// handleAnnounce wakes the syncer when a peer announces a block, so blocks
+// produced elsewhere are fetched without waiting for the next poll
+func (s *Syncer) handleAnnounce(msg *network.Message) error {
+	s.wake()
+	return nil
+}
+
+// wake makes the syncer poll its peers now
+func (s *Syncer) wake() {
+	select {
+	case s.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// headerHash returns the hash of the block a header belongs to; block
+// hashes cover the header only
+func headerHash(header blockchain.BlockHeader) [32]byte {
+	block := blockchain.Block{Header: header}
+	return block.Hash()
+}
+
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
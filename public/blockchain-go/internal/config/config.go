@@ -0,0 +1,181 @@
+// Package config loads node settings from a YAML file and environment
+// variables into the command-line flags that define them
+package config
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrUnknownSetting is returned for a config file entry that names no
+// setting
+var ErrUnknownSetting = errors.New("unknown setting")
+
+// Section groups flags in the config file: a YAML mapping from flag names
+// to values, lists as sequences or comma-separated strings
+type Section struct {
+	Name  string
+	Flags []string
+}
+
+// Schema lists the sections of a binary's config file, in the order
+// Generate writes them. Flags in no section can only be given on the
+// command line.
+type Schema []Section
+
+// Load applies the config file at path, if path is not empty, and then the
+// environment to the flags of fs not set on the command line, so the
+// environment wins over the file. fs must have been parsed. Entries the
+// schema does not know are errors.
+func Load(fs *flag.FlagSet, schema Schema, path, envPrefix string) error {
+	sections, err := schema.lookup(fs)
+	if err != nil {
+		return err
+	}
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	values := make(map[string]string)
+	if path != "" {
+		if values, err = readFile(path, sections); err != nil {
+			return err
+		}
+	}
+	for _, section := range schema {
+		for _, name := range section.Flags {
+			if value, ok := os.LookupEnv(EnvName(envPrefix, name)); ok {
+				values[name] = value
+			}
+		}
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if explicit[name] {
+			continue
+		}
+		if err := fs.Set(name, values[name]); err != nil {
+			return fmt.Errorf("%s.%s: %w", sections[name], name, err)
+		}
+	}
+	return nil
+}
+
+// Generate writes a config file with every setting of the schema at its
+// default, each preceded by its flag's usage
+func Generate(w io.Writer, fs *flag.FlagSet, schema Schema, envPrefix string) error {
+	if _, err := schema.lookup(fs); err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "# Settings left out keep their defaults. Command-line flags and\n")
+	fmt.Fprintf(out, "# %s_* environment variables override this file.\n", envPrefix)
+	for _, section := range schema {
+		fmt.Fprintf(out, "\n%s:\n", section.Name)
+		for _, name := range section.Flags {
+			f := fs.Lookup(name)
+			value, err := formatDefault(f)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			fmt.Fprintf(out, "  # %s\n  %s: %s\n", f.Usage, name, value)
+		}
+	}
+	return out.Flush()
+}
+
+// EnvName returns the environment variable that sets a flag
+func EnvName(prefix, flagName string) string {
+	return prefix + "_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Helper functions
+
+// lookup maps each flag of the schema to its section, checking that the
+// flags exist and appear once
+func (s Schema) lookup(fs *flag.FlagSet) (map[string]string, error) {
+	sections := make(map[string]string)
+	for _, section := range s {
+		for _, name := range section.Flags {
+			if fs.Lookup(name) == nil {
+				return nil, fmt.Errorf("config section %s names unknown flag %s", section.Name, name)
+			}
+			if other, exists := sections[name]; exists {
+				return nil, fmt.Errorf("flag %s is in config sections %s and %s", name, other, section.Name)
+			}
+			sections[name] = section.Name
+		}
+	}
+	return sections, nil
+}
+
+// readFile reads a config file into flag values
+func readFile(path string, sections map[string]string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	var file map[string]map[string]interface{}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	for section, entries := range file {
+		for name, raw := range entries {
+			if sections[name] != section {
+				return nil, fmt.Errorf("%w: %s.%s", ErrUnknownSetting, section, name)
+			}
+			if raw == nil {
+				continue
+			}
+			values[name] = formatValue(raw)
+		}
+	}
+	return values, nil
+}
+
+// formatValue turns a decoded YAML value into flag syntax
+func formatValue(raw interface{}) string {
+	switch v := raw.(type) {
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = formatValue(item)
+		}
+		return strings.Join(items, ",")
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// formatDefault encodes a flag's default as a YAML scalar. Only strings
+// need quoting; other defaults print as YAML reads them.
+func formatDefault(f *flag.Flag) (string, error) {
+	if getter, ok := f.Value.(flag.Getter); ok {
+		if _, isString := getter.Get().(string); !isString {
+			return f.DefValue, nil
+		}
+	}
+	encoded, err := yaml.Marshal(f.DefValue)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(encoded)), nil
+}
@@ -0,0 +1,114 @@
+// Package consensus - Development mode: instant sealing and clock control
+package consensus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/tracing"
+)
+
+// ErrDevModeDisabled is returned by the clock and sealing controls of an
+// engine not running in dev mode
+var ErrDevModeDisabled = errors.New("dev mode is disabled")
+
+// devValidatorStake is the dev validator's stake when no minimum is set
+var devValidatorStake = new(big.Int).Mul(big.NewInt(32), big.NewInt(1e18))
+
+// Mine seals a block with the pool's pending transactions now, and
+// returns its height
+func (pos *PoSEngine) Mine() (uint64, error) {
+	if !pos.config.DevMode {
+		return 0, ErrDevModeDisabled
+	}
+	if err := pos.seal(); err != nil {
+		return 0, err
+	}
+	return pos.chain.GetCurrentBlock().Header.Height, nil
+}
+
+// IncreaseTime moves the engine's clock forward, and returns how far ahead
+// of the wall clock it now runs. The offset is not persisted.
+func (pos *PoSEngine) IncreaseTime(d time.Duration) (time.Duration, error) {
+	if !pos.config.DevMode {
+		return 0, ErrDevModeDisabled
+	}
+	if d < 0 {
+		return 0, errors.New("time can only move forward")
+	}
+	offset := pos.clockOffset.Add(int64(d / time.Second))
+	return time.Duration(offset) * time.Second, nil
+}
+
+// Helper functions
+
+// now returns the engine's clock in Unix seconds: the wall clock plus any
+// dev mode offset
+func (pos *PoSEngine) now() uint64 {
+	return uint64(time.Now().Unix() + pos.clockOffset.Load())
+}
+
+// queueSeal wakes the consensus loop to seal a new transaction at once
+// instead of at the next round. It never blocks the goroutine adding it.
+func (pos *PoSEngine) queueSeal(*blockchain.Transaction) {
+	select {
+	case pos.sealCh <- struct{}{}:
+	default:
+	}
+}
+
+// seal proposes a block on the head right away, timestamped a second after
+// the parent if the clock has not moved past it
+func (pos *PoSEngine) seal() error {
+	pos.mu.Lock()
+	defer pos.mu.Unlock()
+
+	parent := pos.chain.GetCurrentBlock()
+	now := pos.now()
+	timestamp := now
+	if timestamp <= parent.Header.Timestamp {
+		timestamp = parent.Header.Timestamp + 1
+	}
+	if timestamp > now+maxFutureBlockTime {
+		return errors.New("chain clock is too far ahead of the wall clock; wait for the next round")
+	}
+	pos.voteForHead()
+	if !pos.isProposer(parent, timestamp) {
+		return errors.New("this node is not the proposer")
+	}
+
+	ctx, span := tracing.StartSpan(context.Background(), "consensus.sealBlock")
+	defer span.End()
+	return pos.proposeBlock(ctx, parent.Header.Height+1, timestamp)
+}
+
+// registerDevValidator makes the node's signer the chain's validator if
+// there is none yet. Callers must hold pos.mu.
+func (pos *PoSEngine) registerDevValidator() error {
+	if len(pos.validators) > 0 {
+		return nil
+	}
+	signer := pos.activeSigner()
+	if signer == nil {
+		return errors.New("dev mode needs a validator key")
+	}
+
+	stake := devValidatorStake
+	if pos.config.MinStake != nil && pos.config.MinStake.Sign() > 0 {
+		stake = pos.config.MinStake
+	}
+	pos.validators[pos.localAddr] = &Validator{
+		Address:    pos.localAddr,
+		PublicKey:  signer.PublicKey(),
+		Stake:      new(big.Int).Set(stake),
+		Commission: 10,
+		Active:     true,
+		Uptime:     100.0,
+	}
+	logger.Info("Dev mode: sealing blocks", "validator", fmt.Sprintf("%x", pos.localAddr))
+	return nil
+}
@@ -0,0 +1,132 @@
+// Package consensus - Epoch-based validator set rotation
+package consensus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"chaincore/internal/blockchain"
+)
+
+// defaultMaxValidators caps the active set when the config leaves it zero
+const defaultMaxValidators = 100
+
+// ErrInvalidValidatorSet is returned for blocks whose validator set
+// commitment does not match the set selected for the epoch
+var ErrInvalidValidatorSet = errors.New("block commits to the wrong validator set")
+
+// VerifyValidatorSetCommitment checks that a header is the first block of a
+// snapshot's epoch and commits to that snapshot, as lite clients do
+func VerifyValidatorSetCommitment(header *blockchain.BlockHeader, snapshot *ValidatorSetSnapshot) error {
+	if header.Height != snapshot.StartHeight {
+		return fmt.Errorf("block %d does not start epoch %d", header.Height, snapshot.Epoch)
+	}
+	if header.ValidatorSetHash != snapshot.Hash {
+		return fmt.Errorf("%w: epoch %d", ErrInvalidValidatorSet, snapshot.Epoch)
+	}
+	return nil
+}
+
+// Helper functions
+
+func (pos *PoSEngine) maxValidators() int {
+	if pos.config.MaxValidators > 0 {
+		return pos.config.MaxValidators
+	}
+	return defaultMaxValidators
+}
+
+// selectValidatorSet makes the top-staked eligible candidates the active
+// set until the next epoch boundary. Callers must hold pos.mu.
+func (pos *PoSEngine) selectValidatorSet() {
+	candidates := make([]*Validator, 0, len(pos.validators))
+	for _, v := range pos.validators {
+		if v.Active && !v.Jailed {
+			candidates = append(candidates, v)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if c := candidates[i].Stake.Cmp(candidates[j].Stake); c != 0 {
+			return c > 0
+		}
+		return bytes.Compare(candidates[i].Address[:], candidates[j].Address[:]) < 0
+	})
+	if len(candidates) > pos.maxValidators() {
+		candidates = candidates[:pos.maxValidators()]
+	}
+
+	selected := make(map[[20]byte]bool, len(candidates))
+	joined, left := 0, 0
+	for _, v := range candidates {
+		selected[v.Address] = true
+		if !pos.activeSet[v.Address] {
+			joined++
+		}
+	}
+	for addr := range pos.activeSet {
+		if !selected[addr] {
+			left++
+		}
+	}
+	if joined > 0 || left > 0 {
+		logger.Info("Selected validator set", "epoch", pos.currentEpoch,
+			"validators", len(selected), "joined", joined, "left", left)
+	}
+	pos.activeSet = selected
+}
+
+// restoreValidatorSet reloads the active set from the epoch's snapshot so a
+// restart mid-epoch keeps the membership the epoch started with. It reports
+// false if the epoch has no snapshot yet. Callers must hold pos.mu.
+func (pos *PoSEngine) restoreValidatorSet(epoch uint64) bool {
+	snapshot, err := pos.GetValidatorSet(epoch)
+	if err != nil {
+		return false
+	}
+	pos.activeSet = make(map[[20]byte]bool, len(snapshot.Validators))
+	for _, v := range snapshot.Validators {
+		pos.activeSet[v.Address] = true
+	}
+	return true
+}
+
+// inActiveSet reports whether a validator was selected for the current
+// epoch and is still in good standing. Callers must hold pos.mu.
+func (pos *PoSEngine) inActiveSet(v *Validator) bool {
+	return v.Active && !v.Jailed && pos.activeSet[v.Address]
+}
+
+// epochStartHash returns the validator set commitment for a block at
+// height: the epoch's snapshot hash in an epoch's first block, zero
+// elsewhere. Callers must hold pos.mu.
+func (pos *PoSEngine) epochStartHash(height uint64) ([32]byte, error) {
+	if height == 0 || height%pos.epochLength() != 0 {
+		return [32]byte{}, nil
+	}
+	snapshot, err := pos.GetValidatorSet(pos.epochOf(height))
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return snapshot.Hash, nil
+}
+
+// validateValidatorSetHash checks a block's validator set commitment. A node
+// that has not selected the set for the block's epoch, such as one syncing
+// old blocks, cannot check the hash and accepts it.
+func (pos *PoSEngine) validateValidatorSetHash(block *blockchain.Block) error {
+	height := block.Header.Height
+	if height == 0 || height%pos.epochLength() != 0 {
+		if block.Header.ValidatorSetHash != ([32]byte{}) {
+			return fmt.Errorf("%w: block %d is not an epoch boundary", ErrInvalidValidatorSet, height)
+		}
+		return nil
+	}
+
+	snapshot, known := pos.votePool.epochSet(pos.epochOf(height))
+	if known && block.Header.ValidatorSetHash != snapshot.Hash {
+		return fmt.Errorf("%w: epoch %d", ErrInvalidValidatorSet, pos.epochOf(height))
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+// Package consensus - Block content rules for validator votes and mining shares
+package consensus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+
+	"chaincore/internal/blockchain"
+)
+
+// Inclusion limits
+const (
+	voteRetentionBlocks = 64
+	shareTimestampSlack = 30 // Seconds a share may predate its block's parent
+)
+
+// Block content errors
+var (
+	ErrVoteCensored      = errors.New("round 0 block lacks a quorum of votes for its parent")
+	ErrInvalidBlockVote  = errors.New("invalid validator vote in block")
+	ErrInvalidBlockShare = errors.New("invalid mining share in block")
+)
+
+// votePool holds votes seen for recent blocks and the validator sets they
+// are checked against. It has its own lock so block validation never waits
+// on the consensus loop.
+type votePool struct {
+	votes     map[[32]byte]map[[20]byte]blockchain.ValidatorVote // Voted block hash -> validator -> vote
+	heights   map[[32]byte]uint64
+	epochSets map[uint64]*ValidatorSetSnapshot
+	mu        sync.RWMutex
+}
+
+func newVotePool() *votePool {
+	return &votePool{
+		votes:     make(map[[32]byte]map[[20]byte]blockchain.ValidatorVote),
+		heights:   make(map[[32]byte]uint64),
+		epochSets: make(map[uint64]*ValidatorSetSnapshot),
+	}
+}
+
+// VotesForInclusion returns the votes seen for parentHash that a block at
+// height may carry, those by members of its epoch's set, in block order
+func (pos *PoSEngine) VotesForInclusion(parentHash [32]byte, height uint64) []blockchain.ValidatorVote {
+	snapshot, err := pos.epochSnapshot(pos.epochOf(height))
+	if err != nil {
+		return nil
+	}
+	votes := pos.votePool.forBlock(parentHash)
+	included := votes[:0]
+	for _, vote := range votes {
+		if _, member := snapshot.validator(vote.ValidatorAddr); member {
+			included = append(included, vote)
+		}
+	}
+	return included
+}
+
+// ValidateBlockContent checks a block's proposer signature, validator set
+// commitment, votes and mining shares against the content rules. It is
+// installed as the chain's content validator.
+func (pos *PoSEngine) ValidateBlockContent(block *blockchain.Block) error {
+	if err := pos.validateProposer(block); err != nil {
+		return err
+	}
+	if err := pos.validateValidatorSetHash(block); err != nil {
+		return err
+	}
+	if err := pos.validateVotes(block); err != nil {
+		return err
+	}
+	return pos.validateShares(block)
+}
+
+// Helper functions
+
+// validateVotes checks a block's votes for its parent against its epoch's
+// set, and that a round 0 block carries votes from two thirds of its stake
+func (pos *PoSEngine) validateVotes(block *blockchain.Block) error {
+	if block.Header.Height == 0 {
+		return nil
+	}
+	parentHeight := block.Header.Height - 1
+	snapshot, err := pos.epochSnapshot(pos.epochOf(block.Header.Height))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBlockVote, err)
+	}
+
+	voted := big.NewInt(0)
+	for i, vote := range block.Validators {
+		if vote.BlockHash != block.Header.PrevHash {
+			return fmt.Errorf("%w: vote %d is not for the parent", ErrInvalidBlockVote, i)
+		}
+		if i > 0 && bytes.Compare(block.Validators[i-1].ValidatorAddr[:], vote.ValidatorAddr[:]) >= 0 {
+			return fmt.Errorf("%w: votes must be sorted by validator with one vote each", ErrInvalidBlockVote)
+		}
+		member, exists := snapshot.validator(vote.ValidatorAddr)
+		if !exists {
+			return fmt.Errorf("%w: %x is not in the validator set of epoch %d", ErrInvalidBlockVote, vote.ValidatorAddr, snapshot.Epoch)
+		}
+		key, err := unmarshalPublicKey(member.PublicKey)
+		if err != nil || !blockchain.VerifyVoteSignature(key, parentHeight, vote.BlockHash, vote.Signature) {
+			return fmt.Errorf("%w: bad signature from %x", ErrInvalidBlockVote, vote.ValidatorAddr)
+		}
+		voted.Add(voted, member.Stake)
+	}
+
+	// The genesis block is not voted on, and a chain without validators yet
+	// has no quorum to wait for
+	if parentHeight == 0 || len(snapshot.Validators) == 0 {
+		return nil
+	}
+	parent, err := pos.chain.GetParent(block)
+	if err != nil {
+		return err
+	}
+	if pos.roundAt(parent.Header.Timestamp, block.Header.Timestamp) == 0 && !hasQuorum(voted, snapshot.TotalStake()) {
+		return fmt.Errorf("%w: votes for %s of %s stake", ErrVoteCensored, voted, snapshot.TotalStake())
+	}
+	return nil
+}
+
+// attestedStake returns the stake of the members of a block's epoch set
+// whose votes it carries. ValidateBlockContent has verified the votes of
+// every block the chain weighs.
+func (pos *PoSEngine) attestedStake(block *blockchain.Block) *big.Int {
+	stake := big.NewInt(0)
+	if block.Header.Height == 0 {
+		return stake
+	}
+	snapshot, err := pos.epochSnapshot(pos.epochOf(block.Header.Height))
+	if err != nil {
+		return stake
+	}
+	for _, vote := range block.Validators {
+		if member, exists := snapshot.validator(vote.ValidatorAddr); exists {
+			stake.Add(stake, member.Stake)
+		}
+	}
+	return stake
+}
+
+// hasVoteQuorum reports whether the votes seen for parent give a block on
+// it the quorum round 0 requires
+func (pos *PoSEngine) hasVoteQuorum(parent *blockchain.Block) bool {
+	if parent.Header.Height == 0 {
+		return true
+	}
+	height := parent.Header.Height + 1
+	snapshot, err := pos.epochSnapshot(pos.epochOf(height))
+	if err != nil {
+		return false
+	}
+	if len(snapshot.Validators) == 0 {
+		return true
+	}
+	voted := big.NewInt(0)
+	for _, vote := range pos.VotesForInclusion(parent.Hash(), height) {
+		member, _ := snapshot.validator(vote.ValidatorAddr)
+		voted.Add(voted, member.Stake)
+	}
+	return hasQuorum(voted, snapshot.TotalStake())
+}
+
+// validateShares checks that a block carries the shares its mining summary
+// covers, unique and timed between its parent, less a slack, and itself.
+// The chain verifies each share's work and reward when it executes the
+// block.
+func (pos *PoSEngine) validateShares(block *blockchain.Block) error {
+	if block.Header.Height == 0 {
+		return nil
+	}
+	if len(block.MiningShares) == 0 {
+		if block.Mining.ShareCount > 0 {
+			return fmt.Errorf("%w: block carries no shares for its mining summary", ErrInvalidBlockShare)
+		}
+		return nil
+	}
+	parent, err := pos.chain.GetParent(block)
+	if err != nil {
+		return err
+	}
+
+	earliest := uint64(0)
+	if parent.Header.Timestamp > shareTimestampSlack {
+		earliest = parent.Header.Timestamp - shareTimestampSlack
+	}
+	seen := make(map[[32]byte]bool, len(block.MiningShares))
+	for i, share := range block.MiningShares {
+		if share.Timestamp < earliest || share.Timestamp > block.Header.Timestamp {
+			return fmt.Errorf("%w: share %d is outside the block's time window", ErrInvalidBlockShare, i)
+		}
+		if seen[share.ShareHash] {
+			return fmt.Errorf("%w: share %d is a duplicate", ErrInvalidBlockShare, i)
+		}
+		seen[share.ShareHash] = true
+	}
+	return nil
+}
+
+// add records a vote the first time it is seen
+func (p *votePool) add(height uint64, vote blockchain.ValidatorVote) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	votes, exists := p.votes[vote.BlockHash]
+	if !exists {
+		votes = make(map[[20]byte]blockchain.ValidatorVote)
+		p.votes[vote.BlockHash] = votes
+		p.heights[vote.BlockHash] = height
+	}
+	if _, exists := votes[vote.ValidatorAddr]; !exists {
+		votes[vote.ValidatorAddr] = vote
+	}
+}
+
+// forBlock returns all votes seen for a block, sorted by validator
+func (p *votePool) forBlock(blockHash [32]byte) []blockchain.ValidatorVote {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	votes := make([]blockchain.ValidatorVote, 0, len(p.votes[blockHash]))
+	for _, vote := range p.votes[blockHash] {
+		votes = append(votes, vote)
+	}
+	sort.Slice(votes, func(i, j int) bool {
+		return bytes.Compare(votes[i].ValidatorAddr[:], votes[j].ValidatorAddr[:]) < 0
+	})
+	return votes
+}
+
+// setEpochSet records the validator set snapshot of an epoch, keeping only
+// the previous epoch's besides. Snapshots are not modified once taken.
+func (p *votePool) setEpochSet(snapshot *ValidatorSetSnapshot) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.epochSets[snapshot.Epoch] = snapshot
+	for e := range p.epochSets {
+		if e+1 < snapshot.Epoch {
+			delete(p.epochSets, e)
+		}
+	}
+}
+
+func (p *votePool) epochSet(epoch uint64) (*ValidatorSetSnapshot, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	snapshot, exists := p.epochSets[epoch]
+	return snapshot, exists
+}
+
+// hasQuorum reports whether voted is at least two thirds of total
+func hasQuorum(voted, total *big.Int) bool {
+	threshold := new(big.Int).Mul(total, big.NewInt(2))
+	threshold.Div(threshold, big.NewInt(3))
+	return voted.Sign() > 0 && voted.Cmp(threshold) >= 0
+}
+
+// prune forgets votes for blocks below minHeight
+func (p *votePool) prune(minHeight uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for hash, height := range p.heights {
+		if height < minHeight {
+			delete(p.votes, hash)
+			delete(p.heights, hash)
+		}
+	}
+}
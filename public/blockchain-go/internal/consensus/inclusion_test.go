@@ -0,0 +1,87 @@
+package consensus
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"sort"
+	"testing"
+
+	"chaincore/internal/blockchain"
+)
+
+func TestRoundZeroBlockNeedsVoteQuorum(t *testing.T) {
+	// Stakes 1, 2 and 3: a quorum is 4
+	keys := []*ecdsa.PrivateKey{newTestKey(t), newTestKey(t), newTestKey(t)}
+	pos := newTestEngine(t, keys...)
+	pos.mu.Lock()
+	pos.selectValidatorSet()
+	err := pos.snapshotValidatorSet(0, 0)
+	pos.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byAddress := make(map[[20]byte]*ecdsa.PrivateKey, len(keys))
+	for _, key := range keys {
+		byAddress[pubKeyToAddress(key.PublicKey)] = key
+	}
+
+	// Block 1 carries no votes: nobody votes on the genesis block
+	genesis := pos.chain.GetCurrentBlock()
+	proposer, _ := pos.selectProposer(1, genesis.Hash(), 0)
+	parent := &blockchain.Block{Header: blockchain.BlockHeader{
+		Height:       1,
+		Timestamp:    genesis.Header.Timestamp + 1,
+		PrevHash:     genesis.Hash(),
+		ProposerAddr: proposer,
+		GasLimit:     defaultBlockGasLimit,
+	}}
+	if err := pos.chain.FillBlock(parent); err != nil {
+		t.Fatal(err)
+	}
+	hash := parent.Hash()
+	if parent.Signature, err = ecdsa.SignASN1(rand.Reader, byAddress[proposer], hash[:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := pos.chain.InsertBlock(parent); err != nil {
+		t.Fatal(err)
+	}
+
+	vote := func(key *ecdsa.PrivateKey) blockchain.ValidatorVote {
+		v := Vote{Height: 1, BlockHash: parent.Hash(), Validator: pubKeyToAddress(key.PublicKey)}
+		digest := v.Digest()
+		signature, err := signVote(NewLocalSigner(key), digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return blockchain.ValidatorVote{ValidatorAddr: v.Validator, BlockHash: v.BlockHash, Signature: signature}
+	}
+	block := func(round uint64, keys ...*ecdsa.PrivateKey) *blockchain.Block {
+		b := &blockchain.Block{Header: blockchain.BlockHeader{
+			Height:    2,
+			Timestamp: parent.Header.Timestamp + 1 + round*pos.proposerTimeout(),
+			PrevHash:  parent.Hash(),
+		}}
+		for _, key := range keys {
+			b.Validators = append(b.Validators, vote(key))
+		}
+		sort.Slice(b.Validators, func(i, j int) bool {
+			return bytes.Compare(b.Validators[i].ValidatorAddr[:], b.Validators[j].ValidatorAddr[:]) < 0
+		})
+		return b
+	}
+
+	if err := pos.validateVotes(block(0, keys[0], keys[1])); !errors.Is(err, ErrVoteCensored) {
+		t.Fatalf("round 0 block with votes for 3 of 6: %v", err)
+	}
+	if err := pos.validateVotes(block(0, keys[0], keys[2])); err != nil {
+		t.Fatalf("round 0 block with votes for 4 of 6: %v", err)
+	}
+	if err := pos.validateVotes(block(1, keys[0])); err != nil {
+		t.Fatalf("fallback round block with votes for 1 of 6: %v", err)
+	}
+	if err := pos.validateVotes(block(1, newTestKey(t))); !errors.Is(err, ErrInvalidBlockVote) {
+		t.Fatalf("vote from outside the epoch's set: %v", err)
+	}
+}
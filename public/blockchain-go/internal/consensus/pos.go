@@ -2,25 +2,42 @@
 package consensus
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"errors"
+	"fmt"
 	"math/big"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/sha3"
+
 	"chaincore/internal/blockchain"
+	"chaincore/internal/logging"
+	"chaincore/internal/tracing"
 )
 
+// logger is the consensus module's logger
+var logger = logging.New("consensus")
+
 // PoSConfig holds PoS consensus configuration
 type PoSConfig struct {
-	ValidatorKeyPath   string
-	MinValidators      int
-	BlockFinality      int    // Blocks needed for finality
-	SlashingEnabled    bool
-	RewardPerBlock     *big.Int
-	MinStake           *big.Int
-	UnbondingPeriod    time.Duration
+	ValidatorKeyPath     string
+	MinValidators        int
+	BlockFinality        int // Blocks needed for finality
+	SlashingEnabled      bool
+	RewardPerBlock       *big.Int
+	MinStake             *big.Int
+	UnbondingPeriod      time.Duration
+	NextValidatorKeyPath string        // Staged key that takes over after a key rotation
+	ProposerTimeout      time.Duration // How long each proposer round lasts before the next fallback takes over (default 12s)
+	MaxValidators        int           // Size of the active set selected each epoch (default 100)
+	BlockTime            time.Duration // Minimum time between blocks, at most ProposerTimeout (default 1s)
+	DevMode              bool          // Seal blocks as transactions arrive and allow moving the clock; single-validator development chains only
 }
 
 // Validator represents a PoS validator
@@ -28,7 +45,8 @@ type Validator struct {
 	Address    [20]byte
 	PublicKey  *ecdsa.PublicKey
 	Stake      *big.Int
-	Commission uint8 // 0-100 percentage
+	Unbonding  *big.Int // Withdrawn stake still slashable until released
+	Commission uint8    // 0-100 percentage
 	Active     bool
 	Jailed     bool
 	Uptime     float64
@@ -37,23 +55,49 @@ type Validator struct {
 
 // PoSEngine implements the PoS consensus
 type PoSEngine struct {
-	config       PoSConfig
-	chain        *blockchain.Blockchain
-	validators   map[[20]byte]*Validator
-	proposerKey  *ecdsa.PrivateKey
-	currentRound uint64
-	finalizedAt  uint64
-	votes        map[uint64]map[[20]byte]bool // height -> validator -> voted
-	mu           sync.RWMutex
+	config         PoSConfig
+	chain          *blockchain.Blockchain
+	validators     map[[20]byte]*Validator
+	proposerKey    *ecdsa.PrivateKey
+	currentRound   uint64
+	finalizedAt    uint64
+	votes          map[uint64]map[[20]byte]Vote // height -> validator -> first vote seen
+	equivocations  []Equivocation
+	signers        []Signer // Current key first, then any staged successors
+	localAddr      [20]byte // This node's validator address, stable across key rotations
+	currentEpoch   uint64
+	activeSet      map[[20]byte]bool                  // Validators selected for the current epoch
+	delegations    map[[20]byte]map[[20]byte]*big.Int // validator -> delegator -> bonded stake
+	rewards        map[[20]byte]*Rewards
+	rewardedHeight uint64 // Last block whose reward has been distributed
+	votePool       *votePool
+	shareSource    func() []blockchain.MiningShare // Mining shares to attach to proposed blocks
+	broadcastBlock func(block *blockchain.Block)   // Announces proposed blocks to peers
+	broadcastVote  func(vote *Vote)                // Gossips votes to peers
+	stakingQueue   [][20]byte                      // Validators changed on chain, applied by the consensus loop
+	stakingMu      sync.Mutex                      // Guards stakingQueue only, so block import never waits on pos.mu
+	clockOffset    atomic.Int64                    // Seconds dev mode moved the clock forward
+	sealCh         chan struct{}                   // Dev mode: a transaction is waiting to be sealed
+	stopCh         chan struct{}
+	mu             sync.RWMutex
 }
 
 // NewPoSEngine creates a new PoS consensus engine
 func NewPoSEngine(chain *blockchain.Blockchain, config PoSConfig) (*PoSEngine, error) {
 	engine := &PoSEngine{
-		config:     config,
-		chain:      chain,
-		validators: make(map[[20]byte]*Validator),
-		votes:      make(map[uint64]map[[20]byte]bool),
+		config:      config,
+		chain:       chain,
+		validators:  make(map[[20]byte]*Validator),
+		activeSet:   make(map[[20]byte]bool),
+		votes:       make(map[uint64]map[[20]byte]Vote),
+		delegations: make(map[[20]byte]map[[20]byte]*big.Int),
+		rewards:     make(map[[20]byte]*Rewards),
+		votePool:    newVotePool(),
+		sealCh:      make(chan struct{}, 1),
+		stopCh:      make(chan struct{}),
+	}
+	if engine.blockTime() > engine.proposerTimeout() {
+		return nil, fmt.Errorf("block time %s exceeds the proposer timeout of %ds", config.BlockTime, engine.proposerTimeout())
 	}
 
 	// Load validator key if provided
@@ -63,6 +107,33 @@ func NewPoSEngine(chain *blockchain.Blockchain, config PoSConfig) (*PoSEngine, e
 			return nil, err
 		}
 		engine.proposerKey = key
+		if key != nil {
+			engine.AddSigner(NewLocalSigner(key))
+		}
+	}
+
+	// Stage the successor key so the switch happens at the epoch boundary
+	if config.NextValidatorKeyPath != "" {
+		key, err := loadValidatorKey(config.NextValidatorKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		if key != nil {
+			engine.AddSigner(NewLocalSigner(key))
+		}
+	}
+
+	// Follow validators registered by staking transactions
+	chain.OnStakingChange(engine.queueStakingChange)
+
+	// Reject blocks that break the vote and share inclusion rules, and
+	// weigh branches by the stake their verified votes attest
+	chain.SetContentValidator(engine.ValidateBlockContent)
+	chain.SetVoteWeigher(engine.attestedStake)
+
+	// In dev mode, seal each transaction as it reaches the pool
+	if config.DevMode {
+		chain.OnPendingTransaction(engine.queueSeal)
 	}
 
 	return engine, nil
@@ -70,6 +141,30 @@ func NewPoSEngine(chain *blockchain.Blockchain, config PoSConfig) (*PoSEngine, e
 
 // Start starts the consensus engine
 func (pos *PoSEngine) Start() error {
+	// Make sure the current epoch has a validator set snapshot
+	pos.mu.Lock()
+	height := pos.chain.GetCurrentBlock().Header.Height + 1
+	pos.currentEpoch = pos.epochOf(height)
+	pos.loadStakedValidators()
+	if err := pos.loadRewards(); err != nil {
+		pos.mu.Unlock()
+		return err
+	}
+	if pos.config.DevMode {
+		if err := pos.registerDevValidator(); err != nil {
+			pos.mu.Unlock()
+			return err
+		}
+	}
+	if !pos.restoreValidatorSet(pos.currentEpoch) {
+		pos.selectValidatorSet()
+	}
+	err := pos.snapshotValidatorSet(pos.currentEpoch, pos.currentEpoch*pos.epochLength())
+	pos.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
 	// Start consensus loop
 	go pos.consensusLoop()
 	return nil
@@ -77,7 +172,11 @@ func (pos *PoSEngine) Start() error {
 
 // Stop stops the consensus engine
 func (pos *PoSEngine) Stop() {
-	// Cleanup
+	select {
+	case <-pos.stopCh:
+	default:
+		close(pos.stopCh)
+	}
 }
 
 // consensusLoop runs the main consensus loop
@@ -85,8 +184,17 @@ func (pos *PoSEngine) consensusLoop() {
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		pos.processRound()
+	for {
+		select {
+		case <-pos.stopCh:
+			return
+		case <-ticker.C:
+			pos.processRound()
+		case <-pos.sealCh:
+			if err := pos.seal(); err != nil {
+				logger.Warn("Dev mode: failed to seal block", "err", err)
+			}
+		}
 	}
 }
 
@@ -98,87 +206,152 @@ func (pos *PoSEngine) processRound() {
 	currentBlock := pos.chain.GetCurrentBlock()
 	height := currentBlock.Header.Height + 1
 
-	// Check if we're the proposer
-	if pos.isProposer(height) {
-		pos.proposeBlock(height)
+	// Pick up stake changes from blocks imported since the last round and
+	// pay out those blocks' rewards
+	pos.syncStakedValidators()
+	pos.distributeRewards(currentBlock.Header.Height)
+	if height > voteRetentionBlocks {
+		pos.votePool.prune(height - voteRetentionBlocks)
+		pos.pruneVotes(height - voteRetentionBlocks)
+	}
+
+	// Activate consensus keys scheduled for this epoch, select its
+	// validator set and record it
+	for epoch := pos.epochOf(height); pos.currentEpoch < epoch; {
+		pos.currentEpoch++
+		pos.applyKeyRotations(pos.currentEpoch)
+		pos.selectValidatorSet()
+		if err := pos.snapshotValidatorSet(pos.currentEpoch, pos.currentEpoch*pos.epochLength()); err != nil {
+			logger.Error("Failed to snapshot validator set", "epoch", pos.currentEpoch, "err", err)
+		}
+	}
+
+	// A new chain has no validators until the first ones stake; let them
+	// start producing blocks without waiting for the next epoch
+	if len(pos.activeSet) == 0 {
+		pos.selectValidatorSet()
+	}
+
+	// Vote for the head, whether we proposed it or imported it, before
+	// proposing on it so the proposal can carry the vote
+	pos.voteForHead()
+
+	// Check if we're the proposer for the current round, once the block
+	// time has passed since the parent
+	now := pos.now()
+	if now >= currentBlock.Header.Timestamp+pos.blockTime() && pos.isProposer(currentBlock, now) {
+		ctx, span := tracing.StartSpan(context.Background(), "consensus.proposeBlock",
+			attribute.Int64("block.height", int64(height)))
+		pos.proposeBlock(ctx, height, now)
+		span.End()
 	}
 
 	// Process votes and finality
 	pos.processFinalityVotes(height)
 }
 
-// isProposer checks if this node is the block proposer
-func (pos *PoSEngine) isProposer(height uint64) bool {
-	if pos.proposerKey == nil {
+// isProposer checks if this node proposes the block on top of parent in
+// the round current at now. In round 0 it does once it holds the quorum of
+// votes for the parent the block must carry.
+func (pos *PoSEngine) isProposer(parent *blockchain.Block, now uint64) bool {
+	v, exists := pos.validators[pos.localAddr]
+	if pos.activeSigner() == nil || !exists || !pos.inActiveSet(v) {
 		return false
 	}
 
-	// Deterministic proposer selection based on stake weight
-	proposer := pos.selectProposer(height)
-	myAddr := pubKeyToAddress(pos.proposerKey.PublicKey)
-	return proposer == myAddr
+	round := pos.roundAt(parent.Header.Timestamp, now)
+	proposer, ok := pos.selectProposer(parent.Header.Height+1, parent.Hash(), round)
+	if !ok || proposer != pos.localAddr {
+		return false
+	}
+	return round > 0 || pos.hasVoteQuorum(parent)
 }
 
-// selectProposer selects a proposer for the given height
-func (pos *PoSEngine) selectProposer(height uint64) [20]byte {
-	// Get active validators sorted by stake
-	active := pos.getActiveValidators()
-	if len(active) == 0 {
-		return [20]byte{}
+// proposeBlock creates and proposes a new block
+func (pos *PoSEngine) proposeBlock(ctx context.Context, height, timestamp uint64) error {
+	// This is where PoS creates blocks - mining has NO influence here
+	// Mining only distributes rewards, never affects block production
+	parent := pos.chain.GetCurrentBlock()
+	if parent.Header.Height+1 != height {
+		return fmt.Errorf("head moved past block %d", height-1)
 	}
 
-	// Weighted random selection based on stake
-	totalStake := big.NewInt(0)
-	for _, v := range active {
-		totalStake.Add(totalStake, v.Stake)
+	// Timestamps must advance; wait for the next round if the parent was
+	// produced this second
+	if timestamp <= parent.Header.Timestamp {
+		return fmt.Errorf("timestamp %d does not follow parent block %d", timestamp, parent.Header.Height)
 	}
 
-	// Use block height as seed for determinism
-	seed := new(big.Int).SetUint64(height)
-	selection := new(big.Int).Mod(seed, totalStake)
-
-	cumulative := big.NewInt(0)
-	for _, v := range active {
-		cumulative.Add(cumulative, v.Stake)
-		if cumulative.Cmp(selection) > 0 {
-			return v.Address
-		}
+	block, err := pos.buildProposal(parent, timestamp)
+	if err != nil {
+		logger.Warn("Failed to build block", "height", height, "err", err)
+		return err
 	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("block.txs", len(block.Transactions)))
 
-	return active[0].Address
-}
-
-// proposeBlock creates and proposes a new block
-func (pos *PoSEngine) proposeBlock(height uint64) {
-	// This is where PoS creates blocks - mining has NO influence here
-	// Mining only distributes rewards, never affects block production
+	// InsertBlock persists the block; it runs the content validator, which
+	// only takes the vote pool's lock, so holding pos.mu here is safe
+	if err := pos.chain.InsertBlock(block); err != nil {
+		logger.Warn("Failed to insert proposed block", "height", height, "err", err)
+		return err
+	}
+	if pos.broadcastBlock != nil {
+		pos.broadcastBlock(block)
+	}
+	return nil
 }
 
 // processFinalityVotes processes votes for block finality
 func (pos *PoSEngine) processFinalityVotes(height uint64) {
-	// Count votes for blocks
-	for h, votes := range pos.votes {
-		if h < height-uint64(pos.config.BlockFinality) {
-			votedStake := pos.calculateVotedStake(votes)
-			totalStake := pos.getTotalActiveStake()
+	// Walk heights in order so every finalized block gets its certificate
+	heights := make([]uint64, 0, len(pos.votes))
+	for h := range pos.votes {
+		if h > pos.finalizedAt && h < height-uint64(pos.config.BlockFinality) {
+			heights = append(heights, h)
+		}
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
 
-			// Finalize if 2/3+ stake voted
-			threshold := new(big.Int).Mul(totalStake, big.NewInt(2))
-			threshold.Div(threshold, big.NewInt(3))
+	// Count votes for blocks
+	for _, h := range heights {
+		block, err := pos.chain.GetBlock(h)
+		if err != nil {
+			continue
+		}
 
-			if votedStake.Cmp(threshold) >= 0 {
-				pos.finalizeBlock(h)
+		// Only votes for the block on our chain count
+		blockHash := block.Hash()
+		voted := make(map[[20]byte]bool)
+		for addr, vote := range pos.votes[h] {
+			if vote.BlockHash == blockHash {
+				voted[addr] = true
 			}
 		}
+		// Finalize if 2/3+ stake voted
+		if hasQuorum(pos.calculateVotedStake(voted), pos.getTotalActiveStake()) {
+			pos.finalizeBlock(h, pos.quorumCertificate(h, blockHash))
+		}
 	}
 }
 
-// finalizeBlock marks a block as finalized (irreversible)
-func (pos *PoSEngine) finalizeBlock(height uint64) {
+// finalizeBlock marks a block as finalized (irreversible) and stores the
+// quorum certificate that finalized it
+func (pos *PoSEngine) finalizeBlock(height uint64, qc *QuorumCertificate) {
+	_, span := tracing.StartSpan(context.Background(), "consensus.finalizeBlock",
+		attribute.Int64("block.height", int64(height)))
+	defer span.End()
+
 	if height > pos.finalizedAt {
+		if err := pos.saveQuorumCertificate(qc); err != nil {
+			logger.Error("Failed to save quorum certificate", "height", height, "err", err)
+			return
+		}
 		pos.finalizedAt = height
 		// Emit finality event
 		// Once finalized, the block CANNOT be reverted
+		if err := pos.chain.SetFinalizedHeight(height); err != nil {
+			logger.Error("Failed to record finality", "height", height, "err", err)
+		}
 	}
 }
 
@@ -187,25 +360,12 @@ func (pos *PoSEngine) VoteForBlock(height uint64, blockHash [32]byte, validator
 	pos.mu.Lock()
 	defer pos.mu.Unlock()
 
-	// Verify validator is active
-	v, exists := pos.validators[validator]
-	if !exists || !v.Active || v.Jailed {
-		return errors.New("invalid or inactive validator")
-	}
-
-	// Verify signature
-	if !verifyVoteSignature(height, blockHash, validator, signature) {
-		return errors.New("invalid vote signature")
-	}
-
-	// Record vote
-	if pos.votes[height] == nil {
-		pos.votes[height] = make(map[[20]byte]bool)
-	}
-	pos.votes[height][validator] = true
-	v.LastVote = height
-
-	return nil
+	return pos.recordVote(Vote{
+		Height:    height,
+		BlockHash: blockHash,
+		Validator: validator,
+		Signature: signature,
+	})
 }
 
 // RegisterValidator registers a new validator
@@ -251,6 +411,13 @@ func (pos *PoSEngine) SlashValidator(addr [20]byte, reason string, percentage ui
 	// Reduce stake
 	v.Stake.Sub(v.Stake, slashAmount)
 
+	// Stake that is unbonding was bonded when the misbehaviour happened
+	if v.Unbonding != nil {
+		unbondingSlash := new(big.Int).Mul(v.Unbonding, big.NewInt(int64(percentage)))
+		unbondingSlash.Div(unbondingSlash, big.NewInt(100))
+		v.Unbonding.Sub(v.Unbonding, unbondingSlash)
+	}
+
 	// Jail validator if severe
 	if percentage >= 30 {
 		v.Jailed = true
@@ -278,7 +445,7 @@ func (pos *PoSEngine) GetFinalizedHeight() uint64 {
 func (pos *PoSEngine) getActiveValidators() []*Validator {
 	active := make([]*Validator, 0)
 	for _, v := range pos.validators {
-		if v.Active && !v.Jailed {
+		if pos.inActiveSet(v) {
 			active = append(active, v)
 		}
 	}
@@ -301,7 +468,7 @@ func (pos *PoSEngine) calculateVotedStake(votes map[[20]byte]bool) *big.Int {
 func (pos *PoSEngine) getTotalActiveStake() *big.Int {
 	total := big.NewInt(0)
 	for _, v := range pos.validators {
-		if v.Active && !v.Jailed {
+		if pos.inActiveSet(v) {
 			total.Add(total, v.Stake)
 		}
 	}
@@ -314,11 +481,18 @@ func loadValidatorKey(path string) (*ecdsa.PrivateKey, error) {
 }
 
 func pubKeyToAddress(pub ecdsa.PublicKey) [20]byte {
-	// Convert public key to address
-	return [20]byte{}
-}
+	// Keccak-256 of the uncompressed X||Y coordinates, last 20 bytes
+	var addr [20]byte
+	if pub.X == nil || pub.Y == nil {
+		return addr
+	}
+
+	coords := make([]byte, 64)
+	pub.X.FillBytes(coords[:32])
+	pub.Y.FillBytes(coords[32:])
 
-func verifyVoteSignature(height uint64, blockHash [32]byte, validator [20]byte, signature [65]byte) bool {
-	// Verify ECDSA signature
-	return true
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write(coords)
+	copy(addr[:], hasher.Sum(nil)[12:])
+	return addr
 }
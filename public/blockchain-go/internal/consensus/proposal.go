@@ -0,0 +1,254 @@
+// Package consensus - Block proposal and proposer signatures
+package consensus
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"chaincore/internal/blockchain"
+)
+
+// Proposal defaults, used when the config leaves them zero
+const (
+	defaultBlockGasLimit   = 30000000
+	defaultProposerTimeout = 12 * time.Second
+	defaultBlockTime       = time.Second
+	maxFutureBlockTime     = 15 // Seconds a block timestamp may run ahead of the local clock
+)
+
+// proposerSeedDomain separates proposer selection seeds from other hashes
+var proposerSeedDomain = []byte("PROP")
+
+// ErrInvalidProposer is returned for blocks not signed by the validator
+// entitled to propose them
+var ErrInvalidProposer = errors.New("block not signed by an active validator")
+
+// SetShareSource installs the source of mining shares the proposer attaches
+// to its blocks, normally the mining distributor's TakeBlockShares
+func (pos *PoSEngine) SetShareSource(fn func() []blockchain.MiningShare) {
+	pos.mu.Lock()
+	defer pos.mu.Unlock()
+
+	pos.shareSource = fn
+}
+
+// SetBlockBroadcaster installs the function that announces blocks this node
+// proposes to its peers
+func (pos *PoSEngine) SetBlockBroadcaster(fn func(block *blockchain.Block)) {
+	pos.mu.Lock()
+	defer pos.mu.Unlock()
+
+	pos.broadcastBlock = fn
+}
+
+// Helper functions
+
+// buildProposal assembles and signs a block on top of parent. Callers must
+// hold pos.mu.
+func (pos *PoSEngine) buildProposal(parent *blockchain.Block, timestamp uint64) (*blockchain.Block, error) {
+	signer := pos.activeSigner()
+	if signer == nil {
+		return nil, errors.New("no signer for the active consensus key")
+	}
+
+	gasLimit := parent.Header.GasLimit
+	if limit, governed := pos.chain.GetGovernanceParam(blockchain.ParamBlockGasLimit); governed {
+		gasLimit = limit.Uint64()
+	}
+	if gasLimit == 0 {
+		gasLimit = defaultBlockGasLimit
+	}
+	parentHash := parent.Hash()
+	setHash, err := pos.epochStartHash(parent.Header.Height + 1)
+	if err != nil {
+		return nil, err
+	}
+	block := &blockchain.Block{
+		Header: blockchain.BlockHeader{
+			Version:          parent.Header.Version,
+			Height:           parent.Header.Height + 1,
+			Timestamp:        timestamp,
+			PrevHash:         parentHash,
+			ProposerAddr:     pos.localAddr,
+			GasLimit:         gasLimit,
+			ValidatorSetHash: setHash,
+		},
+		Validators: pos.VotesForInclusion(parentHash, parent.Header.Height+1),
+
+		// Punish double-signing seen since the last block
+		Transactions: pos.evidenceTransactions(),
+	}
+
+	// Pay the mining rewards of epochs that are over
+	if settlement := pos.chain.PendingSettlement(); settlement != nil {
+		block.Transactions = append(block.Transactions, blockchain.NewSettlementTx(settlement))
+	}
+
+	var shares []blockchain.MiningShare
+	if pos.shareSource != nil {
+		shares = pos.chain.EligibleMiningShares(eligibleShares(pos.shareSource(), parent.Header.Timestamp, timestamp))
+	}
+	block.SetMiningShares(shares)
+
+	if err := pos.chain.FillBlock(block); err != nil {
+		return nil, err
+	}
+
+	hash := block.Hash()
+	signature, err := signer.Sign(hash[:])
+	if err != nil {
+		return nil, err
+	}
+	block.Signature = signature
+	return block, nil
+}
+
+// validateProposer checks that a block is signed by the validator its
+// epoch's set snapshot makes proposer for the block's round. An epoch whose
+// set is empty, on a new chain before its first validators stake, accepts
+// unsigned blocks; a block of an epoch whose set this node has not recorded
+// yet is rejected until it has.
+func (pos *PoSEngine) validateProposer(block *blockchain.Block) error {
+	if block.Header.Height == 0 {
+		return nil
+	}
+	snapshot, err := pos.epochSnapshot(pos.epochOf(block.Header.Height))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidProposer, err)
+	}
+	if len(snapshot.Validators) == 0 {
+		return nil
+	}
+	if limit := pos.now() + maxFutureBlockTime; block.Header.Timestamp > limit {
+		return fmt.Errorf("%w: timestamp %d is in the future", ErrInvalidProposer, block.Header.Timestamp)
+	}
+	proposer, exists := snapshot.validator(block.Header.ProposerAddr)
+	if !exists {
+		return fmt.Errorf("%w: %x is not in the validator set of epoch %d", ErrInvalidProposer, block.Header.ProposerAddr, snapshot.Epoch)
+	}
+	key, err := unmarshalPublicKey(proposer.PublicKey)
+	if err != nil {
+		return fmt.Errorf("%w: %x: %v", ErrInvalidProposer, block.Header.ProposerAddr, err)
+	}
+	hash := block.Hash()
+	if !ecdsa.VerifyASN1(key, hash[:], block.Signature) {
+		return fmt.Errorf("%w: bad signature from %x", ErrInvalidProposer, block.Header.ProposerAddr)
+	}
+
+	parent, err := pos.chain.GetParent(block)
+	if err != nil {
+		return err
+	}
+	round := pos.roundAt(parent.Header.Timestamp, block.Header.Timestamp)
+	expected, ok := weightedProposer(snapshot.Validators, block.Header.PrevHash, block.Header.Height, round)
+	if !ok || expected != block.Header.ProposerAddr {
+		return fmt.Errorf("%w: round %d belongs to %x, not %x", ErrInvalidProposer, round, expected, block.Header.ProposerAddr)
+	}
+	return nil
+}
+
+// selectProposer returns the proposer for a round at height. A new chain's
+// first validators are not in any snapshot yet; until one is taken they
+// take turns by current stake. Callers must hold pos.mu.
+func (pos *PoSEngine) selectProposer(height uint64, parentHash [32]byte, round uint64) ([20]byte, bool) {
+	if snapshot, known := pos.votePool.epochSet(pos.epochOf(height)); known && len(snapshot.Validators) > 0 {
+		return weightedProposer(snapshot.Validators, parentHash, height, round)
+	}
+
+	active := pos.getActiveValidators()
+	candidates := make([]SnapshotValidator, len(active))
+	for i, v := range active {
+		candidates[i] = SnapshotValidator{Address: v.Address, Stake: v.Stake}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return bytes.Compare(candidates[i].Address[:], candidates[j].Address[:]) < 0
+	})
+	return weightedProposer(candidates, parentHash, height, round)
+}
+
+func (pos *PoSEngine) proposerTimeout() uint64 {
+	if seconds := uint64(pos.config.ProposerTimeout / time.Second); seconds > 0 {
+		return seconds
+	}
+	return uint64(defaultProposerTimeout / time.Second)
+}
+
+// blockTime returns the minimum seconds between a block and its parent.
+// A block time set by governance replaces the configured one, up to the
+// proposer timeout.
+func (pos *PoSEngine) blockTime() uint64 {
+	if seconds, governed := pos.chain.GetGovernanceParam(blockchain.ParamBlockTime); governed {
+		return min(seconds.Uint64(), pos.proposerTimeout())
+	}
+	if seconds := uint64(pos.config.BlockTime / time.Second); seconds > 0 {
+		return seconds
+	}
+	return uint64(defaultBlockTime / time.Second)
+}
+
+// roundAt returns the proposer round a block timestamped at timestamp
+// belongs to: each round lasts the proposer timeout after the parent
+func (pos *PoSEngine) roundAt(parentTime, timestamp uint64) uint64 {
+	if timestamp <= parentTime {
+		return 0
+	}
+	return (timestamp - parentTime - 1) / pos.proposerTimeout()
+}
+
+// weightedProposer draws a proposer from validators sorted by address, each
+// weighted by stake, seeded by the parent, height and round. It reports
+// false if no validator has stake.
+func weightedProposer(validators []SnapshotValidator, parentHash [32]byte, height, round uint64) ([20]byte, bool) {
+	total := big.NewInt(0)
+	for _, v := range validators {
+		total.Add(total, v.Stake)
+	}
+	if total.Sign() == 0 {
+		return [20]byte{}, false
+	}
+
+	data := make([]byte, 0, len(proposerSeedDomain)+32+8+8)
+	data = append(data, proposerSeedDomain...)
+	data = append(data, parentHash[:]...)
+	data = binary.BigEndian.AppendUint64(data, height)
+	data = binary.BigEndian.AppendUint64(data, round)
+	seed := sha256.Sum256(data)
+	selection := new(big.Int).Mod(new(big.Int).SetBytes(seed[:]), total)
+
+	cumulative := big.NewInt(0)
+	for _, v := range validators {
+		cumulative.Add(cumulative, v.Stake)
+		if cumulative.Cmp(selection) > 0 {
+			return v.Address, true
+		}
+	}
+	return [20]byte{}, false
+}
+
+// eligibleShares drops shares the content rules would reject: duplicates and
+// shares outside the window between the parent (less the slack) and the
+// block timestamp
+func eligibleShares(shares []blockchain.MiningShare, parentTime, timestamp uint64) []blockchain.MiningShare {
+	earliest := uint64(0)
+	if parentTime > shareTimestampSlack {
+		earliest = parentTime - shareTimestampSlack
+	}
+
+	eligible := make([]blockchain.MiningShare, 0, len(shares))
+	seen := make(map[[32]byte]bool, len(shares))
+	for _, share := range shares {
+		if share.Timestamp < earliest || share.Timestamp > timestamp || seen[share.ShareHash] {
+			continue
+		}
+		seen[share.ShareHash] = true
+		eligible = append(eligible, share)
+	}
+	return eligible
+}
@@ -0,0 +1,101 @@
+package consensus
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"testing"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/storage"
+)
+
+// newTestEngine returns an engine on a new chain with a validator
+// registered for each key, without starting it
+func newTestEngine(t *testing.T, keys ...*ecdsa.PrivateKey) *PoSEngine {
+	t.Helper()
+	db, err := storage.NewMemoryLevelDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	chain, err := blockchain.NewBlockchain(db, blockchain.Config{ChainID: 1, MinGasPrice: 1, ValidatorMinStake: big.NewInt(1), ValidatorEpochBlocks: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos, err := NewPoSEngine(chain, PoSConfig{MinStake: big.NewInt(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range keys {
+		if err := pos.RegisterValidator(pubKeyToAddress(key.PublicKey), big.NewInt(int64(i+1)), &key.PublicKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return pos
+}
+
+func newTestKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestValidateProposerUsesEpochSet(t *testing.T) {
+	keys := []*ecdsa.PrivateKey{newTestKey(t), newTestKey(t)}
+	pos := newTestEngine(t, keys...)
+	parent := pos.chain.GetCurrentBlock()
+	proposal := func(key *ecdsa.PrivateKey) *blockchain.Block {
+		block := &blockchain.Block{Header: blockchain.BlockHeader{
+			Height:       parent.Header.Height + 1,
+			Timestamp:    parent.Header.Timestamp + 1,
+			PrevHash:     parent.Hash(),
+			ProposerAddr: pubKeyToAddress(key.PublicKey),
+		}}
+		hash := block.Hash()
+		signature, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		block.Signature = signature
+		return block
+	}
+
+	// Registered validators do not count until the epoch's set is recorded
+	if err := pos.validateProposer(proposal(keys[0])); !errors.Is(err, ErrInvalidProposer) {
+		t.Fatalf("block of an unknown epoch: %v", err)
+	}
+
+	pos.mu.Lock()
+	pos.selectValidatorSet()
+	err := pos.snapshotValidatorSet(0, 0)
+	pos.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := pos.selectProposer(parent.Header.Height+1, parent.Hash(), 0)
+	for _, key := range keys {
+		err := pos.validateProposer(proposal(key))
+		if pubKeyToAddress(key.PublicKey) == expected {
+			if err != nil {
+				t.Fatalf("round's proposer: %v", err)
+			}
+		} else if !errors.Is(err, ErrInvalidProposer) {
+			t.Fatalf("another validator's round: %v", err)
+		}
+	}
+
+	// Validators registered since the snapshot wait for the next epoch
+	late := newTestKey(t)
+	if err := pos.RegisterValidator(pubKeyToAddress(late.PublicKey), big.NewInt(100), &late.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := pos.validateProposer(proposal(late)); !errors.Is(err, ErrInvalidProposer) {
+		t.Fatalf("validator outside the epoch's set: %v", err)
+	}
+}
@@ -0,0 +1,219 @@
+// Package consensus - Block reward distribution to validators and delegators
+package consensus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"sort"
+
+	"chaincore/internal/blockchain"
+)
+
+// Reward storage keys
+var (
+	rewardsKeyPrefix = []byte("pos:rewards:")
+	rewardsHeightKey = []byte("pos:rewards-height")
+)
+
+// Delegation is stake bonded to a validator. A validator's own stake is a
+// delegation from itself.
+type Delegation struct {
+	Validator [20]byte
+	Delegator [20]byte
+	Amount    *big.Int
+}
+
+// Rewards are the block rewards accrued to an address
+type Rewards struct {
+	Commission *big.Int // Earned as the proposing validator's commission
+	Staking    *big.Int // Earned on stake bonded to proposing validators
+}
+
+// Total returns the commission and staking rewards combined
+func (r *Rewards) Total() *big.Int {
+	return new(big.Int).Add(r.Commission, r.Staking)
+}
+
+// GetDelegations returns the delegations made by an address, excluding a
+// validator's own stake
+func (pos *PoSEngine) GetDelegations(delegator [20]byte) []Delegation {
+	pos.mu.RLock()
+	defer pos.mu.RUnlock()
+
+	delegations := make([]Delegation, 0)
+	for validator, bonds := range pos.delegations {
+		if amount, exists := bonds[delegator]; exists && validator != delegator {
+			delegations = append(delegations, Delegation{
+				Validator: validator,
+				Delegator: delegator,
+				Amount:    new(big.Int).Set(amount),
+			})
+		}
+	}
+	sort.Slice(delegations, func(i, j int) bool {
+		return bytes.Compare(delegations[i].Validator[:], delegations[j].Validator[:]) < 0
+	})
+	return delegations
+}
+
+// GetRewards returns the rewards accrued to an address
+func (pos *PoSEngine) GetRewards(addr [20]byte) *Rewards {
+	pos.mu.RLock()
+	defer pos.mu.RUnlock()
+
+	rewards := &Rewards{Commission: big.NewInt(0), Staking: big.NewInt(0)}
+	if r, exists := pos.rewards[addr]; exists {
+		rewards.Commission.Set(r.Commission)
+		rewards.Staking.Set(r.Staking)
+	}
+	return rewards
+}
+
+// Helper functions
+
+// distributeRewards splits the reward of every block imported since the
+// last call. Callers must hold pos.mu.
+func (pos *PoSEngine) distributeRewards(head uint64) {
+	if pos.rewardedHeight >= head {
+		return
+	}
+
+	credited := make(map[[20]byte]bool)
+	height := pos.rewardedHeight
+	for height < head {
+		block, err := pos.chain.GetBlock(height + 1)
+		if err != nil {
+			logger.Error("Failed to load block for rewards", "height", height+1, "err", err)
+			break
+		}
+		for _, addr := range pos.rewardBlock(block) {
+			credited[addr] = true
+		}
+		height++
+	}
+	pos.rewardedHeight = height
+
+	if err := pos.saveRewards(credited); err != nil {
+		logger.Error("Failed to save rewards", "err", err)
+	}
+}
+
+// rewardBlock splits RewardPerBlock for a block: the proposer takes its
+// commission, and the rest is shared by everything bonded to the proposer
+// in proportion to stake. Rounding dust goes to the proposer's own stake.
+// It returns the credited addresses.
+func (pos *PoSEngine) rewardBlock(block *blockchain.Block) [][20]byte {
+	reward := pos.config.RewardPerBlock
+	proposer := block.Header.ProposerAddr
+	v, exists := pos.validators[proposer]
+	if reward == nil || reward.Sign() == 0 || !exists {
+		return nil
+	}
+
+	bonded := big.NewInt(0)
+	for _, amount := range pos.delegations[proposer] {
+		bonded.Add(bonded, amount)
+	}
+	if bonded.Sign() == 0 {
+		return nil
+	}
+
+	commission := new(big.Int).Mul(reward, big.NewInt(int64(v.Commission)))
+	commission.Div(commission, big.NewInt(100))
+	pos.creditReward(proposer, commission, big.NewInt(0))
+	credited := [][20]byte{proposer}
+
+	pool := new(big.Int).Sub(reward, commission)
+	remaining := new(big.Int).Set(pool)
+	for delegator, amount := range pos.delegations[proposer] {
+		if delegator == proposer {
+			continue
+		}
+		share := new(big.Int).Mul(pool, amount)
+		share.Div(share, bonded)
+		pos.creditReward(delegator, big.NewInt(0), share)
+		remaining.Sub(remaining, share)
+		credited = append(credited, delegator)
+	}
+	pos.creditReward(proposer, big.NewInt(0), remaining)
+
+	return credited
+}
+
+func (pos *PoSEngine) creditReward(addr [20]byte, commission, staking *big.Int) {
+	r, exists := pos.rewards[addr]
+	if !exists {
+		r = &Rewards{Commission: big.NewInt(0), Staking: big.NewInt(0)}
+		pos.rewards[addr] = r
+	}
+	r.Commission.Add(r.Commission, commission)
+	r.Staking.Add(r.Staking, staking)
+}
+
+// syncDelegations mirrors a validator's bonded stake from the chain.
+// Callers must hold pos.mu.
+func (pos *PoSEngine) syncDelegations(staked *blockchain.StakedValidator) {
+	bonds := make(map[[20]byte]*big.Int)
+	if staked.SelfStake.Sign() > 0 {
+		bonds[staked.Address] = new(big.Int).Set(staked.SelfStake)
+	}
+	for _, d := range pos.chain.GetDelegations(staked.Address) {
+		bonds[d.Delegator] = d.Amount
+	}
+	pos.delegations[staked.Address] = bonds
+}
+
+// loadRewards restores accrued rewards. A node without saved rewards starts
+// distributing from the current head, since past stake is not known.
+// Callers must hold pos.mu.
+func (pos *PoSEngine) loadRewards() error {
+	db := pos.chain.Database()
+	data, err := db.Get(rewardsHeightKey)
+	if err != nil || len(data) != 8 {
+		pos.rewardedHeight = pos.chain.GetCurrentBlock().Header.Height
+		return nil
+	}
+	pos.rewardedHeight = binary.BigEndian.Uint64(data)
+
+	it := db.NewIterator(rewardsKeyPrefix)
+	defer it.Release()
+	for it.Next() {
+		key := it.Key()
+		if len(key) != len(rewardsKeyPrefix)+20 {
+			continue
+		}
+		var r Rewards
+		if err := json.Unmarshal(it.Value(), &r); err != nil {
+			return err
+		}
+		var addr [20]byte
+		copy(addr[:], key[len(rewardsKeyPrefix):])
+		pos.rewards[addr] = &r
+	}
+	return it.Error()
+}
+
+// saveRewards persists the given addresses' rewards with the distribution
+// height. Callers must hold pos.mu.
+func (pos *PoSEngine) saveRewards(addrs map[[20]byte]bool) error {
+	batch := pos.chain.Database().NewBatch()
+	for addr := range addrs {
+		data, err := json.Marshal(pos.rewards[addr])
+		if err != nil {
+			return err
+		}
+		if err := batch.Put(rewardsKey(addr), data); err != nil {
+			return err
+		}
+	}
+	if err := batch.Put(rewardsHeightKey, binary.BigEndian.AppendUint64(nil, pos.rewardedHeight)); err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
+func rewardsKey(addr [20]byte) []byte {
+	return append(append([]byte(nil), rewardsKeyPrefix...), addr[:]...)
+}
@@ -0,0 +1,165 @@
+// Package consensus - Validator consensus key rotation
+package consensus
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"chaincore/internal/blockchain"
+)
+
+// KeyRotationAddress is the system address key-rotation transactions are sent to
+var KeyRotationAddress = blockchain.KeyRotationAddress
+
+// KeyRotation registers a new consensus key for a validator. The chain
+// checks and records rotations as it executes them; the engine switches
+// keys at the start of their epoch.
+type KeyRotation = blockchain.KeyRotation
+
+// Signer produces consensus signatures. It may be backed by a local key or
+// by a remote signing service holding the key.
+type Signer interface {
+	PublicKey() *ecdsa.PublicKey
+	Sign(digest []byte) ([]byte, error)
+}
+
+// LocalSigner signs with an in-memory private key
+type LocalSigner struct {
+	key *ecdsa.PrivateKey
+}
+
+// NewLocalSigner creates a signer from a private key
+func NewLocalSigner(key *ecdsa.PrivateKey) *LocalSigner {
+	return &LocalSigner{key: key}
+}
+
+// PublicKey returns the signer's public key
+func (s *LocalSigner) PublicKey() *ecdsa.PublicKey {
+	return &s.key.PublicKey
+}
+
+// Sign signs a digest
+func (s *LocalSigner) Sign(digest []byte) ([]byte, error) {
+	return ecdsa.SignASN1(rand.Reader, s.key, digest)
+}
+
+// NewKeyRotation creates a rotation request signed by the current key
+func NewKeyRotation(current Signer, validator [20]byte, newKey *ecdsa.PublicKey, epoch uint64) (*KeyRotation, error) {
+	kr := &KeyRotation{
+		Validator:      validator,
+		NewKey:         marshalPublicKey(newKey),
+		EffectiveEpoch: epoch,
+	}
+	digest := kr.Digest()
+	sig, err := current.Sign(digest[:])
+	if err != nil {
+		return nil, err
+	}
+	kr.Signature = sig
+	return kr, nil
+}
+
+// GetPendingKeyRotation returns the consensus key a validator rotated to on
+// chain and the epoch it takes effect, or nil if no rotation is pending
+func (pos *PoSEngine) GetPendingKeyRotation(addr [20]byte) (*ecdsa.PublicKey, uint64) {
+	staked, err := pos.chain.GetStakedValidator(addr)
+	if err != nil || staked.PendingKey == nil {
+		return nil, 0
+	}
+	key, err := unmarshalPublicKey(staked.PendingKey)
+	if err != nil {
+		return nil, 0
+	}
+	return key, staked.PendingKeyEpoch
+}
+
+// AddSigner makes a signer available to the engine. Staging the signer for a
+// pending key ahead of time lets the node switch at the epoch boundary
+// without downtime.
+func (pos *PoSEngine) AddSigner(s Signer) {
+	pos.mu.Lock()
+	defer pos.mu.Unlock()
+
+	pos.signers = append(pos.signers, s)
+	if pos.localAddr == ([20]byte{}) {
+		pos.localAddr = pubKeyToAddress(*s.PublicKey())
+	}
+}
+
+// LocalAddress returns this node's validator address, derived from the first
+// signer added, or the zero address if it has none
+func (pos *PoSEngine) LocalAddress() [20]byte {
+	pos.mu.RLock()
+	defer pos.mu.RUnlock()
+	return pos.localAddr
+}
+
+// Sign signs a consensus digest with the signer matching this validator's
+// currently registered key
+func (pos *PoSEngine) Sign(digest []byte) ([]byte, error) {
+	pos.mu.RLock()
+	signer := pos.activeSigner()
+	pos.mu.RUnlock()
+
+	if signer == nil {
+		return nil, errors.New("no signer for the active consensus key")
+	}
+	return signer.Sign(digest)
+}
+
+// Helper functions
+func (pos *PoSEngine) epochLength() uint64 {
+	return pos.chain.ValidatorEpochBlocks()
+}
+
+func (pos *PoSEngine) epochOf(height uint64) uint64 {
+	return height / pos.epochLength()
+}
+
+// applyKeyRotations loads each validator's consensus key for the given
+// epoch from chain state, activating rotations that are due. Callers must
+// hold pos.mu.
+func (pos *PoSEngine) applyKeyRotations(epoch uint64) {
+	for addr, v := range pos.validators {
+		staked, err := pos.chain.GetStakedValidator(addr)
+		if err != nil {
+			continue
+		}
+		key, err := unmarshalPublicKey(staked.ConsensusKey(epoch))
+		if err != nil {
+			logger.Warn("Staked validator has an invalid consensus key", "validator", fmt.Sprintf("%x", addr), "err", err)
+			continue
+		}
+		v.PublicKey = key
+	}
+}
+
+// activeSigner returns the local signer for the key currently registered
+// for this validator. Callers must hold pos.mu.
+func (pos *PoSEngine) activeSigner() Signer {
+	v, exists := pos.validators[pos.localAddr]
+	for _, s := range pos.signers {
+		if !exists || v.PublicKey == nil || s.PublicKey().Equal(v.PublicKey) {
+			return s
+		}
+	}
+	return nil
+}
+
+func marshalPublicKey(pub *ecdsa.PublicKey) []byte {
+	if pub == nil {
+		return nil
+	}
+	return elliptic.Marshal(pub.Curve, pub.X, pub.Y)
+}
+
+func unmarshalPublicKey(data []byte) (*ecdsa.PublicKey, error) {
+	x, y := elliptic.Unmarshal(elliptic.P256(), data)
+	if x == nil {
+		return nil, errors.New("invalid consensus public key")
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+}
@@ -0,0 +1,158 @@
+package consensus
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"testing"
+
+	"golang.org/x/crypto/sha3"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/secp256k1"
+	"chaincore/internal/storage"
+)
+
+// includeTx signs tx with the account key priv and includes it in a new
+// block
+func includeTx(t *testing.T, chain *blockchain.Blockchain, priv []byte, tx *blockchain.Transaction) {
+	t.Helper()
+	pub, _ := secp256k1.PublicKey(priv)
+	tx.From = secp256k1.PubkeyToAddress(pub)
+	tx.ChainID, tx.Nonce, tx.GasPrice, tx.GasLimit = 1, chain.GetNonce(tx.From), 1, 100000
+	hash, err := tx.SigningHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := secp256k1.Sign(hash, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(tx.Signature[:], sig)
+	raw, err := tx.EncodeRaw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write(raw)
+	copy(tx.Hash[:], hasher.Sum(nil))
+	if err := chain.AddTransaction(context.Background(), tx); err != nil {
+		t.Fatal(err)
+	}
+
+	head := chain.GetCurrentBlock()
+	block := &blockchain.Block{Header: blockchain.BlockHeader{
+		Height:    head.Header.Height + 1,
+		PrevHash:  head.Hash(),
+		Timestamp: head.Header.Timestamp + 1,
+		GasLimit:  head.Header.GasLimit,
+	}}
+	if err := chain.FillBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.InsertBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	if receipt, err := chain.GetReceipt(tx.Hash); err != nil || receipt.Status != 1 {
+		t.Fatalf("transaction failed: %+v, %v", receipt, err)
+	}
+}
+
+func TestProposerSignsWithRotatedKeyFromItsEpoch(t *testing.T) {
+	account, err := secp256k1.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _ := secp256k1.PublicKey(account)
+	addr := secp256k1.PubkeyToAddress(pub)
+	db, err := storage.NewMemoryLevelDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	chain, err := blockchain.NewBlockchain(db, blockchain.Config{
+		ChainID:              1,
+		MinGasPrice:          1,
+		ValidatorMinStake:    big.NewInt(1),
+		ValidatorEpochBlocks: 3,
+		GenesisAlloc:         map[[20]byte]*big.Int{addr: big.NewInt(1e18)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Stake with the first key, then rotate to the second at epoch 1
+	first, second := newTestKey(t), newTestKey(t)
+	includeTx(t, chain, account, &blockchain.Transaction{
+		To: blockchain.StakingAddress, Value: big.NewInt(1e17), Data: blockchain.EncodeStake(marshalPublicKey(&first.PublicKey)),
+	})
+	rotation, err := NewKeyRotation(NewLocalSigner(first), addr, &second.PublicKey, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	includeTx(t, chain, account, &blockchain.Transaction{
+		To: KeyRotationAddress, Value: big.NewInt(0), Data: blockchain.EncodeKeyRotation(rotation),
+	})
+
+	pos, err := NewPoSEngine(chain, PoSConfig{MinStake: big.NewInt(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, epoch := pos.GetPendingKeyRotation(addr); key == nil || !key.Equal(&second.PublicKey) || epoch != 1 {
+		t.Fatalf("pending rotation %v at epoch %d", key, epoch)
+	}
+	proposal := func(height uint64, key *ecdsa.PrivateKey) *blockchain.Block {
+		parent, err := chain.GetBlock(height - 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block := &blockchain.Block{Header: blockchain.BlockHeader{
+			Height:       height,
+			Timestamp:    parent.Header.Timestamp + 1,
+			PrevHash:     parent.Hash(),
+			ProposerAddr: addr,
+		}}
+		hash := block.Hash()
+		signature, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		block.Signature = signature
+		return block
+	}
+
+	// Epoch 0, up to block 2, is signed with the registered key
+	pos.mu.Lock()
+	pos.loadStakedValidators()
+	pos.selectValidatorSet()
+	err = pos.snapshotValidatorSet(0, 0)
+	pos.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pos.validateProposer(proposal(2, first)); err != nil {
+		t.Fatalf("epoch 0 block signed with the registered key: %v", err)
+	}
+	if err := pos.validateProposer(proposal(2, second)); !errors.Is(err, ErrInvalidProposer) {
+		t.Fatalf("epoch 0 block signed with the pending key: %v", err)
+	}
+
+	// From epoch 1 only the rotated key is accepted
+	pos.mu.Lock()
+	pos.currentEpoch = 1
+	pos.applyKeyRotations(1)
+	pos.selectValidatorSet()
+	err = pos.snapshotValidatorSet(1, 3)
+	pos.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pos.validateProposer(proposal(3, second)); err != nil {
+		t.Fatalf("epoch 1 block signed with the rotated key: %v", err)
+	}
+	if err := pos.validateProposer(proposal(3, first)); !errors.Is(err, ErrInvalidProposer) {
+		t.Fatalf("epoch 1 block signed with the retired key: %v", err)
+	}
+}
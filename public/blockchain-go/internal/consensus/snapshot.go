@@ -0,0 +1,265 @@
+// Package consensus - Per-epoch validator set snapshots for lite clients
+package consensus
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"chaincore/internal/blockchain"
+)
+
+// validatorSetKeyPrefix is the storage keyspace for snapshots
+var validatorSetKeyPrefix = []byte("vset:")
+
+// ValidatorSetSnapshot is the active validator set for an epoch. Each
+// snapshot commits to its predecessor, forming a hash chain lite clients
+// can follow from a trusted starting epoch.
+type ValidatorSetSnapshot struct {
+	Epoch       uint64
+	StartHeight uint64
+	Validators  []SnapshotValidator // Sorted by address
+	PrevHash    [32]byte
+	Hash        [32]byte
+}
+
+// SnapshotValidator is a validator entry in a snapshot
+type SnapshotValidator struct {
+	Address   [20]byte
+	PublicKey []byte // Uncompressed consensus key
+	Stake     *big.Int
+}
+
+// ValidatorsRoot is the Merkle root over the snapshot's validators
+func (s *ValidatorSetSnapshot) ValidatorsRoot() [32]byte {
+	leaves := make([][32]byte, len(s.Validators))
+	for i, v := range s.Validators {
+		leaves[i] = v.leaf()
+	}
+	return blockchain.MerkleRoot(leaves)
+}
+
+// ComputeHash returns the commitment for the snapshot
+func (s *ValidatorSetSnapshot) ComputeHash() [32]byte {
+	root := s.ValidatorsRoot()
+
+	data := make([]byte, 0, 8+8+32+32)
+	data = binary.BigEndian.AppendUint64(data, s.Epoch)
+	data = binary.BigEndian.AppendUint64(data, s.StartHeight)
+	data = append(data, s.PrevHash[:]...)
+	data = append(data, root[:]...)
+	return sha256.Sum256(data)
+}
+
+// TotalStake returns the combined stake of the set
+func (s *ValidatorSetSnapshot) TotalStake() *big.Int {
+	total := big.NewInt(0)
+	for _, v := range s.Validators {
+		total.Add(total, v.Stake)
+	}
+	return total
+}
+
+// VerifyValidatorSetLink checks that next is a well-formed successor of a
+// trusted snapshot
+func VerifyValidatorSetLink(trusted, next *ValidatorSetSnapshot) error {
+	if next.Epoch != trusted.Epoch+1 {
+		return fmt.Errorf("expected epoch %d, got %d", trusted.Epoch+1, next.Epoch)
+	}
+	if next.PrevHash != trusted.Hash {
+		return errors.New("validator set does not link to trusted snapshot")
+	}
+	if next.ComputeHash() != next.Hash {
+		return errors.New("validator set hash mismatch")
+	}
+	return nil
+}
+
+// GetValidatorSet returns the snapshot for an epoch
+func (pos *PoSEngine) GetValidatorSet(epoch uint64) (*ValidatorSetSnapshot, error) {
+	data, err := pos.chain.Database().Get(validatorSetKey(epoch))
+	if err != nil {
+		return nil, fmt.Errorf("no validator set for epoch %d", epoch)
+	}
+
+	var snapshot ValidatorSetSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// epochSnapshot returns an epoch's validator set snapshot, from the vote
+// pool for recent epochs or else from the database. It does not take pos.mu.
+func (pos *PoSEngine) epochSnapshot(epoch uint64) (*ValidatorSetSnapshot, error) {
+	if snapshot, known := pos.votePool.epochSet(epoch); known {
+		return snapshot, nil
+	}
+	return pos.GetValidatorSet(epoch)
+}
+
+// CurrentEpoch returns the epoch of the next block
+func (pos *PoSEngine) CurrentEpoch() uint64 {
+	pos.mu.RLock()
+	defer pos.mu.RUnlock()
+	return pos.currentEpoch
+}
+
+// snapshotValidatorSet persists the active set for an epoch and makes its
+// hash the commitment expected in the epoch's first block. Callers must
+// hold pos.mu.
+func (pos *PoSEngine) snapshotValidatorSet(epoch, startHeight uint64) error {
+	db := pos.chain.Database()
+	if existing, err := pos.GetValidatorSet(epoch); err == nil {
+		pos.votePool.setEpochSet(existing)
+		return nil
+	}
+
+	snapshot := &ValidatorSetSnapshot{
+		Epoch:       epoch,
+		StartHeight: startHeight,
+		Validators:  make([]SnapshotValidator, 0),
+	}
+	for _, v := range pos.getActiveValidators() {
+		snapshot.Validators = append(snapshot.Validators, SnapshotValidator{
+			Address:   v.Address,
+			PublicKey: marshalPublicKey(v.PublicKey),
+			Stake:     new(big.Int).Set(v.Stake),
+		})
+	}
+	sort.Slice(snapshot.Validators, func(i, j int) bool {
+		return bytes.Compare(snapshot.Validators[i].Address[:], snapshot.Validators[j].Address[:]) < 0
+	})
+
+	// Chain to the previous epoch. The first snapshot a node records has a
+	// zero PrevHash and serves as the trust anchor.
+	if epoch > 0 {
+		if data, err := db.Get(validatorSetKey(epoch - 1)); err == nil {
+			var parent ValidatorSetSnapshot
+			if err := json.Unmarshal(data, &parent); err != nil {
+				return err
+			}
+			snapshot.PrevHash = parent.Hash
+		}
+	}
+	snapshot.Hash = snapshot.ComputeHash()
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	if err := db.Put(validatorSetKey(epoch), data); err != nil {
+		return err
+	}
+	pos.votePool.setEpochSet(snapshot)
+	return nil
+}
+
+// MarshalJSON encodes the snapshot with hex-encoded hashes and keys
+func (s *ValidatorSetSnapshot) MarshalJSON() ([]byte, error) {
+	validators := make([]map[string]string, len(s.Validators))
+	for i, v := range s.Validators {
+		validators[i] = map[string]string{
+			"address":   blockchain.ChecksumAddress(v.Address),
+			"publicKey": "0x" + hex.EncodeToString(v.PublicKey),
+			"stake":     v.Stake.String(),
+		}
+	}
+	root := s.ValidatorsRoot()
+
+	return json.Marshal(map[string]interface{}{
+		"epoch":          s.Epoch,
+		"startHeight":    s.StartHeight,
+		"validators":     validators,
+		"validatorsRoot": "0x" + hex.EncodeToString(root[:]),
+		"totalStake":     s.TotalStake().String(),
+		"prevHash":       "0x" + hex.EncodeToString(s.PrevHash[:]),
+		"hash":           "0x" + hex.EncodeToString(s.Hash[:]),
+	})
+}
+
+// UnmarshalJSON decodes a snapshot produced by MarshalJSON
+func (s *ValidatorSetSnapshot) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Epoch       uint64 `json:"epoch"`
+		StartHeight uint64 `json:"startHeight"`
+		Validators  []struct {
+			Address   string `json:"address"`
+			PublicKey string `json:"publicKey"`
+			Stake     string `json:"stake"`
+		} `json:"validators"`
+		PrevHash string `json:"prevHash"`
+		Hash     string `json:"hash"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	s.Epoch = raw.Epoch
+	s.StartHeight = raw.StartHeight
+	s.Validators = make([]SnapshotValidator, len(raw.Validators))
+	for i, rv := range raw.Validators {
+		addr, err := blockchain.ParseAddress(rv.Address, false)
+		if err != nil {
+			return err
+		}
+		pub, err := hex.DecodeString(strings.TrimPrefix(rv.PublicKey, "0x"))
+		if err != nil {
+			return fmt.Errorf("invalid public key: %w", err)
+		}
+		stake, ok := new(big.Int).SetString(rv.Stake, 10)
+		if !ok {
+			return errors.New("invalid stake")
+		}
+		s.Validators[i] = SnapshotValidator{Address: addr, PublicKey: pub, Stake: stake}
+	}
+
+	if err := decodeHash32(raw.PrevHash, &s.PrevHash); err != nil {
+		return err
+	}
+	return decodeHash32(raw.Hash, &s.Hash)
+}
+
+// Helper functions
+
+// validator finds a member of the set by address
+func (s *ValidatorSetSnapshot) validator(addr [20]byte) (*SnapshotValidator, bool) {
+	i := sort.Search(len(s.Validators), func(i int) bool {
+		return bytes.Compare(s.Validators[i].Address[:], addr[:]) >= 0
+	})
+	if i == len(s.Validators) || s.Validators[i].Address != addr {
+		return nil, false
+	}
+	return &s.Validators[i], true
+}
+
+func (v SnapshotValidator) leaf() [32]byte {
+	var stake [32]byte
+	v.Stake.FillBytes(stake[:])
+
+	data := make([]byte, 0, 20+len(v.PublicKey)+32)
+	data = append(data, v.Address[:]...)
+	data = append(data, v.PublicKey...)
+	data = append(data, stake[:]...)
+	return sha256.Sum256(data)
+}
+
+func validatorSetKey(epoch uint64) []byte {
+	return binary.BigEndian.AppendUint64(append([]byte(nil), validatorSetKeyPrefix...), epoch)
+}
+
+func decodeHash32(s string, out *[32]byte) error {
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil || len(b) != 32 {
+		return errors.New("invalid hash")
+	}
+	copy(out[:], b)
+	return nil
+}
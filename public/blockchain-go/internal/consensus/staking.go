@@ -0,0 +1,80 @@
+// Package consensus - Validator set from on-chain staking
+package consensus
+
+import (
+	"fmt"
+	"math/big"
+
+	"chaincore/internal/blockchain"
+)
+
+// queueStakingChange records a validator whose stake changed in an inserted
+// block. It runs on the block importer's goroutine, so it only queues; the
+// consensus loop applies changes before it snapshots the next epoch.
+func (pos *PoSEngine) queueStakingChange(validator [20]byte) {
+	pos.stakingMu.Lock()
+	defer pos.stakingMu.Unlock()
+
+	pos.stakingQueue = append(pos.stakingQueue, validator)
+}
+
+// Helper functions
+
+// syncStakedValidators applies queued staking changes from the chain.
+// Callers must hold pos.mu.
+func (pos *PoSEngine) syncStakedValidators() {
+	pos.stakingMu.Lock()
+	queue := pos.stakingQueue
+	pos.stakingQueue = nil
+	pos.stakingMu.Unlock()
+
+	for _, addr := range queue {
+		staked, err := pos.chain.GetStakedValidator(addr)
+		if err != nil {
+			logger.Error("Failed to load staked validator", "validator", fmt.Sprintf("%x", addr), "err", err)
+			continue
+		}
+		pos.syncValidator(staked)
+	}
+}
+
+// loadStakedValidators registers every validator staked on chain. Callers
+// must hold pos.mu.
+func (pos *PoSEngine) loadStakedValidators() {
+	for _, staked := range pos.chain.GetStakedValidators() {
+		pos.syncValidator(staked)
+	}
+}
+
+// syncValidator updates a validator from its staking record. The stake is
+// the validator's own stake plus delegations; the consensus key is the one
+// for the current epoch and changes only when an epoch starts, and a
+// validator jailed on chain stays jailed.
+// Callers must hold pos.mu.
+func (pos *PoSEngine) syncValidator(staked *blockchain.StakedValidator) {
+	v, exists := pos.validators[staked.Address]
+	if !exists {
+		pubKey, err := unmarshalPublicKey(staked.ConsensusKey(pos.currentEpoch))
+		if err != nil {
+			logger.Warn("Staked validator has an invalid consensus key", "validator", fmt.Sprintf("%x", staked.Address), "err", err)
+			return
+		}
+		v = &Validator{
+			Address:    staked.Address,
+			PublicKey:  pubKey,
+			Commission: 10,
+			Uptime:     100.0,
+		}
+		pos.validators[staked.Address] = v
+	}
+
+	pos.syncDelegations(staked)
+
+	v.Stake = new(big.Int).Set(staked.TotalStake)
+	v.Unbonding = new(big.Int).Set(staked.Unbonding)
+	if staked.Jailed {
+		v.Jailed = true
+	}
+	v.Active = !v.Jailed && v.Stake.Sign() > 0 &&
+		(pos.config.MinStake == nil || v.Stake.Cmp(pos.config.MinStake) >= 0)
+}
@@ -0,0 +1,285 @@
+// Package consensus - Validator vote gossip, equivocation detection and quorum certificates
+package consensus
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"chaincore/internal/blockchain"
+)
+
+// voteMessageSize is the encoded size of a vote: height, block hash,
+// validator and signature
+const voteMessageSize = 8 + 32 + 20 + 65
+
+// Quorum certificate storage keys
+var qcKeyPrefix = []byte("pos:qc:")
+
+// Vote errors
+var (
+	ErrInvalidVote   = errors.New("invalid vote")
+	ErrDuplicateVote = errors.New("vote already seen")
+	ErrEquivocation  = errors.New("validator voted for conflicting blocks")
+)
+
+// Vote is a validator's signed vote for the block at a height, as gossiped
+// between nodes
+type Vote struct {
+	Height    uint64
+	BlockHash [32]byte
+	Validator [20]byte
+	Signature [65]byte // r || s of the ECDSA signature; the last byte is unused
+}
+
+// Equivocation is evidence that a validator voted for two different blocks
+// at the same height
+type Equivocation struct {
+	First  Vote
+	Second Vote
+}
+
+// QuorumCertificate holds the votes of at least two thirds of active stake
+// for a block. It is stored when the block is finalized.
+type QuorumCertificate struct {
+	Height    uint64
+	BlockHash [32]byte
+	Votes     []Vote // Sorted by validator
+}
+
+// Digest returns the message a validator signs to vote
+func (v *Vote) Digest() [32]byte {
+	return blockchain.VoteDigest(v.Height, v.BlockHash)
+}
+
+// EncodeVote serializes a vote for a MsgValidatorVote payload
+func EncodeVote(v *Vote) []byte {
+	data := make([]byte, 0, voteMessageSize)
+	data = binary.BigEndian.AppendUint64(data, v.Height)
+	data = append(data, v.BlockHash[:]...)
+	data = append(data, v.Validator[:]...)
+	data = append(data, v.Signature[:]...)
+	return data
+}
+
+// DecodeVote parses a MsgValidatorVote payload
+func DecodeVote(data []byte) (*Vote, error) {
+	if len(data) != voteMessageSize {
+		return nil, fmt.Errorf("%w: payload is %d bytes, want %d", ErrInvalidVote, len(data), voteMessageSize)
+	}
+	v := &Vote{Height: binary.BigEndian.Uint64(data[:8])}
+	copy(v.BlockHash[:], data[8:40])
+	copy(v.Validator[:], data[40:60])
+	copy(v.Signature[:], data[60:])
+	return v, nil
+}
+
+// SetVoteBroadcaster installs the function that gossips votes to peers. It
+// receives this node's own votes and votes from peers seen for the first
+// time.
+func (pos *PoSEngine) SetVoteBroadcaster(fn func(vote *Vote)) {
+	pos.mu.Lock()
+	defer pos.mu.Unlock()
+
+	pos.broadcastVote = fn
+}
+
+// ReceiveVote handles a vote gossiped by a peer. New votes are relayed so
+// they reach validators the sender is not connected to; so is the second
+// vote of an equivocation, letting every node record the evidence.
+func (pos *PoSEngine) ReceiveVote(vote *Vote) error {
+	pos.mu.Lock()
+	err := pos.recordVote(*vote)
+	broadcast := pos.broadcastVote
+	pos.mu.Unlock()
+
+	if broadcast != nil && (err == nil || errors.Is(err, ErrEquivocation)) {
+		broadcast(vote)
+	}
+	return err
+}
+
+// GetEquivocations returns the equivocation evidence collected so far
+func (pos *PoSEngine) GetEquivocations() []Equivocation {
+	pos.mu.RLock()
+	defer pos.mu.RUnlock()
+
+	evidence := make([]Equivocation, len(pos.equivocations))
+	copy(evidence, pos.equivocations)
+	return evidence
+}
+
+// GetQuorumCertificate returns the quorum certificate of a finalized block
+func (pos *PoSEngine) GetQuorumCertificate(height uint64) (*QuorumCertificate, error) {
+	data, err := pos.chain.Database().Get(qcKey(height))
+	if err != nil {
+		return nil, fmt.Errorf("no quorum certificate for block %d", height)
+	}
+	var qc QuorumCertificate
+	if err := json.Unmarshal(data, &qc); err != nil {
+		return nil, err
+	}
+	return &qc, nil
+}
+
+// Helper functions
+
+// recordVote checks a vote and adds it to the tally and the inclusion pool.
+// A validator's first vote at a height is the one that counts. Callers must
+// hold pos.mu.
+func (pos *PoSEngine) recordVote(vote Vote) error {
+	v, exists := pos.validators[vote.Validator]
+	if !exists || !pos.inActiveSet(v) {
+		return errors.New("invalid or inactive validator")
+	}
+	if !blockchain.VerifyVoteSignature(v.PublicKey, vote.Height, vote.BlockHash, vote.Signature) {
+		return errors.New("invalid vote signature")
+	}
+
+	if prev, exists := pos.votes[vote.Height][vote.Validator]; exists {
+		if prev.BlockHash == vote.BlockHash {
+			return ErrDuplicateVote
+		}
+		pos.equivocations = append(pos.equivocations, Equivocation{First: prev, Second: vote})
+		logger.Warn("Validator equivocated", "validator", fmt.Sprintf("%x", vote.Validator), "height", vote.Height)
+		return fmt.Errorf("%w: %x at height %d", ErrEquivocation, vote.Validator, vote.Height)
+	}
+
+	if pos.votes[vote.Height] == nil {
+		pos.votes[vote.Height] = make(map[[20]byte]Vote)
+	}
+	pos.votes[vote.Height][vote.Validator] = vote
+	if vote.Height > v.LastVote {
+		v.LastVote = vote.Height
+	}
+
+	// Keep the vote for inclusion in the next block
+	pos.votePool.add(vote.Height, blockchain.ValidatorVote{
+		ValidatorAddr: vote.Validator,
+		BlockHash:     vote.BlockHash,
+		Signature:     vote.Signature,
+		Timestamp:     uint64(time.Now().Unix()),
+	})
+	return nil
+}
+
+// voteForHead signs and gossips this node's vote for the chain head, once
+// per height. Callers must hold pos.mu.
+func (pos *PoSEngine) voteForHead() {
+	v, exists := pos.validators[pos.localAddr]
+	signer := pos.activeSigner()
+	if !exists || !pos.inActiveSet(v) || signer == nil {
+		return
+	}
+	head := pos.chain.GetCurrentBlock()
+	if head.Header.Height == 0 {
+		return
+	}
+	if _, voted := pos.votes[head.Header.Height][pos.localAddr]; voted {
+		return
+	}
+
+	vote := Vote{
+		Height:    head.Header.Height,
+		BlockHash: head.Hash(),
+		Validator: pos.localAddr,
+	}
+	digest := vote.Digest()
+	signature, err := signVote(signer, digest[:])
+	if err != nil {
+		logger.Error("Failed to sign vote", "height", vote.Height, "err", err)
+		return
+	}
+	vote.Signature = signature
+
+	if err := pos.recordVote(vote); err != nil {
+		logger.Warn("Failed to record own vote", "height", vote.Height, "err", err)
+		return
+	}
+	if pos.broadcastVote != nil {
+		pos.broadcastVote(&vote)
+	}
+}
+
+// quorumCertificate collects the votes for a block into a certificate.
+// Callers must hold pos.mu.
+func (pos *PoSEngine) quorumCertificate(height uint64, blockHash [32]byte) *QuorumCertificate {
+	qc := &QuorumCertificate{Height: height, BlockHash: blockHash, Votes: make([]Vote, 0)}
+	for _, vote := range pos.votes[height] {
+		if vote.BlockHash == blockHash {
+			qc.Votes = append(qc.Votes, vote)
+		}
+	}
+	sort.Slice(qc.Votes, func(i, j int) bool {
+		return bytes.Compare(qc.Votes[i].Validator[:], qc.Votes[j].Validator[:]) < 0
+	})
+	return qc
+}
+
+func (pos *PoSEngine) saveQuorumCertificate(qc *QuorumCertificate) error {
+	data, err := json.Marshal(qc)
+	if err != nil {
+		return err
+	}
+	return pos.chain.Database().Put(qcKey(qc.Height), data)
+}
+
+// evidenceTransactions packages the equivocations not yet punished on chain
+// as evidence transactions, and forgets those that have been. Callers must
+// hold pos.mu.
+func (pos *PoSEngine) evidenceTransactions() []blockchain.Transaction {
+	txs := make([]blockchain.Transaction, 0)
+	pending := pos.equivocations[:0]
+	for _, eq := range pos.equivocations {
+		if pos.chain.EvidenceApplied(eq.First.Validator, eq.First.Height) {
+			continue
+		}
+		pending = append(pending, eq)
+		txs = append(txs, blockchain.NewEvidenceTx(&blockchain.DoubleSignEvidence{
+			Validator:  eq.First.Validator,
+			Height:     eq.First.Height,
+			FirstHash:  eq.First.BlockHash,
+			FirstSig:   eq.First.Signature,
+			SecondHash: eq.Second.BlockHash,
+			SecondSig:  eq.Second.Signature,
+		}))
+	}
+	pos.equivocations = pending
+	return txs
+}
+
+// pruneVotes forgets tallied votes below minHeight. Callers must hold pos.mu.
+func (pos *PoSEngine) pruneVotes(minHeight uint64) {
+	for height := range pos.votes {
+		if height < minHeight {
+			delete(pos.votes, height)
+		}
+	}
+}
+
+// signVote signs a vote digest and packs the signature into the fixed-size
+// vote signature field
+func signVote(signer Signer, digest []byte) ([65]byte, error) {
+	var signature [65]byte
+	der, err := signer.Sign(digest)
+	if err != nil {
+		return signature, err
+	}
+	var rs struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(der, &rs); err != nil {
+		return signature, err
+	}
+	rs.R.FillBytes(signature[:32])
+	rs.S.FillBytes(signature[32:64])
+	return signature, nil
+}
+
+func qcKey(height uint64) []byte {
+	return binary.BigEndian.AppendUint64(append([]byte(nil), qcKeyPrefix...), height)
+}
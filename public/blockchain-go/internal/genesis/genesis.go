@@ -18,6 +18,7 @@ type GenesisConfig struct {
 	InitialPrice    float64          `json:"initial_price"`
 	ReservedWallets []ReservedWallet `json:"reserved_wallets"`
 	Tokenomics      Tokenomics       `json:"tokenomics"`
+	BridgeAuthority [20]byte         `json:"bridge_authority,omitempty"` // Account that sends bridge mints; zero disables them
 }
 
 // ReservedWallet represents a pre-allocated wallet
@@ -39,6 +40,7 @@ type Tokenomics struct {
 	HalvingInterval    uint64   `json:"halving_interval"`
 	TargetBlockTime    uint64   `json:"target_block_time"`
 	BurnRateOnTransfer float64  `json:"burn_rate_on_transfer"`
+	TreasuryFeePercent uint8    `json:"treasury_fee_percent"` // Percent of transaction fees paid to the protocol treasury
 }
 
 // DefaultGenesisConfig returns the default genesis configuration
@@ -104,6 +106,7 @@ func DefaultGenesisConfig() *GenesisConfig {
 			HalvingInterval:    2_100_000,
 			TargetBlockTime:    12, // 12 seconds
 			BurnRateOnTransfer: 0.001,
+			TreasuryFeePercent: 10,
 		},
 	}
 }
@@ -0,0 +1,74 @@
+// Package liteclient - HD wallet account endpoints
+package liteclient
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"chaincore/internal/wallet"
+)
+
+// handleWalletAccounts lists the accounts derived from the wallet. A wallet
+// without a mnemonic lists only its own address.
+func (api *APIServer) handleWalletAccounts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if api.wallet == nil {
+		http.Error(w, "No wallet loaded", http.StatusBadRequest)
+		return
+	}
+
+	accounts, err := api.wallet.Accounts()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"hd":       api.wallet.IsHD(),
+		"path":     wallet.HDPath,
+		"accounts": accounts,
+	})
+}
+
+// handleWalletDerive derives an account of an HD wallet and records it in
+// the wallet file: the one at index if given, e.g. to bring back accounts
+// after restoring a mnemonic, otherwise the next unused one
+func (api *APIServer) handleWalletDerive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if api.wallet == nil {
+		http.Error(w, "No wallet loaded", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Index *uint32 `json:"index"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var account wallet.Account
+	var err error
+	if req.Index != nil {
+		account, err = api.wallet.AddAccount(*req.Index)
+	} else {
+		account, err = api.wallet.DeriveAccount()
+	}
+	if errors.Is(err, wallet.ErrNotHD) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(account)
+}
@@ -4,23 +4,60 @@ package liteclient
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
+	"chaincore/internal/blockchain"
 	"chaincore/internal/mining"
 	"chaincore/internal/wallet"
 )
 
 // APIServer serves a local web interface
 type APIServer struct {
-	client     *Client
-	wallet     *wallet.Wallet
-	miner      *mining.LiteMiner
-	port       int
-	httpServer *http.Server
+	client         *Client
+	wallet         *wallet.Wallet
+	miner          *mining.LiteMiner
+	port           int
+	strictChecksum bool
+	httpServer     *http.Server
+	queue          *TxQueue
 }
 
+// HistoryEntry is a transaction sent through the local API
+type HistoryEntry struct {
+	TxHash        string `json:"txHash"`
+	Account       uint32 `json:"account"` // HD account sent from
+	From          string `json:"from"`
+	To            string `json:"to"`
+	Amount        string `json:"amount"`
+	Memo          string `json:"memo,omitempty"`
+	Nonce         uint64 `json:"nonce"`
+	GasPrice      uint64 `json:"gasPrice"`
+	Timestamp     int64  `json:"timestamp"`
+	Status        string `json:"status"`
+	BlockNumber   uint64 `json:"blockNumber,omitempty"`
+	FailureReason string `json:"failureReason,omitempty"`
+	ReplacedBy    string `json:"replacedBy,omitempty"` // Hash of the fee-bumped replacement
+	BlockedBy     string `json:"blockedBy,omitempty"`  // Hash of the stuck transaction ahead, if known
+}
+
+// History entry statuses; see TxQueue for when each applies
+const (
+	HistoryStatusPending  = "pending"
+	HistoryStatusStuck    = "stuck"
+	HistoryStatusBlocked  = "blocked"
+	HistoryStatusSuccess  = "success"
+	HistoryStatusFailed   = "failed"
+	HistoryStatusReplaced = "replaced"
+	HistoryStatusDropped  = "dropped"
+)
+
+// maxHistoryEntries bounds the in-memory send history
+const maxHistoryEntries = 1000
+
 // NewAPIServer creates a new API server
 func NewAPIServer(client *Client, wallet *wallet.Wallet, miner *mining.LiteMiner, port int) *APIServer {
 	return &APIServer{
@@ -28,9 +65,22 @@ func NewAPIServer(client *Client, wallet *wallet.Wallet, miner *mining.LiteMiner
 		wallet: wallet,
 		miner:  miner,
 		port:   port,
+		queue:  NewTxQueue(client, DefaultStuckAfter),
 	}
 }
 
+// SetStrictChecksum requires recipient addresses to be EIP-55 checksummed
+func (api *APIServer) SetStrictChecksum(strict bool) {
+	api.strictChecksum = strict
+}
+
+// SetStuckAfter sets how long a transaction may wait to be included before
+// it and the ones queued behind it are reported stuck. It must be called
+// before Start.
+func (api *APIServer) SetStuckAfter(d time.Duration) {
+	api.queue = NewTxQueue(api.client, d)
+}
+
 // Start starts the API server
 func (api *APIServer) Start() error {
 	mux := http.NewServeMux()
@@ -45,8 +95,15 @@ func (api *APIServer) Start() error {
 	mux.HandleFunc("/api/mining/start", api.handleMiningStart)
 	mux.HandleFunc("/api/mining/stop", api.handleMiningStop)
 	mux.HandleFunc("/api/mining/stats", api.handleMiningStats)
+	mux.HandleFunc("/api/mining/ws", api.handleMiningWS)
+	mux.HandleFunc("/api/mining/throttle", api.handleMiningThrottle)
+	mux.HandleFunc("/api/mining/battery", api.handleMiningBattery)
+	mux.HandleFunc("/api/mining/thermal", api.handleMiningThermal)
 	mux.HandleFunc("/api/blocks", api.handleBlocks)
 	mux.HandleFunc("/api/transactions", api.handleTransactions)
+	mux.HandleFunc("/api/transactions/bump", api.handleBumpFee)
+	mux.HandleFunc("/api/wallet/accounts", api.handleWalletAccounts)
+	mux.HandleFunc("/api/wallet/derive", api.handleWalletDerive)
 
 	api.httpServer = &http.Server{
 		Addr:    fmt.Sprintf(":%d", api.port),
@@ -101,21 +158,36 @@ func (api *APIServer) handleStatus(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(status)
 }
 
-// handleBalance returns wallet balance
+// handleBalance returns wallet balance, of the HD account given by the
+// account query parameter if there is one
 func (api *APIServer) handleBalance(w http.ResponseWriter, r *http.Request) {
 	if api.wallet == nil {
 		http.Error(w, "No wallet loaded", http.StatusBadRequest)
 		return
 	}
 
-	balance, err := api.client.GetBalance(api.wallet.Address())
+	var index uint64
+	if v := r.URL.Query().Get("account"); v != "" {
+		var err error
+		if index, err = strconv.ParseUint(v, 10, 32); err != nil {
+			http.Error(w, "Invalid account", http.StatusBadRequest)
+			return
+		}
+	}
+	account, err := api.wallet.Account(uint32(index))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	balance, err := api.client.GetBalance(account.Address())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	json.NewEncoder(w).Encode(map[string]string{
-		"address": api.wallet.Address(),
+		"address": account.Address(),
 		"balance": balance,
 	})
 }
@@ -133,8 +205,10 @@ func (api *APIServer) handleSend(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		To     string `json:"to"`
-		Amount string `json:"amount"`
+		Account uint32 `json:"account"` // HD account to send from
+		To      string `json:"to"`
+		Amount  string `json:"amount"`
+		Memo    string `json:"memo"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -142,8 +216,20 @@ func (api *APIServer) handleSend(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Validate recipient before signing
+	if _, err := blockchain.ParseAddress(req.To, api.strictChecksum); err != nil {
+		http.Error(w, "Invalid recipient address: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	account, err := api.wallet.Account(req.Account)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Create and sign transaction
-	tx, err := api.wallet.CreateTransaction(req.To, req.Amount)
+	tx, err := account.CreateTransaction(req.To, req.Amount, req.Memo)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -152,10 +238,26 @@ func (api *APIServer) handleSend(w http.ResponseWriter, r *http.Request) {
 	// Send transaction
 	txHash, err := api.client.SendTransaction(tx)
 	if err != nil {
+		// The nonce was not used; take the next one from the network
+		account.ResetNonce()
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	nonce, gasPrice := sentParams(tx)
+	api.queue.Add(HistoryEntry{
+		TxHash:    txHash,
+		Account:   req.Account,
+		From:      account.Address(),
+		To:        req.To,
+		Amount:    req.Amount,
+		Memo:      req.Memo,
+		Nonce:     nonce,
+		GasPrice:  gasPrice,
+		Timestamp: time.Now().Unix(),
+		Status:    HistoryStatusPending,
+	})
+
 	json.NewEncoder(w).Encode(map[string]string{
 		"txHash": txHash,
 	})
@@ -198,14 +300,144 @@ func (api *APIServer) handleMiningStats(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(stats)
 }
 
+// handleMiningWS serves the WebSocket browser tabs mine over
+func (api *APIServer) handleMiningWS(w http.ResponseWriter, r *http.Request) {
+	if api.miner == nil {
+		http.Error(w, "Mining not configured", http.StatusBadRequest)
+		return
+	}
+
+	api.miner.HandleBrowser(w, r)
+}
+
+// handleMiningThrottle returns the mining throttle's state, or on POST sets
+// its CPU targets
+func (api *APIServer) handleMiningThrottle(w http.ResponseWriter, r *http.Request) {
+	if api.miner == nil {
+		http.Error(w, "Mining not configured", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == "POST" {
+		current := api.miner.ThrottleStatus()
+		req := struct {
+			CPUTarget        float64 `json:"cpuTarget"`
+			BatteryCPUTarget float64 `json:"batteryCpuTarget"`
+		}{current.CPUTarget, current.BatteryCPUTarget}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := api.miner.SetCPUTargets(req.CPUTarget, req.BatteryCPUTarget); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(api.miner.ThrottleStatus())
+}
+
+// handleMiningBattery takes a battery report from a mobile wrapper
+func (api *APIServer) handleMiningBattery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if api.miner == nil {
+		http.Error(w, "Mining not configured", http.StatusBadRequest)
+		return
+	}
+
+	var status mining.BatteryStatus
+	if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := api.miner.ReportBattery(status); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(api.miner.ThrottleStatus())
+}
+
+// handleMiningThermal takes a thermal report from a mobile wrapper
+func (api *APIServer) handleMiningThermal(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if api.miner == nil {
+		http.Error(w, "Mining not configured", http.StatusBadRequest)
+		return
+	}
+
+	var status mining.ThermalStatus
+	if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := api.miner.ReportThermal(status); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(api.miner.ThrottleStatus())
+}
+
 // handleBlocks returns recent blocks
 func (api *APIServer) handleBlocks(w http.ResponseWriter, r *http.Request) {
 	// Return recent blocks
 	json.NewEncoder(w).Encode([]interface{}{})
 }
 
-// handleTransactions returns recent transactions
+// handleTransactions returns recent transactions, newest first
 func (api *APIServer) handleTransactions(w http.ResponseWriter, r *http.Request) {
-	// Return recent transactions
-	json.NewEncoder(w).Encode([]interface{}{})
+	json.NewEncoder(w).Encode(api.queue.List())
+}
+
+// handleBumpFee resends the stuck head of an account's queue at a higher
+// gas price
+func (api *APIServer) handleBumpFee(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if api.wallet == nil {
+		http.Error(w, "No wallet loaded", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		TxHash string `json:"txHash"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	replacement, err := api.queue.BumpFee(req.TxHash, func(entry HistoryEntry, gasPrice uint64) (interface{}, error) {
+		account, err := api.wallet.Account(entry.Account)
+		if err != nil {
+			return nil, err
+		}
+		return account.ReplaceTransaction(entry.To, entry.Amount, entry.Memo, entry.Nonce, gasPrice)
+	})
+	switch {
+	case errors.Is(err, ErrTxNotQueued):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, ErrTxNotPending), errors.Is(err, ErrNotQueueHead):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(replacement)
 }
@@ -3,31 +3,46 @@ package liteclient
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
 	"chaincore/internal/storage"
+	"chaincore/internal/tracing"
 )
 
 // Config holds lite client configuration
 type Config struct {
 	RPCEndpoints   []string
-	MaxRetries     int
+	MaxRetries     int // Retry rounds across all endpoints after the first attempt
 	TimeoutSeconds int
 	EnableFailover bool
 	SyncHeaders    bool
 	ValidateProofs bool
+	Transport      TransportConfig
+	Retry          RetryConfig
+	TLS            map[string]EndpointTLS // Keyed by endpoint URL
 }
 
 // Client implements the lite node RPC client
 type Client struct {
 	config        Config
 	cache         *storage.LiteCache
+	httpClients   map[string]*http.Client // One per endpoint, sharing a pooled transport unless TLS differs
+	transports    []*http.Transport
+	pinned        map[string]bool // Endpoints with TLS pins; if any, the others are never used
 	currentEndpoint int
+	headers       *HeaderStore // Verified header chain; nil if headers are not kept
 	latestHeight  uint64
 	syncing       bool
 	mu            sync.RWMutex
@@ -39,17 +54,58 @@ func NewClient(config Config, cache *storage.LiteCache) (*Client, error) {
 		return nil, errors.New("no RPC endpoints provided")
 	}
 
-	return &Client{
+	c := &Client{
 		config:        config,
 		cache:         cache,
+		httpClients:   make(map[string]*http.Client),
+		pinned:        make(map[string]bool),
 		currentEndpoint: 0,
-	}, nil
+	}
+
+	timeout := time.Duration(config.TimeoutSeconds) * time.Second
+	shared := newTransport(config.Transport, nil)
+	c.transports = append(c.transports, shared)
+
+	for _, endpoint := range config.RPCEndpoints {
+		transport := shared
+		if endpointTLS, ok := config.TLS[endpoint]; ok {
+			if endpointTLS.pinned() {
+				if !strings.HasPrefix(endpoint, "https://") {
+					return nil, fmt.Errorf("endpoint %s: pins need an https endpoint", endpoint)
+				}
+				c.pinned[endpoint] = true
+			}
+			tlsConfig, err := endpointTLS.tlsConfig()
+			if err != nil {
+				return nil, fmt.Errorf("endpoint %s: %w", endpoint, err)
+			}
+			transport = newTransport(config.Transport, tlsConfig)
+			c.transports = append(c.transports, transport)
+		}
+		c.httpClients[endpoint] = &http.Client{
+			Transport: transport,
+			Timeout:   timeout,
+		}
+	}
+
+	// Start on a pinned endpoint if pinning is in use
+	for i, endpoint := range config.RPCEndpoints {
+		if c.usable(endpoint) {
+			c.currentEndpoint = i
+			break
+		}
+	}
+
+	return c, nil
 }
 
 // Start starts the lite client
 func (c *Client) Start() error {
 	// Test connection to endpoints
 	for i, endpoint := range c.config.RPCEndpoints {
+		if !c.usable(endpoint) {
+			continue
+		}
 		if err := c.testEndpoint(endpoint); err == nil {
 			c.currentEndpoint = i
 			return nil
@@ -60,16 +116,27 @@ func (c *Client) Start() error {
 
 // Stop stops the lite client
 func (c *Client) Stop() {
-	// Cleanup
+	for _, transport := range c.transports {
+		transport.CloseIdleConnections()
+	}
+}
+
+// usable reports whether calls may go to an endpoint. Once any endpoint is
+// pinned, unpinned ones are refused, so failover cannot be steered to a
+// hijacked host name.
+func (c *Client) usable(endpoint string) bool {
+	return len(c.pinned) == 0 || c.pinned[endpoint]
 }
 
 // testEndpoint tests connectivity to an endpoint
 func (c *Client) testEndpoint(endpoint string) error {
-	_, err := c.callRPC(endpoint, "chain_getBlockNumber", nil)
+	_, err := c.callRPC(context.Background(), endpoint, "chain_getBlockNumber", nil)
 	return err
 }
 
-// SyncHeaders syncs block headers from full nodes
+// SyncHeaders syncs block headers from full nodes. With a header store the
+// chain is verified and stored, resuming from the stored head; otherwise
+// only the latest height is recorded.
 func (c *Client) SyncHeaders() error {
 	c.mu.Lock()
 	c.syncing = true
@@ -82,45 +149,71 @@ func (c *Client) SyncHeaders() error {
 	}()
 
 	// Get latest block number
-	result, err := c.Call("chain_getBlockNumber", nil)
+	height, err := c.GetBlockNumber()
 	if err != nil {
 		return err
 	}
 
-	var height uint64
-	if err := json.Unmarshal(result, &height); err != nil {
-		return err
+	if c.headers != nil {
+		return c.syncHeaderStore(height)
 	}
 
 	c.mu.Lock()
 	c.latestHeight = height
 	c.mu.Unlock()
-
-	// Sync headers from our latest to network latest
-	// This implements SPV-like header chain verification
-
 	return nil
 }
 
 // Call makes an RPC call with failover support
 func (c *Client) Call(method string, params interface{}) (json.RawMessage, error) {
+	return c.CallContext(context.Background(), method, params)
+}
+
+// CallContext makes an RPC call with failover support, propagating the trace
+// context carried by ctx to the full node. Transient failures are retried up
+// to MaxRetries rounds with jittered exponential backoff.
+func (c *Client) CallContext(ctx context.Context, method string, params interface{}) (result json.RawMessage, err error) {
+	ctx, span := tracing.StartSpan(ctx, "liteclient."+method, attribute.String("rpc.method", method))
+	defer func() { tracing.End(span, err) }()
+
+	for attempt := 0; ; attempt++ {
+		result, err = c.callOnce(ctx, method, params, span)
+		if err == nil || !isRetryable(err) || attempt >= c.config.MaxRetries || ctx.Err() != nil {
+			return result, err
+		}
+
+		delay := c.config.Retry.backoff(attempt)
+		span.AddEvent("retry", trace.WithAttributes(
+			attribute.Int("rpc.attempt", attempt+1),
+			attribute.String("rpc.backoff", delay.String()),
+		))
+		if sleepErr := sleepContext(ctx, delay); sleepErr != nil {
+			return nil, err
+		}
+	}
+}
+
+// callOnce tries the current endpoint, then the others if failover is enabled
+func (c *Client) callOnce(ctx context.Context, method string, params interface{}, span trace.Span) (json.RawMessage, error) {
 	c.mu.RLock()
-	endpoint := c.config.RPCEndpoints[c.currentEndpoint]
+	current := c.currentEndpoint
 	c.mu.RUnlock()
+	endpoint := c.config.RPCEndpoints[current]
 
-	result, err := c.callRPC(endpoint, method, params)
-	if err != nil && c.config.EnableFailover {
+	result, err := c.callRPC(ctx, endpoint, method, params)
+	if err != nil && isRetryable(err) && c.config.EnableFailover {
 		// Try other endpoints
 		for i, ep := range c.config.RPCEndpoints {
-			if i == c.currentEndpoint {
+			if i == current || !c.usable(ep) {
 				continue
 			}
-			result, err = c.callRPC(ep, method, params)
-			if err == nil {
+			span.AddEvent("failover", trace.WithAttributes(attribute.String("rpc.endpoint", ep)))
+			result, err = c.callRPC(ctx, ep, method, params)
+			if err == nil || !isRetryable(err) {
 				c.mu.Lock()
 				c.currentEndpoint = i
 				c.mu.Unlock()
-				return result, nil
+				return result, err
 			}
 		}
 	}
@@ -129,7 +222,7 @@ func (c *Client) Call(method string, params interface{}) (json.RawMessage, error
 }
 
 // callRPC makes a raw RPC call
-func (c *Client) callRPC(endpoint, method string, params interface{}) (json.RawMessage, error) {
+func (c *Client) callRPC(ctx context.Context, endpoint, method string, params interface{}) (json.RawMessage, error) {
 	reqBody := map[string]interface{}{
 		"jsonrpc": "2.0",
 		"method":  method,
@@ -142,16 +235,30 @@ func (c *Client) callRPC(endpoint, method string, params interface{}) (json.RawM
 		return nil, err
 	}
 
-	client := &http.Client{
-		Timeout: time.Duration(c.config.TimeoutSeconds) * time.Second,
+	client, ok := c.httpClients[endpoint]
+	if !ok {
+		return nil, fmt.Errorf("unknown endpoint: %s", endpoint)
 	}
 
-	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	tracing.Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		// Drain so the connection can be reused
+		io.Copy(io.Discard, resp.Body)
+		return nil, &httpStatusError{StatusCode: resp.StatusCode}
+	}
+
 	var rpcResp struct {
 		Result json.RawMessage `json:"result"`
 		Error  *struct {
@@ -165,7 +272,7 @@ func (c *Client) callRPC(endpoint, method string, params interface{}) (json.RawM
 	}
 
 	if rpcResp.Error != nil {
-		return nil, fmt.Errorf("RPC error %d: %s", rpcResp.Error.Code, rpcResp.Error.Message)
+		return nil, &RPCError{Code: rpcResp.Error.Code, Message: rpcResp.Error.Message}
 	}
 
 	return rpcResp.Result, nil
@@ -191,6 +298,58 @@ func (c *Client) GetBlock(height uint64) (json.RawMessage, error) {
 	return c.Call("chain_getBlock", height)
 }
 
+// GetTransaction retrieves a transaction by hash. The result is JSON null
+// if the node does not know the transaction.
+func (c *Client) GetTransaction(txHash string) (json.RawMessage, error) {
+	return c.Call("chain_getTransaction", txHash)
+}
+
+// GetTransactionsByAddress retrieves up to count transactions an address
+// sent or received, newest first, skipping the first offset. The result
+// holds the transactions and the nextOffset to continue from, null once
+// there are no older ones.
+func (c *Client) GetTransactionsByAddress(address string, offset, count int) (json.RawMessage, error) {
+	return c.Call("chain_getTransactionsByAddress", []interface{}{address, offset, count})
+}
+
+// TxReceipt is the outcome of an included transaction
+type TxReceipt struct {
+	BlockNumber   uint64
+	Success       bool
+	FailureReason string // Reason code for failed transactions
+}
+
+// GetTransactionReceipt retrieves the receipt of a transaction. It returns
+// nil while the transaction is pending or unknown.
+func (c *Client) GetTransactionReceipt(txHash string) (*TxReceipt, error) {
+	result, err := c.Call("chain_getTransactionReceipt", txHash)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw *struct {
+		BlockNumber   string `json:"blockNumber"`
+		Status        string `json:"status"`
+		FailureReason string `json:"failureReason"`
+	}
+	if err := json.Unmarshal(result, &raw); err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, nil
+	}
+
+	blockNumber, err := decodeHexUint(raw.BlockNumber)
+	if err != nil {
+		return nil, err
+	}
+	return &TxReceipt{
+		BlockNumber:   blockNumber,
+		Success:       raw.Status == "0x1",
+		FailureReason: raw.FailureReason,
+	}, nil
+}
+
 // GetBalance retrieves an account balance
 func (c *Client) GetBalance(address string) (string, error) {
 	result, err := c.Call("chain_getBalance", address)
@@ -206,9 +365,108 @@ func (c *Client) GetBalance(address string) (string, error) {
 	return balance, nil
 }
 
-// SendTransaction sends a transaction
+// GetNonce retrieves the account nonce of an address
+func (c *Client) GetNonce(address string) (uint64, error) {
+	result, err := c.Call("chain_getNonce", address)
+	if err != nil {
+		return 0, err
+	}
+
+	var nonce uint64
+	if err := json.Unmarshal(result, &nonce); err != nil {
+		return 0, err
+	}
+
+	return nonce, nil
+}
+
+// GetMinGasPrice retrieves the full node's advisory minimum gas price
+func (c *Client) GetMinGasPrice() (uint64, error) {
+	result, err := c.Call("chain_getGasPriceAdvisory", nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var advisory struct {
+		MinGasPrice uint64 `json:"minGasPrice"`
+	}
+	if err := json.Unmarshal(result, &advisory); err != nil {
+		return 0, err
+	}
+
+	return advisory.MinGasPrice, nil
+}
+
+// GetPendingNonce retrieves the next nonce of an address, counting its
+// transactions waiting in the full node's pool
+func (c *Client) GetPendingNonce(address string) (uint64, error) {
+	result, err := c.Call("eth_getTransactionCount", []string{address, "pending"})
+	if err != nil {
+		return 0, err
+	}
+
+	var nonce string
+	if err := json.Unmarshal(result, &nonce); err != nil {
+		return 0, err
+	}
+
+	return decodeHexUint(nonce)
+}
+
+// SuggestGasPrice returns a gas price likely to be accepted promptly: the
+// higher of eth_gasPrice and the latest base fee plus the median priority
+// fee from eth_feeHistory. Fee history is optional; if the node does not
+// serve it, eth_gasPrice alone is used.
+func (c *Client) SuggestGasPrice() (uint64, error) {
+	result, err := c.Call("eth_gasPrice", nil)
+	if err != nil {
+		return 0, err
+	}
+	var priceHex string
+	if err := json.Unmarshal(result, &priceHex); err != nil {
+		return 0, err
+	}
+	price, err := decodeHexUint(priceHex)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err = c.Call("eth_feeHistory", []interface{}{1, "latest", []int{50}})
+	if err != nil {
+		return price, nil
+	}
+	var history struct {
+		BaseFeePerGas []string   `json:"baseFeePerGas"`
+		Reward        [][]string `json:"reward"`
+	}
+	if err := json.Unmarshal(result, &history); err != nil || len(history.BaseFeePerGas) == 0 || len(history.Reward) == 0 || len(history.Reward[0]) == 0 {
+		return price, nil
+	}
+	baseFee, err := decodeHexUint(history.BaseFeePerGas[len(history.BaseFeePerGas)-1])
+	if err != nil {
+		return price, nil
+	}
+	tip, err := decodeHexUint(history.Reward[len(history.Reward)-1][0])
+	if err != nil {
+		return price, nil
+	}
+	if baseFee+tip > price {
+		price = baseFee + tip
+	}
+	return price, nil
+}
+
+// SendTransaction sends a transaction. Transactions carrying an EIP-155
+// encoding under "raw" are submitted through eth_sendRawTransaction.
 func (c *Client) SendTransaction(tx interface{}) (string, error) {
-	result, err := c.Call("chain_sendTransaction", tx)
+	method, params := "chain_sendTransaction", tx
+	if fields, ok := tx.(map[string]interface{}); ok {
+		if raw, ok := fields["raw"].(string); ok {
+			method, params = "eth_sendRawTransaction", []string{raw}
+		}
+	}
+
+	result, err := c.Call(method, params)
 	if err != nil {
 		return "", err
 	}
@@ -251,6 +509,38 @@ func (c *Client) SubmitMiningShare(share interface{}) (bool, error) {
 	return response["accepted"], nil
 }
 
+// GetBlockTemplate retrieves a solo mining template paying address
+func (c *Client) GetBlockTemplate(address string) (map[string]interface{}, error) {
+	result, err := c.Call("mining_getBlockTemplate", address)
+	if err != nil {
+		return nil, err
+	}
+
+	var template map[string]interface{}
+	if err := json.Unmarshal(result, &template); err != nil {
+		return nil, err
+	}
+
+	return template, nil
+}
+
+// SubmitSoloShare submits a nonce found on a solo mining template
+func (c *Client) SubmitSoloShare(share interface{}) (bool, error) {
+	result, err := c.Call("mining_submitSoloShare", share)
+	if err != nil {
+		return false, err
+	}
+
+	var response struct {
+		Accepted bool `json:"accepted"`
+	}
+	if err := json.Unmarshal(result, &response); err != nil {
+		return false, err
+	}
+
+	return response.Accepted, nil
+}
+
 // GetLatestHeight returns the latest synced height
 func (c *Client) GetLatestHeight() uint64 {
 	c.mu.RLock()
@@ -0,0 +1,463 @@
+// Package liteclient - Disk-backed header chain for lite node restarts
+package liteclient
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/storage"
+)
+
+// DefaultCheckpointInterval is the number of headers between checkpoints
+const DefaultCheckpointInterval = 1024
+
+// headerBatchSize is the number of headers asked for per chain_getHeaders
+// call; full nodes cap responses at the same size
+const headerBatchSize = 192
+
+// Header store keys
+var (
+	headerKeyPrefix     = []byte("hdr:h:")
+	checkpointKeyPrefix = []byte("hdr:cp:")
+	headerHeadKey       = []byte("hdr:head")
+)
+
+// Header store errors
+var (
+	ErrHeaderNotFound = errors.New("header not found")
+	ErrUnlinkedHeader = errors.New("header does not extend the stored chain")
+)
+
+// Checkpoint records the hash of a stored header at a fixed interval.
+// Checkpoints bound the work of verifying the store on open and of finding
+// where a full node's chain diverges from ours.
+type Checkpoint struct {
+	Height uint64   `json:"height"`
+	Hash   [32]byte `json:"hash"`
+}
+
+// HeaderStore keeps the verified header chain on disk so a restarted lite
+// node resumes syncing from its last header instead of from genesis. Every
+// header is checked to extend the one before it; the head and the headers
+// are written in one batch, so a crash leaves the previous head intact.
+type HeaderStore struct {
+	db                 storage.Database
+	checkpointInterval uint64
+	head               *blockchain.BlockHeader
+	headHash           [32]byte
+	checkpoints        []Checkpoint // Ascending by height
+	mu                 sync.RWMutex
+}
+
+// OpenHeaderStore opens (or creates) the header store under dataDir. The
+// stored chain is re-verified from the last checkpoint, and any headers
+// past the first broken link are discarded.
+func OpenHeaderStore(dataDir string, checkpointInterval uint64) (*HeaderStore, error) {
+	db, err := storage.NewLevelDB(storage.Config{DataDir: filepath.Join(dataDir, "headers")})
+	if err != nil {
+		return nil, err
+	}
+	hs, err := NewHeaderStore(db, checkpointInterval)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return hs, nil
+}
+
+// NewHeaderStore creates a header store on an open database. Zero uses the
+// default checkpoint interval.
+func NewHeaderStore(db storage.Database, checkpointInterval uint64) (*HeaderStore, error) {
+	if checkpointInterval == 0 {
+		checkpointInterval = DefaultCheckpointInterval
+	}
+	hs := &HeaderStore{
+		db:                 db,
+		checkpointInterval: checkpointInterval,
+	}
+	if err := hs.load(); err != nil {
+		return nil, err
+	}
+	return hs, nil
+}
+
+// Close closes the underlying database
+func (hs *HeaderStore) Close() error {
+	return hs.db.Close()
+}
+
+// Head returns the last stored header, or nil if the store is empty
+func (hs *HeaderStore) Head() *blockchain.BlockHeader {
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+	if hs.head == nil {
+		return nil
+	}
+	head := *hs.head
+	return &head
+}
+
+// Header returns the stored header at a height
+func (hs *HeaderStore) Header(height uint64) (*blockchain.BlockHeader, error) {
+	data, err := hs.db.Get(headerKey(height))
+	if err == storage.ErrNotFound {
+		return nil, ErrHeaderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var header blockchain.BlockHeader
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, err
+	}
+	return &header, nil
+}
+
+// Checkpoints returns the stored checkpoints in ascending height order
+func (hs *HeaderStore) Checkpoints() []Checkpoint {
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+	return append([]Checkpoint(nil), hs.checkpoints...)
+}
+
+// Append verifies that headers are consecutive, linked and extend the
+// stored head, then stores them. The first header of an empty store is
+// taken as given.
+func (hs *HeaderStore) Append(headers []blockchain.BlockHeader) error {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	var prevHash [32]byte
+	next := headers[0].Height
+	if hs.head != nil {
+		prevHash, next = hs.headHash, hs.head.Height+1
+	}
+
+	batch := hs.db.NewBatch()
+	var checkpoints []Checkpoint
+	for i := range headers {
+		header := &headers[i]
+		if header.Height != next || ((hs.head != nil || i > 0) && header.PrevHash != prevHash) {
+			return fmt.Errorf("%w: height %d", ErrUnlinkedHeader, header.Height)
+		}
+		data, err := json.Marshal(header)
+		if err != nil {
+			return err
+		}
+		batch.Put(headerKey(header.Height), data)
+
+		prevHash = headerHash(header)
+		if header.Height%hs.checkpointInterval == 0 {
+			checkpoint := Checkpoint{Height: header.Height, Hash: prevHash}
+			batch.Put(checkpointKey(header.Height), prevHash[:])
+			checkpoints = append(checkpoints, checkpoint)
+		}
+		next++
+	}
+	batch.Put(headerHeadKey, uint64Bytes(next-1))
+	if err := batch.Write(); err != nil {
+		return err
+	}
+
+	head := headers[len(headers)-1]
+	hs.head, hs.headHash = &head, prevHash
+	hs.checkpoints = append(hs.checkpoints, checkpoints...)
+	return nil
+}
+
+// Rewind discards the headers above height, keeping height as the new
+// head. Rewinding below the first stored header empties the store.
+func (hs *HeaderStore) Rewind(height uint64) error {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	return hs.rewind(height)
+}
+
+// Header sync
+
+// maxReorgRetries bounds how often one sync re-finds the fork point when
+// the full node's chain changes while headers are being fetched
+const maxReorgRetries = 3
+
+// SetHeaderStore makes SyncHeaders verify and store the header chain,
+// resuming from the stored head. It must be called before Start.
+func (c *Client) SetHeaderStore(hs *HeaderStore) {
+	c.headers = hs
+	if head := hs.Head(); head != nil {
+		c.latestHeight = head.Height
+	}
+}
+
+// GetHeaders retrieves up to count consecutive headers from height start
+func (c *Client) GetHeaders(start, count uint64) ([]blockchain.BlockHeader, error) {
+	result, err := c.Call("chain_getHeaders", []uint64{start, count})
+	if err != nil {
+		return nil, err
+	}
+	var headers []blockchain.BlockHeader
+	if err := json.Unmarshal(result, &headers); err != nil {
+		return nil, err
+	}
+	return headers, nil
+}
+
+// syncHeaderStore extends the stored header chain up to target. If the full
+// node's chain no longer contains our head, the store is first rewound to
+// the newest checkpoint the node still agrees with.
+func (c *Client) syncHeaderStore(target uint64) error {
+	if err := c.reconcileHeaders(target); err != nil {
+		return err
+	}
+
+	for retries := 0; ; {
+		var next uint64
+		if head := c.headers.Head(); head != nil {
+			next = head.Height + 1
+		}
+		if next > target {
+			return nil
+		}
+
+		count := target - next + 1
+		if count > headerBatchSize {
+			count = headerBatchSize
+		}
+		headers, err := c.GetHeaders(next, count)
+		if err != nil {
+			return err
+		}
+		if len(headers) == 0 {
+			return fmt.Errorf("full node returned no headers from %d", next)
+		}
+
+		err = c.headers.Append(headers)
+		if errors.Is(err, ErrUnlinkedHeader) && retries < maxReorgRetries {
+			retries++
+			if err := c.reconcileHeaders(target); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		c.mu.Lock()
+		c.latestHeight = c.headers.Head().Height
+		c.mu.Unlock()
+	}
+}
+
+// reconcileHeaders checks that the full node's chain contains our head, or
+// the header at target if the node is behind us, and otherwise rewinds to
+// the newest checkpoint both chains share
+func (c *Client) reconcileHeaders(target uint64) error {
+	head := c.headers.Head()
+	if head == nil {
+		return nil
+	}
+	height := head.Height
+	if target < height {
+		height = target
+	}
+	if ok, err := c.sharesHeader(height); err != nil || ok {
+		return err
+	}
+
+	checkpoints := c.headers.Checkpoints()
+	for i := len(checkpoints) - 1; i >= 0; i-- {
+		if checkpoints[i].Height >= height {
+			continue
+		}
+		ok, err := c.sharesHeader(checkpoints[i].Height)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return c.headers.Rewind(checkpoints[i].Height)
+		}
+	}
+
+	// No shared checkpoint: start again from genesis if the node has ours
+	ok, err := c.sharesHeader(0)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("full node is on a different chain than the stored headers")
+	}
+	return c.headers.Rewind(0)
+}
+
+// sharesHeader reports whether the full node's header at height matches
+// the stored one
+func (c *Client) sharesHeader(height uint64) (bool, error) {
+	local, err := c.headers.Header(height)
+	if err != nil {
+		return false, err
+	}
+	remote, err := c.GetHeaders(height, 1)
+	if err != nil {
+		return false, err
+	}
+	return len(remote) == 1 && headerHash(&remote[0]) == headerHash(local), nil
+}
+
+// Helper functions
+
+// load reads the head and checkpoints and verifies the headers above the
+// last checkpoint, rewinding past any that do not link
+func (hs *HeaderStore) load() error {
+	it := hs.db.NewIterator(checkpointKeyPrefix)
+	for it.Next() {
+		key, value := it.Key(), it.Value()
+		if len(key) != len(checkpointKeyPrefix)+8 || len(value) != 32 {
+			continue
+		}
+		var checkpoint Checkpoint
+		checkpoint.Height = binary.BigEndian.Uint64(key[len(checkpointKeyPrefix):])
+		copy(checkpoint.Hash[:], value)
+		hs.checkpoints = append(hs.checkpoints, checkpoint)
+	}
+	it.Release()
+	if err := it.Error(); err != nil {
+		return err
+	}
+
+	data, err := hs.db.Get(headerHeadKey)
+	if err == storage.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(data) != 8 {
+		return errors.New("corrupt header store head")
+	}
+	headHeight := binary.BigEndian.Uint64(data)
+
+	// Drop checkpoints past the head, left by an interrupted rewind
+	for len(hs.checkpoints) > 0 && hs.checkpoints[len(hs.checkpoints)-1].Height > headHeight {
+		hs.checkpoints = hs.checkpoints[:len(hs.checkpoints)-1]
+	}
+
+	// Verify from the last checkpoint, or the first stored header
+	var start *blockchain.BlockHeader
+	var startHash [32]byte
+	if n := len(hs.checkpoints); n > 0 {
+		checkpoint := hs.checkpoints[n-1]
+		if start, err = hs.Header(checkpoint.Height); err != nil {
+			return fmt.Errorf("checkpoint header %d: %w", checkpoint.Height, err)
+		}
+		if startHash = headerHash(start); startHash != checkpoint.Hash {
+			return fmt.Errorf("checkpoint header %d does not match its hash", checkpoint.Height)
+		}
+	} else if start, err = hs.firstHeader(); err == ErrHeaderNotFound {
+		// A head without headers; start over
+		return hs.db.Delete(headerHeadKey)
+	} else if err != nil {
+		return err
+	} else {
+		startHash = headerHash(start)
+	}
+
+	hs.head, hs.headHash = start, startHash
+	for height := start.Height + 1; height <= headHeight; height++ {
+		header, err := hs.Header(height)
+		if err != nil || header.Height != height || header.PrevHash != hs.headHash {
+			return hs.truncate(headHeight, height-1)
+		}
+		hs.head, hs.headHash = header, headerHash(header)
+	}
+	return nil
+}
+
+// firstHeader returns the lowest stored header
+func (hs *HeaderStore) firstHeader() (*blockchain.BlockHeader, error) {
+	it := hs.db.NewIterator(headerKeyPrefix)
+	defer it.Release()
+	if !it.Next() {
+		if err := it.Error(); err != nil {
+			return nil, err
+		}
+		return nil, ErrHeaderNotFound
+	}
+	var header blockchain.BlockHeader
+	if err := json.Unmarshal(it.Value(), &header); err != nil {
+		return nil, err
+	}
+	return &header, nil
+}
+
+// rewind discards headers and checkpoints above height. The caller must
+// hold mu.
+func (hs *HeaderStore) rewind(height uint64) error {
+	if hs.head == nil || height >= hs.head.Height {
+		return nil
+	}
+	return hs.truncate(hs.head.Height, height)
+}
+
+// truncate deletes the headers from top down to height+1 and makes the
+// header at height the head. The caller must hold mu.
+func (hs *HeaderStore) truncate(top, height uint64) error {
+	batch := hs.db.NewBatch()
+	for h := top; h > height; h-- {
+		batch.Delete(headerKey(h))
+	}
+	kept := len(hs.checkpoints)
+	for kept > 0 && hs.checkpoints[kept-1].Height > height {
+		kept--
+		batch.Delete(checkpointKey(hs.checkpoints[kept].Height))
+	}
+
+	newHead, err := hs.Header(height)
+	if err == ErrHeaderNotFound {
+		// Nothing left at or below height
+		batch.Delete(headerHeadKey)
+		newHead = nil
+	} else if err != nil {
+		return err
+	} else {
+		batch.Put(headerHeadKey, uint64Bytes(height))
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+
+	hs.checkpoints = hs.checkpoints[:kept]
+	hs.head = newHead
+	if newHead != nil {
+		hs.headHash = headerHash(newHead)
+	} else {
+		hs.headHash = [32]byte{}
+	}
+	return nil
+}
+
+func headerHash(header *blockchain.BlockHeader) [32]byte {
+	block := blockchain.Block{Header: *header}
+	return block.Hash()
+}
+
+func headerKey(height uint64) []byte {
+	return append(append([]byte{}, headerKeyPrefix...), uint64Bytes(height)...)
+}
+
+func checkpointKey(height uint64) []byte {
+	return append(append([]byte{}, checkpointKeyPrefix...), uint64Bytes(height)...)
+}
+
+func uint64Bytes(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
@@ -0,0 +1,193 @@
+// Package liteclient - SPV state proof retrieval and verification
+package liteclient
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"chaincore/internal/blockchain"
+)
+
+// rpcAccountProof mirrors the eth_getProof response
+type rpcAccountProof struct {
+	Address          string            `json:"address"`
+	AccountProof     []string          `json:"accountProof"`
+	AccountProofLeaf *rpcTrieLeaf      `json:"accountProofLeaf"`
+	Balance          string            `json:"balance"`
+	CodeHash         string            `json:"codeHash"`
+	Nonce            string            `json:"nonce"`
+	StorageHash      string            `json:"storageHash"`
+	StateRoot        string            `json:"stateRoot"`
+	BlockNumber      string            `json:"blockNumber"`
+	StorageProof     []rpcStorageProof `json:"storageProof"`
+}
+
+type rpcStorageProof struct {
+	Key       string       `json:"key"`
+	Value     string       `json:"value"`
+	Proof     []string     `json:"proof"`
+	ProofLeaf *rpcTrieLeaf `json:"proofLeaf"`
+}
+
+// rpcTrieLeaf is the leaf terminating a trie proof path
+type rpcTrieLeaf struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// GetProof fetches an account proof from a full node. When ValidateProofs
+// is enabled the proof is verified against the state root it claims; callers
+// must still compare that root with a trusted header.
+func (c *Client) GetProof(address string, storageKeys []string, block string) (*blockchain.AccountProof, error) {
+	if storageKeys == nil {
+		storageKeys = []string{}
+	}
+	if block == "" {
+		block = "latest"
+	}
+
+	result, err := c.Call("eth_getProof", []interface{}{address, storageKeys, block})
+	if err != nil {
+		return nil, err
+	}
+
+	var raw rpcAccountProof
+	if err := json.Unmarshal(result, &raw); err != nil {
+		return nil, err
+	}
+
+	proof, err := decodeAccountProof(&raw)
+	if err != nil {
+		return nil, fmt.Errorf("malformed proof: %w", err)
+	}
+
+	if c.config.ValidateProofs {
+		if err := blockchain.VerifyAccountProof(proof.StateRoot, proof); err != nil {
+			return nil, err
+		}
+	}
+
+	return proof, nil
+}
+
+// VerifyBalance fetches and verifies the balance of an address against a
+// trusted state root
+func (c *Client) VerifyBalance(address string, stateRoot [32]byte) (*big.Int, error) {
+	proof, err := c.GetProof(address, nil, "latest")
+	if err != nil {
+		return nil, err
+	}
+	if err := blockchain.VerifyAccountProof(stateRoot, proof); err != nil {
+		return nil, err
+	}
+	return proof.Balance, nil
+}
+
+// Helper functions
+func decodeAccountProof(raw *rpcAccountProof) (*blockchain.AccountProof, error) {
+	proof := &blockchain.AccountProof{}
+
+	addr, err := decodeFixedHex(raw.Address, 20)
+	if err != nil {
+		return nil, err
+	}
+	copy(proof.Address[:], addr)
+
+	if proof.Nonce, err = decodeHexUint(raw.Nonce); err != nil {
+		return nil, err
+	}
+	if proof.Height, err = decodeHexUint(raw.BlockNumber); err != nil {
+		return nil, err
+	}
+
+	balance, ok := new(big.Int).SetString(strings.TrimPrefix(raw.Balance, "0x"), 16)
+	if !ok {
+		return nil, errors.New("invalid balance")
+	}
+	proof.Balance = balance
+
+	if err := decodeHash(raw.CodeHash, &proof.CodeHash); err != nil {
+		return nil, err
+	}
+	if err := decodeHash(raw.StorageHash, &proof.StorageRoot); err != nil {
+		return nil, err
+	}
+	if err := decodeHash(raw.StateRoot, &proof.StateRoot); err != nil {
+		return nil, err
+	}
+
+	if proof.Proof, err = decodeTrieProof(raw.AccountProof, raw.AccountProofLeaf); err != nil {
+		return nil, err
+	}
+
+	for _, rsp := range raw.StorageProof {
+		sp := blockchain.StorageProof{}
+		if err := decodeHash(rsp.Key, &sp.Key); err != nil {
+			return nil, err
+		}
+		value, ok := new(big.Int).SetString(strings.TrimPrefix(rsp.Value, "0x"), 16)
+		if !ok {
+			return nil, errors.New("invalid storage value")
+		}
+		value.FillBytes(sp.Value[:])
+
+		if sp.Proof, err = decodeTrieProof(rsp.Proof, rsp.ProofLeaf); err != nil {
+			return nil, err
+		}
+		proof.StorageProof = append(proof.StorageProof, sp)
+	}
+
+	return proof, nil
+}
+
+func decodeTrieProof(nodes []string, leaf *rpcTrieLeaf) (*blockchain.TrieProof, error) {
+	proof := &blockchain.TrieProof{
+		Siblings: make([][32]byte, len(nodes)),
+	}
+	for i, node := range nodes {
+		if err := decodeHash(node, &proof.Siblings[i]); err != nil {
+			return nil, err
+		}
+	}
+	if leaf != nil {
+		if err := decodeHash(leaf.Key, &proof.LeafKey); err != nil {
+			return nil, err
+		}
+		if err := decodeHash(leaf.Value, &proof.LeafValue); err != nil {
+			return nil, err
+		}
+	}
+	return proof, nil
+}
+
+func decodeHash(s string, out *[32]byte) error {
+	b, err := decodeFixedHex(s, 32)
+	if err != nil {
+		return err
+	}
+	copy(out[:], b)
+	return nil
+}
+
+func decodeFixedHex(s string, size int) ([]byte, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != size {
+		return nil, fmt.Errorf("expected %d bytes, got %d", size, len(b))
+	}
+	return b, nil
+}
+
+func decodeHexUint(s string) (uint64, error) {
+	n, ok := new(big.Int).SetString(strings.TrimPrefix(s, "0x"), 16)
+	if !ok || !n.IsUint64() {
+		return 0, fmt.Errorf("invalid quantity: %s", s)
+	}
+	return n.Uint64(), nil
+}
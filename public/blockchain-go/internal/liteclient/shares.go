@@ -0,0 +1,228 @@
+// Package liteclient - Mining share and reward inclusion proofs
+package liteclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"chaincore/internal/blockchain"
+)
+
+// rpcMiningProof mirrors the chain_getMiningShareProof and
+// chain_getMiningRewardProof responses
+type rpcMiningProof struct {
+	BlockNumber  uint64   `json:"blockNumber"`
+	BlockHash    string   `json:"blockHash"`
+	MiningRoot   string   `json:"miningRoot"`
+	SharesRoot   string   `json:"sharesRoot"`
+	ShareCount   uint64   `json:"shareCount"`
+	RewardsRoot  string   `json:"rewardsRoot"`
+	Miner        string   `json:"miner"`
+	ShareHash    string   `json:"shareHash"`
+	Difficulty   string   `json:"difficulty"`
+	Nonce        uint64   `json:"nonce"`
+	Timestamp    uint64   `json:"timestamp"`
+	HumanScore   uint8    `json:"humanScore"`
+	SessionID    string   `json:"sessionId"`
+	PoolID       string   `json:"poolId"`
+	Shares       uint64   `json:"shares"`
+	Reward       string   `json:"reward"`
+	JobHeight    uint64   `json:"jobHeight"`
+	Signature    string   `json:"signature"`
+	TemplateRoot string   `json:"templateRoot"`
+	Index        uint64   `json:"index"`
+	Proof        []string `json:"proof"`
+}
+
+// VerifyMiningShare fetches the inclusion proof of a share, by its hash,
+// in the block at height and verifies it against the stored header. It
+// needs a header store holding that height.
+func (c *Client) VerifyMiningShare(height uint64, shareHash string) (*blockchain.MiningShareProof, error) {
+	root, err := c.trustedMiningRoot(height)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := c.getMiningProof("chain_getMiningShareProof", height, shareHash)
+	if err != nil {
+		return nil, err
+	}
+	proof, err := decodeMiningShareProof(raw)
+	if err != nil {
+		return nil, fmt.Errorf("malformed proof: %w", err)
+	}
+	if err := blockchain.VerifyMiningShareProof(root, proof); err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+// VerifyMiningReward fetches the inclusion proof of what the block at
+// height credited address and verifies it against the stored header. It
+// needs a header store holding that height.
+func (c *Client) VerifyMiningReward(height uint64, address string) (*blockchain.MiningRewardProof, error) {
+	root, err := c.trustedMiningRoot(height)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := c.getMiningProof("chain_getMiningRewardProof", height, address)
+	if err != nil {
+		return nil, err
+	}
+	proof, err := decodeMiningRewardProof(raw)
+	if err != nil {
+		return nil, fmt.Errorf("malformed proof: %w", err)
+	}
+	if err := blockchain.VerifyMiningRewardProof(root, proof); err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+// Helper functions
+
+// trustedMiningRoot returns the MiningRoot of the stored header at height
+func (c *Client) trustedMiningRoot(height uint64) ([32]byte, error) {
+	if c.headers == nil {
+		return [32]byte{}, errors.New("mining proofs need a header store")
+	}
+	header, err := c.headers.Header(height)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return header.MiningRoot, nil
+}
+
+func (c *Client) getMiningProof(method string, height uint64, key string) (*rpcMiningProof, error) {
+	result, err := c.Call(method, []interface{}{height, key})
+	if err != nil {
+		return nil, err
+	}
+	var raw rpcMiningProof
+	if err := json.Unmarshal(result, &raw); err != nil {
+		return nil, err
+	}
+	if raw.BlockNumber != height {
+		return nil, fmt.Errorf("proof is for block %d, not %d", raw.BlockNumber, height)
+	}
+	return &raw, nil
+}
+
+func decodeMiningShareProof(raw *rpcMiningProof) (*blockchain.MiningShareProof, error) {
+	proof := &blockchain.MiningShareProof{
+		BlockHeight: raw.BlockNumber,
+		ShareCount:  raw.ShareCount,
+		Share: blockchain.MiningShare{
+			Nonce:      raw.Nonce,
+			Timestamp:  raw.Timestamp,
+			HumanScore: raw.HumanScore,
+			JobHeight:  raw.JobHeight,
+		},
+	}
+	if err := decodeMiningRoots(raw, &proof.BlockHash, &proof.MiningRoot, &proof.SharesRoot, &proof.RewardsRoot); err != nil {
+		return nil, err
+	}
+
+	share := &proof.Share
+	miner, err := decodeFixedHex(raw.Miner, 20)
+	if err != nil {
+		return nil, err
+	}
+	copy(share.MinerAddr[:], miner)
+	pool, err := decodeFixedHex(raw.PoolID, 20)
+	if err != nil {
+		return nil, err
+	}
+	copy(share.PoolID[:], pool)
+	if err := decodeHash(raw.ShareHash, &share.ShareHash); err != nil {
+		return nil, err
+	}
+	if err := decodeHash(raw.SessionID, &share.SessionID); err != nil {
+		return nil, err
+	}
+	if share.Difficulty, err = decodeDecimal(raw.Difficulty); err != nil {
+		return nil, err
+	}
+	if share.Reward, err = decodeDecimal(raw.Reward); err != nil {
+		return nil, err
+	}
+	if err := decodeHash(raw.TemplateRoot, &share.TemplateRoot); err != nil {
+		return nil, err
+	}
+	if raw.Signature != "" && raw.Signature != "0x" {
+		if share.Signature, err = decodeFixedHex(raw.Signature, 65); err != nil {
+			return nil, err
+		}
+	}
+
+	if proof.Proof, err = decodeMerkleProof(raw.Index, raw.Proof); err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+func decodeMiningRewardProof(raw *rpcMiningProof) (*blockchain.MiningRewardProof, error) {
+	proof := &blockchain.MiningRewardProof{
+		BlockHeight: raw.BlockNumber,
+		ShareCount:  raw.ShareCount,
+		Reward:      blockchain.MiningReward{Shares: raw.Shares},
+	}
+	if err := decodeMiningRoots(raw, &proof.BlockHash, &proof.MiningRoot, &proof.SharesRoot, &proof.RewardsRoot); err != nil {
+		return nil, err
+	}
+
+	miner, err := decodeFixedHex(raw.Miner, 20)
+	if err != nil {
+		return nil, err
+	}
+	copy(proof.Reward.Address[:], miner)
+	if proof.Reward.Reward, err = decodeDecimal(raw.Reward); err != nil {
+		return nil, err
+	}
+
+	if proof.Proof, err = decodeMerkleProof(raw.Index, raw.Proof); err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+func decodeMiningRoots(raw *rpcMiningProof, blockHash, miningRoot, sharesRoot, rewardsRoot *[32]byte) error {
+	for _, field := range []struct {
+		value string
+		out   *[32]byte
+	}{
+		{raw.BlockHash, blockHash},
+		{raw.MiningRoot, miningRoot},
+		{raw.SharesRoot, sharesRoot},
+		{raw.RewardsRoot, rewardsRoot},
+	} {
+		if err := decodeHash(field.value, field.out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeMerkleProof(index uint64, nodes []string) (*blockchain.MerkleProof, error) {
+	proof := &blockchain.MerkleProof{
+		Index:    index,
+		Siblings: make([][32]byte, len(nodes)),
+	}
+	for i, node := range nodes {
+		if err := decodeHash(node, &proof.Siblings[i]); err != nil {
+			return nil, err
+		}
+	}
+	return proof, nil
+}
+
+func decodeDecimal(s string) (*big.Int, error) {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid amount: %s", s)
+	}
+	return n, nil
+}
@@ -0,0 +1,278 @@
+// Package liteclient - Shared HTTP transport, TLS and retry policy
+package liteclient
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// TransportConfig controls connection pooling and keep-alive for RPC calls.
+// Zero values fall back to the defaults below.
+type TransportConfig struct {
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	IdleConnTimeout       time.Duration
+	KeepAlive             time.Duration
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+
+	// Dial replaces TCP dialing, e.g. with a network.MemoryTransport; no
+	// proxy is used with it
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// EndpointTLS holds TLS settings for a single RPC endpoint. An endpoint with
+// pins is trusted on its pins alone: the server's certificate must match one
+// of them and is not checked against any CA, so a node may serve a
+// self-signed certificate, and a hijacked host name cannot pass with a
+// certificate from a public CA.
+type EndpointTLS struct {
+	CAFile             string // PEM bundle used instead of the system roots
+	CertFile           string // Client certificate for mutual TLS
+	KeyFile            string
+	ServerName         string   // Overrides the SNI / verification host name
+	InsecureSkipVerify bool     // Development only
+	CertPins           []string // Hex SHA-256 fingerprints of accepted server certificates
+	KeyPins            []string // Hex SHA-256 fingerprints of accepted server public keys (DER SubjectPublicKeyInfo)
+}
+
+// ErrPinMismatch is returned when a pinned endpoint presents a certificate
+// matching none of its pins
+var ErrPinMismatch = errors.New("server certificate does not match any pin")
+
+// RetryConfig controls backoff between retry rounds. Delays grow
+// exponentially from BaseDelay up to MaxDelay with full jitter.
+type RetryConfig struct {
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// Transport defaults
+const (
+	defaultMaxIdleConns          = 100
+	defaultMaxIdleConnsPerHost   = 10
+	defaultIdleConnTimeout       = 90 * time.Second
+	defaultKeepAlive             = 30 * time.Second
+	defaultDialTimeout           = 10 * time.Second
+	defaultTLSHandshakeTimeout   = 10 * time.Second
+	defaultResponseHeaderTimeout = 20 * time.Second
+	defaultRetryBaseDelay        = 250 * time.Millisecond
+	defaultRetryMaxDelay         = 10 * time.Second
+)
+
+// RPCError is an error returned by the full node itself. It is never retried
+// because the request reached a healthy node.
+type RPCError struct {
+	Code    int
+	Message string
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("RPC error %d: %s", e.Code, e.Message)
+}
+
+// httpStatusError is a non-200 HTTP response from an endpoint
+type httpStatusError struct {
+	StatusCode int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("unexpected HTTP status %d", e.StatusCode)
+}
+
+// newTransport builds a pooled transport with keep-alive enabled
+func newTransport(config TransportConfig, tlsConfig *tls.Config) *http.Transport {
+	config = config.withDefaults()
+
+	dialer := &net.Dialer{
+		Timeout:   config.DialTimeout,
+		KeepAlive: config.KeepAlive,
+	}
+
+	proxy, dial := http.ProxyFromEnvironment, dialer.DialContext
+	if config.Dial != nil {
+		proxy, dial = nil, config.Dial
+	}
+
+	return &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dial,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          config.MaxIdleConns,
+		MaxIdleConnsPerHost:   config.MaxIdleConnsPerHost,
+		IdleConnTimeout:       config.IdleConnTimeout,
+		TLSHandshakeTimeout:   config.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: config.ResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+		TLSClientConfig:       tlsConfig,
+	}
+}
+
+// withDefaults fills unset fields
+func (tc TransportConfig) withDefaults() TransportConfig {
+	if tc.MaxIdleConns <= 0 {
+		tc.MaxIdleConns = defaultMaxIdleConns
+	}
+	if tc.MaxIdleConnsPerHost <= 0 {
+		tc.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+	if tc.IdleConnTimeout <= 0 {
+		tc.IdleConnTimeout = defaultIdleConnTimeout
+	}
+	if tc.KeepAlive == 0 {
+		tc.KeepAlive = defaultKeepAlive
+	}
+	if tc.DialTimeout <= 0 {
+		tc.DialTimeout = defaultDialTimeout
+	}
+	if tc.TLSHandshakeTimeout <= 0 {
+		tc.TLSHandshakeTimeout = defaultTLSHandshakeTimeout
+	}
+	if tc.ResponseHeaderTimeout <= 0 {
+		tc.ResponseHeaderTimeout = defaultResponseHeaderTimeout
+	}
+	return tc
+}
+
+// tlsConfig builds a tls.Config from endpoint settings
+func (e EndpointTLS) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         e.ServerName,
+		InsecureSkipVerify: e.InsecureSkipVerify,
+	}
+
+	if e.CAFile != "" {
+		pem, err := os.ReadFile(e.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", e.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if e.CertFile != "" || e.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(e.CertFile, e.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if e.pinned() {
+		certPins, err := parsePins(e.CertPins)
+		if err != nil {
+			return nil, fmt.Errorf("certificate pin: %w", err)
+		}
+		keyPins, err := parsePins(e.KeyPins)
+		if err != nil {
+			return nil, fmt.Errorf("public key pin: %w", err)
+		}
+
+		// The pins replace CA verification
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return ErrPinMismatch
+			}
+			leaf := state.PeerCertificates[0]
+			if certPins[sha256.Sum256(leaf.Raw)] || keyPins[sha256.Sum256(leaf.RawSubjectPublicKeyInfo)] {
+				return nil
+			}
+			return ErrPinMismatch
+		}
+	}
+
+	return config, nil
+}
+
+// pinned reports whether the endpoint has certificate or public key pins
+func (e EndpointTLS) pinned() bool {
+	return len(e.CertPins) > 0 || len(e.KeyPins) > 0
+}
+
+// parsePins decodes hex SHA-256 fingerprints. Colons between bytes, as
+// printed by openssl, are allowed.
+func parsePins(pins []string) (map[[32]byte]bool, error) {
+	parsed := make(map[[32]byte]bool, len(pins))
+	for _, pin := range pins {
+		raw, err := hex.DecodeString(strings.ReplaceAll(strings.TrimSpace(pin), ":", ""))
+		if err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("%q is not a hex SHA-256 fingerprint", pin)
+		}
+		var fingerprint [32]byte
+		copy(fingerprint[:], raw)
+		parsed[fingerprint] = true
+	}
+	return parsed, nil
+}
+
+// backoff returns the delay before retry round n (0-based) using
+// exponential growth capped at MaxDelay, with full jitter
+func (rc RetryConfig) backoff(n int) time.Duration {
+	base := rc.BaseDelay
+	if base <= 0 {
+		base = defaultRetryBaseDelay
+	}
+	max := rc.MaxDelay
+	if max <= 0 {
+		max = defaultRetryMaxDelay
+	}
+
+	delay := base
+	for i := 0; i < n && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// isRetryable reports whether a failed call may succeed on another attempt
+func isRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		return false
+	}
+
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+
+	// Network and decoding errors
+	return true
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,273 @@
+// Package liteclient - Queue of sent transactions with stuck detection and fee bumping
+package liteclient
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultStuckAfter is how long the oldest pending transaction of an
+// account may wait before it is reported stuck
+const DefaultStuckAfter = 3 * time.Minute
+
+// replacementBumpPercent is the premium a replacement pays over the
+// transaction it replaces; full nodes reject replacements paying less
+const replacementBumpPercent = 10
+
+// Transaction queue errors
+var (
+	ErrTxNotQueued  = errors.New("transaction was not sent through this node")
+	ErrTxNotPending = errors.New("transaction is no longer pending")
+	ErrNotQueueHead = errors.New("only the oldest pending transaction of an account can be bumped")
+)
+
+// ReplaceFunc signs a replacement for entry that pays gasPrice
+type ReplaceFunc func(entry HistoryEntry, gasPrice uint64) (interface{}, error)
+
+// TxQueue tracks transactions sent from the wallet's accounts. The pending
+// transaction at an account's confirmed nonce is stuck once it has waited
+// stuckAfter, blocking the account's later ones.
+type TxQueue struct {
+	client     *Client
+	stuckAfter time.Duration
+	entries    []HistoryEntry       // Oldest first
+	queuedAt   map[string]time.Time // When open transactions were last seen behind an earlier nonce
+	bumpMu     sync.Mutex           // Serializes fee bumps
+	mu         sync.Mutex
+}
+
+// NewTxQueue creates a transaction queue. A zero stuckAfter uses
+// DefaultStuckAfter.
+func NewTxQueue(client *Client, stuckAfter time.Duration) *TxQueue {
+	if stuckAfter <= 0 {
+		stuckAfter = DefaultStuckAfter
+	}
+	return &TxQueue{
+		client:     client,
+		stuckAfter: stuckAfter,
+		queuedAt:   make(map[string]time.Time),
+	}
+}
+
+// Add records a sent transaction
+func (q *TxQueue) Add(entry HistoryEntry) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.entries = append(q.entries, entry)
+	if len(q.entries) > maxHistoryEntries {
+		delete(q.queuedAt, q.entries[0].TxHash)
+		q.entries = q.entries[len(q.entries)-maxHistoryEntries:]
+	}
+}
+
+// List refreshes the queue and returns its transactions, newest first
+func (q *TxQueue) List() []HistoryEntry {
+	q.Refresh()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entries := make([]HistoryEntry, 0, len(q.entries))
+	for i := len(q.entries) - 1; i >= 0; i-- {
+		entries = append(entries, q.entries[i])
+	}
+	return entries
+}
+
+// Get refreshes the queue and returns one transaction
+func (q *TxQueue) Get(txHash string) (HistoryEntry, error) {
+	q.Refresh()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if i := q.find(txHash); i >= 0 {
+		return q.entries[i], nil
+	}
+	return HistoryEntry{}, ErrTxNotQueued
+}
+
+// Refresh looks up receipts for the transactions still open and the
+// confirmed nonce of their accounts, and updates their statuses. Accounts
+// whose nonce cannot be fetched keep their statuses until the next refresh.
+func (q *TxQueue) Refresh() {
+	q.mu.Lock()
+	var hashes []string
+	accounts := make(map[string]bool)
+	for _, entry := range q.entries {
+		if isOpen(entry.Status) || entry.Status == HistoryStatusReplaced {
+			hashes = append(hashes, entry.TxHash)
+		}
+		if isOpen(entry.Status) {
+			accounts[entry.From] = true
+		}
+	}
+	q.mu.Unlock()
+
+	receipts := make(map[string]*TxReceipt, len(hashes))
+	for _, txHash := range hashes {
+		receipt, err := q.client.GetTransactionReceipt(txHash)
+		if err == nil && receipt != nil {
+			receipts[txHash] = receipt
+		}
+	}
+	confirmed := make(map[string]uint64, len(accounts))
+	for from := range accounts {
+		if nonce, err := q.client.GetNonce(from); err == nil {
+			confirmed[from] = nonce
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// Included transactions first; a replaced one may have been included
+	// after all if its replacement never reached the pool
+	for i := range q.entries {
+		entry := &q.entries[i]
+		receipt, included := receipts[entry.TxHash]
+		if !included || !(isOpen(entry.Status) || entry.Status == HistoryStatusReplaced) {
+			continue
+		}
+		delete(q.queuedAt, entry.TxHash)
+		entry.BlockNumber = receipt.BlockNumber
+		entry.FailureReason = receipt.FailureReason
+		entry.BlockedBy = ""
+		entry.Status = HistoryStatusSuccess
+		if !receipt.Success {
+			entry.Status = HistoryStatusFailed
+		}
+	}
+
+	// Then the open ones of each account whose nonce is known, head first
+	now := time.Now()
+	for from, nonce := range confirmed {
+		head := -1
+		for i := range q.entries {
+			entry := &q.entries[i]
+			if entry.From != from || !isOpen(entry.Status) {
+				continue
+			}
+			entry.BlockedBy = ""
+			switch {
+			case entry.Nonce < nonce:
+				entry.Status = HistoryStatusDropped
+				delete(q.queuedAt, entry.TxHash)
+			case entry.Nonce == nonce:
+				entry.Status = HistoryStatusPending
+				head = i // The latest sent wins if there are several
+			default:
+				entry.Status = HistoryStatusPending
+				q.queuedAt[entry.TxHash] = now
+			}
+		}
+
+		stuck := false
+		if head >= 0 {
+			since := time.Unix(q.entries[head].Timestamp, 0)
+			if queued, ok := q.queuedAt[q.entries[head].TxHash]; ok && queued.After(since) {
+				since = queued
+			}
+			stuck = now.Sub(since) >= q.stuckAfter
+		}
+		if stuck {
+			q.entries[head].Status = HistoryStatusStuck
+		}
+		for i := range q.entries {
+			entry := &q.entries[i]
+			if entry.From != from || entry.Status != HistoryStatusPending || entry.Nonce <= nonce {
+				continue
+			}
+			if head < 0 {
+				entry.Status = HistoryStatusBlocked
+			} else if stuck {
+				entry.Status = HistoryStatusBlocked
+				entry.BlockedBy = q.entries[head].TxHash
+			}
+		}
+	}
+}
+
+// BumpFee replaces the head of an account's queue with the same transaction
+// at a higher gas price, signed by replace, and returns the replacement
+func (q *TxQueue) BumpFee(txHash string, replace ReplaceFunc) (HistoryEntry, error) {
+	q.bumpMu.Lock()
+	defer q.bumpMu.Unlock()
+
+	entry, err := q.Get(txHash)
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+	if !isOpen(entry.Status) {
+		return HistoryEntry{}, ErrTxNotPending
+	}
+	nonce, err := q.client.GetNonce(entry.From)
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+	if entry.Nonce != nonce {
+		return HistoryEntry{}, ErrNotQueueHead
+	}
+
+	gasPrice := entry.GasPrice + entry.GasPrice*replacementBumpPercent/100
+	if gasPrice == entry.GasPrice {
+		gasPrice++
+	}
+	if suggested, err := q.client.SuggestGasPrice(); err == nil && suggested > gasPrice {
+		gasPrice = suggested
+	}
+	tx, err := replace(entry, gasPrice)
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+	replacementHash, err := q.client.SendTransaction(tx)
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+
+	replacement := entry
+	replacement.TxHash = replacementHash
+	replacement.GasPrice = gasPrice
+	replacement.Timestamp = time.Now().Unix()
+	replacement.Status = HistoryStatusPending
+
+	q.mu.Lock()
+	if i := q.find(txHash); i >= 0 {
+		q.entries[i].Status = HistoryStatusReplaced
+		q.entries[i].ReplacedBy = replacementHash
+	}
+	q.mu.Unlock()
+	q.Add(replacement)
+	return replacement, nil
+}
+
+// Helper functions
+
+// find returns the index of a transaction, or -1. The caller must hold mu.
+func (q *TxQueue) find(txHash string) int {
+	for i := len(q.entries) - 1; i >= 0; i-- {
+		if q.entries[i].TxHash == txHash {
+			return i
+		}
+	}
+	return -1
+}
+
+// isOpen reports whether a transaction with status may still be included
+func isOpen(status string) bool {
+	return status == HistoryStatusPending || status == HistoryStatusStuck || status == HistoryStatusBlocked
+}
+
+// sentParams reads the nonce and gas price of a transaction created by the
+// wallet
+func sentParams(tx interface{}) (nonce uint64, gasPrice uint64) {
+	fields, _ := tx.(map[string]interface{})
+	nonce, _ = fields["nonce"].(uint64)
+	if price, ok := fields["gasPrice"].(string); ok {
+		gasPrice, _ = strconv.ParseUint(price, 10, 64)
+	}
+	return nonce, gasPrice
+}
@@ -0,0 +1,65 @@
+// Package liteclient - Validator set tracking across epochs
+package liteclient
+
+import (
+	"encoding/json"
+	"errors"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/consensus"
+)
+
+var errInvalidValidatorSet = errors.New("validator set hash mismatch")
+
+// GetValidatorSet fetches the validator set snapshot for an epoch
+func (c *Client) GetValidatorSet(epoch uint64) (*consensus.ValidatorSetSnapshot, error) {
+	result, err := c.Call("pos_getValidatorSet", []interface{}{epoch})
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot consensus.ValidatorSetSnapshot
+	if err := json.Unmarshal(result, &snapshot); err != nil {
+		return nil, err
+	}
+	if snapshot.ComputeHash() != snapshot.Hash {
+		return nil, errInvalidValidatorSet
+	}
+	return &snapshot, nil
+}
+
+// FollowValidatorSets walks the validator set hash chain from a trusted
+// snapshot up to the target epoch, verifying every link and the commitment
+// in each epoch's first block. It returns the snapshot for the target epoch.
+func (c *Client) FollowValidatorSets(trusted *consensus.ValidatorSetSnapshot, target uint64) (*consensus.ValidatorSetSnapshot, error) {
+	current := trusted
+	for current.Epoch < target {
+		next, err := c.GetValidatorSet(current.Epoch + 1)
+		if err != nil {
+			return nil, err
+		}
+		if err := consensus.VerifyValidatorSetLink(current, next); err != nil {
+			return nil, err
+		}
+		if err := c.verifyValidatorSetCommitment(next); err != nil {
+			return nil, err
+		}
+		current = next
+	}
+	return current, nil
+}
+
+// verifyValidatorSetCommitment checks a snapshot against the hash committed
+// in the header of its epoch's first block
+func (c *Client) verifyValidatorSetCommitment(snapshot *consensus.ValidatorSetSnapshot) error {
+	result, err := c.GetBlock(snapshot.StartHeight)
+	if err != nil {
+		return err
+	}
+
+	var block blockchain.Block
+	if err := json.Unmarshal(result, &block); err != nil {
+		return err
+	}
+	return consensus.VerifyValidatorSetCommitment(&block.Header, snapshot)
+}
@@ -0,0 +1,251 @@
+// Package logging provides leveled, structured logs for each subsystem.
+// Subsystems log through a module logger created with New; which records
+// are written is decided by each module's level, which can be changed while
+// the node runs, and how they are written by Setup.
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Rotation defaults, used when the config leaves them zero
+const (
+	defaultMaxSizeMB  = 100
+	defaultMaxBackups = 5
+)
+
+// moduleKey is the attribute naming the module a record was logged by
+const moduleKey = "module"
+
+// stdModule is the module the standard library logger writes as once Setup
+// has run, which covers the binaries' own log.Printf calls
+const stdModule = "main"
+
+// ErrUnknownModule is returned for a level given to a module that no
+// logger was created for
+var ErrUnknownModule = errors.New("unknown log module")
+
+// Config configures log output
+type Config struct {
+	Level      string // Level spec, e.g. "info,chainsync=debug"; defaults to info
+	JSON       bool   // Write JSON lines instead of text
+	File       string // Write to this file instead of stderr
+	MaxSizeMB  int    // Rotate the file once it grows past this size
+	MaxBackups int    // Rotated files kept, as File.1 (newest) to File.N
+}
+
+// Levels is a default level with overrides by module
+type Levels struct {
+	Default slog.Level
+	Modules map[string]slog.Level
+}
+
+var (
+	// handler is where every module logger's records go
+	handler atomic.Pointer[slog.Handler]
+	// output is the log file, if Setup opened one
+	output io.Closer
+
+	levels  = Levels{Default: slog.LevelInfo, Modules: map[string]slog.Level{}}
+	modules = map[string]bool{} // Modules a logger was created for
+	mu      sync.RWMutex
+)
+
+func init() {
+	setHandler(os.Stderr, false)
+}
+
+// New returns the logger of a module. Packages keep it in a package
+// variable; until Setup is called it writes text to stderr.
+func New(module string) *slog.Logger {
+	mu.Lock()
+	modules[module] = true
+	mu.Unlock()
+
+	return slog.New(&moduleHandler{module: module})
+}
+
+// Setup directs logs to the configured output and applies the level spec.
+// The standard library logger is redirected too, logging as module main.
+func Setup(config Config) error {
+	parsed, err := ParseLevels(config.Level)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stderr
+	var file *rotatingFile
+	if config.File != "" {
+		maxSize, maxBackups := config.MaxSizeMB, config.MaxBackups
+		if maxSize <= 0 {
+			maxSize = defaultMaxSizeMB
+		}
+		if maxBackups <= 0 {
+			maxBackups = defaultMaxBackups
+		}
+		if file, err = openRotatingFile(config.File, int64(maxSize)<<20, maxBackups); err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		w = file
+	}
+
+	// The previous file is closed only once nothing writes to it
+	setHandler(w, config.JSON)
+	Close()
+	if file != nil {
+		output = file
+	}
+	slog.SetDefault(New(stdModule))
+	SetLevels(parsed)
+	return nil
+}
+
+// Close closes the log file, if any. Records logged afterwards are lost.
+func Close() error {
+	if output == nil {
+		return nil
+	}
+	err := output.Close()
+	output = nil
+	return err
+}
+
+// ParseLevels parses a level spec such as "info,chainsync=debug". Levels
+// are debug, info, warn and error; modules must have a logger.
+func ParseLevels(spec string) (Levels, error) {
+	parsed := Levels{Default: slog.LevelInfo, Modules: make(map[string]slog.Level)}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		module, level, found := strings.Cut(part, "=")
+		if !found {
+			module, level = "", part
+		}
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(strings.TrimSpace(level))); err != nil {
+			return Levels{}, fmt.Errorf("invalid log level %q", level)
+		}
+		if module = strings.TrimSpace(module); module == "" {
+			parsed.Default = l
+			continue
+		}
+		mu.RLock()
+		known := modules[module]
+		mu.RUnlock()
+		if !known {
+			return Levels{}, fmt.Errorf("%w: %s", ErrUnknownModule, module)
+		}
+		parsed.Modules[module] = l
+	}
+	return parsed, nil
+}
+
+// SetLevels replaces the default level and every module override
+func SetLevels(l Levels) {
+	overrides := make(map[string]slog.Level, len(l.Modules))
+	for module, level := range l.Modules {
+		overrides[module] = level
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	levels = Levels{Default: l.Default, Modules: overrides}
+}
+
+// CurrentLevels returns the level each module logs at
+func CurrentLevels() map[string]string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	result := make(map[string]string, len(modules))
+	for module := range modules {
+		result[module] = levelName(levelOf(module))
+	}
+	return result
+}
+
+// String formats levels as a spec ParseLevels accepts
+func (l Levels) String() string {
+	parts := []string{levelName(l.Default)}
+	names := make([]string, 0, len(l.Modules))
+	for module := range l.Modules {
+		names = append(names, module)
+	}
+	sort.Strings(names)
+	for _, module := range names {
+		parts = append(parts, module+"="+levelName(l.Modules[module]))
+	}
+	return strings.Join(parts, ",")
+}
+
+// Helper functions
+
+// moduleHandler filters records by its module's level and hands the rest
+// to the current handler, tagged with the module
+type moduleHandler struct {
+	module string
+	wrap   []func(slog.Handler) slog.Handler // WithAttrs and WithGroup calls, in order
+}
+
+func (h *moduleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return level >= levelOf(h.module)
+}
+
+func (h *moduleHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := (*handler.Load()).WithAttrs([]slog.Attr{slog.String(moduleKey, h.module)})
+	for _, wrap := range h.wrap {
+		out = wrap(out)
+	}
+	return out.Handle(ctx, r)
+}
+
+func (h *moduleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (h *moduleHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+func (h *moduleHandler) with(wrap func(slog.Handler) slog.Handler) *moduleHandler {
+	wraps := make([]func(slog.Handler) slog.Handler, len(h.wrap), len(h.wrap)+1)
+	copy(wraps, h.wrap)
+	return &moduleHandler{module: h.module, wrap: append(wraps, wrap)}
+}
+
+// setHandler makes every logger write to w. Filtering is left to the
+// module handlers, so the output handler accepts every level.
+func setHandler(w io.Writer, json bool) {
+	options := &slog.HandlerOptions{Level: slog.Level(math.MinInt)}
+	var h slog.Handler = slog.NewTextHandler(w, options)
+	if json {
+		h = slog.NewJSONHandler(w, options)
+	}
+	handler.Store(&h)
+}
+
+// levelOf returns a module's level. Callers must hold mu.
+func levelOf(module string) slog.Level {
+	if level, ok := levels.Modules[module]; ok {
+		return level
+	}
+	return levels.Default
+}
+
+func levelName(level slog.Level) string {
+	return strings.ToLower(level.String())
+}
@@ -17,7 +17,10 @@ type AntiBotConfig struct {
 	EntropyMinimum          float64
 	BehaviorCacheSize       int
 	ChallengeEnabled        bool
-	ChallengeInterval       time.Duration
+	ChallengeInterval       time.Duration // Time a challenge may take to solve
+	ChallengeThreshold      uint8         // Scores below this are challenged
+	ChallengeRecovery       uint8         // Score restored by each solved challenge
+	RecordTTL               time.Duration // How long evidence about an address lasts (default a day)
 }
 
 // BehaviorPattern tracks miner behavior for analysis
@@ -32,20 +35,27 @@ type BehaviorPattern struct {
 
 // AntiBotEngine implements bot detection
 type AntiBotEngine struct {
-	config    AntiBotConfig
-	patterns  map[[20]byte]*BehaviorPattern
-	scores    map[[20]byte]uint8
-	blacklist map[[20]byte]time.Time
-	mu        sync.RWMutex
+	config     AntiBotConfig
+	patterns   map[[20]byte]*BehaviorPattern
+	scores     map[[20]byte]uint8
+	blacklist  map[[20]byte]time.Time
+	challenges map[[20]byte]*Challenge // Outstanding challenges
+	lastSeen   map[[20]byte]time.Time // Last activity of each address, for decay
+	origins    map[string]*originActivity
+	lastSweep  time.Time // Last sweep of idle origins
+	mu         sync.RWMutex
 }
 
 // NewAntiBotEngine creates a new anti-bot engine
 func NewAntiBotEngine(config AntiBotConfig) *AntiBotEngine {
 	return &AntiBotEngine{
-		config:    config,
-		patterns:  make(map[[20]byte]*BehaviorPattern),
-		scores:    make(map[[20]byte]uint8),
-		blacklist: make(map[[20]byte]time.Time),
+		config:     config,
+		patterns:   make(map[[20]byte]*BehaviorPattern),
+		scores:     make(map[[20]byte]uint8),
+		blacklist:  make(map[[20]byte]time.Time),
+		challenges: make(map[[20]byte]*Challenge),
+		lastSeen:   make(map[[20]byte]time.Time),
+		origins:    make(map[string]*originActivity),
 	}
 }
 
@@ -64,6 +74,7 @@ func (ab *AntiBotEngine) AnalyzeSubmission(addr [20]byte, submission *ShareSubmi
 
 	// Get or create pattern
 	pattern := ab.getOrCreatePattern(addr)
+	ab.lastSeen[addr] = time.Now()
 	
 	// Record this submission
 	pattern.SubmissionTimes = append(pattern.SubmissionTimes, time.Now())
@@ -235,13 +246,10 @@ func GetDailyAddressCap(humanScore uint8, daysActive int, baseCap float64) float
 
 // GenerateChallenge generates a PoW challenge for verification
 func (ab *AntiBotEngine) GenerateChallenge(addr [20]byte) *Challenge {
-	challenge := &Challenge{
-		ID:         generateChallengeID(addr),
-		Difficulty: calculateChallengeDifficulty(ab.scores[addr]),
-		CreatedAt:  time.Now(),
-		ExpiresAt:  time.Now().Add(time.Minute),
-	}
-	return challenge
+	ab.mu.RLock()
+	defer ab.mu.RUnlock()
+
+	return ab.newChallenge(addr, ab.decayedScore(addr, time.Now()))
 }
 
 // Challenge represents a PoW challenge for bot verification
@@ -265,15 +273,20 @@ func (ab *AntiBotEngine) VerifyChallenge(addr [20]byte, challenge *Challenge, no
 	
 	hash := sha256.Sum256(data)
 	
-	// Check leading zeros
-	requiredZeros := challenge.Difficulty / 8
-	for i := uint64(0); i < requiredZeros; i++ {
-		if hash[i] != 0 {
-			return false
-		}
-	}
+	// Check leading zero bits
+	return leadingZeroBits(hash) >= challenge.Difficulty
+}
 
-	return true
+// Forget drops everything recorded for addr, for sessions that have ended
+func (ab *AntiBotEngine) Forget(addr [20]byte) {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	delete(ab.patterns, addr)
+	delete(ab.scores, addr)
+	delete(ab.blacklist, addr)
+	delete(ab.challenges, addr)
+	delete(ab.lastSeen, addr)
 }
 
 // Helper functions
@@ -301,6 +314,17 @@ func (ab *AntiBotEngine) trimPattern(pattern *BehaviorPattern) {
 	if len(pattern.NonceValues) > maxSize {
 		pattern.NonceValues = pattern.NonceValues[len(pattern.NonceValues)-maxSize:]
 	}
+
+	// Drop submissions older than the record TTL
+	cutoff := time.Now().Add(-ab.recordTTL())
+	stale := 0
+	for stale < len(pattern.SubmissionTimes) && pattern.SubmissionTimes[stale].Before(cutoff) {
+		stale++
+	}
+	if stale > 0 && stale <= len(pattern.NonceValues) {
+		pattern.SubmissionTimes = pattern.SubmissionTimes[stale:]
+		pattern.NonceValues = pattern.NonceValues[stale:]
+	}
 }
 
 func average(values []float64) float64 {
@@ -356,10 +380,10 @@ func generateChallengeID(addr [20]byte) [32]byte {
 func calculateChallengeDifficulty(score uint8) uint64 {
 	// Lower scores = harder challenges
 	base := uint64(16)
-	if score < 50 {
+	if score < 30 {
 		base = 24
-	} else if score < 30 {
-		base = 32
+	} else if score < 50 {
+		base = 20
 	}
 	return base
 }
@@ -0,0 +1,324 @@
+// Package mining - Browser mining bridge for the lite node API
+package mining
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"chaincore/internal/websocket"
+)
+
+// Browser mining limits
+const (
+	maxBrowserTabs       = 8                      // Tabs mining at once
+	maxTabJobs           = 4                      // Jobs a tab may still submit shares for
+	browserNonceRange    = 1 << 22                // Nonces per job, seconds of work for a browser
+	browserJobInterval   = 500 * time.Millisecond // Least time between a tab's job requests
+	browserMinHumanScore = 30                     // Tabs scoring below are disconnected
+)
+
+// browserNonceBase starts the nonces handed to tabs, apart from those of
+// the CPU threads
+const browserNonceBase = 1 << 63
+
+// Browser mining errors
+var (
+	ErrBrowserDisabled  = errors.New("browser mining disabled")
+	ErrTooManyTabs      = errors.New("too many browser tabs mining")
+	ErrOriginNotAllowed = errors.New("origin not allowed to mine")
+)
+
+// browserTab is one tab's mining session
+type browserTab struct {
+	id           [20]byte // Anti-bot session key
+	conn         *websocket.Conn
+	host         string
+	userAgent    string
+	jobs         []*browserJob // Oldest first
+	lastJob      time.Time
+	windowStart  time.Time // Start of the current share rate window
+	windowShares int
+}
+
+// browserJob is a nonce range of the miner's work handed to a tab
+type browserJob struct {
+	id         string
+	work       miningJob
+	header     []byte
+	target     *big.Int
+	nonceStart uint64
+	found      map[uint64]bool
+}
+
+// browserMessage is a message between a tab and the node
+type browserMessage struct {
+	Type       string          `json:"type"`
+	Job        *browserJobInfo `json:"job,omitempty"`
+	JobID      string          `json:"jobId,omitempty"`
+	Nonce      string          `json:"nonce,omitempty"`
+	Accepted   bool            `json:"accepted,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	RetryAfter float64         `json:"retryAfter,omitempty"`
+}
+
+// browserJobInfo is a job as tabs receive it. A tab hashes
+// sha256(header ‖ nonce), the nonce 8 bytes big-endian, over its range and
+// submits nonces below the target.
+type browserJobInfo struct {
+	ID         string `json:"id"`
+	Algorithm  string `json:"algorithm"`
+	Header     string `json:"header"`
+	Target     string `json:"target"`
+	NonceStart string `json:"nonceStart"` // 16 hex digits
+	NonceCount uint64 `json:"nonceCount"`
+}
+
+// HandleBrowser serves a browser tab's mining WebSocket. The miner must be
+// running, with EnableBrowser set.
+func (m *LiteMiner) HandleBrowser(w http.ResponseWriter, r *http.Request) {
+	if !m.config.EnableBrowser {
+		http.Error(w, ErrBrowserDisabled.Error(), http.StatusNotFound)
+		return
+	}
+	if !m.browserOriginAllowed(r) {
+		http.Error(w, ErrOriginNotAllowed.Error(), http.StatusForbidden)
+		return
+	}
+	if !m.IsRunning() {
+		http.Error(w, "mining not started", http.StatusServiceUnavailable)
+		return
+	}
+	if atomic.AddInt32(&m.tabs, 1) > maxBrowserTabs {
+		atomic.AddInt32(&m.tabs, -1)
+		http.Error(w, ErrTooManyTabs.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer atomic.AddInt32(&m.tabs, -1)
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	tab := &browserTab{conn: conn, userAgent: r.UserAgent()}
+	tab.host, _, _ = net.SplitHostPort(r.RemoteAddr)
+	if _, err := rand.Read(tab.id[:]); err != nil {
+		conn.Close()
+		return
+	}
+	m.serveTab(tab)
+}
+
+// Helper functions
+
+// serveTab runs a tab's session until it disconnects or the miner stops
+func (m *LiteMiner) serveTab(tab *browserTab) {
+	done := make(chan struct{})
+	defer close(done)
+	stopCh := m.stopCh
+	go func() {
+		ticker := time.NewTicker(websocket.PingPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-stopCh:
+				tab.conn.WriteClose(websocket.CloseGoingAway, "mining stopped")
+				tab.conn.Close()
+				return
+			case <-ticker.C:
+				if err := tab.conn.WritePing(); err != nil {
+					tab.conn.Close()
+					return
+				}
+			}
+		}
+	}()
+	defer tab.conn.Close()
+	defer m.antiBot.Forget(tab.id)
+
+	for {
+		data, err := tab.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg browserMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			tab.send(browserMessage{Type: "error", Error: "invalid message"})
+			continue
+		}
+
+		switch msg.Type {
+		case "job":
+			m.sendTabJob(tab)
+		case "submit":
+			if !m.submitTabShare(tab, msg.JobID, msg.Nonce) {
+				return
+			}
+		default:
+			tab.send(browserMessage{Type: "error", Error: fmt.Sprintf("unknown message type %q", msg.Type)})
+		}
+	}
+}
+
+// sendTabJob hands a tab the next nonce range of the current work
+func (m *LiteMiner) sendTabJob(tab *browserTab) {
+	now := time.Now()
+	if wait := browserJobInterval - now.Sub(tab.lastJob); wait > 0 {
+		tab.send(browserMessage{Type: "throttle", RetryAfter: wait.Seconds()})
+		return
+	}
+	tab.lastJob = now
+
+	job, err := m.newBrowserJob()
+	if err != nil {
+		tab.send(browserMessage{Type: "error", Error: err.Error()})
+		return
+	}
+	tab.jobs = append(tab.jobs, job)
+	if len(tab.jobs) > maxTabJobs {
+		tab.jobs = tab.jobs[len(tab.jobs)-maxTabJobs:]
+	}
+	tab.send(browserMessage{Type: "job", Job: &browserJobInfo{
+		ID:         job.id,
+		Algorithm:  "sha256",
+		Header:     hex.EncodeToString(job.header),
+		Target:     fmt.Sprintf("%064x", job.target),
+		NonceStart: fmt.Sprintf("%016x", job.nonceStart),
+		NonceCount: browserNonceRange,
+	}})
+}
+
+// submitTabShare checks and submits a nonce a tab found, answering the tab.
+// It returns false if the tab is to be disconnected.
+func (m *LiteMiner) submitTabShare(tab *browserTab, jobID, nonceHex string) bool {
+	reject := func(reason string) bool {
+		tab.send(browserMessage{Type: "result", JobID: jobID, Nonce: nonceHex, Error: reason})
+		return true
+	}
+
+	var job *browserJob
+	for _, candidate := range tab.jobs {
+		if candidate.id == jobID {
+			job = candidate
+		}
+	}
+	if job == nil {
+		return reject("unknown or expired job")
+	}
+	nonce, err := strconv.ParseUint(strings.TrimPrefix(nonceHex, "0x"), 16, 64)
+	if err != nil {
+		return reject("invalid nonce")
+	}
+	if nonce < job.nonceStart || nonce-job.nonceStart >= browserNonceRange {
+		return reject("nonce outside the job's range")
+	}
+	if job.found[nonce] {
+		return reject("duplicate share")
+	}
+	hash := sha256.Sum256(append(job.header[:len(job.header):len(job.header)], uint64Bytes(nonce)...))
+	if new(big.Int).SetBytes(hash[:]).Cmp(job.target) >= 0 {
+		return reject("share above target")
+	}
+	job.found[nonce] = true
+
+	// Score the tab and hold it to the share rate its score allows
+	now := time.Now()
+	score, _ := m.antiBot.AnalyzeSubmission(tab.id, &ShareSubmission{
+		Nonce:     nonce,
+		Hash:      hash,
+		Timestamp: now,
+		UserAgent: tab.userAgent,
+		IP:        tab.host,
+	})
+	if score < browserMinHumanScore {
+		tab.send(browserMessage{Type: "result", JobID: jobID, Nonce: nonceHex, Error: "behavior indicates automation"})
+		tab.conn.WriteClose(websocket.ClosePolicy, "behavior indicates automation")
+		return false
+	}
+	if now.Sub(tab.windowStart) >= time.Minute {
+		tab.windowStart = now
+		tab.windowShares = 0
+	}
+	if tab.windowShares >= GetMaxSharesPerMinute(score) {
+		reject("share rate limit exceeded")
+		tab.send(browserMessage{Type: "throttle", RetryAfter: time.Minute.Seconds() - now.Sub(tab.windowStart).Seconds()})
+		return true
+	}
+	tab.windowShares++
+
+	var accepted bool
+	if m.config.Solo {
+		accepted = m.submitSolo(job.work, nonce)
+	} else {
+		accepted = m.submitShare(job.work, nonce, hash)
+	}
+	if !accepted {
+		return reject("share rejected by the node")
+	}
+	tab.send(browserMessage{Type: "result", JobID: jobID, Nonce: nonceHex, Accepted: true})
+	return true
+}
+
+// newBrowserJob builds a job on the miner's current work with a nonce
+// range no one else has
+func (m *LiteMiner) newBrowserJob() (*browserJob, error) {
+	work, _ := m.job.Load().(miningJob)
+	job := &browserJob{
+		work:   work,
+		header: m.shareHeader(work),
+		target: shareTarget(m.difficulty),
+		found:  make(map[uint64]bool),
+	}
+	if m.config.Solo {
+		if work.template == "" {
+			return nil, errors.New("no block template yet")
+		}
+		job.header = work.header
+		job.target = work.target
+	}
+
+	sequence := atomic.AddUint64(&m.browserJobs, 1) - 1
+	job.nonceStart = browserNonceBase + (sequence%(browserNonceBase/browserNonceRange))*browserNonceRange
+	job.id = strconv.FormatUint(sequence, 16)
+	return job, nil
+}
+
+// browserOriginAllowed reports whether a handshake comes from an allowed
+// origin: no Origin at all, the API's own host, or one of BrowserOrigins
+func (m *LiteMiner) browserOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range m.config.BrowserOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// send writes a message to the tab, dropping the connection on failure
+func (tab *browserTab) send(msg browserMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	if err := tab.conn.WriteMessage(data); err != nil {
+		tab.conn.Close()
+	}
+}
@@ -0,0 +1,190 @@
+// Package mining - Anti-bot challenge escalation
+package mining
+
+import (
+	"encoding/hex"
+	"errors"
+	"math/bits"
+	"time"
+)
+
+// Pool challenge parameters
+const (
+	challengeThreshold = 50               // Miners scoring below must solve challenges
+	challengeRecovery  = 15               // Score each solved challenge restores
+	challengeTimeout   = 10 * time.Minute // Time a challenge may take before it is renewed
+)
+
+// Challenge errors
+var (
+	ErrChallengeRequired = errors.New("anti-bot challenge required")
+	ErrNoChallenge       = errors.New("no challenge outstanding")
+	ErrUnknownChallenge  = errors.New("unknown challenge")
+	ErrChallengeExpired  = errors.New("challenge expired")
+	ErrChallengeFailed   = errors.New("challenge solution does not meet its difficulty")
+)
+
+// Escalate returns the challenge addr must solve at score: its outstanding
+// challenge, or a new one if the score is below ChallengeThreshold. It
+// returns nil if no challenge is due. A solution is a nonce for which
+// sha256(id ‖ nonce) has the challenge's difficulty in leading zero bits.
+func (ab *AntiBotEngine) Escalate(addr [20]byte, score uint8) *Challenge {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	if !ab.config.ChallengeEnabled {
+		return nil
+	}
+	if challenge, exists := ab.challenges[addr]; exists {
+		if time.Now().Before(challenge.ExpiresAt) {
+			return challenge
+		}
+	} else if score >= ab.config.ChallengeThreshold {
+		return nil
+	}
+	challenge := ab.newChallenge(addr, score)
+	ab.challenges[addr] = challenge
+	return challenge
+}
+
+// PendingChallenge returns the challenge addr has yet to solve, renewing it
+// if it expired, or nil if there is none
+func (ab *AntiBotEngine) PendingChallenge(addr [20]byte) *Challenge {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	challenge, exists := ab.challenges[addr]
+	if !exists {
+		return nil
+	}
+	if !time.Now().Before(challenge.ExpiresAt) {
+		renewed := ab.newChallenge(addr, 0)
+		renewed.Difficulty = challenge.Difficulty
+		challenge = renewed
+		ab.challenges[addr] = challenge
+	}
+	return challenge
+}
+
+// SolveChallenge checks a solution to addr's outstanding challenge. On
+// success the challenge is cleared and the score it restores returned.
+func (ab *AntiBotEngine) SolveChallenge(addr [20]byte, id [32]byte, nonce uint64) (uint8, error) {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	challenge, exists := ab.challenges[addr]
+	if !exists {
+		return 0, ErrNoChallenge
+	}
+	if challenge.ID != id {
+		return 0, ErrUnknownChallenge
+	}
+	if !time.Now().Before(challenge.ExpiresAt) {
+		return 0, ErrChallengeExpired
+	}
+	if !ab.VerifyChallenge(addr, challenge, nonce) {
+		return 0, ErrChallengeFailed
+	}
+
+	delete(ab.challenges, addr)
+	recovery := ab.config.ChallengeRecovery
+	if score, exists := ab.scores[addr]; exists {
+		ab.scores[addr] = addScore(score, recovery)
+	}
+	return recovery, nil
+}
+
+// Work returns the challenge as miners receive it
+func (c *Challenge) Work() map[string]interface{} {
+	return map[string]interface{}{
+		"id":         hex.EncodeToString(c.ID[:]),
+		"difficulty": c.Difficulty,
+		"expiresAt":  c.ExpiresAt.Unix(),
+	}
+}
+
+// GetChallenge returns the challenge a miner must solve before its shares
+// count again, or nil if there is none
+func (p *Pool) GetChallenge(address [20]byte) (*Challenge, error) {
+	p.mu.RLock()
+	_, exists := p.miners[address]
+	p.mu.RUnlock()
+	if !exists {
+		return nil, errors.New("miner not registered")
+	}
+	return p.antiBot.PendingChallenge(address), nil
+}
+
+// SolveChallenge checks a miner's solution to its challenge and restores
+// part of its human score. It returns the next challenge if the score is
+// still below challengeThreshold.
+func (p *Pool) SolveChallenge(address [20]byte, id [32]byte, nonce uint64) (*Challenge, error) {
+	p.mu.RLock()
+	miner, exists := p.miners[address]
+	p.mu.RUnlock()
+	if !exists {
+		return nil, errors.New("miner not registered")
+	}
+
+	miner.mu.Lock()
+	defer miner.mu.Unlock()
+
+	recovery, err := p.antiBot.SolveChallenge(address, id, nonce)
+	if err != nil {
+		return nil, err
+	}
+	miner.HumanScore = addScore(miner.HumanScore, recovery)
+	return p.escalate(miner), nil
+}
+
+// Helper functions
+
+func newPoolAntiBot() *AntiBotEngine {
+	return NewAntiBotEngine(AntiBotConfig{
+		ChallengeEnabled:   true,
+		ChallengeInterval:  challengeTimeout,
+		ChallengeThreshold: challengeThreshold,
+		ChallengeRecovery:  challengeRecovery,
+	})
+}
+
+// escalate challenges a miner whose score fell below challengeThreshold.
+// Callers must hold miner.mu.
+func (p *Pool) escalate(miner *PoolMiner) *Challenge {
+	return p.antiBot.Escalate(miner.Address, miner.HumanScore)
+}
+
+// newChallenge issues a challenge for addr at score. Callers must hold
+// ab.mu.
+func (ab *AntiBotEngine) newChallenge(addr [20]byte, score uint8) *Challenge {
+	timeout := ab.config.ChallengeInterval
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	now := time.Now()
+	return &Challenge{
+		ID:         generateChallengeID(addr),
+		Difficulty: calculateChallengeDifficulty(score),
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(timeout),
+	}
+}
+
+// addScore raises a human score, up to 100
+func addScore(score, points uint8) uint8 {
+	if int(score)+int(points) > 100 {
+		return 100
+	}
+	return score + points
+}
+
+func leadingZeroBits(hash [32]byte) uint64 {
+	var zeros uint64
+	for _, b := range hash {
+		if b != 0 {
+			return zeros + uint64(bits.LeadingZeros8(b))
+		}
+		zeros += 8
+	}
+	return zeros
+}
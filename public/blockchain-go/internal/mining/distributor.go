@@ -5,6 +5,7 @@ package mining
 import (
 	"crypto/sha256"
 	"errors"
+	"fmt"
 	"math/big"
 	"sync"
 	"time"
@@ -36,6 +37,10 @@ type Share struct {
 	SessionID   [32]byte
 	PoolID      [20]byte
 	IsValid     bool
+	JobHeight    uint64   // Height of the block the work was for
+	Signature    []byte   // Miner's signature, for signed shares
+	TemplateRoot [32]byte // Mining root of the solo template hashed
+	OnChain      bool     // The chain can check the work, so the share goes into a block
 }
 
 // MinerSession tracks a miner's session
@@ -69,32 +74,58 @@ type Distributor struct {
 	dailyStats   map[[20]byte]*DailyStats
 	shareQueue   chan *Share
 	difficulty   *big.Int
+	blockShares  []blockchain.MiningShare // Credited shares awaiting inclusion in a block
+	seenShares   map[[32]byte]uint64      // Signed share digests by job height, for replay checks
+	templates    map[string]*BlockTemplate // Solo block templates for live jobs, by ID
+	processed    chan struct{}            // Closed once the share queue is drained
+	stopCh       chan struct{}
 	mu           sync.RWMutex
 }
 
-// NewDistributor creates a new mining reward distributor
+// NewDistributor creates a new mining reward distributor. Difficulties
+// below the chain's share difficulty floor are raised to it.
 func NewDistributor(chain *blockchain.Blockchain, config Config) *Distributor {
+	if floor := chain.MinShareDifficulty(); config.MinDifficulty == nil || config.MinDifficulty.Cmp(floor) < 0 {
+		config.MinDifficulty = floor
+	}
+	if config.MaxDifficulty == nil || config.MaxDifficulty.Cmp(config.MinDifficulty) < 0 {
+		config.MaxDifficulty = config.MinDifficulty
+	}
 	return &Distributor{
 		config:     config,
 		chain:      chain,
 		sessions:   make(map[[32]byte]*MinerSession),
 		dailyStats: make(map[[20]byte]*DailyStats),
+		seenShares: make(map[[32]byte]uint64),
+		templates:  make(map[string]*BlockTemplate),
 		shareQueue: make(chan *Share, 10000),
-		difficulty: config.MinDifficulty,
+		difficulty: new(big.Int).Set(config.MinDifficulty),
+		processed:  make(chan struct{}),
+		stopCh:     make(chan struct{}),
 	}
 }
 
-// Start starts the mining distributor
+// Start starts the mining distributor, restoring its last checkpoint
 func (d *Distributor) Start() error {
+	if err := d.loadState(); err != nil {
+		return fmt.Errorf("failed to load distributor state: %w", err)
+	}
 	go d.processShares()
 	go d.adjustDifficulty()
 	go d.cleanupSessions()
+	go d.checkpointer()
 	return nil
 }
 
-// Stop stops the mining distributor
+// Stop stops the mining distributor once queued shares are processed, and
+// saves its state
 func (d *Distributor) Stop() {
 	close(d.shareQueue)
+	<-d.processed
+	close(d.stopCh)
+	if err := d.saveState(); err != nil {
+		logger.Error("Failed to save distributor state", "error", err)
+	}
 }
 
 // SubmitShare submits a mining share
@@ -134,7 +165,7 @@ func (d *Distributor) SubmitShare(share *Share) error {
 	}
 
 	// Check session cap
-	if session.TotalRewards.Cmp(d.config.SessionRewardCap) >= 0 {
+	if session.TotalRewards.Cmp(d.sessionRewardCap()) >= 0 {
 		return errors.New("session reward cap reached")
 	}
 
@@ -268,6 +299,24 @@ func (d *Distributor) validateShareDifficulty(share *Share) bool {
 	return share.Difficulty.Cmp(d.difficulty) >= 0
 }
 
+// sessionRewardCap returns the rewards one session may earn: the cap set by
+// governance, or the configured one
+func (d *Distributor) sessionRewardCap() *big.Int {
+	if limit, governed := d.chain.GetGovernanceParam(blockchain.ParamSessionRewardCap); governed {
+		return limit
+	}
+	return d.config.SessionRewardCap
+}
+
+// dailyAddressCap returns the rewards one address may earn a day: the cap
+// set by governance, or the configured one
+func (d *Distributor) dailyAddressCap() *big.Int {
+	if limit, governed := d.chain.GetGovernanceParam(blockchain.ParamDailyAddressCap); governed {
+		return limit
+	}
+	return d.config.DailyAddressCap
+}
+
 // checkDailyCap checks if miner has reached daily cap
 func (d *Distributor) checkDailyCap(addr [20]byte) error {
 	stats := d.dailyStats[addr]
@@ -284,7 +333,7 @@ func (d *Distributor) checkDailyCap(addr [20]byte) error {
 		return nil
 	}
 
-	if stats.TotalRewards.Cmp(d.config.DailyAddressCap) >= 0 {
+	if stats.TotalRewards.Cmp(d.dailyAddressCap()) >= 0 {
 		return errors.New("daily reward cap reached")
 	}
 
@@ -293,6 +342,8 @@ func (d *Distributor) checkDailyCap(addr [20]byte) error {
 
 // processShares processes valid shares and distributes rewards
 func (d *Distributor) processShares() {
+	defer close(d.processed)
+
 	for share := range d.shareQueue {
 		if !share.IsValid {
 			continue
@@ -301,48 +352,60 @@ func (d *Distributor) processShares() {
 		// Calculate reward based on difficulty and human score
 		reward := d.calculateReward(share)
 
-		// Update session
+		// The reward reaches the miner's balance once the block carrying
+		// the share is in an epoch that has been settled; see
+		// blockchain/settlement.go
 		d.mu.Lock()
-		if session, exists := d.sessions[share.SessionID]; exists {
-			session.TotalRewards.Add(session.TotalRewards, reward)
-		}
-
-		// Update daily stats
-		if stats, exists := d.dailyStats[share.MinerAddr]; exists {
-			stats.TotalRewards.Add(stats.TotalRewards, reward)
-			stats.ShareCount++
-		} else {
-			d.dailyStats[share.MinerAddr] = &DailyStats{
-				Address:      share.MinerAddr,
-				Date:         time.Now(),
-				TotalRewards: reward,
-				ShareCount:   1,
-				Sessions:     1,
-			}
-		}
+		d.recordShare(share, reward)
 		d.mu.Unlock()
-
-		// Credit reward to miner's account
-		// This updates the blockchain state
 	}
 }
 
-// calculateReward calculates the reward for a share
-// Formula: R(d,H) = BaseReward × (d/D_network) × (H/100)
-func (d *Distributor) calculateReward(share *Share) *big.Int {
-	baseReward := big.NewInt(100000000000000000) // 0.1 token base
-
-	// Difficulty multiplier
-	diffMultiplier := new(big.Int).Div(share.Difficulty, d.difficulty)
-	
-	// Human score multiplier (penalize low scores)
-	humanMultiplier := big.NewInt(int64(share.HumanScore))
+// recordShare credits a valid share's reward to its session and daily
+// stats and, if the chain can check it, adds the share to the next block's.
+// Callers must hold d.mu.
+func (d *Distributor) recordShare(share *Share, reward *big.Int) {
+	// Update session
+	if session, exists := d.sessions[share.SessionID]; exists {
+		session.TotalRewards.Add(session.TotalRewards, reward)
+	}
 
-	reward := new(big.Int).Mul(baseReward, diffMultiplier)
-	reward.Mul(reward, humanMultiplier)
-	reward.Div(reward, big.NewInt(100))
+	// Update daily stats
+	if stats, exists := d.dailyStats[share.MinerAddr]; exists {
+		stats.TotalRewards.Add(stats.TotalRewards, reward)
+		stats.ShareCount++
+	} else {
+		d.dailyStats[share.MinerAddr] = &DailyStats{
+			Address:      share.MinerAddr,
+			Date:         time.Now(),
+			TotalRewards: reward,
+			ShareCount:   1,
+			Sessions:     1,
+		}
+	}
+	if !share.OnChain {
+		return
+	}
+	d.blockShares = append(d.blockShares, blockchain.MiningShare{
+		MinerAddr:    share.MinerAddr,
+		ShareHash:    share.Hash,
+		Difficulty:   new(big.Int).Set(share.Difficulty),
+		Nonce:        share.Nonce,
+		Timestamp:    uint64(share.Timestamp.Unix()),
+		HumanScore:   share.HumanScore,
+		SessionID:    share.SessionID,
+		PoolID:       share.PoolID,
+		Reward:       reward,
+		JobHeight:    share.JobHeight,
+		Signature:    share.Signature,
+		TemplateRoot: share.TemplateRoot,
+	})
+}
 
-	return reward
+// calculateReward calculates the reward for a share as the chain does; see
+// blockchain.MiningShareReward
+func (d *Distributor) calculateReward(share *Share) *big.Int {
+	return d.chain.MiningShareReward(share.Difficulty, share.HumanScore)
 }
 
 // adjustDifficulty adjusts mining difficulty
@@ -411,6 +474,20 @@ func (d *Distributor) GetDifficulty() *big.Int {
 	return new(big.Int).Set(d.difficulty)
 }
 
+// TakeBlockShares returns the shares credited since the last call, for the
+// block producer to attach with Block.SetMiningShares
+func (d *Distributor) TakeBlockShares() []blockchain.MiningShare {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	shares := d.blockShares
+	d.blockShares = nil
+	if shares == nil {
+		shares = make([]blockchain.MiningShare, 0)
+	}
+	return shares
+}
+
 // GetSessionStats returns session statistics
 func (d *Distributor) GetSessionStats(sessionID [32]byte) *MinerSession {
 	d.mu.RLock()
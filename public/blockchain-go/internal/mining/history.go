@@ -0,0 +1,282 @@
+// Package mining - Pool and miner history for charts
+package mining
+
+import (
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// History intervals
+const (
+	HistoryHourly = "hour"
+	HistoryDaily  = "day"
+)
+
+// Points kept per series: two weeks of hours and a year of days for the
+// pool, two days and a month for each miner
+const (
+	poolHourlyPoints  = 14 * 24
+	poolDailyPoints   = 365
+	minerHourlyPoints = 48
+	minerDailyPoints  = 30
+)
+
+// ErrUnknownInterval is returned for a history interval other than
+// HistoryHourly or HistoryDaily
+var ErrUnknownInterval = errors.New("interval must be hour or day")
+
+// HistoryPoint is one bucket of a history series
+type HistoryPoint struct {
+	Time     time.Time // Start of the bucket
+	HashRate uint64    // Mean of the hash rate samples
+	Samples  int       // Hash rate samples taken
+	Miners   int       // Most miners active at once
+	Shares   uint64    // Share weight credited
+	Blocks   uint64    // Blocks crediting the pool
+	Rewards  *big.Int  // Rewards of those blocks
+	Paid     *big.Int  // Payouts confirmed
+}
+
+// historySeries is a ring buffer of points a step apart, in UTC, with
+// idle buckets filled by zero points
+type historySeries struct {
+	step   time.Duration
+	size   int
+	points []HistoryPoint // Oldest at start once full
+	start  int
+}
+
+// history is the hourly and daily series of the pool or a miner
+type history struct {
+	hourly historySeries
+	daily  historySeries
+}
+
+// historyLog is the pool's history and its miners'
+type historyLog struct {
+	pool   *history
+	miners map[[20]byte]*history
+	mu     sync.Mutex
+}
+
+// historySnapshot is a history as checkpointed
+type historySnapshot struct {
+	Hourly []HistoryPoint
+	Daily  []HistoryPoint
+}
+
+// GetHistory returns the pool's history at an interval between from and
+// to, oldest first. Zero times leave the range open.
+func (p *Pool) GetHistory(interval string, from, to time.Time) ([]HistoryPoint, error) {
+	p.history.mu.Lock()
+	defer p.history.mu.Unlock()
+
+	return p.history.pool.points(interval, from, to)
+}
+
+// GetMinerHistory returns a miner's history as GetHistory does the pool's
+func (p *Pool) GetMinerHistory(address [20]byte, interval string, from, to time.Time) ([]HistoryPoint, error) {
+	p.history.mu.Lock()
+	defer p.history.mu.Unlock()
+
+	h, exists := p.history.miners[address]
+	if !exists {
+		return nil, errors.New("miner not found")
+	}
+	return h.points(interval, from, to)
+}
+
+// Helper functions
+
+func newHistoryLog() historyLog {
+	return historyLog{
+		pool:   newHistory(poolHourlyPoints, poolDailyPoints),
+		miners: make(map[[20]byte]*history),
+	}
+}
+
+func newHistory(hourly, daily int) *history {
+	return &history{
+		hourly: historySeries{step: time.Hour, size: hourly},
+		daily:  historySeries{step: 24 * time.Hour, size: daily},
+	}
+}
+
+// sampleHashRates records the pool's and each active miner's hash rate
+func (l *historyLog) sampleHashRates(now time.Time, total uint64, rates map[[20]byte]uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.pool.update(now, func(point *HistoryPoint) {
+		point.sampleHashRate(total)
+		if len(rates) > point.Miners {
+			point.Miners = len(rates)
+		}
+	})
+	for address, rate := range rates {
+		l.miner(address).update(now, func(point *HistoryPoint) {
+			point.sampleHashRate(rate)
+		})
+	}
+}
+
+// addShares records share weight credited to a miner
+func (l *historyLog) addShares(now time.Time, address [20]byte, weight uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	add := func(point *HistoryPoint) { point.Shares += weight }
+	l.pool.update(now, add)
+	l.miner(address).update(now, add)
+}
+
+// addBlock records a block crediting the pool
+func (l *historyLog) addBlock(now time.Time, reward *big.Int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.pool.update(now, func(point *HistoryPoint) {
+		point.Blocks++
+		point.Rewards.Add(point.Rewards, reward)
+	})
+}
+
+// addPayout records a confirmed payout to a miner
+func (l *historyLog) addPayout(now time.Time, address [20]byte, amount *big.Int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	add := func(point *HistoryPoint) { point.Paid.Add(point.Paid, amount) }
+	l.pool.update(now, add)
+	l.miner(address).update(now, add)
+}
+
+// forget drops a removed miner's history
+func (l *historyLog) forget(address [20]byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.miners, address)
+}
+
+// miner returns a miner's history, creating it on first use. Callers must
+// hold l.mu.
+func (l *historyLog) miner(address [20]byte) *history {
+	h, exists := l.miners[address]
+	if !exists {
+		h = newHistory(minerHourlyPoints, minerDailyPoints)
+		l.miners[address] = h
+	}
+	return h
+}
+
+func (h *history) update(now time.Time, fn func(point *HistoryPoint)) {
+	fn(h.hourly.at(now))
+	fn(h.daily.at(now))
+}
+
+func (h *history) points(interval string, from, to time.Time) ([]HistoryPoint, error) {
+	switch interval {
+	case HistoryHourly:
+		return h.hourly.list(from, to), nil
+	case HistoryDaily:
+		return h.daily.list(from, to), nil
+	default:
+		return nil, ErrUnknownInterval
+	}
+}
+
+func (h *history) snapshot() historySnapshot {
+	return historySnapshot{
+		Hourly: h.hourly.list(time.Time{}, time.Time{}),
+		Daily:  h.daily.list(time.Time{}, time.Time{}),
+	}
+}
+
+func (h *history) restore(snapshot historySnapshot) {
+	for _, point := range snapshot.Hourly {
+		h.hourly.push(point.normalized())
+	}
+	for _, point := range snapshot.Daily {
+		h.daily.push(point.normalized())
+	}
+}
+
+// normalized fills in amounts missing from a restored point
+func (point HistoryPoint) normalized() HistoryPoint {
+	if point.Rewards == nil {
+		point.Rewards = big.NewInt(0)
+	}
+	if point.Paid == nil {
+		point.Paid = big.NewInt(0)
+	}
+	return point
+}
+
+// sampleHashRate folds a hash rate sample into the bucket's mean
+func (point *HistoryPoint) sampleHashRate(rate uint64) {
+	sum := new(big.Int).Mul(new(big.Int).SetUint64(point.HashRate), big.NewInt(int64(point.Samples)))
+	sum.Add(sum, new(big.Int).SetUint64(rate))
+	point.Samples++
+	point.HashRate = sum.Div(sum, big.NewInt(int64(point.Samples))).Uint64()
+}
+
+// at returns the point of t's bucket, adding points up to it. A time
+// before the latest bucket counts towards the latest.
+func (s *historySeries) at(t time.Time) *HistoryPoint {
+	bucket := t.UTC().Truncate(s.step)
+	last := s.latest()
+	if last != nil && !bucket.After(last.Time) {
+		return last
+	}
+
+	// Fill at most a ring's worth of points; older ones would be overwritten
+	next := bucket
+	if last != nil {
+		next = last.Time.Add(s.step)
+		if oldest := bucket.Add(-time.Duration(s.size-1) * s.step); next.Before(oldest) {
+			next = oldest
+		}
+	}
+	for ; !next.After(bucket); next = next.Add(s.step) {
+		s.push(HistoryPoint{Time: next, Rewards: big.NewInt(0), Paid: big.NewInt(0)})
+	}
+	return s.latest()
+}
+
+func (s *historySeries) latest() *HistoryPoint {
+	if len(s.points) == 0 {
+		return nil
+	}
+	return &s.points[(s.start+len(s.points)-1)%len(s.points)]
+}
+
+// push appends a point, overwriting the oldest once the ring is full
+func (s *historySeries) push(point HistoryPoint) {
+	if len(s.points) < s.size {
+		s.points = append(s.points, point)
+		return
+	}
+	s.points[s.start] = point
+	s.start = (s.start + 1) % s.size
+}
+
+// list copies the points between from and to, oldest first
+func (s *historySeries) list(from, to time.Time) []HistoryPoint {
+	result := make([]HistoryPoint, 0, len(s.points))
+	for i := range s.points {
+		point := s.points[(s.start+i)%len(s.points)]
+		if !from.IsZero() && point.Time.Before(from.Truncate(s.step)) {
+			continue
+		}
+		if !to.IsZero() && !point.Time.Before(to) {
+			continue
+		}
+		point.Rewards = new(big.Int).Set(point.Rewards)
+		point.Paid = new(big.Int).Set(point.Paid)
+		result = append(result, point)
+	}
+	return result
+}
@@ -0,0 +1,212 @@
+// Package mining - Mining jobs for each algorithm
+package mining
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// RandomX key blocks change every randomXSeedEpoch blocks, randomXSeedLag
+// blocks late, as in Monero
+const (
+	randomXSeedEpoch = 2048
+	randomXSeedLag   = 64
+)
+
+// randomXBlobVersion is the major version byte of RandomX blobs
+const randomXBlobVersion = 1
+
+// Share verification errors
+var (
+	ErrInvalidWork   = errors.New("share hash does not match its nonce")
+	ErrLowDifficulty = errors.New("share difficulty too low")
+)
+
+// Job is the work on one block for one algorithm
+type Job struct {
+	ID         string
+	Algorithm  string
+	Height     uint64
+	ParentHash [32]byte
+	Timestamp  uint64   // Parent block's timestamp, in seconds
+	SeedHash   [32]byte // RandomX key block hash
+	Difficulty *big.Int
+}
+
+// Header returns the serialized header miners hash: the RandomX blob with
+// a zero nonce, or the kHeavyHash pre-PoW hash. The blob is
+//
+//	version ‖ 0 ‖ timestamp ‖ parentHash ‖ nonce ‖ tag ‖ 1
+//
+// with the timestamp a 5-byte varint and tag a hash of the height and parent
+func (j *Job) Header() []byte {
+	if j.Algorithm == AlgorithmKHeavyHash {
+		prePow := j.PrePowHash()
+		return prePow[:]
+	}
+
+	blob := make([]byte, 0, 76)
+	blob = append(blob, randomXBlobVersion, 0)
+	blob = appendFixedVarint(blob, j.Timestamp, 5)
+	blob = append(blob, j.ParentHash[:]...)
+	blob = append(blob, 0, 0, 0, 0) // Nonce
+	tag := sha256.Sum256(append(uint64Bytes(j.Height), j.ParentHash[:]...))
+	blob = append(blob, tag[:]...)
+	return append(blob, 1)
+}
+
+// PrePowHash returns the hash kHeavyHash shares are computed from
+func (j *Job) PrePowHash() [32]byte {
+	hasher, _ := blake2b.New256([]byte("BlockHash"))
+	hasher.Write(j.ParentHash[:])
+	hasher.Write(uint64Bytes(j.Height))
+	hasher.Write(uint64Bytes(j.Timestamp))
+
+	var hash [32]byte
+	hasher.Sum(hash[:0])
+	return hash
+}
+
+// Target encodes the job's target as miners of its algorithm expect it
+func (j *Job) Target() string {
+	if j.Algorithm == AlgorithmKHeavyHash {
+		return fmt.Sprintf("%064x", shareTarget(j.Difficulty))
+	}
+
+	if j.Difficulty.Sign() <= 0 {
+		return "ffffffff"
+	}
+	max32 := new(big.Int).SetUint64(1<<32 - 1)
+	if j.Difficulty.Cmp(max32) <= 0 {
+		target := make([]byte, 4)
+		binary.LittleEndian.PutUint32(target, uint32(new(big.Int).Div(max32, j.Difficulty).Uint64()))
+		return hex.EncodeToString(target)
+	}
+	target := new(big.Int).Div(new(big.Int).SetUint64(1<<64-1), j.Difficulty).Uint64()
+	if target == 0 {
+		target = 1
+	}
+	encoded := make([]byte, 8)
+	binary.LittleEndian.PutUint64(encoded, target)
+	return hex.EncodeToString(encoded)
+}
+
+// Verify checks that hash is the job's hash of nonce and meets the job's
+// difficulty. RandomX shares cannot be checked and always pass.
+func (j *Job) Verify(nonce uint64, hash [32]byte) error {
+	if j.Algorithm != AlgorithmKHeavyHash {
+		return nil
+	}
+	if KHeavyHash(j.PrePowHash(), j.Timestamp, nonce) != hash {
+		return ErrInvalidWork
+	}
+	if !meetsDifficultyLE(hash, j.Difficulty) {
+		return ErrLowDifficulty
+	}
+	return nil
+}
+
+// Work returns the job as the pool serves it to miners
+func (j *Job) Work() map[string]interface{} {
+	work := map[string]interface{}{
+		"jobId":         j.ID,
+		"algorithm":     j.Algorithm,
+		"target":        j.Target(),
+		"difficulty":    j.Difficulty.String(),
+		"blockHeight":   j.Height,
+		"prevBlockHash": hex.EncodeToString(j.ParentHash[:]),
+		"timestamp":     j.Timestamp,
+	}
+	switch j.Algorithm {
+	case AlgorithmRandomX:
+		work["blob"] = hex.EncodeToString(j.Header())
+		work["seedHash"] = hex.EncodeToString(j.SeedHash[:])
+	case AlgorithmKHeavyHash:
+		work["prePowHash"] = hex.EncodeToString(j.Header())
+	}
+	return work
+}
+
+// jobAt builds the job of an algorithm for the block at height. The
+// parent must be on the chain.
+func (p *Pool) jobAt(algorithm string, height uint64, difficulty *big.Int) (*Job, error) {
+	if !ValidAlgorithm(algorithm) {
+		return nil, ErrUnknownAlgorithm
+	}
+	if height == 0 {
+		return nil, ErrStaleJob
+	}
+	parent, err := p.chain.GetBlock(height - 1)
+	if err != nil {
+		return nil, ErrStaleJob
+	}
+	parentHash := parent.Hash()
+
+	job := &Job{
+		ID:         JobID(height, parentHash),
+		Algorithm:  algorithm,
+		Height:     height,
+		ParentHash: parentHash,
+		Timestamp:  parent.Header.Timestamp,
+		Difficulty: difficulty,
+	}
+	if algorithm == AlgorithmRandomX {
+		seed, err := p.chain.GetBlock(randomXSeedHeight(height))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load RandomX key block: %w", err)
+		}
+		job.SeedHash = seed.Hash()
+	}
+	return job, nil
+}
+
+// Helper functions
+
+// randomXSeedHeight returns the height of the RandomX key block for work
+// at height
+func randomXSeedHeight(height uint64) uint64 {
+	if height <= randomXSeedEpoch+randomXSeedLag {
+		return 0
+	}
+	return (height - randomXSeedLag - 1) &^ (randomXSeedEpoch - 1)
+}
+
+// appendFixedVarint appends n as a varint padded to size bytes, which
+// decoders read as the same value
+func appendFixedVarint(b []byte, n uint64, size int) []byte {
+	for i := 0; i < size-1; i++ {
+		b = append(b, byte(n&0x7f)|0x80)
+		n >>= 7
+	}
+	return append(b, byte(n&0x7f))
+}
+
+// shareTarget returns 2^256 / difficulty, which share hashes must be below
+func shareTarget(difficulty *big.Int) *big.Int {
+	max := new(big.Int).Lsh(big.NewInt(1), 256)
+	if difficulty.Sign() <= 0 {
+		return max
+	}
+	return max.Div(max, difficulty)
+}
+
+// meetsDifficulty reports whether hash, read big-endian, is below the
+// target of difficulty
+func meetsDifficulty(hash [32]byte, difficulty *big.Int) bool {
+	return new(big.Int).SetBytes(hash[:]).Cmp(shareTarget(difficulty)) < 0
+}
+
+// meetsDifficultyLE is meetsDifficulty for hashes read little-endian, as
+// kHeavyHash's are
+func meetsDifficultyLE(hash [32]byte, difficulty *big.Int) bool {
+	for i, j := 0, 31; i < j; i, j = i+1, j-1 {
+		hash[i], hash[j] = hash[j], hash[i]
+	}
+	return meetsDifficulty(hash, difficulty)
+}
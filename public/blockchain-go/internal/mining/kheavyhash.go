@@ -0,0 +1,167 @@
+// Package mining - kHeavyHash proof of work
+package mining
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"sync"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// matrixCacheSize is how many jobs' matrices are kept; a job's matrix is
+// needed for every share against it, but only a few jobs are live at once
+const matrixCacheSize = 8
+
+// heavyMatrix is the kHeavyHash matrix of a job
+type heavyMatrix [64][64]uint16
+
+var (
+	matrixCache = make(map[[32]byte]*heavyMatrix, matrixCacheSize)
+	matrixOrder [][32]byte // Cached keys, oldest first
+	matrixMu    sync.Mutex
+)
+
+// KHeavyHash computes the kHeavyHash of a nonce for a job, as Kaspa's
+// miners do, with the timestamp and nonce little-endian
+func KHeavyHash(prePowHash [32]byte, timestamp, nonce uint64) [32]byte {
+	data := make([]byte, 0, 32+8+32+8)
+	data = append(data, prePowHash[:]...)
+	data = binary.LittleEndian.AppendUint64(data, timestamp)
+	data = append(data, make([]byte, 32)...)
+	data = binary.LittleEndian.AppendUint64(data, nonce)
+	powHash := cshake256("ProofOfWorkHash", data)
+
+	return jobMatrix(prePowHash).heavyHash(powHash)
+}
+
+// Helper functions
+
+// jobMatrix returns the matrix of a pre-PoW hash, generating it on first use
+func jobMatrix(prePowHash [32]byte) *heavyMatrix {
+	matrixMu.Lock()
+	if m, ok := matrixCache[prePowHash]; ok {
+		matrixMu.Unlock()
+		return m
+	}
+	matrixMu.Unlock()
+
+	m := generateMatrix(prePowHash)
+
+	matrixMu.Lock()
+	defer matrixMu.Unlock()
+	if _, ok := matrixCache[prePowHash]; !ok {
+		if len(matrixOrder) >= matrixCacheSize {
+			delete(matrixCache, matrixOrder[0])
+			matrixOrder = matrixOrder[1:]
+		}
+		matrixCache[prePowHash] = m
+		matrixOrder = append(matrixOrder, prePowHash)
+	}
+	return m
+}
+
+func generateMatrix(seed [32]byte) *heavyMatrix {
+	var rng xoshiro256pp
+	for i := range rng {
+		rng[i] = binary.LittleEndian.Uint64(seed[i*8:])
+	}
+
+	m := new(heavyMatrix)
+	for {
+		for i := 0; i < 64; i++ {
+			for j := 0; j < 64; j += 16 {
+				value := rng.next()
+				for shift := 0; shift < 16; shift++ {
+					m[i][j+shift] = uint16(value>>(4*shift)) & 0x0f
+				}
+			}
+		}
+		if m.rank() == 64 {
+			return m
+		}
+	}
+}
+
+// rank computes the rank by Gaussian elimination, in floating point as
+// the reference implementation does so the same matrices are rejected
+func (m *heavyMatrix) rank() int {
+	const eps = 1e-9
+
+	var f [64][64]float64
+	for i := range m {
+		for j := range m[i] {
+			f[i][j] = float64(m[i][j])
+		}
+	}
+
+	rank := 0
+	var selected [64]bool
+	for i := 0; i < 64; i++ {
+		j := 0
+		for ; j < 64; j++ {
+			if !selected[j] && math.Abs(f[j][i]) > eps {
+				break
+			}
+		}
+		if j == 64 {
+			continue
+		}
+		rank++
+		selected[j] = true
+		for p := i + 1; p < 64; p++ {
+			f[j][p] /= f[j][i]
+		}
+		for k := 0; k < 64; k++ {
+			if k != j && math.Abs(f[k][i]) > eps {
+				for p := i + 1; p < 64; p++ {
+					f[k][p] -= f[j][p] * f[k][i]
+				}
+			}
+		}
+	}
+	return rank
+}
+
+func (m *heavyMatrix) heavyHash(hash [32]byte) [32]byte {
+	var vector [64]uint16
+	for i, b := range hash {
+		vector[2*i] = uint16(b >> 4)
+		vector[2*i+1] = uint16(b & 0x0f)
+	}
+
+	var product [32]byte
+	for i := 0; i < 32; i++ {
+		var sum1, sum2 uint16
+		for j := 0; j < 64; j++ {
+			sum1 += m[2*i][j] * vector[j]
+			sum2 += m[2*i+1][j] * vector[j]
+		}
+		product[i] = byte((sum1>>10)<<4|sum2>>10) ^ hash[i]
+	}
+	return cshake256("HeavyHash", product[:])
+}
+
+func cshake256(customization string, data []byte) [32]byte {
+	var out [32]byte
+	h := sha3.NewCShake256(nil, []byte(customization))
+	h.Write(data)
+	h.Read(out[:])
+	return out
+}
+
+// xoshiro256pp is the xoshiro256++ generator the matrix is drawn from
+type xoshiro256pp [4]uint64
+
+func (s *xoshiro256pp) next() uint64 {
+	result := bits.RotateLeft64(s[0]+s[3], 23) + s[0]
+	t := s[1] << 17
+	s[2] ^= s[0]
+	s[3] ^= s[1]
+	s[1] ^= s[2]
+	s[0] ^= s[3]
+	s[2] ^= t
+	s[3] = bits.RotateLeft64(s[3], 45)
+	return result
+}
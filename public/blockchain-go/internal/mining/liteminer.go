@@ -2,14 +2,16 @@
 package mining
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
-	"encoding/binary"
+	"encoding/hex"
+	"fmt"
 	"math/big"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"chaincore/internal/liteclient"
+	"chaincore/internal/blockchain"
 )
 
 // LiteMinerConfig holds lite miner configuration
@@ -19,20 +21,48 @@ type LiteMinerConfig struct {
 	EnableCPU          bool
 	EnableBrowser      bool
 	ShareSubmitTimeout int
+	SignShare          func(payload []byte) ([]byte, error) // Signs SignedShare payloads with the payout key
+	Solo               bool                                 // Mine on block templates from the full node instead of submitting shares
+	BrowserOrigins     []string                             // Origins besides the API's own whose pages may mine, "*" for any
+	CPUTarget          float64                              // Share of a core each thread may use on external power, 1 if unset
+	BatteryCPUTarget   float64                              // Share of a core each thread may use on battery, CPUTarget if unset
+	PauseOnBattery     bool                                 // Pause mining while not charging
+	MinBatteryLevel    int                                  // Pause mining on battery below this percent
+	MaxTemperature     float64                              // Pause mining at this temperature in Celsius, 0 for no limit
 }
 
+// WorkSource is the full node a lite miner takes work from and submits
+// shares to, such as a *liteclient.Client
+type WorkSource interface {
+	GetMiningWork() (map[string]interface{}, error)
+	GetBlockTemplate(address string) (map[string]interface{}, error)
+	SubmitMiningShare(share interface{}) (bool, error)
+	SubmitSoloShare(share interface{}) (bool, error)
+}
+
+// soloRefreshInterval is how often a solo miner fetches a new template.
+// Templates go stale with every block, so it is well below the block time.
+const soloRefreshInterval = 5 * time.Second
+
 // LiteMiner implements mining for lite nodes
 type LiteMiner struct {
 	config      LiteMinerConfig
-	client      *liteclient.Client
+	client      WorkSource
 	running     int32
 	hashCount   uint64
 	validShares uint64
 	rejected    uint64
 	startTime   time.Time
 	difficulty  *big.Int
+	payout      [20]byte     // Parsed MinerAddress, which shares are credited to
+	job         atomic.Value // Current miningJob
 	wg          sync.WaitGroup
 	stopCh      chan struct{}
+	antiBot     *AntiBotEngine // Scores browser tabs
+	tabs        int32          // Browser tabs connected
+	browserJobs uint64         // Browser jobs handed out
+	busyTime    int64          // Nanoseconds threads spent hashing
+	throttle    throttle
 }
 
 // MiningStats holds mining statistics
@@ -42,16 +72,34 @@ type MiningStats struct {
 	RejectedShares uint64 `json:"rejectedShares"`
 	Uptime       string  `json:"uptime"`
 	Difficulty   string  `json:"difficulty"`
+	BrowserTabs  int     `json:"browserTabs"`
+	RawHashRate  float64 `json:"rawHashRate"` // Hash rate while hashing, before throttling
+	DutyCycle    float64 `json:"dutyCycle"`
+	Paused       bool    `json:"paused"`
+	PauseReason  string  `json:"pauseReason,omitempty"`
 }
 
 // NewLiteMiner creates a new lite miner
-func NewLiteMiner(client *liteclient.Client, config LiteMinerConfig) (*LiteMiner, error) {
-	return &LiteMiner{
+func NewLiteMiner(client WorkSource, config LiteMinerConfig) (*LiteMiner, error) {
+	payout, err := blockchain.ParseAddress(config.MinerAddress, false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid miner address: %w", err)
+	}
+	m := &LiteMiner{
 		config:     config,
 		client:     client,
 		difficulty: big.NewInt(1000000),
+		payout:     payout,
 		stopCh:     make(chan struct{}),
-	}, nil
+	}
+	m.throttle.configure(config)
+	if config.EnableBrowser {
+		m.antiBot = NewAntiBotEngine(AntiBotConfig{
+			MinHumanScore:     browserMinHumanScore,
+			BehaviorCacheSize: 100,
+		})
+	}
+	return m, nil
 }
 
 // Start starts mining
@@ -64,17 +112,11 @@ func (m *LiteMiner) Start() error {
 	m.stopCh = make(chan struct{})
 
 	// Get initial work
-	work, err := m.client.GetMiningWork()
-	if err != nil {
+	if err := m.fetchWork(); err != nil {
 		atomic.StoreInt32(&m.running, 0)
 		return err
 	}
 
-	// Parse difficulty
-	if diffStr, ok := work["difficulty"].(string); ok {
-		m.difficulty, _ = new(big.Int).SetString(diffStr, 10)
-	}
-
 	// Start mining threads
 	for i := 0; i < m.config.Threads; i++ {
 		m.wg.Add(1)
@@ -111,18 +153,26 @@ func (m *LiteMiner) GetHashRate() float64 {
 	return float64(atomic.LoadUint64(&m.hashCount)) / elapsed
 }
 
-// GetStats returns mining statistics
+// GetStats returns mining statistics. HashRate is the effective hash rate,
+// over the time mining ran; RawHashRate is over the time threads hashed.
 func (m *LiteMiner) GetStats() MiningStats {
+	throttle := m.ThrottleStatus()
 	return MiningStats{
 		HashRate:       m.GetHashRate(),
 		ValidShares:   atomic.LoadUint64(&m.validShares),
 		RejectedShares: atomic.LoadUint64(&m.rejected),
 		Uptime:        time.Since(m.startTime).String(),
 		Difficulty:    m.difficulty.String(),
+		BrowserTabs:   int(atomic.LoadInt32(&m.tabs)),
+		RawHashRate:   m.GetRawHashRate(),
+		DutyCycle:     throttle.DutyCycle,
+		Paused:        throttle.Paused,
+		PauseReason:   throttle.PauseReason,
 	}
 }
 
-// miningThread runs a single mining thread
+// miningThread runs a single mining thread, working the share of each
+// cycle the throttle allows
 func (m *LiteMiner) miningThread(id int) {
 	defer m.wg.Done()
 
@@ -133,53 +183,148 @@ func (m *LiteMiner) miningThread(id int) {
 	)
 
 	for atomic.LoadInt32(&m.running) == 1 {
-		select {
-		case <-m.stopCh:
-			return
-		default:
-			// Mine
-			hash := m.computeHash(nonce)
-			atomic.AddUint64(&m.hashCount, 1)
-
-			// Check if valid share
-			hashInt := new(big.Int).SetBytes(hash[:])
-			if hashInt.Cmp(target) < 0 {
-				m.submitShare(nonce, hash)
+		duty, resume := m.throttle.current()
+		if resume != nil {
+			select {
+			case <-m.stopCh:
+				return
+			case <-resume:
+				continue
 			}
+		}
 
+		start := time.Now()
+		work := time.Duration(duty * float64(dutyPeriod))
+		for i := 1; ; i++ {
+			submitted := m.mineNonce(nonce, target)
 			nonce++
+			if (submitted || i%dutyCheckInterval == 0) && time.Since(start) >= work {
+				break
+			}
+		}
+		worked := time.Since(start)
+		atomic.AddInt64(&m.busyTime, int64(worked))
+
+		if !m.rest(worked, duty) {
+			return
 		}
 	}
 }
 
-// computeHash computes the mining hash
-func (m *LiteMiner) computeHash(nonce uint64) [32]byte {
-	data := make([]byte, 40)
-	copy(data[:32], []byte(m.config.MinerAddress))
-	binary.BigEndian.PutUint64(data[32:], nonce)
-	return sha256.Sum256(data)
+// mineNonce hashes one nonce, submitting it if it makes a share. It
+// reports whether it submitted.
+func (m *LiteMiner) mineNonce(nonce uint64, target *big.Int) bool {
+	if m.config.Solo {
+		return m.mineSolo(nonce)
+	}
+
+	job, _ := m.job.Load().(miningJob)
+	hash := m.computeHash(job, nonce)
+	atomic.AddUint64(&m.hashCount, 1)
+
+	// Check if valid share
+	hashInt := new(big.Int).SetBytes(hash[:])
+	if hashInt.Cmp(target) < 0 {
+		m.submitShare(job, nonce, hash)
+		return true
+	}
+	return false
+}
+
+// computeHash computes the work of a nonce on job for the payout address;
+// see blockchain.MiningShareWork
+func (m *LiteMiner) computeHash(job miningJob, nonce uint64) [32]byte {
+	return blockchain.MiningShareWork(job.id, m.payout, nonce)
 }
 
-// submitShare submits a valid share
-func (m *LiteMiner) submitShare(nonce uint64, hash [32]byte) {
+// shareHeader returns the bytes hashed before the nonce for shares on job
+func (m *LiteMiner) shareHeader(job miningJob) []byte {
+	return blockchain.MiningWorkHeader(job.id, m.payout)
+}
+
+// submitShare signs and submits a valid share for job, reporting whether
+// the node accepted it
+func (m *LiteMiner) submitShare(job miningJob, nonce uint64, hash [32]byte) bool {
+	sub := &SignedShare{
+		JobID:  job.id,
+		Height: job.height,
+		Nonce:  nonce,
+		Hash:   hash,
+	}
+	if m.config.SignShare == nil {
+		atomic.AddUint64(&m.rejected, 1)
+		return false
+	}
+	signature, err := m.config.SignShare(sub.Payload())
+	if err != nil {
+		atomic.AddUint64(&m.rejected, 1)
+		return false
+	}
+
+	// The client retries timed-out calls with the same key, so a share the
+	// node already took is not rejected as a duplicate or credited twice
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		atomic.AddUint64(&m.rejected, 1)
+		return false
+	}
 	share := map[string]interface{}{
-		"minerAddr": m.config.MinerAddress,
-		"nonce":     nonce,
-		"hash":      hash[:],
+		"jobId":          job.id,
+		"height":         job.height,
+		"nonce":          fmt.Sprintf("%016x", nonce),
+		"hash":           hex.EncodeToString(hash[:]),
+		"signature":      hex.EncodeToString(signature),
+		"idempotencyKey": hex.EncodeToString(key),
 	}
 
 	accepted, err := m.client.SubmitMiningShare(share)
 	if err != nil || !accepted {
 		atomic.AddUint64(&m.rejected, 1)
-		return
+		return false
 	}
 
 	atomic.AddUint64(&m.validShares, 1)
+	return true
+}
+
+// mineSolo hashes one nonce of the current block template, submitting it
+// if it wins, and reports whether it submitted
+func (m *LiteMiner) mineSolo(nonce uint64) bool {
+	job, _ := m.job.Load().(miningJob)
+	if job.template == "" {
+		return false
+	}
+	hash := sha256.Sum256(append(job.header[:len(job.header):len(job.header)], uint64Bytes(nonce)...))
+	atomic.AddUint64(&m.hashCount, 1)
+	if new(big.Int).SetBytes(hash[:]).Cmp(job.target) < 0 {
+		m.submitSolo(job, nonce)
+		return true
+	}
+	return false
+}
+
+// submitSolo submits a nonce that wins on job's template, reporting
+// whether the node accepted it
+func (m *LiteMiner) submitSolo(job miningJob, nonce uint64) bool {
+	accepted, err := m.client.SubmitSoloShare(map[string]interface{}{
+		"templateId": job.template,
+		"nonce":      fmt.Sprintf("%016x", nonce),
+	})
+	if err != nil || !accepted {
+		atomic.AddUint64(&m.rejected, 1)
+		return false
+	}
+	atomic.AddUint64(&m.validShares, 1)
+	return true
 }
 
 // workUpdater updates mining work periodically
 func (m *LiteMiner) workUpdater() {
-	ticker := time.NewTicker(30 * time.Second)
+	interval := 30 * time.Second
+	if m.config.Solo {
+		interval = soloRefreshInterval
+	}
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
@@ -187,15 +332,62 @@ func (m *LiteMiner) workUpdater() {
 		case <-m.stopCh:
 			return
 		case <-ticker.C:
-			work, err := m.client.GetMiningWork()
-			if err != nil {
-				continue
-			}
+			m.fetchWork()
+		}
+	}
+}
 
-			if diffStr, ok := work["difficulty"].(string); ok {
-				newDiff, _ := new(big.Int).SetString(diffStr, 10)
-				m.difficulty = newDiff
-			}
+// fetchWork fetches pool work, or a block template in solo mode
+func (m *LiteMiner) fetchWork() error {
+	var work map[string]interface{}
+	var err error
+	if m.config.Solo {
+		work, err = m.client.GetBlockTemplate(m.config.MinerAddress)
+	} else {
+		work, err = m.client.GetMiningWork()
+	}
+	if err != nil {
+		return err
+	}
+
+	if diffStr, ok := work["difficulty"].(string); ok {
+		if newDiff, ok := new(big.Int).SetString(diffStr, 10); ok {
+			m.difficulty = newDiff
 		}
 	}
+	return m.updateJob(work)
+}
+
+// miningJob identifies the work that shares are signed against, and in
+// solo mode the template nonces are hashed with
+type miningJob struct {
+	id       string
+	height   uint64
+	template string
+	header   []byte
+	target   *big.Int
+}
+
+func (m *LiteMiner) updateJob(work map[string]interface{}) error {
+	id, _ := work["jobId"].(string)
+	height, _ := work["blockHeight"].(float64)
+	job := miningJob{id: id, height: uint64(height)}
+
+	if m.config.Solo {
+		headerHex, _ := work["header"].(string)
+		targetHex, _ := work["target"].(string)
+		header, err := hex.DecodeString(headerHex)
+		if err != nil {
+			return fmt.Errorf("invalid template header: %w", err)
+		}
+		target, ok := new(big.Int).SetString(targetHex, 16)
+		if !ok {
+			return fmt.Errorf("invalid template target %q", targetHex)
+		}
+		job.template, _ = work["templateId"].(string)
+		job.header = header
+		job.target = target
+	}
+	m.job.Store(job)
+	return nil
 }
@@ -0,0 +1,76 @@
+// Package mining - Earnings estimates for miners joining the pool
+package mining
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"time"
+)
+
+// Mining algorithms
+const (
+	AlgorithmRandomX    = "randomx"    // CPU
+	AlgorithmKHeavyHash = "kheavyhash" // GPU
+)
+
+// minShareInterval is the shortest time the pool accepts between two
+// shares of a miner
+const minShareInterval = 5 * time.Second
+
+// ErrUnknownAlgorithm is returned for algorithms the pool does not mine
+var ErrUnknownAlgorithm = errors.New("unknown mining algorithm")
+
+// EarningsEstimate is the expected reward of declared hardware
+type EarningsEstimate struct {
+	Algorithm      string
+	HashRate       float64  // Hashes per second
+	Difficulty     *big.Int // Difficulty the estimate was made at
+	SharesPerDay   float64
+	RewardPerShare *big.Int // After the pool fee
+	Daily          *big.Int
+	Weekly         *big.Int
+	Monthly        *big.Int // 30 days
+	RateLimited    bool     // Shares are found faster than the pool accepts them
+}
+
+// ValidAlgorithm reports whether the pool mines algorithm
+func ValidAlgorithm(algorithm string) bool {
+	return algorithm == AlgorithmRandomX || algorithm == AlgorithmKHeavyHash
+}
+
+// EstimateEarnings estimates what hardware mining algorithm at hashRate
+// hashes per second would earn at the current difficulty and a human score
+// of 100
+func (p *Pool) EstimateEarnings(algorithm string, hashRate float64) (*EarningsEstimate, error) {
+	if !ValidAlgorithm(algorithm) {
+		return nil, ErrUnknownAlgorithm
+	}
+	if hashRate <= 0 || math.IsInf(hashRate, 0) || math.IsNaN(hashRate) {
+		return nil, errors.New("hash rate must be positive")
+	}
+
+	difficulty := p.distributor.GetDifficulty()
+	maxSharesPerSecond := 1 / minShareInterval.Seconds()
+	sharesPerSecond := maxSharesPerSecond
+	if difficulty.Sign() > 0 {
+		d, _ := new(big.Float).SetInt(difficulty).Float64()
+		sharesPerSecond = math.Min(hashRate/d, maxSharesPerSecond)
+	}
+	sharesPerDay := sharesPerSecond * (24 * time.Hour).Seconds()
+
+	rewardPerShare, _ := p.splitFee(p.calculateShareReward(algorithm, 100))
+	daily, _ := new(big.Float).Mul(new(big.Float).SetInt(rewardPerShare), big.NewFloat(sharesPerDay)).Int(nil)
+
+	return &EarningsEstimate{
+		Algorithm:      algorithm,
+		HashRate:       hashRate,
+		Difficulty:     difficulty,
+		SharesPerDay:   sharesPerDay,
+		RewardPerShare: rewardPerShare,
+		Daily:          daily,
+		Weekly:         new(big.Int).Mul(daily, big.NewInt(7)),
+		Monthly:        new(big.Int).Mul(daily, big.NewInt(30)),
+		RateLimited:    sharesPerSecond >= maxSharesPerSecond,
+	}, nil
+}
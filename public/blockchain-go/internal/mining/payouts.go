@@ -0,0 +1,153 @@
+// Package mining - Payout ledger and accounting export
+package mining
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math/big"
+	"strconv"
+	"time"
+
+	"chaincore/internal/blockchain"
+)
+
+// PayoutRecord is a single entry in the pool payout ledger
+type PayoutRecord struct {
+	ID          uint64
+	Miner       [20]byte
+	WorkerName  string
+	Amount      *big.Int // Paid to the miner, after fees
+	FeeWithheld *big.Int // Pool fee withheld from the gross reward
+	Shares      uint64
+	Status      string   // PayoutQueued, PayoutSent, PayoutConfirmed or PayoutFailed
+	TxHash      [32]byte // Payout transaction, zero while queued
+	TxNonce     uint64
+	Attempts    int // Payout transactions that failed
+	Timestamp   time.Time
+}
+
+// PayoutFilter selects ledger entries for export. Zero values match all.
+type PayoutFilter struct {
+	Miner *[20]byte
+	From  time.Time
+	To    time.Time
+}
+
+// payoutCSVHeader is the column order of WritePayoutsCSV
+var payoutCSVHeader = []string{
+	"id", "timestamp", "miner", "worker", "tx_hash", "shares", "gross_wei", "fee_wei", "amount_wei", "status",
+}
+
+// GetPayouts returns ledger entries matching the filter, oldest first
+func (p *Pool) GetPayouts(filter PayoutFilter) []PayoutRecord {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	result := make([]PayoutRecord, 0)
+	for _, record := range p.payouts {
+		if filter.Miner != nil && record.Miner != *filter.Miner {
+			continue
+		}
+		if !filter.From.IsZero() && record.Timestamp.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !record.Timestamp.Before(filter.To) {
+			continue
+		}
+		result = append(result, record)
+	}
+	return result
+}
+
+// Gross returns the reward before the pool fee was withheld
+func (r PayoutRecord) Gross() *big.Int {
+	return new(big.Int).Add(r.Amount, r.FeeWithheld)
+}
+
+// MarshalJSON encodes amounts as decimal wei strings and the miner as a
+// checksummed address
+func (r PayoutRecord) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"id":         r.ID,
+		"timestamp":  r.Timestamp.UTC().Format(time.RFC3339),
+		"miner":      blockchain.ChecksumAddress(r.Miner),
+		"workerName": r.WorkerName,
+		"txHash":     "0x" + hex.EncodeToString(r.TxHash[:]),
+		"shares":     r.Shares,
+		"grossWei":   r.Gross().String(),
+		"feeWei":     r.FeeWithheld.String(),
+		"amountWei":  r.Amount.String(),
+		"status":     r.Status,
+	})
+}
+
+// WritePayoutsCSV writes ledger entries as CSV with a header row
+func WritePayoutsCSV(w io.Writer, records []PayoutRecord) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(payoutCSVHeader); err != nil {
+		return err
+	}
+
+	for _, r := range records {
+		row := []string{
+			strconv.FormatUint(r.ID, 10),
+			r.Timestamp.UTC().Format(time.RFC3339),
+			blockchain.ChecksumAddress(r.Miner),
+			r.WorkerName,
+			"0x" + hex.EncodeToString(r.TxHash[:]),
+			strconv.FormatUint(r.Shares, 10),
+			r.Gross().String(),
+			r.FeeWithheld.String(),
+			r.Amount.String(),
+			r.Status,
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// recordPayout appends a queued ledger entry for the miner's pending
+// balance and returns its index. Callers must hold p.mu and miner.mu.
+func (p *Pool) recordPayout(miner *PoolMiner) int {
+	p.payouts = append(p.payouts, PayoutRecord{
+		ID:          uint64(len(p.payouts)) + 1,
+		Miner:       miner.Address,
+		WorkerName:  miner.WorkerName,
+		Amount:      new(big.Int).Set(miner.PendingReward),
+		FeeWithheld: new(big.Int).Set(miner.PendingFees),
+		Shares:      miner.PendingShares,
+		Status:      PayoutQueued,
+		Timestamp:   time.Now(),
+	})
+	return len(p.payouts) - 1
+}
+
+// recordTreasuryPayout appends a queued ledger entry forwarding amount of
+// the pool's fees to the treasury and returns its index. Callers must hold
+// p.mu.
+func (p *Pool) recordTreasuryPayout(amount *big.Int) int {
+	p.payouts = append(p.payouts, PayoutRecord{
+		ID:          uint64(len(p.payouts)) + 1,
+		Miner:       blockchain.TreasuryAddress,
+		WorkerName:  "treasury",
+		Amount:      new(big.Int).Set(amount),
+		FeeWithheld: big.NewInt(0),
+		Status:      PayoutQueued,
+		Timestamp:   time.Now(),
+	})
+	return len(p.payouts) - 1
+}
+
+func uint64Bytes(n uint64) []byte {
+	b := make([]byte, 8)
+	for i := 0; i < 8; i++ {
+		b[7-i] = byte(n >> (8 * i))
+	}
+	return b
+}
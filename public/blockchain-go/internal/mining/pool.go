@@ -3,8 +3,8 @@ package mining
 
 import (
 	"crypto/sha256"
-	"encoding/hex"
 	"errors"
+	"fmt"
 	"math/big"
 	"sync"
 	"sync/atomic"
@@ -22,6 +22,14 @@ type PoolConfig struct {
 	TargetBlockTime uint64 // Target block time in seconds (120)
 	MaxMiners       int
 	Enabled         bool
+	PayoutScheme    string   // PayoutPPS (the default) or PayoutPPLNS
+	WindowShares    uint64   // PPLNS window, in share weight
+	Address         [20]byte // Account the chain credits the pool's shares to under PPLNS; defaults to the wallet's
+	Wallet          PayoutWallet
+	PayoutInterval  time.Duration // How often payouts run (default one hour)
+	PayoutBatchSize int           // Miners paid per payout transaction (default 100)
+	PayoutGasPrice  uint64        // Gas price for payouts, if above the pool's advisory price
+	TreasuryShare   float64       // Percentage of withheld pool fees forwarded to the protocol treasury
 }
 
 // PoolStats holds pool statistics
@@ -34,26 +42,32 @@ type PoolStats struct {
 	PendingRewards *big.Int  `json:"pendingRewards"`
 	Luck           float64   `json:"luck"`
 	Difficulty     *big.Int  `json:"difficulty"`
+	TreasuryOwed   *big.Int  `json:"treasuryOwed"` // Fee share not yet queued for the treasury
+	TreasuryPaid   *big.Int  `json:"treasuryPaid"` // Fee share forwarded to the treasury
 }
 
 // PoolMiner represents a connected miner
 type PoolMiner struct {
-	Address        [20]byte
-	PublicKey      []byte
-	SessionID      [32]byte
-	Algorithm      string // "randomx" or "kheavyhash"
-	HashRate       uint64
-	ValidShares    uint64
-	RejectedShares uint64
-	PendingReward  *big.Int
-	TotalPaid      *big.Int
-	LastShareTime  time.Time
-	ConnectedAt    time.Time
-	HumanScore     uint8
-	IsOnline       bool
-	WorkerName     string
-	IPAddress      string
-	mu             sync.Mutex
+	Address         [20]byte
+	PublicKey       []byte
+	SessionID       [32]byte
+	Algorithm       string // AlgorithmRandomX or AlgorithmKHeavyHash
+	HashRate        uint64
+	ValidShares     uint64
+	RejectedShares  uint64
+	DuplicateShares uint64 // Shares rejected as duplicates, also counted in RejectedShares
+	PendingReward   *big.Int
+	PendingFees     *big.Int // Pool fees withheld from PendingReward
+	PendingShares   uint64   // Shares counted towards the next payout
+	TotalPaid       *big.Int
+	LastShareTime   time.Time
+	ConnectedAt     time.Time
+	HumanScore      uint8
+	IsOnline        bool
+	WorkerName      string
+	IPAddress       string // Guarded, with UserAgent, by the pool's mu
+	UserAgent       string
+	mu              sync.Mutex
 }
 
 // Pool implements a production mining pool
@@ -64,6 +78,14 @@ type Pool struct {
 	miners      map[[20]byte]*PoolMiner
 	sessions    map[[32]byte]*PoolMiner
 	stats       PoolStats
+	payouts     []PayoutRecord
+	payoutTxs   map[[32]byte]*blockchain.Transaction // Outstanding payout transactions
+	payoutAddr  [20]byte
+	pplns       pplnsLedger
+	history     historyLog
+	shares      shareRegistry
+	antiBot     *AntiBotEngine // Challenges miners with low human scores
+	antiBotSave sync.Mutex     // Orders saves of anti-bot records
 	running     int32
 	stopCh      chan struct{}
 	mu          sync.RWMutex
@@ -71,6 +93,18 @@ type Pool struct {
 
 // NewPool creates a new mining pool
 func NewPool(chain *blockchain.Blockchain, distributor *Distributor, config PoolConfig) *Pool {
+	if config.PayoutScheme == "" {
+		config.PayoutScheme = PayoutPPS
+	}
+	if config.WindowShares == 0 {
+		config.WindowShares = defaultPPLNSWindow
+	}
+	if config.PayoutInterval <= 0 {
+		config.PayoutInterval = defaultPayoutInterval
+	}
+	if config.PayoutBatchSize <= 0 || config.PayoutBatchSize > blockchain.MaxBatchOutputs {
+		config.PayoutBatchSize = defaultPayoutBatch
+	}
 	return &Pool{
 		config:      config,
 		chain:       chain,
@@ -81,34 +115,90 @@ func NewPool(chain *blockchain.Blockchain, distributor *Distributor, config Pool
 			TotalPaid:      big.NewInt(0),
 			PendingRewards: big.NewInt(0),
 			Difficulty:     distributor.GetDifficulty(),
+			TreasuryOwed:   big.NewInt(0),
+			TreasuryPaid:   big.NewInt(0),
 		},
-		stopCh: make(chan struct{}),
+		payoutTxs: make(map[[32]byte]*blockchain.Transaction),
+		pplns:     pplnsLedger{weights: make(map[[20]byte]uint64)},
+		history:   newHistoryLog(),
+		shares:    newShareRegistry(),
+		antiBot:   newPoolAntiBot(),
+		stopCh:    make(chan struct{}),
 	}
 }
 
-// Start starts the mining pool
+// Start starts the mining pool, restoring its state and payout ledger
 func (p *Pool) Start() error {
+	if p.config.Wallet == nil {
+		return ErrNoPayoutWallet
+	}
+	payoutAddr, err := blockchain.ParseAddress(p.config.Wallet.Address(), false)
+	if err != nil {
+		return fmt.Errorf("invalid pool wallet address: %w", err)
+	}
+	if p.config.Address == ([20]byte{}) {
+		p.config.Address = payoutAddr
+	}
+
+	switch p.config.PayoutScheme {
+	case PayoutPPS:
+	case PayoutPPLNS:
+	default:
+		return ErrUnknownPayoutScheme
+	}
 	if !atomic.CompareAndSwapInt32(&p.running, 0, 1) {
 		return nil // Already running
 	}
 
+	p.mu.Lock()
+	p.payoutAddr = payoutAddr
+	err = p.loadState()
+	if err == nil {
+		err = p.loadPayouts()
+	}
+	if err == nil {
+		err = p.loadAntiBot()
+	}
+	p.mu.Unlock()
+	if err != nil {
+		atomic.StoreInt32(&p.running, 0)
+		return fmt.Errorf("failed to restore pool: %w", err)
+	}
+
+	if p.config.PayoutScheme == PayoutPPLNS {
+		p.chain.OnNewHead(func(block *blockchain.Block) {
+			if atomic.LoadInt32(&p.running) == 1 {
+				p.onNewHead(block)
+			}
+		})
+	}
+
 	go p.statsUpdater()
 	go p.payoutProcessor()
 	go p.minerCleanup()
+	go p.checkpointer()
 
 	return nil
 }
 
-// Stop stops the mining pool
+// Stop stops the mining pool and saves its state
 func (p *Pool) Stop() {
 	if !atomic.CompareAndSwapInt32(&p.running, 1, 0) {
 		return
 	}
 	close(p.stopCh)
+	if err := p.saveState(); err != nil {
+		logger.Error("Failed to save pool state", "error", err)
+	}
 }
 
-// Connect connects a new miner to the pool
-func (p *Pool) Connect(address [20]byte, algorithm string, workerName string, ipAddress string) (*PoolMiner, error) {
+// Connect connects a new miner to the pool. Its shares are correlated with
+// others from ipAddress and userAgent, see sybil.go.
+func (p *Pool) Connect(address [20]byte, algorithm string, workerName string, ipAddress string, userAgent string) (*PoolMiner, error) {
+	if p.antiBot.IsBlacklisted(address) {
+		return nil, ErrMinerBlacklisted
+	}
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -121,6 +211,8 @@ func (p *Pool) Connect(address [20]byte, algorithm string, workerName string, ip
 	if existing, exists := p.miners[address]; exists {
 		existing.IsOnline = true
 		existing.ConnectedAt = time.Now()
+		existing.IPAddress = ipAddress
+		existing.UserAgent = userAgent
 		return existing, nil
 	}
 
@@ -134,6 +226,7 @@ func (p *Pool) Connect(address [20]byte, algorithm string, workerName string, ip
 		HashRate:      0,
 		ValidShares:   0,
 		PendingReward: big.NewInt(0),
+		PendingFees:   big.NewInt(0),
 		TotalPaid:     big.NewInt(0),
 		ConnectedAt:   time.Now(),
 		LastShareTime: time.Now(),
@@ -141,6 +234,7 @@ func (p *Pool) Connect(address [20]byte, algorithm string, workerName string, ip
 		IsOnline:      true,
 		WorkerName:    workerName,
 		IPAddress:     ipAddress,
+		UserAgent:     userAgent,
 	}
 
 	p.miners[address] = miner
@@ -159,64 +253,146 @@ func (p *Pool) Disconnect(sessionID [32]byte) {
 	}
 }
 
-// SubmitShare processes a share submission from a miner
-func (p *Pool) SubmitShare(sessionID [32]byte, nonce uint64, hash [32]byte, jobID string) (bool, *big.Int, error) {
+// SubmitShare processes a signed share submission. The miner is identified
+// by the signature, not by a session, so the address must have connected
+// once but the share may arrive through any front-end.
+func (p *Pool) SubmitShare(sub *SignedShare) (bool, *big.Int, error) {
+	addr, err := sub.Signer()
+	if err != nil {
+		return false, nil, err
+	}
+
 	p.mu.RLock()
-	miner, exists := p.sessions[sessionID]
+	miner, exists := p.miners[addr]
+	var ip, userAgent string
+	if exists {
+		ip, userAgent = miner.IPAddress, miner.UserAgent
+	}
 	p.mu.RUnlock()
 
 	if !exists {
-		return false, nil, errors.New("invalid session")
+		return false, nil, errors.New("miner not registered")
 	}
 
 	miner.mu.Lock()
 	defer miner.mu.Unlock()
 
+	if p.antiBot.IsBlacklisted(addr) {
+		miner.RejectedShares++
+		return false, nil, ErrMinerBlacklisted
+	}
+	if p.antiBot.PendingChallenge(addr) != nil {
+		return false, nil, ErrChallengeRequired
+	}
+	origin := p.antiBot.Correlate(addr, ip, userAgent)
+	if origin.RateLimited {
+		miner.RejectedShares++
+		return false, nil, ErrSubnetRateLimited
+	}
+
+	// Reject work on stale jobs and nonces already found on the job
+	if err := p.distributor.verifyJob(sub.JobID, sub.Height); err != nil {
+		miner.RejectedShares++
+		return false, nil, err
+	}
+	if p.shares.seen(sub.JobID, sub.Nonce) {
+		p.penalizeDuplicate(miner)
+		return false, nil, ErrDuplicateShare
+	}
+
 	// Rate limiting - minimum 5 seconds between shares
-	if time.Since(miner.LastShareTime) < 5*time.Second {
+	if time.Since(miner.LastShareTime) < minShareInterval {
 		miner.RejectedShares++
 		return false, nil, errors.New("rate limited")
 	}
 
-	// Create share for distributor
-	share := &Share{
-		MinerAddr:  miner.Address,
-		Nonce:      nonce,
-		Hash:       hash,
-		Difficulty: p.stats.Difficulty,
-		Timestamp:  time.Now(),
-		HumanScore: miner.HumanScore,
-		SessionID:  sessionID,
-		IsValid:    false,
+	// Check the work where the algorithm allows it
+	job, err := p.jobAt(miner.Algorithm, sub.Height, p.distributor.GetDifficulty())
+	if err == nil {
+		err = job.Verify(sub.Nonce, sub.Hash)
+	}
+	if err != nil {
+		miner.RejectedShares++
+		return false, nil, err
+	}
+	if err := p.shares.add(sub.JobID, sub.Height, sub.Nonce); err != nil {
+		p.penalizeDuplicate(miner)
+		return false, nil, err
 	}
 
-	// Submit to distributor for validation and reward calculation
-	if err := p.distributor.SubmitShare(share); err != nil {
+	// Submit to distributor for validation and reward calculation. Under
+	// PPLNS the share is credited to the pool, which pays the miner.
+	var credit [20]byte
+	if p.config.PayoutScheme == PayoutPPLNS {
+		credit = p.config.Address
+	}
+	_, err = p.distributor.SubmitPoolShare(sub, credit)
+	if errors.Is(err, ErrDuplicateShare) {
+		p.penalizeDuplicate(miner)
+		return false, nil, err
+	}
+	if err != nil {
 		miner.RejectedShares++
 		return false, nil, err
 	}
 
-	// Share accepted
-	miner.ValidShares++
-	miner.LastShareTime = time.Now()
+	return true, p.creditShare(miner, 1, origin.Dampening), nil
+}
 
-	// Calculate share reward based on algorithm
-	reward := p.calculateShareReward(miner.Algorithm, miner.HumanScore)
+// SubmitWorkerShare processes a share a pool front-end verified for a
+// connected miner, such as the Stratum server's. It earns the reward of
+// one signed share for each multiple of the pool difficulty it reaches.
+// Front-ends bound each worker's share rate by raising its difficulty, so
+// the rate limit of signed shares does not apply.
+func (p *Pool) SubmitWorkerShare(address [20]byte, share *WorkerShare) (bool, *big.Int, error) {
+	p.mu.RLock()
+	miner, exists := p.miners[address]
+	var ip, userAgent string
+	if exists {
+		ip, userAgent = miner.IPAddress, miner.UserAgent
+	}
+	p.mu.RUnlock()
 
-	// Apply pool fee
-	poolFee := new(big.Int).Mul(reward, big.NewInt(int64(p.config.Fee*100)))
-	poolFee.Div(poolFee, big.NewInt(10000))
-	minerReward := new(big.Int).Sub(reward, poolFee)
+	if !exists {
+		return false, nil, errors.New("miner not registered")
+	}
 
-	// Add to pending rewards
-	miner.PendingReward.Add(miner.PendingReward, minerReward)
+	miner.mu.Lock()
+	defer miner.mu.Unlock()
 
-	// Update pool pending rewards
-	p.mu.Lock()
-	p.stats.PendingRewards.Add(p.stats.PendingRewards, minerReward)
-	p.mu.Unlock()
+	if p.antiBot.IsBlacklisted(address) {
+		miner.RejectedShares++
+		return false, nil, ErrMinerBlacklisted
+	}
+	if p.antiBot.PendingChallenge(address) != nil {
+		return false, nil, ErrChallengeRequired
+	}
+	origin := p.antiBot.Correlate(address, ip, userAgent)
+	if origin.RateLimited {
+		miner.RejectedShares++
+		return false, nil, ErrSubnetRateLimited
+	}
+	if p.config.PayoutScheme == PayoutPPLNS {
+		share.PoolID = p.config.Address
+	}
+	_, err := p.distributor.SubmitWorkerShare(address, share)
+	if errors.Is(err, ErrDuplicateShare) {
+		p.penalizeDuplicate(miner)
+		return false, nil, err
+	}
+	if err != nil {
+		miner.RejectedShares++
+		return false, nil, err
+	}
 
-	return true, minerReward, nil
+	weight := int64(1)
+	if poolDifficulty := p.distributor.GetDifficulty(); poolDifficulty.Sign() > 0 {
+		multiple := new(big.Int).Div(share.Difficulty, poolDifficulty)
+		if multiple.IsInt64() && multiple.Int64() > 1 {
+			weight = multiple.Int64()
+		}
+	}
+	return true, p.creditShare(miner, weight, origin.Dampening), nil
 }
 
 // calculateShareReward calculates reward based on algorithm
@@ -226,7 +402,7 @@ func (p *Pool) calculateShareReward(algorithm string, humanScore uint8) *big.Int
 	// Base reward in wei (18 decimals)
 	var baseReward *big.Int
 
-	if algorithm == "randomx" {
+	if algorithm == AlgorithmRandomX {
 		// RandomX: 0.00032077 / 86400 / 1000 per H/s per second ≈ 3.7e-12 per share
 		// Assuming 1 share = 5 seconds of work at ~1000 H/s
 		baseReward = big.NewInt(1855) // ~1.855e-15 tokens per share (scaled up)
@@ -246,30 +422,71 @@ func (p *Pool) calculateShareReward(algorithm string, humanScore uint8) *big.Int
 	return baseReward
 }
 
-// GetWork returns current mining work for a miner
-func (p *Pool) GetWork(sessionID [32]byte) (map[string]interface{}, error) {
-	p.mu.RLock()
-	miner, exists := p.sessions[sessionID]
-	p.mu.RUnlock()
+// creditShare records an accepted share worth weight shares and returns the
+// miner's reward for it, which under PPLNS is paid only once a block
+// credits the pool. The share restores a point of human score lost to
+// duplicates. Rewards, or PPLNS weight, are scaled by the dampening of
+// the miner's origin. Callers must hold miner.mu.
+func (p *Pool) creditShare(miner *PoolMiner, weight int64, dampening float64) *big.Int {
+	miner.ValidShares++
+	if miner.HumanScore < 100 {
+		miner.HumanScore++
+	}
+	miner.LastShareTime = time.Now()
+	p.history.addShares(miner.LastShareTime, miner.Address, uint64(weight))
 
-	if !exists {
-		return nil, errors.New("invalid session")
+	if p.config.PayoutScheme == PayoutPPLNS {
+		weight = dampenWeight(weight, dampening)
+		miner.PendingShares += uint64(weight)
+		p.addPPLNSShare(miner.Address, uint64(weight))
+		return big.NewInt(0)
 	}
 
-	// Get current block data
-	currentBlock := p.chain.GetCurrentBlock()
+	// Calculate share reward based on algorithm
+	reward := p.calculateShareReward(miner.Algorithm, miner.HumanScore)
+	reward.Mul(reward, big.NewInt(weight))
+	reward = dampenReward(reward, dampening)
 
-	work := map[string]interface{}{
-		"jobId":         hex.EncodeToString(p.generateJobID()),
-		"target":        p.stats.Difficulty.Text(16),
-		"difficulty":    p.stats.Difficulty.String(),
-		"blockHeight":   currentBlock.Header.Height + 1,
-		"prevBlockHash": hex.EncodeToString(currentBlock.Header.BlockHash[:]),
-		"timestamp":     time.Now().Unix(),
-		"algorithm":     miner.Algorithm,
-	}
+	// Apply pool fee
+	minerReward, poolFee := p.splitFee(reward)
+
+	// Add to pending rewards
+	miner.PendingReward.Add(miner.PendingReward, minerReward)
+	miner.PendingFees.Add(miner.PendingFees, poolFee)
+	miner.PendingShares += uint64(weight)
+
+	// Update pool pending rewards
+	p.mu.Lock()
+	p.stats.PendingRewards.Add(p.stats.PendingRewards, minerReward)
+	p.stats.TreasuryOwed.Add(p.stats.TreasuryOwed, p.treasuryShare(poolFee))
+	p.mu.Unlock()
+
+	return minerReward
+}
+
+// splitFee splits a share reward into the miner's part and the pool fee
+func (p *Pool) splitFee(reward *big.Int) (minerReward, poolFee *big.Int) {
+	poolFee = new(big.Int).Mul(reward, big.NewInt(int64(p.config.Fee*100)))
+	poolFee.Div(poolFee, big.NewInt(10000))
+	return new(big.Int).Sub(reward, poolFee), poolFee
+}
 
-	return work, nil
+// treasuryShare returns the part of a pool fee owed to the treasury
+func (p *Pool) treasuryShare(poolFee *big.Int) *big.Int {
+	share := new(big.Int).Mul(poolFee, big.NewInt(int64(p.config.TreasuryShare*100)))
+	return share.Div(share, big.NewInt(10000))
+}
+
+// GetWork returns current mining work for an algorithm. Work depends only
+// on the chain head, so no session is needed; shares for it are signed
+// over the job ID and block height.
+func (p *Pool) GetWork(algorithm string) (map[string]interface{}, error) {
+	height := p.chain.GetCurrentBlock().Header.Height + 1
+	job, err := p.jobAt(algorithm, height, p.distributor.GetDifficulty())
+	if err != nil {
+		return nil, err
+	}
+	return job.Work(), nil
 }
 
 // GetPoolStats returns current pool statistics
@@ -335,6 +552,7 @@ func (p *Pool) updateStats() {
 	// Calculate hash rates for all miners
 	var totalHashRate uint64
 	activeCount := 0
+	rates := make(map[[20]byte]uint64)
 
 	for _, miner := range p.miners {
 		miner.mu.Lock()
@@ -352,6 +570,7 @@ func (p *Pool) updateStats() {
 			}
 			totalHashRate += miner.HashRate
 			activeCount++
+			rates[miner.Address] = miner.HashRate
 		} else if time.Since(miner.LastShareTime) > 5*time.Minute {
 			miner.IsOnline = false
 		}
@@ -360,11 +579,12 @@ func (p *Pool) updateStats() {
 
 	p.stats.TotalHashRate = totalHashRate
 	p.stats.ActiveMiners = activeCount
+	p.history.sampleHashRates(time.Now(), totalHashRate, rates)
 }
 
 // payoutProcessor processes pending payouts
 func (p *Pool) payoutProcessor() {
-	ticker := time.NewTicker(1 * time.Hour)
+	ticker := time.NewTicker(p.config.PayoutInterval)
 	defer ticker.Stop()
 
 	for {
@@ -377,23 +597,15 @@ func (p *Pool) payoutProcessor() {
 	}
 }
 
+// processPayouts settles sent payouts, then queues balances of at least
+// MinPayout and the treasury's share and sends them in batch transfers
 func (p *Pool) processPayouts() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	for _, miner := range p.miners {
-		miner.mu.Lock()
-		// Check if pending reward exceeds minimum payout
-		if miner.PendingReward.Cmp(p.config.MinPayout) >= 0 {
-			// In production, this would create a blockchain transaction
-			// For now, just track the payout
-			miner.TotalPaid.Add(miner.TotalPaid, miner.PendingReward)
-			p.stats.TotalPaid.Add(p.stats.TotalPaid, miner.PendingReward)
-			p.stats.PendingRewards.Sub(p.stats.PendingRewards, miner.PendingReward)
-			miner.PendingReward = big.NewInt(0)
-		}
-		miner.mu.Unlock()
-	}
+	p.settlePayouts()
+	p.queuePayouts()
+	p.sendPayouts()
 }
 
 // minerCleanup removes inactive miners
@@ -418,9 +630,10 @@ func (p *Pool) cleanupInactiveMiners() {
 	cutoff := time.Now().Add(-24 * time.Hour)
 
 	for addr, miner := range p.miners {
-		if miner.LastShareTime.Before(cutoff) && miner.PendingReward.Cmp(big.NewInt(0)) == 0 {
+		if miner.LastShareTime.Before(cutoff) && miner.PendingReward.Cmp(big.NewInt(0)) == 0 && !p.hasPPLNSShares(addr) {
 			delete(p.sessions, miner.SessionID)
 			delete(p.miners, addr)
+			p.history.forget(addr)
 		}
 	}
 }
@@ -429,9 +642,3 @@ func (p *Pool) generateSessionID(addr [20]byte) [32]byte {
 	data := append(addr[:], []byte(time.Now().String())...)
 	return sha256.Sum256(data)
 }
-
-func (p *Pool) generateJobID() []byte {
-	data := []byte(time.Now().String())
-	hash := sha256.Sum256(data)
-	return hash[:8]
-}
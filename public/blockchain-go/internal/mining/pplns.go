@@ -0,0 +1,246 @@
+// Package mining - PPLNS payout scheme
+package mining
+
+import (
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+
+	"chaincore/internal/blockchain"
+)
+
+// Payout schemes
+const (
+	PayoutPPS   = "pps"   // A fixed reward per share
+	PayoutPPLNS = "pplns" // Block rewards shared over the last N shares
+)
+
+const (
+	// defaultPPLNSWindow is the share weight rewards are split over when
+	// the config leaves it zero
+	defaultPPLNSWindow = 10000
+
+	// rewardConfirmations is how deep a block crediting the pool must be
+	// before its reward is shared out
+	rewardConfirmations = 6
+
+	// roundsKept is how many closed rounds GetRounds reports
+	roundsKept = 100
+)
+
+// Round statuses
+const (
+	RoundPending  = "pending"  // Block found, awaiting confirmations
+	RoundPaid     = "paid"     // Reward shared out
+	RoundOrphaned = "orphaned" // Block left the chain before confirming
+)
+
+// ErrUnknownPayoutScheme is returned for a payout scheme the pool does not
+// implement
+var ErrUnknownPayoutScheme = errors.New("unknown payout scheme")
+
+// Round is the shares between two blocks crediting the pool. Its reward is
+// shared over the last WindowShares shares once the block is
+// rewardConfirmations deep, and not at all if the block is reorganized away.
+type Round struct {
+	Number       uint64
+	Height       uint64 // Block that closed the round
+	BlockHash    [32]byte
+	Reward       *big.Int // Credited to the pool by the block
+	RoundShares  uint64   // Share weight found during the round
+	WindowShares uint64   // Share weight the reward is split over
+	Status       string
+	FoundAt      time.Time
+
+	weights map[[20]byte]uint64 // Window snapshot by miner
+}
+
+// pplnsLedger is the sliding share window and the rounds awaiting payment
+type pplnsLedger struct {
+	window      []pplnsShare // Oldest first
+	weights     map[[20]byte]uint64
+	total       uint64
+	roundShares uint64
+	rounds      []*Round // Closed rounds, oldest first
+	nextRound   uint64
+	mu          sync.Mutex
+}
+
+// pplnsShare is a share's weight in the window; the fields are exported
+// for checkpoints
+type pplnsShare struct {
+	Miner  [20]byte
+	Weight uint64
+}
+
+// GetRounds returns the most recent closed rounds, oldest first
+func (p *Pool) GetRounds() []Round {
+	p.pplns.mu.Lock()
+	defer p.pplns.mu.Unlock()
+
+	rounds := make([]Round, len(p.pplns.rounds))
+	for i, round := range p.pplns.rounds {
+		rounds[i] = *round
+		rounds[i].Reward = new(big.Int).Set(round.Reward)
+		rounds[i].weights = nil
+	}
+	return rounds
+}
+
+// Helper functions
+
+// addPPLNSShare adds a share to the window, dropping the oldest weight
+// beyond the window size
+func (p *Pool) addPPLNSShare(miner [20]byte, weight uint64) {
+	l := &p.pplns
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.window = append(l.window, pplnsShare{Miner: miner, Weight: weight})
+	l.weights[miner] += weight
+	l.total += weight
+	l.roundShares += weight
+
+	limit := p.config.WindowShares
+	for l.total > limit {
+		oldest := &l.window[0]
+		drop := l.total - limit
+		if drop > oldest.Weight {
+			drop = oldest.Weight
+		}
+		oldest.Weight -= drop
+		l.weights[oldest.Miner] -= drop
+		l.total -= drop
+		if l.weights[oldest.Miner] == 0 {
+			delete(l.weights, oldest.Miner)
+		}
+		if oldest.Weight == 0 {
+			l.window = l.window[1:]
+		}
+	}
+}
+
+// hasPPLNSShares reports whether a miner has shares that may still be
+// paid, so its entry must be kept
+func (p *Pool) hasPPLNSShares(miner [20]byte) bool {
+	l := &p.pplns
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.weights[miner] > 0 {
+		return true
+	}
+	for _, round := range l.rounds {
+		if round.Status == RoundPending && round.weights[miner] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// onNewHead closes a round when a block credits the pool and shares out
+// the rewards of rounds that are now confirmed
+func (p *Pool) onNewHead(block *blockchain.Block) {
+	for _, reward := range block.Mining.Rewards {
+		if reward.Address == p.config.Address && reward.Reward != nil && reward.Reward.Sign() > 0 {
+			p.closeRound(block, reward.Reward)
+		}
+	}
+
+	if block.Header.Height < rewardConfirmations {
+		return
+	}
+	for _, round := range p.confirmRounds(block.Header.Height - rewardConfirmations) {
+		p.shareReward(round)
+	}
+}
+
+func (p *Pool) closeRound(block *blockchain.Block, reward *big.Int) {
+	l := &p.pplns
+	l.mu.Lock()
+	l.nextRound++
+	round := &Round{
+		Number:       l.nextRound,
+		Height:       block.Header.Height,
+		BlockHash:    block.Hash(),
+		Reward:       new(big.Int).Set(reward),
+		RoundShares:  l.roundShares,
+		WindowShares: l.total,
+		Status:       RoundPending,
+		FoundAt:      time.Now(),
+		weights:      make(map[[20]byte]uint64, len(l.weights)),
+	}
+	for miner, weight := range l.weights {
+		round.weights[miner] = weight
+	}
+	l.roundShares = 0
+	l.rounds = append(l.rounds, round)
+	l.mu.Unlock()
+
+	p.mu.Lock()
+	p.stats.BlocksFound++
+	p.stats.LastBlockTime = round.FoundAt
+	p.mu.Unlock()
+	p.history.addBlock(round.FoundAt, reward)
+}
+
+// confirmRounds settles pending rounds closed at or below height: those
+// whose block is still on the chain are returned for payment, the rest
+// are orphaned
+func (p *Pool) confirmRounds(height uint64) []*Round {
+	l := &p.pplns
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var confirmed []*Round
+	for _, round := range l.rounds {
+		if round.Status != RoundPending || round.Height > height {
+			continue
+		}
+		block, err := p.chain.GetBlock(round.Height)
+		if err != nil || block.Hash() != round.BlockHash {
+			round.Status = RoundOrphaned
+			round.weights = nil
+			continue
+		}
+		round.Status = RoundPaid
+		confirmed = append(confirmed, round)
+	}
+
+	// Drop the oldest settled rounds beyond roundsKept
+	for len(l.rounds) > roundsKept && l.rounds[0].Status != RoundPending {
+		l.rounds = l.rounds[1:]
+	}
+	return confirmed
+}
+
+// shareReward credits a confirmed round's reward to the miners of its
+// window in proportion to their weight. Rounding dust stays with the pool.
+func (p *Pool) shareReward(round *Round) {
+	if round.WindowShares == 0 {
+		return
+	}
+	total := new(big.Int).SetUint64(round.WindowShares)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for address, weight := range round.weights {
+		miner, exists := p.miners[address]
+		if !exists {
+			continue
+		}
+		gross := new(big.Int).Mul(round.Reward, new(big.Int).SetUint64(weight))
+		gross.Div(gross, total)
+		minerReward, poolFee := p.splitFee(gross)
+
+		miner.mu.Lock()
+		miner.PendingReward.Add(miner.PendingReward, minerReward)
+		miner.PendingFees.Add(miner.PendingFees, poolFee)
+		miner.mu.Unlock()
+		p.stats.PendingRewards.Add(p.stats.PendingRewards, minerReward)
+		p.stats.TreasuryOwed.Add(p.stats.TreasuryOwed, p.treasuryShare(poolFee))
+	}
+	round.weights = nil
+}
@@ -0,0 +1,125 @@
+// Package mining - Per-job share registry
+package mining
+
+import (
+	"sync"
+)
+
+// duplicateSharePenalty is how far a duplicate share lowers a miner's
+// human score. Each accepted share restores one point.
+const duplicateSharePenalty = 10
+
+// shareRegistry records the nonces accepted for each live job. RandomX
+// hashes cannot be checked, so a nonce found twice on one job is the same
+// work whatever hash it claims.
+type shareRegistry struct {
+	jobs map[string]*jobShares
+	mu   sync.Mutex
+}
+
+// jobShares is the nonces accepted for one job
+type jobShares struct {
+	height uint64
+	nonces map[uint64]struct{}
+}
+
+// registeredShare is a registry entry as checkpointed
+type registeredShare struct {
+	JobID  string
+	Height uint64
+	Nonce  uint64
+}
+
+// Helper functions
+
+func newShareRegistry() shareRegistry {
+	return shareRegistry{jobs: make(map[string]*jobShares)}
+}
+
+// seen reports whether a nonce was accepted for a job
+func (r *shareRegistry) seen(jobID string, nonce uint64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job, exists := r.jobs[jobID]
+	if !exists {
+		return false
+	}
+	_, seen := job.nonces[nonce]
+	return seen
+}
+
+// add records a nonce for the job at height, failing with
+// ErrDuplicateShare if it was recorded before. Jobs below the previous
+// height expire.
+func (r *shareRegistry) add(jobID string, height, nonce uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.expire(height)
+	job, exists := r.jobs[jobID]
+	if !exists {
+		job = &jobShares{height: height, nonces: make(map[uint64]struct{})}
+		r.jobs[jobID] = job
+	}
+	if _, seen := job.nonces[nonce]; seen {
+		return ErrDuplicateShare
+	}
+	job.nonces[nonce] = struct{}{}
+	return nil
+}
+
+// expire forgets jobs the distributor no longer accepts work on once work
+// at height arrives. Callers must hold r.mu.
+func (r *shareRegistry) expire(height uint64) {
+	if height < 2 {
+		return
+	}
+	for id, job := range r.jobs {
+		if job.height < height-1 {
+			delete(r.jobs, id)
+		}
+	}
+}
+
+// snapshot lists the registry's entries
+func (r *shareRegistry) snapshot() []registeredShare {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var shares []registeredShare
+	for id, job := range r.jobs {
+		for nonce := range job.nonces {
+			shares = append(shares, registeredShare{JobID: id, Height: job.height, Nonce: nonce})
+		}
+	}
+	return shares
+}
+
+// restore adds checkpointed entries to the registry
+func (r *shareRegistry) restore(shares []registeredShare) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, share := range shares {
+		job, exists := r.jobs[share.JobID]
+		if !exists {
+			job = &jobShares{height: share.Height, nonces: make(map[uint64]struct{})}
+			r.jobs[share.JobID] = job
+		}
+		job.nonces[share.Nonce] = struct{}{}
+	}
+}
+
+// penalizeDuplicate counts a duplicate share against a miner, challenging
+// it if its score falls too low. Callers must hold miner.mu.
+func (p *Pool) penalizeDuplicate(miner *PoolMiner) {
+	miner.RejectedShares++
+	miner.DuplicateShares++
+	if miner.HumanScore > duplicateSharePenalty {
+		miner.HumanScore -= duplicateSharePenalty
+	} else {
+		miner.HumanScore = 0
+	}
+	p.escalate(miner)
+}
@@ -0,0 +1,297 @@
+// Package mining - Persisted anti-bot records and operator overrides
+package mining
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"chaincore/internal/storage"
+)
+
+// defaultRecordTTL is how long evidence about an address lasts unless
+// AntiBotConfig.RecordTTL sets otherwise
+const defaultRecordTTL = 24 * time.Hour
+
+// antiBotStateKey is where the pool saves its anti-bot records
+var antiBotStateKey = []byte("pool:antibot")
+
+// ErrMinerBlacklisted is returned for connections and shares from
+// blacklisted addresses
+var ErrMinerBlacklisted = errors.New("miner is blacklisted")
+
+// AntiBotRecord is what the engine holds on an address. Records are
+// checkpointed, and decay over RecordTTL unless blacklisted.
+type AntiBotRecord struct {
+	Address     [20]byte
+	Pattern     *BehaviorPattern `json:",omitempty"`
+	Score       uint8
+	Scored      bool      // Unscored addresses count as human
+	LastSeen    time.Time // Last submission or override
+	BannedUntil time.Time // Zero unless blacklisted
+	Challenged  bool      `json:"-"` // Has a challenge outstanding
+}
+
+// Blacklisted reports whether the record's address is blacklisted
+func (r *AntiBotRecord) Blacklisted() bool {
+	return time.Now().Before(r.BannedUntil)
+}
+
+// Record returns what the engine holds on addr, its score decayed to now
+func (ab *AntiBotEngine) Record(addr [20]byte) AntiBotRecord {
+	ab.mu.RLock()
+	defer ab.mu.RUnlock()
+
+	record := ab.record(addr)
+	record.Score = ab.decayedScore(addr, time.Now())
+	if record.Pattern != nil {
+		record.Pattern = copyPattern(record.Pattern)
+	}
+	return record
+}
+
+// Ban blacklists addr until the given time, replacing any blacklisting it
+// had
+func (ab *AntiBotEngine) Ban(addr [20]byte, until time.Time) {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	ab.blacklist[addr] = until
+	ab.lastSeen[addr] = time.Now()
+}
+
+// IsBlacklisted reports whether addr is blacklisted
+func (ab *AntiBotEngine) IsBlacklisted(addr [20]byte) bool {
+	ab.mu.RLock()
+	defer ab.mu.RUnlock()
+
+	expiry, blacklisted := ab.blacklist[addr]
+	return blacklisted && time.Now().Before(expiry)
+}
+
+// Blacklisted returns the records of blacklisted addresses, soonest to
+// expire first
+func (ab *AntiBotEngine) Blacklisted() []AntiBotRecord {
+	ab.mu.RLock()
+	defer ab.mu.RUnlock()
+
+	now := time.Now()
+	var records []AntiBotRecord
+	for addr, expiry := range ab.blacklist {
+		if !now.Before(expiry) {
+			continue
+		}
+		record := ab.record(addr)
+		record.Score = ab.decayedScore(addr, now)
+		record.Pattern = nil
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].BannedUntil.Before(records[j].BannedUntil)
+	})
+	return records
+}
+
+// GetAntiBotRecord returns what the pool holds on a miner: the engine's
+// record, with the pool's human score if the miner is registered
+func (p *Pool) GetAntiBotRecord(address [20]byte) AntiBotRecord {
+	record := p.antiBot.Record(address)
+
+	p.mu.RLock()
+	miner, exists := p.miners[address]
+	p.mu.RUnlock()
+	if exists {
+		miner.mu.Lock()
+		record.Score = miner.HumanScore
+		record.Scored = true
+		miner.mu.Unlock()
+	}
+	return record
+}
+
+// ClearAntiBotRecord forgets everything the engine holds on a miner,
+// including its blacklisting and challenge, and restores its human score
+func (p *Pool) ClearAntiBotRecord(address [20]byte) error {
+	p.mu.RLock()
+	miner, exists := p.miners[address]
+	p.mu.RUnlock()
+	if exists {
+		miner.mu.Lock()
+		miner.HumanScore = 100
+		miner.mu.Unlock()
+	}
+
+	p.antiBot.Forget(address)
+	return p.saveAntiBot()
+}
+
+// BanMiner blacklists a miner for duration. The ban takes effect on its
+// next connection or share.
+func (p *Pool) BanMiner(address [20]byte, duration time.Duration) error {
+	if duration <= 0 {
+		return errors.New("ban duration must be positive")
+	}
+	p.antiBot.Ban(address, time.Now().Add(duration))
+	return p.saveAntiBot()
+}
+
+// GetBannedMiners returns the records of blacklisted miners, soonest to
+// expire first
+func (p *Pool) GetBannedMiners() []AntiBotRecord {
+	return p.antiBot.Blacklisted()
+}
+
+// Helper functions
+
+func (ab *AntiBotEngine) recordTTL() time.Duration {
+	if ab.config.RecordTTL > 0 {
+		return ab.config.RecordTTL
+	}
+	return defaultRecordTTL
+}
+
+// record assembles addr's record, without decay. Callers must hold ab.mu.
+func (ab *AntiBotEngine) record(addr [20]byte) AntiBotRecord {
+	record := AntiBotRecord{
+		Address:     addr,
+		Pattern:     ab.patterns[addr],
+		LastSeen:    ab.lastSeen[addr],
+		BannedUntil: ab.blacklist[addr],
+	}
+	record.Score, record.Scored = ab.scores[addr]
+	_, record.Challenged = ab.challenges[addr]
+	return record
+}
+
+// decayedScore returns addr's score recovered towards 100 for the time it
+// has been idle, 100 if it has none. Callers must hold ab.mu.
+func (ab *AntiBotEngine) decayedScore(addr [20]byte, now time.Time) uint8 {
+	score, exists := ab.scores[addr]
+	if !exists {
+		return 100
+	}
+	idle := now.Sub(ab.lastSeen[addr])
+	ttl := ab.recordTTL()
+	if idle >= ttl {
+		return 100
+	}
+	if idle <= 0 {
+		return score
+	}
+	return score + uint8(float64(100-score)*float64(idle)/float64(ttl))
+}
+
+// sweepRecords forgets addresses idle for the record TTL, keeping those
+// blacklisted or challenged, and expired blacklistings. Callers must hold
+// ab.mu.
+func (ab *AntiBotEngine) sweepRecords(now time.Time) {
+	for addr, expiry := range ab.blacklist {
+		if !now.Before(expiry) {
+			delete(ab.blacklist, addr)
+		}
+	}
+	cutoff := now.Add(-ab.recordTTL())
+	for addr, last := range ab.lastSeen {
+		if !last.Before(cutoff) {
+			continue
+		}
+		if _, blacklisted := ab.blacklist[addr]; blacklisted {
+			continue
+		}
+		if _, challenged := ab.challenges[addr]; challenged {
+			continue
+		}
+		delete(ab.patterns, addr)
+		delete(ab.scores, addr)
+		delete(ab.lastSeen, addr)
+	}
+}
+
+// records returns every address's record, without decay, for saving
+func (ab *AntiBotEngine) records() []AntiBotRecord {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	ab.sweepRecords(time.Now())
+	addrs := make(map[[20]byte]struct{}, len(ab.lastSeen))
+	for addr := range ab.lastSeen {
+		addrs[addr] = struct{}{}
+	}
+	for addr := range ab.blacklist {
+		addrs[addr] = struct{}{}
+	}
+	records := make([]AntiBotRecord, 0, len(addrs))
+	for addr := range addrs {
+		record := ab.record(addr)
+		if record.Pattern != nil {
+			record.Pattern = copyPattern(record.Pattern)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// restore adds saved records for addresses the engine holds nothing on
+func (ab *AntiBotEngine) restore(records []AntiBotRecord) {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	for _, record := range records {
+		if _, exists := ab.lastSeen[record.Address]; exists {
+			continue
+		}
+		if record.Pattern != nil {
+			ab.patterns[record.Address] = record.Pattern
+		}
+		if record.Scored {
+			ab.scores[record.Address] = record.Score
+		}
+		if !record.BannedUntil.IsZero() {
+			ab.blacklist[record.Address] = record.BannedUntil
+		}
+		ab.lastSeen[record.Address] = record.LastSeen
+	}
+	ab.sweepRecords(time.Now())
+}
+
+// saveAntiBot writes the pool's anti-bot records
+func (p *Pool) saveAntiBot() error {
+	p.antiBotSave.Lock()
+	defer p.antiBotSave.Unlock()
+
+	data, err := json.Marshal(p.antiBot.records())
+	if err != nil {
+		return err
+	}
+	return p.chain.Database().Put(antiBotStateKey, data)
+}
+
+// loadAntiBot restores the pool's anti-bot records
+func (p *Pool) loadAntiBot() error {
+	data, err := p.chain.Database().Get(antiBotStateKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var records []AntiBotRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("corrupt anti-bot records: %w", err)
+	}
+	p.antiBot.restore(records)
+	return nil
+}
+
+func copyPattern(pattern *BehaviorPattern) *BehaviorPattern {
+	return &BehaviorPattern{
+		SubmissionTimes:  append([]time.Time(nil), pattern.SubmissionTimes...),
+		NonceValues:      append([]uint64(nil), pattern.NonceValues...),
+		DifficultyDeltas: append([]int64(nil), pattern.DifficultyDeltas...),
+		HashRates:        append([]float64(nil), pattern.HashRates...),
+		GeoLocations:     append([]string(nil), pattern.GeoLocations...),
+		UserAgents:       append([]string(nil), pattern.UserAgents...),
+	}
+}
@@ -0,0 +1,349 @@
+// Package mining - On-chain payout transactions
+package mining
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/storage"
+)
+
+// Payout statuses
+const (
+	PayoutQueued    = "queued"    // Awaiting a payout transaction
+	PayoutSent      = "sent"      // In a transaction not yet confirmed
+	PayoutConfirmed = "confirmed" // Transaction confirmed on the chain
+	PayoutFailed    = "failed"    // Abandoned; the amount was returned to the miner's, or the treasury's, balance
+)
+
+const (
+	// defaultPayoutInterval is how often payouts run when the config
+	// leaves PayoutInterval zero
+	defaultPayoutInterval = time.Hour
+
+	// defaultPayoutBatch is the outputs per payout transaction when the
+	// config leaves PayoutBatchSize zero
+	defaultPayoutBatch = 100
+
+	// maxPayoutAttempts is how many payout transactions may fail before a
+	// payout is abandoned
+	maxPayoutAttempts = 3
+)
+
+// Payout storage keys. Ledger entries are keyed by ID, outstanding payout
+// transactions by hash.
+var (
+	payoutKeyPrefix   = []byte("pool:payout:")
+	payoutTxKeyPrefix = []byte("pool:payout-tx:")
+)
+
+// ErrNoPayoutWallet is returned by Start when the pool has no wallet to pay
+// miners from
+var ErrNoPayoutWallet = errors.New("pool payouts need a wallet")
+
+// PayoutWallet signs the pool's payout transactions; *wallet.Wallet is one
+type PayoutWallet interface {
+	// Address returns the checksummed address payouts are sent from
+	Address() string
+	// SignTransaction signs tx for chainID, filling in From, Signature,
+	// Hash and ChainID
+	SignTransaction(tx *blockchain.Transaction, chainID uint64) ([]byte, error)
+}
+
+// Helper functions
+
+// settlePayouts checks the transactions of sent payouts, confirming or
+// requeueing them. Callers must hold p.mu.
+func (p *Pool) settlePayouts() {
+	head := p.chain.GetCurrentBlock().Header.Height
+	stateNonce := p.chain.GetNonce(p.payoutAddr)
+
+	outcomes := make(map[[32]byte]string)
+	var changed []int
+	for i := range p.payouts {
+		record := &p.payouts[i]
+		if record.Status != PayoutSent {
+			continue
+		}
+		outcome, checked := outcomes[record.TxHash]
+		if !checked {
+			outcome = p.payoutTxOutcome(record.TxHash, record.TxNonce, head, stateNonce)
+			outcomes[record.TxHash] = outcome
+		}
+
+		switch outcome {
+		case PayoutConfirmed:
+			record.Status = PayoutConfirmed
+			if record.Miner == blockchain.TreasuryAddress {
+				p.stats.TreasuryPaid.Add(p.stats.TreasuryPaid, record.Amount)
+				break
+			}
+			if miner, exists := p.miners[record.Miner]; exists {
+				miner.mu.Lock()
+				miner.TotalPaid.Add(miner.TotalPaid, record.Amount)
+				miner.mu.Unlock()
+			}
+			p.stats.TotalPaid.Add(p.stats.TotalPaid, record.Amount)
+			p.history.addPayout(time.Now(), record.Miner, record.Amount)
+		case PayoutQueued:
+			record.Attempts++
+			record.TxHash = [32]byte{}
+			record.Status = PayoutQueued
+			if record.Attempts >= maxPayoutAttempts {
+				record.Status = PayoutFailed
+				p.returnPayout(record)
+			}
+		default:
+			continue
+		}
+		changed = append(changed, i)
+	}
+
+	batch := p.chain.Database().NewBatch()
+	for hash, outcome := range outcomes {
+		if outcome != PayoutSent {
+			delete(p.payoutTxs, hash)
+			batch.Delete(payoutTxKey(hash))
+		}
+	}
+	if err := p.writePayouts(batch, changed); err != nil {
+		logger.Error("Failed to save payouts", "error", err)
+	}
+}
+
+// payoutTxOutcome returns PayoutConfirmed for a payout transaction deep
+// enough on the chain, PayoutQueued for one that failed or can no longer
+// execute, and PayoutSent while it is pending. A pending transaction
+// missing from the pool is added back. Callers must hold p.mu.
+func (p *Pool) payoutTxOutcome(hash [32]byte, nonce uint64, head uint64, stateNonce uint64) string {
+	if _, block, _, err := p.chain.GetTransaction(hash); err == nil {
+		receipt, err := p.chain.GetReceipt(hash)
+		if err != nil {
+			return PayoutSent
+		}
+		if receipt.Status != blockchain.ReceiptStatusSuccessful {
+			logger.Warn("Payout transaction failed", "tx", hex.EncodeToString(hash[:]), "reason", receipt.FailureReason)
+			return PayoutQueued
+		}
+		if block.Header.Height+rewardConfirmations > head {
+			return PayoutSent
+		}
+		return PayoutConfirmed
+	}
+
+	if stateNonce > nonce {
+		logger.Warn("Payout transaction replaced at its nonce", "tx", hex.EncodeToString(hash[:]), "nonce", nonce)
+		return PayoutQueued
+	}
+	if p.chain.GetPendingTransaction(hash) == nil {
+		if tx := p.payoutTxs[hash]; tx != nil {
+			if err := p.chain.AddTransaction(context.Background(), tx); err != nil && !errors.Is(err, blockchain.ErrTxKnown) {
+				logger.Warn("Failed to resend payout transaction", "tx", hex.EncodeToString(hash[:]), "error", err)
+			}
+		}
+	}
+	return PayoutSent
+}
+
+// returnPayout credits an abandoned payout back to the miner's balance, or
+// to the fees owed to the treasury. Callers must hold p.mu.
+func (p *Pool) returnPayout(record *PayoutRecord) {
+	logger.Warn("Abandoned payout after failed transactions", "id", record.ID,
+		"miner", blockchain.ChecksumAddress(record.Miner), "attempts", record.Attempts)
+
+	if record.Miner == blockchain.TreasuryAddress {
+		p.stats.TreasuryOwed.Add(p.stats.TreasuryOwed, record.Amount)
+		return
+	}
+	miner := p.minerEntry(record.Miner)
+	miner.mu.Lock()
+	miner.PendingReward.Add(miner.PendingReward, record.Amount)
+	miner.PendingFees.Add(miner.PendingFees, record.FeeWithheld)
+	miner.PendingShares += record.Shares
+	miner.mu.Unlock()
+	p.stats.PendingRewards.Add(p.stats.PendingRewards, record.Amount)
+}
+
+// minerEntry returns a miner's entry, creating an offline one if the miner
+// was removed. Callers must hold p.mu.
+func (p *Pool) minerEntry(address [20]byte) *PoolMiner {
+	if miner, exists := p.miners[address]; exists {
+		return miner
+	}
+	miner := &PoolMiner{
+		Address:       address,
+		SessionID:     p.generateSessionID(address),
+		PendingReward: big.NewInt(0),
+		PendingFees:   big.NewInt(0),
+		TotalPaid:     big.NewInt(0),
+		LastShareTime: time.Now(),
+		HumanScore:    100,
+	}
+	p.miners[address] = miner
+	p.sessions[miner.SessionID] = miner
+	return miner
+}
+
+// queuePayouts moves every balance of at least the minimum payout, and the
+// fees owed to the treasury, into queued ledger entries. Callers must hold
+// p.mu.
+func (p *Pool) queuePayouts() {
+	var queued []int
+	for _, miner := range p.miners {
+		miner.mu.Lock()
+		if miner.PendingReward.Sign() > 0 && miner.PendingReward.Cmp(p.config.MinPayout) >= 0 {
+			queued = append(queued, p.recordPayout(miner))
+			p.stats.PendingRewards.Sub(p.stats.PendingRewards, miner.PendingReward)
+			miner.PendingReward = big.NewInt(0)
+			miner.PendingFees = big.NewInt(0)
+			miner.PendingShares = 0
+		}
+		miner.mu.Unlock()
+	}
+	if p.stats.TreasuryOwed.Sign() > 0 {
+		queued = append(queued, p.recordTreasuryPayout(p.stats.TreasuryOwed))
+		p.stats.TreasuryOwed = big.NewInt(0)
+	}
+
+	if err := p.writePayouts(p.chain.Database().NewBatch(), queued); err != nil {
+		logger.Error("Failed to save payouts", "error", err)
+	}
+}
+
+// sendPayouts sends the queued payouts in batch transfers. Callers must
+// hold p.mu.
+func (p *Pool) sendPayouts() {
+	var queued []int
+	for i := range p.payouts {
+		if p.payouts[i].Status == PayoutQueued {
+			queued = append(queued, i)
+		}
+	}
+
+	for len(queued) > 0 {
+		n := len(queued)
+		if n > p.config.PayoutBatchSize {
+			n = p.config.PayoutBatchSize
+		}
+		if err := p.sendPayoutBatch(queued[:n]); err != nil {
+			// The rest stay queued for the next run
+			logger.Warn("Failed to send payout transaction", "payouts", n, "error", err)
+			return
+		}
+		queued = queued[n:]
+	}
+}
+
+// sendPayoutBatch pays ledger entries in one batch transfer. Callers must
+// hold p.mu.
+func (p *Pool) sendPayoutBatch(indices []int) error {
+	outputs := make([]blockchain.BatchOutput, len(indices))
+	for i, index := range indices {
+		outputs[i] = blockchain.BatchOutput{To: p.payouts[index].Miner, Amount: p.payouts[index].Amount}
+	}
+	data := blockchain.EncodeBatchTransfer(outputs)
+
+	gasPrice := p.chain.GasPriceAdvisory().MinGasPrice
+	if p.config.PayoutGasPrice > gasPrice {
+		gasPrice = p.config.PayoutGasPrice
+	}
+	tx := &blockchain.Transaction{
+		Nonce:    p.chain.GetPendingNonce(p.payoutAddr),
+		To:       blockchain.BatchAddress,
+		Value:    blockchain.BatchTransferValue(outputs),
+		GasLimit: blockchain.BatchTransferGas(data, len(outputs)),
+		GasPrice: gasPrice,
+		Data:     data,
+	}
+	if _, err := p.config.Wallet.SignTransaction(tx, p.chain.ChainID()); err != nil {
+		return fmt.Errorf("failed to sign: %w", err)
+	}
+	if err := p.chain.AddTransaction(context.Background(), tx); err != nil {
+		return err
+	}
+
+	p.payoutTxs[tx.Hash] = tx
+	for _, index := range indices {
+		p.payouts[index].Status = PayoutSent
+		p.payouts[index].TxHash = tx.Hash
+		p.payouts[index].TxNonce = tx.Nonce
+	}
+	logger.Info("Sent payout transaction", "tx", hex.EncodeToString(tx.Hash[:]), "payouts", len(indices), "value", tx.Value.String())
+
+	batch := p.chain.Database().NewBatch()
+	encoded, err := json.Marshal(tx)
+	if err == nil {
+		err = batch.Put(payoutTxKey(tx.Hash), encoded)
+	}
+	if err == nil {
+		err = p.writePayouts(batch, indices)
+	}
+	if err != nil {
+		logger.Error("Failed to save payouts", "error", err)
+	}
+	return nil
+}
+
+// storedPayout is a ledger entry as stored, without the export encoding
+type storedPayout PayoutRecord
+
+// writePayouts adds ledger entries to batch and writes it. Callers must
+// hold p.mu.
+func (p *Pool) writePayouts(batch storage.Batch, indices []int) error {
+	for _, index := range indices {
+		record := &p.payouts[index]
+		data, err := json.Marshal((*storedPayout)(record))
+		if err != nil {
+			return err
+		}
+		if err := batch.Put(payoutKey(record.ID), data); err != nil {
+			return err
+		}
+	}
+	return batch.Write()
+}
+
+// loadPayouts restores the ledger and the outstanding payout transactions.
+// Callers must hold p.mu.
+func (p *Pool) loadPayouts() error {
+	db := p.chain.Database()
+
+	it := db.NewIterator(payoutKeyPrefix)
+	defer it.Release()
+	for it.Next() {
+		var record storedPayout
+		if err := json.Unmarshal(it.Value(), &record); err != nil {
+			return fmt.Errorf("corrupt payout record: %w", err)
+		}
+		p.payouts = append(p.payouts, PayoutRecord(record))
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+
+	txs := db.NewIterator(payoutTxKeyPrefix)
+	defer txs.Release()
+	for txs.Next() {
+		tx := new(blockchain.Transaction)
+		if err := json.Unmarshal(txs.Value(), tx); err != nil {
+			return fmt.Errorf("corrupt payout transaction: %w", err)
+		}
+		p.payoutTxs[tx.Hash] = tx
+	}
+	return txs.Error()
+}
+
+func payoutKey(id uint64) []byte {
+	return binary.BigEndian.AppendUint64(append([]byte(nil), payoutKeyPrefix...), id)
+}
+
+func payoutTxKey(hash [32]byte) []byte {
+	return append(append([]byte(nil), payoutTxKeyPrefix...), hash[:]...)
+}
@@ -0,0 +1,245 @@
+// Package mining - Signed share submission
+package mining
+
+import (
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"time"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/secp256k1"
+)
+
+// Share submission errors
+var (
+	ErrInvalidShareSignature = errors.New("invalid share signature")
+	ErrStaleJob              = errors.New("stale or unknown job")
+	ErrDuplicateShare        = errors.New("duplicate share")
+)
+
+// SignedShare is a share signed by the miner's payout key. The pool
+// identifies the miner from the signature alone, so any front-end can accept
+// it without session state and a leaked session ID cannot redirect rewards.
+// Hash is blockchain.MiningShareWork for the job, signer and nonce, or for
+// pool shares the hash of the pool's algorithm.
+type SignedShare struct {
+	JobID     string
+	Height    uint64
+	Nonce     uint64
+	Hash      [32]byte
+	Signature []byte // 65-byte r || s || v over Digest()
+
+	signer    [20]byte
+	recovered bool
+}
+
+// Payload returns the signed message; see blockchain.MiningSharePayload
+func (s *SignedShare) Payload() []byte {
+	return blockchain.MiningSharePayload(s.JobID, s.Height, s.Nonce, s.Hash)
+}
+
+// Digest returns the Keccak-256 hash of the payload
+func (s *SignedShare) Digest() [32]byte {
+	return blockchain.MiningShareDigest(s.Payload())
+}
+
+// Sign signs the submission with a secp256k1 private key
+func (s *SignedShare) Sign(priv []byte) error {
+	digest := s.Digest()
+	sig, err := secp256k1.Sign(digest[:], priv)
+	if err != nil {
+		return err
+	}
+	s.Signature = sig
+	s.recovered = false
+	return nil
+}
+
+// Signer recovers the address that signed the submission
+func (s *SignedShare) Signer() ([20]byte, error) {
+	if s.recovered {
+		return s.signer, nil
+	}
+	addr, err := blockchain.RecoverShareSigner(s.Digest(), s.Signature)
+	if err != nil {
+		return [20]byte{}, ErrInvalidShareSignature
+	}
+
+	s.signer = addr
+	s.recovered = true
+	return addr, nil
+}
+
+// JobID derives the job ID for work on top of a parent block; see
+// blockchain.MiningJobID
+func JobID(height uint64, parentHash [32]byte) string {
+	return blockchain.MiningJobID(height, parentHash)
+}
+
+// WorkerShare is a share whose proof of work a pool front-end checked
+// itself, for miners that cannot sign shares. Difficulty is the difficulty
+// the front-end set for the worker, at least the distributor's.
+type WorkerShare struct {
+	JobID      string
+	Height     uint64
+	Nonce      uint64
+	Hash       [32]byte
+	Difficulty *big.Int
+	PoolID     [20]byte // Pool the share is credited to, which pays the worker itself; zero to credit the worker
+}
+
+// SubmitSignedShare verifies a signed share against the chain and queues it
+// for the signer. Work for the next block and for the current head (one
+// block stale) is accepted. The hash must be the share's work at the
+// distributor's difficulty, which the chain checks again before it accrues
+// the reward. The miner's distributor session is looked up by address.
+func (d *Distributor) SubmitSignedShare(sub *SignedShare) (*Share, error) {
+	if err := d.verifyJob(sub.JobID, sub.Height); err != nil {
+		return nil, err
+	}
+	addr, err := sub.Signer()
+	if err != nil {
+		return nil, err
+	}
+	if sub.Hash != blockchain.MiningShareWork(sub.JobID, addr, sub.Nonce) {
+		return nil, ErrInvalidWork
+	}
+	share := &Share{
+		MinerAddr: addr,
+		Nonce:     sub.Nonce,
+		Hash:      sub.Hash,
+		JobHeight: sub.Height,
+		Signature: append([]byte(nil), sub.Signature...),
+		OnChain:   true,
+	}
+	return d.queueShare(share, sub.Digest())
+}
+
+// SubmitPoolShare queues a signed share whose work a pool checked for its
+// algorithm, credited to the pool, which pays the signer itself, or to the
+// signer if pool is zero. The chain cannot check algorithm work, so the
+// share counts against the distributor's caps but accrues nothing on chain.
+func (d *Distributor) SubmitPoolShare(sub *SignedShare, pool [20]byte) (*Share, error) {
+	if err := d.verifyJob(sub.JobID, sub.Height); err != nil {
+		return nil, err
+	}
+	addr, err := sub.Signer()
+	if err != nil {
+		return nil, err
+	}
+	if pool != ([20]byte{}) {
+		addr = pool
+	}
+	return d.queueShare(&Share{MinerAddr: addr, Nonce: sub.Nonce, Hash: sub.Hash, JobHeight: sub.Height, PoolID: pool}, sub.Digest())
+}
+
+// SubmitWorkerShare queues a share a front-end verified for addr, or for
+// its pool if the share names one. The job is checked as for signed
+// shares; the work itself is not, so like pool shares it accrues nothing
+// on chain.
+func (d *Distributor) SubmitWorkerShare(addr [20]byte, share *WorkerShare) (*Share, error) {
+	if err := d.verifyJob(share.JobID, share.Height); err != nil {
+		return nil, err
+	}
+	if share.PoolID != ([20]byte{}) {
+		addr = share.PoolID
+	}
+	// Keyed apart from signed share digests, which cover the hash too
+	key := sha256.Sum256(append([]byte("worker:"), share.Hash[:]...))
+	queued := &Share{
+		MinerAddr:  addr,
+		Nonce:      share.Nonce,
+		Hash:       share.Hash,
+		Difficulty: share.Difficulty,
+		JobHeight:  share.Height,
+		PoolID:     share.PoolID,
+	}
+	return d.queueShare(queued, key)
+}
+
+// Helper functions
+
+// queueShare submits a share unless key was seen before, filling in the
+// miner's session and the time. A nil difficulty means the distributor's,
+// which on-chain shares must meet.
+func (d *Distributor) queueShare(share *Share, key [32]byte) (*Share, error) {
+	d.mu.Lock()
+	if _, seen := d.seenShares[key]; seen {
+		d.mu.Unlock()
+		return nil, ErrDuplicateShare
+	}
+	if share.Difficulty == nil {
+		share.Difficulty = new(big.Int).Set(d.difficulty)
+	}
+	if share.OnChain && !blockchain.MeetsShareDifficulty(share.Hash, share.Difficulty) {
+		d.mu.Unlock()
+		return nil, ErrLowDifficulty
+	}
+	d.pruneSeenShares(share.JobHeight)
+	d.seenShares[key] = share.JobHeight
+	session := d.addressSession(share.MinerAddr)
+	share.Timestamp = time.Now()
+	share.HumanScore = session.HumanScore
+	share.SessionID = session.SessionID
+	d.mu.Unlock()
+
+	if err := d.SubmitShare(share); err != nil {
+		return nil, err
+	}
+	return share, nil
+}
+
+func (d *Distributor) verifyJob(jobID string, height uint64) error {
+	head := d.chain.GetCurrentBlock()
+	switch height {
+	case head.Header.Height + 1:
+		if jobID == JobID(height, head.Hash()) {
+			return nil
+		}
+	case head.Header.Height:
+		if height == 0 {
+			break
+		}
+		parent, err := d.chain.GetBlock(height - 1)
+		if err == nil && jobID == JobID(height, parent.Hash()) {
+			return nil
+		}
+	}
+	return ErrStaleJob
+}
+
+// addressSession returns the miner's session, creating it on first use. The
+// ID is derived from the address so it is the same on every front-end.
+// Callers must hold d.mu.
+func (d *Distributor) addressSession(addr [20]byte) *MinerSession {
+	sessionID := sha256.Sum256(append([]byte("session:"), addr[:]...))
+	if session, exists := d.sessions[sessionID]; exists {
+		return session
+	}
+
+	session := &MinerSession{
+		SessionID:         sessionID,
+		MinerAddr:         addr,
+		StartTime:         time.Now(),
+		TotalRewards:      big.NewInt(0),
+		CurrentDifficulty: d.difficulty,
+		HumanScore:        100,
+		LastShareTime:     time.Now(),
+	}
+	d.sessions[sessionID] = session
+	return session
+}
+
+// pruneSeenShares forgets shares for jobs that can no longer be accepted.
+// Callers must hold d.mu.
+func (d *Distributor) pruneSeenShares(height uint64) {
+	if height < 2 {
+		return
+	}
+	for digest, h := range d.seenShares {
+		if h < height-1 {
+			delete(d.seenShares, digest)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+// Package mining - Solo mining on block templates
+package mining
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"chaincore/internal/blockchain"
+)
+
+// soloDifficultyMultiple is how many shares' worth of work a winning solo
+// share takes
+const soloDifficultyMultiple = 64
+
+// maxSoloTemplates bounds the templates kept for live jobs
+const maxSoloTemplates = 4096
+
+// Solo mining errors
+var (
+	ErrUnknownTemplate  = errors.New("unknown or expired block template")
+	ErrTooManyTemplates = errors.New("too many block templates")
+)
+
+// SoloCoinbase is who a template's winning shares pay and how much
+type SoloCoinbase struct {
+	Address [20]byte
+	Reward  *big.Int
+}
+
+// BlockTemplate is the work on the next block for one solo miner. Its
+// header commits to the coinbase, so a winning nonce needs no signature.
+type BlockTemplate struct {
+	ID         string
+	JobID      string
+	Height     uint64
+	ParentHash [32]byte
+	Timestamp  uint64   // Parent block's timestamp, in seconds
+	MiningRoot [32]byte // Root of the shares pending for the block
+	Coinbase   SoloCoinbase
+	Difficulty *big.Int
+	HumanScore uint8 // Score the reward was computed with
+}
+
+// Header returns the bytes solo miners hash, followed by the nonce; see
+// blockchain.SoloHeader
+func (t *BlockTemplate) Header() []byte {
+	return blockchain.SoloHeader(t.ParentHash, t.Height, t.Timestamp, t.MiningRoot, t.Coinbase.Address, t.Coinbase.Reward, t.Difficulty)
+}
+
+// Hash returns the hash of the template's header with nonce
+func (t *BlockTemplate) Hash(nonce uint64) [32]byte {
+	return sha256.Sum256(append(t.Header(), uint64Bytes(nonce)...))
+}
+
+// Work returns the template as the node serves it to miners
+func (t *BlockTemplate) Work() map[string]interface{} {
+	return map[string]interface{}{
+		"templateId":    t.ID,
+		"jobId":         t.JobID,
+		"blockHeight":   t.Height,
+		"prevBlockHash": hex.EncodeToString(t.ParentHash[:]),
+		"timestamp":     t.Timestamp,
+		"miningRoot":    hex.EncodeToString(t.MiningRoot[:]),
+		"coinbase": map[string]interface{}{
+			"address": blockchain.ChecksumAddress(t.Coinbase.Address),
+			"reward":  t.Coinbase.Reward.String(),
+		},
+		"difficulty": t.Difficulty.String(),
+		"target":     fmt.Sprintf("%064x", shareTarget(t.Difficulty)),
+		"header":     hex.EncodeToString(t.Header()),
+	}
+}
+
+// GetBlockTemplate returns a solo template for the next block paying
+// address. Asking again returns the same template until the chain head,
+// the pending shares or the difficulty change.
+func (d *Distributor) GetBlockTemplate(address [20]byte) (*BlockTemplate, error) {
+	head := d.chain.GetCurrentBlock()
+	height := head.Header.Height + 1
+	parentHash := head.Hash()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	difficulty := new(big.Int).Mul(d.difficulty, big.NewInt(soloDifficultyMultiple))
+	session := d.addressSession(address)
+	pending := blockchain.SummarizeShares(d.blockShares)
+	template := &BlockTemplate{
+		JobID:      JobID(height, parentHash),
+		Height:     height,
+		ParentHash: parentHash,
+		Timestamp:  head.Header.Timestamp,
+		MiningRoot: pending.Root(),
+		Coinbase: SoloCoinbase{
+			Address: address,
+			Reward:  d.calculateReward(&Share{Difficulty: difficulty, HumanScore: session.HumanScore}),
+		},
+		Difficulty: difficulty,
+		HumanScore: session.HumanScore,
+	}
+	id := sha256.Sum256(template.Header())
+	template.ID = hex.EncodeToString(id[:8])
+
+	if existing, exists := d.templates[template.ID]; exists {
+		return existing, nil
+	}
+	d.pruneSoloTemplates(height)
+	if len(d.templates) >= maxSoloTemplates {
+		return nil, ErrTooManyTemplates
+	}
+	d.templates[template.ID] = template
+	return template, nil
+}
+
+// SubmitSoloShare checks a nonce found on a template and, if it wins, adds
+// the share to the next block the node proposes. It returns the reward. A
+// nonce submitted again is accepted but not credited again, so retries
+// are safe: the coinbase is fixed, so a replay can only pay the finder.
+func (d *Distributor) SubmitSoloShare(templateID string, nonce uint64) (*big.Int, error) {
+	d.mu.RLock()
+	template, exists := d.templates[templateID]
+	d.mu.RUnlock()
+	if !exists {
+		return nil, ErrUnknownTemplate
+	}
+	if err := d.verifyJob(template.JobID, template.Height); err != nil {
+		return nil, err
+	}
+	hash := template.Hash(nonce)
+	if !meetsDifficulty(hash, template.Difficulty) {
+		return nil, ErrLowDifficulty
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Keyed apart from signed share digests and worker share hashes
+	key := sha256.Sum256(append([]byte("solo:"), hash[:]...))
+	if _, seen := d.seenShares[key]; seen {
+		return new(big.Int).Set(template.Coinbase.Reward), nil
+	}
+	address := template.Coinbase.Address
+	if err := d.checkDailyCap(address); err != nil {
+		return nil, err
+	}
+	session := d.addressSession(address)
+	if session.TotalRewards.Cmp(d.sessionRewardCap()) >= 0 {
+		return nil, errors.New("session reward cap reached")
+	}
+	d.pruneSeenShares(template.Height)
+	d.seenShares[key] = template.Height
+
+	share := &Share{
+		MinerAddr:    address,
+		Nonce:        nonce,
+		Hash:         hash,
+		Difficulty:   new(big.Int).Set(template.Difficulty),
+		Timestamp:    time.Now(),
+		HumanScore:   template.HumanScore,
+		SessionID:    session.SessionID,
+		IsValid:      true,
+		JobHeight:    template.Height,
+		TemplateRoot: template.MiningRoot,
+		OnChain:      true,
+	}
+	reward := new(big.Int).Set(template.Coinbase.Reward)
+	d.recordShare(share, reward)
+	session.ShareCount++
+	session.ValidShares++
+	session.LastShareTime = share.Timestamp
+	return reward, nil
+}
+
+// Helper functions
+
+// pruneSoloTemplates forgets templates for jobs that can no longer be
+// accepted once work at height is issued. Callers must hold d.mu.
+func (d *Distributor) pruneSoloTemplates(height uint64) {
+	if height < 2 {
+		return
+	}
+	for id, template := range d.templates {
+		if template.Height < height-1 {
+			delete(d.templates, id)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+// Package mining - Checkpoints of distributor and pool state
+package mining
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/storage"
+)
+
+// checkpointInterval is how often the distributor and pool save their state
+const checkpointInterval = time.Minute
+
+// State storage keys
+var (
+	distributorStateKey = []byte("mining:distributor-state")
+	poolStateKey        = []byte("pool:state")
+)
+
+// distributorState is the distributor's checkpoint
+type distributorState struct {
+	Difficulty  *big.Int
+	Sessions    []*MinerSession
+	DailyStats  []*DailyStats
+	BlockShares []blockchain.MiningShare
+	SeenShares  []seenShare
+}
+
+type seenShare struct {
+	Key    [32]byte
+	Height uint64
+}
+
+// poolState is the pool's checkpoint
+type poolState struct {
+	Miners     []json.RawMessage
+	Stats      PoolStats
+	Window     []pplnsShare
+	RoundShare uint64
+	Rounds     []storedRound
+	NextRound  uint64
+	History    historySnapshot
+	Miner      []minerHistory
+	Shares     []registeredShare
+}
+
+// minerHistory is a miner's history as checkpointed
+type minerHistory struct {
+	Address [20]byte
+	History historySnapshot
+}
+
+// storedRound is a round with its window snapshot
+type storedRound struct {
+	Round
+	Weights []pplnsShare
+}
+
+// Helper functions
+
+// checkpointer saves the distributor's state every checkpointInterval, so a
+// crash loses at most the shares since the last checkpoint
+func (d *Distributor) checkpointer() {
+	ticker := time.NewTicker(checkpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stopCh:
+			return
+		case <-ticker.C:
+			if err := d.saveState(); err != nil {
+				logger.Error("Failed to save distributor state", "error", err)
+			}
+		}
+	}
+}
+
+// saveState writes the distributor's checkpoint
+func (d *Distributor) saveState() error {
+	d.mu.RLock()
+	state := distributorState{
+		Difficulty:  d.difficulty,
+		Sessions:    make([]*MinerSession, 0, len(d.sessions)),
+		DailyStats:  make([]*DailyStats, 0, len(d.dailyStats)),
+		BlockShares: d.blockShares,
+		SeenShares:  make([]seenShare, 0, len(d.seenShares)),
+	}
+	for _, session := range d.sessions {
+		state.Sessions = append(state.Sessions, session)
+	}
+	for _, stats := range d.dailyStats {
+		state.DailyStats = append(state.DailyStats, stats)
+	}
+	for key, height := range d.seenShares {
+		state.SeenShares = append(state.SeenShares, seenShare{Key: key, Height: height})
+	}
+	data, err := json.Marshal(state)
+	d.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return d.chain.Database().Put(distributorStateKey, data)
+}
+
+// loadState restores the distributor's checkpoint. Sessions created since
+// the distributor was made are kept.
+func (d *Distributor) loadState() error {
+	data, err := d.chain.Database().Get(distributorStateKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state distributorState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("corrupt distributor state: %w", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if state.Difficulty != nil && state.Difficulty.Sign() > 0 {
+		d.difficulty = state.Difficulty
+	}
+	for _, session := range state.Sessions {
+		if _, exists := d.sessions[session.SessionID]; !exists {
+			d.sessions[session.SessionID] = session
+		}
+	}
+	for _, stats := range state.DailyStats {
+		if _, exists := d.dailyStats[stats.Address]; !exists {
+			d.dailyStats[stats.Address] = stats
+		}
+	}
+	d.blockShares = append(state.BlockShares, d.blockShares...)
+	for _, seen := range state.SeenShares {
+		d.seenShares[seen.Key] = seen.Height
+	}
+	return nil
+}
+
+// checkpointer saves the pool's state every checkpointInterval
+func (p *Pool) checkpointer() {
+	ticker := time.NewTicker(checkpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case <-ticker.C:
+			if err := p.saveState(); err != nil {
+				logger.Error("Failed to save pool state", "error", err)
+			}
+		}
+	}
+}
+
+// saveState writes the pool's checkpoint. Miners are encoded one at a time
+// under their own locks, never while holding p.mu.
+func (p *Pool) saveState() error {
+	var state poolState
+
+	p.mu.RLock()
+	miners := make([]*PoolMiner, 0, len(p.miners))
+	for _, miner := range p.miners {
+		miners = append(miners, miner)
+	}
+	state.Stats = p.stats
+	state.Stats.TotalPaid = new(big.Int).Set(p.stats.TotalPaid)
+	state.Stats.PendingRewards = new(big.Int).Set(p.stats.PendingRewards)
+	state.Stats.TreasuryOwed = new(big.Int).Set(p.stats.TreasuryOwed)
+	state.Stats.TreasuryPaid = new(big.Int).Set(p.stats.TreasuryPaid)
+	state.Stats.Difficulty = nil
+	p.mu.RUnlock()
+
+	for _, miner := range miners {
+		miner.mu.Lock()
+		data, err := json.Marshal(miner)
+		miner.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		state.Miners = append(state.Miners, data)
+	}
+
+	l := &p.pplns
+	l.mu.Lock()
+	state.Window = append([]pplnsShare(nil), l.window...)
+	state.RoundShare = l.roundShares
+	state.NextRound = l.nextRound
+	for _, round := range l.rounds {
+		stored := storedRound{Round: *round, Weights: make([]pplnsShare, 0, len(round.weights))}
+		stored.Reward = new(big.Int).Set(round.Reward)
+		for miner, weight := range round.weights {
+			stored.Weights = append(stored.Weights, pplnsShare{Miner: miner, Weight: weight})
+		}
+		state.Rounds = append(state.Rounds, stored)
+	}
+	l.mu.Unlock()
+
+	p.history.mu.Lock()
+	state.History = p.history.pool.snapshot()
+	for address, h := range p.history.miners {
+		state.Miner = append(state.Miner, minerHistory{Address: address, History: h.snapshot()})
+	}
+	p.history.mu.Unlock()
+	state.Shares = p.shares.snapshot()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := p.chain.Database().Put(poolStateKey, data); err != nil {
+		return err
+	}
+	return p.saveAntiBot()
+}
+
+// loadState restores the pool's checkpoint, with every miner offline.
+// Callers must hold p.mu.
+func (p *Pool) loadState() error {
+	data, err := p.chain.Database().Get(poolStateKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state poolState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("corrupt pool state: %w", err)
+	}
+
+	for _, raw := range state.Miners {
+		miner := new(PoolMiner)
+		if err := json.Unmarshal(raw, miner); err != nil {
+			return fmt.Errorf("corrupt pool miner: %w", err)
+		}
+		if _, exists := p.miners[miner.Address]; exists {
+			continue
+		}
+		miner.IsOnline = false
+		miner.HashRate = 0
+		p.miners[miner.Address] = miner
+		p.sessions[miner.SessionID] = miner
+		p.escalate(miner)
+	}
+
+	p.stats.BlocksFound = state.Stats.BlocksFound
+	p.stats.LastBlockTime = state.Stats.LastBlockTime
+	if state.Stats.TotalPaid != nil {
+		p.stats.TotalPaid = state.Stats.TotalPaid
+	}
+	if state.Stats.PendingRewards != nil {
+		p.stats.PendingRewards = state.Stats.PendingRewards
+	}
+	if state.Stats.TreasuryOwed != nil {
+		p.stats.TreasuryOwed = state.Stats.TreasuryOwed
+	}
+	if state.Stats.TreasuryPaid != nil {
+		p.stats.TreasuryPaid = state.Stats.TreasuryPaid
+	}
+
+	p.history.mu.Lock()
+	p.history.pool.restore(state.History)
+	for _, saved := range state.Miner {
+		if _, exists := p.history.miners[saved.Address]; !exists {
+			p.history.miner(saved.Address).restore(saved.History)
+		}
+	}
+	p.history.mu.Unlock()
+	p.shares.restore(state.Shares)
+
+	l := &p.pplns
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, share := range state.Window {
+		l.window = append(l.window, share)
+		l.weights[share.Miner] += share.Weight
+		l.total += share.Weight
+	}
+	l.roundShares = state.RoundShare
+	l.nextRound = state.NextRound
+	for i := range state.Rounds {
+		round := state.Rounds[i].Round
+		if len(state.Rounds[i].Weights) > 0 {
+			round.weights = make(map[[20]byte]uint64, len(state.Rounds[i].Weights))
+			for _, share := range state.Rounds[i].Weights {
+				round.weights[share.Miner] = share.Weight
+			}
+		}
+		l.rounds = append(l.rounds, &round)
+	}
+	return nil
+}
@@ -0,0 +1,791 @@
+// Package mining - Stratum v1 server for external miners
+package mining
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/logging"
+)
+
+var logger = logging.New("mining")
+
+// Stratum defaults, used when the config leaves them zero
+const (
+	defaultStratumShareTime = 10 * time.Second
+	defaultRetarget         = time.Minute
+	defaultStratumIdle      = 5 * time.Minute
+)
+
+const (
+	extranonce1Size  = 4    // Bytes the server assigns each connection
+	extranonce2Size  = 4    // Bytes each miner rolls itself
+	stratumMaxLine   = 4096 // Longest request line accepted
+	stratumJobsKept  = 4    // Jobs a connection can still submit shares for
+	maxWorkerNameLen = 32
+	maxRetargetStep  = 4 // Vardiff changes a difficulty at most this factor at once
+	maxNTimeDrift    = 2 * time.Minute
+)
+
+// Stratum error codes, as sent in the error triple
+const (
+	stratumErrOther         = 20
+	stratumErrJobNotFound   = 21
+	stratumErrDuplicate     = 22
+	stratumErrLowDifficulty = 23
+	stratumErrUnauthorized  = 24
+	stratumErrNotSubscribed = 25
+	stratumErrChallenge     = 26 // Shares held until an anti-bot challenge is solved
+)
+
+// StratumConfig configures a Stratum listener
+type StratumConfig struct {
+	Addr             string        // Listen address, e.g. ":3333"
+	Algorithm        string        // Algorithm miners on this port use
+	TargetShareTime  time.Duration // Vardiff aims for one share per connection this often
+	RetargetInterval time.Duration // Shortest time between difficulty changes of a connection
+	MaxDifficulty    *big.Int      // Vardiff ceiling; none if nil
+	MaxConnections   int           // Unlimited if zero
+	IdleTimeout      time.Duration // Connections silent this long are closed
+}
+
+// StratumServer accepts Stratum v1 miners into a pool. Workers authorize as
+// "<payout address>.<worker>", and vardiff keeps each connection at about
+// one share per TargetShareTime.
+type StratumServer struct {
+	config   StratumConfig
+	pool     *Pool
+	listener net.Listener
+	conns    map[*stratumConn]struct{}
+	workers  map[[20]byte]int // Authorized connections by payout address
+	work     *Job             // Work on the current head
+	nonce1   uint32           // Last extranonce1 assigned
+	running  int32
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+}
+
+// stratumJob is work as sent to one connection
+type stratumJob struct {
+	id         string
+	work       *Job
+	ntime      uint32
+	difficulty *big.Int
+}
+
+// stratumConn is one miner connection
+type stratumConn struct {
+	server      *StratumServer
+	conn        net.Conn
+	extranonce1 [extranonce1Size]byte
+
+	subscribed  bool
+	userAgent   string              // Miner software, from mining.subscribe
+	workers     map[string][20]byte // Authorized worker names and their addresses
+	difficulty  *big.Int
+	jobs        []*stratumJob // Oldest first
+	nextJob     uint64
+	shares      int       // Shares since windowStart
+	windowStart time.Time // Start of the current vardiff window
+	mu          sync.Mutex
+
+	writeMu sync.Mutex
+}
+
+// stratumRequest is a request or notification from a miner
+type stratumRequest struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// stratumError is a failed request, sent as [code, message, null]
+type stratumError struct {
+	code    int
+	message string
+}
+
+func (e *stratumError) Error() string {
+	return e.message
+}
+
+func (e *stratumError) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.code, e.message, nil})
+}
+
+// NewStratumServer creates a Stratum server for pool
+func NewStratumServer(pool *Pool, config StratumConfig) *StratumServer {
+	if config.TargetShareTime <= 0 {
+		config.TargetShareTime = defaultStratumShareTime
+	}
+	if config.RetargetInterval <= 0 {
+		config.RetargetInterval = defaultRetarget
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = defaultStratumIdle
+	}
+	return &StratumServer{
+		config:  config,
+		pool:    pool,
+		conns:   make(map[*stratumConn]struct{}),
+		workers: make(map[[20]byte]int),
+	}
+}
+
+// Start listens for miners and sends them work as the chain advances
+func (s *StratumServer) Start() error {
+	if !ValidAlgorithm(s.config.Algorithm) {
+		return ErrUnknownAlgorithm
+	}
+	if !atomic.CompareAndSwapInt32(&s.running, 0, 1) {
+		return errors.New("stratum server already started")
+	}
+	listener, err := net.Listen("tcp", s.config.Addr)
+	if err != nil {
+		atomic.StoreInt32(&s.running, 0)
+		return fmt.Errorf("failed to listen for stratum miners: %w", err)
+	}
+
+	work, err := s.currentWork()
+	if err != nil {
+		listener.Close()
+		atomic.StoreInt32(&s.running, 0)
+		return err
+	}
+	s.mu.Lock()
+	s.listener = listener
+	s.work = work
+	s.mu.Unlock()
+
+	s.pool.chain.OnNewHead(s.onNewHead)
+	s.wg.Add(1)
+	go s.acceptConnections()
+	logger.Info("Stratum server listening", "addr", listener.Addr().String(), "algorithm", s.config.Algorithm)
+	return nil
+}
+
+// Stop closes the listener and every connection
+func (s *StratumServer) Stop() {
+	if !atomic.CompareAndSwapInt32(&s.running, 1, 0) {
+		return
+	}
+	s.listener.Close()
+
+	s.mu.Lock()
+	for c := range s.conns {
+		c.conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// Addr returns the address the server listens on once started
+func (s *StratumServer) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Connections returns the number of open miner connections
+func (s *StratumServer) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+func (s *StratumServer) acceptConnections() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if atomic.LoadInt32(&s.running) == 0 {
+				return
+			}
+			continue
+		}
+
+		s.mu.Lock()
+		full := s.config.MaxConnections > 0 && len(s.conns) >= s.config.MaxConnections
+		if full || atomic.LoadInt32(&s.running) == 0 {
+			s.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		s.nonce1++
+		c := &stratumConn{
+			server:  s,
+			conn:    conn,
+			workers: make(map[string][20]byte),
+		}
+		binary.BigEndian.PutUint32(c.extranonce1[:], s.nonce1)
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go c.serve()
+	}
+}
+
+// onNewHead sends every authorized connection work on the new head
+func (s *StratumServer) onNewHead(*blockchain.Block) {
+	if atomic.LoadInt32(&s.running) == 0 {
+		return
+	}
+
+	work, err := s.currentWork()
+	if err != nil {
+		logger.Warn("Failed to build stratum job", "error", err)
+		return
+	}
+	s.mu.Lock()
+	s.work = work
+	conns := make([]*stratumConn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range conns {
+		c.mu.Lock()
+		ready := c.subscribed && len(c.workers) > 0
+		c.mu.Unlock()
+		if ready {
+			c.retarget()
+			c.sendJob(true)
+		}
+	}
+}
+
+// currentWork builds the job on the chain head
+func (s *StratumServer) currentWork() (*Job, error) {
+	height := s.pool.chain.GetCurrentBlock().Header.Height + 1
+	return s.pool.jobAt(s.config.Algorithm, height, s.pool.distributor.GetDifficulty())
+}
+
+// authorize connects a worker's address to the pool. The address stays
+// connected while any of its workers is.
+func (s *StratumServer) authorize(address [20]byte, worker, ip, userAgent string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.workers[address] == 0 {
+		if _, err := s.pool.Connect(address, s.config.Algorithm, worker, ip, userAgent); err != nil {
+			return err
+		}
+	}
+	s.workers[address]++
+	return nil
+}
+
+// release undoes authorize for one worker
+func (s *StratumServer) release(address [20]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.workers[address]--
+	if s.workers[address] > 0 {
+		return
+	}
+	delete(s.workers, address)
+
+	s.pool.mu.RLock()
+	miner, exists := s.pool.miners[address]
+	s.pool.mu.RUnlock()
+	if exists {
+		s.pool.Disconnect(miner.SessionID)
+	}
+}
+
+// serve reads requests until the miner disconnects
+func (c *stratumConn) serve() {
+	defer c.server.wg.Done()
+	defer c.close()
+
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 0, 512), stratumMaxLine)
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(c.server.config.IdleTimeout))
+		if !scanner.Scan() {
+			return
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var req stratumRequest
+		if err := json.Unmarshal([]byte(line), &req); err != nil {
+			logger.Debug("Closing stratum connection after malformed request", "remote", c.conn.RemoteAddr().String())
+			return
+		}
+		result, err := c.handle(&req)
+		if len(req.ID) == 0 || string(req.ID) == "null" {
+			continue // Notifications get no response
+		}
+		if err != nil {
+			c.send(map[string]interface{}{"id": req.ID, "result": nil, "error": err})
+			continue
+		}
+		c.send(map[string]interface{}{"id": req.ID, "result": result, "error": nil})
+
+		// Work follows the first successful authorization
+		if req.Method == "mining.authorize" && result == true {
+			c.mu.Lock()
+			first := len(c.workers) == 1 && len(c.jobs) == 0
+			c.mu.Unlock()
+			if first {
+				c.sendDifficulty()
+				c.sendJob(true)
+			}
+		}
+	}
+}
+
+// close releases the connection's workers
+func (c *stratumConn) close() {
+	c.conn.Close()
+
+	c.server.mu.Lock()
+	delete(c.server.conns, c)
+	c.server.mu.Unlock()
+
+	c.mu.Lock()
+	workers := c.workers
+	c.workers = nil
+	c.mu.Unlock()
+	for _, address := range workers {
+		c.server.release(address)
+	}
+}
+
+func (c *stratumConn) handle(req *stratumRequest) (interface{}, *stratumError) {
+	var params []interface{}
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &stratumError{stratumErrOther, "params must be an array"}
+		}
+	}
+
+	switch req.Method {
+	case "mining.subscribe":
+		return c.subscribe(params)
+	case "mining.authorize":
+		return c.authorize(params)
+	case "mining.submit":
+		return c.submit(params)
+	case "mining.solve_challenge":
+		return c.solveChallenge(params)
+	case "mining.extranonce.subscribe":
+		return false, nil
+	default:
+		return nil, &stratumError{stratumErrOther, "unknown method " + req.Method}
+	}
+}
+
+func (c *stratumConn) subscribe(params []interface{}) (interface{}, *stratumError) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(params) > 0 {
+		c.userAgent, _ = params[0].(string)
+	}
+	if !c.subscribed {
+		c.subscribed = true
+		c.difficulty = c.server.pool.distributor.GetDifficulty()
+		c.windowStart = time.Now()
+	}
+	subscriptionID := hex.EncodeToString(c.extranonce1[:])
+	return []interface{}{
+		[][]string{
+			{"mining.set_difficulty", subscriptionID},
+			{"mining.notify", subscriptionID},
+		},
+		hex.EncodeToString(c.extranonce1[:]),
+		extranonce2Size,
+	}, nil
+}
+
+func (c *stratumConn) authorize(params []interface{}) (interface{}, *stratumError) {
+	c.mu.Lock()
+	subscribed := c.subscribed
+	c.mu.Unlock()
+	if !subscribed {
+		return nil, &stratumError{stratumErrNotSubscribed, "not subscribed"}
+	}
+	if len(params) < 1 {
+		return nil, &stratumError{stratumErrOther, "missing user name"}
+	}
+	user, _ := params[0].(string)
+	addressText, worker, _ := strings.Cut(user, ".")
+	if worker == "" {
+		worker = "default"
+	}
+	if !validWorkerName(worker) {
+		return nil, &stratumError{stratumErrUnauthorized, fmt.Sprintf("worker name must be 1 to %d letters, digits, '_' or '-'", maxWorkerNameLen)}
+	}
+	address, err := blockchain.ParseAddress(addressText, false)
+	if err != nil {
+		return nil, &stratumError{stratumErrUnauthorized, "invalid payout address: " + err.Error()}
+	}
+	if address == ([20]byte{}) {
+		return nil, &stratumError{stratumErrUnauthorized, "payouts to the zero address would be lost"}
+	}
+
+	c.mu.Lock()
+	_, known := c.workers[user]
+	c.mu.Unlock()
+	if known {
+		return true, nil
+	}
+
+	host, _, _ := net.SplitHostPort(c.conn.RemoteAddr().String())
+	c.mu.Lock()
+	userAgent := c.userAgent
+	c.mu.Unlock()
+	if err := c.server.authorize(address, worker, host, userAgent); err != nil {
+		return nil, &stratumError{stratumErrUnauthorized, err.Error()}
+	}
+	c.mu.Lock()
+	if c.workers == nil {
+		// The connection closed meanwhile
+		c.mu.Unlock()
+		c.server.release(address)
+		return nil, &stratumError{stratumErrOther, "connection closed"}
+	}
+	c.workers[user] = address
+	c.mu.Unlock()
+	return true, nil
+}
+
+func (c *stratumConn) submit(params []interface{}) (interface{}, *stratumError) {
+	fields := make([]string, len(params))
+	for i, param := range params {
+		field, ok := param.(string)
+		if !ok {
+			return nil, &stratumError{stratumErrOther, "params must be strings"}
+		}
+		fields[i] = field
+	}
+	if len(fields) < 2 {
+		return nil, &stratumError{stratumErrOther, "missing worker or job ID"}
+	}
+
+	c.mu.Lock()
+	address, authorized := c.workers[fields[0]]
+	job := c.findJob(fields[1])
+	c.mu.Unlock()
+	if !authorized {
+		return nil, &stratumError{stratumErrUnauthorized, "unauthorized worker"}
+	}
+	if job == nil {
+		return nil, &stratumError{stratumErrJobNotFound, "job not found"}
+	}
+
+	var nonce uint64
+	var hash [32]byte
+	var serr *stratumError
+	if job.work.Algorithm == AlgorithmKHeavyHash {
+		nonce, hash, serr = c.kHeavyHashShare(job, fields[2:])
+	} else {
+		nonce, hash, serr = c.sha256Share(job, fields[2:])
+	}
+	if serr != nil {
+		return nil, serr
+	}
+
+	_, _, err := c.server.pool.SubmitWorkerShare(address, &WorkerShare{
+		JobID:      job.work.ID,
+		Height:     job.work.Height,
+		Nonce:      nonce,
+		Hash:       hash,
+		Difficulty: job.difficulty,
+	})
+	switch {
+	case errors.Is(err, ErrChallengeRequired):
+		c.sendChallenge(address)
+		return nil, &stratumError{stratumErrChallenge, err.Error()}
+	case errors.Is(err, ErrDuplicateShare):
+		c.sendChallenge(address)
+		return nil, &stratumError{stratumErrDuplicate, "duplicate share"}
+	case errors.Is(err, ErrMinerBlacklisted):
+		return nil, &stratumError{stratumErrUnauthorized, err.Error()}
+	case errors.Is(err, ErrStaleJob):
+		return nil, &stratumError{stratumErrJobNotFound, "stale job"}
+	case err != nil:
+		return nil, &stratumError{stratumErrOther, err.Error()}
+	}
+
+	c.mu.Lock()
+	c.shares++
+	c.mu.Unlock()
+	if c.retarget() {
+		c.sendJob(false)
+	}
+	return true, nil
+}
+
+// solveChallenge answers the challenge of a worker's address with
+// [worker, id, nonce]
+func (c *stratumConn) solveChallenge(params []interface{}) (interface{}, *stratumError) {
+	if len(params) != 3 {
+		return nil, &stratumError{stratumErrOther, "expected [worker, id, nonce]"}
+	}
+	worker, _ := params[0].(string)
+	idText, _ := params[1].(string)
+	nonceText, _ := params[2].(string)
+
+	c.mu.Lock()
+	address, authorized := c.workers[worker]
+	c.mu.Unlock()
+	if !authorized {
+		return nil, &stratumError{stratumErrUnauthorized, "unauthorized worker"}
+	}
+	idBytes, err := decodeHexField(idText, 32)
+	if err != nil {
+		return nil, &stratumError{stratumErrOther, "invalid challenge ID: " + err.Error()}
+	}
+	nonceBytes, err := decodeHexField(nonceText, 8)
+	if err != nil {
+		return nil, &stratumError{stratumErrOther, "invalid nonce: " + err.Error()}
+	}
+
+	var id [32]byte
+	copy(id[:], idBytes)
+	next, err := c.server.pool.SolveChallenge(address, id, binary.BigEndian.Uint64(nonceBytes))
+	if err != nil {
+		return nil, &stratumError{stratumErrOther, err.Error()}
+	}
+	if next != nil {
+		c.notifyChallenge(next)
+	}
+	return true, nil
+}
+
+// kHeavyHashShare checks the [nonce] of a kHeavyHash share
+func (c *stratumConn) kHeavyHashShare(job *stratumJob, fields []string) (uint64, [32]byte, *stratumError) {
+	if len(fields) != 1 {
+		return 0, [32]byte{}, &stratumError{stratumErrOther, "expected [worker, jobId, nonce]"}
+	}
+	nonceBytes, err := decodeHexField(fields[0], 8)
+	if err != nil {
+		return 0, [32]byte{}, &stratumError{stratumErrOther, "invalid nonce: " + err.Error()}
+	}
+	if string(nonceBytes[:extranonce1Size]) != string(c.extranonce1[:]) {
+		return 0, [32]byte{}, &stratumError{stratumErrOther, "nonce does not start with extranonce1"}
+	}
+
+	nonce := binary.BigEndian.Uint64(nonceBytes)
+	hash := KHeavyHash(job.work.PrePowHash(), job.work.Timestamp, nonce)
+	if !meetsDifficultyLE(hash, job.difficulty) {
+		return 0, [32]byte{}, &stratumError{stratumErrLowDifficulty, "low difficulty share"}
+	}
+	return nonce, hash, nil
+}
+
+// sha256Share checks the [extranonce2, ntime, nonce] of a SHA-256 share
+func (c *stratumConn) sha256Share(job *stratumJob, fields []string) (uint64, [32]byte, *stratumError) {
+	if len(fields) != 3 {
+		return 0, [32]byte{}, &stratumError{stratumErrOther, "expected [worker, jobId, extranonce2, ntime, nonce]"}
+	}
+	extranonce2, err := decodeHexField(fields[0], extranonce2Size)
+	if err != nil {
+		return 0, [32]byte{}, &stratumError{stratumErrOther, "invalid extranonce2: " + err.Error()}
+	}
+	ntimeBytes, err := decodeHexField(fields[1], 4)
+	if err != nil {
+		return 0, [32]byte{}, &stratumError{stratumErrOther, "invalid ntime: " + err.Error()}
+	}
+	nonceBytes, err := decodeHexField(fields[2], 8)
+	if err != nil {
+		return 0, [32]byte{}, &stratumError{stratumErrOther, "invalid nonce: " + err.Error()}
+	}
+	ntime := binary.BigEndian.Uint32(ntimeBytes)
+	if ntime < job.ntime || time.Unix(int64(ntime), 0).After(time.Now().Add(maxNTimeDrift)) {
+		return 0, [32]byte{}, &stratumError{stratumErrOther, "ntime out of range"}
+	}
+
+	nonce := binary.BigEndian.Uint64(nonceBytes)
+	data := make([]byte, 0, 32+8+4+extranonce1Size+extranonce2Size+8)
+	data = append(data, job.work.ParentHash[:]...)
+	data = append(data, uint64Bytes(job.work.Height)...)
+	data = binary.BigEndian.AppendUint32(data, ntime)
+	data = append(data, c.extranonce1[:]...)
+	data = append(data, extranonce2...)
+	data = append(data, uint64Bytes(nonce)...)
+	hash := sha256.Sum256(data)
+	if !meetsDifficulty(hash, job.difficulty) {
+		return 0, [32]byte{}, &stratumError{stratumErrLowDifficulty, "low difficulty share"}
+	}
+	return nonce, hash, nil
+}
+
+// retarget applies vardiff once the window is long enough, sending the new
+// difficulty if it changed. The caller sends a job to apply it.
+func (c *stratumConn) retarget() bool {
+	c.mu.Lock()
+	elapsed := time.Since(c.windowStart)
+	if elapsed < c.server.config.RetargetInterval {
+		c.mu.Unlock()
+		return false
+	}
+
+	// Time per share, counting the time since the last one as a share
+	// about to be found
+	perShare := elapsed / time.Duration(c.shares+1)
+	next := scaleDifficulty(c.difficulty, float64(c.server.config.TargetShareTime)/float64(perShare))
+	if floor := c.server.pool.distributor.GetDifficulty(); next.Cmp(floor) < 0 {
+		next = floor
+	}
+	if ceiling := c.server.config.MaxDifficulty; ceiling != nil && next.Cmp(ceiling) > 0 {
+		next = new(big.Int).Set(ceiling)
+	}
+	c.shares = 0
+	c.windowStart = time.Now()
+	changed := next.Cmp(c.difficulty) != 0
+	c.difficulty = next
+	c.mu.Unlock()
+
+	if changed {
+		c.sendDifficulty()
+	}
+	return changed
+}
+
+func (c *stratumConn) sendDifficulty() {
+	c.mu.Lock()
+	difficulty, _ := new(big.Float).SetInt(c.difficulty).Float64()
+	c.mu.Unlock()
+	c.send(map[string]interface{}{"id": nil, "method": "mining.set_difficulty", "params": []interface{}{difficulty}})
+}
+
+// sendJob sends the current work at the connection's difficulty.
+// cleanJobs tells the miner to drop work on earlier jobs.
+func (c *stratumConn) sendJob(cleanJobs bool) {
+	c.server.mu.Lock()
+	work := c.server.work
+	c.server.mu.Unlock()
+
+	c.mu.Lock()
+	c.nextJob++
+	job := &stratumJob{
+		id:         strconv.FormatUint(c.nextJob, 16),
+		work:       work,
+		ntime:      uint32(time.Now().Unix()),
+		difficulty: new(big.Int).Set(c.difficulty),
+	}
+	c.jobs = append(c.jobs, job)
+	if len(c.jobs) > stratumJobsKept {
+		c.jobs = c.jobs[len(c.jobs)-stratumJobsKept:]
+	}
+	c.mu.Unlock()
+
+	var params []interface{}
+	if work.Algorithm == AlgorithmKHeavyHash {
+		params = []interface{}{job.id, hex.EncodeToString(work.Header()), fmt.Sprintf("%016x", work.Timestamp), cleanJobs}
+	} else {
+		params = []interface{}{job.id, hex.EncodeToString(work.ParentHash[:]), fmt.Sprintf("%016x", work.Height), fmt.Sprintf("%08x", job.ntime), cleanJobs}
+	}
+	c.send(map[string]interface{}{"id": nil, "method": "mining.notify", "params": params})
+}
+
+// sendChallenge sends the challenge the pool holds address's shares for,
+// if there is one
+func (c *stratumConn) sendChallenge(address [20]byte) {
+	if challenge, _ := c.server.pool.GetChallenge(address); challenge != nil {
+		c.notifyChallenge(challenge)
+	}
+}
+
+func (c *stratumConn) notifyChallenge(challenge *Challenge) {
+	c.send(map[string]interface{}{"id": nil, "method": "mining.challenge", "params": []interface{}{
+		hex.EncodeToString(challenge.ID[:]), challenge.Difficulty, challenge.ExpiresAt.Unix(),
+	}})
+}
+
+// send writes one message. A failed write closes the connection, which
+// ends serve.
+func (c *stratumConn) send(msg interface{}) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := c.conn.Write(data); err != nil {
+		c.conn.Close()
+	}
+}
+
+// Helper functions
+
+// findJob returns the connection's job with id. Callers must hold c.mu.
+func (c *stratumConn) findJob(id string) *stratumJob {
+	for _, job := range c.jobs {
+		if job.id == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// scaleDifficulty multiplies difficulty by factor, bounded by
+// maxRetargetStep either way
+func scaleDifficulty(difficulty *big.Int, factor float64) *big.Int {
+	if factor > maxRetargetStep {
+		factor = maxRetargetStep
+	} else if factor < 1.0/maxRetargetStep {
+		factor = 1.0 / maxRetargetStep
+	}
+	scaled, _ := new(big.Float).Mul(new(big.Float).SetInt(difficulty), big.NewFloat(factor)).Int(nil)
+	if scaled.Sign() <= 0 {
+		scaled.SetInt64(1)
+	}
+	return scaled
+}
+
+// decodeHexField decodes a hex field of exactly size bytes
+func decodeHexField(field string, size int) ([]byte, error) {
+	data, err := hex.DecodeString(strings.TrimPrefix(field, "0x"))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != size {
+		return nil, fmt.Errorf("expected %d bytes, got %d", size, len(data))
+	}
+	return data, nil
+}
+
+func validWorkerName(name string) bool {
+	if len(name) == 0 || len(name) > maxWorkerNameLen {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,125 @@
+package mining
+
+import (
+	"bufio"
+	"encoding/json"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/storage"
+)
+
+// startTestStratum starts a pool for up to maxMiners addresses and a
+// kHeavyHash Stratum listener on a free local port
+func startTestStratum(t *testing.T, maxMiners int) (*Pool, *StratumServer) {
+	t.Helper()
+	db, err := storage.NewMemoryLevelDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	chain, err := blockchain.NewBlockchain(db, blockchain.Config{ChainID: 1, MinGasPrice: 1, ValidatorMinStake: big.NewInt(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	distributor := NewDistributor(chain, Config{MinDifficulty: big.NewInt(1), MaxDifficulty: big.NewInt(1)})
+	pool := NewPool(chain, distributor, PoolConfig{MinPayout: big.NewInt(0), MaxMiners: maxMiners, Enabled: true})
+
+	stratum := NewStratumServer(pool, StratumConfig{Addr: "127.0.0.1:0", Algorithm: AlgorithmKHeavyHash})
+	if err := stratum.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(stratum.Stop)
+	return pool, stratum
+}
+
+// stratumClient speaks line-delimited Stratum JSON to a test server
+type stratumClient struct {
+	t      *testing.T
+	conn   net.Conn
+	lines  *bufio.Scanner
+	nextID int
+}
+
+func dialStratum(t *testing.T, stratum *StratumServer) *stratumClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", stratum.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &stratumClient{t: t, conn: conn, lines: bufio.NewScanner(conn)}
+}
+
+// call sends a request and returns its response, skipping notifications
+// received before it
+func (c *stratumClient) call(method string, params ...interface{}) map[string]json.RawMessage {
+	c.t.Helper()
+	c.nextID++
+	request, _ := json.Marshal(map[string]interface{}{"id": c.nextID, "method": method, "params": params})
+	if _, err := c.conn.Write(append(request, '\n')); err != nil {
+		c.t.Fatal(err)
+	}
+	for {
+		msg := c.read()
+		var id int
+		if json.Unmarshal(msg["id"], &id) == nil && id == c.nextID {
+			return msg
+		}
+	}
+}
+
+// notification reads the next message, which must be a notification
+func (c *stratumClient) notification() string {
+	c.t.Helper()
+	var method string
+	json.Unmarshal(c.read()["method"], &method)
+	return method
+}
+
+func (c *stratumClient) read() map[string]json.RawMessage {
+	c.t.Helper()
+	if !c.lines.Scan() {
+		c.t.Fatalf("connection closed: %v", c.lines.Err())
+	}
+	var msg map[string]json.RawMessage
+	if err := json.Unmarshal(c.lines.Bytes(), &msg); err != nil {
+		c.t.Fatalf("invalid message %q: %v", c.lines.Text(), err)
+	}
+	return msg
+}
+
+func TestStratumClientAuthorizesAndGetsWork(t *testing.T) {
+	pool, stratum := startTestStratum(t, 1)
+	client := dialStratum(t, stratum)
+
+	response := client.call("mining.subscribe", "test-miner/1.0")
+	var subscription []json.RawMessage
+	if err := json.Unmarshal(response["result"], &subscription); err != nil || len(subscription) != 3 {
+		t.Fatalf("subscribe result %s", response["result"])
+	}
+
+	response = client.call("mining.authorize", "0x00000000000000000000000000000000000000a1.rig1", "x")
+	if string(response["result"]) != "true" {
+		t.Fatalf("authorize: %s %s", response["result"], response["error"])
+	}
+	got := []string{client.notification(), client.notification()}
+	if got[0] != "mining.set_difficulty" || got[1] != "mining.notify" {
+		t.Fatalf("notifications after authorize: %v", got)
+	}
+	if stratum.Connections() != 1 || pool.GetPoolStats().ActiveMiners != 1 {
+		t.Fatalf("%d connections, %d miners", stratum.Connections(), pool.GetPoolStats().ActiveMiners)
+	}
+
+	// The pool is full, so a second payout address is turned away
+	other := dialStratum(t, stratum)
+	other.call("mining.subscribe")
+	response = other.call("mining.authorize", "0x00000000000000000000000000000000000000b2.rig1", "x")
+	if string(response["result"]) == "true" {
+		t.Fatal("authorized a miner past MaxMiners")
+	}
+}
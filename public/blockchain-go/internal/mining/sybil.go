@@ -0,0 +1,243 @@
+// Package mining - Origin correlation and Sybil clustering
+package mining
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Origin correlation limits
+const (
+	originWindow             = time.Hour   // How long an address counts towards its origins
+	originSweepInterval      = time.Minute // How often idle origins are dropped
+	maxIPAddresses           = 4           // Addresses one IP may mine for undamped
+	maxSubnetAddresses       = 16          // Addresses one subnet may mine for undamped
+	maxFingerprintAddresses  = 32          // Addresses one fingerprint may mine for undamped
+	maxSubnetSharesPerMinute = 600         // Shares a subnet may submit a minute
+)
+
+// Origin kinds
+const (
+	OriginIP          = "ip"
+	OriginSubnet      = "subnet"
+	OriginFingerprint = "fingerprint"
+)
+
+// ErrSubnetRateLimited is returned for shares over a subnet's rate limit
+var ErrSubnetRateLimited = errors.New("subnet share rate limit exceeded")
+
+// OriginReport is what the engine found about a submission's origin
+type OriginReport struct {
+	IPAddresses          int     // Addresses seen from the IP
+	SubnetAddresses      int     // Addresses seen from the subnet
+	FingerprintAddresses int     // Addresses seen with the fingerprint
+	Dampening            float64 // Factor to scale rewards by, 1 for none
+	RateLimited          bool    // The subnet is over its share rate
+}
+
+// OriginCluster is the addresses seen from one origin over its limit. Its
+// rewards are dampened by the limit over its size, so rotating addresses
+// earns no more than the limit would.
+type OriginCluster struct {
+	Kind      string // OriginIP, OriginSubnet or OriginFingerprint
+	Origin    string
+	Addresses [][20]byte
+	Limit     int
+}
+
+// originActivity is what an origin submitted within the window
+type originActivity struct {
+	kind      string
+	origin    string
+	addresses map[[20]byte]time.Time // Last submission of each address
+	shares    []time.Time            // Submissions within the last minute, for subnets
+}
+
+// Correlate records a submission by addr from ip with userAgent and
+// reports the origin's clusters. Submissions without a valid IP are not
+// correlated.
+func (ab *AntiBotEngine) Correlate(addr [20]byte, ip, userAgent string) OriginReport {
+	report := OriginReport{Dampening: 1}
+	parsed := parseOriginIP(ip)
+	if parsed == nil {
+		return report
+	}
+
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(ab.lastSweep) >= originSweepInterval {
+		ab.sweepOrigins(now)
+		ab.sweepRecords(now)
+		ab.lastSweep = now
+	}
+
+	ipOrigin, subnet, fingerprint := originKeys(parsed, userAgent)
+	report.IPAddresses = ab.recordOrigin(OriginIP, ipOrigin, addr, now)
+	report.SubnetAddresses = ab.recordOrigin(OriginSubnet, subnet, addr, now)
+	report.FingerprintAddresses = ab.recordOrigin(OriginFingerprint, fingerprint, addr, now)
+
+	activity := ab.origins[OriginSubnet+":"+subnet]
+	cutoff := now.Add(-time.Minute)
+	kept := activity.shares[:0]
+	for _, at := range activity.shares {
+		if at.After(cutoff) {
+			kept = append(kept, at)
+		}
+	}
+	activity.shares = kept
+	if len(activity.shares) >= maxSubnetSharesPerMinute {
+		report.RateLimited = true
+	} else {
+		activity.shares = append(activity.shares, now)
+	}
+
+	for _, level := range []struct{ count, limit int }{
+		{report.IPAddresses, maxIPAddresses},
+		{report.SubnetAddresses, maxSubnetAddresses},
+		{report.FingerprintAddresses, maxFingerprintAddresses},
+	} {
+		if level.count > level.limit {
+			if factor := float64(level.limit) / float64(level.count); factor < report.Dampening {
+				report.Dampening = factor
+			}
+		}
+	}
+	return report
+}
+
+// Clusters lists the origins with more addresses than their limit, largest
+// first
+func (ab *AntiBotEngine) Clusters() []OriginCluster {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	now := time.Now()
+	ab.sweepOrigins(now)
+	ab.lastSweep = now
+
+	var clusters []OriginCluster
+	for _, activity := range ab.origins {
+		limit := originLimit(activity.kind)
+		if len(activity.addresses) <= limit {
+			continue
+		}
+		cluster := OriginCluster{Kind: activity.kind, Origin: activity.origin, Limit: limit}
+		for addr := range activity.addresses {
+			cluster.Addresses = append(cluster.Addresses, addr)
+		}
+		sort.Slice(cluster.Addresses, func(i, j int) bool {
+			return string(cluster.Addresses[i][:]) < string(cluster.Addresses[j][:])
+		})
+		clusters = append(clusters, cluster)
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		if len(clusters[i].Addresses) != len(clusters[j].Addresses) {
+			return len(clusters[i].Addresses) > len(clusters[j].Addresses)
+		}
+		return clusters[i].Kind+clusters[i].Origin < clusters[j].Kind+clusters[j].Origin
+	})
+	return clusters
+}
+
+// GetClusters returns the Sybil clusters among the pool's miners
+func (p *Pool) GetClusters() []OriginCluster {
+	return p.antiBot.Clusters()
+}
+
+// Helper functions
+
+// recordOrigin notes a submission by addr from an origin and returns the
+// addresses seen from it. Callers must hold ab.mu.
+func (ab *AntiBotEngine) recordOrigin(kind, origin string, addr [20]byte, now time.Time) int {
+	key := kind + ":" + origin
+	activity, exists := ab.origins[key]
+	if !exists {
+		activity = &originActivity{kind: kind, origin: origin, addresses: make(map[[20]byte]time.Time)}
+		ab.origins[key] = activity
+	}
+	activity.addresses[addr] = now
+	return len(activity.addresses)
+}
+
+// sweepOrigins forgets addresses idle for originWindow and origins left
+// without any. Callers must hold ab.mu.
+func (ab *AntiBotEngine) sweepOrigins(now time.Time) {
+	cutoff := now.Add(-originWindow)
+	for key, activity := range ab.origins {
+		for addr, last := range activity.addresses {
+			if last.Before(cutoff) {
+				delete(activity.addresses, addr)
+			}
+		}
+		if len(activity.addresses) == 0 {
+			delete(ab.origins, key)
+		}
+	}
+}
+
+// dampenReward scales a reward by a dampening factor
+func dampenReward(reward *big.Int, factor float64) *big.Int {
+	if factor >= 1 {
+		return reward
+	}
+	scaled := new(big.Int).Mul(reward, big.NewInt(int64(factor*1e6)))
+	return scaled.Div(scaled, big.NewInt(1e6))
+}
+
+// dampenWeight scales a share weight by a dampening factor, rounding up or
+// down at random so the expected weight is exact
+func dampenWeight(weight int64, factor float64) int64 {
+	if factor >= 1 {
+		return weight
+	}
+	scaled := float64(weight) * factor
+	whole := int64(scaled)
+	if rand.Float64() < scaled-float64(whole) {
+		whole++
+	}
+	return whole
+}
+
+func originLimit(kind string) int {
+	switch kind {
+	case OriginIP:
+		return maxIPAddresses
+	case OriginSubnet:
+		return maxSubnetAddresses
+	default:
+		return maxFingerprintAddresses
+	}
+}
+
+// parseOriginIP parses an IP address, with or without a port
+func parseOriginIP(ip string) net.IP {
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	return net.ParseIP(ip)
+}
+
+// originKeys returns the IP, subnet and fingerprint origins of a
+// submission
+func originKeys(ip net.IP, userAgent string) (string, string, string) {
+	subnetBits, networkBits, size := 64, 48, 128
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+		subnetBits, networkBits, size = 24, 16, 32
+	}
+	subnet := net.IPNet{IP: ip.Mask(net.CIDRMask(subnetBits, size)), Mask: net.CIDRMask(subnetBits, size)}
+	network := net.IPNet{IP: ip.Mask(net.CIDRMask(networkBits, size)), Mask: net.CIDRMask(networkBits, size)}
+
+	agent := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(userAgent))))
+	fingerprint := network.String() + "/" + hex.EncodeToString(agent[:8])
+	return ip.String(), subnet.String(), fingerprint
+}
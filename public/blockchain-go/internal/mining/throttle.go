@@ -0,0 +1,267 @@
+// Package mining - Thermal and battery aware throttling for the lite miner
+package mining
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Throttling parameters
+const (
+	dutyPeriod         = 100 * time.Millisecond // Length of one work and rest cycle
+	dutyCheckInterval  = 256                    // Hashes between checks of a cycle's work time
+	minCPUTarget       = 0.05                   // Least CPU share a target may set
+	thermalHysteresis  = 5.0                    // Degrees below the limit a device must cool to resume
+	seriousThermalDuty = 0.5                    // Duty scale under serious thermal state
+)
+
+// ThermalState is the thermal pressure a device reports, after the states
+// mobile platforms expose
+type ThermalState string
+
+// Thermal states, in rising order of pressure
+const (
+	ThermalNominal  ThermalState = "nominal"
+	ThermalFair     ThermalState = "fair"
+	ThermalSerious  ThermalState = "serious"
+	ThermalCritical ThermalState = "critical"
+)
+
+// Reasons mining is paused
+const (
+	PauseBattery    = "on battery"
+	PauseLowBattery = "battery low"
+	PauseThermal    = "thermal state critical"
+	PauseOverheated = "temperature limit reached"
+)
+
+// ErrInvalidCPUTarget is returned for CPU targets below minCPUTarget or
+// above 1
+var ErrInvalidCPUTarget = fmt.Errorf("cpu target must be between %v and 1", minCPUTarget)
+
+// BatteryStatus is a device's battery as its wrapper reports it
+type BatteryStatus struct {
+	Charging bool `json:"charging"` // On external power
+	Level    int  `json:"level"`    // Charge percent
+}
+
+// ThermalStatus is a device's thermal state as its wrapper reports it
+type ThermalStatus struct {
+	State       ThermalState `json:"state"`
+	Temperature float64      `json:"temperature,omitempty"` // Degrees Celsius, 0 if not known
+}
+
+// ThrottleStatus describes the throttle's current settings and state
+type ThrottleStatus struct {
+	CPUTarget        float64        `json:"cpuTarget"`
+	BatteryCPUTarget float64        `json:"batteryCpuTarget"`
+	DutyCycle        float64        `json:"dutyCycle"` // Share of each cycle threads hash for, 0 when paused
+	Paused           bool           `json:"paused"`
+	PauseReason      string         `json:"pauseReason,omitempty"`
+	Battery          *BatteryStatus `json:"battery,omitempty"`
+	Thermal          *ThermalStatus `json:"thermal,omitempty"`
+}
+
+// throttle decides how much of each cycle mining threads work
+type throttle struct {
+	cpuTarget        float64
+	batteryCPUTarget float64
+	pauseOnBattery   bool
+	minBatteryLevel  int
+	maxTemperature   float64 // 0 for no limit
+	battery          *BatteryStatus
+	thermal          *ThermalStatus
+	overheated       bool          // Reached maxTemperature and not yet cooled
+	resume           chan struct{} // Closed when mining resumes, nil unless paused
+	mu               sync.Mutex
+}
+
+// ReportBattery updates the battery state throttling follows
+func (m *LiteMiner) ReportBattery(status BatteryStatus) error {
+	if status.Level < 0 || status.Level > 100 {
+		return errors.New("battery level must be between 0 and 100")
+	}
+
+	m.throttle.mu.Lock()
+	defer m.throttle.mu.Unlock()
+
+	m.throttle.battery = &status
+	m.throttle.update()
+	return nil
+}
+
+// ReportThermal updates the thermal state throttling follows
+func (m *LiteMiner) ReportThermal(status ThermalStatus) error {
+	switch status.State {
+	case ThermalNominal, ThermalFair, ThermalSerious, ThermalCritical:
+	default:
+		return fmt.Errorf("unknown thermal state %q", status.State)
+	}
+
+	m.throttle.mu.Lock()
+	defer m.throttle.mu.Unlock()
+
+	t := &m.throttle
+	t.thermal = &status
+	if t.maxTemperature > 0 && status.Temperature > 0 {
+		if status.Temperature >= t.maxTemperature {
+			t.overheated = true
+		} else if status.Temperature <= t.maxTemperature-thermalHysteresis {
+			t.overheated = false
+		}
+	}
+	t.update()
+	return nil
+}
+
+// SetCPUTargets sets the share of CPU time each mining thread may use on
+// external power and on battery
+func (m *LiteMiner) SetCPUTargets(cpuTarget, batteryCPUTarget float64) error {
+	if !validCPUTarget(cpuTarget) || !validCPUTarget(batteryCPUTarget) {
+		return ErrInvalidCPUTarget
+	}
+
+	m.throttle.mu.Lock()
+	defer m.throttle.mu.Unlock()
+
+	m.throttle.cpuTarget = cpuTarget
+	m.throttle.batteryCPUTarget = batteryCPUTarget
+	return nil
+}
+
+// ThrottleStatus returns the throttle's settings and state
+func (m *LiteMiner) ThrottleStatus() ThrottleStatus {
+	m.throttle.mu.Lock()
+	defer m.throttle.mu.Unlock()
+
+	t := &m.throttle
+	duty, reason := t.duty()
+	status := ThrottleStatus{
+		CPUTarget:        t.cpuTarget,
+		BatteryCPUTarget: t.batteryCPUTarget,
+		DutyCycle:        duty,
+		Paused:           reason != "",
+		PauseReason:      reason,
+	}
+	if t.battery != nil {
+		battery := *t.battery
+		status.Battery = &battery
+	}
+	if t.thermal != nil {
+		thermal := *t.thermal
+		status.Thermal = &thermal
+	}
+	return status
+}
+
+// GetRawHashRate returns the hash rate over the time threads spent
+// hashing, what the miner would reach unthrottled
+func (m *LiteMiner) GetRawHashRate() float64 {
+	busy := time.Duration(atomic.LoadInt64(&m.busyTime)).Seconds()
+	if busy <= 0 {
+		return 0
+	}
+	// Busy time adds up over threads
+	return float64(atomic.LoadUint64(&m.hashCount)) / busy * float64(m.config.Threads)
+}
+
+// Helper functions
+
+// configure sets the throttle from the miner's config, defaulting CPU
+// targets that are unset or invalid to full use
+func (t *throttle) configure(config LiteMinerConfig) {
+	t.cpuTarget = config.CPUTarget
+	t.batteryCPUTarget = config.BatteryCPUTarget
+	t.pauseOnBattery = config.PauseOnBattery
+	t.minBatteryLevel = config.MinBatteryLevel
+	t.maxTemperature = config.MaxTemperature
+	if !validCPUTarget(t.cpuTarget) {
+		t.cpuTarget = 1
+	}
+	if !validCPUTarget(t.batteryCPUTarget) {
+		t.batteryCPUTarget = t.cpuTarget
+	}
+}
+
+func validCPUTarget(target float64) bool {
+	return target >= minCPUTarget && target <= 1
+}
+
+// current returns the duty cycle threads work at, or, while mining is
+// paused, a channel closed once it resumes
+func (t *throttle) current() (float64, <-chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.resume != nil {
+		return 0, t.resume
+	}
+	duty, _ := t.duty()
+	return duty, nil
+}
+
+// duty returns the duty cycle the sensors allow, or 0 and why mining is
+// paused: the CPU target for the power source, halved under serious thermal
+// pressure. Callers must hold t.mu.
+func (t *throttle) duty() (float64, string) {
+	target := t.cpuTarget
+	if battery := t.battery; battery != nil && !battery.Charging {
+		switch {
+		case t.pauseOnBattery:
+			return 0, PauseBattery
+		case battery.Level < t.minBatteryLevel:
+			return 0, PauseLowBattery
+		}
+		target = t.batteryCPUTarget
+	}
+	if t.overheated {
+		return 0, PauseOverheated
+	}
+	if thermal := t.thermal; thermal != nil {
+		switch thermal.State {
+		case ThermalCritical:
+			return 0, PauseThermal
+		case ThermalSerious:
+			target *= seriousThermalDuty
+		}
+	}
+	return target, ""
+}
+
+// update pauses or resumes mining as the state requires. Callers must
+// hold t.mu.
+func (t *throttle) update() {
+	_, reason := t.duty()
+	switch {
+	case reason != "" && t.resume == nil:
+		t.resume = make(chan struct{})
+	case reason == "" && t.resume != nil:
+		close(t.resume)
+		t.resume = nil
+	}
+}
+
+// rest holds a thread that worked for part of a cycle until the next. It
+// returns false once the miner stops.
+func (m *LiteMiner) rest(worked time.Duration, duty float64) bool {
+	if duty >= 1 {
+		select {
+		case <-m.stopCh:
+			return false
+		default:
+			return true
+		}
+	}
+
+	timer := time.NewTimer(dutyPeriod - worked)
+	defer timer.Stop()
+	select {
+	case <-m.stopCh:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
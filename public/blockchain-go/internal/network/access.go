@@ -0,0 +1,175 @@
+// Package network - Peer allow/deny lists for private networks
+package network
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// AccessConfig restricts which peers may connect. Deny rules take
+// precedence; when an allowlist is non-empty, only matching peers are
+// accepted. Node IDs are asserted by the remote side during the handshake,
+// so private deployments should pair node ID rules with CIDR rules.
+type AccessConfig struct {
+	AllowCIDRs   []string // e.g. "10.0.0.0/8", or a bare IP
+	DenyCIDRs    []string
+	AllowNodeIDs []string // Hex node IDs
+	DenyNodeIDs  []string
+}
+
+// PeerFilter enforces an AccessConfig
+type PeerFilter struct {
+	allowNets []*net.IPNet
+	denyNets  []*net.IPNet
+	allowIDs  map[string]bool
+	denyIDs   map[string]bool
+}
+
+// Errors returned when a peer is refused
+var (
+	ErrPeerIPDenied     = errors.New("peer address is not permitted")
+	ErrPeerNodeIDDenied = errors.New("peer node ID is not permitted")
+)
+
+// NewPeerFilter parses an access configuration
+func NewPeerFilter(config AccessConfig) (*PeerFilter, error) {
+	f := &PeerFilter{
+		allowIDs: make(map[string]bool),
+		denyIDs:  make(map[string]bool),
+	}
+
+	var err error
+	if f.allowNets, err = parseCIDRs(config.AllowCIDRs); err != nil {
+		return nil, err
+	}
+	if f.denyNets, err = parseCIDRs(config.DenyCIDRs); err != nil {
+		return nil, err
+	}
+	if err := parseNodeIDs(config.AllowNodeIDs, f.allowIDs); err != nil {
+		return nil, err
+	}
+	if err := parseNodeIDs(config.DenyNodeIDs, f.denyIDs); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// CheckIP reports whether a remote address may connect
+func (f *PeerFilter) CheckIP(ip net.IP) error {
+	if ip == nil {
+		return ErrPeerIPDenied
+	}
+	for _, n := range f.denyNets {
+		if n.Contains(ip) {
+			return ErrPeerIPDenied
+		}
+	}
+	if len(f.allowNets) == 0 {
+		return nil
+	}
+	for _, n := range f.allowNets {
+		if n.Contains(ip) {
+			return nil
+		}
+	}
+	return ErrPeerIPDenied
+}
+
+// CheckNodeID reports whether a node ID may connect
+func (f *PeerFilter) CheckNodeID(id string) error {
+	id = strings.ToLower(id)
+	if f.denyIDs[id] {
+		return ErrPeerNodeIDDenied
+	}
+	if len(f.allowIDs) > 0 && !f.allowIDs[id] {
+		return ErrPeerNodeIDDenied
+	}
+	return nil
+}
+
+// LoadOrCreateNodeID reads a node ID from path, creating one if the file does
+// not exist. A stable ID lets other operators allowlist this node.
+func LoadOrCreateNodeID(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		id := strings.ToLower(strings.TrimSpace(string(data)))
+		if !validNodeID(id) {
+			return "", fmt.Errorf("invalid node ID in %s", path)
+		}
+		return id, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	id := generateNodeID()
+	if err := os.WriteFile(path, []byte(id+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Helper functions
+func parseCIDRs(entries []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		// Accept bare addresses as single-host networks
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", entry)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func parseNodeIDs(entries []string, out map[string]bool) error {
+	for _, entry := range entries {
+		id := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(entry), "0x"))
+		if id == "" {
+			continue
+		}
+		if !validNodeID(id) {
+			return fmt.Errorf("invalid node ID %q", entry)
+		}
+		out[id] = true
+	}
+	return nil
+}
+
+func validNodeID(id string) bool {
+	b, err := hex.DecodeString(id)
+	return err == nil && len(b) == nodeIDLength
+}
+
+// remoteIP extracts the IP of a connection's remote address
+func remoteIP(conn net.Conn) net.IP {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP
+	}
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,108 @@
+// Package network - Protocol version negotiation and peer capabilities
+package network
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Protocol versions. Each side sends its version in the handshake and the
+// connection speaks the lower of the two, so a new version can be rolled out
+// node by node as long as MinProtocolVersion stays put. Version 1 handshakes
+// carried no version or capabilities and cannot be parsed by this one;
+// version 3 adds compact block announcements.
+const (
+	ProtocolVersion    uint16 = 3
+	MinProtocolVersion uint16 = 2
+)
+
+// CompactBlocksVersion is the first protocol version whose peers accept
+// MsgCompactBlock; older peers are sent block hashes
+const CompactBlocksVersion uint16 = 3
+
+// Capabilities is a set of services a node offers its peers
+type Capabilities uint32
+
+// Capability flags advertised in the handshake
+const (
+	CapServesHistory        Capabilities = 1 << iota // Serves historical blocks and receipts
+	CapServesStateSnapshots                          // Serves state snapshots for fast sync
+	CapRelay                                         // Relays blocks and transactions between peers
+	CapMiningPool                                    // Accepts mining shares
+)
+
+var capabilityNames = []struct {
+	cap  Capabilities
+	name string
+}{
+	{CapServesHistory, "serves-history"},
+	{CapServesStateSnapshots, "serves-state-snapshots"},
+	{CapRelay, "relay"},
+	{CapMiningPool, "mining-pool"},
+}
+
+// Has reports whether every capability in want is set
+func (c Capabilities) Has(want Capabilities) bool {
+	return c&want == want
+}
+
+// String lists the capability names, e.g. "serves-history,relay"
+func (c Capabilities) String() string {
+	names := make([]string, 0, len(capabilityNames))
+	for _, cn := range capabilityNames {
+		if c.Has(cn.cap) {
+			names = append(names, cn.name)
+		}
+	}
+	if unknown := c &^ knownCapabilities(); unknown != 0 {
+		names = append(names, fmt.Sprintf("0x%x", uint32(unknown)))
+	}
+	return strings.Join(names, ",")
+}
+
+// PeersWithCapability returns snapshots of the connected peers offering all
+// of the given capabilities, so syncing nodes can pick peers that serve what
+// they need
+func (n *P2PNetwork) PeersWithCapability(want Capabilities) []*Peer {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	peers := make([]*Peer, 0)
+	for _, p := range n.peers {
+		if p.info.Capabilities.Has(want) {
+			peers = append(peers, p.snapshot())
+		}
+	}
+	return peers
+}
+
+// Capabilities returns the capabilities this node advertises
+func (n *P2PNetwork) Capabilities() Capabilities {
+	caps := n.config.Capabilities
+	if n.config.EnableRelay {
+		caps |= CapRelay
+	}
+	return caps
+}
+
+// Helper functions
+
+func knownCapabilities() Capabilities {
+	var all Capabilities
+	for _, cn := range capabilityNames {
+		all |= cn.cap
+	}
+	return all
+}
+
+// negotiateVersion picks the protocol version for a connection, refusing
+// peers older than MinProtocolVersion
+func negotiateVersion(remote uint16) (uint16, error) {
+	if remote < MinProtocolVersion {
+		return 0, fmt.Errorf("peer protocol version %d is below minimum %d", remote, MinProtocolVersion)
+	}
+	if remote < ProtocolVersion {
+		return remote, nil
+	}
+	return ProtocolVersion, nil
+}
@@ -4,9 +4,14 @@ package network
 import (
 	"context"
 	"crypto/rand"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
+	"fmt"
+	"io"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -27,18 +32,39 @@ type Config struct {
 	EnableRelay    bool
 	EnableRPCProxy bool
 	BootstrapNodes []string
+	NodeID         string       // Hex node ID; generated if empty
+	Access         AccessConfig // Peer allow/deny lists
+	Capabilities   Capabilities // Services advertised to peers; CapRelay is implied by EnableRelay
+	Transport      Transport    // Carries peer connections; TCP if nil
 }
 
-// Peer represents a connected peer
+// nodeIDLength is the size of a node ID in bytes
+const nodeIDLength = 32
+
+// helloLength is the size of a handshake: node ID, node type, protocol
+// version and capability flags
+const helloLength = nodeIDLength + 1 + 2 + 4
+
+// handshakeTimeout bounds the node ID exchange on a new connection
+const handshakeTimeout = 10 * time.Second
+
+// Peer describes a connected peer. GetPeers returns copies, so callers may
+// read them freely.
 type Peer struct {
-	ID          string
-	Address     string
-	NodeType    NodeType
-	Connected   time.Time
-	LastSeen    time.Time
-	Latency     time.Duration
-	BytesSent   uint64
-	BytesRecv   uint64
+	ID           string
+	Address      string
+	NodeType     NodeType
+	Version      uint16       // Protocol version negotiated for this connection
+	Capabilities Capabilities // Services the peer advertised
+	Connected    time.Time
+	LastSeen     time.Time
+	Latency      time.Duration
+	BytesSent    uint64
+	BytesRecv    uint64
+	MessagesSent uint64
+	MessagesRecv uint64
+	Sent         map[string]TrafficCount // Traffic by message type name
+	Received     map[string]TrafficCount
 }
 
 // Message represents a P2P message
@@ -64,21 +90,32 @@ const (
 	MsgValidatorVote
 	MsgMiningShare
 	MsgPeerDiscovery
+	MsgCompactBlock
 )
 
-// P2PNetwork manages P2P connections
+// P2PNetwork manages P2P connections. Handlers are registered before Start
+// and read without locking afterwards; each peer's connection is owned by
+// its own reader and writer goroutines.
 type P2PNetwork struct {
 	config      Config
 	nodeID      string
-	peers       map[string]*Peer
+	peers       map[string]*peerConn
 	listener    net.Listener
 	messagesCh  chan *Message
-	handlers    map[MessageType]MessageHandler
+	handlers    map[MessageType]MessageHandler // Immutable once started
+	started     bool
+	filter      *PeerFilter
 	mu          sync.RWMutex
 	ctx         context.Context
 	cancel      context.CancelFunc
 }
 
+// Network errors
+var (
+	ErrNetworkStarted = errors.New("handlers must be registered before the network starts")
+	ErrUnknownPeer    = errors.New("peer not connected")
+)
+
 // MessageHandler handles incoming messages
 type MessageHandler func(*Message) error
 
@@ -86,24 +123,48 @@ type MessageHandler func(*Message) error
 func NewP2PNetwork(config Config) (*P2PNetwork, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	
-	nodeID := generateNodeID()
-	
+	nodeID := config.NodeID
+	if nodeID == "" {
+		nodeID = generateNodeID()
+	} else if !validNodeID(nodeID) {
+		cancel()
+		return nil, errors.New("invalid node ID")
+	}
+
+	filter, err := NewPeerFilter(config.Access)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if config.Transport == nil {
+		config.Transport = tcpTransport{}
+	}
+
 	return &P2PNetwork{
 		config:     config,
 		nodeID:     nodeID,
-		peers:      make(map[string]*Peer),
+		peers:      make(map[string]*peerConn),
 		messagesCh: make(chan *Message, 1000),
 		handlers:   make(map[MessageType]MessageHandler),
+		filter:     filter,
 		ctx:        ctx,
 		cancel:     cancel,
 	}, nil
 }
 
-// Start starts the P2P network
+// Start starts the P2P network. The handler set is frozen from here on.
 func (n *P2PNetwork) Start() error {
-	// Start TCP listener
+	n.mu.Lock()
+	if n.started {
+		n.mu.Unlock()
+		return errors.New("network already started")
+	}
+	n.started = true
+	n.mu.Unlock()
+
+	// Start listener
 	addr := fmt.Sprintf("0.0.0.0:%d", n.config.Port)
-	listener, err := net.Listen("tcp", addr)
+	listener, err := n.config.Transport.Listen(addr)
 	if err != nil {
 		return err
 	}
@@ -131,11 +192,17 @@ func (n *P2PNetwork) Stop() {
 		n.listener.Close()
 	}
 	
-	n.mu.Lock()
+	n.mu.RLock()
+	peers := make([]*peerConn, 0, len(n.peers))
 	for _, peer := range n.peers {
+		peers = append(peers, peer)
+	}
+	n.mu.RUnlock()
+
+	// Each peer's reader removes it from the map as it exits
+	for _, peer := range peers {
 		n.disconnectPeer(peer)
 	}
-	n.mu.Unlock()
 }
 
 // acceptConnections accepts incoming connections
@@ -154,68 +221,108 @@ func (n *P2PNetwork) acceptConnections() {
 	}
 }
 
+// NodeID returns this node's ID
+func (n *P2PNetwork) NodeID() string {
+	return n.nodeID
+}
+
 // handleConnection handles a new connection
 func (n *P2PNetwork) handleConnection(conn net.Conn) {
+	// Refuse filtered addresses before spending anything on a handshake
+	if err := n.filter.CheckIP(remoteIP(conn)); err != nil {
+		conn.Close()
+		return
+	}
+
 	// Perform handshake
-	peer, err := n.performHandshake(conn)
+	info, err := n.performHandshake(conn)
 	if err != nil {
 		conn.Close()
 		return
 	}
 
-	// Check max peers
-	n.mu.Lock()
-	if len(n.peers) >= n.config.MaxPeers {
-		n.mu.Unlock()
+	peer := newPeerConn(info, conn)
+	if err := n.addPeer(peer); err != nil {
 		conn.Close()
 		return
 	}
-	n.peers[peer.ID] = peer
-	n.mu.Unlock()
 
 	// Handle peer messages
-	n.handlePeerMessages(conn, peer)
+	n.runPeer(peer)
 }
 
-// performHandshake performs the handshake protocol
+// performHandshake exchanges node IDs, node types, protocol versions and
+// capabilities, then applies the node ID allow/deny lists
 func (n *P2PNetwork) performHandshake(conn net.Conn) (*Peer, error) {
-	// Exchange node IDs and capabilities
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	id, _ := hex.DecodeString(n.nodeID)
+	hello := append(id, byte(n.config.NodeType))
+	hello = binary.BigEndian.AppendUint16(hello, ProtocolVersion)
+	hello = binary.BigEndian.AppendUint32(hello, uint32(n.Capabilities()))
+	if _, err := conn.Write(hello); err != nil {
+		return nil, err
+	}
+
+	remote := make([]byte, helloLength)
+	if _, err := io.ReadFull(conn, remote); err != nil {
+		return nil, err
+	}
+	remoteID := hex.EncodeToString(remote[:nodeIDLength])
+	if remoteID == n.nodeID {
+		return nil, errors.New("connected to self")
+	}
+	if err := n.filter.CheckNodeID(remoteID); err != nil {
+		return nil, err
+	}
+	version, err := negotiateVersion(binary.BigEndian.Uint16(remote[nodeIDLength+1:]))
+	if err != nil {
+		return nil, err
+	}
+
 	peer := &Peer{
-		ID:        generateNodeID(),
-		Address:   conn.RemoteAddr().String(),
-		Connected: time.Now(),
-		LastSeen:  time.Now(),
+		ID:           remoteID,
+		Address:      conn.RemoteAddr().String(),
+		NodeType:     NodeType(remote[nodeIDLength]),
+		Version:      version,
+		Capabilities: Capabilities(binary.BigEndian.Uint32(remote[nodeIDLength+3:])),
+		Connected:    time.Now(),
+		LastSeen:     time.Now(),
 	}
 	return peer, nil
 }
 
-// handlePeerMessages handles messages from a peer
-func (n *P2PNetwork) handlePeerMessages(conn net.Conn, peer *Peer) {
-	defer n.removePeer(peer.ID)
-	
-	buffer := make([]byte, 1024*1024) // 1MB buffer
-	
+// runPeer starts the peer's writer and runs its reader until the connection
+// closes, then removes the peer
+func (n *P2PNetwork) runPeer(peer *peerConn) {
+	defer n.removePeer(peer)
+	defer peer.close()
+
+	go peer.writeLoop()
+	n.handlePeerMessages(peer)
+}
+
+// handlePeerMessages reads messages from a peer. It runs on the peer's
+// reader goroutine, the only one reading from the connection.
+func (n *P2PNetwork) handlePeerMessages(peer *peerConn) {
 	for {
+		peer.conn.SetReadDeadline(time.Now().Add(peerReadTimeout))
+		msg, size, err := readMessage(peer.conn)
+		if err != nil {
+			return
+		}
+
+		peer.recv.add(msg.Type, size)
+		peer.lastSeen.Store(time.Now().UnixNano())
+
+		msg.From = peer.info.ID
 		select {
+		case n.messagesCh <- msg:
+		case <-peer.done:
+			return
 		case <-n.ctx.Done():
 			return
-		default:
-			conn.SetReadDeadline(time.Now().Add(time.Minute))
-			nBytes, err := conn.Read(buffer)
-			if err != nil {
-				return
-			}
-			
-			peer.BytesRecv += uint64(nBytes)
-			peer.LastSeen = time.Now()
-			
-			// Parse and handle message
-			msg, err := parseMessage(buffer[:nBytes])
-			if err != nil {
-				continue
-			}
-			msg.From = peer.ID
-			n.messagesCh <- msg
 		}
 	}
 }
@@ -234,9 +341,17 @@ func (n *P2PNetwork) processMessages() {
 	}
 }
 
-// RegisterHandler registers a message handler
-func (n *P2PNetwork) RegisterHandler(msgType MessageType, handler MessageHandler) {
+// RegisterHandler registers a message handler. Handlers must be registered
+// before Start.
+func (n *P2PNetwork) RegisterHandler(msgType MessageType, handler MessageHandler) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.started {
+		return ErrNetworkStarted
+	}
 	n.handlers[msgType] = handler
+	return nil
 }
 
 // BroadcastBlock broadcasts a new block to all peers
@@ -248,58 +363,102 @@ func (n *P2PNetwork) BroadcastBlock(blockHash []byte) error {
 	return n.broadcast(msg)
 }
 
-// BroadcastTx broadcasts a new transaction
-func (n *P2PNetwork) BroadcastTx(txHash []byte) error {
+// BroadcastVote gossips an encoded validator vote to all peers
+func (n *P2PNetwork) BroadcastVote(vote []byte) error {
+	msg := &Message{
+		Type:    MsgValidatorVote,
+		Payload: vote,
+	}
+	return n.broadcast(msg)
+}
+
+// BroadcastTx gossips an encoded pending transaction to all peers
+func (n *P2PNetwork) BroadcastTx(tx []byte) error {
 	msg := &Message{
 		Type:    MsgTxAnnounce,
-		Payload: txHash,
+		Payload: tx,
 	}
 	return n.broadcast(msg)
 }
 
-// broadcast sends a message to all peers
+// broadcast queues a message for all peers. Peers whose send queue is full
+// are skipped rather than allowed to stall the broadcast.
 func (n *P2PNetwork) broadcast(msg *Message) error {
 	n.mu.RLock()
-	defer n.mu.RUnlock()
-
+	peers := make([]*peerConn, 0, len(n.peers))
 	for _, peer := range n.peers {
-		go n.sendToPeer(peer, msg)
+		peers = append(peers, peer)
+	}
+	n.mu.RUnlock()
+
+	for _, peer := range peers {
+		n.sendToPeer(peer, msg)
 	}
 	return nil
 }
 
-// sendToPeer sends a message to a specific peer
-func (n *P2PNetwork) sendToPeer(peer *Peer, msg *Message) error {
-	// Serialize and send message
-	return nil
+// SendTo queues a message for the connected peer with the given ID, such as
+// the reply to a request it sent
+func (n *P2PNetwork) SendTo(peerID string, msg *Message) error {
+	n.mu.RLock()
+	peer, exists := n.peers[peerID]
+	n.mu.RUnlock()
+
+	if !exists {
+		return ErrUnknownPeer
+	}
+	return n.sendToPeer(peer, msg)
+}
+
+// sendToPeer queues a message for a specific peer
+func (n *P2PNetwork) sendToPeer(peer *peerConn, msg *Message) error {
+	return peer.send(msg)
 }
 
 // connectToBootstrapNodes connects to bootstrap nodes
 func (n *P2PNetwork) connectToBootstrapNodes() {
-	for _, addr := range n.config.BootstrapNodes {
-		go n.connectToPeer(addr)
+	for _, target := range n.config.BootstrapNodes {
+		go n.connectToPeer(target)
 	}
 }
 
-// connectToPeer connects to a peer
-func (n *P2PNetwork) connectToPeer(addr string) error {
-	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+// connectToPeer connects to a peer at a host:port address or enode URL,
+// and returns it once the handshake is done
+func (n *P2PNetwork) connectToPeer(target string) (*Peer, error) {
+	wantID, addr, err := parseEnode(target)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	peer, err := n.performHandshake(conn)
+	ctx, cancel := context.WithTimeout(n.ctx, 10*time.Second)
+	defer cancel()
+	conn, err := n.config.Transport.DialContext(ctx, "tcp", addr)
 	if err != nil {
+		return nil, err
+	}
+	if err := n.filter.CheckIP(remoteIP(conn)); err != nil {
 		conn.Close()
-		return err
+		return nil, err
 	}
 
-	n.mu.Lock()
-	n.peers[peer.ID] = peer
-	n.mu.Unlock()
+	info, err := n.performHandshake(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if wantID != "" && info.ID != wantID {
+		conn.Close()
+		return nil, fmt.Errorf("peer at %s has node ID %s, not %s", addr, info.ID, wantID)
+	}
 
-	go n.handlePeerMessages(conn, peer)
-	return nil
+	peer := newPeerConn(info, conn)
+	if err := n.addPeer(peer); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go n.runPeer(peer)
+	return peer.snapshot(), nil
 }
 
 // peerDiscoveryLoop runs periodic peer discovery
@@ -331,30 +490,105 @@ func (n *P2PNetwork) discoverPeers() {
 	n.broadcast(msg)
 }
 
-// removePeer removes a peer
-func (n *P2PNetwork) removePeer(id string) {
+// addPeer registers a peer after its handshake, enforcing MaxPeers and
+// refusing a second connection from the same node
+func (n *P2PNetwork) addPeer(peer *peerConn) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.ctx.Err() != nil {
+		return errors.New("network stopped")
+	}
+	if len(n.peers) >= n.config.MaxPeers {
+		return errors.New("too many peers")
+	}
+	if _, exists := n.peers[peer.info.ID]; exists {
+		return errors.New("peer already connected")
+	}
+	n.peers[peer.info.ID] = peer
+	return nil
+}
+
+// removePeer removes a peer, unless its ID has since been taken by a newer
+// connection
+func (n *P2PNetwork) removePeer(peer *peerConn) {
 	n.mu.Lock()
-	delete(n.peers, id)
+	if n.peers[peer.info.ID] == peer {
+		delete(n.peers, peer.info.ID)
+	}
 	n.mu.Unlock()
 }
 
 // disconnectPeer disconnects a peer
-func (n *P2PNetwork) disconnectPeer(peer *Peer) {
-	// Close connection
+func (n *P2PNetwork) disconnectPeer(peer *peerConn) {
+	peer.close()
 }
 
-// GetPeers returns connected peers
+// GetPeers returns snapshots of the connected peers
 func (n *P2PNetwork) GetPeers() []*Peer {
 	n.mu.RLock()
 	defer n.mu.RUnlock()
 
 	peers := make([]*Peer, 0, len(n.peers))
 	for _, p := range n.peers {
-		peers = append(peers, p)
+		peers = append(peers, p.snapshot())
 	}
 	return peers
 }
 
+// AddPeer connects to a peer at a host:port address, or at an
+// enode://<node ID>@host:port URL whose node ID the peer must present. It
+// returns the peer once the handshake is done.
+func (n *P2PNetwork) AddPeer(target string) (*Peer, error) {
+	n.mu.RLock()
+	started := n.started
+	n.mu.RUnlock()
+	if !started {
+		return nil, errors.New("network not started")
+	}
+	return n.connectToPeer(target)
+}
+
+// RemovePeer disconnects a peer. It may reconnect, or be reconnected to
+// through discovery, unless its node ID is denied.
+func (n *P2PNetwork) RemovePeer(id string) error {
+	n.mu.RLock()
+	peer, exists := n.peers[id]
+	n.mu.RUnlock()
+
+	if !exists {
+		return ErrUnknownPeer
+	}
+	n.disconnectPeer(peer)
+	return nil
+}
+
+// ListenPort returns the port the network accepts peers on once started,
+// which differs from Config.Port if that was 0
+func (n *P2PNetwork) ListenPort() int {
+	if n.listener != nil {
+		if tcpAddr, ok := n.listener.Addr().(*net.TCPAddr); ok {
+			return tcpAddr.Port
+		}
+		if _, port, err := net.SplitHostPort(n.listener.Addr().String()); err == nil {
+			if p, err := strconv.Atoi(port); err == nil {
+				return p
+			}
+		}
+	}
+	return n.config.Port
+}
+
+// Enode returns the node's enode URL, which other operators pass to
+// admin_addPeer or list as bootstrap nodes. The host is host if given,
+// or the loopback address, as the node does not know its public address.
+func (n *P2PNetwork) Enode(host string) string {
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return fmt.Sprintf("enode://%s@%s", n.nodeID, net.JoinHostPort(host, strconv.Itoa(n.ListenPort())))
+}
+
 // GetPeerCount returns the number of connected peers
 func (n *P2PNetwork) GetPeerCount() int {
 	n.mu.RLock()
@@ -364,11 +598,27 @@ func (n *P2PNetwork) GetPeerCount() int {
 
 // Helper functions
 func generateNodeID() string {
-	bytes := make([]byte, 32)
+	bytes := make([]byte, nodeIDLength)
 	rand.Read(bytes)
 	return hex.EncodeToString(bytes)
 }
 
+// parseEnode splits an enode://<node ID>@host:port URL into the node ID
+// and address. A plain host:port address has no node ID.
+func parseEnode(target string) (id, addr string, err error) {
+	rest, ok := strings.CutPrefix(target, "enode://")
+	if !ok {
+		return "", target, nil
+	}
+	id, addr, ok = strings.Cut(rest, "@")
+	if !ok || !validNodeID(id) {
+		return "", "", fmt.Errorf("invalid enode URL: %s", target)
+	}
+	// Drop query parameters such as ?discport=
+	addr, _, _ = strings.Cut(addr, "?")
+	return strings.ToLower(id), addr, nil
+}
+
 func parseMessage(data []byte) (*Message, error) {
 	if len(data) < 1 {
 		return nil, errors.New("empty message")
@@ -378,6 +628,3 @@ func parseMessage(data []byte) (*Message, error) {
 		Payload: data[1:],
 	}, nil
 }
-
-// Required import
-import "fmt"
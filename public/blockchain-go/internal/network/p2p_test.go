@@ -0,0 +1,154 @@
+package network
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startTestNetwork starts a network on transport with handlers registered
+func startTestNetwork(t *testing.T, transport Transport, handlers map[MessageType]MessageHandler) *P2PNetwork {
+	t.Helper()
+	n, err := NewP2PNetwork(Config{MaxPeers: 16, Transport: transport})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for msgType, handler := range handlers {
+		if err := n.RegisterHandler(msgType, handler); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := n.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(n.Stop)
+	return n
+}
+
+// waitFor polls cond until it holds, failing the test after five seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Run with -race: peers connect, disconnect and exchange messages while the
+// hub broadcasts and lists its peers
+func TestConcurrentPeerChurn(t *testing.T) {
+	transport := NewMemoryTransport()
+	var received atomic.Int64
+	hub := startTestNetwork(t, transport, map[MessageType]MessageHandler{
+		MsgTxAnnounce: func(*Message) error { received.Add(1); return nil },
+	})
+	enode := hub.Enode("")
+
+	clients := make([]*P2PNetwork, 8)
+	final := make([]atomic.Bool, len(clients))
+	for i := range clients {
+		final := &final[i]
+		clients[i] = startTestNetwork(t, transport, map[MessageType]MessageHandler{
+			MsgBlockAnnounce: func(msg *Message) error {
+				if string(msg.Payload) == "final" {
+					final.Store(true)
+				}
+				return nil
+			},
+		})
+	}
+
+	done := make(chan struct{})
+	var background sync.WaitGroup
+	background.Add(2)
+	go func() {
+		defer background.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				hub.BroadcastBlock([]byte("churn"))
+			}
+		}
+	}()
+	go func() {
+		defer background.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				for _, peer := range hub.GetPeers() {
+					_ = peer.MessagesRecv
+				}
+				hub.GetPeerCount()
+			}
+		}
+	}()
+
+	var churn sync.WaitGroup
+	for i, client := range clients {
+		churn.Add(1)
+		go func(i int, client *P2PNetwork) {
+			defer churn.Done()
+			for round := 0; round < 5; round++ {
+				peer, err := client.AddPeer(enode)
+				if err != nil {
+					continue
+				}
+				client.BroadcastTx([]byte{byte(i), byte(round)})
+				if round%2 == 0 {
+					hub.RemovePeer(client.NodeID())
+				} else {
+					client.RemovePeer(peer.ID)
+				}
+			}
+		}(i, client)
+	}
+	churn.Wait()
+	close(done)
+	background.Wait()
+
+	// Every client can still connect and hear the hub. The hub may list a
+	// link for a while after the client dropped it, so clients reconnect
+	// until the broadcast arrives.
+	reconnect := func() bool {
+		connected := true
+		for _, client := range clients {
+			if client.GetPeerCount() == 0 {
+				client.AddPeer(enode)
+				connected = false
+			}
+		}
+		return connected
+	}
+	waitFor(t, "all clients to reconnect", func() bool {
+		return reconnect() && hub.GetPeerCount() == len(clients)
+	})
+	waitFor(t, "the final broadcast", func() bool {
+		reconnect()
+		hub.BroadcastBlock([]byte("final"))
+		for i := range final {
+			if !final[i].Load() {
+				return false
+			}
+		}
+		return true
+	})
+	waitFor(t, "the hub to handle a transaction", func() bool {
+		clients[0].BroadcastTx([]byte("final"))
+		return received.Load() > 0
+	})
+}
+
+func TestRegisterHandlerAfterStart(t *testing.T) {
+	n := startTestNetwork(t, NewMemoryTransport(), nil)
+	if err := n.RegisterHandler(MsgPing, func(*Message) error { return nil }); err != ErrNetworkStarted {
+		t.Fatalf("handler registered after start: %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+// Package network - Peer connections and message framing
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Peer connection limits
+const (
+	maxMessageSize   = 1024 * 1024 // Type byte plus payload
+	peerSendQueue    = 256
+	peerReadTimeout  = time.Minute
+	peerWriteTimeout = 10 * time.Second
+)
+
+// ErrPeerQueueFull is returned when a peer is not draining its send queue
+var ErrPeerQueueFull = errors.New("peer send queue full")
+
+// peerConn owns a peer's connection. The reader goroutine is the only one
+// reading from conn and the writer goroutine, fed by sendCh, the only one
+// writing to it; traffic counters are atomics so snapshots never race with
+// them.
+type peerConn struct {
+	info      Peer // Handshake results and Connected; immutable after the handshake
+	conn      net.Conn
+	sendCh    chan *Message
+	done      chan struct{}
+	closeOnce sync.Once
+	lastSeen  atomic.Int64 // Unix nanoseconds of the last received message
+	sent      trafficCounters
+	recv      trafficCounters
+}
+
+func newPeerConn(info *Peer, conn net.Conn) *peerConn {
+	p := &peerConn{
+		info:   *info,
+		conn:   conn,
+		sendCh: make(chan *Message, peerSendQueue),
+		done:   make(chan struct{}),
+	}
+	p.lastSeen.Store(info.LastSeen.UnixNano())
+	return p
+}
+
+// snapshot returns a copy of the peer's state for callers outside the network
+func (p *peerConn) snapshot() *Peer {
+	peer := p.info
+	peer.LastSeen = time.Unix(0, p.lastSeen.Load())
+	sent, sentByType := p.sent.snapshot()
+	recv, recvByType := p.recv.snapshot()
+	peer.BytesSent, peer.MessagesSent, peer.Sent = sent.Bytes, sent.Messages, sentByType
+	peer.BytesRecv, peer.MessagesRecv, peer.Received = recv.Bytes, recv.Messages, recvByType
+	return &peer
+}
+
+// send queues a message for the writer goroutine without blocking
+func (p *peerConn) send(msg *Message) error {
+	select {
+	case <-p.done:
+		return net.ErrClosed
+	default:
+	}
+	select {
+	case p.sendCh <- msg:
+		return nil
+	default:
+		return ErrPeerQueueFull
+	}
+}
+
+// close shuts the connection down; the reader and writer goroutines exit
+func (p *peerConn) close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.conn.Close()
+	})
+}
+
+// writeLoop drains the send queue until the peer is closed
+func (p *peerConn) writeLoop() {
+	for {
+		select {
+		case <-p.done:
+			return
+		case msg := <-p.sendCh:
+			p.conn.SetWriteDeadline(time.Now().Add(peerWriteTimeout))
+			size, err := writeMessage(p.conn, msg)
+			if err != nil {
+				p.close()
+				return
+			}
+			p.sent.add(msg.Type, size)
+		}
+	}
+}
+
+// Helper functions
+
+// writeMessage writes a frame: a 4-byte big-endian length, the message type
+// byte and the payload
+func writeMessage(w io.Writer, msg *Message) (int, error) {
+	if 1+len(msg.Payload) > maxMessageSize {
+		return 0, errors.New("message too large")
+	}
+	frame := make([]byte, 4, 4+1+len(msg.Payload))
+	binary.BigEndian.PutUint32(frame, uint32(1+len(msg.Payload)))
+	frame = append(frame, byte(msg.Type))
+	frame = append(frame, msg.Payload...)
+	return w.Write(frame)
+}
+
+// readMessage reads one frame and returns the message and bytes consumed
+func readMessage(r io.Reader) (*Message, int, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, 0, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size == 0 || size > maxMessageSize {
+		return nil, 0, errors.New("invalid message size")
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, 0, err
+	}
+	msg, err := parseMessage(body)
+	return msg, len(header) + len(body), err
+}
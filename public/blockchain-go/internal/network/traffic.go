@@ -0,0 +1,83 @@
+// Package network - Per-peer traffic accounting
+package network
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// TrafficCount is the number of messages and bytes of one kind of traffic,
+// bytes as framed on the wire; the handshake is not counted
+type TrafficCount struct {
+	Messages uint64 `json:"messages"`
+	Bytes    uint64 `json:"bytes"`
+}
+
+// messageTypeNames names the known message types, indexed by type
+var messageTypeNames = [...]string{
+	MsgPing:          "ping",
+	MsgPong:          "pong",
+	MsgBlockAnnounce: "blockAnnounce",
+	MsgBlockRequest:  "blockRequest",
+	MsgBlockResponse: "blockResponse",
+	MsgTxAnnounce:    "txAnnounce",
+	MsgTxRequest:     "txRequest",
+	MsgTxResponse:    "txResponse",
+	MsgValidatorVote: "validatorVote",
+	MsgMiningShare:   "miningShare",
+	MsgPeerDiscovery: "peerDiscovery",
+	MsgCompactBlock:  "compactBlock",
+}
+
+// unknownMessageType is the counter slot of types without a name
+const unknownMessageType = len(messageTypeNames)
+
+// String returns the message type's name, e.g. "blockAnnounce"
+func (t MessageType) String() string {
+	if t >= 0 && int(t) < len(messageTypeNames) {
+		return messageTypeNames[t]
+	}
+	return fmt.Sprintf("unknown(%d)", int(t))
+}
+
+// trafficCounters counts one direction of a peer's traffic. Counters are
+// atomics, updated by the peer's reader or writer goroutine and read by
+// snapshots.
+type trafficCounters struct {
+	messages [unknownMessageType + 1]atomic.Uint64
+	bytes    [unknownMessageType + 1]atomic.Uint64
+}
+
+// add counts a message of the given type and framed size
+func (c *trafficCounters) add(t MessageType, size int) {
+	slot := unknownMessageType
+	if t >= 0 && int(t) < unknownMessageType {
+		slot = int(t)
+	}
+	c.messages[slot].Add(1)
+	c.bytes[slot].Add(uint64(size))
+}
+
+// snapshot returns the totals and the counts of the message types seen,
+// keyed by type name
+func (c *trafficCounters) snapshot() (TrafficCount, map[string]TrafficCount) {
+	var total TrafficCount
+	byType := make(map[string]TrafficCount)
+	for slot := range c.messages {
+		count := TrafficCount{
+			Messages: c.messages[slot].Load(),
+			Bytes:    c.bytes[slot].Load(),
+		}
+		if count.Messages == 0 {
+			continue
+		}
+		name := "unknown"
+		if slot < unknownMessageType {
+			name = messageTypeNames[slot]
+		}
+		byType[name] = count
+		total.Messages += count.Messages
+		total.Bytes += count.Bytes
+	}
+	return total, byType
+}
@@ -0,0 +1,298 @@
+// Package network - Connection transports, including an in-memory one
+package network
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Transport carries connections between nodes. P2PNetwork uses TCP unless
+// its Config names another transport.
+type Transport interface {
+	// Listen listens on a host:port address
+	Listen(addr string) (net.Listener, error)
+	// DialContext connects to a host:port address; its signature matches
+	// net.Dialer, so HTTP clients can dial through a transport too
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// tcpTransport is the default transport
+type tcpTransport struct{}
+
+func (tcpTransport) Listen(addr string) (net.Listener, error) {
+	return net.Listen("tcp", addr)
+}
+
+func (tcpTransport) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// firstEphemeralPort is the first port MemoryTransport hands out to dialers
+// and to listeners on port 0
+const firstEphemeralPort = 49152
+
+// MemoryTransport is a Transport whose connections never leave the process.
+// Every dialer gets its own 127.0.0.1 port, and reads honour deadlines.
+type MemoryTransport struct {
+	listeners map[string]*memoryListener
+	nextPort  int
+	mu        sync.Mutex
+}
+
+// NewMemoryTransport creates an in-memory transport with no listeners
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{
+		listeners: make(map[string]*memoryListener),
+		nextPort:  firstEphemeralPort,
+	}
+}
+
+// Listen listens on addr; port 0 picks a free port
+func (t *MemoryTransport) Listen(addr string) (net.Listener, error) {
+	host, port, err := splitMemoryAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if port == 0 {
+		port = t.ephemeralPort()
+	}
+	addr = net.JoinHostPort(host, strconv.Itoa(port))
+	if _, exists := t.listeners[addr]; exists {
+		return nil, fmt.Errorf("listen %s: address already in use", addr)
+	}
+	l := &memoryListener{
+		transport: t,
+		addr:      memoryAddr(addr),
+		conns:     make(chan net.Conn),
+		done:      make(chan struct{}),
+	}
+	t.listeners[addr] = l
+	return l, nil
+}
+
+// DialContext connects to the listener on addr
+func (t *MemoryTransport) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := splitMemoryAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	addr = net.JoinHostPort(host, strconv.Itoa(port))
+
+	t.mu.Lock()
+	l := t.listeners[addr]
+	local := memoryAddr(net.JoinHostPort("127.0.0.1", strconv.Itoa(t.ephemeralPort())))
+	t.mu.Unlock()
+
+	refused := fmt.Errorf("dial %s %s: connection refused", network, addr)
+	if l == nil {
+		return nil, refused
+	}
+	client, server := newMemoryConnPair(local, l.addr)
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		return nil, refused
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Helper functions
+
+// ephemeralPort returns an unused port. The caller must hold t.mu.
+func (t *MemoryTransport) ephemeralPort() int {
+	port := t.nextPort
+	t.nextPort++
+	return port
+}
+
+// splitMemoryAddr splits a host:port address, defaulting the host to
+// 127.0.0.1
+func splitMemoryAddr(addr string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port in %q", addr)
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" || host == "localhost" {
+		host = "127.0.0.1"
+	}
+	return host, port, nil
+}
+
+// memoryAddr is the address of one end of an in-memory connection
+type memoryAddr string
+
+func (a memoryAddr) Network() string { return "memory" }
+func (a memoryAddr) String() string  { return string(a) }
+
+// memoryListener accepts connections dialed through its transport
+type memoryListener struct {
+	transport *MemoryTransport
+	addr      memoryAddr
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *memoryListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *memoryListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		l.transport.mu.Lock()
+		delete(l.transport.listeners, string(l.addr))
+		l.transport.mu.Unlock()
+	})
+	return nil
+}
+
+func (l *memoryListener) Addr() net.Addr {
+	return l.addr
+}
+
+// memoryConn is one end of an in-memory connection. It reads from in and
+// writes to out, which the other end reads from.
+type memoryConn struct {
+	in     *pipeBuffer
+	out    *pipeBuffer
+	local  memoryAddr
+	remote memoryAddr
+}
+
+func newMemoryConnPair(clientAddr, serverAddr memoryAddr) (*memoryConn, *memoryConn) {
+	toServer, toClient := newPipeBuffer(), newPipeBuffer()
+	client := &memoryConn{in: toClient, out: toServer, local: clientAddr, remote: serverAddr}
+	server := &memoryConn{in: toServer, out: toClient, local: serverAddr, remote: clientAddr}
+	return client, server
+}
+
+func (c *memoryConn) Read(p []byte) (int, error)  { return c.in.read(p) }
+func (c *memoryConn) Write(p []byte) (int, error) { return c.out.write(p) }
+func (c *memoryConn) LocalAddr() net.Addr         { return c.local }
+func (c *memoryConn) RemoteAddr() net.Addr        { return c.remote }
+
+// Close closes both directions; the other end reads what was already
+// written, then EOF
+func (c *memoryConn) Close() error {
+	c.in.close()
+	c.out.close()
+	return nil
+}
+
+func (c *memoryConn) SetDeadline(t time.Time) error {
+	return c.in.setDeadline(t)
+}
+
+func (c *memoryConn) SetReadDeadline(t time.Time) error {
+	return c.in.setDeadline(t)
+}
+
+// SetWriteDeadline has no effect: writes never block
+func (c *memoryConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// pipeBuffer is an unbounded byte queue with one reader
+type pipeBuffer struct {
+	data     []byte
+	closed   bool
+	deadline time.Time
+	wake     chan struct{} // Signalled on writes, close and deadline changes
+	mu       sync.Mutex
+}
+
+func newPipeBuffer() *pipeBuffer {
+	return &pipeBuffer{wake: make(chan struct{}, 1)}
+}
+
+// read waits until data is buffered, the pipe is closed or the read
+// deadline passes
+func (b *pipeBuffer) read(p []byte) (int, error) {
+	for {
+		b.mu.Lock()
+		if len(b.data) > 0 {
+			n := copy(p, b.data)
+			b.data = b.data[n:]
+			b.mu.Unlock()
+			return n, nil
+		}
+		if b.closed {
+			b.mu.Unlock()
+			return 0, io.EOF
+		}
+		deadline := b.deadline
+		b.mu.Unlock()
+
+		if deadline.IsZero() {
+			<-b.wake
+			continue
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return 0, os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-b.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+func (b *pipeBuffer) write(p []byte) (int, error) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return 0, net.ErrClosed
+	}
+	b.data = append(b.data, p...)
+	b.mu.Unlock()
+	b.signal()
+	return len(p), nil
+}
+
+func (b *pipeBuffer) close() {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	b.signal()
+}
+
+func (b *pipeBuffer) setDeadline(t time.Time) error {
+	b.mu.Lock()
+	b.deadline = t
+	b.mu.Unlock()
+	b.signal()
+	return nil
+}
+
+func (b *pipeBuffer) signal() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
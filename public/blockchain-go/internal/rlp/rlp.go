@@ -0,0 +1,159 @@
+// Package rlp implements the Recursive Length Prefix encoding used by
+// Ethereum-compatible transactions
+package rlp
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Item is a decoded RLP string or list
+type Item struct {
+	IsList bool
+	Data   []byte // String payload
+	List   []Item // List elements
+	Raw    []byte // Full encoding, including the header
+}
+
+// Decode decodes one item from the front of data and returns the remaining
+// bytes. Non-canonical encodings are rejected.
+func Decode(data []byte) (Item, []byte, error) {
+	if len(data) == 0 {
+		return Item{}, nil, errors.New("rlp: unexpected end of input")
+	}
+
+	b := data[0]
+	var isList bool
+	var offset, size int
+	switch {
+	case b < 0x80:
+		return Item{Data: data[:1], Raw: data[:1]}, data[1:], nil
+	case b <= 0xb7:
+		offset, size = 1, int(b-0x80)
+	case b <= 0xbf:
+		offset, size = longSize(data, int(b-0xb7))
+	case b <= 0xf7:
+		isList, offset, size = true, 1, int(b-0xc0)
+	default:
+		isList = true
+		offset, size = longSize(data, int(b-0xf7))
+	}
+	if offset < 0 || size < 0 || len(data)-offset < size {
+		return Item{}, nil, errors.New("rlp: invalid length")
+	}
+
+	payload := data[offset : offset+size]
+	item := Item{IsList: isList, Raw: data[:offset+size]}
+	if !isList {
+		if size == 1 && payload[0] < 0x80 {
+			return Item{}, nil, errors.New("rlp: non-canonical single byte")
+		}
+		item.Data = payload
+		return item, data[offset+size:], nil
+	}
+
+	item.List = make([]Item, 0)
+	for len(payload) > 0 {
+		elem, rest, err := Decode(payload)
+		if err != nil {
+			return Item{}, nil, err
+		}
+		item.List = append(item.List, elem)
+		payload = rest
+	}
+	return item, data[offset+size:], nil
+}
+
+// DecodeList decodes data as a single list with exactly n elements
+func DecodeList(data []byte, n int) ([]Item, error) {
+	item, rest, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("rlp: trailing bytes after list")
+	}
+	if !item.IsList || len(item.List) != n {
+		return nil, fmt.Errorf("rlp: expected a list of %d fields", n)
+	}
+	return item.List, nil
+}
+
+// Uint64 decodes a string item as an unsigned integer
+func (item Item) Uint64() (uint64, error) {
+	if item.IsList || len(item.Data) > 8 || (len(item.Data) > 0 && item.Data[0] == 0) {
+		return 0, errors.New("rlp: invalid integer")
+	}
+	var n uint64
+	for _, b := range item.Data {
+		n = n<<8 | uint64(b)
+	}
+	return n, nil
+}
+
+// BigInt decodes a string item as an unsigned integer of up to 256 bits
+func (item Item) BigInt() (*big.Int, error) {
+	if item.IsList || len(item.Data) > 32 || (len(item.Data) > 0 && item.Data[0] == 0) {
+		return nil, errors.New("rlp: invalid integer")
+	}
+	return new(big.Int).SetBytes(item.Data), nil
+}
+
+// EncodeBytes encodes a byte string
+func EncodeBytes(b []byte) []byte {
+	if len(b) == 1 && b[0] < 0x80 {
+		return []byte{b[0]}
+	}
+	return append(header(0x80, 0xb7, len(b)), b...)
+}
+
+// EncodeUint encodes an unsigned integer
+func EncodeUint(n uint64) []byte {
+	return EncodeBigInt(new(big.Int).SetUint64(n))
+}
+
+// EncodeBigInt encodes a non-negative integer. Nil encodes as zero.
+func EncodeBigInt(n *big.Int) []byte {
+	if n == nil {
+		return []byte{0x80}
+	}
+	return EncodeBytes(n.Bytes())
+}
+
+// EncodeList wraps already-encoded items in a list header
+func EncodeList(items ...[]byte) []byte {
+	size := 0
+	for _, item := range items {
+		size += len(item)
+	}
+	out := header(0xc0, 0xf7, size)
+	for _, item := range items {
+		out = append(out, item...)
+	}
+	return out
+}
+
+// Helper functions
+func header(short, long byte, size int) []byte {
+	if size <= 55 {
+		return []byte{short + byte(size)}
+	}
+	sizeBytes := big.NewInt(int64(size)).Bytes()
+	return append([]byte{long + byte(len(sizeBytes))}, sizeBytes...)
+}
+
+// longSize reads a long-form length. It returns -1 values on error.
+func longSize(data []byte, lenOfLen int) (int, int) {
+	if len(data) < 1+lenOfLen || lenOfLen > 4 || data[1] == 0 {
+		return -1, -1
+	}
+	size := 0
+	for _, b := range data[1 : 1+lenOfLen] {
+		size = size<<8 | int(b)
+	}
+	if size <= 55 {
+		return -1, -1
+	}
+	return 1 + lenOfLen, size
+}
@@ -0,0 +1,250 @@
+// Package rpc - Admin methods for peers, node info and the transaction pool
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/buildinfo"
+	"chaincore/internal/logging"
+	"chaincore/internal/network"
+)
+
+// adminPeers lists the connected peers with the traffic exchanged with
+// each, in total and by message type, ordered by peer ID
+func (s *Server) adminPeers() (interface{}, error) {
+	p2p, err := s.adminNetwork()
+	if err != nil {
+		return nil, err
+	}
+
+	peers := p2p.GetPeers()
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+	result := make([]map[string]interface{}, 0, len(peers))
+	for _, p := range peers {
+		result = append(result, formatPeer(p))
+	}
+	return result, nil
+}
+
+// adminAddPeer connects to a peer. Params are [target], a host:port
+// address or an enode URL; the result is the connected peer.
+func (s *Server) adminAddPeer(params json.RawMessage) (interface{}, error) {
+	p2p, err := s.adminNetwork()
+	if err != nil {
+		return nil, err
+	}
+	var args []string
+	if err := json.Unmarshal(params, &args); err != nil || len(args) != 1 {
+		return nil, fmt.Errorf("params must be [address or enode URL]")
+	}
+	peer, err := p2p.AddPeer(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to add peer: %w", err)
+	}
+	return formatPeer(peer), nil
+}
+
+// adminRemovePeer disconnects a peer. Params are [peerID].
+func (s *Server) adminRemovePeer(params json.RawMessage) (interface{}, error) {
+	p2p, err := s.adminNetwork()
+	if err != nil {
+		return nil, err
+	}
+	var args []string
+	if err := json.Unmarshal(params, &args); err != nil || len(args) != 1 {
+		return nil, fmt.Errorf("params must be [peerID]")
+	}
+	if err := p2p.RemovePeer(args[0]); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+// adminNodeInfo describes the node: its identity and enode URL, the ports
+// it serves, and the chain it follows
+func (s *Server) adminNodeInfo() (interface{}, error) {
+	p2p, err := s.adminNetwork()
+	if err != nil {
+		return nil, err
+	}
+
+	config := s.chain.Config()
+	head := s.chain.GetCurrentBlock()
+	headHash := head.Hash()
+	chain := map[string]interface{}{
+		"chainId":          config.ChainID,
+		"blockTime":        config.BlockTime,
+		"maxBlockSize":     config.MaxBlockSize,
+		"minGasPrice":      config.MinGasPrice,
+		"unbondingPeriod":  uint64(config.UnbondingPeriod.Seconds()),
+		"archive":          config.Archive,
+		"snapshotInterval": config.SnapshotInterval,
+		"headNumber":       head.Header.Height,
+		"headHash":         "0x" + hex.EncodeToString(headHash[:]),
+	}
+	if config.ValidatorMinStake != nil {
+		chain["validatorMinStake"] = config.ValidatorMinStake.String()
+	}
+	if genesis, err := s.chain.GetBlock(0); err == nil {
+		genesisHash := genesis.Hash()
+		chain["genesisHash"] = "0x" + hex.EncodeToString(genesisHash[:])
+	}
+	if s.pos != nil {
+		chain["finalizedNumber"] = s.pos.GetFinalizedHeight()
+	}
+
+	return map[string]interface{}{
+		"id":    p2p.NodeID(),
+		"name":  buildinfo.Get().ClientVersion(),
+		"enode": p2p.Enode(""),
+		"ports": map[string]interface{}{
+			"p2p": p2p.ListenPort(),
+			"rpc": s.rpcPort(),
+		},
+		"protocol": map[string]interface{}{
+			"version":    network.ProtocolVersion,
+			"minVersion": network.MinProtocolVersion,
+		},
+		"capabilities": p2p.Capabilities().String(),
+		"peers":        p2p.GetPeerCount(),
+		"chain":        chain,
+	}, nil
+}
+
+// adminVersionInfo describes the build the node runs
+func (s *Server) adminVersionInfo() (interface{}, error) {
+	if !s.config.EnableAdminAPI {
+		return nil, errors.New("admin API is disabled")
+	}
+	info := buildinfo.Get()
+	return map[string]interface{}{
+		"clientVersion": info.ClientVersion(),
+		"version":       info.Version,
+		"commit":        info.Commit,
+		"date":          info.Date,
+		"dirty":         info.Dirty,
+		"goVersion":     info.GoVersion,
+		"os":            info.OS,
+		"arch":          info.Arch,
+		"features":      info.Features,
+		"protocol":      network.ProtocolVersion,
+	}, nil
+}
+
+// adminGetLogLevels returns the level each module logs at. Levels are
+// changed through the log.level setting.
+func (s *Server) adminGetLogLevels() (interface{}, error) {
+	if !s.config.EnableAdminAPI {
+		return nil, errors.New("admin API is disabled")
+	}
+	return logging.CurrentLevels(), nil
+}
+
+// adminTxPoolStatus summarizes the transaction pool
+func (s *Server) adminTxPoolStatus() (interface{}, error) {
+	if !s.config.EnableAdminAPI {
+		return nil, errors.New("admin API is disabled")
+	}
+	advisory := s.chain.GasPriceAdvisory()
+	return map[string]interface{}{
+		"pending":     advisory.Pending,
+		"senders":     len(s.chain.PoolContent()),
+		"maxSize":     advisory.MaxSize,
+		"saturation":  advisory.Saturation,
+		"minGasPrice": advisory.MinGasPrice,
+	}, nil
+}
+
+// adminTxPoolContent lists pooled transactions by sender and nonce. Params
+// are [address] to list one sender's, or none for every sender's.
+func (s *Server) adminTxPoolContent(params json.RawMessage) (interface{}, error) {
+	if !s.config.EnableAdminAPI {
+		return nil, errors.New("admin API is disabled")
+	}
+	var args []string
+	if len(params) > 0 && string(params) != "null" {
+		if err := json.Unmarshal(params, &args); err != nil {
+			return nil, fmt.Errorf("params must be [address]")
+		}
+	}
+	var only *[20]byte
+	if len(args) > 0 {
+		addr, err := s.eth.parseAddress(args[0])
+		if err != nil {
+			return nil, err
+		}
+		only = &addr
+	}
+
+	result := make(map[string]map[string]interface{})
+	for from, txs := range s.chain.PoolContent() {
+		if only != nil && from != *only {
+			continue
+		}
+		byNonce := make(map[string]interface{}, len(txs))
+		for _, tx := range txs {
+			byNonce[strconv.FormatUint(tx.Nonce, 10)] = s.eth.formatTransaction(tx, nil, 0)
+		}
+		result[blockchain.ChecksumAddress(from)] = byNonce
+	}
+	return result, nil
+}
+
+// Helper functions
+
+// adminNetwork returns the P2P network if the admin API is enabled
+func (s *Server) adminNetwork() (*network.P2PNetwork, error) {
+	if !s.config.EnableAdminAPI {
+		return nil, errors.New("admin API is disabled")
+	}
+	if s.p2p == nil {
+		return nil, errors.New("peers are not available")
+	}
+	return s.p2p, nil
+}
+
+// rpcPort returns the port the server listens on
+func (s *Server) rpcPort() int {
+	if s.listener != nil {
+		if addr, ok := s.listener.Addr().(*net.TCPAddr); ok {
+			return addr.Port
+		}
+		if _, port, err := net.SplitHostPort(s.listener.Addr().String()); err == nil {
+			if p, err := strconv.Atoi(port); err == nil {
+				return p
+			}
+		}
+	}
+	return s.config.Port
+}
+
+// formatPeer encodes a peer and its traffic for admin results
+func formatPeer(p *network.Peer) map[string]interface{} {
+	nodeType := "full"
+	if p.NodeType == network.LiteNode {
+		nodeType = "lite"
+	}
+	return map[string]interface{}{
+		"id":               p.ID,
+		"address":          p.Address,
+		"nodeType":         nodeType,
+		"version":          p.Version,
+		"capabilities":     p.Capabilities.String(),
+		"connected":        p.Connected.Unix(),
+		"lastSeen":         p.LastSeen.Unix(),
+		"latencyMs":        p.Latency.Milliseconds(),
+		"bytesSent":        p.BytesSent,
+		"bytesReceived":    p.BytesRecv,
+		"messagesSent":     p.MessagesSent,
+		"messagesReceived": p.MessagesRecv,
+		"sent":             p.Sent,
+		"received":         p.Received,
+	}
+}
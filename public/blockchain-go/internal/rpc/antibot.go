@@ -0,0 +1,135 @@
+// Package rpc - Admin methods reviewing and overriding the pool's anti-bot records
+package rpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/mining"
+)
+
+// adminGetAntiBotRecord returns what the pool holds on a miner. Params are
+// [address].
+func (s *Server) adminGetAntiBotRecord(params json.RawMessage) (interface{}, error) {
+	pool, err := s.adminPool()
+	if err != nil {
+		return nil, err
+	}
+	addr, err := s.parseAntiBotAddress(params)
+	if err != nil {
+		return nil, err
+	}
+	record := pool.GetAntiBotRecord(addr)
+	return formatAntiBotRecord(&record), nil
+}
+
+// adminClearAntiBotRecord forgets what the pool holds on a miner, lifting
+// any ban or challenge and restoring its human score. Params are
+// [address].
+func (s *Server) adminClearAntiBotRecord(params json.RawMessage) (interface{}, error) {
+	pool, err := s.adminPool()
+	if err != nil {
+		return nil, err
+	}
+	addr, err := s.parseAntiBotAddress(params)
+	if err != nil {
+		return nil, err
+	}
+	if err := pool.ClearAntiBotRecord(addr); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+// adminBanMiner blacklists a miner. Params are [address, seconds]; the
+// result is the miner's record.
+func (s *Server) adminBanMiner(params json.RawMessage) (interface{}, error) {
+	pool, err := s.adminPool()
+	if err != nil {
+		return nil, err
+	}
+	var args []json.RawMessage
+	var address string
+	var seconds uint64
+	if err := json.Unmarshal(params, &args); err != nil || len(args) != 2 ||
+		json.Unmarshal(args[0], &address) != nil || json.Unmarshal(args[1], &seconds) != nil {
+		return nil, fmt.Errorf("params must be [address, seconds]")
+	}
+	if seconds == 0 || seconds > math.MaxInt64/uint64(time.Second) {
+		return nil, fmt.Errorf("ban must last between 1 and %d seconds", math.MaxInt64/uint64(time.Second))
+	}
+	addr, err := s.eth.parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	if err := pool.BanMiner(addr, time.Duration(seconds)*time.Second); err != nil {
+		return nil, err
+	}
+	record := pool.GetAntiBotRecord(addr)
+	return formatAntiBotRecord(&record), nil
+}
+
+// adminListBannedMiners lists the blacklisted miners, soonest to expire
+// first
+func (s *Server) adminListBannedMiners() (interface{}, error) {
+	pool, err := s.adminPool()
+	if err != nil {
+		return nil, err
+	}
+	records := pool.GetBannedMiners()
+	result := make([]map[string]interface{}, 0, len(records))
+	for i := range records {
+		result = append(result, formatAntiBotRecord(&records[i]))
+	}
+	return result, nil
+}
+
+// Helper functions
+
+// adminPool returns the mining pool if the admin API is enabled
+func (s *Server) adminPool() (*mining.Pool, error) {
+	if !s.config.EnableAdminAPI {
+		return nil, errors.New("admin API is disabled")
+	}
+	if s.pool == nil {
+		return nil, errors.New("mining pool is not available")
+	}
+	return s.pool, nil
+}
+
+// parseAntiBotAddress parses params of [address]
+func (s *Server) parseAntiBotAddress(params json.RawMessage) ([20]byte, error) {
+	var args []string
+	if err := json.Unmarshal(params, &args); err != nil || len(args) != 1 {
+		return [20]byte{}, fmt.Errorf("params must be [address]")
+	}
+	return s.eth.parseAddress(args[0])
+}
+
+// formatAntiBotRecord encodes an anti-bot record for admin results
+func formatAntiBotRecord(record *mining.AntiBotRecord) map[string]interface{} {
+	result := map[string]interface{}{
+		"address":     blockchain.ChecksumAddress(record.Address),
+		"humanScore":  record.Score,
+		"scored":      record.Scored,
+		"blacklisted": record.Blacklisted(),
+		"challenged":  record.Challenged,
+		"submissions": 0,
+		"lastSeen":    nil,
+		"bannedUntil": nil,
+	}
+	if record.Pattern != nil {
+		result["submissions"] = len(record.Pattern.SubmissionTimes)
+	}
+	if !record.LastSeen.IsZero() {
+		result["lastSeen"] = record.LastSeen.Unix()
+	}
+	if record.Blacklisted() {
+		result["bannedUntil"] = record.BannedUntil.Unix()
+	}
+	return result
+}
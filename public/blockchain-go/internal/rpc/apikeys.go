@@ -0,0 +1,622 @@
+// Package rpc - API keys for operators offering public RPC as a service
+package rpc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// API key defaults and limits
+const (
+	defaultAPIKeyFlushInterval = 30 * time.Second
+	apiKeyPrefix               = "gyds_"
+	apiKeySecretBytes          = 24
+	apiKeyIDBytes              = 8
+	maxAPIKeyUsageDays         = 366
+	usageDayFormat             = "2006-01-02"
+)
+
+// API key errors
+var (
+	ErrAPIKeyRequired       = errors.New("API key required")
+	ErrInvalidAPIKey        = errors.New("invalid or revoked API key")
+	ErrAPIKeyRateLimited    = errors.New("API key rate limit exceeded")
+	ErrAPIKeyQuotaExceeded  = errors.New("API key daily quota exceeded")
+	ErrMethodNotAllowed     = errors.New("method not allowed for this API key")
+	ErrAPIKeyNotFound       = errors.New("API key not found")
+	ErrAPIKeyDBNotConnected = errors.New("API key database is not connected")
+	ErrKeyCreatorRequired   = errors.New("API keys are created with a JWT or founder token")
+)
+
+// apiKeySchema creates the key and usage tables. Only a hash of each
+// secret is stored; usage is one row per key per UTC day.
+var apiKeySchema = []string{
+	`CREATE TABLE IF NOT EXISTS rpc_api_keys (
+		id                  TEXT PRIMARY KEY,
+		name                TEXT NOT NULL,
+		secret_hash         TEXT NOT NULL UNIQUE,
+		methods             TEXT NOT NULL,
+		requests_per_second INTEGER NOT NULL,
+		daily_quota         BIGINT NOT NULL,
+		created_at          TIMESTAMPTZ NOT NULL,
+		revoked_at          TIMESTAMPTZ
+	)`,
+	`CREATE TABLE IF NOT EXISTS rpc_api_key_usage (
+		key_id   TEXT NOT NULL REFERENCES rpc_api_keys (id),
+		day      DATE NOT NULL,
+		requests BIGINT NOT NULL DEFAULT 0,
+		rejected BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (key_id, day)
+	)`,
+}
+
+// APIKeyConfig configures API key enforcement
+type APIKeyConfig struct {
+	Required      bool          // Reject requests without a key instead of rate limiting them by IP
+	FlushInterval time.Duration // How often usage is written to the database (0 = default)
+}
+
+// APIKey describes a key and its limits. The secret itself is only
+// returned once, when the key is created.
+type APIKey struct {
+	ID                string     `json:"id"`
+	Name              string     `json:"name"`
+	Methods           []string   `json:"methods"`           // Allowed JSON-RPC methods; "eth_*" matches a prefix, "eth" a namespace, empty allows all but admin_
+	RequestsPerSecond int        `json:"requestsPerSecond"` // 0 = unlimited
+	DailyQuota        int64      `json:"dailyQuota"`        // Requests per UTC day, 0 = unlimited
+	CreatedAt         time.Time  `json:"createdAt"`
+	RevokedAt         *time.Time `json:"revokedAt,omitempty"`
+}
+
+// NewAPIKey is a newly created key together with its secret
+type NewAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// APIKeyUsage is a key's usage on one UTC day
+type APIKeyUsage struct {
+	Day      string `json:"day"`
+	Requests int64  `json:"requests"` // Requests accepted against the quota
+	Rejected int64  `json:"rejected"` // Requests refused for rate, quota or method
+}
+
+// APIKeyManager authenticates requests by API key and enforces each key's
+// rate, daily quota and method allowlist. Keys and usage are persisted to
+// the DatabaseManager's active database so that several full nodes behind
+// one load balancer can share them: each node counts usage in memory,
+// adds it to the stored totals every flush, and picks up the other nodes'
+// totals and any keys created or revoked elsewhere at the same time.
+type APIKeyManager struct {
+	db      *DatabaseManager
+	config  APIKeyConfig
+	keys    map[string]*apiKeyEntry // By secret hash
+	pending map[usageKey]*APIKeyUsage
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+	flushMu sync.Mutex // Serializes flushes
+	mu      sync.Mutex
+}
+
+type apiKeyEntry struct {
+	APIKey
+	hash        string
+	windowCount int
+	windowReset time.Time
+	day         string
+	dayRequests int64 // Stored total plus requests counted since the last flush
+}
+
+type usageKey struct {
+	id  string
+	day string
+}
+
+type apiKeyContextKey struct{}
+
+// NewAPIKeyManager creates an API key manager backed by the database
+// manager's active connection
+func NewAPIKeyManager(db *DatabaseManager, config APIKeyConfig) *APIKeyManager {
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaultAPIKeyFlushInterval
+	}
+	return &APIKeyManager{
+		db:      db,
+		config:  config,
+		keys:    make(map[string]*apiKeyEntry),
+		pending: make(map[usageKey]*APIKeyUsage),
+		stopCh:  make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+}
+
+// Start creates the tables if needed, loads the active keys and begins
+// flushing usage
+func (m *APIKeyManager) Start() error {
+	db, err := m.activeDB()
+	if err != nil {
+		return err
+	}
+	for _, stmt := range apiKeySchema {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to create API key tables: %w", err)
+		}
+	}
+	if err := m.reload(db); err != nil {
+		return err
+	}
+	go m.flushLoop()
+	return nil
+}
+
+// Stop stops the flush loop and writes the remaining usage
+func (m *APIKeyManager) Stop() {
+	close(m.stopCh)
+	<-m.doneCh
+	if err := m.flush(); err != nil {
+		logger.Error("Failed to save API key usage", "err", err)
+	}
+}
+
+// Authenticate checks the key presented with a request, in the X-API-Key
+// header or as a bearer token, and charges the request to it. It returns
+// nil without error for a request with no key when keys are optional.
+func (m *APIKeyManager) Authenticate(r *http.Request) (*APIKey, error) {
+	secret := r.Header.Get("X-API-Key")
+	if secret == "" {
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			secret = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+		}
+	}
+	if secret == "" {
+		if m.config.Required {
+			return nil, ErrAPIKeyRequired
+		}
+		return nil, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.keys[hashAPIKey(secret)]
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	today := now.UTC().Format(usageDayFormat)
+	if entry.day != today {
+		entry.day = today
+		entry.dayRequests = 0
+	}
+	if entry.DailyQuota > 0 && entry.dayRequests >= entry.DailyQuota {
+		m.usage(entry.ID, today).Rejected++
+		return nil, ErrAPIKeyQuotaExceeded
+	}
+	if entry.RequestsPerSecond > 0 {
+		if now.After(entry.windowReset) {
+			entry.windowCount = 0
+			entry.windowReset = now.Add(rateLimitWindow)
+		}
+		if entry.windowCount >= entry.RequestsPerSecond {
+			m.usage(entry.ID, today).Rejected++
+			return nil, ErrAPIKeyRateLimited
+		}
+		entry.windowCount++
+	}
+	entry.dayRequests++
+	m.usage(entry.ID, today).Requests++
+	key := entry.APIKey
+	return &key, nil
+}
+
+// AllowMethod reports whether the key may call a JSON-RPC method, counting
+// a refusal against the key's usage
+func (m *APIKeyManager) AllowMethod(key *APIKey, method string) bool {
+	if keyAllowsMethod(key.Methods, method) {
+		return true
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.usage(key.ID, time.Now().UTC().Format(usageDayFormat)).Rejected++
+	return false
+}
+
+// CreateKey creates a key with the given limits and returns it with its
+// secret. The secret cannot be recovered later.
+func (m *APIKeyManager) CreateKey(name string, methods []string, requestsPerSecond int, dailyQuota int64) (*NewAPIKey, error) {
+	if name == "" {
+		return nil, errors.New("API key name is required")
+	}
+	if requestsPerSecond < 0 || dailyQuota < 0 {
+		return nil, errors.New("API key limits must not be negative")
+	}
+	for _, method := range methods {
+		if method == "" || strings.Contains(strings.TrimSuffix(method, "*"), "*") {
+			return nil, fmt.Errorf("invalid method pattern %q", method)
+		}
+	}
+	db, err := m.activeDB()
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := randomHex(apiKeyIDBytes)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomHex(apiKeySecretBytes)
+	if err != nil {
+		return nil, err
+	}
+	secret = apiKeyPrefix + secret
+
+	key := APIKey{
+		ID:                id,
+		Name:              name,
+		Methods:           methods,
+		RequestsPerSecond: requestsPerSecond,
+		DailyQuota:        dailyQuota,
+		CreatedAt:         time.Now().UTC().Truncate(time.Second),
+	}
+	hash := hashAPIKey(secret)
+	_, err = db.Exec(`INSERT INTO rpc_api_keys (id, name, secret_hash, methods, requests_per_second, daily_quota, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		key.ID, key.Name, hash, strings.Join(key.Methods, ","), key.RequestsPerSecond, key.DailyQuota, key.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store API key: %w", err)
+	}
+
+	m.mu.Lock()
+	m.keys[hash] = &apiKeyEntry{APIKey: key, hash: hash}
+	m.mu.Unlock()
+
+	return &NewAPIKey{APIKey: key, Key: secret}, nil
+}
+
+// RevokeKey revokes a key. Requests with it are refused from then on;
+// other nodes sharing the database stop accepting it at their next flush.
+func (m *APIKeyManager) RevokeKey(id string) error {
+	db, err := m.activeDB()
+	if err != nil {
+		return err
+	}
+	result, err := db.Exec(`UPDATE rpc_api_keys SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL`,
+		time.Now().UTC(), id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	m.mu.Lock()
+	for hash, entry := range m.keys {
+		if entry.ID == id {
+			delete(m.keys, hash)
+		}
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+// ListKeys returns all keys, including revoked ones, oldest first
+func (m *APIKeyManager) ListKeys() ([]APIKey, error) {
+	db, err := m.activeDB()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT id, name, methods, requests_per_second, daily_quota, created_at, revoked_at
+		FROM rpc_api_keys ORDER BY created_at, id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var key APIKey
+		var methods string
+		var revoked sql.NullTime
+		if err := rows.Scan(&key.ID, &key.Name, &methods, &key.RequestsPerSecond, &key.DailyQuota, &key.CreatedAt, &revoked); err != nil {
+			return nil, err
+		}
+		key.Methods = splitMethods(methods)
+		if revoked.Valid {
+			key.RevokedAt = &revoked.Time
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// Usage returns a key's usage over the last days UTC days, newest first.
+// Usage counted here but not yet flushed is written first.
+func (m *APIKeyManager) Usage(id string, days int) ([]APIKeyUsage, error) {
+	if days <= 0 || days > maxAPIKeyUsageDays {
+		return nil, fmt.Errorf("days must be between 1 and %d", maxAPIKeyUsageDays)
+	}
+	if err := m.flush(); err != nil {
+		return nil, err
+	}
+	db, err := m.activeDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM rpc_api_keys WHERE id = $1)`, id).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrAPIKeyNotFound
+	}
+
+	since := time.Now().UTC().AddDate(0, 0, 1-days).Format(usageDayFormat)
+	rows, err := db.Query(`SELECT day, requests, rejected FROM rpc_api_key_usage
+		WHERE key_id = $1 AND day >= $2 ORDER BY day DESC`, id, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API key usage: %w", err)
+	}
+	defer rows.Close()
+
+	usage := []APIKeyUsage{}
+	for rows.Next() {
+		var u APIKeyUsage
+		var day time.Time
+		if err := rows.Scan(&day, &u.Requests, &u.Rejected); err != nil {
+			return nil, err
+		}
+		u.Day = day.Format(usageDayFormat)
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
+
+// Helper functions
+func (m *APIKeyManager) flushLoop() {
+	defer close(m.doneCh)
+	ticker := time.NewTicker(m.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stopCh:
+			return
+		case <-ticker.C:
+			if err := m.flush(); err != nil {
+				logger.Error("Failed to save API key usage", "err", err)
+				continue
+			}
+			if db, err := m.activeDB(); err == nil {
+				if err := m.reload(db); err != nil {
+					logger.Error("Failed to reload API keys", "err", err)
+				}
+			}
+		}
+	}
+}
+
+// flush adds the usage counted since the last flush to the stored totals
+// and takes today's totals, which include other nodes' usage, as the new
+// quota baseline. Usage that fails to save is kept for the next flush.
+func (m *APIKeyManager) flush() error {
+	m.flushMu.Lock()
+	defer m.flushMu.Unlock()
+
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = make(map[usageKey]*APIKeyUsage)
+	m.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	db, err := m.activeDB()
+	if err != nil {
+		m.restorePending(pending)
+		return err
+	}
+
+	totals := make(map[usageKey]int64)
+	var firstErr error
+	for k, u := range pending {
+		var total int64
+		err := db.QueryRow(`INSERT INTO rpc_api_key_usage (key_id, day, requests, rejected)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (key_id, day) DO UPDATE SET
+				requests = rpc_api_key_usage.requests + EXCLUDED.requests,
+				rejected = rpc_api_key_usage.rejected + EXCLUDED.rejected
+			RETURNING requests`, k.id, k.day, u.Requests, u.Rejected).Scan(&total)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to save API key usage: %w", err)
+			}
+			continue
+		}
+		totals[k] = total
+		delete(pending, k)
+	}
+	m.restorePending(pending)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, entry := range m.keys {
+		k := usageKey{id: entry.ID, day: entry.day}
+		if total, ok := totals[k]; ok {
+			entry.dayRequests = total + m.pendingRequests(k)
+		}
+	}
+	return firstErr
+}
+
+// restorePending adds usage that was not saved back to the pending counts
+func (m *APIKeyManager) restorePending(pending map[usageKey]*APIKeyUsage) {
+	if len(pending) == 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, u := range pending {
+		p := m.usage(k.id, k.day)
+		p.Requests += u.Requests
+		p.Rejected += u.Rejected
+	}
+}
+
+// reload replaces the key set with the active keys in the database,
+// keeping the rate and quota counters of keys that are still active
+func (m *APIKeyManager) reload(db *sql.DB) error {
+	today := time.Now().UTC().Format(usageDayFormat)
+	rows, err := db.Query(`SELECT k.id, k.name, k.secret_hash, k.methods, k.requests_per_second, k.daily_quota, k.created_at,
+			COALESCE(u.requests, 0)
+		FROM rpc_api_keys k
+		LEFT JOIN rpc_api_key_usage u ON u.key_id = k.id AND u.day = $1
+		WHERE k.revoked_at IS NULL`, today)
+	if err != nil {
+		return fmt.Errorf("failed to load API keys: %w", err)
+	}
+	defer rows.Close()
+
+	loaded := make(map[string]*apiKeyEntry)
+	for rows.Next() {
+		var entry apiKeyEntry
+		var methods string
+		if err := rows.Scan(&entry.ID, &entry.Name, &entry.hash, &methods, &entry.RequestsPerSecond,
+			&entry.DailyQuota, &entry.CreatedAt, &entry.dayRequests); err != nil {
+			return err
+		}
+		entry.Methods = splitMethods(methods)
+		entry.day = today
+		loaded[entry.hash] = &entry
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for hash, entry := range loaded {
+		entry.dayRequests += m.pendingRequests(usageKey{id: entry.ID, day: today})
+		if current, ok := m.keys[hash]; ok {
+			entry.windowCount = current.windowCount
+			entry.windowReset = current.windowReset
+		}
+	}
+	m.keys = loaded
+	return nil
+}
+
+// usage returns the pending usage for a key and day, creating it if needed.
+// The caller must hold mu.
+func (m *APIKeyManager) usage(id, day string) *APIKeyUsage {
+	k := usageKey{id: id, day: day}
+	u, ok := m.pending[k]
+	if !ok {
+		u = &APIKeyUsage{Day: day}
+		m.pending[k] = u
+	}
+	return u
+}
+
+// pendingRequests returns the unflushed request count for a key and day.
+// The caller must hold mu.
+func (m *APIKeyManager) pendingRequests(k usageKey) int64 {
+	if u, ok := m.pending[k]; ok {
+		return u.Requests
+	}
+	return 0
+}
+
+func (m *APIKeyManager) activeDB() (*sql.DB, error) {
+	db := m.db.GetActiveDB()
+	if db == nil {
+		return nil, ErrAPIKeyDBNotConnected
+	}
+	return db, nil
+}
+
+// keyAllowsMethod reports whether an API key's allowlist grants a method.
+// Admin methods must be granted by a pattern naming the admin namespace, so
+// neither an empty allowlist nor "*" reaches them.
+func keyAllowsMethod(allowed []string, method string) bool {
+	if methodNamespace(method) != "admin" {
+		return methodAllowed(allowed, method)
+	}
+	for _, pattern := range allowed {
+		if strings.HasPrefix(pattern, "admin") && methodAllowed([]string{pattern}, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// methodAllowed reports whether a method matches an allowlist. An empty
+// allowlist allows every method; a pattern ending in "*" matches a prefix
+// and a bare namespace such as "mining" matches the methods in it.
+func methodAllowed(allowed []string, method string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, pattern := range allowed {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(method, prefix) {
+				return true
+			}
+		} else if pattern == method || pattern == methodNamespace(method) {
+			return true
+		}
+	}
+	return false
+}
+
+// methodNamespace returns the namespace of a method, the part before its
+// first underscore, or "" if it has none
+func methodNamespace(method string) string {
+	namespace, _, ok := strings.Cut(method, "_")
+	if !ok {
+		return ""
+	}
+	return namespace
+}
+
+func splitMethods(value string) []string {
+	if value == "" {
+		return []string{}
+	}
+	return strings.Split(value, ",")
+}
+
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// withAPIKey attaches the authenticated key to a request context
+func withAPIKey(ctx context.Context, key *APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyContextKey{}, key)
+}
+
+// apiKeyFromContext returns the key a request was authenticated with, or
+// nil for an anonymous request
+func apiKeyFromContext(ctx context.Context) *APIKey {
+	key, _ := ctx.Value(apiKeyContextKey{}).(*APIKey)
+	return key
+}
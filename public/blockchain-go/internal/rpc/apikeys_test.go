@@ -0,0 +1,39 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestKeyAllowlistGrantsAdminExplicitly(t *testing.T) {
+	for _, tc := range []struct {
+		allowed []string
+		method  string
+		want    bool
+	}{
+		{nil, "eth_blockNumber", true},
+		{nil, "admin_createApiKey", false},
+		{[]string{"*"}, "admin_addPeer", false},
+		{[]string{"*"}, "mining_submitShare", true},
+		{[]string{"admin"}, "admin_setSetting", true},
+		{[]string{"admin_*"}, "admin_banMiner", true},
+		{[]string{"admin_peers"}, "admin_peers", true},
+		{[]string{"admin_peers"}, "admin_addPeer", false},
+	} {
+		if got := keyAllowsMethod(tc.allowed, tc.method); got != tc.want {
+			t.Fatalf("%v allows %s: %v", tc.allowed, tc.method, got)
+		}
+	}
+}
+
+func TestTenantKeyCannotCreateKeys(t *testing.T) {
+	s := &Server{config: Config{EnableAdminAPI: true}, apiKeys: NewAPIKeyManager(nil, APIKeyConfig{})}
+	params, _ := json.Marshal(map[string]interface{}{"name": "escalated", "methods": []string{"*"}})
+
+	grant := withAPIKey(context.Background(), &APIKey{ID: "tenant", Methods: []string{"admin_createApiKey"}})
+	if _, err := s.createAPIKey(grant, params); !errors.Is(err, ErrKeyCreatorRequired) {
+		t.Fatalf("key created by an API key: %v", err)
+	}
+}
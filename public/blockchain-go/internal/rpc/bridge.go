@@ -0,0 +1,115 @@
+// Package rpc - Founder methods of the burn-to-mint bridge
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/token"
+)
+
+// SetBridge provides the burn-to-mint bridge behind token_burnUsdtForMint,
+// token_getBridgeDeposits and token_mapBridgeRecipient on a founder node.
+// It must be called before Start.
+func (s *Server) SetBridge(b *token.Bridge) {
+	s.bridge = b
+}
+
+// burnUSDTForMint mints for the deposits in a foreign transaction once the
+// bridge has verified them, without waiting for its scan to find them.
+// Params are [txHash]; the result lists the transaction's deposits.
+func (s *Server) burnUSDTForMint(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	bridge, err := s.founderBridge(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var args []string
+	if err := json.Unmarshal(params, &args); err != nil || len(args) != 1 {
+		return nil, fmt.Errorf("params must be [foreign transaction hash]")
+	}
+	return bridge.SubmitDeposit(ctx, args[0])
+}
+
+// getBridgeDeposits lists the bridge's verified deposits, newest first,
+// with their proofs. Params are [offset, count].
+func (s *Server) getBridgeDeposits(params json.RawMessage) (interface{}, error) {
+	if s.bridge == nil {
+		return nil, errors.New("the burn-to-mint bridge is not enabled on this node")
+	}
+	var args []uint64
+	if len(params) > 0 && string(params) != "null" {
+		if err := json.Unmarshal(params, &args); err != nil || len(args) > 2 {
+			return nil, fmt.Errorf("params must be [offset, count]")
+		}
+	}
+	var offset, count uint64
+	if len(args) > 0 {
+		offset = args[0]
+	}
+	if len(args) > 1 {
+		count = args[1]
+	}
+
+	deposits, total, err := s.bridge.Deposits(offset, count)
+	if err != nil {
+		return nil, err
+	}
+	config := s.bridge.Config()
+	result := map[string]interface{}{
+		"network":        config.Network,
+		"token":          config.Token,
+		"depositAddress": config.DepositAddress,
+		"confirmations":  config.Confirmations,
+		"scannedBlock":   s.bridge.ScannedBlock(),
+		"deposits":       deposits,
+		"total":          total,
+		"nextOffset":     nil,
+	}
+	if next := offset + uint64(len(deposits)); next < total {
+		result["nextOffset"] = next
+	}
+	return result, nil
+}
+
+// mapBridgeRecipient sets the address a foreign sender's deposits mint to,
+// and mints its deposits waiting for one. Params are [foreignAddress,
+// recipient].
+func (s *Server) mapBridgeRecipient(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	bridge, err := s.founderBridge(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var args []string
+	if err := json.Unmarshal(params, &args); err != nil || len(args) != 2 {
+		return nil, fmt.Errorf("params must be [foreign address, recipient]")
+	}
+	recipient, err := s.eth.parseAddress(args[1])
+	if err != nil {
+		return nil, err
+	}
+	minted, err := bridge.MapRecipient(args[0], recipient)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"recipient": blockchain.ChecksumAddress(recipient),
+		"minted":    minted,
+	}, nil
+}
+
+// Helper functions
+
+// founderBridge returns the bridge if the request carried a valid founder
+// token
+func (s *Server) founderBridge(ctx context.Context) (*token.Bridge, error) {
+	if _, err := s.founderTokens(ctx); err != nil {
+		return nil, err
+	}
+	if s.bridge == nil {
+		return nil, errors.New("the burn-to-mint bridge is not enabled on this node")
+	}
+	return s.bridge, nil
+}
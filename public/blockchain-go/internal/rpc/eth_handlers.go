@@ -3,13 +3,19 @@
 package rpc
 
 import (
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"math/big"
 	"strings"
 
+	"go.opentelemetry.io/otel/attribute"
+
 	"chaincore/internal/blockchain"
+	"chaincore/internal/buildinfo"
+	"chaincore/internal/chainsync"
+	"chaincore/internal/tracing"
 )
 
 // ChainConfig holds network configuration
@@ -51,14 +57,23 @@ func TestnetChainConfig() *ChainConfig {
 
 // EthHandlers provides Ethereum-compatible RPC handlers
 type EthHandlers struct {
-	chain  *blockchain.Blockchain
-	config *ChainConfig
+	chain           *blockchain.Blockchain
+	config          *ChainConfig
+	strictChecksum  bool
+	finalizedHeight func() uint64 // Resolves the "finalized" block tag
+	syncProgress    func() chainsync.Progress
+	filters         *FilterManager
 }
 
-// NewEthHandlers creates new Ethereum-compatible handlers
+// NewEthHandlers creates new Ethereum-compatible handlers. Without a
+// config, the mainnet configuration is used with the chain's own chain ID,
+// so wallets sign for the chain that will accept their transactions.
 func NewEthHandlers(chain *blockchain.Blockchain, config *ChainConfig) *EthHandlers {
 	if config == nil {
 		config = DefaultChainConfig()
+		if id := chain.ChainID(); id != 0 {
+			config.ChainID, config.NetworkID = id, id
+		}
 	}
 	return &EthHandlers{
 		chain:  chain,
@@ -66,8 +81,30 @@ func NewEthHandlers(chain *blockchain.Blockchain, config *ChainConfig) *EthHandl
 	}
 }
 
+// SetStrictChecksum requires all address inputs to be EIP-55 checksummed
+func (h *EthHandlers) SetStrictChecksum(strict bool) {
+	h.strictChecksum = strict
+}
+
+// SetFinalitySource installs the function that reports the last finalized
+// height, enabling the "finalized" and "safe" block tags
+func (h *EthHandlers) SetFinalitySource(fn func() uint64) {
+	h.finalizedHeight = fn
+}
+
+// SetSyncProgress installs the source of block sync progress reported by
+// eth_syncing
+func (h *EthHandlers) SetSyncProgress(fn func() chainsync.Progress) {
+	h.syncProgress = fn
+}
+
+// SetFilterManager installs the filter manager behind the filter methods
+func (h *EthHandlers) SetFilterManager(m *FilterManager) {
+	h.filters = m
+}
+
 // HandleMethod processes Ethereum-compatible RPC methods
-func (h *EthHandlers) HandleMethod(method string, params json.RawMessage) (interface{}, error) {
+func (h *EthHandlers) HandleMethod(ctx context.Context, method string, params json.RawMessage) (interface{}, error) {
 	switch method {
 	// Network methods
 	case "eth_chainId":
@@ -106,10 +143,12 @@ func (h *EthHandlers) HandleMethod(method string, params json.RawMessage) (inter
 		return h.ethGetStorageAt(params)
 	case "eth_accounts":
 		return h.ethAccounts()
+	case "eth_getProof":
+		return h.ethGetProof(params)
 
 	// Transaction methods
 	case "eth_sendRawTransaction":
-		return h.ethSendRawTransaction(params)
+		return h.ethSendRawTransaction(ctx, params)
 	case "eth_getTransactionByHash":
 		return h.ethGetTransactionByHash(params)
 	case "eth_getTransactionReceipt":
@@ -135,6 +174,18 @@ func (h *EthHandlers) HandleMethod(method string, params json.RawMessage) (inter
 	case "eth_getLogs":
 		return h.ethGetLogs(params)
 
+	// Filter methods
+	case "eth_newFilter":
+		return h.ethNewFilter(ctx, params)
+	case "eth_newBlockFilter":
+		return h.installFilter(ctx, FilterBlocks, nil, 0, 0)
+	case "eth_newPendingTransactionFilter":
+		return h.installFilter(ctx, FilterPendingTransactions, nil, 0, 0)
+	case "eth_getFilterChanges":
+		return h.ethGetFilterChanges(ctx, params)
+	case "eth_uninstallFilter":
+		return h.ethUninstallFilter(ctx, params)
+
 	// Sync status
 	case "eth_syncing":
 		return h.ethSyncing()
@@ -167,7 +218,7 @@ func (h *EthHandlers) netPeerCount() (interface{}, error) {
 }
 
 func (h *EthHandlers) web3ClientVersion() (interface{}, error) {
-	return "GYDS/v1.0.0/go", nil
+	return buildinfo.Get().ClientVersion(), nil
 }
 
 // Block methods
@@ -190,22 +241,9 @@ func (h *EthHandlers) ethGetBlockByNumber(params json.RawMessage) (interface{},
 	if !ok {
 		return nil, fmt.Errorf("invalid block number")
 	}
-
-	var height uint64
-	if blockNumberStr == "latest" {
-		block := h.chain.GetCurrentBlock()
-		height = block.Header.Height
-	} else if blockNumberStr == "pending" {
-		block := h.chain.GetCurrentBlock()
-		height = block.Header.Height
-	} else if blockNumberStr == "earliest" {
-		height = 0
-	} else {
-		// Parse hex number
-		blockNumberStr = strings.TrimPrefix(blockNumberStr, "0x")
-		n := new(big.Int)
-		n.SetString(blockNumberStr, 16)
-		height = n.Uint64()
+	height, err := h.resolveBlockNumber(blockNumberStr)
+	if err != nil {
+		return nil, err
 	}
 
 	block, err := h.chain.GetBlock(height)
@@ -227,13 +265,19 @@ func (h *EthHandlers) ethGetBlockByHash(params json.RawMessage) (interface{}, er
 }
 
 func (h *EthHandlers) ethGetBlockTransactionCountByNumber(params json.RawMessage) (interface{}, error) {
-	var args []interface{}
+	var args []string
 	if err := json.Unmarshal(params, &args); err != nil {
 		return nil, err
 	}
+	if len(args) < 1 {
+		return nil, fmt.Errorf("missing block number parameter")
+	}
 
-	// TODO: Get actual transaction count
-	return "0x0", nil
+	block, err := h.blockByTag(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return fmt.Sprintf("0x%x", len(block.Transactions)), nil
 }
 
 func (h *EthHandlers) ethGetBlockTransactionCountByHash(params json.RawMessage) (interface{}, error) {
@@ -257,7 +301,19 @@ func (h *EthHandlers) ethGetBalance(params json.RawMessage) (interface{}, error)
 		return nil, err
 	}
 
-	balance := h.chain.GetBalance(addr)
+	blockTag := "latest"
+	if len(args) > 1 {
+		blockTag = args[1]
+	}
+	height, err := h.resolveBlockNumber(blockTag)
+	if err != nil {
+		return nil, err
+	}
+
+	balance, err := h.chain.GetBalanceAt(addr, height)
+	if err != nil {
+		return nil, err
+	}
 	return fmt.Sprintf("0x%x", balance), nil
 }
 
@@ -270,18 +326,98 @@ func (h *EthHandlers) ethGetTransactionCount(params json.RawMessage) (interface{
 	if len(args) < 1 {
 		return nil, fmt.Errorf("missing address parameter")
 	}
+	addr, err := h.parseAddress(args[0])
+	if err != nil {
+		return nil, err
+	}
 
-	// TODO: Get actual nonce from state
-	return "0x0", nil
+	// "pending" counts transactions waiting in the pool so wallets can send
+	// several in a row; other tags use the state at that block
+	blockTag := "latest"
+	if len(args) > 1 {
+		blockTag = args[1]
+	}
+	if blockTag == "pending" {
+		return fmt.Sprintf("0x%x", h.chain.GetPendingNonce(addr)), nil
+	}
+	height, err := h.resolveBlockNumber(blockTag)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := h.chain.GetNonceAt(addr, height)
+	if err != nil {
+		return nil, err
+	}
+	return fmt.Sprintf("0x%x", nonce), nil
 }
 
+// ethGetCode returns the contract code of an address. Params are
+// [address, block]; only the head has contract state.
 func (h *EthHandlers) ethGetCode(params json.RawMessage) (interface{}, error) {
-	// GYDS doesn't support smart contracts in v1
-	return "0x", nil
+	var args []string
+	if err := json.Unmarshal(params, &args); err != nil {
+		return nil, err
+	}
+
+	if len(args) < 1 {
+		return nil, fmt.Errorf("missing address parameter")
+	}
+	addr, err := h.parseAddress(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	blockTag := "latest"
+	if len(args) > 1 {
+		blockTag = args[1]
+	}
+	height, err := h.resolveBlockNumber(blockTag)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := h.chain.GetCode(addr, height)
+	if err != nil {
+		return nil, err
+	}
+	return fmt.Sprintf("0x%x", code), nil
 }
 
+// ethGetStorageAt returns a contract storage slot. Params are [address,
+// slot, block]; only the head has contract state.
 func (h *EthHandlers) ethGetStorageAt(params json.RawMessage) (interface{}, error) {
-	return "0x0000000000000000000000000000000000000000000000000000000000000000", nil
+	var args []string
+	if err := json.Unmarshal(params, &args); err != nil {
+		return nil, err
+	}
+
+	if len(args) < 2 {
+		return nil, fmt.Errorf("missing address or storage slot parameter")
+	}
+	addr, err := h.parseAddress(args[0])
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseHash(args[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage slot %s: %v", args[1], err)
+	}
+
+	blockTag := "latest"
+	if len(args) > 2 {
+		blockTag = args[2]
+	}
+	height, err := h.resolveBlockNumber(blockTag)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := h.chain.GetStorageAt(addr, key, height)
+	if err != nil {
+		return nil, err
+	}
+	return fmt.Sprintf("0x%x", value), nil
 }
 
 func (h *EthHandlers) ethAccounts() (interface{}, error) {
@@ -289,8 +425,58 @@ func (h *EthHandlers) ethAccounts() (interface{}, error) {
 	return []string{}, nil
 }
 
+func (h *EthHandlers) ethGetProof(params json.RawMessage) (interface{}, error) {
+	var args []json.RawMessage
+	if err := json.Unmarshal(params, &args); err != nil {
+		return nil, err
+	}
+
+	if len(args) < 2 {
+		return nil, fmt.Errorf("missing address or storage keys parameter")
+	}
+
+	var address string
+	if err := json.Unmarshal(args[0], &address); err != nil {
+		return nil, fmt.Errorf("invalid address")
+	}
+	addr, err := h.parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyStrs []string
+	if err := json.Unmarshal(args[1], &keyStrs); err != nil {
+		return nil, fmt.Errorf("invalid storage keys")
+	}
+	keys := make([][32]byte, len(keyStrs))
+	for i, k := range keyStrs {
+		keys[i], err = parseHash(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid storage key %s: %v", k, err)
+		}
+	}
+
+	blockTag := "latest"
+	if len(args) > 2 {
+		if err := json.Unmarshal(args[2], &blockTag); err != nil {
+			return nil, fmt.Errorf("invalid block number")
+		}
+	}
+	height, err := h.resolveBlockNumber(blockTag)
+	if err != nil {
+		return nil, err
+	}
+
+	proof, err := h.chain.GetProof(addr, keys, height)
+	if err != nil {
+		return nil, err
+	}
+
+	return formatAccountProof(proof), nil
+}
+
 // Transaction methods
-func (h *EthHandlers) ethSendRawTransaction(params json.RawMessage) (interface{}, error) {
+func (h *EthHandlers) ethSendRawTransaction(ctx context.Context, params json.RawMessage) (interface{}, error) {
 	var args []string
 	if err := json.Unmarshal(params, &args); err != nil {
 		return nil, err
@@ -309,31 +495,103 @@ func (h *EthHandlers) ethSendRawTransaction(params json.RawMessage) (interface{}
 	}
 
 	// Parse and validate transaction
+	_, span := tracing.StartSpan(ctx, "rpc.decodeTransaction", attribute.Int("tx.size", len(txBytes)))
 	tx, err := h.parseTransaction(txBytes)
+	tracing.End(span, err)
 	if err != nil {
 		return nil, err
 	}
 
 	// Add to transaction pool
-	if err := h.chain.AddTransaction(tx); err != nil {
+	if err := h.chain.AddTransaction(ctx, tx); err != nil {
 		return nil, err
 	}
 
-	return fmt.Sprintf("0x%s", tx.HashHex()), nil
+	return fmt.Sprintf("0x%x", tx.Hash), nil
 }
 
 func (h *EthHandlers) ethGetTransactionByHash(params json.RawMessage) (interface{}, error) {
-	// TODO: Implement transaction lookup by hash
-	return nil, nil
+	var args []string
+	if err := json.Unmarshal(params, &args); err != nil {
+		return nil, err
+	}
+
+	if len(args) < 1 {
+		return nil, fmt.Errorf("missing transaction hash parameter")
+	}
+
+	txHash, err := parseHash(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction hash: %v", err)
+	}
+	return h.transactionByHash(txHash)
+}
+
+// transactionByHash formats an included or pending transaction, or returns
+// nil for an unknown one. Pending transactions have no block fields.
+func (h *EthHandlers) transactionByHash(txHash [32]byte) (interface{}, error) {
+	tx, block, index, err := h.chain.GetTransaction(txHash)
+	if err == nil {
+		return h.formatTransaction(tx, block, index), nil
+	}
+	if err != blockchain.ErrTransactionNotFound {
+		return nil, err
+	}
+
+	pending := h.chain.GetPendingTransaction(txHash)
+	if pending == nil {
+		return nil, nil
+	}
+	return h.formatTransaction(pending, nil, 0), nil
 }
 
 func (h *EthHandlers) ethGetTransactionReceipt(params json.RawMessage) (interface{}, error) {
-	// TODO: Implement transaction receipt
-	return nil, nil
+	var args []string
+	if err := json.Unmarshal(params, &args); err != nil {
+		return nil, err
+	}
+
+	if len(args) < 1 {
+		return nil, fmt.Errorf("missing transaction hash parameter")
+	}
+
+	txHash, err := parseHash(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction hash: %v", err)
+	}
+
+	receipt, err := h.chain.GetReceipt(txHash)
+	if err == blockchain.ErrReceiptNotFound {
+		// Pending or unknown transactions have no receipt yet
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return formatReceipt(receipt), nil
 }
 
 func (h *EthHandlers) ethGetTransactionByBlockNumberAndIndex(params json.RawMessage) (interface{}, error) {
-	return nil, nil
+	var args []string
+	if err := json.Unmarshal(params, &args); err != nil {
+		return nil, err
+	}
+	if len(args) < 2 {
+		return nil, fmt.Errorf("missing block number or index parameter")
+	}
+
+	block, err := h.blockByTag(args[0])
+	if err != nil {
+		return nil, err
+	}
+	index, ok := new(big.Int).SetString(strings.TrimPrefix(args[1], "0x"), 16)
+	if !ok || !index.IsUint64() {
+		return nil, fmt.Errorf("invalid transaction index: %s", args[1])
+	}
+	if index.Uint64() >= uint64(len(block.Transactions)) {
+		return nil, nil
+	}
+	return h.formatTransaction(&block.Transactions[index.Uint64()], block, index.Uint64()), nil
 }
 
 func (h *EthHandlers) ethGetTransactionByBlockHashAndIndex(params json.RawMessage) (interface{}, error) {
@@ -342,84 +600,376 @@ func (h *EthHandlers) ethGetTransactionByBlockHashAndIndex(params json.RawMessag
 
 // Gas methods
 func (h *EthHandlers) ethGasPrice() (interface{}, error) {
-	// 1 Gwei = 1000000000 wei
-	return "0x3b9aca00", nil
+	// Never suggest less than the pool will accept
+	return fmt.Sprintf("0x%x", h.suggestedGasPrice()), nil
 }
 
+// ethEstimateGas dry-runs a transaction on top of the head and returns the
+// lowest gas limit it succeeds with. Params are [call, block]; only the
+// pending state is estimated against, whatever the block.
 func (h *EthHandlers) ethEstimateGas(params json.RawMessage) (interface{}, error) {
-	// Default gas for simple transfer: 21000
-	return "0x5208", nil
+	var args []json.RawMessage
+	if err := json.Unmarshal(params, &args); err != nil || len(args) == 0 {
+		return nil, fmt.Errorf("missing transaction parameter")
+	}
+	tx, err := h.parseCallArgs(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	gas, err := h.chain.EstimateGas(tx)
+	if err != nil {
+		return nil, err
+	}
+	return fmt.Sprintf("0x%x", gas), nil
 }
 
 func (h *EthHandlers) ethMaxPriorityFeePerGas() (interface{}, error) {
-	// 1.5 Gwei
-	return "0x59682f00", nil
+	return fmt.Sprintf("0x%x", h.suggestedTip()), nil
 }
 
 func (h *EthHandlers) ethFeeHistory(params json.RawMessage) (interface{}, error) {
 	return map[string]interface{}{
-		"baseFeePerGas": []string{"0x3b9aca00"},
+		"baseFeePerGas": []string{fmt.Sprintf("0x%x", ethBaseFee)},
 		"gasUsedRatio":  []float64{0.5},
 		"oldestBlock":   "0x1",
-		"reward":        [][]string{{"0x59682f00"}},
+		"reward":        [][]string{{fmt.Sprintf("0x%x", h.suggestedTip())}},
 	}, nil
 }
 
+// suggestedGasPrice is the pool's advisory minimum, but at least 1 Gwei
+func (h *EthHandlers) suggestedGasPrice() *big.Int {
+	price := new(big.Int).SetUint64(h.chain.GasPriceAdvisory().MinGasPrice)
+	if price.Cmp(ethBaseFee) < 0 {
+		price.Set(ethBaseFee)
+	}
+	return price
+}
+
+// suggestedTip is the priority fee that lifts base fee plus tip to the
+// advisory minimum, but at least 1.5 Gwei
+func (h *EthHandlers) suggestedTip() *big.Int {
+	tip := new(big.Int).Sub(h.suggestedGasPrice(), ethBaseFee)
+	if minTip := big.NewInt(1500000000); tip.Cmp(minTip) < 0 {
+		tip = minTip
+	}
+	return tip
+}
+
 // Call methods
+
+// ethCall executes a call on top of the head without a transaction and
+// returns its output. Params are [call, block]; as for eth_estimateGas,
+// calls run against the pending state whatever the block. A call that
+// reverts fails with the revert data.
 func (h *EthHandlers) ethCall(params json.RawMessage) (interface{}, error) {
-	// GYDS v1 doesn't support smart contract calls
-	return "0x", nil
-}
+	var args []json.RawMessage
+	if err := json.Unmarshal(params, &args); err != nil || len(args) == 0 {
+		return nil, fmt.Errorf("missing transaction parameter")
+	}
+	msg, err := h.parseCallArgs(args[0])
+	if err != nil {
+		return nil, err
+	}
 
-func (h *EthHandlers) ethGetLogs(params json.RawMessage) (interface{}, error) {
-	// Return empty logs for v1
-	return []interface{}{}, nil
+	ret, err := h.chain.Call(msg)
+	if err != nil {
+		return nil, err
+	}
+	return fmt.Sprintf("0x%x", ret), nil
 }
 
 // Sync status
 func (h *EthHandlers) ethSyncing() (interface{}, error) {
-	// TODO: Return actual sync status
-	return false, nil
+	if h.syncProgress == nil {
+		return false, nil
+	}
+	progress := h.syncProgress()
+	if !progress.Syncing {
+		return false, nil
+	}
+	return map[string]interface{}{
+		"startingBlock": fmt.Sprintf("0x%x", progress.StartingBlock),
+		"currentBlock":  fmt.Sprintf("0x%x", progress.CurrentBlock),
+		"highestBlock":  fmt.Sprintf("0x%x", progress.HighestBlock),
+	}, nil
 }
 
 // Helper methods
 func (h *EthHandlers) parseAddress(addr string) ([20]byte, error) {
-	var address [20]byte
-	addr = strings.TrimPrefix(addr, "0x")
-	if len(addr) != 40 {
-		return address, fmt.Errorf("invalid address length")
+	address, err := blockchain.ParseAddress(addr, h.strictChecksum)
+	if err != nil {
+		return address, fmt.Errorf("%s: %v", addr, err)
+	}
+	return address, nil
+}
+
+// callArgs is the transaction object of eth_call and eth_estimateGas
+type callArgs struct {
+	From         string `json:"from"`
+	To           string `json:"to"`
+	Gas          string `json:"gas"`
+	GasPrice     string `json:"gasPrice"`
+	MaxFeePerGas string `json:"maxFeePerGas"`
+	Value        string `json:"value"`
+	Data         string `json:"data"`
+	Input        string `json:"input"`
+}
+
+// parseCallArgs decodes a call object into an unsigned transaction. Absent
+// fields are zero, except that without "to" the call deploys a contract.
+func (h *EthHandlers) parseCallArgs(raw json.RawMessage) (*blockchain.Transaction, error) {
+	var args callArgs
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, fmt.Errorf("invalid transaction object: %v", err)
+	}
+
+	tx := &blockchain.Transaction{Value: big.NewInt(0)}
+	var err error
+	if args.From != "" {
+		if tx.From, err = h.parseAddress(args.From); err != nil {
+			return nil, err
+		}
+	}
+	if args.To != "" {
+		if tx.To, err = h.parseAddress(args.To); err != nil {
+			return nil, err
+		}
+	} else {
+		tx.Create = true
+	}
+
+	gasPrice := args.GasPrice
+	if gasPrice == "" {
+		gasPrice = args.MaxFeePerGas
+	}
+	for _, field := range []struct {
+		name  string
+		value string
+		out   *uint64
+	}{
+		{"gas", args.Gas, &tx.GasLimit},
+		{"gasPrice", gasPrice, &tx.GasPrice},
+	} {
+		if field.value == "" {
+			continue
+		}
+		n, ok := new(big.Int).SetString(strings.TrimPrefix(field.value, "0x"), 16)
+		if !ok || !n.IsUint64() {
+			return nil, fmt.Errorf("invalid %s: %s", field.name, field.value)
+		}
+		*field.out = n.Uint64()
 	}
+	if args.Value != "" {
+		value, ok := new(big.Int).SetString(strings.TrimPrefix(args.Value, "0x"), 16)
+		if !ok || value.Sign() < 0 {
+			return nil, fmt.Errorf("invalid value: %s", args.Value)
+		}
+		tx.Value = value
+	}
+
+	input := args.Input
+	if input == "" {
+		input = args.Data
+	}
+	if tx.Data, err = hex.DecodeString(strings.TrimPrefix(input, "0x")); err != nil {
+		return nil, fmt.Errorf("invalid data: %v", err)
+	}
+	if len(tx.Data) > blockchain.MaxTxDataSize {
+		return nil, fmt.Errorf("data exceeds %d bytes", blockchain.MaxTxDataSize)
+	}
+	return tx, nil
+}
+
+// resolveBlockNumber converts a block tag or hex number into a height.
+// Blocks are built as they are proposed, so there is no separate pending
+// state and "pending" resolves to the head. "finalized" and "safe" both
+// resolve to the last block finalized by PoS votes, which can never be
+// reverted.
+func (h *EthHandlers) resolveBlockNumber(tag string) (uint64, error) {
+	switch tag {
+	case "latest", "pending", "":
+		return h.chain.GetCurrentBlock().Header.Height, nil
+	case "earliest":
+		return 0, nil
+	case "finalized", "safe":
+		if h.finalizedHeight == nil {
+			return 0, fmt.Errorf("finalized block not available")
+		}
+		return h.finalizedHeight(), nil
+	}
+
+	n, ok := new(big.Int).SetString(strings.TrimPrefix(tag, "0x"), 16)
+	if !ok || !n.IsUint64() {
+		return 0, fmt.Errorf("invalid block number: %s", tag)
+	}
+	return n.Uint64(), nil
+}
 
-	bytes, err := hex.DecodeString(addr)
+// blockByTag returns the block a block tag or hex number refers to
+func (h *EthHandlers) blockByTag(tag string) (*blockchain.Block, error) {
+	height, err := h.resolveBlockNumber(tag)
 	if err != nil {
-		return address, err
+		return nil, err
 	}
+	return h.chain.GetBlock(height)
+}
 
-	copy(address[:], bytes)
-	return address, nil
+func parseHash(s string) ([32]byte, error) {
+	var hash [32]byte
+	s = strings.TrimPrefix(s, "0x")
+	if len(s) > 64 {
+		return hash, fmt.Errorf("invalid hash length")
+	}
+	if len(s)%2 == 1 {
+		s = "0" + s
+	}
+
+	bytes, err := hex.DecodeString(s)
+	if err != nil {
+		return hash, err
+	}
+
+	// Left-pad short values such as storage slot "0x0"
+	copy(hash[32-len(bytes):], bytes)
+	return hash, nil
 }
 
-func (h *EthHandlers) parseTransaction(data []byte) (*blockchain.Transaction, error) {
-	// Parse RLP-encoded transaction
-	// This is a simplified version - production would use proper RLP decoding
-	tx := &blockchain.Transaction{
-		// Parse fields from RLP data
+func formatReceipt(r *blockchain.Receipt) map[string]interface{} {
+	logs := make([]map[string]interface{}, len(r.Logs))
+	for i, l := range r.Logs {
+		logs[i] = formatLog(r, l)
+	}
+
+	receipt := map[string]interface{}{
+		"transactionHash":   fmt.Sprintf("0x%x", r.TxHash),
+		"transactionIndex":  fmt.Sprintf("0x%x", r.TxIndex),
+		"blockHash":         fmt.Sprintf("0x%x", r.BlockHash),
+		"blockNumber":       fmt.Sprintf("0x%x", r.BlockNumber),
+		"from":              blockchain.ChecksumAddress(r.From),
+		"to":                blockchain.ChecksumAddress(r.To),
+		"cumulativeGasUsed": fmt.Sprintf("0x%x", r.CumulativeGasUsed),
+		"gasUsed":           fmt.Sprintf("0x%x", r.GasUsed),
+		"effectiveGasPrice": fmt.Sprintf("0x%x", r.EffectiveGasPrice),
+		"contractAddress":   nil,
+		"logs":              logs,
+		"logsBloom":         fmt.Sprintf("0x%x", blockchain.CreateBloom([]*blockchain.Receipt{r})),
+		"status":            fmt.Sprintf("0x%x", r.Status),
+		"type":              "0x0",
+	}
+	if r.Create {
+		receipt["to"] = nil
+	}
+	if r.ContractAddress != ([20]byte{}) {
+		receipt["contractAddress"] = blockchain.ChecksumAddress(r.ContractAddress)
+	}
+	// Not part of the Ethereum schema; clients that don't know it ignore it
+	if r.FailureReason != "" {
+		receipt["failureReason"] = r.FailureReason
+	}
+	return receipt
+}
+
+// formatLog formats a log emitted by the receipt's transaction
+func formatLog(r *blockchain.Receipt, l *blockchain.Log) map[string]interface{} {
+	topics := make([]string, len(l.Topics))
+	for i, topic := range l.Topics {
+		topics[i] = fmt.Sprintf("0x%x", topic)
+	}
+	return map[string]interface{}{
+		"address":          blockchain.ChecksumAddress(l.Address),
+		"topics":           topics,
+		"data":             fmt.Sprintf("0x%x", l.Data),
+		"blockNumber":      fmt.Sprintf("0x%x", r.BlockNumber),
+		"blockHash":        fmt.Sprintf("0x%x", r.BlockHash),
+		"transactionHash":  fmt.Sprintf("0x%x", r.TxHash),
+		"transactionIndex": fmt.Sprintf("0x%x", r.TxIndex),
+		"logIndex":         fmt.Sprintf("0x%x", l.LogIndex),
+		"removed":          false,
 	}
-	return tx, nil
+}
+
+func formatMerkleProof(proof *blockchain.MerkleProof) []string {
+	if proof == nil {
+		return []string{}
+	}
+	nodes := make([]string, len(proof.Siblings))
+	for i, sibling := range proof.Siblings {
+		nodes[i] = fmt.Sprintf("0x%x", sibling)
+	}
+	return nodes
+}
+
+func formatTrieSiblings(proof *blockchain.TrieProof) []string {
+	if proof == nil {
+		return []string{}
+	}
+	nodes := make([]string, len(proof.Siblings))
+	for i, sibling := range proof.Siblings {
+		nodes[i] = fmt.Sprintf("0x%x", sibling)
+	}
+	return nodes
+}
+
+// formatTrieLeaf returns the leaf terminating a trie proof, or nil if the
+// path ends in an empty subtree
+func formatTrieLeaf(proof *blockchain.TrieProof) map[string]string {
+	if proof == nil || proof.LeafValue == ([32]byte{}) {
+		return nil
+	}
+	return map[string]string{
+		"key":   fmt.Sprintf("0x%x", proof.LeafKey),
+		"value": fmt.Sprintf("0x%x", proof.LeafValue),
+	}
+}
+
+func formatAccountProof(proof *blockchain.AccountProof) map[string]interface{} {
+	storageProof := make([]map[string]interface{}, len(proof.StorageProof))
+	for i, sp := range proof.StorageProof {
+		entry := map[string]interface{}{
+			"key":   fmt.Sprintf("0x%x", sp.Key),
+			"value": fmt.Sprintf("0x%x", new(big.Int).SetBytes(sp.Value[:])),
+			"proof": formatTrieSiblings(sp.Proof),
+		}
+		if leaf := formatTrieLeaf(sp.Proof); leaf != nil {
+			entry["proofLeaf"] = leaf
+		}
+		storageProof[i] = entry
+	}
+
+	result := map[string]interface{}{
+		"address":      blockchain.ChecksumAddress(proof.Address),
+		"accountProof": formatTrieSiblings(proof.Proof),
+		"balance":      fmt.Sprintf("0x%x", proof.Balance),
+		"codeHash":     fmt.Sprintf("0x%x", proof.CodeHash),
+		"nonce":        fmt.Sprintf("0x%x", proof.Nonce),
+		"storageHash":  fmt.Sprintf("0x%x", proof.StorageRoot),
+		"storageProof": storageProof,
+		"stateRoot":    fmt.Sprintf("0x%x", proof.StateRoot),
+		"blockNumber":  fmt.Sprintf("0x%x", proof.Height),
+	}
+	if leaf := formatTrieLeaf(proof.Proof); leaf != nil {
+		result["accountProofLeaf"] = leaf
+	}
+	return result
+}
+
+func (h *EthHandlers) parseTransaction(data []byte) (*blockchain.Transaction, error) {
+	return decodeRawTransaction(data)
 }
 
 func (h *EthHandlers) formatBlock(block *blockchain.Block, fullTx bool) map[string]interface{} {
 	result := map[string]interface{}{
 		"number":           fmt.Sprintf("0x%x", block.Header.Height),
 		"hash":             fmt.Sprintf("0x%s", block.HashHex()),
-		"parentHash":       fmt.Sprintf("0x%x", block.Header.ParentHash),
+		"parentHash":       fmt.Sprintf("0x%x", block.Header.PrevHash),
 		"nonce":            fmt.Sprintf("0x%016x", block.Header.Nonce),
 		"sha3Uncles":       "0x0000000000000000000000000000000000000000000000000000000000000000",
-		"logsBloom":        "0x" + strings.Repeat("0", 512),
+		"logsBloom":        fmt.Sprintf("0x%x", block.Header.LogsBloom),
 		"transactionsRoot": fmt.Sprintf("0x%x", block.Header.TxRoot),
 		"stateRoot":        fmt.Sprintf("0x%x", block.Header.StateRoot),
-		"receiptsRoot":     "0x0000000000000000000000000000000000000000000000000000000000000000",
-		"miner":            fmt.Sprintf("0x%x", block.Header.ProposerAddress),
+		"receiptsRoot":     fmt.Sprintf("0x%x", block.Header.ReceiptsRoot),
+		"miner":            blockchain.ChecksumAddress(block.Header.ProposerAddr),
 		"difficulty":       fmt.Sprintf("0x%x", block.Header.Difficulty),
 		"totalDifficulty":  fmt.Sprintf("0x%x", block.Header.Difficulty),
 		"extraData":        fmt.Sprintf("0x%x", block.Header.ExtraData),
@@ -433,14 +983,14 @@ func (h *EthHandlers) formatBlock(block *blockchain.Block, fullTx bool) map[stri
 
 	if fullTx {
 		txs := make([]map[string]interface{}, len(block.Transactions))
-		for i, tx := range block.Transactions {
-			txs[i] = h.formatTransaction(tx, block, uint64(i))
+		for i := range block.Transactions {
+			txs[i] = h.formatTransaction(&block.Transactions[i], block, uint64(i))
 		}
 		result["transactions"] = txs
 	} else {
 		txHashes := make([]string, len(block.Transactions))
 		for i, tx := range block.Transactions {
-			txHashes[i] = fmt.Sprintf("0x%s", tx.HashHex())
+			txHashes[i] = fmt.Sprintf("0x%x", tx.Hash)
 		}
 		result["transactions"] = txHashes
 	}
@@ -448,22 +998,54 @@ func (h *EthHandlers) formatBlock(block *blockchain.Block, fullTx bool) map[stri
 	return result
 }
 
+// formatTransaction formats a transaction at index in block. A pending
+// transaction has a nil block, and null block fields.
 func (h *EthHandlers) formatTransaction(tx *blockchain.Transaction, block *blockchain.Block, index uint64) map[string]interface{} {
-	return map[string]interface{}{
-		"hash":             fmt.Sprintf("0x%s", tx.HashHex()),
+	result := map[string]interface{}{
+		"hash":             fmt.Sprintf("0x%x", tx.Hash),
 		"nonce":            fmt.Sprintf("0x%x", tx.Nonce),
-		"blockHash":        fmt.Sprintf("0x%s", block.HashHex()),
-		"blockNumber":      fmt.Sprintf("0x%x", block.Header.Height),
-		"transactionIndex": fmt.Sprintf("0x%x", index),
-		"from":             fmt.Sprintf("0x%x", tx.From),
-		"to":               fmt.Sprintf("0x%x", tx.To),
+		"blockHash":        nil,
+		"blockNumber":      nil,
+		"transactionIndex": nil,
+		"from":             blockchain.ChecksumAddress(tx.From),
+		"to":               blockchain.ChecksumAddress(tx.To),
 		"value":            fmt.Sprintf("0x%x", tx.Value),
 		"gas":              fmt.Sprintf("0x%x", tx.GasLimit),
 		"gasPrice":         fmt.Sprintf("0x%x", tx.GasPrice),
-		"input":            "0x",
-		"v":                "0x0",
-		"r":                "0x0",
-		"s":                "0x0",
-		"type":             "0x0",
+		"input":            fmt.Sprintf("0x%x", tx.Data),
+		"v":                fmt.Sprintf("0x%x", tx.Signature[64]),
+		"r":                fmt.Sprintf("0x%x", new(big.Int).SetBytes(tx.Signature[:32])),
+		"s":                fmt.Sprintf("0x%x", new(big.Int).SetBytes(tx.Signature[32:64])),
+		"type":             fmt.Sprintf("0x%x", tx.Version),
+		"chainId":          fmt.Sprintf("0x%x", tx.ChainID),
+	}
+	switch tx.Version {
+	case blockchain.LegacyTxType:
+		result["v"] = fmt.Sprintf("0x%x", tx.ChainID*2+35+uint64(tx.Signature[64]))
+	case blockchain.DynamicFeeTxType:
+		result["maxPriorityFeePerGas"] = fmt.Sprintf("0x%x", tx.GasTipCap)
+		result["maxFeePerGas"] = fmt.Sprintf("0x%x", tx.GasFeeCap)
+		result["yParity"] = result["v"]
+		accessList := make([]map[string]interface{}, len(tx.AccessList))
+		for i, tuple := range tx.AccessList {
+			keys := make([]string, len(tuple.StorageKeys))
+			for j, key := range tuple.StorageKeys {
+				keys[j] = fmt.Sprintf("0x%x", key)
+			}
+			accessList[i] = map[string]interface{}{"address": blockchain.ChecksumAddress(tuple.Address), "storageKeys": keys}
+		}
+		result["accessList"] = accessList
 	}
+	if tx.IsContractCreation() {
+		result["to"] = nil
+	}
+	if block != nil {
+		result["blockHash"] = fmt.Sprintf("0x%s", block.HashHex())
+		result["blockNumber"] = fmt.Sprintf("0x%x", block.Header.Height)
+		result["transactionIndex"] = fmt.Sprintf("0x%x", index)
+	}
+	if memo, ok := tx.Memo(); ok {
+		result["memo"] = memo
+	}
+	return result
 }
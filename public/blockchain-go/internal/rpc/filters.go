@@ -0,0 +1,435 @@
+// Package rpc - Polled block, pending transaction and log filters
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"chaincore/internal/blockchain"
+)
+
+// Filter limits
+const (
+	filterTimeout         = 5 * time.Minute // Filters not polled for this long are removed
+	filterCleanupInterval = time.Minute
+	maxFiltersPerClient   = 64
+	maxFilterChanges      = 10000 // Changes kept between polls; older ones are dropped
+	maxLogQueryBlocks     = 10000 // Blocks one eth_getLogs call may cover
+	maxLogQueryResults    = 10000 // Logs one eth_getLogs call may return
+)
+
+// Filter errors
+var (
+	ErrFilterNotFound = errors.New("filter not found")
+	ErrTooManyFilters = errors.New("too many filters")
+)
+
+// FilterKind is the kind of events a filter collects
+type FilterKind int
+
+// Filter kinds
+const (
+	FilterBlocks              FilterKind = iota // Hashes of new head blocks
+	FilterPendingTransactions                   // Hashes of transactions added to the pool
+	FilterLogs                                  // Logs of new head blocks
+)
+
+// FilterManager keeps the installed filters and collects their changes.
+// Filters belong to the client that installed them, by API key or IP
+// address, and are removed if not polled for filterTimeout.
+type FilterManager struct {
+	chain    *blockchain.Blockchain
+	filters  map[string]*filter
+	byClient map[string]int // Installed filters per client
+	stopCh   chan struct{}
+	mu       sync.Mutex
+}
+
+type filter struct {
+	kind     FilterKind
+	client   string
+	logs     *logFilter // Log filters only
+	from     uint64     // Block range of a log filter
+	to       uint64
+	changes  []interface{}
+	lastPoll time.Time
+}
+
+// NewFilterManager creates a filter manager for a chain
+func NewFilterManager(chain *blockchain.Blockchain) *FilterManager {
+	return &FilterManager{
+		chain:    chain,
+		filters:  make(map[string]*filter),
+		byClient: make(map[string]int),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start begins collecting chain events and removing expired filters
+func (m *FilterManager) Start() {
+	m.chain.OnNewHead(m.onHead)
+	m.chain.OnPendingTransaction(m.onPendingTransaction)
+	go m.cleanupLoop()
+}
+
+// Stop stops removing expired filters
+func (m *FilterManager) Stop() {
+	select {
+	case <-m.stopCh:
+	default:
+		close(m.stopCh)
+	}
+}
+
+// Install adds a filter for client and returns its ID. logs and the block
+// range apply to log filters only.
+func (m *FilterManager) Install(client string, kind FilterKind, logs *logFilter, from, to uint64) (string, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+	id = "0x" + id
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.byClient[client] >= maxFiltersPerClient {
+		return "", ErrTooManyFilters
+	}
+	m.filters[id] = &filter{
+		kind:     kind,
+		client:   client,
+		logs:     logs,
+		from:     from,
+		to:       to,
+		lastPoll: time.Now(),
+	}
+	m.byClient[client]++
+	return id, nil
+}
+
+// Changes returns and clears the changes collected by a client's filter
+// since the last call
+func (m *FilterManager) Changes(client, id string) ([]interface{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.filters[id]
+	if !ok || f.client != client {
+		return nil, ErrFilterNotFound
+	}
+	changes := f.changes
+	if changes == nil {
+		changes = []interface{}{}
+	}
+	f.changes = nil
+	f.lastPoll = time.Now()
+	return changes, nil
+}
+
+// Uninstall removes a client's filter and reports whether it existed
+func (m *FilterManager) Uninstall(client, id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.filters[id]
+	if !ok || f.client != client {
+		return false
+	}
+	m.remove(id, f)
+	return true
+}
+
+// Cleanup removes filters not polled within filterTimeout and returns how
+// many were removed
+func (m *FilterManager) Cleanup() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	removed := 0
+	for id, f := range m.filters {
+		if time.Since(f.lastPoll) > filterTimeout {
+			m.remove(id, f)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Filter RPC methods
+
+func (h *EthHandlers) ethNewFilter(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var args []json.RawMessage
+	if err := json.Unmarshal(params, &args); err != nil || len(args) == 0 {
+		return nil, fmt.Errorf("missing filter parameter")
+	}
+	logs, err := h.parseLogFilter(args[0])
+	if err != nil {
+		return nil, err
+	}
+	from, to, err := h.parseFilterRange(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return h.installFilter(ctx, FilterLogs, logs, from, to)
+}
+
+func (h *EthHandlers) ethGetFilterChanges(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	id, err := filterIDParam(params)
+	if err != nil {
+		return nil, err
+	}
+	if h.filters == nil {
+		return nil, ErrFilterNotFound
+	}
+	return h.filters.Changes(clientFromContext(ctx), id)
+}
+
+func (h *EthHandlers) ethUninstallFilter(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	id, err := filterIDParam(params)
+	if err != nil {
+		return nil, err
+	}
+	if h.filters == nil {
+		return false, nil
+	}
+	return h.filters.Uninstall(clientFromContext(ctx), id), nil
+}
+
+// ethGetLogs queries past blocks, skipping those whose logs bloom rules the
+// filter out. Blocks are not indexed by hash, so blockHash is rejected.
+func (h *EthHandlers) ethGetLogs(params json.RawMessage) (interface{}, error) {
+	var args []json.RawMessage
+	if err := json.Unmarshal(params, &args); err != nil || len(args) == 0 {
+		return nil, fmt.Errorf("missing filter parameter")
+	}
+	logs, err := h.parseLogFilter(args[0])
+	if err != nil {
+		return nil, err
+	}
+	from, to, err := h.parseQueryRange(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	results := []interface{}{}
+	for height := from; height <= to; height++ {
+		block, err := h.chain.GetBlock(height)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %v", height, err)
+		}
+		if !logs.mayMatch(&block.Header.LogsBloom) {
+			continue
+		}
+		receipts, err := h.chain.GetBlockReceipts(block)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %v", height, err)
+		}
+		for _, receipt := range receipts {
+			for _, l := range receipt.Logs {
+				if !logs.matches(l) {
+					continue
+				}
+				if len(results) == maxLogQueryResults {
+					return nil, fmt.Errorf("query returns more than %d logs", maxLogQueryResults)
+				}
+				results = append(results, formatLog(receipt, l))
+			}
+		}
+	}
+	return results, nil
+}
+
+// installFilter installs a filter for the requesting client
+func (h *EthHandlers) installFilter(ctx context.Context, kind FilterKind, logs *logFilter, from, to uint64) (interface{}, error) {
+	if h.filters == nil {
+		return nil, fmt.Errorf("filters are not available")
+	}
+	return h.filters.Install(clientFromContext(ctx), kind, logs, from, to)
+}
+
+// parseFilterRange parses the block range of a log filter object. Open
+// ends and the "latest" and "pending" tags leave the range unbounded,
+// since a filter only reports blocks that arrive after it is installed.
+func (h *EthHandlers) parseFilterRange(raw json.RawMessage) (uint64, uint64, error) {
+	var args struct {
+		FromBlock string `json:"fromBlock"`
+		ToBlock   string `json:"toBlock"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return 0, 0, fmt.Errorf("invalid log filter: %v", err)
+	}
+
+	from, to := uint64(0), uint64(math.MaxUint64)
+	var err error
+	if !openBlockTag(args.FromBlock) {
+		if from, err = h.resolveBlockNumber(args.FromBlock); err != nil {
+			return 0, 0, err
+		}
+	}
+	if !openBlockTag(args.ToBlock) {
+		if to, err = h.resolveBlockNumber(args.ToBlock); err != nil {
+			return 0, 0, err
+		}
+	}
+	if from > to {
+		return 0, 0, fmt.Errorf("invalid block range: fromBlock %d is after toBlock %d", from, to)
+	}
+	return from, to, nil
+}
+
+// parseQueryRange parses the block range of an eth_getLogs filter object.
+// Missing ends default to the head, and the range is capped there.
+func (h *EthHandlers) parseQueryRange(raw json.RawMessage) (uint64, uint64, error) {
+	var args struct {
+		FromBlock string `json:"fromBlock"`
+		ToBlock   string `json:"toBlock"`
+		BlockHash string `json:"blockHash"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return 0, 0, fmt.Errorf("invalid log filter: %v", err)
+	}
+	if args.BlockHash != "" {
+		return 0, 0, fmt.Errorf("blockHash filters are not supported; use fromBlock and toBlock")
+	}
+
+	from, err := h.resolveBlockNumber(args.FromBlock)
+	if err != nil {
+		return 0, 0, err
+	}
+	to, err := h.resolveBlockNumber(args.ToBlock)
+	if err != nil {
+		return 0, 0, err
+	}
+	if head := h.chain.GetCurrentBlock().Header.Height; to > head {
+		to = head
+	}
+	if from > to {
+		return 0, 0, fmt.Errorf("invalid block range: fromBlock %d is after toBlock %d", from, to)
+	}
+	if to-from >= maxLogQueryBlocks {
+		return 0, 0, fmt.Errorf("block range exceeds %d blocks", maxLogQueryBlocks)
+	}
+	return from, to, nil
+}
+
+// Helper functions
+
+func (m *FilterManager) cleanupLoop() {
+	ticker := time.NewTicker(filterCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stopCh:
+			return
+		case <-ticker.C:
+			m.Cleanup()
+		}
+	}
+}
+
+// onHead collects a new head block for block and log filters. Receipts
+// are only read back when a log filter covers the block.
+func (m *FilterManager) onHead(block *blockchain.Block) {
+	hash := block.Hash()
+	height := block.Header.Height
+
+	m.mu.Lock()
+	wantLogs := false
+	for _, f := range m.filters {
+		switch f.kind {
+		case FilterBlocks:
+			f.add(fmt.Sprintf("0x%x", hash))
+		case FilterLogs:
+			wantLogs = wantLogs || (height >= f.from && height <= f.to)
+		}
+	}
+	m.mu.Unlock()
+	if !wantLogs {
+		return
+	}
+
+	var receipts []*blockchain.Receipt
+	for i := range block.Transactions {
+		if receipt, err := m.chain.GetReceipt(block.Transactions[i].Hash); err == nil {
+			receipts = append(receipts, receipt)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, f := range m.filters {
+		if f.kind != FilterLogs || height < f.from || height > f.to {
+			continue
+		}
+		for _, receipt := range receipts {
+			for _, l := range receipt.Logs {
+				if f.logs.matches(l) {
+					f.add(formatLog(receipt, l))
+				}
+			}
+		}
+	}
+}
+
+// onPendingTransaction collects a transaction added to the pool for
+// pending transaction filters
+func (m *FilterManager) onPendingTransaction(tx *blockchain.Transaction) {
+	hash := fmt.Sprintf("0x%x", tx.Hash)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, f := range m.filters {
+		if f.kind == FilterPendingTransactions {
+			f.add(hash)
+		}
+	}
+}
+
+// remove deletes a filter. The caller must hold mu.
+func (m *FilterManager) remove(id string, f *filter) {
+	delete(m.filters, id)
+	if m.byClient[f.client]--; m.byClient[f.client] <= 0 {
+		delete(m.byClient, f.client)
+	}
+}
+
+// add appends a change, dropping the oldest once maxFilterChanges are kept
+func (f *filter) add(change interface{}) {
+	if len(f.changes) >= maxFilterChanges {
+		f.changes = f.changes[1:]
+	}
+	f.changes = append(f.changes, change)
+}
+
+func filterIDParam(params json.RawMessage) (string, error) {
+	var args []string
+	if err := json.Unmarshal(params, &args); err != nil || len(args) == 0 {
+		return "", fmt.Errorf("missing filter ID")
+	}
+	return args[0], nil
+}
+
+func openBlockTag(tag string) bool {
+	return tag == "" || tag == "latest" || tag == "pending"
+}
+
+type clientContextKey struct{}
+
+// withClient attaches the identity filters are owned by to a request
+// context: the API key's ID, or else the client's host
+func withClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientContextKey{}, client)
+}
+
+// clientFromContext returns the identity attached by withClient
+func clientFromContext(ctx context.Context) string {
+	client, _ := ctx.Value(clientContextKey{}).(string)
+	return client
+}
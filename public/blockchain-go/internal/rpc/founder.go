@@ -0,0 +1,287 @@
+// Package rpc - Founder token methods, authorized separately from everything else
+package rpc
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/token"
+)
+
+// maxOperationsPerCall bounds the operations returned by one
+// token_getOperations call
+const maxOperationsPerCall = 100
+
+// founderTokenHeader carries the JWT authorizing a founder request
+const founderTokenHeader = "X-Founder-Token"
+
+// ErrFounderOnly is returned for a founder method called without a valid
+// founder token
+var ErrFounderOnly = errors.New("method requires founder authorization")
+
+// founderContextKey marks requests that carried a valid founder token
+type founderContextKey struct{}
+
+// SetFounder enables the token_ methods on a founder node: tm holds the
+// token state, key is the founder authorization key and wallet the address
+// operations are recorded as made by. Methods that change supply or price
+// need a founder token signed with key. It must be called before Start.
+func (s *Server) SetFounder(tm *token.TokenManager, key []byte, wallet [20]byte) {
+	s.tokens = tm
+	s.founderKey = key
+	s.founder = wallet
+}
+
+// authenticateFounder checks a request's founder token, if it has one, and
+// reports whether it was valid
+func (s *Server) authenticateFounder(r *http.Request) (bool, error) {
+	jwt := strings.TrimSpace(r.Header.Get(founderTokenHeader))
+	if jwt == "" || s.founderKey == nil {
+		return false, nil
+	}
+	if _, err := verifyJWT(jwt, s.founderKey, time.Now()); err != nil {
+		return false, fmt.Errorf("founder token: %w", err)
+	}
+	return true, nil
+}
+
+// getTokenStats returns the token's supply, GYDS burned, USDT taken in by
+// the bridge and price
+func (s *Server) getTokenStats() (interface{}, error) {
+	if s.tokens == nil {
+		return nil, errors.New("token methods are only served by the founder node")
+	}
+	totalSupply, circulating, burned, price := s.tokens.GetStats()
+	return map[string]interface{}{
+		"totalSupply":       totalSupply.String(),
+		"circulatingSupply": circulating.String(),
+		"burnedTotal":       burned.String(),
+		"usdtBurned":        s.tokens.GetUSDTBurned().String(),
+		"price":             price.Text('g', -1),
+	}, nil
+}
+
+// getTokenOperations pages through the operation journal, oldest first.
+// Params are [offset, count, filter]; filter is an object with optional
+// type ("mint" or "burn"), wallet, and from and to times (unix seconds or
+// RFC3339, to exclusive). At most maxOperationsPerCall are returned, and
+// nextOffset is set while more match.
+func (s *Server) getTokenOperations(params json.RawMessage) (interface{}, error) {
+	if s.tokens == nil {
+		return nil, errors.New("token methods are only served by the founder node")
+	}
+	var args []json.RawMessage
+	if len(params) > 0 && string(params) != "null" {
+		if err := json.Unmarshal(params, &args); err != nil || len(args) > 3 {
+			return nil, fmt.Errorf("params must be [offset, count, filter]")
+		}
+	}
+	offset, count := 0, maxOperationsPerCall
+	if len(args) > 0 {
+		if err := json.Unmarshal(args[0], &offset); err != nil || offset < 0 {
+			return nil, fmt.Errorf("invalid offset")
+		}
+	}
+	if len(args) > 1 {
+		if err := json.Unmarshal(args[1], &count); err != nil || count <= 0 {
+			return nil, fmt.Errorf("invalid count")
+		}
+	}
+	if count > maxOperationsPerCall {
+		count = maxOperationsPerCall
+	}
+	var filter token.OperationFilter
+	if len(args) > 2 {
+		var err error
+		if filter, err = s.parseOperationFilter(args[2]); err != nil {
+			return nil, err
+		}
+	}
+
+	operations, total, err := s.tokens.GetOperations(filter, offset, count)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]map[string]interface{}, len(operations))
+	for i, op := range operations {
+		list[i] = formatOperation(&op)
+	}
+
+	result := map[string]interface{}{
+		"operations": list,
+		"total":      total,
+		"nextOffset": nil,
+	}
+	if next := offset + len(operations); next < total {
+		result["nextOffset"] = next
+	}
+	return result, nil
+}
+
+// mintTokens mints tokens to an address. Params are [address, amount],
+// the amount in wei as a decimal string.
+func (s *Server) mintTokens(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	tokens, err := s.founderTokens(ctx)
+	if err != nil {
+		return nil, err
+	}
+	addr, amount, err := s.parseAddressAmount(params)
+	if err != nil {
+		return nil, err
+	}
+	op, err := tokens.DirectMint(amount, addr, s.founder)
+	if err != nil {
+		return nil, err
+	}
+	return formatOperation(op), nil
+}
+
+// burnTokens burns tokens held by an address. Params are [address, amount].
+func (s *Server) burnTokens(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	tokens, err := s.founderTokens(ctx)
+	if err != nil {
+		return nil, err
+	}
+	addr, amount, err := s.parseAddressAmount(params)
+	if err != nil {
+		return nil, err
+	}
+	op, err := tokens.BurnTokens(amount, addr, s.founder)
+	if err != nil {
+		return nil, err
+	}
+	return formatOperation(op), nil
+}
+
+// setTokenPrice changes the token price. Params are [price], a decimal
+// string.
+func (s *Server) setTokenPrice(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	tokens, err := s.founderTokens(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var args []string
+	if err := json.Unmarshal(params, &args); err != nil || len(args) != 1 {
+		return nil, fmt.Errorf("params must be [price]")
+	}
+	price, ok := new(big.Float).SetString(args[0])
+	if !ok {
+		return nil, fmt.Errorf("invalid price: %s", args[0])
+	}
+	if err := tokens.SetPrice(price); err != nil {
+		return nil, err
+	}
+	return price.Text('g', -1), nil
+}
+
+// Helper functions
+
+// founderTokens returns the token manager if the request carried a valid
+// founder token
+func (s *Server) founderTokens(ctx context.Context) (*token.TokenManager, error) {
+	if s.tokens == nil {
+		return nil, errors.New("token methods are only served by the founder node")
+	}
+	if !isFounderRequest(ctx) {
+		return nil, ErrFounderOnly
+	}
+	return s.tokens, nil
+}
+
+// isFounderRequest reports whether a request carried a valid founder token
+func isFounderRequest(ctx context.Context) bool {
+	founder, _ := ctx.Value(founderContextKey{}).(bool)
+	return founder
+}
+
+// parseAddressAmount parses [address, amount] params
+func (s *Server) parseAddressAmount(params json.RawMessage) ([20]byte, *big.Int, error) {
+	var args []string
+	if err := json.Unmarshal(params, &args); err != nil || len(args) != 2 {
+		return [20]byte{}, nil, fmt.Errorf("params must be [address, amount]")
+	}
+	addr, err := s.eth.parseAddress(args[0])
+	if err != nil {
+		return [20]byte{}, nil, err
+	}
+	amount, ok := new(big.Int).SetString(args[1], 10)
+	if !ok {
+		return [20]byte{}, nil, fmt.Errorf("invalid amount: %s", args[1])
+	}
+	return addr, amount, nil
+}
+
+// formatOperation encodes a token operation for RPC results
+// parseOperationFilter decodes a token_getOperations filter object
+func (s *Server) parseOperationFilter(raw json.RawMessage) (token.OperationFilter, error) {
+	var filter token.OperationFilter
+	var args struct {
+		Type   string `json:"type"`
+		Wallet string `json:"wallet"`
+		From   string `json:"from"`
+		To     string `json:"to"`
+	}
+	if string(raw) == "null" {
+		return filter, nil
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return filter, fmt.Errorf("invalid filter: %w", err)
+	}
+
+	switch args.Type {
+	case "":
+	case "mint", "burn":
+		kind := token.Mint
+		if args.Type == "burn" {
+			kind = token.Burn
+		}
+		filter.Type = &kind
+	default:
+		return filter, fmt.Errorf("invalid type %q: must be mint or burn", args.Type)
+	}
+	if args.Wallet != "" {
+		wallet, err := s.eth.parseAddress(args.Wallet)
+		if err != nil {
+			return filter, fmt.Errorf("invalid wallet: %w", err)
+		}
+		filter.Wallet = &wallet
+	}
+	var err error
+	if filter.From, err = parseTimeParam(args.From); err != nil {
+		return filter, fmt.Errorf("invalid from: %w", err)
+	}
+	if filter.To, err = parseTimeParam(args.To); err != nil {
+		return filter, fmt.Errorf("invalid to: %w", err)
+	}
+	return filter, nil
+}
+
+func formatOperation(op *token.Operation) map[string]interface{} {
+	kind := "mint"
+	if op.Type == token.Burn {
+		kind = "burn"
+	}
+	result := map[string]interface{}{
+		"index":     op.Index,
+		"id":        "0x" + hex.EncodeToString(op.ID[:]),
+		"type":      kind,
+		"amount":    op.Amount.String(),
+		"wallet":    blockchain.ChecksumAddress(op.WalletAddress),
+		"txHash":    "0x" + hex.EncodeToString(op.TxHash[:]),
+		"createdBy": blockchain.ChecksumAddress(op.CreatedBy),
+		"createdAt": op.CreatedAt.Unix(),
+		"status":    op.Status,
+	}
+	if op.USDTAmount != nil {
+		result["usdtAmount"] = op.USDTAmount.String()
+	}
+	return result
+}
@@ -0,0 +1,144 @@
+// Package rpc - Governance proposal, vote and parameter queries
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"chaincore/internal/blockchain"
+)
+
+// maxProposalsPerCall bounds the proposals returned by one gov_getProposals
+// call
+const maxProposalsPerCall = 100
+
+// getProposal returns a governance proposal and its tally. Params are [id].
+func (s *Server) getProposal(params json.RawMessage) (interface{}, error) {
+	var args []uint64
+	if err := json.Unmarshal(params, &args); err != nil || len(args) != 1 {
+		return nil, fmt.Errorf("params must be [id]")
+	}
+	p, err := s.chain.GetProposal(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return proposalJSON(p), nil
+}
+
+// getProposals lists proposals in submission order. Params are [offset,
+// count]; at most maxProposalsPerCall are returned, and nextOffset is set
+// while more remain.
+func (s *Server) getProposals(params json.RawMessage) (interface{}, error) {
+	var args []int
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &args); err != nil || len(args) > 2 {
+			return nil, fmt.Errorf("params must be [offset, count]")
+		}
+	}
+	offset, count := 0, maxProposalsPerCall
+	if len(args) > 0 {
+		if offset = args[0]; offset < 0 {
+			return nil, fmt.Errorf("invalid offset")
+		}
+	}
+	if len(args) > 1 {
+		if count = args[1]; count <= 0 {
+			return nil, fmt.Errorf("invalid count")
+		}
+	}
+	if count > maxProposalsPerCall {
+		count = maxProposalsPerCall
+	}
+
+	proposals, total := s.chain.GetProposals(uint64(offset), uint64(count))
+	list := make([]map[string]interface{}, len(proposals))
+	for i, p := range proposals {
+		list[i] = proposalJSON(p)
+	}
+
+	result := map[string]interface{}{
+		"proposals":  list,
+		"total":      total,
+		"nextOffset": nil,
+	}
+	if next := uint64(offset + len(proposals)); next < total {
+		result["nextOffset"] = next
+	}
+	return result, nil
+}
+
+// getVote returns an address's vote on a proposal, null if it has not
+// voted, and the weight its vote would carry now. Params are [id, address].
+func (s *Server) getVote(params json.RawMessage) (interface{}, error) {
+	var args []json.RawMessage
+	if err := json.Unmarshal(params, &args); err != nil || len(args) != 2 {
+		return nil, fmt.Errorf("params must be [id, address]")
+	}
+	var id uint64
+	var address string
+	if err := json.Unmarshal(args[0], &id); err != nil {
+		return nil, fmt.Errorf("invalid proposal id")
+	}
+	if err := json.Unmarshal(args[1], &address); err != nil {
+		return nil, fmt.Errorf("invalid address")
+	}
+	voter, err := s.eth.parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	vote, err := s.chain.GetVote(id, voter)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]interface{}{
+		"choice":        nil,
+		"weight":        nil,
+		"currentWeight": s.chain.GetVotingWeight(voter).String(),
+	}
+	if vote != nil {
+		result["choice"] = vote.Choice.String()
+		result["weight"] = vote.Weight.String()
+	}
+	return result, nil
+}
+
+// getGovernanceParams returns the parameters governance can set, with the
+// value each has been set to, or null while node configuration applies
+func (s *Server) getGovernanceParams() (interface{}, error) {
+	result := make(map[string]interface{})
+	for _, param := range blockchain.GovernanceParams() {
+		result[param.String()] = nil
+		if value, governed := s.chain.GetGovernanceParam(param); governed {
+			result[param.String()] = value.String()
+		}
+	}
+	return result, nil
+}
+
+// Helper functions
+
+func proposalJSON(p *blockchain.Proposal) map[string]interface{} {
+	result := map[string]interface{}{
+		"id":          p.ID,
+		"kind":        p.Kind.String(),
+		"proposer":    blockchain.ChecksumAddress(p.Proposer),
+		"startHeight": p.StartHeight,
+		"endHeight":   p.EndHeight,
+		"bondedStake": p.BondedStake.String(),
+		"yes":         p.Yes.String(),
+		"no":          p.No.String(),
+		"abstain":     p.Abstain.String(),
+		"status":      p.Status.String(),
+	}
+	switch p.Kind {
+	case blockchain.ProposalParamChange:
+		result["param"] = p.Param.String()
+		result["value"] = p.Value.String()
+		result["activationHeight"] = p.Activation
+	case blockchain.ProposalTreasurySpend:
+		result["recipient"] = blockchain.ChecksumAddress(p.Recipient)
+		result["amount"] = p.Value.String()
+	}
+	return result
+}
@@ -0,0 +1,112 @@
+// Package rpc - Idempotency keys for share submissions
+package rpc
+
+import (
+	"container/list"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"chaincore/internal/mining"
+)
+
+// Idempotency limits
+const (
+	idempotencyTTL          = 10 * time.Minute // Longer than any share stays valid
+	maxIdempotencyKeys      = 100000
+	maxIdempotencyKeyLength = 128
+)
+
+// Idempotency errors
+var (
+	ErrIdempotencyKeyReused  = errors.New("idempotency key was already used for a different share")
+	ErrIdempotencyKeyTooLong = errors.New("idempotency key is too long")
+)
+
+// shareResults remembers the outcome of share submissions made with an
+// idempotency key, so a miner that retries after a timeout gets the
+// original result instead of a duplicate-share rejection or a second
+// credit. Keys are scoped to the address that signed the share. A retry
+// arriving while the first attempt is still being processed waits for it.
+// Results are kept in memory for idempotencyTTL on the node that took the
+// submission.
+type shareResults struct {
+	entries map[string]*shareResult
+	order   *list.List // Oldest first; all entries live equally long
+	mu      sync.Mutex
+}
+
+type shareResult struct {
+	key     string
+	digest  [32]byte
+	expires time.Time
+	done    chan struct{} // Closed once value and err are set
+	value   interface{}
+	err     error
+}
+
+func newShareResults() *shareResults {
+	return &shareResults{
+		entries: make(map[string]*shareResult),
+		order:   list.New(),
+	}
+}
+
+// do calls submit for the first submission with a key and returns its
+// result for every retry of the same share with that key
+func (r *shareResults) do(key string, sub *mining.SignedShare, submit func() (interface{}, error)) (interface{}, error) {
+	if len(key) > maxIdempotencyKeyLength {
+		return nil, ErrIdempotencyKeyTooLong
+	}
+	signer, err := sub.Signer()
+	if err != nil {
+		return nil, err
+	}
+	scoped := hex.EncodeToString(signer[:]) + ":" + key
+	digest := sub.Digest()
+
+	r.mu.Lock()
+	now := time.Now()
+	r.expire(now)
+	if entry, ok := r.entries[scoped]; ok {
+		r.mu.Unlock()
+		if entry.digest != digest {
+			return nil, ErrIdempotencyKeyReused
+		}
+		<-entry.done
+		return entry.value, entry.err
+	}
+	for len(r.entries) >= maxIdempotencyKeys {
+		r.remove(r.order.Front())
+	}
+	entry := &shareResult{
+		key:     scoped,
+		digest:  digest,
+		expires: now.Add(idempotencyTTL),
+		done:    make(chan struct{}),
+	}
+	r.entries[scoped] = entry
+	r.order.PushBack(entry)
+	r.mu.Unlock()
+
+	entry.value, entry.err = submit()
+	close(entry.done)
+	return entry.value, entry.err
+}
+
+// Helper functions
+
+// expire drops entries past their lifetime. The caller must hold mu.
+func (r *shareResults) expire(now time.Time) {
+	for elem := r.order.Front(); elem != nil && now.After(elem.Value.(*shareResult).expires); elem = r.order.Front() {
+		r.remove(elem)
+	}
+}
+
+// remove drops an entry. A retry already waiting on it still gets its
+// result. The caller must hold mu.
+func (r *shareResults) remove(elem *list.Element) {
+	entry := r.order.Remove(elem).(*shareResult)
+	delete(r.entries, entry.key)
+}
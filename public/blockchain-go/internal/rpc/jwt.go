@@ -0,0 +1,170 @@
+// Package rpc - JWT authentication with a shared secret
+package rpc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// JWT parameters
+const (
+	jwtSecretBytes  = 32
+	jwtMaxClockSkew = 60 * time.Second // How far a token's iat may be from the server's clock
+	jwtKeyID        = "jwt"            // ID of the APIKey standing for JWT callers
+)
+
+// ErrInvalidJWT is returned for a bearer token that is not a valid JWT
+// signed with the server's secret
+var ErrInvalidJWT = errors.New("invalid JWT")
+
+// jwtClaims are the claims a token is checked for. Namespaces, if present,
+// narrows the methods it may call.
+type jwtClaims struct {
+	IssuedAt   *int64   `json:"iat"`
+	Expires    *int64   `json:"exp"`
+	Subject    string   `json:"sub"`
+	Namespaces []string `json:"namespaces"`
+}
+
+// SetJWTSecret enables authentication with HS256 JWTs signed with a shared
+// secret, as engine API clients use. It must be called before Start.
+func (s *Server) SetJWTSecret(secret []byte) {
+	s.jwtSecret = secret
+}
+
+// LoadJWTSecret reads a hex-encoded 32-byte secret from path, creating the
+// file with a random secret if it does not exist
+func LoadJWTSecret(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		secret := make([]byte, jwtSecretBytes)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(secret)), 0600); err != nil {
+			return nil, fmt.Errorf("failed to write JWT secret: %w", err)
+		}
+		return secret, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWT secret: %w", err)
+	}
+
+	secret, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(string(data)), "0x"))
+	if err != nil || len(secret) != jwtSecretBytes {
+		return nil, fmt.Errorf("JWT secret must be %d hex-encoded bytes", jwtSecretBytes)
+	}
+	return secret, nil
+}
+
+// authenticate returns the API key or JWT caller a request was made by,
+// or nil for an anonymous request
+func (s *Server) authenticate(r *http.Request) (*APIKey, error) {
+	if s.jwtSecret != nil {
+		if token, ok := bearerJWT(r); ok {
+			claims, err := verifyJWT(token, s.jwtSecret, time.Now())
+			if err != nil {
+				return nil, err
+			}
+			return jwtCaller(claims), nil
+		}
+	}
+	if s.apiKeys != nil {
+		return s.apiKeys.Authenticate(r)
+	}
+	return nil, nil
+}
+
+// Helper functions
+
+// bearerJWT returns the request's bearer token if it has the form of a JWT
+func bearerJWT(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	if strings.HasPrefix(token, apiKeyPrefix) || strings.Count(token, ".") != 2 {
+		return "", false
+	}
+	return token, true
+}
+
+// verifyJWT checks a token's signature and time claims
+func verifyJWT(token string, secret []byte, now time.Time) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidJWT
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "HS256" {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidJWT, header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidJWT)
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidJWT)
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if claims.IssuedAt == nil {
+		return nil, fmt.Errorf("%w: missing iat claim", ErrInvalidJWT)
+	}
+	skew := now.Sub(time.Unix(*claims.IssuedAt, 0))
+	if skew > jwtMaxClockSkew || skew < -jwtMaxClockSkew {
+		return nil, fmt.Errorf("%w: iat too far from the server's clock", ErrInvalidJWT)
+	}
+	if claims.Expires != nil && now.Unix() >= *claims.Expires {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidJWT)
+	}
+	return &claims, nil
+}
+
+func decodeJWTSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: malformed segment", ErrInvalidJWT)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: malformed segment", ErrInvalidJWT)
+	}
+	return nil
+}
+
+// jwtCaller represents a JWT caller as an API key limited to the token's
+// namespaces, without rate limits
+func jwtCaller(claims *jwtClaims) *APIKey {
+	name := "jwt"
+	if claims.Subject != "" {
+		name = "jwt:" + claims.Subject
+	}
+	methods := []string{"*"}
+	if len(claims.Namespaces) > 0 {
+		methods = claims.Namespaces
+	}
+	return &APIKey{ID: jwtKeyID, Name: name, Methods: methods}
+}
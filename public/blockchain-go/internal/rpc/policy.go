@@ -0,0 +1,115 @@
+// Package rpc - Access policy separating the lite node surface from operator queries
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrOperatorOnly is returned for a restricted method called without an
+// operator API key
+var ErrOperatorOnly = errors.New("method is restricted to operators")
+
+// ErrAuthRequired is returned for an admin method, or a method in one of
+// AuthNamespaces, called anonymously
+var ErrAuthRequired = errors.New("authentication required")
+
+// AccessTier is the class of clients a method is served to. Operator
+// methods are restricted to API keys whose allowlist grants them.
+type AccessTier int
+
+// Access tiers
+const (
+	AccessPublic   AccessTier = iota // Any client, including lite nodes
+	AccessOperator                   // Operator API keys when restricted
+)
+
+// operatorMethods are the heavy and internal methods restricted to
+// operators, as API key allowlist patterns
+var operatorMethods = []string{
+	"eth_getLogs",           // Scans the receipts of a block range
+	"chain_getMetrics*",     // Scans chain metric buckets
+	"rpc_getRateLimitStats", // Exposes every client's request counts
+}
+
+// adminNamespaces are served only to founder tokens, JWTs and API keys
+// granted them by name, whatever AuthNamespaces lists: they change peers,
+// API keys, settings and bans
+var adminNamespaces = []string{"admin"}
+
+// MethodAccess returns the access tier of a method
+func MethodAccess(method string) AccessTier {
+	if methodAllowed(operatorMethods, method) {
+		return AccessOperator
+	}
+	return AccessPublic
+}
+
+// authorize applies the access policy to a request with the API key and
+// founder token its context carries
+func (s *Server) authorize(ctx context.Context, method string) error {
+	key := apiKeyFromContext(ctx)
+	if methodAllowed(adminNamespaces, method) {
+		return s.authorizeAdmin(ctx, key, method)
+	}
+	if key == nil && len(s.config.AuthNamespaces) > 0 && methodAllowed(s.config.AuthNamespaces, method) {
+		return fmt.Errorf("%w: %s", ErrAuthRequired, method)
+	}
+	if key != nil && !s.allowMethod(key, method) {
+		return fmt.Errorf("%w: %s", ErrMethodNotAllowed, method)
+	}
+	if s.config.RestrictOperatorMethods && MethodAccess(method) == AccessOperator && !isOperator(key) {
+		return fmt.Errorf("%w: %s", ErrOperatorOnly, method)
+	}
+	return nil
+}
+
+// authorizeHTTP applies the access policy to a REST endpoint as if it
+// were the JSON-RPC method
+func (s *Server) authorizeHTTP(method string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := s.authorize(r.Context(), method); err != nil {
+			status := http.StatusForbidden
+			if errors.Is(err, ErrAuthRequired) {
+				status = http.StatusUnauthorized
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Helper functions
+
+// authorizeAdmin lets a founder token, or a JWT or API key whose
+// allowlist grants the method, call an admin method. API keys never reach
+// admin methods through an empty allowlist or "*".
+func (s *Server) authorizeAdmin(ctx context.Context, key *APIKey, method string) error {
+	switch {
+	case isFounderRequest(ctx):
+		return nil
+	case key == nil:
+		return fmt.Errorf("%w: %s", ErrAuthRequired, method)
+	case !s.allowMethod(key, method):
+		return fmt.Errorf("%w: %s", ErrMethodNotAllowed, method)
+	}
+	return nil
+}
+
+// allowMethod reports whether an API key or JWT caller may call a method
+func (s *Server) allowMethod(key *APIKey, method string) bool {
+	if key.ID == jwtKeyID {
+		return methodAllowed(key.Methods, method)
+	}
+	return s.apiKeys.AllowMethod(key, method)
+}
+
+// isOperator reports whether key was issued to an operator, that is with
+// an explicit allowlist. The caller has checked the allowlist grants the
+// method.
+func isOperator(key *APIKey) bool {
+	return key != nil && len(key.Methods) > 0
+}
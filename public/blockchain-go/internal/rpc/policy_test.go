@@ -0,0 +1,42 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestAdminMethodsNeedAuthentication(t *testing.T) {
+	s := &Server{
+		config:  Config{AuthNamespaces: []string{"mining"}},
+		apiKeys: NewAPIKeyManager(nil, APIKeyConfig{}),
+	}
+	anonymous := context.Background()
+	founder := context.WithValue(anonymous, founderContextKey{}, true)
+	jwt := withAPIKey(anonymous, jwtCaller(&jwtClaims{}))
+	tenant := withAPIKey(anonymous, &APIKey{ID: "tenant"})
+	wildcard := withAPIKey(anonymous, &APIKey{ID: "wildcard", Methods: []string{"*"}})
+	operator := withAPIKey(anonymous, &APIKey{ID: "operator", Methods: []string{"admin"}})
+
+	for _, tc := range []struct {
+		ctx    context.Context
+		method string
+		want   error
+	}{
+		{anonymous, "admin_addPeer", ErrAuthRequired},
+		{anonymous, "admin_setSetting", ErrAuthRequired},
+		{founder, "admin_banMiner", nil},
+		{jwt, "admin_createApiKey", nil},
+		{tenant, "admin_createApiKey", ErrMethodNotAllowed},
+		{wildcard, "admin_addPeer", ErrMethodNotAllowed},
+		{operator, "admin_setSetting", nil},
+		{tenant, "eth_blockNumber", nil},
+		{anonymous, "eth_blockNumber", nil},
+		{founder, "mining_submitShare", ErrAuthRequired},
+		{jwt, "mining_submitShare", nil},
+	} {
+		if err := s.authorize(tc.ctx, tc.method); !errors.Is(err, tc.want) || (tc.want == nil && err != nil) {
+			t.Fatalf("%s: %v, want %v", tc.method, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Package rpc - Aggregated pool telemetry for the poolStats WebSocket topic
+package rpc
+
+import (
+	"sync"
+	"time"
+
+	"chaincore/internal/mining"
+)
+
+// Pool feed defaults
+const (
+	defaultPoolStatsInterval  = 30 * time.Second
+	poolStatsSamplesPerWindow = 6
+)
+
+// PoolStatsUpdate is the aggregate published on the poolStats topic once
+// per window. Hashrate is averaged over the samples taken in the window so
+// a dashboard does not jump with every share.
+type PoolStatsUpdate struct {
+	WindowStart      int64  `json:"windowStart"` // Unix seconds
+	WindowEnd        int64  `json:"windowEnd"`
+	AvgHashRate      uint64 `json:"avgHashRate"`
+	PeakHashRate     uint64 `json:"peakHashRate"`
+	ActiveMiners     int    `json:"activeMiners"` // At the end of the window
+	PeakActiveMiners int    `json:"peakActiveMiners"`
+	Difficulty       string `json:"difficulty"`
+	BlocksFound      uint64 `json:"blocksFound"`
+	BlocksInWindow   uint64 `json:"blocksInWindow"`
+}
+
+// PoolStatsFeed samples pool statistics and broadcasts an aggregate to
+// poolStats subscribers every interval. Dashboards subscribe once instead
+// of polling /pool/info, so the cost no longer grows with the audience.
+type PoolStatsFeed struct {
+	pool       *mining.Pool
+	hub        *WebSocketHub
+	interval   time.Duration
+	lastBlocks uint64
+	latest     *PoolStatsUpdate
+	stopCh     chan struct{}
+	mu         sync.RWMutex
+}
+
+// NewPoolStatsFeed creates a feed publishing to hub. A zero interval means
+// 30 seconds.
+func NewPoolStatsFeed(pool *mining.Pool, hub *WebSocketHub, interval time.Duration) *PoolStatsFeed {
+	if interval <= 0 {
+		interval = defaultPoolStatsInterval
+	}
+	return &PoolStatsFeed{
+		pool:     pool,
+		hub:      hub,
+		interval: interval,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start begins sampling and publishing
+func (f *PoolStatsFeed) Start() {
+	f.lastBlocks = f.pool.GetPoolStats().BlocksFound
+	go f.run()
+}
+
+// Stop ends publishing
+func (f *PoolStatsFeed) Stop() {
+	select {
+	case <-f.stopCh:
+	default:
+		close(f.stopCh)
+	}
+}
+
+// Latest returns the last published aggregate, or nil before the first
+// window closes
+func (f *PoolStatsFeed) Latest() *PoolStatsUpdate {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.latest
+}
+
+// Helper functions
+
+func (f *PoolStatsFeed) run() {
+	ticker := time.NewTicker(f.interval / poolStatsSamplesPerWindow)
+	defer ticker.Stop()
+
+	window := &poolStatsWindow{start: time.Now()}
+	for {
+		select {
+		case <-f.stopCh:
+			return
+		case now := <-ticker.C:
+			window.add(f.pool.GetPoolStats())
+			if now.Sub(window.start) < f.interval {
+				continue
+			}
+			update := f.summarize(window, now)
+			f.mu.Lock()
+			f.latest = update
+			f.mu.Unlock()
+			f.hub.BroadcastPoolStats(update)
+			window = &poolStatsWindow{start: now}
+		}
+	}
+}
+
+// summarize turns a window of samples into the published aggregate
+func (f *PoolStatsFeed) summarize(w *poolStatsWindow, end time.Time) *PoolStatsUpdate {
+	update := &PoolStatsUpdate{
+		WindowStart:      w.start.Unix(),
+		WindowEnd:        end.Unix(),
+		PeakHashRate:     w.peakHashRate,
+		ActiveMiners:     w.last.ActiveMiners,
+		PeakActiveMiners: w.peakMiners,
+		BlocksFound:      w.last.BlocksFound,
+	}
+	if w.samples > 0 {
+		update.AvgHashRate = w.totalHashRate / w.samples
+	}
+	if w.last.Difficulty != nil {
+		update.Difficulty = w.last.Difficulty.String()
+	}
+	if w.last.BlocksFound > f.lastBlocks {
+		update.BlocksInWindow = w.last.BlocksFound - f.lastBlocks
+	}
+	f.lastBlocks = w.last.BlocksFound
+	return update
+}
+
+// poolStatsWindow accumulates samples for one publishing window
+type poolStatsWindow struct {
+	start         time.Time
+	samples       uint64
+	totalHashRate uint64
+	peakHashRate  uint64
+	peakMiners    int
+	last          mining.PoolStats
+}
+
+func (w *poolStatsWindow) add(stats mining.PoolStats) {
+	w.samples++
+	w.totalHashRate += stats.TotalHashRate
+	if stats.TotalHashRate > w.peakHashRate {
+		w.peakHashRate = stats.TotalHashRate
+	}
+	if stats.ActiveMiners > w.peakMiners {
+		w.peakMiners = stats.ActiveMiners
+	}
+	w.last = stats
+}
@@ -2,21 +2,46 @@
 package rpc
 
 import (
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"chaincore/internal/blockchain"
 	"chaincore/internal/mining"
 )
 
+// maxWorkerNameLength bounds the worker names accepted at onboarding
+const maxWorkerNameLength = 32
+
 // PoolHandlers holds pool-related RPC handlers
 type PoolHandlers struct {
-	pool *mining.Pool
+	pool           *mining.Pool
+	strictChecksum bool
+	shares         *shareResults // Results of share submissions by idempotency key
+	publicURL      string        // Pool URL given to miners at onboarding
 }
 
 // NewPoolHandlers creates new pool handlers
 func NewPoolHandlers(pool *mining.Pool) *PoolHandlers {
-	return &PoolHandlers{pool: pool}
+	return &PoolHandlers{pool: pool, shares: newShareResults()}
+}
+
+// SetStrictChecksum requires payout addresses to be EIP-55 checksummed
+func (h *PoolHandlers) SetStrictChecksum(strict bool) {
+	h.strictChecksum = strict
+}
+
+// SetPublicURL sets the pool URL written into the miner configs served at
+// onboarding. Without one, the URL the request was made to is used, which
+// is wrong behind a proxy that rewrites the host or terminates TLS.
+func (h *PoolHandlers) SetPublicURL(url string) {
+	h.publicURL = strings.TrimRight(url, "/")
 }
 
 // ConnectRequest represents a pool connect request
@@ -36,20 +61,155 @@ type ConnectResponse struct {
 	Message    string `json:"message,omitempty"`
 }
 
-// SubmitShareRequest represents a share submission
+// SubmitShareRequest represents a share submission. Signature is the
+// miner's secp256k1 signature over (jobId, nonce, hash, height). A retry
+// carrying the same IdempotencyKey as an earlier submission of the same
+// share gets that submission's result instead of being processed again.
 type SubmitShareRequest struct {
-	SessionID string `json:"sessionId"`
-	Nonce     string `json:"nonce"`
-	Hash      string `json:"hash"`
-	JobID     string `json:"jobId"`
+	JobID          string `json:"jobId"`
+	Height         uint64 `json:"height"`
+	Nonce          string `json:"nonce"`
+	Hash           string `json:"hash"`
+	Signature      string `json:"signature"`
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
 }
 
 // SubmitShareResponse represents share result
 type SubmitShareResponse struct {
-	Accepted      bool   `json:"accepted"`
-	Reward        string `json:"reward,omitempty"`
-	NewDifficulty string `json:"newDifficulty,omitempty"`
-	Message       string `json:"message,omitempty"`
+	Accepted      bool                   `json:"accepted"`
+	Reward        string                 `json:"reward,omitempty"`
+	NewDifficulty string                 `json:"newDifficulty,omitempty"`
+	Message       string                 `json:"message,omitempty"`
+	Challenge     map[string]interface{} `json:"challenge,omitempty"` // Challenge to solve before shares count again
+}
+
+// SolveChallengeRequest answers a miner's anti-bot challenge. ID is the
+// challenge's, and Nonce 8 bytes, both hex.
+type SolveChallengeRequest struct {
+	Address string `json:"address"`
+	ID      string `json:"id"`
+	Nonce   string `json:"nonce"`
+}
+
+// OnboardRequest describes a miner's payout address and hardware
+type OnboardRequest struct {
+	Address    string  `json:"address"`
+	Algorithm  string  `json:"algorithm"`  // Default randomx
+	HashRate   float64 `json:"hashRate"`   // Hashes per second
+	WorkerName string  `json:"workerName"` // Generated from the address if empty
+}
+
+// OnboardResponse is a validated payout address with the estimated
+// earnings of the declared hardware and a miner config to start with
+type OnboardResponse struct {
+	Address  string          `json:"address"` // EIP-55 checksummed
+	Estimate OnboardEstimate `json:"estimate"`
+	Config   MinerConfig     `json:"config"`
+}
+
+// OnboardEstimate is the estimated earnings of a miner, in wei
+type OnboardEstimate struct {
+	HashRate       float64 `json:"hashRate"`
+	Difficulty     string  `json:"difficulty"`
+	SharesPerDay   float64 `json:"sharesPerDay"`
+	RewardPerShare string  `json:"rewardPerShare"`
+	Daily          string  `json:"daily"`
+	Weekly         string  `json:"weekly"`
+	Monthly        string  `json:"monthly"`
+	RateLimited    bool    `json:"rateLimited"` // Capped by the pool's minimum time between shares
+}
+
+// MinerConfig is everything a miner needs to connect to the pool
+type MinerConfig struct {
+	PoolURL    string `json:"poolUrl"`
+	Algorithm  string `json:"algorithm"`
+	Address    string `json:"address"`
+	WorkerName string `json:"workerName"`
+}
+
+// OnboardError is an onboarding request rejected for one of its fields
+type OnboardError struct {
+	Error string `json:"error"`
+	Field string `json:"field"`
+}
+
+// HandleOnboard validates a prospective miner's payout address, estimates
+// the earnings of their hardware and returns a miner config, so a web UI
+// can set a miner up in one step. Nothing is registered; the miner still
+// connects with the config.
+func (h *PoolHandlers) HandleOnboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req OnboardRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	addr, err := blockchain.ParseAddress(req.Address, h.strictChecksum)
+	if err != nil {
+		sendOnboardError(w, "address", err.Error())
+		return
+	}
+	if addr == ([20]byte{}) {
+		sendOnboardError(w, "address", "payouts to the zero address would be lost")
+		return
+	}
+
+	if req.Algorithm == "" {
+		req.Algorithm = mining.AlgorithmRandomX
+	}
+	if !mining.ValidAlgorithm(req.Algorithm) {
+		sendOnboardError(w, "algorithm", fmt.Sprintf("algorithm must be %s or %s", mining.AlgorithmRandomX, mining.AlgorithmKHeavyHash))
+		return
+	}
+
+	if req.WorkerName == "" {
+		req.WorkerName = "worker-" + hex.EncodeToString(addr[:4])
+	}
+	if !validWorkerName(req.WorkerName) {
+		sendOnboardError(w, "workerName", fmt.Sprintf("worker name must be 1 to %d letters, digits, '.', '_' or '-'", maxWorkerNameLength))
+		return
+	}
+
+	estimate, err := h.pool.EstimateEarnings(req.Algorithm, req.HashRate)
+	if err != nil {
+		sendOnboardError(w, "hashRate", err.Error())
+		return
+	}
+
+	poolURL := h.publicURL
+	if poolURL == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		poolURL = scheme + "://" + r.Host
+	}
+
+	address := blockchain.ChecksumAddress(addr)
+	json.NewEncoder(w).Encode(OnboardResponse{
+		Address: address,
+		Estimate: OnboardEstimate{
+			HashRate:       estimate.HashRate,
+			Difficulty:     estimate.Difficulty.String(),
+			SharesPerDay:   estimate.SharesPerDay,
+			RewardPerShare: estimate.RewardPerShare.String(),
+			Daily:          estimate.Daily.String(),
+			Weekly:         estimate.Weekly.String(),
+			Monthly:        estimate.Monthly.String(),
+			RateLimited:    estimate.RateLimited,
+		},
+		Config: MinerConfig{
+			PoolURL:    poolURL,
+			Algorithm:  req.Algorithm,
+			Address:    address,
+			WorkerName: req.WorkerName,
+		},
+	})
 }
 
 // HandleConnect handles miner connection
@@ -66,17 +226,14 @@ func (h *PoolHandlers) HandleConnect(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Parse address
-	addrBytes, err := hex.DecodeString(req.Address)
-	if err != nil || len(addrBytes) != 20 {
-		sendJSONError(w, "Invalid address", http.StatusBadRequest)
+	addr, err := blockchain.ParseAddress(req.Address, h.strictChecksum)
+	if err != nil {
+		sendJSONError(w, "Invalid address: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	var addr [20]byte
-	copy(addr[:], addrBytes)
-
 	// Connect to pool
-	miner, err := h.pool.Connect(addr, req.Algorithm, req.WorkerName, r.RemoteAddr)
+	miner, err := h.pool.Connect(addr, req.Algorithm, req.WorkerName, r.RemoteAddr, r.UserAgent())
 	if err != nil {
 		json.NewEncoder(w).Encode(ConnectResponse{
 			Success: false,
@@ -125,15 +282,14 @@ func (h *PoolHandlers) HandleDisconnect(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(map[string]bool{"success": true})
 }
 
-// HandleGetWork handles work requests
+// HandleGetWork handles work requests. The algorithm query parameter picks
+// the job format, RandomX by default.
 func (h *PoolHandlers) HandleGetWork(w http.ResponseWriter, r *http.Request) {
-	sessionID, err := parseSessionID(r)
-	if err != nil {
-		sendJSONError(w, "Invalid session", http.StatusBadRequest)
-		return
+	algorithm := r.URL.Query().Get("algorithm")
+	if algorithm == "" {
+		algorithm = mining.AlgorithmRandomX
 	}
-
-	work, err := h.pool.GetWork(sessionID)
+	work, err := h.pool.GetWork(algorithm)
 	if err != nil {
 		sendJSONError(w, err.Error(), http.StatusBadRequest)
 		return
@@ -155,58 +311,54 @@ func (h *PoolHandlers) HandleSubmitShare(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Parse session ID
-	sessionBytes, err := hex.DecodeString(req.SessionID)
-	if err != nil || len(sessionBytes) != 32 {
+	sub, err := req.signedShare()
+	if err != nil {
 		json.NewEncoder(w).Encode(SubmitShareResponse{
 			Accepted: false,
-			Message:  "Invalid session ID",
+			Message:  err.Error(),
 		})
 		return
 	}
-	var sessionID [32]byte
-	copy(sessionID[:], sessionBytes)
 
-	// Parse nonce
-	nonceBytes, err := hex.DecodeString(req.Nonce)
-	if err != nil {
-		json.NewEncoder(w).Encode(SubmitShareResponse{
-			Accepted: false,
-			Message:  "Invalid nonce",
-		})
-		return
+	// Submit to pool, once per idempotency key
+	submit := func() (interface{}, error) {
+		accepted, reward, err := h.pool.SubmitShare(sub)
+		response := SubmitShareResponse{
+			Accepted: accepted,
+		}
+		if err != nil {
+			response.Message = err.Error()
+		}
+		if errors.Is(err, mining.ErrChallengeRequired) || errors.Is(err, mining.ErrDuplicateShare) {
+			if addr, signerErr := sub.Signer(); signerErr == nil {
+				if challenge, _ := h.pool.GetChallenge(addr); challenge != nil {
+					response.Challenge = challenge.Work()
+				}
+			}
+		}
+		if reward != nil {
+			response.Reward = reward.String()
+		}
+		return response, nil
 	}
-	var nonce uint64
-	for _, b := range nonceBytes {
-		nonce = (nonce << 8) | uint64(b)
+	key := req.IdempotencyKey
+	if key == "" {
+		key = r.Header.Get("Idempotency-Key")
 	}
-
-	// Parse hash
-	hashBytes, err := hex.DecodeString(req.Hash)
-	if err != nil || len(hashBytes) != 32 {
+	var result interface{}
+	if key == "" {
+		result, err = submit()
+	} else {
+		result, err = h.shares.do(key, sub, submit)
+	}
+	if err != nil {
 		json.NewEncoder(w).Encode(SubmitShareResponse{
 			Accepted: false,
-			Message:  "Invalid hash",
+			Message:  err.Error(),
 		})
 		return
 	}
-	var hash [32]byte
-	copy(hash[:], hashBytes)
-
-	// Submit to pool
-	accepted, reward, err := h.pool.SubmitShare(sessionID, nonce, hash, req.JobID)
-	
-	response := SubmitShareResponse{
-		Accepted: accepted,
-	}
-
-	if err != nil {
-		response.Message = err.Error()
-	}
-
-	if reward != nil {
-		response.Reward = reward.String()
-	}
+	response := result.(SubmitShareResponse)
 
 	// Get updated difficulty
 	stats := h.pool.GetPoolStats()
@@ -215,6 +367,84 @@ func (h *PoolHandlers) HandleSubmitShare(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(response)
 }
 
+// HandleChallenge serves /pool/challenge: on GET, the challenge the miner
+// given by the address query parameter must solve before its shares count
+// again, null if none; on POST, a SolveChallengeRequest, answered with the
+// next challenge if the miner's score is still too low
+func (h *PoolHandlers) HandleChallenge(w http.ResponseWriter, r *http.Request) {
+	var challenge *mining.Challenge
+	switch r.Method {
+	case "GET":
+		addr, err := blockchain.ParseAddress(r.URL.Query().Get("address"), h.strictChecksum)
+		if err != nil {
+			sendJSONError(w, "Invalid address: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if challenge, err = h.pool.GetChallenge(addr); err != nil {
+			sendJSONError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+	case "POST":
+		var req SolveChallengeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			sendJSONError(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		addr, err := blockchain.ParseAddress(req.Address, h.strictChecksum)
+		if err != nil {
+			sendJSONError(w, "Invalid address: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		idBytes, err := hex.DecodeString(strings.TrimPrefix(req.ID, "0x"))
+		if err != nil || len(idBytes) != 32 {
+			sendJSONError(w, "Invalid challenge ID", http.StatusBadRequest)
+			return
+		}
+		nonceBytes, err := hex.DecodeString(strings.TrimPrefix(req.Nonce, "0x"))
+		if err != nil || len(nonceBytes) != 8 {
+			sendJSONError(w, "Invalid nonce", http.StatusBadRequest)
+			return
+		}
+		var id [32]byte
+		copy(id[:], idBytes)
+		if challenge, err = h.pool.SolveChallenge(addr, id, binary.BigEndian.Uint64(nonceBytes)); err != nil {
+			sendJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var work map[string]interface{}
+	if challenge != nil {
+		work = challenge.Work()
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"challenge": work})
+}
+
+// HandleClusters serves /pool/clusters: the IPs, subnets and user agent
+// fingerprints mining for more addresses than their limit, largest first
+func (h *PoolHandlers) HandleClusters(w http.ResponseWriter, r *http.Request) {
+	clusters := h.pool.GetClusters()
+	list := make([]map[string]interface{}, len(clusters))
+	for i, cluster := range clusters {
+		addresses := make([]string, len(cluster.Addresses))
+		for j, addr := range cluster.Addresses {
+			addresses[j] = blockchain.ChecksumAddress(addr)
+		}
+		list[i] = map[string]interface{}{
+			"kind":      cluster.Kind,
+			"origin":    cluster.Origin,
+			"limit":     cluster.Limit,
+			"addresses": addresses,
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"clusters": list})
+}
+
 // HandleGetStats handles stats requests
 func (h *PoolHandlers) HandleGetStats(w http.ResponseWriter, r *http.Request) {
 	sessionID, err := parseSessionID(r)
@@ -232,14 +462,15 @@ func (h *PoolHandlers) HandleGetStats(w http.ResponseWriter, r *http.Request) {
 	}
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"hashRate":       miner.HashRate,
-		"validShares":    miner.ValidShares,
-		"rejectedShares": miner.RejectedShares,
-		"pendingReward":  miner.PendingReward.String(),
-		"totalPaid":      miner.TotalPaid.String(),
-		"humanScore":     miner.HumanScore,
-		"isOnline":       miner.IsOnline,
-		"algorithm":      miner.Algorithm,
+		"hashRate":        miner.HashRate,
+		"validShares":     miner.ValidShares,
+		"rejectedShares":  miner.RejectedShares,
+		"duplicateShares": miner.DuplicateShares,
+		"pendingReward":   miner.PendingReward.String(),
+		"totalPaid":       miner.TotalPaid.String(),
+		"humanScore":      miner.HumanScore,
+		"isOnline":        miner.IsOnline,
+		"algorithm":       miner.Algorithm,
 	})
 }
 
@@ -258,10 +489,192 @@ func (h *PoolHandlers) HandleGetPoolInfo(w http.ResponseWriter, r *http.Request)
 		"luck":           stats.Luck,
 		"totalPaid":      stats.TotalPaid.String(),
 		"pendingRewards": stats.PendingRewards.String(),
+		"treasuryPaid":   stats.TreasuryPaid.String(),
 	})
 }
 
+// HandleGetPayouts exports the payout ledger as JSON or CSV. Query
+// parameters: miner (address), from/to (unix seconds or RFC 3339) and
+// format ("json" or "csv").
+func (h *PoolHandlers) HandleGetPayouts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	var filter mining.PayoutFilter
+
+	if minerStr := query.Get("miner"); minerStr != "" {
+		addr, err := blockchain.ParseAddress(minerStr, h.strictChecksum)
+		if err != nil {
+			sendJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.Miner = &addr
+	}
+
+	var err error
+	if filter.From, err = parseTimeParam(query.Get("from")); err != nil {
+		sendJSONError(w, "invalid from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.To, err = parseTimeParam(query.Get("to")); err != nil {
+		sendJSONError(w, "invalid to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	records := h.pool.GetPayouts(filter)
+
+	switch query.Get("format") {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="payouts.csv"`)
+		mining.WritePayoutsCSV(w, records)
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"count":   len(records),
+			"payouts": records,
+		})
+	default:
+		sendJSONError(w, "format must be json or csv", http.StatusBadRequest)
+	}
+}
+
+// HandleHashRateHistory serves the pool's hash rate, active miners and
+// shares over time. Query parameters: interval ("hour", the default, or
+// "day") and from/to (unix seconds or RFC 3339).
+func (h *PoolHandlers) HandleHashRateHistory(w http.ResponseWriter, r *http.Request) {
+	interval, from, to, ok := parseHistoryQuery(w, r)
+	if !ok {
+		return
+	}
+	points, err := h.pool.GetHistory(interval, from, to)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	series := make([]map[string]interface{}, len(points))
+	for i, point := range points {
+		series[i] = map[string]interface{}{
+			"time":     point.Time.Unix(),
+			"hashRate": point.HashRate,
+			"miners":   point.Miners,
+			"shares":   point.Shares,
+		}
+	}
+	sendHistory(w, interval, series)
+}
+
+// HandleBlockHistory serves the blocks found and their rewards over time,
+// with the query parameters of HandleHashRateHistory
+func (h *PoolHandlers) HandleBlockHistory(w http.ResponseWriter, r *http.Request) {
+	interval, from, to, ok := parseHistoryQuery(w, r)
+	if !ok {
+		return
+	}
+	points, err := h.pool.GetHistory(interval, from, to)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	series := make([]map[string]interface{}, len(points))
+	for i, point := range points {
+		series[i] = map[string]interface{}{
+			"time":       point.Time.Unix(),
+			"blocks":     point.Blocks,
+			"rewardsWei": point.Rewards.String(),
+			"paidWei":    point.Paid.String(),
+		}
+	}
+	sendHistory(w, interval, series)
+}
+
+// HandleMinerHistory serves /pool/miner/{address}/history: a miner's hash
+// rate, shares and payouts over time, with the query parameters of
+// HandleHashRateHistory
+func (h *PoolHandlers) HandleMinerHistory(w http.ResponseWriter, r *http.Request) {
+	addrStr, found := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/pool/miner/"), "/history")
+	if !found || addrStr == "" || strings.Contains(addrStr, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	addr, err := blockchain.ParseAddress(addrStr, h.strictChecksum)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	interval, from, to, ok := parseHistoryQuery(w, r)
+	if !ok {
+		return
+	}
+	points, err := h.pool.GetMinerHistory(addr, interval, from, to)
+	if errors.Is(err, mining.ErrUnknownInterval) {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	series := make([]map[string]interface{}, len(points))
+	for i, point := range points {
+		series[i] = map[string]interface{}{
+			"time":     point.Time.Unix(),
+			"hashRate": point.HashRate,
+			"shares":   point.Shares,
+			"paidWei":  point.Paid.String(),
+		}
+	}
+	sendHistory(w, interval, series)
+}
+
 // Helper functions
+func parseTimeParam(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// signedShare decodes the hex fields of a share submission
+func (req *SubmitShareRequest) signedShare() (*mining.SignedShare, error) {
+	nonceBytes, err := hex.DecodeString(strings.TrimPrefix(req.Nonce, "0x"))
+	if err != nil || len(nonceBytes) > 8 {
+		return nil, errors.New("invalid nonce")
+	}
+	var nonce uint64
+	for _, b := range nonceBytes {
+		nonce = (nonce << 8) | uint64(b)
+	}
+
+	hashBytes, err := hex.DecodeString(strings.TrimPrefix(req.Hash, "0x"))
+	if err != nil || len(hashBytes) != 32 {
+		return nil, errors.New("invalid hash")
+	}
+
+	signature, err := hex.DecodeString(strings.TrimPrefix(req.Signature, "0x"))
+	if err != nil || len(signature) != 65 {
+		return nil, errors.New("invalid signature")
+	}
+
+	sub := &mining.SignedShare{
+		JobID:     req.JobID,
+		Height:    req.Height,
+		Nonce:     nonce,
+		Signature: signature,
+	}
+	copy(sub.Hash[:], hashBytes)
+	return sub, nil
+}
+
 func parseSessionID(r *http.Request) ([32]byte, error) {
 	var sessionID [32]byte
 
@@ -289,11 +702,65 @@ func parseSessionID(r *http.Request) ([32]byte, error) {
 	return sessionID, nil
 }
 
+// parseHistoryQuery reads the interval and range of a history request,
+// answering it with an error if they are invalid
+func parseHistoryQuery(w http.ResponseWriter, r *http.Request) (interval string, from, to time.Time, ok bool) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return "", time.Time{}, time.Time{}, false
+	}
+
+	query := r.URL.Query()
+	interval = query.Get("interval")
+	if interval == "" {
+		interval = mining.HistoryHourly
+	}
+	var err error
+	if from, err = parseTimeParam(query.Get("from")); err != nil {
+		sendJSONError(w, "invalid from: "+err.Error(), http.StatusBadRequest)
+		return "", time.Time{}, time.Time{}, false
+	}
+	if to, err = parseTimeParam(query.Get("to")); err != nil {
+		sendJSONError(w, "invalid to: "+err.Error(), http.StatusBadRequest)
+		return "", time.Time{}, time.Time{}, false
+	}
+	return interval, from, to, true
+}
+
+func sendHistory(w http.ResponseWriter, interval string, series []map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"interval": interval,
+		"points":   series,
+	})
+}
+
 func sendJSONError(w http.ResponseWriter, message string, status int) {
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(map[string]string{"error": message})
 }
 
+func sendOnboardError(w http.ResponseWriter, field, message string) {
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(OnboardError{Error: message, Field: field})
+}
+
+// validWorkerName reports whether a worker name is short and made only of
+// letters, digits, '.', '_' and '-'
+func validWorkerName(name string) bool {
+	if name == "" || len(name) > maxWorkerNameLength {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 // RegisterPoolRoutes registers pool RPC routes
 func RegisterPoolRoutes(mux *http.ServeMux, handlers *PoolHandlers) {
 	// Mining pool endpoints
@@ -301,8 +768,15 @@ func RegisterPoolRoutes(mux *http.ServeMux, handlers *PoolHandlers) {
 	mux.HandleFunc("/pool/disconnect", handlers.HandleDisconnect)
 	mux.HandleFunc("/pool/getwork", handlers.HandleGetWork)
 	mux.HandleFunc("/pool/submit", handlers.HandleSubmitShare)
+	mux.HandleFunc("/pool/challenge", handlers.HandleChallenge)
+	mux.HandleFunc("/pool/clusters", handlers.HandleClusters)
 	mux.HandleFunc("/pool/stats", handlers.HandleGetStats)
 	mux.HandleFunc("/pool/info", handlers.HandleGetPoolInfo)
+	mux.HandleFunc("/pool/payouts", handlers.HandleGetPayouts)
+	mux.HandleFunc("/pool/onboard", handlers.HandleOnboard)
+	mux.HandleFunc("/pool/history/hashrate", handlers.HandleHashRateHistory)
+	mux.HandleFunc("/pool/history/blocks", handlers.HandleBlockHistory)
+	mux.HandleFunc("/pool/miner/", handlers.HandleMinerHistory)
 
 	// JSON-RPC compatible endpoints
 	mux.HandleFunc("/mining/connect", handlers.HandleConnect)
@@ -0,0 +1,179 @@
+// Package rpc - Per-client request rate limiting
+package rpc
+
+import (
+	"container/list"
+	"net"
+	"sync"
+	"time"
+)
+
+// Rate limiter defaults, used when the config leaves them zero
+const (
+	defaultRateLimitMaxClients = 100000
+	rateLimitCleanupInterval   = time.Minute
+	rateLimitWindow            = time.Second
+)
+
+// RateLimiter allows each client a fixed number of requests per second.
+// Clients are tracked in LRU order; entries whose window has expired are
+// dropped periodically, and the least recently seen client is evicted when
+// the table is full.
+type RateLimiter struct {
+	limit      int
+	maxClients int
+	clients    map[string]*list.Element
+	lru        *list.List // Front is most recently seen
+	evicted    uint64
+	expired    uint64
+	rejected   uint64
+	stopCh     chan struct{}
+	mu         sync.Mutex
+}
+
+// RateLimiterStats reports the size and churn of the client table
+type RateLimiterStats struct {
+	TrackedClients int    `json:"trackedClients"`
+	MaxClients     int    `json:"maxClients"`
+	Evicted        uint64 `json:"evicted"`  // Dropped to stay under MaxClients
+	Expired        uint64 `json:"expired"`  // Dropped by cleanup
+	Rejected       uint64 `json:"rejected"` // Requests over the limit
+}
+
+type rateLimitEntry struct {
+	client    string
+	count     int
+	resetTime time.Time
+}
+
+// NewRateLimiter creates a rate limiter. maxClients bounds the number of
+// tracked clients; zero uses the default.
+func NewRateLimiter(limit, maxClients int) *RateLimiter {
+	if maxClients <= 0 {
+		maxClients = defaultRateLimitMaxClients
+	}
+	return &RateLimiter{
+		limit:      limit,
+		maxClients: maxClients,
+		clients:    make(map[string]*list.Element),
+		lru:        list.New(),
+		stopCh:     make(chan struct{}),
+	}
+}
+
+// Start begins periodic cleanup of expired entries
+func (rl *RateLimiter) Start() {
+	go rl.cleanupLoop()
+}
+
+// Stop stops the cleanup loop
+func (rl *RateLimiter) Stop() {
+	close(rl.stopCh)
+}
+
+// Allow records a request from the client and reports whether it is within
+// the limit
+func (rl *RateLimiter) Allow(clientIP string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	if elem, exists := rl.clients[clientIP]; exists {
+		rl.lru.MoveToFront(elem)
+		entry := elem.Value.(*rateLimitEntry)
+		if now.After(entry.resetTime) {
+			entry.count = 1
+			entry.resetTime = now.Add(rateLimitWindow)
+			return true
+		}
+		if entry.count >= rl.limit {
+			rl.rejected++
+			return false
+		}
+		entry.count++
+		return true
+	}
+
+	for len(rl.clients) >= rl.maxClients {
+		rl.removeElement(rl.lru.Back())
+		rl.evicted++
+	}
+	rl.clients[clientIP] = rl.lru.PushFront(&rateLimitEntry{
+		client:    clientIP,
+		count:     1,
+		resetTime: now.Add(rateLimitWindow),
+	})
+	return true
+}
+
+// Cleanup drops clients whose window has expired and returns how many were
+// removed. An expired entry behaves exactly like an absent one.
+func (rl *RateLimiter) Cleanup() int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	removed := 0
+	for elem := rl.lru.Back(); elem != nil; {
+		prev := elem.Prev()
+		if now.After(elem.Value.(*rateLimitEntry).resetTime) {
+			rl.removeElement(elem)
+			removed++
+		}
+		elem = prev
+	}
+	rl.expired += uint64(removed)
+	return removed
+}
+
+// SetLimit changes the number of requests each client may make per second.
+// Clients already over the new limit are refused from their next request.
+func (rl *RateLimiter) SetLimit(limit int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.limit = limit
+}
+
+// Stats returns the current client table statistics
+func (rl *RateLimiter) Stats() RateLimiterStats {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	return RateLimiterStats{
+		TrackedClients: len(rl.clients),
+		MaxClients:     rl.maxClients,
+		Evicted:        rl.evicted,
+		Expired:        rl.expired,
+		Rejected:       rl.rejected,
+	}
+}
+
+// Helper functions
+func (rl *RateLimiter) cleanupLoop() {
+	ticker := time.NewTicker(rateLimitCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rl.stopCh:
+			return
+		case <-ticker.C:
+			rl.Cleanup()
+		}
+	}
+}
+
+func (rl *RateLimiter) removeElement(elem *list.Element) {
+	entry := rl.lru.Remove(elem).(*rateLimitEntry)
+	delete(rl.clients, entry.client)
+}
+
+// clientHost strips the port from a remote address so every connection
+// from one host shares a rate limit entry
+func clientHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
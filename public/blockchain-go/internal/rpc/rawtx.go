@@ -0,0 +1,209 @@
+// Package rpc - Decoding of signed Ethereum transactions
+package rpc
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/sha3"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/rlp"
+)
+
+// ethBaseFee is the fixed base fee advertised as baseFeePerGas
+var ethBaseFee = blockchain.BaseFee
+
+// decodeRawTransaction decodes a signed legacy (EIP-155) or EIP-1559
+// transaction and recovers its sender. The signed fields are all kept on
+// the transaction, so any node can check the signature again. The chain ID
+// the signature commits to is recorded on the transaction; the chain checks
+// it on admission.
+func decodeRawTransaction(data []byte) (*blockchain.Transaction, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty transaction")
+	}
+
+	var tx *blockchain.Transaction
+	var err error
+	switch {
+	case data[0] >= 0xc0:
+		tx, err = decodeLegacyTx(data)
+	case data[0] == blockchain.DynamicFeeTxType:
+		tx, err = decodeDynamicFeeTx(data)
+	default:
+		return nil, fmt.Errorf("unsupported transaction type 0x%02x", data[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	copy(tx.Hash[:], keccak256(data))
+	return tx, nil
+}
+
+// decodeLegacyTx decodes rlp([nonce, gasPrice, gas, to, value, data, v, r, s])
+func decodeLegacyTx(data []byte) (*blockchain.Transaction, error) {
+	fields, err := rlp.DecodeList(data, 9)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := fields[6].Uint64()
+	if err != nil {
+		return nil, fmt.Errorf("invalid v: %w", err)
+	}
+	// Unprotected (pre-EIP-155) signatures could be replayed from other chains
+	if v == 27 || v == 28 {
+		return nil, fmt.Errorf("%w: transaction is not replay-protected (EIP-155)", blockchain.ErrInvalidChainID)
+	}
+	if v < 35 {
+		return nil, errors.New("invalid v")
+	}
+	chainID, recID := (v-35)/2, byte((v-35)%2)
+
+	gasPrice, err := fields[1].Uint64()
+	if err != nil {
+		return nil, fmt.Errorf("invalid gas price: %w", err)
+	}
+
+	tx := &blockchain.Transaction{Version: blockchain.LegacyTxType, GasPrice: gasPrice, ChainID: chainID}
+	if err := fillTxFields(tx, fields[0], fields[2], fields[3], fields[4], fields[5]); err != nil {
+		return nil, err
+	}
+	if err := recoverSender(tx, fields[7], fields[8], recID); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// decodeDynamicFeeTx decodes 0x02 || rlp([chainId, nonce, maxPriorityFeePerGas,
+// maxFeePerGas, gas, to, value, data, accessList, yParity, r, s])
+func decodeDynamicFeeTx(data []byte) (*blockchain.Transaction, error) {
+	fields, err := rlp.DecodeList(data[1:], 12)
+	if err != nil {
+		return nil, err
+	}
+
+	chainID, err := fields[0].Uint64()
+	if err != nil {
+		return nil, fmt.Errorf("invalid chain ID: %w", err)
+	}
+	accessList, err := decodeAccessList(fields[8])
+	if err != nil {
+		return nil, err
+	}
+	parity, err := fields[9].Uint64()
+	if err != nil || parity > 1 {
+		return nil, errors.New("invalid signature y-parity")
+	}
+
+	tip, err := fields[2].BigInt()
+	if err != nil {
+		return nil, fmt.Errorf("invalid priority fee: %w", err)
+	}
+	maxFee, err := fields[3].BigInt()
+	if err != nil {
+		return nil, fmt.Errorf("invalid max fee: %w", err)
+	}
+	if tip.Cmp(maxFee) > 0 {
+		return nil, errors.New("max priority fee exceeds max fee")
+	}
+
+	// The base fee is fixed, so the effective price is known up front
+	price := blockchain.DynamicFeeGasPrice(tip, maxFee)
+	if !price.IsUint64() {
+		return nil, errors.New("gas price too large")
+	}
+
+	tx := &blockchain.Transaction{
+		Version:    blockchain.DynamicFeeTxType,
+		GasPrice:   price.Uint64(),
+		ChainID:    chainID,
+		GasTipCap:  tip,
+		GasFeeCap:  maxFee,
+		AccessList: accessList,
+	}
+	if err := fillTxFields(tx, fields[1], fields[4], fields[5], fields[6], fields[7]); err != nil {
+		return nil, err
+	}
+	if err := recoverSender(tx, fields[10], fields[11], byte(parity)); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Helper functions
+func fillTxFields(tx *blockchain.Transaction, nonce, gas, to, value, data rlp.Item) error {
+	var err error
+	if tx.Nonce, err = nonce.Uint64(); err != nil {
+		return fmt.Errorf("invalid nonce: %w", err)
+	}
+	if tx.GasLimit, err = gas.Uint64(); err != nil {
+		return fmt.Errorf("invalid gas limit: %w", err)
+	}
+	// An empty recipient deploys a contract
+	if to.IsList || (len(to.Data) != 0 && len(to.Data) != 20) {
+		return errors.New("invalid recipient address")
+	}
+	tx.Create = len(to.Data) == 0
+	copy(tx.To[:], to.Data)
+	if tx.Value, err = value.BigInt(); err != nil {
+		return fmt.Errorf("invalid value: %w", err)
+	}
+	if data.IsList {
+		return errors.New("invalid data field")
+	}
+	tx.Data = append([]byte(nil), data.Data...)
+	return nil
+}
+
+// decodeAccessList decodes [[address, [storageKey, ...]], ...]
+func decodeAccessList(item rlp.Item) ([]blockchain.AccessTuple, error) {
+	if !item.IsList {
+		return nil, errors.New("invalid access list")
+	}
+	var list []blockchain.AccessTuple
+	for _, entry := range item.List {
+		if !entry.IsList || len(entry.List) != 2 || entry.List[0].IsList || len(entry.List[0].Data) != 20 || !entry.List[1].IsList {
+			return nil, errors.New("invalid access list")
+		}
+		tuple := blockchain.AccessTuple{Address: [20]byte(entry.List[0].Data)}
+		for _, key := range entry.List[1].List {
+			if key.IsList || len(key.Data) != 32 {
+				return nil, errors.New("invalid access list storage key")
+			}
+			tuple.StorageKeys = append(tuple.StorageKeys, [32]byte(key.Data))
+		}
+		list = append(list, tuple)
+	}
+	return list, nil
+}
+
+// recoverSender sets the signature from its RLP fields and the sender it
+// recovers to. The signing hash is rebuilt from the decoded fields, as any
+// node checking the signature later does.
+func recoverSender(tx *blockchain.Transaction, r, s rlp.Item, recID byte) error {
+	if r.IsList || s.IsList || len(r.Data) > 32 || len(s.Data) > 32 {
+		return errors.New("invalid signature")
+	}
+
+	var sig [65]byte
+	copy(sig[32-len(r.Data):32], r.Data)
+	copy(sig[64-len(s.Data):64], s.Data)
+	sig[64] = recID
+
+	tx.Signature = sig
+	from, err := tx.Sender()
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	tx.From = from
+	return nil
+}
+
+func keccak256(data []byte) []byte {
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write(data)
+	return hasher.Sum(nil)
+}
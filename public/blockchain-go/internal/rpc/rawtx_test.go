@@ -0,0 +1,84 @@
+package rpc
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/secp256k1"
+)
+
+func TestDecodeRawTransactionKeepsSignedFields(t *testing.T) {
+	priv, _ := secp256k1.GenerateKey()
+	pub, _ := secp256k1.PublicKey(priv)
+	tip, feeCap := big.NewInt(1), big.NewInt(2000000000)
+	for _, signed := range []*blockchain.Transaction{
+		{Version: blockchain.LegacyTxType, ChainID: 7, Nonce: 3, To: [20]byte{1}, Value: big.NewInt(10), GasLimit: 21000, GasPrice: 5},
+		{
+			Version:    blockchain.DynamicFeeTxType,
+			ChainID:    7,
+			Nonce:      3,
+			To:         [20]byte{1},
+			Value:      big.NewInt(10),
+			GasLimit:   30000,
+			GasPrice:   blockchain.DynamicFeeGasPrice(tip, feeCap).Uint64(),
+			GasTipCap:  tip,
+			GasFeeCap:  feeCap,
+			Data:       []byte{0xca, 0xfe},
+			AccessList: []blockchain.AccessTuple{{Address: [20]byte{2}, StorageKeys: [][32]byte{{3}, {4}}}},
+		},
+	} {
+		hash, _ := signed.SigningHash()
+		sig, err := secp256k1.Sign(hash, priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		copy(signed.Signature[:], sig)
+		raw, err := signed.EncodeRaw()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tx, err := decodeRawTransaction(raw)
+		if err != nil {
+			t.Fatalf("type %d: %v", signed.Version, err)
+		}
+		if tx.From != secp256k1.PubkeyToAddress(pub) {
+			t.Fatalf("type %d: sender %x", signed.Version, tx.From)
+		}
+		if tx.GasPrice != signed.GasPrice || len(tx.AccessList) != len(signed.AccessList) {
+			t.Fatalf("type %d: decoded %+v", signed.Version, tx)
+		}
+
+		// Another node rebuilds the same envelope from the decoded fields
+		again, err := tx.EncodeRaw()
+		if err != nil || !bytes.Equal(again, raw) {
+			t.Fatalf("type %d: re-encoded %x, want %x", signed.Version, again, raw)
+		}
+		if sender, err := tx.Sender(); err != nil || sender != tx.From {
+			t.Fatalf("type %d: recovered %x: %v", signed.Version, sender, err)
+		}
+	}
+}
+
+func TestDecodeRawTransactionRecipient(t *testing.T) {
+	priv, _ := secp256k1.GenerateKey()
+	for _, create := range []bool{false, true} {
+		// A transfer to the zero address encodes it in full; only an empty
+		// recipient deploys a contract
+		signed := &blockchain.Transaction{ChainID: 7, Create: create, Value: big.NewInt(1), GasLimit: 60000, GasPrice: 5, Data: []byte{0x00}}
+		hash, _ := signed.SigningHash()
+		sig, _ := secp256k1.Sign(hash, priv)
+		copy(signed.Signature[:], sig)
+		raw, _ := signed.EncodeRaw()
+
+		tx, err := decodeRawTransaction(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tx.Create != create || tx.IsContractCreation() != create || tx.To != ([20]byte{}) {
+			t.Fatalf("create %v: decoded create %v to %x", create, tx.Create, tx.To)
+		}
+	}
+}
@@ -3,25 +3,52 @@ package rpc
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+
 	"chaincore/internal/blockchain"
+	"chaincore/internal/chainsync"
 	"chaincore/internal/consensus"
+	"chaincore/internal/logging"
 	"chaincore/internal/mining"
+	"chaincore/internal/network"
+	"chaincore/internal/storage"
+	"chaincore/internal/token"
+	"chaincore/internal/tracing"
 )
 
+// logger is the rpc module's logger
+var logger = logging.New("rpc")
+
 // Config holds RPC server configuration
 type Config struct {
-	Port               int
-	MaxConnections     int
-	EnableWebSocket    bool
-	EnableMiningAPI    bool
-	EnableValidatorAPI bool
-	RateLimitPerSecond int
+	Port                    int
+	MaxConnections          int
+	EnableWebSocket         bool
+	EnableMiningAPI         bool
+	EnableValidatorAPI      bool
+	RateLimitPerSecond      int
+	RateLimitClients        int       // Maximum clients tracked by the rate limiter (0 = default)
+	StrictChecksum          bool      // Reject addresses without a valid EIP-55 checksum
+	EnableAdminAPI          bool      // Serve admin_ methods; only enable on trusted networks
+	RestrictOperatorMethods bool      // Serve heavy and internal methods only to operator API keys
+	EnableDevAPI            bool      // Serve evm_ methods that seal blocks and move the clock; dev mode only
+	WSAllowedOrigins        []string  // Browser origins besides the node's own host that may open WebSockets; "*" allows any
+	WSAuthToken             string    // Token WebSocket clients must present for validator and mining channels (empty = open)
+	TLS                     TLSConfig // HTTPS and mutual TLS for the validator API
+	AuthNamespaces          []string  // Namespaces served only to requests with an API key or JWT, e.g. mining, pos; admin always is
 }
 
 // Server implements the RPC server
@@ -30,9 +57,29 @@ type Server struct {
 	chain       *blockchain.Blockchain
 	pos         *consensus.PoSEngine
 	mining      *mining.Distributor
+	pool        *mining.Pool   // Set on nodes running a mining pool
+	poolFeed    *PoolStatsFeed // Publishes the pool's poolStats topic
+	eth         *EthHandlers
 	httpServer  *http.Server
 	clients     map[string]*Client
 	rateLimiter *RateLimiter
+	compactor   *storage.Compactor
+	dbMetrics   *storage.MeteredDatabase
+	reserved    *token.ReservedWalletMonitor
+	apiKeys     *APIKeyManager
+	shares      *shareResults // Results of share submissions by idempotency key
+	settings    *storage.Settings
+	health      func() chainsync.Health
+	p2p         *network.P2PNetwork
+	listener    net.Listener // Replaces listening on Config.Port if set
+	wsHub       *WebSocketHub
+	filters     *FilterManager
+	tlsConfig   *tls.Config         // Nil when serving plaintext HTTP
+	jwtSecret   []byte              // Enables JWT authentication
+	tokens      *token.TokenManager // Token state; set on the founder's node only
+	founderKey  []byte              // Signs founder tokens
+	founder     [20]byte            // Recorded as the author of token operations
+	bridge      *token.Bridge       // Burn-to-mint bridge; founder's node only, if enabled
 	mu          sync.RWMutex
 }
 
@@ -60,24 +107,208 @@ type Response struct {
 	ID      interface{} `json:"id"`
 }
 
+// MarshalJSON encodes the response. A successful response always carries
+// a result, even a null one such as the receipt of a pending transaction;
+// omitempty alone would drop it.
+func (r Response) MarshalJSON() ([]byte, error) {
+	type response Response
+	if r.Error != nil {
+		return json.Marshal(response(r))
+	}
+	return json.Marshal(struct {
+		JSONRPC string      `json:"jsonrpc"`
+		Result  interface{} `json:"result"`
+		ID      interface{} `json:"id"`
+	}{r.JSONRPC, r.Result, r.ID})
+}
+
 // RPCError represents an RPC error
 type RPCError struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// maxHeadersPerCall bounds the headers returned by one chain_getHeaders call
+const maxHeadersPerCall = 192
+
+// maxAddressTransactionsPerCall bounds the transactions returned by one
+// chain_getTransactionsByAddress call
+const maxAddressTransactionsPerCall = 100
+
+// Server error codes. Transaction rejections each have their own code in
+// the implementation-defined -32000 to -32099 range so clients need not
+// parse messages.
+const (
+	ErrCodeServer             = -32000 // Any other error
+	ErrCodeTxKnown            = -32010
+	ErrCodeNonceTooLow        = -32011
+	ErrCodeNonceGap           = -32012
+	ErrCodeConflictingTx      = -32013 // Another transaction uses the same nonce
+	ErrCodeInsufficientFunds  = -32014
+	ErrCodeGasPriceTooLow     = -32015
+	ErrCodeIntrinsicGas       = -32016
+	ErrCodeTxDataTooLarge     = -32017
+	ErrCodeInvalidSignature   = -32018
+	ErrCodeUnvestedFunds      = -32019
+	ErrCodeInvalidStaking     = -32020
+	ErrCodePoolFull           = -32021
+	ErrCodeTooManyFromAddress = -32022
+	ErrCodeInvalidEvidence    = -32023
+	ErrCodeStatePruned        = -32024 // State for the requested block is not kept
+	ErrCodeMethodNotAllowed   = -32025 // The request's API key, or lack of one, may not call the method
+	ErrCodeInvalidChainID     = -32026 // Signed for another chain, or without replay protection
+	ErrCodeAuthRequired       = -32027 // The method's namespace needs an API key or JWT
+	ErrCodeFounderOnly        = -32028 // The method needs a founder token
+	ErrCodeInvalidBatch       = -32029
+	ErrCodeExecutionFailed    = -32030 // A dry-run transaction fails at execution
+	ErrCodeGasAllowance       = -32031 // A dry-run transaction needs more gas than it may use
+	ErrCodeInvalidToken       = -32032
+	ErrCodeInvalidGovernance  = -32033
+	ErrCodeUnverifiedDeposit  = -32034 // The bridge cannot verify the foreign deposit
+	ErrCodeInvalidBridgeMint  = -32035
+	ErrCodeInvalidKeyRotation = -32036
+
+	// A call reverted; the code Ethereum nodes use, so tools look for the
+	// revert data
+	ErrCodeExecutionReverted = 3
+)
+
+// txErrorCodes maps transaction admission, state and access errors to
+// their codes
+var txErrorCodes = []struct {
+	err  error
+	code int
+}{
+	{blockchain.ErrTxKnown, ErrCodeTxKnown},
+	{blockchain.ErrNonceTooLow, ErrCodeNonceTooLow},
+	{blockchain.ErrNonceGap, ErrCodeNonceGap},
+	{blockchain.ErrConflictingTx, ErrCodeConflictingTx},
+	{blockchain.ErrInsufficientBalance, ErrCodeInsufficientFunds},
+	{blockchain.ErrGasPriceTooLow, ErrCodeGasPriceTooLow},
+	{blockchain.ErrIntrinsicGas, ErrCodeIntrinsicGas},
+	{blockchain.ErrTxDataTooLarge, ErrCodeTxDataTooLarge},
+	{blockchain.ErrInvalidSignature, ErrCodeInvalidSignature},
+	{blockchain.ErrInvalidChainID, ErrCodeInvalidChainID},
+	{blockchain.ErrUnvestedFunds, ErrCodeUnvestedFunds},
+	{blockchain.ErrInvalidStaking, ErrCodeInvalidStaking},
+	{blockchain.ErrInvalidBatch, ErrCodeInvalidBatch},
+	{blockchain.ErrInvalidToken, ErrCodeInvalidToken},
+	{blockchain.ErrInvalidGovernance, ErrCodeInvalidGovernance},
+	{blockchain.ErrInvalidBridgeMint, ErrCodeInvalidBridgeMint},
+	{blockchain.ErrInvalidKeyRotation, ErrCodeInvalidKeyRotation},
+	{blockchain.ErrPoolFull, ErrCodePoolFull},
+	{blockchain.ErrTooManyFromAddress, ErrCodeTooManyFromAddress},
+	{blockchain.ErrInvalidEvidence, ErrCodeInvalidEvidence},
+	{blockchain.ErrStatePruned, ErrCodeStatePruned},
+	{blockchain.ErrInsufficientFundsForGas, ErrCodeInsufficientFunds},
+	{blockchain.ErrGasAllowanceExceeded, ErrCodeGasAllowance},
+	{blockchain.ErrExecutionFailed, ErrCodeExecutionFailed},
+	{blockchain.ErrExecutionReverted, ErrCodeExecutionReverted},
+	{ErrMethodNotAllowed, ErrCodeMethodNotAllowed},
+	{ErrOperatorOnly, ErrCodeMethodNotAllowed},
+	{ErrWSTokenRequired, ErrCodeMethodNotAllowed},
+	{ErrAuthRequired, ErrCodeAuthRequired},
+	{ErrFounderOnly, ErrCodeFounderOnly},
+	{token.ErrUnverifiedDeposit, ErrCodeUnverifiedDeposit},
 }
 
 // NewServer creates a new RPC server
 func NewServer(chain *blockchain.Blockchain, pos *consensus.PoSEngine, mining *mining.Distributor, config Config) (*Server, error) {
+	eth := NewEthHandlers(chain, nil)
+	eth.SetStrictChecksum(config.StrictChecksum)
+	if pos != nil {
+		eth.SetFinalitySource(pos.GetFinalizedHeight)
+	}
+	filters := NewFilterManager(chain)
+	eth.SetFilterManager(filters)
+
+	var tlsConfig *tls.Config
+	if config.TLS.Enabled() {
+		var err error
+		if tlsConfig, err = newTLSConfig(config.TLS); err != nil {
+			return nil, err
+		}
+	}
+
 	return &Server{
 		config:      config,
 		chain:       chain,
 		pos:         pos,
 		mining:      mining,
+		eth:         eth,
 		clients:     make(map[string]*Client),
-		rateLimiter: NewRateLimiter(config.RateLimitPerSecond),
+		rateLimiter: NewRateLimiter(config.RateLimitPerSecond, config.RateLimitClients),
+		shares:      newShareResults(),
+		wsHub:       NewWebSocketHub(),
+		filters:     filters,
+		tlsConfig:   tlsConfig,
 	}, nil
 }
 
+// SetCompactor provides the database compactor behind admin_compactDb.
+// It must be called before Start.
+func (s *Server) SetCompactor(c *storage.Compactor) {
+	s.compactor = c
+}
+
+// SetStorageMetrics provides the database metrics behind
+// admin_getStorageStats. It must be called before Start.
+func (s *Server) SetStorageMetrics(m *storage.MeteredDatabase) {
+	s.dbMetrics = m
+}
+
+// SetAPIKeys enables API keys: requests are authenticated and limited by
+// the key they present, and the admin_ key management methods are served.
+// It must be called before Start.
+func (s *Server) SetAPIKeys(m *APIKeyManager) {
+	s.apiKeys = m
+}
+
+// SetSettings provides the runtime settings behind the admin_ settings
+// methods. It must be called before Start.
+func (s *Server) SetSettings(settings *storage.Settings) {
+	s.settings = settings
+}
+
+// SetRateLimit changes the requests per second allowed to each client
+// without an API key. It may be called while the server runs.
+func (s *Server) SetRateLimit(limit int) {
+	s.rateLimiter.SetLimit(limit)
+}
+
+// SetSyncProgress provides the block sync progress behind eth_syncing.
+// It must be called before Start.
+func (s *Server) SetSyncProgress(fn func() chainsync.Progress) {
+	s.eth.SetSyncProgress(fn)
+}
+
+// SetHealth provides the block sync health served on /health. It must be
+// called before Start.
+func (s *Server) SetHealth(fn func() chainsync.Health) {
+	s.health = fn
+}
+
+// SetNetwork provides the P2P network behind the admin_ peer and node
+// info methods. It must be called before Start.
+func (s *Server) SetNetwork(n *network.P2PNetwork) {
+	s.p2p = n
+}
+
+// SetPool provides the mining pool behind the admin_ anti-bot methods and,
+// with WebSocket enabled, the poolStats topic. It must be called before
+// Start.
+func (s *Server) SetPool(pool *mining.Pool) {
+	s.pool = pool
+}
+
+// SetListener makes the server accept connections from listener instead of
+// listening on Config.Port, e.g. to serve over an in-memory transport. It
+// must be called before Start.
+func (s *Server) SetListener(listener net.Listener) {
+	s.listener = listener
+}
+
 // Start starts the RPC server
 func (s *Server) Start() error {
 	mux := http.NewServeMux()
@@ -88,18 +319,35 @@ func (s *Server) Start() error {
 	// WebSocket endpoint
 	if s.config.EnableWebSocket {
 		mux.HandleFunc("/ws", s.handleWebSocket)
+		go s.wsHub.Run()
+		s.chain.OnNewHead(s.publishHead)
+		s.chain.OnPendingTransaction(s.publishPendingTransaction)
+		if s.pool != nil {
+			s.poolFeed = NewPoolStatsFeed(s.pool, s.wsHub, 0)
+			s.poolFeed.Start()
+		}
 	}
 	
 	// Mining API
 	if s.config.EnableMiningAPI {
-		mux.HandleFunc("/mining/submit", s.handleMiningSubmit)
-		mux.HandleFunc("/mining/stats", s.handleMiningStats)
-		mux.HandleFunc("/mining/difficulty", s.handleMiningDifficulty)
+		mux.HandleFunc("/mining/submit", s.authorizeHTTP("mining_submitShare", s.handleMiningSubmit))
+		mux.HandleFunc("/mining/stats", s.authorizeHTTP("mining_getStats", s.handleMiningStats))
+		mux.HandleFunc("/mining/difficulty", s.authorizeHTTP("mining_getDifficulty", s.handleMiningDifficulty))
 	}
 	
 	// Validator API
 	if s.config.EnableValidatorAPI {
-		mux.HandleFunc("/validator/status", s.handleValidatorStatus)
+		mux.HandleFunc("/validator/status", s.requireClientCert(s.authorizeHTTP("pos_getValidatorStatus", s.handleValidatorStatus)))
+	}
+
+	// Transparency report
+	if s.reserved != nil {
+		mux.HandleFunc("/transparency/reserved-wallets", s.handleReservedWallets)
+	}
+
+	// Health check
+	if s.health != nil {
+		mux.HandleFunc("/health", s.handleHealth)
 	}
 
 	s.httpServer = &http.Server{
@@ -107,9 +355,25 @@ func (s *Server) Start() error {
 		Handler:      s.middleware(mux),
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
+		TLSConfig:    s.tlsConfig,
+	}
+	if s.config.TLS.DisableHTTP2 {
+		// A non-nil, empty map keeps net/http from enabling HTTP/2
+		s.httpServer.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
 	}
 
-	go s.httpServer.ListenAndServe()
+	s.rateLimiter.Start()
+	s.filters.Start()
+	switch {
+	case s.tlsConfig != nil && s.listener != nil:
+		go s.httpServer.ServeTLS(s.listener, "", "")
+	case s.tlsConfig != nil:
+		go s.httpServer.ListenAndServeTLS("", "")
+	case s.listener != nil:
+		go s.httpServer.Serve(s.listener)
+	default:
+		go s.httpServer.ListenAndServe()
+	}
 	return nil
 }
 
@@ -118,14 +382,43 @@ func (s *Server) Stop() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	s.httpServer.Shutdown(ctx)
+	if s.poolFeed != nil {
+		s.poolFeed.Stop()
+	}
+	s.wsHub.Stop()
+	s.filters.Stop()
+	s.rateLimiter.Stop()
 }
 
-// middleware applies rate limiting and logging
+// middleware applies API keys, JWTs, founder tokens, rate limiting and
+// logging. Requests with an API key are limited by the key's own quota
+// instead of by client IP; JWT requests are not limited.
 func (s *Server) middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// API key or JWT, except on CORS preflights which never carry one
+		// and on health checks from load balancers and monitoring
+		var key *APIKey
+		var founder bool
+		if r.Method != "OPTIONS" && r.URL.Path != "/health" {
+			var err error
+			if key, err = s.authenticate(r); err != nil {
+				status := http.StatusUnauthorized
+				if errors.Is(err, ErrAPIKeyRateLimited) || errors.Is(err, ErrAPIKeyQuotaExceeded) {
+					status = http.StatusTooManyRequests
+				}
+				http.Error(w, err.Error(), status)
+				return
+			}
+			// A founder token is checked on its own; it grants nothing else
+			if founder, err = s.authenticateFounder(r); err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+		}
+
 		// Rate limiting
-		clientIP := r.RemoteAddr
-		if !s.rateLimiter.Allow(clientIP) {
+		clientIP := clientHost(r.RemoteAddr)
+		if key == nil && !s.rateLimiter.Allow(clientIP) {
 			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
 			return
 		}
@@ -133,12 +426,22 @@ func (s *Server) middleware(next http.Handler) http.Handler {
 		// CORS headers
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-Founder-Token, traceparent, tracestate")
 
 		if r.Method == "OPTIONS" {
 			return
 		}
 
+		// Filters belong to the key, or else to the client's host
+		client := clientIP
+		if key != nil {
+			r = r.WithContext(withAPIKey(r.Context(), key))
+			client = "key:" + key.ID
+		}
+		r = r.WithContext(withClient(r.Context(), client))
+		if founder {
+			r = r.WithContext(context.WithValue(r.Context(), founderContextKey{}, true))
+		}
 		next.ServeHTTP(w, r)
 	})
 }
@@ -156,9 +459,19 @@ func (s *Server) handleRPC(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := s.handleMethod(req.Method, req.Params)
+	ctx, span := tracing.StartServerSpan(r.Context(), propagation.HeaderCarrier(r.Header), req.Method,
+		attribute.String("rpc.system", "jsonrpc"),
+		attribute.String("rpc.method", req.Method),
+		attribute.String("net.peer.addr", r.RemoteAddr),
+	)
+	var result interface{}
+	var err error
+	if err = s.authorize(ctx, req.Method); err == nil {
+		result, err = s.handleMethod(ctx, req.Method, req.Params)
+	}
+	tracing.End(span, err)
 	if err != nil {
-		s.sendError(w, -32000, err.Error(), req.ID)
+		s.sendRPCError(w, rpcError(err), req.ID)
 		return
 	}
 
@@ -166,21 +479,47 @@ func (s *Server) handleRPC(w http.ResponseWriter, r *http.Request) {
 }
 
 // handleMethod dispatches RPC methods
-func (s *Server) handleMethod(method string, params json.RawMessage) (interface{}, error) {
+func (s *Server) handleMethod(ctx context.Context, method string, params json.RawMessage) (interface{}, error) {
 	switch method {
 	// Blockchain methods
 	case "chain_getBlockNumber":
 		return s.getBlockNumber()
 	case "chain_getBlock":
 		return s.getBlock(params)
+	case "chain_getHeaders":
+		return s.getHeaders(params)
 	case "chain_getTransaction":
 		return s.getTransaction(params)
+	case "chain_getTransactionsByAddress":
+		return s.getTransactionsByAddress(params)
 	case "chain_sendTransaction":
-		return s.sendTransaction(params)
+		return s.sendTransaction(ctx, params)
 	case "chain_getBalance":
 		return s.getBalance(params)
 	case "chain_getNonce":
 		return s.getNonce(params)
+	case "chain_getGasPriceAdvisory":
+		return s.getGasPriceAdvisory()
+	case "chain_getTransactionReceipt":
+		return s.getTransactionReceipt(params)
+	case "chain_getTransactionProof":
+		return s.getTransactionProof(params)
+	case "chain_getMiningShareProof":
+		return s.getMiningShareProof(params)
+	case "chain_getMiningRewardProof":
+		return s.getMiningRewardProof(params)
+	case "chain_getBlockReward":
+		return s.getBlockReward(params)
+	case "chain_getSupply":
+		return s.getSupply()
+	case "chain_getMetricsHourly":
+		return s.getMetrics(blockchain.MetricsHourly, 24*time.Hour, params)
+	case "chain_getMetricsDaily":
+		return s.getMetrics(blockchain.MetricsDaily, 30*24*time.Hour, params)
+	case "rpc_getRateLimitStats":
+		return s.rateLimiter.Stats(), nil
+	case "rpc_getApiKeyUsage":
+		return s.getOwnAPIKeyUsage(ctx, params)
 	
 	// PoS methods
 	case "pos_getValidators":
@@ -189,18 +528,133 @@ func (s *Server) handleMethod(method string, params json.RawMessage) (interface{
 		return s.getFinalizedBlock()
 	case "pos_getStake":
 		return s.getStake(params)
+	case "pos_getValidatorSet":
+		return s.getValidatorSet(params)
+	case "pos_getDelegations":
+		return s.getDelegations(params)
+	case "pos_getRewards":
+		return s.getRewards(params)
 	
 	// Mining methods
 	case "mining_getWork":
 		return s.getMiningWork(params)
 	case "mining_submitShare":
 		return s.submitMiningShare(params)
+	case "mining_getBlockTemplate":
+		return s.getBlockTemplate(params)
+	case "mining_submitSoloShare":
+		return s.submitSoloShare(params)
 	case "mining_getStats":
 		return s.getMiningStats(params)
 	case "mining_getDifficulty":
 		return s.getMiningDifficulty()
+	case "mining_getEpochRewards":
+		return s.getEpochRewards(params)
+	case "mining_getAccrual":
+		return s.getMiningAccrual(params)
+
+	// Token methods; those changing supply or price are the founder's
+	case "token_getStats":
+		return s.getTokenStats()
+	case "token_getOperations":
+		return s.getTokenOperations(params)
+	case "token_mint":
+		return s.mintTokens(ctx, params)
+	case "token_burn":
+		return s.burnTokens(ctx, params)
+	case "token_burnUsdtForMint":
+		return s.burnUSDTForMint(ctx, params)
+	case "token_setPrice":
+		return s.setTokenPrice(ctx, params)
+	case "token_getBridgeDeposits":
+		return s.getBridgeDeposits(params)
+	case "token_mapBridgeRecipient":
+		return s.mapBridgeRecipient(ctx, params)
+
+	// Native token registry methods, served by every node
+	case "token_create":
+		return s.createToken(ctx, params)
+	case "token_balanceOf":
+		return s.getTokenBalance(params)
+	case "token_list":
+		return s.listTokens(params)
+
+	// Governance methods; proposals and votes are sent as transactions
+	case "gov_getProposal":
+		return s.getProposal(params)
+	case "gov_getProposals":
+		return s.getProposals(params)
+	case "gov_getVote":
+		return s.getVote(params)
+	case "gov_getParams":
+		return s.getGovernanceParams()
+
+	// Treasury methods; only passed governance proposals spend from it
+	case "treasury_getBalance":
+		return s.getTreasury()
+	case "treasury_getHistory":
+		return s.getTreasuryHistory(params)
+
+	// Dev methods, named as in common Ethereum development nodes so test
+	// tooling works unchanged
+	case "evm_mine":
+		return s.devMine()
+	case "evm_increaseTime":
+		return s.devIncreaseTime(params)
+
+	// Admin methods
+	case "admin_compactDb":
+		return s.compactDB()
+	case "admin_getCompactionStats":
+		return s.getCompactionStats()
+	case "admin_getStorageStats":
+		return s.getStorageStats()
+	case "admin_peers":
+		return s.adminPeers()
+	case "admin_addPeer":
+		return s.adminAddPeer(params)
+	case "admin_removePeer":
+		return s.adminRemovePeer(params)
+	case "admin_nodeInfo":
+		return s.adminNodeInfo()
+	case "admin_versionInfo":
+		return s.adminVersionInfo()
+	case "admin_getLogLevels":
+		return s.adminGetLogLevels()
+	case "admin_txpoolStatus":
+		return s.adminTxPoolStatus()
+	case "admin_txpoolContent":
+		return s.adminTxPoolContent(params)
+	case "admin_createApiKey":
+		return s.createAPIKey(ctx, params)
+	case "admin_revokeApiKey":
+		return s.revokeAPIKey(params)
+	case "admin_listApiKeys":
+		return s.listAPIKeys()
+	case "admin_getApiKeyUsage":
+		return s.getAPIKeyUsage(params)
+	case "admin_listSettings":
+		return s.listSettings()
+	case "admin_setSetting":
+		return s.setSetting(ctx, params)
+	case "admin_resetSetting":
+		return s.resetSetting(ctx, params)
+	case "admin_getSettingHistory":
+		return s.getSettingHistory(params)
+	case "admin_getAntiBotRecord":
+		return s.adminGetAntiBotRecord(params)
+	case "admin_clearAntiBotRecord":
+		return s.adminClearAntiBotRecord(params)
+	case "admin_banMiner":
+		return s.adminBanMiner(params)
+	case "admin_listBannedMiners":
+		return s.adminListBannedMiners()
 	
 	default:
+		// Ethereum-compatible namespaces
+		if strings.HasPrefix(method, "eth_") || strings.HasPrefix(method, "net_") || strings.HasPrefix(method, "web3_") {
+			return s.eth.HandleMethod(ctx, method, params)
+		}
 		return nil, fmt.Errorf("method not found: %s", method)
 	}
 }
@@ -219,26 +673,382 @@ func (s *Server) getBlock(params json.RawMessage) (interface{}, error) {
 	return s.chain.GetBlock(height)
 }
 
+// getHeaders returns consecutive block headers for lite node header sync.
+// Params are [start, count]; at most maxHeadersPerCall are returned, and
+// fewer past the head.
+func (s *Server) getHeaders(params json.RawMessage) (interface{}, error) {
+	var args []uint64
+	if err := json.Unmarshal(params, &args); err != nil || len(args) != 2 {
+		return nil, fmt.Errorf("params must be [start, count]")
+	}
+	start, count := args[0], args[1]
+	if count > maxHeadersPerCall {
+		count = maxHeadersPerCall
+	}
+
+	headers := make([]blockchain.BlockHeader, 0, count)
+	for h := start; h < start+count; h++ {
+		block, err := s.chain.GetBlock(h)
+		if err != nil {
+			break
+		}
+		headers = append(headers, block.Header)
+	}
+	return headers, nil
+}
+
+// getTransaction returns an included or pending transaction by hash, or
+// nil for an unknown one
 func (s *Server) getTransaction(params json.RawMessage) (interface{}, error) {
-	// Implementation
-	return nil, nil
+	var hash string
+	if err := json.Unmarshal(params, &hash); err != nil {
+		return nil, err
+	}
+	txHash, err := parseHash(hash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction hash: %v", err)
+	}
+	return s.eth.transactionByHash(txHash)
+}
+
+// getTransactionsByAddress lists the transactions an address sent or
+// received, newest first. Params are [address, offset, count]; at most
+// maxAddressTransactionsPerCall are returned, and nextOffset is set while
+// older transactions remain.
+func (s *Server) getTransactionsByAddress(params json.RawMessage) (interface{}, error) {
+	var args []json.RawMessage
+	if err := json.Unmarshal(params, &args); err != nil || len(args) == 0 || len(args) > 3 {
+		return nil, fmt.Errorf("params must be [address, offset, count]")
+	}
+	var address string
+	if err := json.Unmarshal(args[0], &address); err != nil {
+		return nil, fmt.Errorf("params must be [address, offset, count]")
+	}
+	addr, err := s.eth.parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	offset, count := 0, maxAddressTransactionsPerCall
+	if len(args) > 1 {
+		if err := json.Unmarshal(args[1], &offset); err != nil || offset < 0 {
+			return nil, fmt.Errorf("invalid offset")
+		}
+	}
+	if len(args) > 2 {
+		if err := json.Unmarshal(args[2], &count); err != nil || count <= 0 {
+			return nil, fmt.Errorf("invalid count")
+		}
+	}
+	if count > maxAddressTransactionsPerCall {
+		count = maxAddressTransactionsPerCall
+	}
+
+	locations, more, err := s.chain.GetTransactionsByAddress(addr, offset, count)
+	if err != nil {
+		return nil, err
+	}
+
+	// A reorganization between the lookup and loading a block can move a
+	// transaction; entries whose block no longer holds it are skipped
+	txs := make([]map[string]interface{}, 0, len(locations))
+	blocks := make(map[uint64]*blockchain.Block)
+	for _, loc := range locations {
+		block, ok := blocks[loc.BlockNumber]
+		if !ok {
+			if block, err = s.chain.GetBlock(loc.BlockNumber); err != nil {
+				continue
+			}
+			blocks[loc.BlockNumber] = block
+		}
+		if loc.Index >= uint64(len(block.Transactions)) {
+			continue
+		}
+		tx := block.Transactions[loc.Index]
+		if tx.Hash == ([32]byte{}) {
+			tx.Hash = tx.ComputeHash()
+		}
+		if tx.Hash != loc.Hash {
+			continue
+		}
+
+		entry := s.eth.formatTransaction(&tx, block, loc.Index)
+		entry["timestamp"] = fmt.Sprintf("0x%x", block.Header.Timestamp)
+		if receipt, err := s.chain.GetReceipt(loc.Hash); err == nil {
+			entry["status"] = fmt.Sprintf("0x%x", receipt.Status)
+		}
+		txs = append(txs, entry)
+	}
+
+	result := map[string]interface{}{
+		"transactions": txs,
+		"nextOffset":   nil,
+	}
+	if more {
+		result["nextOffset"] = offset + len(locations)
+	}
+	return result, nil
 }
 
-func (s *Server) sendTransaction(params json.RawMessage) (interface{}, error) {
+func (s *Server) sendTransaction(ctx context.Context, params json.RawMessage) (interface{}, error) {
 	// Implementation
 	return nil, nil
 }
 
 func (s *Server) getBalance(params json.RawMessage) (interface{}, error) {
-	var addr [20]byte
-	// Parse address from params
+	var address string
+	if err := json.Unmarshal(params, &address); err != nil {
+		return nil, err
+	}
+	addr, err := s.eth.parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
 	balance := s.chain.GetBalance(addr)
 	return balance.String(), nil
 }
 
 func (s *Server) getNonce(params json.RawMessage) (interface{}, error) {
-	// Implementation
-	return nil, nil
+	var address string
+	if err := json.Unmarshal(params, &address); err != nil {
+		return nil, err
+	}
+	addr, err := s.eth.parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	return s.chain.GetNonce(addr), nil
+}
+
+// getGasPriceAdvisory returns the pool's current minimum gas price and the
+// saturation it was derived from
+func (s *Server) getGasPriceAdvisory() (interface{}, error) {
+	advisory := s.chain.GasPriceAdvisory()
+	return map[string]interface{}{
+		"minGasPrice": advisory.MinGasPrice,
+		"saturation":  advisory.Saturation,
+		"pending":     advisory.Pending,
+		"maxSize":     advisory.MaxSize,
+	}, nil
+}
+
+// getTransactionReceipt returns the receipt for a transaction hash, or null
+// while the transaction is pending
+func (s *Server) getTransactionReceipt(params json.RawMessage) (interface{}, error) {
+	var hash string
+	if err := json.Unmarshal(params, &hash); err != nil {
+		return nil, err
+	}
+	txHash, err := parseHash(hash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction hash: %v", err)
+	}
+
+	receipt, err := s.chain.GetReceipt(txHash)
+	if err == blockchain.ErrReceiptNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return formatReceipt(receipt), nil
+}
+
+// getTransactionProof returns a Merkle inclusion proof for a transaction.
+// Params are [height, index].
+func (s *Server) getTransactionProof(params json.RawMessage) (interface{}, error) {
+	var args []uint64
+	if err := json.Unmarshal(params, &args); err != nil || len(args) != 2 {
+		return nil, fmt.Errorf("params must be [height, index]")
+	}
+
+	proof, err := s.chain.GetTransactionProof(args[0], int(args[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"blockNumber": proof.BlockHeight,
+		"blockHash":   fmt.Sprintf("0x%x", proof.BlockHash),
+		"txRoot":      fmt.Sprintf("0x%x", proof.TxRoot),
+		"txHash":      fmt.Sprintf("0x%x", proof.TxHash),
+		"index":       proof.Proof.Index,
+		"proof":       formatMerkleProof(proof.Proof),
+	}, nil
+}
+
+// getMiningShareProof returns a raw mining share and its inclusion proof
+// against the block's MiningRoot. Params are [height, index] or [height,
+// shareHash]; shares older than the retention window are pruned.
+func (s *Server) getMiningShareProof(params json.RawMessage) (interface{}, error) {
+	var args []json.RawMessage
+	var height uint64
+	if err := json.Unmarshal(params, &args); err != nil || len(args) != 2 || json.Unmarshal(args[0], &height) != nil {
+		return nil, fmt.Errorf("params must be [height, index] or [height, shareHash]")
+	}
+
+	var index int
+	var hash string
+	if err := json.Unmarshal(args[1], &hash); err == nil {
+		shareHash, err := parseHash(hash)
+		if err != nil {
+			return nil, err
+		}
+		if index, err = s.chain.FindMiningShare(height, shareHash); err != nil {
+			return nil, err
+		}
+	} else if err := json.Unmarshal(args[1], &index); err != nil {
+		return nil, fmt.Errorf("params must be [height, index] or [height, shareHash]")
+	}
+
+	proof, err := s.chain.GetMiningShareProof(height, index)
+	if err != nil {
+		return nil, err
+	}
+
+	share := &proof.Share
+	leaf := share.Leaf()
+	return map[string]interface{}{
+		"blockNumber": height,
+		"blockHash":   fmt.Sprintf("0x%x", proof.BlockHash),
+		"miningRoot":  fmt.Sprintf("0x%x", proof.MiningRoot),
+		"sharesRoot":  fmt.Sprintf("0x%x", proof.SharesRoot),
+		"shareCount":  proof.ShareCount,
+		"rewardsRoot": fmt.Sprintf("0x%x", proof.RewardsRoot),
+		"miner":       blockchain.ChecksumAddress(share.MinerAddr),
+		"shareHash":   fmt.Sprintf("0x%x", share.ShareHash),
+		"difficulty":  share.Difficulty.String(),
+		"nonce":       share.Nonce,
+		"timestamp":   share.Timestamp,
+		"humanScore":  share.HumanScore,
+		"sessionId":   fmt.Sprintf("0x%x", share.SessionID),
+		"poolId":      fmt.Sprintf("0x%x", share.PoolID),
+		"reward":      share.Reward.String(),
+		"jobHeight":   share.JobHeight,
+		"signature":   fmt.Sprintf("0x%x", share.Signature),
+		"templateRoot": fmt.Sprintf("0x%x", share.TemplateRoot),
+		"leaf":        fmt.Sprintf("0x%x", leaf),
+		"index":       proof.Proof.Index,
+		"proof":       formatMerkleProof(proof.Proof),
+	}, nil
+}
+
+// getMiningRewardProof returns what a block credited a miner and its
+// inclusion proof against the block's MiningRoot. Params are [height,
+// address]; unlike share proofs these remain available after pruning.
+func (s *Server) getMiningRewardProof(params json.RawMessage) (interface{}, error) {
+	var args []json.RawMessage
+	var height uint64
+	var address string
+	if err := json.Unmarshal(params, &args); err != nil || len(args) != 2 ||
+		json.Unmarshal(args[0], &height) != nil || json.Unmarshal(args[1], &address) != nil {
+		return nil, fmt.Errorf("params must be [height, address]")
+	}
+	miner, err := s.eth.parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	proof, err := s.chain.GetMiningRewardProof(height, miner)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"blockNumber": height,
+		"blockHash":   fmt.Sprintf("0x%x", proof.BlockHash),
+		"miningRoot":  fmt.Sprintf("0x%x", proof.MiningRoot),
+		"sharesRoot":  fmt.Sprintf("0x%x", proof.SharesRoot),
+		"shareCount":  proof.ShareCount,
+		"rewardsRoot": fmt.Sprintf("0x%x", proof.RewardsRoot),
+		"miner":       blockchain.ChecksumAddress(proof.Reward.Address),
+		"shares":      proof.Reward.Shares,
+		"reward":      proof.Reward.Reward.String(),
+		"index":       proof.Proof.Index,
+		"proof":       formatMerkleProof(proof.Proof),
+	}, nil
+}
+
+// getBlockReward returns the block reward schedule at a height: the reward
+// its proposer is credited, and when the reward next halves. Params are
+// [height]; the next block if omitted.
+func (s *Server) getBlockReward(params json.RawMessage) (interface{}, error) {
+	var args []uint64
+	if len(params) > 0 && string(params) != "null" {
+		if err := json.Unmarshal(params, &args); err != nil {
+			return nil, fmt.Errorf("params must be [height]")
+		}
+	}
+	height := s.chain.GetCurrentBlock().Header.Height + 1
+	if len(args) > 0 {
+		height = args[0]
+	}
+
+	result := map[string]interface{}{
+		"height":      height,
+		"reward":      s.chain.BlockReward(height).String(),
+		"halvings":    s.chain.Halvings(height),
+		"nextHalving": nil,
+		"nextReward":  nil,
+		"issued":      s.chain.GetBlockRewardsIssued().String(),
+	}
+	if next := s.chain.NextHalving(height); next != 0 {
+		result["nextHalving"] = next
+		result["nextReward"] = s.chain.BlockReward(next).String()
+	}
+	return result, nil
+}
+
+// getSupply returns the token supply at the head: the total, what of it
+// circulates, and what has been burned
+func (s *Server) getSupply() (interface{}, error) {
+	supply := s.chain.GetSupply()
+	return map[string]interface{}{
+		"total":       supply.Total.String(),
+		"circulating": supply.Circulating.String(),
+		"burned":      supply.Burned.String(),
+	}, nil
+}
+
+// getMetrics returns aggregated chain metrics. Params are [from, to] in unix
+// seconds; both are optional and default to the trailing window ending now.
+func (s *Server) getMetrics(resolution string, window time.Duration, params json.RawMessage) (interface{}, error) {
+	var args []int64
+	if len(params) > 0 && string(params) != "null" {
+		if err := json.Unmarshal(params, &args); err != nil {
+			return nil, fmt.Errorf("params must be [from, to] in unix seconds")
+		}
+	}
+
+	to := time.Now()
+	if len(args) > 1 && args[1] > 0 {
+		to = time.Unix(args[1], 0)
+	}
+	from := to.Add(-window)
+	if len(args) > 0 && args[0] > 0 {
+		from = time.Unix(args[0], 0)
+	}
+
+	buckets, err := s.chain.GetMetrics(resolution, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]map[string]interface{}, len(buckets))
+	for i, b := range buckets {
+		result[i] = map[string]interface{}{
+			"start":        b.Start,
+			"blocks":       b.Blocks,
+			"txCount":      b.TxCount,
+			"gasUsed":      b.GasUsed,
+			"fees":         b.Fees.String(),
+			"burned":       b.Burned.String(),
+			"avgBlockTime": b.AvgBlockTime(),
+			"minBlockTime": b.MinInterval,
+			"maxBlockTime": b.MaxInterval,
+			"firstHeight":  b.FirstHeight,
+			"lastHeight":   b.LastHeight,
+		}
+	}
+	return result, nil
 }
 
 // PoS RPC implementations
@@ -257,27 +1067,156 @@ func (s *Server) getStake(params json.RawMessage) (interface{}, error) {
 	return nil, nil
 }
 
+// getDelegations returns the stake an address has delegated to validators
+func (s *Server) getDelegations(params json.RawMessage) (interface{}, error) {
+	var address string
+	if err := json.Unmarshal(params, &address); err != nil {
+		return nil, err
+	}
+	addr, err := s.eth.parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	delegations := s.pos.GetDelegations(addr)
+	result := make([]map[string]interface{}, len(delegations))
+	for i, d := range delegations {
+		result[i] = map[string]interface{}{
+			"validator": blockchain.ChecksumAddress(d.Validator),
+			"amount":    d.Amount.String(),
+		}
+	}
+	return result, nil
+}
+
+// getRewards returns the block rewards accrued to an address as validator
+// commission and as stake bonded to proposers
+func (s *Server) getRewards(params json.RawMessage) (interface{}, error) {
+	var address string
+	if err := json.Unmarshal(params, &address); err != nil {
+		return nil, err
+	}
+	addr, err := s.eth.parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	rewards := s.pos.GetRewards(addr)
+	return map[string]interface{}{
+		"commission": rewards.Commission.String(),
+		"staking":    rewards.Staking.String(),
+		"total":      rewards.Total().String(),
+	}, nil
+}
+
+// getValidatorSet returns the validator set snapshot for an epoch. The epoch
+// may be given as a number, a hex quantity or "latest", optionally wrapped in
+// an array.
+func (s *Server) getValidatorSet(params json.RawMessage) (interface{}, error) {
+	var args []interface{}
+	if err := json.Unmarshal(params, &args); err != nil {
+		var single interface{}
+		if err := json.Unmarshal(params, &single); err != nil {
+			return nil, err
+		}
+		args = []interface{}{single}
+	}
+
+	epoch := s.pos.CurrentEpoch()
+	if len(args) > 0 && args[0] != nil {
+		switch v := args[0].(type) {
+		case float64:
+			epoch = uint64(v)
+		case string:
+			if v != "latest" {
+				n, err := strconv.ParseUint(strings.TrimPrefix(v, "0x"), 16, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid epoch: %s", v)
+				}
+				epoch = n
+			}
+		default:
+			return nil, fmt.Errorf("invalid epoch")
+		}
+	}
+
+	return s.pos.GetValidatorSet(epoch)
+}
+
 // Mining RPC implementations
 func (s *Server) getMiningWork(params json.RawMessage) (interface{}, error) {
 	difficulty := s.mining.GetDifficulty()
+	head := s.chain.GetCurrentBlock()
+	height := head.Header.Height + 1
 	return map[string]interface{}{
-		"difficulty": difficulty.String(),
-		"target":     difficulty.String(),
+		"difficulty":  difficulty.String(),
+		"target":      difficulty.String(),
+		"jobId":       mining.JobID(height, head.Hash()),
+		"blockHeight": height,
 	}, nil
 }
 
+// submitMiningShare accepts a share signed by the miner; see
+// SubmitShareRequest. The reward goes to the signer.
 func (s *Server) submitMiningShare(params json.RawMessage) (interface{}, error) {
-	var share mining.Share
-	if err := json.Unmarshal(params, &share); err != nil {
+	var req SubmitShareRequest
+	if err := json.Unmarshal(params, &req); err != nil {
 		return nil, err
 	}
-	
-	err := s.mining.SubmitShare(&share)
+	sub, err := req.signedShare()
 	if err != nil {
-		return map[string]bool{"accepted": false}, err
+		return nil, err
 	}
-	
-	return map[string]bool{"accepted": true}, nil
+
+	submit := func() (interface{}, error) {
+		if _, err := s.mining.SubmitSignedShare(sub); err != nil {
+			return map[string]bool{"accepted": false}, err
+		}
+		return map[string]bool{"accepted": true}, nil
+	}
+	if req.IdempotencyKey == "" {
+		return submit()
+	}
+	return s.shares.do(req.IdempotencyKey, sub, submit)
+}
+
+// getBlockTemplate returns a solo mining template paying the address in
+// params; see mining.BlockTemplate
+func (s *Server) getBlockTemplate(params json.RawMessage) (interface{}, error) {
+	var address string
+	if err := json.Unmarshal(params, &address); err != nil {
+		return nil, err
+	}
+	addr, err := s.eth.parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	template, err := s.mining.GetBlockTemplate(addr)
+	if err != nil {
+		return nil, err
+	}
+	return template.Work(), nil
+}
+
+// submitSoloShare accepts a nonce found on a solo template. Params are an
+// object with templateId and nonce, 8 bytes in hex.
+func (s *Server) submitSoloShare(params json.RawMessage) (interface{}, error) {
+	var req struct {
+		TemplateID string `json:"templateId"`
+		Nonce      string `json:"nonce"`
+	}
+	if err := json.Unmarshal(params, &req); err != nil {
+		return nil, err
+	}
+	nonce, err := strconv.ParseUint(strings.TrimPrefix(req.Nonce, "0x"), 16, 64)
+	if err != nil {
+		return nil, errors.New("invalid nonce")
+	}
+	reward, err := s.mining.SubmitSoloShare(req.TemplateID, nonce)
+	if err != nil {
+		return map[string]interface{}{"accepted": false}, err
+	}
+	return map[string]interface{}{"accepted": true, "reward": reward.String()}, nil
 }
 
 func (s *Server) getMiningStats(params json.RawMessage) (interface{}, error) {
@@ -292,9 +1231,324 @@ func (s *Server) getMiningDifficulty() (interface{}, error) {
 	return difficulty.String(), nil
 }
 
-// WebSocket handler
-func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	// WebSocket upgrade and handling
+// getEpochRewards returns the on-chain account of a mining reward
+// settlement epoch. Params are [epoch]; the head's epoch if omitted.
+func (s *Server) getEpochRewards(params json.RawMessage) (interface{}, error) {
+	var args []uint64
+	if len(params) > 0 && string(params) != "null" {
+		if err := json.Unmarshal(params, &args); err != nil {
+			return nil, fmt.Errorf("params must be [epoch]")
+		}
+	}
+	epoch := s.chain.SettlementEpoch(s.chain.GetCurrentBlock().Header.Height)
+	if len(args) > 0 {
+		epoch = args[0]
+	}
+
+	rewards := s.chain.GetEpochRewards(epoch)
+	return map[string]interface{}{
+		"epoch":      rewards.Epoch,
+		"firstBlock": rewards.FirstHeight,
+		"lastBlock":  rewards.LastHeight,
+		"miners":     rewards.Miners,
+		"paid":       rewards.Paid,
+		"total":      rewards.Total.String(),
+		"closed":     rewards.Closed,
+		"settled":    rewards.Settled(),
+	}, nil
+}
+
+// getMiningAccrual returns the rewards a miner has earned in a settlement
+// epoch and not yet been paid. Params are [address, epoch]; the head's
+// epoch if omitted.
+func (s *Server) getMiningAccrual(params json.RawMessage) (interface{}, error) {
+	var args []json.RawMessage
+	var address string
+	if err := json.Unmarshal(params, &args); err != nil || len(args) == 0 || json.Unmarshal(args[0], &address) != nil {
+		return nil, fmt.Errorf("params must be [address, epoch]")
+	}
+	epoch := s.chain.SettlementEpoch(s.chain.GetCurrentBlock().Header.Height)
+	if len(args) > 1 {
+		if err := json.Unmarshal(args[1], &epoch); err != nil {
+			return nil, fmt.Errorf("params must be [address, epoch]")
+		}
+	}
+	addr, err := s.eth.parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"address": blockchain.ChecksumAddress(addr),
+		"epoch":   epoch,
+		"accrued": s.chain.GetMiningAccrual(epoch, addr).String(),
+	}, nil
+}
+
+// Admin RPC implementations
+func (s *Server) compactDB() (interface{}, error) {
+	compactor, err := s.adminCompactor()
+	if err != nil {
+		return nil, err
+	}
+	return compactor.Compact()
+}
+
+func (s *Server) getCompactionStats() (interface{}, error) {
+	compactor, err := s.adminCompactor()
+	if err != nil {
+		return nil, err
+	}
+	return compactor.Stats(), nil
+}
+
+func (s *Server) getStorageStats() (interface{}, error) {
+	if !s.config.EnableAdminAPI {
+		return nil, errors.New("admin API is disabled")
+	}
+	if s.dbMetrics == nil {
+		return nil, errors.New("storage metrics are not available")
+	}
+	return s.dbMetrics.Stats(), nil
+}
+
+// devMine seals a block at once and returns its number
+func (s *Server) devMine() (interface{}, error) {
+	if !s.config.EnableDevAPI || s.pos == nil {
+		return nil, errors.New("dev API is disabled")
+	}
+	height, err := s.pos.Mine()
+	if err != nil {
+		return nil, err
+	}
+	return fmt.Sprintf("0x%x", height), nil
+}
+
+// devIncreaseTime moves the consensus clock forward. Params are [seconds];
+// the result is the total seconds the clock runs ahead.
+func (s *Server) devIncreaseTime(params json.RawMessage) (interface{}, error) {
+	if !s.config.EnableDevAPI || s.pos == nil {
+		return nil, errors.New("dev API is disabled")
+	}
+	var args []uint64
+	if err := json.Unmarshal(params, &args); err != nil || len(args) != 1 {
+		return nil, fmt.Errorf("params must be [seconds]")
+	}
+	if args[0] > math.MaxInt64/uint64(time.Second) {
+		return nil, fmt.Errorf("time increase too large")
+	}
+	offset, err := s.pos.IncreaseTime(time.Duration(args[0]) * time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return uint64(offset / time.Second), nil
+}
+
+// createAPIKey creates an API key. Params are an object with name and
+// optionally methods, requestsPerSecond and dailyQuota; the result holds
+// the secret, which is not shown again. Only JWT and founder callers may
+// create keys.
+func (s *Server) createAPIKey(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	keys, err := s.adminAPIKeys()
+	if err != nil {
+		return nil, err
+	}
+	if key := apiKeyFromContext(ctx); !isFounderRequest(ctx) && (key == nil || key.ID != jwtKeyID) {
+		return nil, ErrKeyCreatorRequired
+	}
+	var args struct {
+		Name              string   `json:"name"`
+		Methods           []string `json:"methods"`
+		RequestsPerSecond int      `json:"requestsPerSecond"`
+		DailyQuota        int64    `json:"dailyQuota"`
+	}
+	if err := json.Unmarshal(params, &args); err != nil {
+		return nil, fmt.Errorf("params must be {name, methods, requestsPerSecond, dailyQuota}")
+	}
+	return keys.CreateKey(args.Name, args.Methods, args.RequestsPerSecond, args.DailyQuota)
+}
+
+func (s *Server) revokeAPIKey(params json.RawMessage) (interface{}, error) {
+	keys, err := s.adminAPIKeys()
+	if err != nil {
+		return nil, err
+	}
+	var id string
+	if err := json.Unmarshal(params, &id); err != nil {
+		return nil, fmt.Errorf("params must be the key ID")
+	}
+	if err := keys.RevokeKey(id); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+func (s *Server) listAPIKeys() (interface{}, error) {
+	keys, err := s.adminAPIKeys()
+	if err != nil {
+		return nil, err
+	}
+	return keys.ListKeys()
+}
+
+// getAPIKeyUsage returns a key's daily usage. Params are [id, days]; days
+// defaults to 30.
+func (s *Server) getAPIKeyUsage(params json.RawMessage) (interface{}, error) {
+	keys, err := s.adminAPIKeys()
+	if err != nil {
+		return nil, err
+	}
+	var args []json.RawMessage
+	var id string
+	days := 30
+	if err := json.Unmarshal(params, &args); err != nil || len(args) == 0 || json.Unmarshal(args[0], &id) != nil {
+		return nil, fmt.Errorf("params must be [id, days]")
+	}
+	if len(args) > 1 {
+		if err := json.Unmarshal(args[1], &days); err != nil {
+			return nil, fmt.Errorf("params must be [id, days]")
+		}
+	}
+	return keys.Usage(id, days)
+}
+
+// getOwnAPIKeyUsage returns the daily usage of the key the request was
+// made with. Params are [days]; days defaults to 30.
+func (s *Server) getOwnAPIKeyUsage(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	key := apiKeyFromContext(ctx)
+	if key == nil || key.ID == jwtKeyID {
+		return nil, errors.New("request was not made with an API key")
+	}
+	var args []int
+	if len(params) > 0 && string(params) != "null" {
+		if err := json.Unmarshal(params, &args); err != nil {
+			return nil, fmt.Errorf("params must be [days]")
+		}
+	}
+	days := 30
+	if len(args) > 0 {
+		days = args[0]
+	}
+	return s.apiKeys.Usage(key.ID, days)
+}
+
+func (s *Server) listSettings() (interface{}, error) {
+	settings, err := s.adminSettings()
+	if err != nil {
+		return nil, err
+	}
+	return settings.List(), nil
+}
+
+// setSetting changes a runtime setting. Params are an object with key,
+// value and author; see settingAuthor.
+func (s *Server) setSetting(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	settings, err := s.adminSettings()
+	if err != nil {
+		return nil, err
+	}
+	var args struct {
+		Key    string `json:"key"`
+		Value  string `json:"value"`
+		Author string `json:"author"`
+	}
+	if err := json.Unmarshal(params, &args); err != nil {
+		return nil, fmt.Errorf("params must be {key, value, author}")
+	}
+	return settings.Set(args.Key, args.Value, settingAuthor(ctx, args.Author))
+}
+
+// resetSetting reverts a runtime setting to its default. Params are an
+// object with key and author.
+func (s *Server) resetSetting(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	settings, err := s.adminSettings()
+	if err != nil {
+		return nil, err
+	}
+	var args struct {
+		Key    string `json:"key"`
+		Author string `json:"author"`
+	}
+	if err := json.Unmarshal(params, &args); err != nil {
+		return nil, fmt.Errorf("params must be {key, author}")
+	}
+	return settings.Reset(args.Key, settingAuthor(ctx, args.Author))
+}
+
+// getSettingHistory returns recent setting changes, newest first. Params
+// are [key, limit]; an empty key returns changes to every setting and limit
+// defaults to 100.
+func (s *Server) getSettingHistory(params json.RawMessage) (interface{}, error) {
+	settings, err := s.adminSettings()
+	if err != nil {
+		return nil, err
+	}
+	var args []json.RawMessage
+	var key string
+	limit := 0
+	if len(params) > 0 && string(params) != "null" {
+		if err := json.Unmarshal(params, &args); err != nil {
+			return nil, fmt.Errorf("params must be [key, limit]")
+		}
+	}
+	if len(args) > 0 && json.Unmarshal(args[0], &key) != nil {
+		return nil, fmt.Errorf("params must be [key, limit]")
+	}
+	if len(args) > 1 && json.Unmarshal(args[1], &limit) != nil {
+		return nil, fmt.Errorf("params must be [key, limit]")
+	}
+	return settings.History(key, limit)
+}
+
+// settingAuthor names who made a settings change: the author given in the
+// request, followed by the API key it was made with, if any
+func settingAuthor(ctx context.Context, author string) string {
+	key := apiKeyFromContext(ctx)
+	if key == nil {
+		return author
+	}
+	if key.ID == jwtKeyID {
+		if author == "" {
+			return key.Name
+		}
+		return fmt.Sprintf("%s via %s", author, key.Name)
+	}
+	if author == "" {
+		return fmt.Sprintf("API key %s (%s)", key.ID, key.Name)
+	}
+	return fmt.Sprintf("%s via API key %s (%s)", author, key.ID, key.Name)
+}
+
+// adminSettings returns the runtime settings if the admin API is enabled
+func (s *Server) adminSettings() (*storage.Settings, error) {
+	if !s.config.EnableAdminAPI {
+		return nil, errors.New("admin API is disabled")
+	}
+	if s.settings == nil {
+		return nil, errors.New("runtime settings are not available")
+	}
+	return s.settings, nil
+}
+
+// adminAPIKeys returns the API key manager if the admin API is enabled
+func (s *Server) adminAPIKeys() (*APIKeyManager, error) {
+	if !s.config.EnableAdminAPI {
+		return nil, errors.New("admin API is disabled")
+	}
+	if s.apiKeys == nil {
+		return nil, errors.New("API keys are not enabled")
+	}
+	return s.apiKeys, nil
+}
+
+// adminCompactor returns the compactor if the admin API is enabled
+func (s *Server) adminCompactor() (*storage.Compactor, error) {
+	if !s.config.EnableAdminAPI {
+		return nil, errors.New("admin API is disabled")
+	}
+	if s.compactor == nil {
+		return nil, errors.New("database compaction is not available")
+	}
+	return s.compactor, nil
 }
 
 // Mining API handlers
@@ -318,6 +1572,23 @@ func (s *Server) handleValidatorStatus(w http.ResponseWriter, r *http.Request) {
 	// Handle validator status request
 }
 
+// handleHealth reports the state of block sync, including any stall
+// recovery in progress. A stalled node answers 503 Service Unavailable so
+// load balancers stop sending it traffic until it catches up.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	health := s.health()
+	w.Header().Set("Content-Type", "application/json")
+	if health.Status == chainsync.HealthStalled {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(health)
+}
+
 // Helper methods
 func (s *Server) sendResult(w http.ResponseWriter, result interface{}, id interface{}) {
 	resp := Response{
@@ -329,57 +1600,37 @@ func (s *Server) sendResult(w http.ResponseWriter, result interface{}, id interf
 	json.NewEncoder(w).Encode(resp)
 }
 
-func (s *Server) sendError(w http.ResponseWriter, code int, message string, id interface{}) {
-	resp := Response{
-		JSONRPC: "2.0",
-		Error: &RPCError{
-			Code:    code,
-			Message: message,
-		},
-		ID: id,
+// errorCode returns the JSON-RPC error code for a method error
+func errorCode(err error) int {
+	for _, c := range txErrorCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
-}
-
-// RateLimiter implements rate limiting
-type RateLimiter struct {
-	limit   int
-	clients map[string]*rateLimitEntry
-	mu      sync.Mutex
-}
-
-type rateLimitEntry struct {
-	count     int
-	resetTime time.Time
+	return ErrCodeServer
 }
 
-func NewRateLimiter(limit int) *RateLimiter {
-	return &RateLimiter{
-		limit:   limit,
-		clients: make(map[string]*rateLimitEntry),
+// rpcError converts a method error to its RPC error. A reverted call
+// carries its revert data, as Ethereum nodes return it.
+func rpcError(err error) *RPCError {
+	rpcErr := &RPCError{Code: errorCode(err), Message: err.Error()}
+	var revert *blockchain.RevertError
+	if errors.As(err, &revert) {
+		rpcErr.Data = fmt.Sprintf("0x%x", revert.Data)
 	}
+	return rpcErr
 }
 
-func (rl *RateLimiter) Allow(clientIP string) bool {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	entry, exists := rl.clients[clientIP]
-	now := time.Now()
-
-	if !exists || now.After(entry.resetTime) {
-		rl.clients[clientIP] = &rateLimitEntry{
-			count:     1,
-			resetTime: now.Add(time.Second),
-		}
-		return true
-	}
+func (s *Server) sendError(w http.ResponseWriter, code int, message string, id interface{}) {
+	s.sendRPCError(w, &RPCError{Code: code, Message: message}, id)
+}
 
-	if entry.count >= rl.limit {
-		return false
+func (s *Server) sendRPCError(w http.ResponseWriter, rpcErr *RPCError, id interface{}) {
+	resp := Response{
+		JSONRPC: "2.0",
+		Error:   rpcErr,
+		ID:      id,
 	}
-
-	entry.count++
-	return true
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
 }
@@ -0,0 +1,363 @@
+// Package rpc - Ethereum eth_subscribe notifications over WebSocket
+package rpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"chaincore/internal/blockchain"
+)
+
+// Subscription kinds
+const (
+	subNewHeads               = "newHeads"
+	subLogs                   = "logs"
+	subNewPendingTransactions = "newPendingTransactions"
+)
+
+// Subscription limits
+const (
+	maxSubscriptionsPerClient = 64
+	maxFilterTopics           = 4 // Topic positions a log filter may constrain
+)
+
+// ErrTooManySubscriptions is returned by eth_subscribe once a connection
+// holds maxSubscriptionsPerClient subscriptions
+var ErrTooManySubscriptions = errors.New("too many subscriptions")
+
+// ethSubscription is an eth_subscribe subscription of a client: newHeads,
+// logs or newPendingTransactions. It ends with the connection.
+type ethSubscription struct {
+	kind   string
+	filter *logFilter // Logs subscriptions only
+}
+
+// logFilter selects logs by address and topics. An empty address list
+// matches any address; each topic position matches any of its topics, or
+// anything if it has none.
+type logFilter struct {
+	addresses [][20]byte
+	topics    [][][32]byte
+}
+
+// subscriptionMessage is an eth_subscription notification
+type subscriptionMessage struct {
+	JSONRPC string             `json:"jsonrpc"`
+	Method  string             `json:"method"`
+	Params  subscriptionResult `json:"params"`
+}
+
+type subscriptionResult struct {
+	Subscription string      `json:"subscription"`
+	Result       interface{} `json:"result"`
+}
+
+// handleWSRequest serves a JSON-RPC request received over a WebSocket
+// connection, under the access policy of the key it was opened with
+func (s *Server) handleWSRequest(c *WebSocketClient, req *Request) {
+	r := c.conn.Request()
+	key := apiKeyFromContext(r.Context())
+	if key == nil && !s.rateLimiter.Allow(clientHost(r.RemoteAddr)) {
+		c.sendError(req.ID, ErrCodeServer, "rate limit exceeded")
+		return
+	}
+
+	var result interface{}
+	err := s.authorize(r.Context(), req.Method)
+	if err == nil && !c.authorized && methodAllowed(wsRestrictedChannels, req.Method) {
+		err = ErrWSTokenRequired
+	}
+	if err == nil {
+		switch req.Method {
+		case "eth_subscribe":
+			// The response is sent as the subscription is added, so no
+			// notification overtakes the ID it carries
+			if err = s.subscribe(c, req); err == nil {
+				return
+			}
+		case "eth_unsubscribe":
+			result, err = c.unsubscribe(req.Params)
+		default:
+			result, err = s.handleMethod(r.Context(), req.Method, req.Params)
+		}
+	}
+	if err != nil {
+		c.sendRPCError(req.ID, rpcError(err))
+		return
+	}
+	c.sendResponse(req.ID, result)
+}
+
+// subscribe adds the subscription requested by an eth_subscribe request
+// and responds with its ID
+func (s *Server) subscribe(c *WebSocketClient, req *Request) error {
+	var args []json.RawMessage
+	if err := json.Unmarshal(req.Params, &args); err != nil || len(args) == 0 {
+		return fmt.Errorf("missing subscription kind")
+	}
+	var kind string
+	if err := json.Unmarshal(args[0], &kind); err != nil {
+		return fmt.Errorf("invalid subscription kind: %v", err)
+	}
+
+	sub := &ethSubscription{kind: kind}
+	switch kind {
+	case subNewHeads, subNewPendingTransactions:
+	case subLogs:
+		var filter json.RawMessage
+		if len(args) > 1 {
+			filter = args[1]
+		}
+		var err error
+		if sub.filter, err = s.eth.parseLogFilter(filter); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported subscription: %s", kind)
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		return err
+	}
+	id = "0x" + id
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.ethSubs) >= maxSubscriptionsPerClient {
+		return ErrTooManySubscriptions
+	}
+	c.ethSubs[id] = sub
+	c.sendResponse(req.ID, id)
+	return nil
+}
+
+// unsubscribe removes the subscription named by an eth_unsubscribe request
+// and reports whether it existed
+func (c *WebSocketClient) unsubscribe(params json.RawMessage) (interface{}, error) {
+	var args []string
+	if err := json.Unmarshal(params, &args); err != nil || len(args) == 0 {
+		return nil, fmt.Errorf("missing subscription ID")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.ethSubs[args[0]]
+	delete(c.ethSubs, args[0])
+	return ok, nil
+}
+
+// publishHead notifies newHeads and logs subscribers of a block that
+// became the head. Logs of reverted blocks are not resent as removed.
+func (s *Server) publishHead(block *blockchain.Block) {
+	if s.wsHub.hasSubscriptions(subNewHeads) {
+		header := s.eth.formatHeader(block)
+		s.wsHub.forEachSubscription(subNewHeads, func(c *WebSocketClient, id string, sub *ethSubscription) {
+			c.notify(id, header)
+		})
+	}
+
+	// Receipts are only read back when someone listens for logs
+	if !s.wsHub.hasSubscriptions(subLogs) {
+		return
+	}
+	for i := range block.Transactions {
+		receipt, err := s.chain.GetReceipt(block.Transactions[i].Hash)
+		if err != nil {
+			continue
+		}
+		for _, l := range receipt.Logs {
+			formatted := formatLog(receipt, l)
+			s.wsHub.forEachSubscription(subLogs, func(c *WebSocketClient, id string, sub *ethSubscription) {
+				if sub.filter.matches(l) {
+					c.notify(id, formatted)
+				}
+			})
+		}
+	}
+}
+
+// publishPendingTransaction notifies newPendingTransactions subscribers of
+// a transaction added to the pool
+func (s *Server) publishPendingTransaction(tx *blockchain.Transaction) {
+	hash := fmt.Sprintf("0x%x", tx.Hash)
+	s.wsHub.forEachSubscription(subNewPendingTransactions, func(c *WebSocketClient, id string, sub *ethSubscription) {
+		c.notify(id, hash)
+	})
+}
+
+// forEachSubscription calls fn for every eth_subscribe subscription of
+// kind, holding the subscriber's lock
+func (h *WebSocketHub) forEachSubscription(kind string, fn func(c *WebSocketClient, id string, sub *ethSubscription)) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, client := range h.clients {
+		client.mu.Lock()
+		for id, sub := range client.ethSubs {
+			if sub.kind == kind {
+				fn(client, id, sub)
+			}
+		}
+		client.mu.Unlock()
+	}
+}
+
+// hasSubscriptions reports whether any client subscribed to kind
+func (h *WebSocketHub) hasSubscriptions(kind string) bool {
+	found := false
+	h.forEachSubscription(kind, func(*WebSocketClient, string, *ethSubscription) {
+		found = true
+	})
+	return found
+}
+
+// notify sends an eth_subscription notification
+func (c *WebSocketClient) notify(id string, result interface{}) {
+	c.send(mustMarshal(subscriptionMessage{
+		JSONRPC: "2.0",
+		Method:  "eth_subscription",
+		Params:  subscriptionResult{Subscription: id, Result: result},
+	}))
+}
+
+// formatHeader formats a block as a newHeads header: the block without its
+// body fields
+func (h *EthHandlers) formatHeader(block *blockchain.Block) map[string]interface{} {
+	header := h.formatBlock(block, false)
+	for _, field := range []string{"transactions", "uncles", "size", "totalDifficulty"} {
+		delete(header, field)
+	}
+	return header
+}
+
+// parseLogFilter parses the address and topics of a log filter object. A
+// missing filter matches every log.
+func (h *EthHandlers) parseLogFilter(raw json.RawMessage) (*logFilter, error) {
+	filter := &logFilter{}
+	if len(raw) == 0 || string(raw) == "null" {
+		return filter, nil
+	}
+
+	var args struct {
+		Address json.RawMessage   `json:"address"`
+		Topics  []json.RawMessage `json:"topics"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, fmt.Errorf("invalid log filter: %v", err)
+	}
+
+	addresses, err := stringOrList(args.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter address: %v", err)
+	}
+	for _, a := range addresses {
+		addr, err := h.parseAddress(a)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter address: %v", err)
+		}
+		filter.addresses = append(filter.addresses, addr)
+	}
+
+	if len(args.Topics) > maxFilterTopics {
+		return nil, fmt.Errorf("too many filter topics: %d, at most %d", len(args.Topics), maxFilterTopics)
+	}
+	filter.topics = make([][][32]byte, len(args.Topics))
+	for i, rawTopics := range args.Topics {
+		topics, err := stringOrList(rawTopics)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter topic %d: %v", i, err)
+		}
+		for _, t := range topics {
+			topic, err := parseHash(t)
+			if err != nil {
+				return nil, fmt.Errorf("invalid filter topic %d: %v", i, err)
+			}
+			filter.topics[i] = append(filter.topics[i], topic)
+		}
+	}
+	return filter, nil
+}
+
+// matches reports whether the filter selects a log
+func (f *logFilter) matches(l *blockchain.Log) bool {
+	if len(f.addresses) > 0 && !containsAddress(f.addresses, l.Address) {
+		return false
+	}
+	for i, topics := range f.topics {
+		if len(topics) == 0 {
+			continue
+		}
+		if i >= len(l.Topics) || !containsHash(topics, l.Topics[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// mayMatch reports whether a block with the given logs bloom can hold logs
+// the filter selects
+func (f *logFilter) mayMatch(bloom *blockchain.Bloom) bool {
+	if *bloom == (blockchain.Bloom{}) {
+		return false
+	}
+	if len(f.addresses) > 0 {
+		found := false
+		for _, addr := range f.addresses {
+			found = found || bloom.Test(addr[:])
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, topics := range f.topics {
+		if len(topics) == 0 {
+			continue
+		}
+		found := false
+		for _, topic := range topics {
+			found = found || bloom.Test(topic[:])
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Helper functions
+
+// stringOrList decodes a JSON string, array of strings or null
+func stringOrList(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var one string
+	if err := json.Unmarshal(raw, &one); err == nil {
+		return []string{one}, nil
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func containsAddress(addresses [][20]byte, addr [20]byte) bool {
+	for _, a := range addresses {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func containsHash(hashes [][32]byte, hash [32]byte) bool {
+	for _, h := range hashes {
+		if h == hash {
+			return true
+		}
+	}
+	return false
+}
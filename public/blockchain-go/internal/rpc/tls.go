@@ -0,0 +1,159 @@
+// Package rpc - HTTPS, HTTP/2 and client certificates for public endpoints
+package rpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certCheckInterval is how often certificate files are checked for renewal
+const certCheckInterval = time.Minute
+
+// TLSConfig configures HTTPS for the RPC server. The server serves
+// plaintext HTTP unless certificate files or autocert domains are set.
+type TLSConfig struct {
+	CertFile         string   // PEM certificate chain, with KeyFile
+	KeyFile          string   // PEM private key
+	AutocertDomains  []string // Obtain certificates for these hosts from Let's Encrypt instead of files
+	AutocertCacheDir string   // Where ACME certificates and the account key are kept
+	ClientCAFile     string   // PEM CAs whose client certificates the validator API requires (mutual TLS)
+	DisableHTTP2     bool     // Serve HTTP/1.1 only
+}
+
+// Enabled reports whether the server serves HTTPS
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != "" || len(c.AutocertDomains) > 0
+}
+
+// newTLSConfig builds the server's TLS configuration, from certificate
+// files reloaded when renewed or from Let's Encrypt through autocert
+func newTLSConfig(c TLSConfig) (*tls.Config, error) {
+	var config *tls.Config
+	switch {
+	case len(c.AutocertDomains) > 0:
+		if c.CertFile != "" || c.KeyFile != "" {
+			return nil, errors.New("TLS certificate files and autocert domains are mutually exclusive")
+		}
+		if c.AutocertCacheDir == "" {
+			return nil, errors.New("autocert needs a cache directory")
+		}
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(c.AutocertDomains...),
+			Cache:      autocert.DirCache(c.AutocertCacheDir),
+		}
+		config = manager.TLSConfig()
+
+	case c.CertFile != "" && c.KeyFile != "":
+		reloader := &certReloader{certFile: c.CertFile, keyFile: c.KeyFile}
+		if err := reloader.reload(); err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		config = &tls.Config{GetCertificate: reloader.getCertificate}
+
+	default:
+		return nil, errors.New("TLS needs both a certificate and a key file")
+	}
+	config.MinVersion = tls.VersionTLS12
+
+	// autocert lists its challenge protocol; keep it after HTTP/2 and 1.1
+	protos := []string{"h2", "http/1.1"}
+	if c.DisableHTTP2 {
+		protos = protos[1:]
+	}
+	for _, p := range config.NextProtos {
+		if p != "h2" && p != "http/1.1" {
+			protos = append(protos, p)
+		}
+	}
+	config.NextProtos = protos
+
+	if c.ClientCAFile != "" {
+		pem, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in client CA file")
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// requireClientCert serves next only to clients that presented a verified
+// certificate, when a client CA is configured
+func (s *Server) requireClientCert(next http.HandlerFunc) http.HandlerFunc {
+	if s.config.TLS.ClientCAFile == "" {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			http.Error(w, "Client certificate required", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// certReloader serves a certificate from files, reloading it when they
+// change so renewed certificates are picked up without a restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	modTime  time.Time // Latest modification time of the files loaded
+	checked  time.Time
+	mu       sync.Mutex
+}
+
+// getCertificate returns the current certificate, reloading it first if
+// the files changed. A renewal that fails to load keeps the old one.
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checked) >= certCheckInterval {
+		if err := r.reload(); err != nil {
+			logger.Error("Failed to reload TLS certificate", "err", err)
+		}
+	}
+	return r.cert, nil
+}
+
+// reload loads the certificate if it was never loaded or its files have
+// changed since. Callers must hold r.mu, except before first use.
+func (r *certReloader) reload() error {
+	r.checked = time.Now()
+	var modTime time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	if r.cert != nil && !modTime.After(r.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
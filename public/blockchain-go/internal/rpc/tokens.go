@@ -0,0 +1,121 @@
+// Package rpc - Native token registry methods
+package rpc
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"chaincore/internal/blockchain"
+)
+
+// maxTokensPerCall bounds the tokens returned by one token_list call
+const maxTokensPerCall = 100
+
+// createToken submits a token creation signed by the wallet, as
+// eth_sendRawTransaction does. Params are [raw]; the result has the
+// transaction hash and the token's address.
+func (s *Server) createToken(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var args []string
+	if err := json.Unmarshal(params, &args); err != nil || len(args) != 1 {
+		return nil, fmt.Errorf("params must be [raw transaction]")
+	}
+	data, err := hex.DecodeString(strings.TrimPrefix(args[0], "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction data: %v", err)
+	}
+	tx, err := s.eth.parseTransaction(data)
+	if err != nil {
+		return nil, err
+	}
+
+	op, err := blockchain.DecodeTokenTx(tx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", blockchain.ErrInvalidToken, err)
+	}
+	if op.Type != blockchain.TokenOpCreate {
+		return nil, fmt.Errorf("%w: not a token creation", blockchain.ErrInvalidToken)
+	}
+	if err := s.chain.AddTransaction(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"transactionHash": fmt.Sprintf("0x%x", tx.Hash),
+		"token":           blockchain.ChecksumAddress(op.Token),
+	}, nil
+}
+
+// getTokenBalance returns an account's balance of a token. Params are
+// [token, address].
+func (s *Server) getTokenBalance(params json.RawMessage) (interface{}, error) {
+	var args []string
+	if err := json.Unmarshal(params, &args); err != nil || len(args) != 2 {
+		return nil, fmt.Errorf("params must be [token, address]")
+	}
+	token, err := s.eth.parseAddress(args[0])
+	if err != nil {
+		return nil, err
+	}
+	owner, err := s.eth.parseAddress(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	balance, err := s.chain.GetTokenBalance(token, owner)
+	if err != nil {
+		return nil, err
+	}
+	return balance.String(), nil
+}
+
+// listTokens lists registered tokens in creation order. Params are
+// [offset, count]; at most maxTokensPerCall are returned, and nextOffset
+// is set while more remain.
+func (s *Server) listTokens(params json.RawMessage) (interface{}, error) {
+	var args []int
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &args); err != nil || len(args) > 2 {
+			return nil, fmt.Errorf("params must be [offset, count]")
+		}
+	}
+	offset, count := 0, maxTokensPerCall
+	if len(args) > 0 {
+		if offset = args[0]; offset < 0 {
+			return nil, fmt.Errorf("invalid offset")
+		}
+	}
+	if len(args) > 1 {
+		if count = args[1]; count <= 0 {
+			return nil, fmt.Errorf("invalid count")
+		}
+	}
+	if count > maxTokensPerCall {
+		count = maxTokensPerCall
+	}
+
+	tokens, total := s.chain.GetTokens(uint64(offset), uint64(count))
+	list := make([]map[string]interface{}, len(tokens))
+	for i, t := range tokens {
+		list[i] = map[string]interface{}{
+			"address":     blockchain.ChecksumAddress(t.Address),
+			"creator":     blockchain.ChecksumAddress(t.Creator),
+			"name":        t.Name,
+			"symbol":      t.Symbol,
+			"decimals":    t.Decimals,
+			"totalSupply": t.TotalSupply.String(),
+		}
+	}
+
+	result := map[string]interface{}{
+		"tokens":     list,
+		"total":      total,
+		"nextOffset": nil,
+	}
+	if next := uint64(offset + len(tokens)); next < total {
+		result["nextOffset"] = next
+	}
+	return result, nil
+}
@@ -0,0 +1,50 @@
+// Package rpc - Public transparency report of reserved wallet movements
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"chaincore/internal/token"
+)
+
+// SetReservedMonitor provides the reserved wallet monitor behind the
+// /transparency/reserved-wallets endpoint. It must be called before Start.
+func (s *Server) SetReservedMonitor(m *token.ReservedWalletMonitor) {
+	s.reserved = m
+}
+
+// handleReservedWallets serves the transparency report. It is public: the
+// movements are on chain anyway, and the report makes them easy to audit.
+// Query parameters offset and limit page through the movements, newest
+// first.
+func (s *Server) handleReservedWallets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var offset, limit uint64
+	var err error
+	if v := r.URL.Query().Get("offset"); v != "" {
+		if offset, err = strconv.ParseUint(v, 10, 64); err != nil {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.ParseUint(v, 10, 64); err != nil {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	report, err := s.reserved.Report(offset, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,88 @@
+// Package rpc - Protocol treasury balance and ledger queries
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"chaincore/internal/blockchain"
+)
+
+// maxTreasuryEntriesPerCall bounds the ledger entries returned by one
+// treasury_getHistory call
+const maxTreasuryEntriesPerCall = 100
+
+// getTreasury returns the treasury's address, balance and running totals
+func (s *Server) getTreasury() (interface{}, error) {
+	t := s.chain.GetTreasury()
+	return map[string]interface{}{
+		"address":    blockchain.ChecksumAddress(blockchain.TreasuryAddress),
+		"balance":    t.Balance.String(),
+		"fees":       t.Fees.String(),
+		"deposits":   t.Deposits.String(),
+		"spent":      t.Spent.String(),
+		"entries":    t.Entries,
+		"feePercent": s.chain.TreasuryFeePercent(),
+	}, nil
+}
+
+// getTreasuryHistory lists the treasury's ledger, oldest first. Params are
+// [offset, count]; at most maxTreasuryEntriesPerCall are returned, and
+// nextOffset is set while more remain.
+func (s *Server) getTreasuryHistory(params json.RawMessage) (interface{}, error) {
+	var args []int
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &args); err != nil || len(args) > 2 {
+			return nil, fmt.Errorf("params must be [offset, count]")
+		}
+	}
+	offset, count := 0, maxTreasuryEntriesPerCall
+	if len(args) > 0 {
+		if offset = args[0]; offset < 0 {
+			return nil, fmt.Errorf("invalid offset")
+		}
+	}
+	if len(args) > 1 {
+		if count = args[1]; count <= 0 {
+			return nil, fmt.Errorf("invalid count")
+		}
+	}
+	if count > maxTreasuryEntriesPerCall {
+		count = maxTreasuryEntriesPerCall
+	}
+
+	entries, total := s.chain.GetTreasuryEntries(uint64(offset), uint64(count))
+	list := make([]map[string]interface{}, len(entries))
+	for i, e := range entries {
+		list[i] = treasuryEntryJSON(e)
+	}
+
+	result := map[string]interface{}{
+		"entries":    list,
+		"total":      total,
+		"nextOffset": nil,
+	}
+	if next := uint64(offset + len(entries)); next < total {
+		result["nextOffset"] = next
+	}
+	return result, nil
+}
+
+// Helper functions
+
+func treasuryEntryJSON(e *blockchain.TreasuryEntry) map[string]interface{} {
+	result := map[string]interface{}{
+		"index":  e.Index,
+		"kind":   e.Kind.String(),
+		"height": e.Height,
+		"amount": e.Amount.String(),
+	}
+	switch e.Kind {
+	case blockchain.TreasuryDeposit:
+		result["from"] = blockchain.ChecksumAddress(e.Account)
+	case blockchain.TreasurySpend:
+		result["recipient"] = blockchain.ChecksumAddress(e.Account)
+		result["proposal"] = e.Proposal
+	}
+	return result
+}
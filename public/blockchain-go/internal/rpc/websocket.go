@@ -2,21 +2,48 @@
 package rpc
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 
-	"golang.org/x/net/websocket"
+	"chaincore/internal/websocket"
 )
 
+// PoolStatsTopic is the subscription topic for aggregated pool telemetry,
+// published by a PoolStatsFeed
+const PoolStatsTopic = "poolStats"
+
+// wsSendBuffer is the number of messages queued for a client before it is
+// disconnected as too slow, with close code 1013 (try again later)
+const wsSendBuffer = 256
+
+// ErrWSTokenRequired is returned for validator and mining channels used
+// over a connection opened without the WebSocket auth token
+var ErrWSTokenRequired = errors.New("WebSocket auth token required for validator and mining channels")
+
+// wsRestrictedChannels are the validator and mining channels: hub topics
+// and method patterns served only to connections opened with the auth
+// token, when one is configured
+var wsRestrictedChannels = []string{PoolStatsTopic, "pos_*", "mining_*"}
+
 // WebSocketClient represents a connected WebSocket client
 type WebSocketClient struct {
 	ID            string
-	Conn          *websocket.Conn
 	Subscriptions map[string]bool
 	Send          chan []byte
 	Close         chan struct{}
+
+	conn       *websocket.Conn
+	authorized bool                        // May use the validator and mining channels
+	slow       chan struct{}               // Closed when the send buffer overflows
+	slowOnce   sync.Once                   // Guards closing slow
+	ethSubs    map[string]*ethSubscription // eth_subscribe subscriptions by ID
+	mu         sync.Mutex                  // Guards Subscriptions and ethSubs
 }
 
 // WebSocketHub manages all WebSocket connections
@@ -25,6 +52,7 @@ type WebSocketHub struct {
 	register   chan *WebSocketClient
 	unregister chan *WebSocketClient
 	broadcast  chan *WebSocketMessage
+	stopCh     chan struct{}
 	mu         sync.RWMutex
 }
 
@@ -34,14 +62,6 @@ type WebSocketMessage struct {
 	Data interface{} `json:"data"`
 }
 
-// SubscriptionRequest represents a subscription request
-type SubscriptionRequest struct {
-	JSONRPC string   `json:"jsonrpc"`
-	Method  string   `json:"method"`
-	Params  []string `json:"params"`
-	ID      int64    `json:"id"`
-}
-
 // NewWebSocketHub creates a new WebSocket hub
 func NewWebSocketHub() *WebSocketHub {
 	return &WebSocketHub{
@@ -49,13 +69,24 @@ func NewWebSocketHub() *WebSocketHub {
 		register:   make(chan *WebSocketClient),
 		unregister: make(chan *WebSocketClient),
 		broadcast:  make(chan *WebSocketMessage, 256),
+		stopCh:     make(chan struct{}),
 	}
 }
 
-// Run starts the WebSocket hub
+// Run starts the WebSocket hub. It returns once the hub is stopped.
 func (h *WebSocketHub) Run() {
 	for {
 		select {
+		case <-h.stopCh:
+			// Closing the connections ends their read loops
+			h.mu.RLock()
+			for _, client := range h.clients {
+				client.conn.WriteClose(websocket.CloseGoingAway, "server shutting down")
+				client.conn.Close()
+			}
+			h.mu.RUnlock()
+			return
+
 		case client := <-h.register:
 			h.mu.Lock()
 			h.clients[client.ID] = client
@@ -70,14 +101,15 @@ func (h *WebSocketHub) Run() {
 			h.mu.Unlock()
 
 		case message := <-h.broadcast:
+			data := mustMarshal(message)
+			restricted := methodAllowed(wsRestrictedChannels, message.Type)
 			h.mu.RLock()
 			for _, client := range h.clients {
-				if client.Subscriptions[message.Type] || client.Subscriptions["*"] {
-					select {
-					case client.Send <- mustMarshal(message):
-					default:
-						// Client buffer full, skip
-					}
+				client.mu.Lock()
+				subscribed := client.Subscriptions[message.Type] || client.Subscriptions["*"]
+				client.mu.Unlock()
+				if subscribed && (client.authorized || !restricted) {
+					client.send(data)
 				}
 			}
 			h.mu.RUnlock()
@@ -85,6 +117,15 @@ func (h *WebSocketHub) Run() {
 	}
 }
 
+// Stop closes every connection and stops the hub
+func (h *WebSocketHub) Stop() {
+	select {
+	case <-h.stopCh:
+	default:
+		close(h.stopCh)
+	}
+}
+
 // BroadcastNewBlock broadcasts a new block to all subscribed clients
 func (h *WebSocketHub) BroadcastNewBlock(block interface{}) {
 	h.broadcast <- &WebSocketMessage{
@@ -117,33 +158,51 @@ func (h *WebSocketHub) BroadcastStatus(status interface{}) {
 	}
 }
 
+// BroadcastPoolStats broadcasts aggregated pool telemetry
+func (h *WebSocketHub) BroadcastPoolStats(stats interface{}) {
+	h.broadcast <- &WebSocketMessage{
+		Type: PoolStatsTopic,
+		Data: stats,
+	}
+}
+
 // handleWebSocket handles WebSocket connections
 func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	wsServer := websocket.Server{
-		Handler: func(ws *websocket.Conn) {
-			s.handleWSConnection(ws)
-		},
-		Handshake: func(config *websocket.Config, r *http.Request) error {
-			// Allow all origins for now
-			return nil
-		},
+	if !s.wsOriginAllowed(r) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+	authorized, ok := s.wsAuthorized(r)
+	if !ok {
+		http.Error(w, "Invalid WebSocket auth token", http.StatusUnauthorized)
+		return
 	}
-	wsServer.ServeHTTP(w, r)
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	s.handleWSConnection(conn, authorized)
 }
 
 // handleWSConnection handles an individual WebSocket connection
-func (s *Server) handleWSConnection(ws *websocket.Conn) {
+func (s *Server) handleWSConnection(conn *websocket.Conn, authorized bool) {
 	client := &WebSocketClient{
 		ID:            generateClientID(),
-		Conn:          ws,
 		Subscriptions: make(map[string]bool),
-		Send:          make(chan []byte, 256),
+		Send:          make(chan []byte, wsSendBuffer),
 		Close:         make(chan struct{}),
+		conn:          conn,
+		authorized:    authorized,
+		slow:          make(chan struct{}),
+		ethSubs:       make(map[string]*ethSubscription),
 	}
 
 	// Register the client
-	if s.wsHub != nil {
-		s.wsHub.register <- client
+	select {
+	case s.wsHub.register <- client:
+	case <-s.wsHub.stopCh:
+		conn.Close()
+		return
 	}
 
 	// Start goroutines for reading and writing
@@ -151,54 +210,73 @@ func (s *Server) handleWSConnection(ws *websocket.Conn) {
 	client.readPump(s)
 
 	// Clean up on disconnect
-	if s.wsHub != nil {
-		s.wsHub.unregister <- client
+	select {
+	case s.wsHub.unregister <- client:
+	case <-s.wsHub.stopCh:
+		close(client.Close)
 	}
 }
 
 // readPump reads messages from the WebSocket connection
 func (c *WebSocketClient) readPump(s *Server) {
 	defer func() {
-		c.Conn.Close()
+		c.conn.Close()
 	}()
 
 	for {
-		var message []byte
-		err := websocket.Message.Receive(c.Conn, &message)
+		message, err := c.conn.ReadMessage()
 		if err != nil {
 			break
 		}
 
-		var req SubscriptionRequest
+		var req Request
 		if err := json.Unmarshal(message, &req); err != nil {
+			c.sendError(nil, -32700, "Parse error")
 			continue
 		}
 
 		switch req.Method {
 		case "subscribe":
-			for _, event := range req.Params {
+			var events []string
+			json.Unmarshal(req.Params, &events)
+			if !c.authorized && containsRestricted(events) {
+				c.sendError(req.ID, ErrCodeMethodNotAllowed, ErrWSTokenRequired.Error())
+				continue
+			}
+			c.mu.Lock()
+			for _, event := range events {
 				c.Subscriptions[event] = true
 			}
+			c.mu.Unlock()
 			c.sendResponse(req.ID, map[string]bool{"subscribed": true})
 
 		case "unsubscribe":
-			for _, event := range req.Params {
+			var events []string
+			json.Unmarshal(req.Params, &events)
+			c.mu.Lock()
+			for _, event := range events {
 				delete(c.Subscriptions, event)
 			}
+			c.mu.Unlock()
 			c.sendResponse(req.ID, map[string]bool{"unsubscribed": true})
 
 		case "ping":
 			c.sendResponse(req.ID, map[string]string{"pong": time.Now().Format(time.RFC3339)})
+
+		default:
+			// eth_subscribe and the methods of the HTTP endpoint
+			s.handleWSRequest(c, &req)
 		}
 	}
 }
 
-// writePump sends messages to the WebSocket connection
+// writePump sends messages to the WebSocket connection. Closing the
+// connection when it returns ends readPump too.
 func (c *WebSocketClient) writePump() {
-	ticker := time.NewTicker(30 * time.Second)
+	ticker := time.NewTicker(websocket.PingPeriod)
 	defer func() {
 		ticker.Stop()
-		c.Conn.Close()
+		c.conn.Close()
 	}()
 
 	for {
@@ -207,20 +285,20 @@ func (c *WebSocketClient) writePump() {
 			if !ok {
 				return
 			}
-			if err := websocket.Message.Send(c.Conn, string(message)); err != nil {
+			if err := c.conn.WriteMessage(message); err != nil {
 				return
 			}
 
 		case <-ticker.C:
-			// Send ping to keep connection alive
-			ping := mustMarshal(&WebSocketMessage{
-				Type: "ping",
-				Data: time.Now().Unix(),
-			})
-			if err := websocket.Message.Send(c.Conn, string(ping)); err != nil {
+			// Keep the connection alive; the reply resets the read deadline
+			if err := c.conn.WritePing(); err != nil {
 				return
 			}
 
+		case <-c.slow:
+			c.conn.WriteClose(websocket.CloseTryAgainLater, "client too slow")
+			return
+
 		case <-c.Close:
 			return
 		}
@@ -228,20 +306,85 @@ func (c *WebSocketClient) writePump() {
 }
 
 // sendResponse sends a JSON-RPC response
-func (c *WebSocketClient) sendResponse(id int64, result interface{}) {
-	response := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"id":      id,
-		"result":  result,
-	}
-	data, _ := json.Marshal(response)
+func (c *WebSocketClient) sendResponse(id interface{}, result interface{}) {
+	c.send(mustMarshal(Response{
+		JSONRPC: "2.0",
+		Result:  result,
+		ID:      id,
+	}))
+}
+
+// sendError sends a JSON-RPC error response
+func (c *WebSocketClient) sendError(id interface{}, code int, message string) {
+	c.sendRPCError(id, &RPCError{Code: code, Message: message})
+}
+
+// sendRPCError sends a JSON-RPC error response with an error's data
+func (c *WebSocketClient) sendRPCError(id interface{}, rpcErr *RPCError) {
+	c.send(mustMarshal(Response{
+		JSONRPC: "2.0",
+		Error:   rpcErr,
+		ID:      id,
+	}))
+}
+
+// send queues a message. If the client's buffer is full, the client is
+// disconnected instead.
+func (c *WebSocketClient) send(data []byte) {
 	select {
 	case c.Send <- data:
 	default:
+		c.slowOnce.Do(func() { close(c.slow) })
+	}
+}
+
+// wsOriginAllowed reports whether a handshake comes from an allowed
+// origin: no Origin at all, the node's own host, or one of
+// WSAllowedOrigins
+func (s *Server) wsOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range s.config.WSAllowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
 	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// wsAuthorized reports whether a handshake may use the validator and
+// mining channels, and ok false if it presented a wrong token
+func (s *Server) wsAuthorized(r *http.Request) (authorized, ok bool) {
+	if s.config.WSAuthToken == "" {
+		return true, true
+	}
+	token := r.Header.Get("X-WS-Token")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	if token == "" {
+		return false, true
+	}
+	valid := subtle.ConstantTimeCompare([]byte(token), []byte(s.config.WSAuthToken)) == 1
+	return valid, valid
 }
 
 // Helper functions
+
+// containsRestricted reports whether any of the hub topics is a validator
+// or mining channel
+func containsRestricted(topics []string) bool {
+	for _, topic := range topics {
+		if methodAllowed(wsRestrictedChannels, topic) {
+			return true
+		}
+	}
+	return false
+}
+
 func mustMarshal(v interface{}) []byte {
 	data, _ := json.Marshal(v)
 	return data
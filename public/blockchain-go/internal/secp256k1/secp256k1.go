@@ -0,0 +1,59 @@
+// Package secp256k1 implements signing and public key recovery on the
+// secp256k1 curve used by Ethereum-compatible wallets. The curve arithmetic
+// is dcrd's constant-time implementation; this package adapts it to the
+// r || s || v signatures and Keccak-256 addresses used by the chain.
+package secp256k1
+
+import (
+	"errors"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+// Errors returned by RecoverPublicKey
+var (
+	ErrInvalidSignature  = errors.New("invalid signature")
+	ErrInvalidRecoveryID = errors.New("invalid recovery id")
+	ErrHighS             = errors.New("signature s value is not canonical")
+)
+
+// compactSigMagicOffset is added to the recovery id in dcrd's compact
+// signatures, which put it before r and s
+const compactSigMagicOffset = 27
+
+// RecoverPublicKey recovers the public key that produced a signature over a
+// 32-byte hash. sig is r || s || v with v the recovery id (0 or 1, plus 2
+// when r overflowed the group order). High-s signatures are rejected as in
+// EIP-2. The key is returned as the 64-byte X || Y encoding.
+func RecoverPublicKey(hash []byte, sig []byte) ([]byte, error) {
+	if len(hash) != 32 || len(sig) != 65 {
+		return nil, ErrInvalidSignature
+	}
+	if sig[64] > 3 {
+		return nil, ErrInvalidRecoveryID
+	}
+	var s secp256k1.ModNScalar
+	if overflow := s.SetByteSlice(sig[32:64]); !overflow && s.IsOverHalfOrder() {
+		return nil, ErrHighS
+	}
+
+	compact := make([]byte, 65)
+	compact[0] = compactSigMagicOffset + sig[64]
+	copy(compact[1:], sig[:64])
+	pub, _, err := ecdsa.RecoverCompact(compact, hash)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	return pub.SerializeUncompressed()[1:], nil
+}
+
+// RecoverAddress recovers the signer's address: the last 20 bytes of the
+// Keccak-256 hash of the public key
+func RecoverAddress(hash []byte, sig []byte) ([20]byte, error) {
+	pub, err := RecoverPublicKey(hash, sig)
+	if err != nil {
+		return [20]byte{}, err
+	}
+	return PubkeyToAddress(pub), nil
+}
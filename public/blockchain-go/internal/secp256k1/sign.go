@@ -0,0 +1,104 @@
+// Package secp256k1 - Key generation and deterministic signing
+package secp256k1
+
+import (
+	"errors"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	"golang.org/x/crypto/sha3"
+)
+
+// ErrInvalidPrivateKey is returned for scalars outside [1, n-1]
+var ErrInvalidPrivateKey = errors.New("invalid private key")
+
+// GenerateKey returns a new random 32-byte private key
+func GenerateKey() ([]byte, error) {
+	key, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	defer key.Zero()
+	return key.Serialize(), nil
+}
+
+// PublicKey derives the 64-byte X || Y public key for a private key
+func PublicKey(priv []byte) ([]byte, error) {
+	key, err := privateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	defer key.Zero()
+	return key.PubKey().SerializeUncompressed()[1:], nil
+}
+
+// CompressPublicKey returns the 33-byte SEC 1 compressed form of a 64-byte
+// X || Y public key
+func CompressPublicKey(pub []byte) []byte {
+	compressed := make([]byte, 33)
+	compressed[0] = 0x02 | pub[63]&1
+	copy(compressed[1:], pub[:32])
+	return compressed
+}
+
+// AddPrivateKeys returns (a + b) mod n, as used by BIP-32 child key
+// derivation. It fails if b is not below n or the sum is zero.
+func AddPrivateKeys(a, b []byte) ([]byte, error) {
+	key, err := privateKey(a)
+	if err != nil {
+		return nil, err
+	}
+	defer key.Zero()
+	var tweak secp256k1.ModNScalar
+	if len(b) != 32 || tweak.SetByteSlice(b) {
+		return nil, ErrInvalidPrivateKey
+	}
+	sum := tweak.Add(&key.Key)
+	if sum.IsZero() {
+		return nil, ErrInvalidPrivateKey
+	}
+	out := sum.Bytes()
+	sum.Zero()
+	return out[:], nil
+}
+
+// PubkeyToAddress returns the last 20 bytes of the Keccak-256 hash of a
+// 64-byte public key
+func PubkeyToAddress(pub []byte) [20]byte {
+	var addr [20]byte
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write(pub)
+	copy(addr[:], hasher.Sum(nil)[12:])
+	return addr
+}
+
+// Sign signs a 32-byte hash and returns r || s || v in the format accepted
+// by RecoverPublicKey. The nonce is derived as in RFC 6979 and s is
+// normalized to the lower half of the group order.
+func Sign(hash []byte, priv []byte) ([]byte, error) {
+	if len(hash) != 32 {
+		return nil, ErrInvalidSignature
+	}
+	key, err := privateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	defer key.Zero()
+
+	compact := ecdsa.SignCompact(key, hash, false)
+	sig := make([]byte, 65)
+	copy(sig, compact[1:])
+	sig[64] = compact[0] - compactSigMagicOffset
+	return sig, nil
+}
+
+// Helper functions
+
+// privateKey parses a 32-byte scalar in [1, n-1]
+func privateKey(b []byte) (*secp256k1.PrivateKey, error) {
+	var k secp256k1.ModNScalar
+	if len(b) != 32 || k.SetByteSlice(b) || k.IsZero() {
+		return nil, ErrInvalidPrivateKey
+	}
+	return secp256k1.NewPrivateKey(&k), nil
+}
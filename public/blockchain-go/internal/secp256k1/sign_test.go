@@ -0,0 +1,43 @@
+package secp256k1
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// The signing example of EIP-155
+func TestSignEIP155Example(t *testing.T) {
+	key, _ := hex.DecodeString("4646464646464646464646464646464646464646464646464646464646464646")
+	hash, _ := hex.DecodeString("daf5a779ae972f972197303d7b574746c7ef83eadac0f2791ad23db92e4c8e53")
+	want := "28ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276" +
+		"67cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83" + "00"
+
+	sig, err := Sign(hash, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(sig); got != want {
+		t.Fatalf("signature %s, want %s", got, want)
+	}
+	addr, err := RecoverAddress(hash, sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(addr[:]); got != "9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f" {
+		t.Fatalf("recovered %s", got)
+	}
+
+	sig[64] = 4
+	if _, err := RecoverAddress(hash, sig); err != ErrInvalidRecoveryID {
+		t.Fatalf("recovery id 4: %v", err)
+	}
+}
+
+func TestPrivateKeyRange(t *testing.T) {
+	n, _ := hex.DecodeString("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141")
+	for _, key := range [][]byte{make([]byte, 32), n, n[:31]} {
+		if _, err := PublicKey(key); err != ErrInvalidPrivateKey {
+			t.Fatalf("key %x: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+// Package storage - Scheduled and manual database compaction
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"chaincore/internal/logging"
+)
+
+// logger is the storage module's logger
+var logger = logging.New("storage")
+
+// Compaction defaults, used when the config leaves them zero
+const (
+	defaultCompactionInterval = 24 * time.Hour
+	compactionCheckInterval   = time.Minute
+)
+
+// ErrCompactionRunning is returned when a compaction is requested while one
+// is already in progress
+var ErrCompactionRunning = errors.New("compaction already running")
+
+// CompactionConfig schedules background compaction. Compaction competes with
+// block import for disk bandwidth, so it runs only inside an off-peak window
+// of local hours. A window whose end is before its start wraps past
+// midnight; equal bounds mean the whole day.
+type CompactionConfig struct {
+	Enabled     bool
+	WindowStart int           // Hour of day (0-23) the window opens
+	WindowEnd   int           // Hour of day (0-23) the window closes
+	Interval    time.Duration // Minimum time between scheduled runs (default 24h)
+}
+
+// CompactionResult describes one compaction run
+type CompactionResult struct {
+	Started    time.Time     `json:"started"`
+	Duration   time.Duration `json:"duration"`
+	SizeBefore int64         `json:"sizeBefore"` // Bytes on disk
+	SizeAfter  int64         `json:"sizeAfter"`
+	Reclaimed  int64         `json:"reclaimed"` // Negative if the database grew meanwhile
+	Manual     bool          `json:"manual"`
+}
+
+// CompactionStats are cumulative compaction metrics
+type CompactionStats struct {
+	Runs           uint64            `json:"runs"`
+	Failures       uint64            `json:"failures"`
+	TotalDuration  time.Duration     `json:"totalDuration"`
+	TotalReclaimed int64             `json:"totalReclaimed"`
+	Running        bool              `json:"running"`
+	Last           *CompactionResult `json:"last,omitempty"`
+}
+
+// Compactor runs full-range compactions of a LevelDB database on a schedule
+// and on demand
+type Compactor struct {
+	db      *LevelDB
+	config  CompactionConfig
+	stats   CompactionStats
+	lastRun time.Time
+	stopCh  chan struct{}
+	mu      sync.Mutex
+}
+
+// NewCompactor creates a compactor for db. Start runs the schedule; manual
+// compactions work either way.
+func NewCompactor(db *LevelDB, config CompactionConfig) (*Compactor, error) {
+	if config.WindowStart < 0 || config.WindowStart > 23 || config.WindowEnd < 0 || config.WindowEnd > 23 {
+		return nil, fmt.Errorf("compaction window hours must be 0-23, got %d-%d", config.WindowStart, config.WindowEnd)
+	}
+	return &Compactor{
+		db:     db,
+		config: config,
+		stopCh: make(chan struct{}),
+	}, nil
+}
+
+// Start begins scheduled compaction if it is enabled
+func (c *Compactor) Start() {
+	if !c.config.Enabled {
+		return
+	}
+	go c.scheduleLoop()
+}
+
+// Stop ends scheduled compaction. A compaction in progress runs to
+// completion.
+func (c *Compactor) Stop() {
+	select {
+	case <-c.stopCh:
+	default:
+		close(c.stopCh)
+	}
+}
+
+// Compact compacts the whole database now, whether or not the window is
+// open
+func (c *Compactor) Compact() (*CompactionResult, error) {
+	return c.run(true)
+}
+
+// Stats returns cumulative compaction metrics
+func (c *Compactor) Stats() CompactionStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	if stats.Last != nil {
+		last := *stats.Last
+		stats.Last = &last
+	}
+	return stats
+}
+
+// Helper functions
+
+func (c *Compactor) scheduleLoop() {
+	ticker := time.NewTicker(compactionCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case now := <-ticker.C:
+			if !c.due(now) {
+				continue
+			}
+			result, err := c.run(false)
+			if errors.Is(err, ErrCompactionRunning) {
+				continue
+			}
+			if err != nil {
+				logger.Error("Scheduled compaction failed", "err", err)
+				continue
+			}
+			logger.Info("Scheduled compaction finished", "reclaimed", result.Reclaimed, "duration", result.Duration)
+		}
+	}
+}
+
+// due reports whether a scheduled compaction should start at now
+func (c *Compactor) due(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	interval := c.config.Interval
+	if interval <= 0 {
+		interval = defaultCompactionInterval
+	}
+	return c.inWindow(now) && (c.lastRun.IsZero() || now.Sub(c.lastRun) >= interval)
+}
+
+func (c *Compactor) inWindow(now time.Time) bool {
+	start, end, hour := c.config.WindowStart, c.config.WindowEnd, now.Hour()
+	switch {
+	case start == end:
+		return true
+	case start < end:
+		return hour >= start && hour < end
+	default:
+		return hour >= start || hour < end
+	}
+}
+
+// run compacts the full key range and records the result
+func (c *Compactor) run(manual bool) (*CompactionResult, error) {
+	c.mu.Lock()
+	if c.stats.Running {
+		c.mu.Unlock()
+		return nil, ErrCompactionRunning
+	}
+	c.stats.Running = true
+	c.mu.Unlock()
+
+	result := &CompactionResult{
+		Started:    time.Now(),
+		SizeBefore: dirSize(c.db.path),
+		Manual:     manual,
+	}
+	err := c.db.Compact(nil, nil)
+	result.Duration = time.Since(result.Started)
+	result.SizeAfter = c.db.GetSize()
+	result.Reclaimed = result.SizeBefore - result.SizeAfter
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats.Running = false
+	c.lastRun = result.Started
+	if err != nil {
+		c.stats.Failures++
+		return nil, err
+	}
+	c.stats.Runs++
+	c.stats.TotalDuration += result.Duration
+	c.stats.TotalReclaimed += result.Reclaimed
+	c.stats.Last = result
+	return result, nil
+}
@@ -3,9 +3,22 @@ package storage
 
 import (
 	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	ldberrors "github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/filter"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	ldbstorage "github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
+// ErrNotFound is returned by Get when a key does not exist
+var ErrNotFound = errors.New("key not found")
+
 // Config holds storage configuration
 type Config struct {
 	DataDir     string
@@ -30,6 +43,8 @@ type Database interface {
 	Has(key []byte) (bool, error)
 	Close() error
 	NewBatch() Batch
+	NewIterator(prefix []byte) Iterator
+	Compact(start, limit []byte) error
 }
 
 // Batch interface for batch operations
@@ -40,92 +55,144 @@ type Batch interface {
 	Reset()
 }
 
+// Iterator walks keys in ascending order. Key and Value are only valid
+// until the next call to Next; Release must be called when done.
+type Iterator interface {
+	Next() bool
+	Key() []byte
+	Value() []byte
+	Error() error
+	Release()
+}
+
 // LevelDB implements Database using LevelDB
 type LevelDB struct {
 	config    Config
-	data      map[string][]byte
+	db        *leveldb.DB
+	path      string
+	sizeBytes int64 // Approximate; refreshed from disk on open and compaction
 	mu        sync.RWMutex
-	sizeBytes int64
 }
 
-// NewLevelDB creates a new LevelDB instance
+// NewLevelDB opens (or creates) the chain database under config.DataDir.
+// A database left corrupted by a crash is recovered from its table files.
 func NewLevelDB(config Config) (*LevelDB, error) {
-	return &LevelDB{
+	path := filepath.Join(config.DataDir, "chaindata")
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, err
+	}
+
+	options := &opt.Options{
+		BlockCacheCapacity: 64 * opt.MiB,
+		WriteBuffer:        16 * opt.MiB,
+		Filter:             filter.NewBloomFilter(10),
+	}
+	ldb, err := leveldb.OpenFile(path, options)
+	if ldberrors.IsCorrupted(err) {
+		ldb, err = leveldb.RecoverFile(path, options)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	db := &LevelDB{
 		config: config,
-		data:   make(map[string][]byte),
-	}, nil
+		db:     ldb,
+		path:   path,
+	}
+	db.sizeBytes = dirSize(path)
+	return db, nil
+}
+
+// NewMemoryLevelDB opens an empty database held in memory, for nodes that
+// need not outlive the process, such as those of a test network. It has no
+// size limit.
+func NewMemoryLevelDB() (*LevelDB, error) {
+	ldb, err := leveldb.Open(ldbstorage.NewMemStorage(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &LevelDB{db: ldb}, nil
 }
 
 // Get retrieves a value by key
 func (db *LevelDB) Get(key []byte) ([]byte, error) {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-
-	value, exists := db.data[string(key)]
-	if !exists {
-		return nil, errors.New("key not found")
+	value, err := db.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, ErrNotFound
 	}
-	return value, nil
+	return value, err
 }
 
 // Put stores a key-value pair
 func (db *LevelDB) Put(key, value []byte) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-
-	// Check size limit
-	newSize := db.sizeBytes + int64(len(key)+len(value))
-	maxBytes := db.config.MaxSizeGB * 1024 * 1024 * 1024
-
-	if newSize > maxBytes {
-		if db.config.EnablePrune {
-			db.prune(newSize - maxBytes)
-		} else {
-			return errors.New("storage limit exceeded")
-		}
+	if err := db.reserve(int64(len(key) + len(value))); err != nil {
+		return err
 	}
-
-	db.data[string(key)] = value
-	db.sizeBytes = newSize
-	return nil
+	return db.db.Put(key, value, nil)
 }
 
 // Delete removes a key
 func (db *LevelDB) Delete(key []byte) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-
-	if value, exists := db.data[string(key)]; exists {
-		db.sizeBytes -= int64(len(key) + len(value))
-		delete(db.data, string(key))
-	}
-	return nil
+	return db.db.Delete(key, nil)
 }
 
 // Has checks if a key exists
 func (db *LevelDB) Has(key []byte) (bool, error) {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-
-	_, exists := db.data[string(key)]
-	return exists, nil
+	return db.db.Has(key, nil)
 }
 
-// Close closes the database
+// Close flushes and closes the database
 func (db *LevelDB) Close() error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-
-	db.data = nil
-	return nil
+	return db.db.Close()
 }
 
 // NewBatch creates a new batch
 func (db *LevelDB) NewBatch() Batch {
 	return &LevelDBBatch{
-		db:   db,
-		ops:  make([]batchOp, 0),
+		db:    db,
+		batch: new(leveldb.Batch),
+	}
+}
+
+// NewIterator returns an iterator over all keys with the given prefix. A
+// nil prefix iterates the whole database.
+func (db *LevelDB) NewIterator(prefix []byte) Iterator {
+	return db.db.NewIterator(util.BytesPrefix(prefix), nil)
+}
+
+// Compact compacts the key range [start, limit). Nil bounds extend the
+// range to the start or end of the database.
+func (db *LevelDB) Compact(start, limit []byte) error {
+	if err := db.db.CompactRange(util.Range{Start: start, Limit: limit}); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	db.sizeBytes = dirSize(db.path)
+	db.mu.Unlock()
+	return nil
+}
+
+// reserve accounts for n bytes about to be written, pruning or refusing
+// the write when the size limit would be exceeded
+func (db *LevelDB) reserve(n int64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	newSize := db.sizeBytes + n
+	maxBytes := db.config.MaxSizeGB * 1024 * 1024 * 1024
+
+	if maxBytes > 0 && newSize > maxBytes {
+		if db.config.EnablePrune {
+			db.prune(newSize - maxBytes)
+		} else {
+			return errors.New("storage limit exceeded")
+		}
 	}
+
+	db.sizeBytes = newSize
+	return nil
 }
 
 // prune removes old data to free space
@@ -140,41 +207,35 @@ func (db *LevelDB) GetSize() int64 {
 	return db.sizeBytes
 }
 
-// LevelDBBatch implements Batch for LevelDB
+// LevelDBBatch implements Batch for LevelDB. Writes are applied atomically
+// and synced to disk, so a crash never leaves a batch partially applied.
 type LevelDBBatch struct {
-	db  *LevelDB
-	ops []batchOp
-}
-
-type batchOp struct {
-	key    []byte
-	value  []byte
-	delete bool
+	db    *LevelDB
+	batch *leveldb.Batch
+	size  int64
 }
 
 func (b *LevelDBBatch) Put(key, value []byte) error {
-	b.ops = append(b.ops, batchOp{key: key, value: value})
+	b.batch.Put(key, value)
+	b.size += int64(len(key) + len(value))
 	return nil
 }
 
 func (b *LevelDBBatch) Delete(key []byte) error {
-	b.ops = append(b.ops, batchOp{key: key, delete: true})
+	b.batch.Delete(key)
 	return nil
 }
 
 func (b *LevelDBBatch) Write() error {
-	for _, op := range b.ops {
-		if op.delete {
-			b.db.Delete(op.key)
-		} else {
-			b.db.Put(op.key, op.value)
-		}
+	if err := b.db.reserve(b.size); err != nil {
+		return err
 	}
-	return nil
+	return b.db.db.Write(b.batch, &opt.WriteOptions{Sync: true})
 }
 
 func (b *LevelDBBatch) Reset() {
-	b.ops = make([]batchOp, 0)
+	b.batch.Reset()
+	b.size = 0
 }
 
 // LiteCache implements caching for lite nodes
@@ -256,3 +317,18 @@ func (lc *LiteCache) Close() error {
 	lc.blocks = nil
 	return nil
 }
+
+// Helper functions
+func dirSize(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,410 @@
+// Package storage - Operation latency metrics and slow operation log
+package storage
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Metrics defaults and limits
+const (
+	defaultSlowThreshold = 100 * time.Millisecond
+	maxSlowOps           = 100 // Slow operations kept for Stats
+	maxKeyPrefixes       = 256 // Distinct prefixes tracked; the rest count as "other"
+	maxKeyPrefixLen      = 24
+	otherKeyPrefix       = "other"
+)
+
+// LatencyBuckets are the upper bounds of the latency histogram buckets. A
+// final bucket counts operations slower than the last bound.
+var LatencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// MetricsConfig configures a MeteredDatabase
+type MetricsConfig struct {
+	SlowThreshold time.Duration // Operations at least this slow are logged (default 100ms)
+}
+
+// LatencyHistogram is the latency distribution of one kind of operation
+type LatencyHistogram struct {
+	Count   uint64        `json:"count"`
+	Errors  uint64        `json:"errors"`
+	Total   time.Duration `json:"total"`
+	Max     time.Duration `json:"max"`
+	Buckets []uint64      `json:"buckets"` // One per LatencyBuckets bound, plus one for slower
+}
+
+// KeyPrefixStats counts the operations on keys sharing a prefix, such as
+// "acct:" or "hist:bal:". A prefix with many operations or a large total
+// time is a hot spot.
+type KeyPrefixStats struct {
+	Reads        uint64        `json:"reads"`
+	Writes       uint64        `json:"writes"` // Puts and deletes, direct or batched
+	BytesRead    uint64        `json:"bytesRead"`
+	BytesWritten uint64        `json:"bytesWritten"`
+	Total        time.Duration `json:"total"` // Time in direct operations; batches are timed as a whole
+	Slow         uint64        `json:"slow"`
+}
+
+// SlowOperation describes one operation that took at least the slow
+// threshold
+type SlowOperation struct {
+	Time      time.Time     `json:"time"`
+	Operation string        `json:"operation"`
+	KeyPrefix string        `json:"keyPrefix"`
+	Duration  time.Duration `json:"duration"`
+	Size      int           `json:"size"` // Bytes read or written
+}
+
+// StorageStats are cumulative storage operation metrics
+type StorageStats struct {
+	BucketBounds  []time.Duration              `json:"bucketBounds"`
+	SlowThreshold time.Duration                `json:"slowThreshold"`
+	Operations    map[string]*LatencyHistogram `json:"operations"` // By operation: get, has, put, delete, batchWrite, iterate, compact
+	KeyPrefixes   map[string]*KeyPrefixStats   `json:"keyPrefixes"`
+	SlowOps       []SlowOperation              `json:"slowOps"` // Most recent last
+}
+
+// MeteredDatabase wraps a Database and records the latency of every
+// operation, per operation and per key prefix. Operations at least as slow
+// as the threshold are logged with their key prefix, duration and size, so
+// hot keys and a degrading disk show up before block import falls behind.
+type MeteredDatabase struct {
+	db         Database
+	config     MetricsConfig
+	operations map[string]*LatencyHistogram
+	prefixes   map[string]*KeyPrefixStats
+	slowOps    []SlowOperation
+	mu         sync.Mutex
+}
+
+// MeteredBatch wraps a Batch and records its write as one operation
+type MeteredBatch struct {
+	batch    Batch
+	db       *MeteredDatabase
+	prefixes map[string]*KeyPrefixStats
+	size     int
+}
+
+// meteredIterator wraps an Iterator and records the whole iteration as one
+// operation when it is released
+type meteredIterator struct {
+	Iterator
+	db      *MeteredDatabase
+	prefix  string
+	started time.Time
+	size    int
+	steps   uint64
+}
+
+// NewMeteredDatabase wraps db with latency metrics
+func NewMeteredDatabase(db Database, config MetricsConfig) *MeteredDatabase {
+	if config.SlowThreshold <= 0 {
+		config.SlowThreshold = defaultSlowThreshold
+	}
+	return &MeteredDatabase{
+		db:         db,
+		config:     config,
+		operations: make(map[string]*LatencyHistogram),
+		prefixes:   make(map[string]*KeyPrefixStats),
+	}
+}
+
+// Get retrieves a value by key
+func (m *MeteredDatabase) Get(key []byte) ([]byte, error) {
+	start := time.Now()
+	value, err := m.db.Get(key)
+	m.record("get", key, len(value), false, time.Since(start), err)
+	return value, err
+}
+
+// Put stores a key-value pair
+func (m *MeteredDatabase) Put(key, value []byte) error {
+	start := time.Now()
+	err := m.db.Put(key, value)
+	m.record("put", key, len(key)+len(value), true, time.Since(start), err)
+	return err
+}
+
+// Delete removes a key
+func (m *MeteredDatabase) Delete(key []byte) error {
+	start := time.Now()
+	err := m.db.Delete(key)
+	m.record("delete", key, len(key), true, time.Since(start), err)
+	return err
+}
+
+// Has checks if a key exists
+func (m *MeteredDatabase) Has(key []byte) (bool, error) {
+	start := time.Now()
+	ok, err := m.db.Has(key)
+	m.record("has", key, 0, false, time.Since(start), err)
+	return ok, err
+}
+
+// Close closes the underlying database
+func (m *MeteredDatabase) Close() error {
+	return m.db.Close()
+}
+
+// NewBatch creates a metered batch
+func (m *MeteredDatabase) NewBatch() Batch {
+	return &MeteredBatch{
+		batch:    m.db.NewBatch(),
+		db:       m,
+		prefixes: make(map[string]*KeyPrefixStats),
+	}
+}
+
+// NewIterator creates a metered iterator. The iteration is timed from
+// creation to Release.
+func (m *MeteredDatabase) NewIterator(prefix []byte) Iterator {
+	return &meteredIterator{
+		Iterator: m.db.NewIterator(prefix),
+		db:       m,
+		prefix:   keyPrefix(prefix),
+		started:  time.Now(),
+	}
+}
+
+// Compact compacts a key range
+func (m *MeteredDatabase) Compact(start, limit []byte) error {
+	began := time.Now()
+	err := m.db.Compact(start, limit)
+	m.record("compact", start, 0, false, time.Since(began), err)
+	return err
+}
+
+// SetSlowThreshold changes how slow an operation must be to be logged
+func (m *MeteredDatabase) SetSlowThreshold(threshold time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.config.SlowThreshold = threshold
+}
+
+// Stats returns a snapshot of the metrics
+func (m *MeteredDatabase) Stats() StorageStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := StorageStats{
+		BucketBounds:  LatencyBuckets,
+		SlowThreshold: m.config.SlowThreshold,
+		Operations:    make(map[string]*LatencyHistogram, len(m.operations)),
+		KeyPrefixes:   make(map[string]*KeyPrefixStats, len(m.prefixes)),
+		SlowOps:       append([]SlowOperation(nil), m.slowOps...),
+	}
+	for op, h := range m.operations {
+		histogram := *h
+		histogram.Buckets = append([]uint64(nil), h.Buckets...)
+		stats.Operations[op] = &histogram
+	}
+	for prefix, p := range m.prefixes {
+		prefixStats := *p
+		stats.KeyPrefixes[prefix] = &prefixStats
+	}
+	return stats
+}
+
+// Put adds a put operation to the batch
+func (b *MeteredBatch) Put(key, value []byte) error {
+	b.count(key, len(key)+len(value))
+	return b.batch.Put(key, value)
+}
+
+// Delete adds a delete operation to the batch
+func (b *MeteredBatch) Delete(key []byte) error {
+	b.count(key, len(key))
+	return b.batch.Delete(key)
+}
+
+// Write commits the batch
+func (b *MeteredBatch) Write() error {
+	start := time.Now()
+	err := b.batch.Write()
+	b.db.recordBatch(b.prefixes, b.size, time.Since(start), err)
+	return err
+}
+
+// Reset clears the batch
+func (b *MeteredBatch) Reset() {
+	b.prefixes = make(map[string]*KeyPrefixStats)
+	b.size = 0
+	b.batch.Reset()
+}
+
+// Next moves to the next key
+func (it *meteredIterator) Next() bool {
+	if !it.Iterator.Next() {
+		return false
+	}
+	it.steps++
+	it.size += len(it.Iterator.Key()) + len(it.Iterator.Value())
+	return true
+}
+
+// Release releases the iterator and records the iteration
+func (it *meteredIterator) Release() {
+	it.Iterator.Release()
+	duration := time.Since(it.started)
+
+	m := it.db
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.observe("iterate", duration, it.Iterator.Error())
+	p := m.prefixStats(it.prefix)
+	p.Reads += it.steps
+	p.BytesRead += uint64(it.size)
+	p.Total += duration
+	if duration >= m.config.SlowThreshold {
+		p.Slow++
+		m.slow("iterate", it.prefix, duration, it.size)
+	}
+}
+
+// Helper functions
+
+// record adds a direct operation on key to the metrics
+func (m *MeteredDatabase) record(op string, key []byte, size int, write bool, duration time.Duration, err error) {
+	prefix := keyPrefix(key)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.observe(op, duration, err)
+	p := m.prefixStats(prefix)
+	if write {
+		p.Writes++
+		p.BytesWritten += uint64(size)
+	} else {
+		p.Reads++
+		p.BytesRead += uint64(size)
+	}
+	p.Total += duration
+	if duration >= m.config.SlowThreshold {
+		p.Slow++
+		m.slow(op, prefix, duration, size)
+	}
+}
+
+// recordBatch adds a batch write to the metrics. A slow batch is logged
+// under the prefix it wrote the most bytes to.
+func (m *MeteredDatabase) recordBatch(prefixes map[string]*KeyPrefixStats, size int, duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.observe("batchWrite", duration, err)
+	heaviest, heaviestBytes := "", uint64(0)
+	for prefix, counted := range prefixes {
+		p := m.prefixStats(prefix)
+		p.Writes += counted.Writes
+		p.BytesWritten += counted.BytesWritten
+		if counted.BytesWritten > heaviestBytes || heaviest == "" {
+			heaviest, heaviestBytes = prefix, counted.BytesWritten
+		}
+	}
+	if duration >= m.config.SlowThreshold {
+		if heaviest != "" {
+			m.prefixStats(heaviest).Slow++
+		}
+		m.slow("batchWrite", heaviest, duration, size)
+	}
+}
+
+// observe adds a latency to an operation's histogram. Callers must hold
+// m.mu.
+func (m *MeteredDatabase) observe(op string, duration time.Duration, err error) {
+	h, exists := m.operations[op]
+	if !exists {
+		h = &LatencyHistogram{Buckets: make([]uint64, len(LatencyBuckets)+1)}
+		m.operations[op] = h
+	}
+	h.Count++
+	if err != nil && err != ErrNotFound {
+		h.Errors++
+	}
+	h.Total += duration
+	if duration > h.Max {
+		h.Max = duration
+	}
+	h.Buckets[sort.Search(len(LatencyBuckets), func(i int) bool {
+		return duration <= LatencyBuckets[i]
+	})]++
+}
+
+// prefixStats returns the stats for a key prefix, folding new prefixes into
+// "other" once maxKeyPrefixes are tracked. Callers must hold m.mu.
+func (m *MeteredDatabase) prefixStats(prefix string) *KeyPrefixStats {
+	p, exists := m.prefixes[prefix]
+	if exists {
+		return p
+	}
+	if len(m.prefixes) >= maxKeyPrefixes {
+		prefix = otherKeyPrefix
+		if p, exists = m.prefixes[prefix]; exists {
+			return p
+		}
+	}
+	p = &KeyPrefixStats{}
+	m.prefixes[prefix] = p
+	return p
+}
+
+// slow logs and keeps a slow operation. Callers must hold m.mu.
+func (m *MeteredDatabase) slow(op, prefix string, duration time.Duration, size int) {
+	logger.Warn("Slow storage operation", "op", op, "prefix", prefix, "duration", duration, "bytes", size)
+	if len(m.slowOps) >= maxSlowOps {
+		m.slowOps = append(m.slowOps[:0], m.slowOps[1:]...)
+	}
+	m.slowOps = append(m.slowOps, SlowOperation{
+		Time:      time.Now(),
+		Operation: op,
+		KeyPrefix: prefix,
+		Duration:  duration,
+		Size:      size,
+	})
+}
+
+// count adds a batched write to the batch's per-prefix tally
+func (b *MeteredBatch) count(key []byte, size int) {
+	prefix := keyPrefix(key)
+	p, exists := b.prefixes[prefix]
+	if !exists {
+		p = &KeyPrefixStats{}
+		b.prefixes[prefix] = p
+	}
+	p.Writes++
+	p.BytesWritten += uint64(size)
+	b.size += size
+}
+
+// keyPrefix returns the namespace of a key: its leading printable text up
+// to and including the last ':', such as "acct:" or "hist:bal:". Keys with
+// no such namespace are grouped as "other".
+func keyPrefix(key []byte) string {
+	end := 0
+	for i := 0; i < len(key) && i < maxKeyPrefixLen; i++ {
+		c := key[i]
+		if c < 0x20 || c > 0x7e {
+			break
+		}
+		if c == ':' {
+			end = i + 1
+		}
+	}
+	if end == 0 {
+		return otherKeyPrefix
+	}
+	return string(key[:end])
+}
@@ -0,0 +1,298 @@
+// Package storage - Persisted runtime settings with change history
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Settings key prefixes. History keys end in a big-endian sequence number,
+// so they iterate oldest first.
+var (
+	settingValuePrefix   = []byte("settings:value:")
+	settingHistoryPrefix = []byte("settings:history:")
+)
+
+// defaultSettingHistory is how many changes History returns when no limit
+// is given
+const defaultSettingHistory = 100
+
+// Settings errors
+var (
+	ErrUnknownSetting = errors.New("unknown setting")
+	ErrSettingDefined = errors.New("setting is already defined")
+	ErrAuthorRequired = errors.New("setting changes need an author")
+)
+
+// Setting defines a runtime setting
+type Setting struct {
+	Key         string
+	Description string
+	Default     string
+	Validate    func(value string) error // Optional
+	Apply       func(value string)       // Called with the effective value whenever it changes
+}
+
+// SettingValue is the current value of a setting
+type SettingValue struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	Default     string `json:"default"`
+	Description string `json:"description"`
+	Changed     bool   `json:"changed"` // The value was set rather than defaulted
+}
+
+// SettingChange records one change to a setting
+type SettingChange struct {
+	Seq    uint64    `json:"seq"`
+	Key    string    `json:"key"`
+	Old    string    `json:"old"`
+	New    string    `json:"new"`
+	Reset  bool      `json:"reset,omitempty"` // Reverted to the default
+	Author string    `json:"author"`
+	Time   time.Time `json:"time"`
+}
+
+// Settings stores runtime settings in a database, so changes survive
+// restarts, and records each change with its author
+type Settings struct {
+	db      Database
+	defined map[string]*Setting
+	stored  map[string]string // Values set and not reset, by key
+	seq     uint64            // Sequence number of the last change
+	applyMu sync.Mutex        // Serializes changes, so values are applied in the order stored
+	mu      sync.Mutex
+}
+
+// NewSettings loads the settings stored in db
+func NewSettings(db Database) (*Settings, error) {
+	s := &Settings{
+		db:      db,
+		defined: make(map[string]*Setting),
+		stored:  make(map[string]string),
+	}
+
+	it := db.NewIterator(settingValuePrefix)
+	for it.Next() {
+		s.stored[string(it.Key()[len(settingValuePrefix):])] = string(it.Value())
+	}
+	it.Release()
+	if err := it.Error(); err != nil {
+		return nil, fmt.Errorf("failed to load settings: %w", err)
+	}
+
+	it = db.NewIterator(settingHistoryPrefix)
+	for it.Next() {
+		if key := it.Key(); len(key) == len(settingHistoryPrefix)+8 {
+			s.seq = binary.BigEndian.Uint64(key[len(settingHistoryPrefix):])
+		}
+	}
+	it.Release()
+	if err := it.Error(); err != nil {
+		return nil, fmt.Errorf("failed to load setting history: %w", err)
+	}
+	return s, nil
+}
+
+// Define adds a setting and applies its effective value. A stored value
+// that no longer validates is an error rather than silently replaced by
+// the default.
+func (s *Settings) Define(setting Setting) error {
+	if setting.Key == "" {
+		return errors.New("setting key must not be empty")
+	}
+	if setting.Validate != nil {
+		if err := setting.Validate(setting.Default); err != nil {
+			return fmt.Errorf("setting %s: invalid default: %w", setting.Key, err)
+		}
+	}
+
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	s.mu.Lock()
+	if _, exists := s.defined[setting.Key]; exists {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrSettingDefined, setting.Key)
+	}
+	value, stored := s.stored[setting.Key]
+	if !stored {
+		value = setting.Default
+	} else if setting.Validate != nil {
+		if err := setting.Validate(value); err != nil {
+			s.mu.Unlock()
+			return fmt.Errorf("setting %s: stored value %q is invalid: %w", setting.Key, value, err)
+		}
+	}
+	s.defined[setting.Key] = &setting
+	s.mu.Unlock()
+
+	if setting.Apply != nil {
+		setting.Apply(value)
+	}
+	return nil
+}
+
+// Get returns the effective value of a setting
+func (s *Settings) Get(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	setting, ok := s.defined[key]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownSetting, key)
+	}
+	return s.value(setting), nil
+}
+
+// List returns the defined settings, sorted by key
+func (s *Settings) List() []SettingValue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	values := make([]SettingValue, 0, len(s.defined))
+	for key, setting := range s.defined {
+		_, changed := s.stored[key]
+		values = append(values, SettingValue{
+			Key:         key,
+			Value:       s.value(setting),
+			Default:     setting.Default,
+			Description: setting.Description,
+			Changed:     changed,
+		})
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].Key < values[j].Key })
+	return values
+}
+
+// Set validates and stores a new value for a setting, records the change
+// and applies the value
+func (s *Settings) Set(key, value, author string) (*SettingChange, error) {
+	return s.change(key, value, false, author)
+}
+
+// Reset reverts a setting to its default, records the change and applies
+// the default
+func (s *Settings) Reset(key, author string) (*SettingChange, error) {
+	return s.change(key, "", true, author)
+}
+
+// History returns up to limit changes, newest first, to the setting key or
+// to every setting if key is empty. A limit of zero returns the last 100.
+func (s *Settings) History(key string, limit int) ([]SettingChange, error) {
+	if limit <= 0 {
+		limit = defaultSettingHistory
+	}
+
+	var changes []SettingChange
+	it := s.db.NewIterator(settingHistoryPrefix)
+	defer it.Release()
+	for it.Next() {
+		var change SettingChange
+		if err := json.Unmarshal(it.Value(), &change); err != nil {
+			return nil, fmt.Errorf("corrupt setting history: %w", err)
+		}
+		if key == "" || change.Key == key {
+			changes = append(changes, change)
+		}
+	}
+	if err := it.Error(); err != nil {
+		return nil, err
+	}
+
+	// Newest first
+	for i, j := 0, len(changes)-1; i < j; i, j = i+1, j-1 {
+		changes[i], changes[j] = changes[j], changes[i]
+	}
+	if len(changes) > limit {
+		changes = changes[:limit]
+	}
+	return changes, nil
+}
+
+// Helper functions
+
+// change stores a new value, or removes the stored one if reset, together
+// with a history entry in one batch, then applies the effective value
+func (s *Settings) change(key, value string, reset bool, author string) (*SettingChange, error) {
+	author = strings.TrimSpace(author)
+	if author == "" {
+		return nil, ErrAuthorRequired
+	}
+
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	s.mu.Lock()
+	setting, ok := s.defined[key]
+	if !ok {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSetting, key)
+	}
+	if reset {
+		value = setting.Default
+	} else if setting.Validate != nil {
+		if err := setting.Validate(value); err != nil {
+			s.mu.Unlock()
+			return nil, fmt.Errorf("invalid value for %s: %w", key, err)
+		}
+	}
+
+	change := &SettingChange{
+		Seq:    s.seq + 1,
+		Key:    key,
+		Old:    s.value(setting),
+		New:    value,
+		Reset:  reset,
+		Author: author,
+		Time:   time.Now().UTC(),
+	}
+	entry, err := json.Marshal(change)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	batch := s.db.NewBatch()
+	valueKey := append(append([]byte(nil), settingValuePrefix...), key...)
+	if reset {
+		err = batch.Delete(valueKey)
+	} else {
+		err = batch.Put(valueKey, []byte(value))
+	}
+	if err == nil {
+		err = batch.Put(binary.BigEndian.AppendUint64(append([]byte(nil), settingHistoryPrefix...), change.Seq), entry)
+	}
+	if err == nil {
+		err = batch.Write()
+	}
+	if err != nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("failed to store setting: %w", err)
+	}
+	s.seq = change.Seq
+	if reset {
+		delete(s.stored, key)
+	} else {
+		s.stored[key] = value
+	}
+	s.mu.Unlock()
+
+	if setting.Apply != nil {
+		setting.Apply(value)
+	}
+	return change, nil
+}
+
+// value returns the effective value of a setting. The caller must hold mu.
+func (s *Settings) value(setting *Setting) string {
+	if value, ok := s.stored[setting.Key]; ok {
+		return value
+	}
+	return setting.Default
+}
@@ -0,0 +1,129 @@
+// Package storage - OpenTelemetry instrumentation for database access
+package storage
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"chaincore/internal/tracing"
+)
+
+// TracedDatabase wraps a Database and records a span for every operation
+type TracedDatabase struct {
+	db  Database
+	ctx context.Context
+}
+
+// TracedBatch wraps a Batch and records a span when it is written
+type TracedBatch struct {
+	batch Batch
+	ctx   context.Context
+	ops   int
+}
+
+// NewTracedDatabase wraps db with tracing
+func NewTracedDatabase(db Database) *TracedDatabase {
+	return &TracedDatabase{
+		db:  db,
+		ctx: context.Background(),
+	}
+}
+
+// WithContext returns a view of the database whose spans are children of
+// the span carried by ctx
+func (t *TracedDatabase) WithContext(ctx context.Context) *TracedDatabase {
+	return &TracedDatabase{
+		db:  t.db,
+		ctx: ctx,
+	}
+}
+
+// Get retrieves a value by key
+func (t *TracedDatabase) Get(key []byte) ([]byte, error) {
+	_, span := tracing.StartSpan(t.ctx, "storage.Get", attribute.Int("db.key_size", len(key)))
+	value, err := t.db.Get(key)
+	span.SetAttributes(attribute.Int("db.value_size", len(value)))
+	tracing.End(span, err)
+	return value, err
+}
+
+// Put stores a key-value pair
+func (t *TracedDatabase) Put(key, value []byte) error {
+	_, span := tracing.StartSpan(t.ctx, "storage.Put",
+		attribute.Int("db.key_size", len(key)),
+		attribute.Int("db.value_size", len(value)),
+	)
+	err := t.db.Put(key, value)
+	tracing.End(span, err)
+	return err
+}
+
+// Delete removes a key
+func (t *TracedDatabase) Delete(key []byte) error {
+	_, span := tracing.StartSpan(t.ctx, "storage.Delete")
+	err := t.db.Delete(key)
+	tracing.End(span, err)
+	return err
+}
+
+// Has checks if a key exists
+func (t *TracedDatabase) Has(key []byte) (bool, error) {
+	_, span := tracing.StartSpan(t.ctx, "storage.Has")
+	ok, err := t.db.Has(key)
+	tracing.End(span, err)
+	return ok, err
+}
+
+// Close closes the underlying database
+func (t *TracedDatabase) Close() error {
+	return t.db.Close()
+}
+
+// NewBatch creates a traced batch
+func (t *TracedDatabase) NewBatch() Batch {
+	return &TracedBatch{
+		batch: t.db.NewBatch(),
+		ctx:   t.ctx,
+	}
+}
+
+// NewIterator returns an iterator from the underlying database. Iteration
+// is not traced; spans per step would dwarf the work being measured.
+func (t *TracedDatabase) NewIterator(prefix []byte) Iterator {
+	return t.db.NewIterator(prefix)
+}
+
+// Compact compacts a key range
+func (t *TracedDatabase) Compact(start, limit []byte) error {
+	_, span := tracing.StartSpan(t.ctx, "storage.Compact")
+	err := t.db.Compact(start, limit)
+	tracing.End(span, err)
+	return err
+}
+
+// Put adds a put operation to the batch
+func (b *TracedBatch) Put(key, value []byte) error {
+	b.ops++
+	return b.batch.Put(key, value)
+}
+
+// Delete adds a delete operation to the batch
+func (b *TracedBatch) Delete(key []byte) error {
+	b.ops++
+	return b.batch.Delete(key)
+}
+
+// Write commits the batch
+func (b *TracedBatch) Write() error {
+	_, span := tracing.StartSpan(b.ctx, "storage.BatchWrite", attribute.Int("db.batch_ops", b.ops))
+	err := b.batch.Write()
+	tracing.End(span, err)
+	return err
+}
+
+// Reset clears the batch
+func (b *TracedBatch) Reset() {
+	b.ops = 0
+	b.batch.Reset()
+}
@@ -0,0 +1,327 @@
+// Package testutil - Assertions on chain growth, finality, payouts and RPC
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/liteclient"
+)
+
+// pollInterval is how often Wait methods check their condition
+const pollInterval = 50 * time.Millisecond
+
+// rpcCheckedBlocks is how many of the newest blocks RequireRPCConsistent
+// compares
+const rpcCheckedBlocks = 32
+
+// WaitForHeight waits until every full node's head is at least height
+func (n *Network) WaitForHeight(height uint64) {
+	n.t.Helper()
+	n.waitFor(fmt.Sprintf("height %d", height), func() string {
+		for _, node := range n.FullNodes {
+			if h := node.Height(); h < height {
+				return fmt.Sprintf("full node %d is at height %d", node.Index, h)
+			}
+		}
+		return ""
+	})
+}
+
+// WaitForFinality waits until every full node has finalized height
+func (n *Network) WaitForFinality(height uint64) {
+	n.t.Helper()
+	n.waitFor(fmt.Sprintf("finality of block %d", height), func() string {
+		for _, node := range n.FullNodes {
+			if h := node.PoS.GetFinalizedHeight(); h < height {
+				return fmt.Sprintf("full node %d has finalized height %d", node.Index, h)
+			}
+		}
+		return ""
+	})
+}
+
+// WaitForSync waits until every full node has the same head block
+func (n *Network) WaitForSync() {
+	n.t.Helper()
+	n.waitFor("full nodes to agree on the head", func() string {
+		head := n.FullNodes[0].Chain.GetCurrentBlock().Hash()
+		for _, node := range n.FullNodes[1:] {
+			if node.Chain.GetCurrentBlock().Hash() != head {
+				return fmt.Sprintf("full node %d is at %d, full node 0 at %d", node.Index, node.Height(), n.FullNodes[0].Height())
+			}
+		}
+		return ""
+	})
+}
+
+// RequireSameChain fails the test unless the full nodes agree on every
+// block up to the lowest height any of them has finalized. Finalized blocks
+// can never be reverted, so a difference there is a safety failure.
+func (n *Network) RequireSameChain() {
+	n.t.Helper()
+
+	finalized := n.FullNodes[0].PoS.GetFinalizedHeight()
+	for _, node := range n.FullNodes[1:] {
+		if h := node.PoS.GetFinalizedHeight(); h < finalized {
+			finalized = h
+		}
+	}
+	for height := uint64(0); height <= finalized; height++ {
+		want, err := n.FullNodes[0].Chain.GetBlock(height)
+		if err != nil {
+			n.t.Fatalf("full node 0: block %d: %v", height, err)
+		}
+		for _, node := range n.FullNodes[1:] {
+			got, err := node.Chain.GetBlock(height)
+			if err != nil {
+				n.t.Fatalf("full node %d: block %d: %v", node.Index, height, err)
+			}
+			if got.Hash() != want.Hash() {
+				n.t.Fatalf("full node %d has finalized block %x at height %d, full node 0 has %x",
+					node.Index, got.Hash(), height, want.Hash())
+			}
+		}
+	}
+}
+
+// WaitForBalance waits until every full node holds balance for addr
+func (n *Network) WaitForBalance(addr string, balance *big.Int) {
+	n.t.Helper()
+	address := n.parseAddress(addr)
+	n.waitFor(fmt.Sprintf("balance of %s to be %s", addr, balance), func() string {
+		for _, node := range n.FullNodes {
+			if got := node.Chain.GetBalance(address); got.Cmp(balance) != 0 {
+				return fmt.Sprintf("full node %d has %s", node.Index, got)
+			}
+		}
+		return ""
+	})
+}
+
+// Balance returns the balance of addr on the first full node
+func (n *Network) Balance(addr string) *big.Int {
+	n.t.Helper()
+	return n.FullNodes[0].Chain.GetBalance(n.parseAddress(addr))
+}
+
+// WaitForReceipt waits until the transaction is included and returns its
+// receipt, as the lite node reports it
+func (l *LiteNode) WaitForReceipt(txHash string) *liteclient.TxReceipt {
+	n := l.network
+	n.t.Helper()
+
+	var receipt *liteclient.TxReceipt
+	n.waitFor("receipt of "+txHash, func() string {
+		var err error
+		if receipt, err = l.Client.GetTransactionReceipt(txHash); err != nil {
+			return err.Error()
+		}
+		if receipt == nil {
+			return "transaction is pending"
+		}
+		return ""
+	})
+	return receipt
+}
+
+// MiningPayout returns the mining rewards credited to addr by the shares of
+// every block on the first full node's chain
+func (n *Network) MiningPayout(addr string) *big.Int {
+	n.t.Helper()
+	address := n.parseAddress(addr)
+
+	chain := n.FullNodes[0].Chain
+	total := big.NewInt(0)
+	for height := uint64(1); height <= chain.GetCurrentBlock().Header.Height; height++ {
+		block, err := chain.GetBlock(height)
+		if err != nil {
+			n.t.Fatalf("block %d: %v", height, err)
+		}
+		for _, reward := range block.Mining.Rewards {
+			if reward.Address == address && reward.Reward != nil {
+				total.Add(total, reward.Reward)
+			}
+		}
+	}
+	return total
+}
+
+// WaitForMiningPayout waits until blocks have credited at least min in
+// mining rewards to addr
+func (n *Network) WaitForMiningPayout(addr string, min *big.Int) {
+	n.t.Helper()
+	n.waitFor(fmt.Sprintf("mining payout of %s to reach %s", addr, min), func() string {
+		if paid := n.MiningPayout(addr); paid.Cmp(min) < 0 {
+			return fmt.Sprintf("%s paid", paid)
+		}
+		return ""
+	})
+}
+
+// RequireLiteHeaders syncs the lite node's headers and fails the test
+// unless every stored header matches its full node's chain
+func (n *Network) RequireLiteHeaders(lite *LiteNode) {
+	n.t.Helper()
+
+	lite.SyncHeaders()
+	head := lite.Headers.Head()
+	if head == nil {
+		n.t.Fatalf("lite node stored no headers")
+	}
+	for height := uint64(0); height <= head.Height; height++ {
+		header, err := lite.Headers.Header(height)
+		if err != nil {
+			n.t.Fatalf("lite node: header %d: %v", height, err)
+		}
+		block, err := lite.Full.Chain.GetBlock(height)
+		if err != nil {
+			n.t.Fatalf("full node %d: block %d: %v", lite.Full.Index, height, err)
+		}
+		stored := &blockchain.Block{Header: *header}
+		if stored.Hash() != block.Hash() {
+			n.t.Fatalf("lite node has header %x at height %d, full node %d has %x",
+				stored.Hash(), height, lite.Full.Index, block.Hash())
+		}
+	}
+}
+
+// RequireRPCConsistent fails the test unless every full node's RPC server
+// answers chain queries as its chain does: the chain ID, the head height,
+// the hashes of the newest blocks, and the balances of the funded accounts
+// through both the chain_ and eth_ methods. The chain keeps growing
+// meanwhile, so the checks are retried until they all pass at once.
+func (n *Network) RequireRPCConsistent() {
+	n.t.Helper()
+	for _, node := range n.FullNodes {
+		n.waitFor(fmt.Sprintf("full node %d's RPC to match its chain", node.Index), func() string {
+			if err := n.checkRPC(node); err != nil {
+				return err.Error()
+			}
+			return ""
+		})
+	}
+}
+
+// Helper functions
+
+// waitFor polls check until it returns an empty string, failing the test
+// with the last reason it gave once the network's timeout passes
+func (n *Network) waitFor(what string, check func() string) {
+	n.t.Helper()
+
+	deadline := time.Now().Add(n.config.Timeout)
+	for {
+		reason := check()
+		if reason == "" {
+			return
+		}
+		if time.Now().After(deadline) {
+			n.t.Fatalf("timed out after %v waiting for %s: %s", n.config.Timeout, what, reason)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// checkRPC compares a node's RPC answers with its chain
+func (n *Network) checkRPC(node *FullNode) error {
+	var chainID string
+	if err := call(node, "eth_chainId", nil, &chainID); err != nil {
+		return err
+	}
+	if want := fmt.Sprintf("0x%x", n.config.ChainID); chainID != want {
+		return fmt.Errorf("eth_chainId returned %s, want %s", chainID, want)
+	}
+
+	before := node.Height()
+	var height uint64
+	if err := call(node, "chain_getBlockNumber", nil, &height); err != nil {
+		return err
+	}
+	var ethHeight string
+	if err := call(node, "eth_blockNumber", nil, &ethHeight); err != nil {
+		return err
+	}
+	after := node.Height()
+	if height < before || height > after {
+		return fmt.Errorf("chain_getBlockNumber returned %d while the head moved from %d to %d", height, before, after)
+	}
+	if got, err := parseHexBig(ethHeight); err != nil || got.Uint64() < before || got.Uint64() > after {
+		return fmt.Errorf("eth_blockNumber returned %s while the head moved from %d to %d", ethHeight, before, after)
+	}
+
+	first := uint64(0)
+	if before >= rpcCheckedBlocks {
+		first = before - rpcCheckedBlocks + 1
+	}
+	for h := first; h <= before; h++ {
+		block, err := node.Chain.GetBlock(h)
+		if err != nil {
+			return err
+		}
+		var result struct {
+			Hash string `json:"hash"`
+		}
+		if err := call(node, "eth_getBlockByNumber", []interface{}{fmt.Sprintf("0x%x", h), false}, &result); err != nil {
+			return err
+		}
+		hash := block.Hash()
+		if want := fmt.Sprintf("0x%x", hash); !strings.EqualFold(result.Hash, want) {
+			return fmt.Errorf("eth_getBlockByNumber(%d) returned hash %s, want %s", h, result.Hash, want)
+		}
+	}
+
+	for _, account := range n.Accounts {
+		addr := account.Address()
+		want := node.Chain.GetBalance(n.parseAddress(addr))
+
+		var balance string
+		if err := call(node, "chain_getBalance", addr, &balance); err != nil {
+			return err
+		}
+		if balance != want.String() {
+			return fmt.Errorf("chain_getBalance(%s) returned %s, want %s", addr, balance, want)
+		}
+		var ethBalance string
+		if err := call(node, "eth_getBalance", []string{addr, "latest"}, &ethBalance); err != nil {
+			return err
+		}
+		if got, err := parseHexBig(ethBalance); err != nil || got.Cmp(want) != 0 {
+			return fmt.Errorf("eth_getBalance(%s) returned %s, want %s", addr, ethBalance, want)
+		}
+	}
+	return nil
+}
+
+// call calls an RPC method on a full node and decodes the result
+func call(node *FullNode, method string, params interface{}, result interface{}) error {
+	raw, err := node.Client.Call(method, params)
+	if err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	if err := json.Unmarshal(raw, result); err != nil {
+		return fmt.Errorf("%s: malformed result: %w", method, err)
+	}
+	return nil
+}
+
+func (n *Network) parseAddress(addr string) [20]byte {
+	n.t.Helper()
+	address, err := blockchain.ParseAddress(addr, false)
+	if err != nil {
+		n.t.Fatalf("invalid address %q: %v", addr, err)
+	}
+	return address
+}
+
+func parseHexBig(s string) (*big.Int, error) {
+	v, ok := new(big.Int).SetString(strings.TrimPrefix(s, "0x"), 16)
+	if !ok {
+		return nil, fmt.Errorf("malformed hex number %q", s)
+	}
+	return v, nil
+}
@@ -0,0 +1,165 @@
+// Package testutil - Miners submitting shares through lite nodes
+package testutil
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/mining"
+)
+
+// shareInterval is how often a miner submits a share, below the rate a
+// distributor accepts from one session
+const shareInterval = time.Second
+
+// Miner mines shares for the wallet of a lite node and submits them through
+// it, as mining.LiteMiner does. It fetches fresh work for every share, so
+// no share is stale however quickly blocks follow each other.
+type Miner struct {
+	Lite *LiteNode
+
+	accepted uint64
+	rejected uint64
+	stopCh   chan struct{}
+	done     chan struct{}
+}
+
+// AddMiner starts a miner that submits shares through lite; mining rewards
+// are credited to the lite node's wallet
+func (n *Network) AddMiner(lite *LiteNode) *Miner {
+	m := &Miner{
+		Lite:   lite,
+		stopCh: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	n.Miners = append(n.Miners, m)
+	go m.run()
+	return m
+}
+
+// Address returns the address mining rewards are credited to
+func (m *Miner) Address() string {
+	return m.Lite.Address()
+}
+
+// Accepted returns how many shares the full node accepted
+func (m *Miner) Accepted() uint64 {
+	return atomic.LoadUint64(&m.accepted)
+}
+
+// Rejected returns how many shares failed or were refused
+func (m *Miner) Rejected() uint64 {
+	return atomic.LoadUint64(&m.rejected)
+}
+
+// Stop stops mining and waits for a submission in flight to finish
+func (m *Miner) Stop() {
+	select {
+	case <-m.stopCh:
+	default:
+		close(m.stopCh)
+	}
+	<-m.done
+}
+
+// Helper functions
+
+func (m *Miner) run() {
+	defer close(m.done)
+
+	ticker := time.NewTicker(shareInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stopCh:
+			return
+		case <-ticker.C:
+			if err := m.mineShare(); err != nil {
+				atomic.AddUint64(&m.rejected, 1)
+			} else {
+				atomic.AddUint64(&m.accepted, 1)
+			}
+		}
+	}
+}
+
+// mineShare finds, signs and submits one share for the current work
+func (m *Miner) mineShare() error {
+	work, err := m.Lite.Client.GetMiningWork()
+	if err != nil {
+		return err
+	}
+	jobID, _ := work["jobId"].(string)
+	height, _ := work["blockHeight"].(float64)
+	diffStr, _ := work["difficulty"].(string)
+	difficulty, ok := new(big.Int).SetString(diffStr, 10)
+	if !ok || difficulty.Sign() <= 0 {
+		return fmt.Errorf("malformed work difficulty %q", diffStr)
+	}
+
+	sub := &mining.SignedShare{
+		JobID:  jobID,
+		Height: uint64(height),
+	}
+	sub.Nonce, sub.Hash, err = m.findShare(jobID, difficulty)
+	if err != nil {
+		return err
+	}
+	signature, err := m.Lite.Wallet.Sign(sub.Payload())
+	if err != nil {
+		return err
+	}
+
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	accepted, err := m.Lite.Client.SubmitMiningShare(map[string]interface{}{
+		"jobId":          sub.JobID,
+		"height":         sub.Height,
+		"nonce":          fmt.Sprintf("%016x", sub.Nonce),
+		"hash":           hex.EncodeToString(sub.Hash[:]),
+		"signature":      hex.EncodeToString(signature),
+		"idempotencyKey": hex.EncodeToString(key),
+	})
+	if err != nil {
+		return err
+	}
+	if !accepted {
+		return errors.New("share not accepted")
+	}
+	return nil
+}
+
+// findShare searches from a random nonce for work on jobID meeting
+// difficulty, hashing as mining.LiteMiner does
+func (m *Miner) findShare(jobID string, difficulty *big.Int) (uint64, [32]byte, error) {
+	miner, err := blockchain.ParseAddress(m.Address(), false)
+	if err != nil {
+		return 0, [32]byte{}, err
+	}
+
+	var start [8]byte
+	if _, err := rand.Read(start[:]); err != nil {
+		return 0, [32]byte{}, err
+	}
+	for nonce := binary.BigEndian.Uint64(start[:]); ; nonce++ {
+		select {
+		case <-m.stopCh:
+			return 0, [32]byte{}, errors.New("miner stopped")
+		default:
+		}
+		hash := blockchain.MiningShareWork(jobID, miner, nonce)
+		if blockchain.MeetsShareDifficulty(hash, difficulty) {
+			return nonce, hash, nil
+		}
+	}
+}
@@ -0,0 +1,171 @@
+// Package testutil runs networks of full nodes, lite nodes and miners inside
+// one test process and checks how the chain behaves
+package testutil
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/network"
+	"chaincore/internal/wallet"
+)
+
+// Test network defaults
+const (
+	defaultValidators    = 1
+	defaultAccounts      = 2
+	defaultBlockFinality = 2
+	defaultTimeout       = 30 * time.Second
+
+	// Nodes listen on consecutive ports of the in-memory transport
+	firstP2PPort = 30303
+)
+
+// defaultAccountBalance is each funded account's genesis balance: 1000 tokens
+var defaultAccountBalance = new(big.Int).Mul(big.NewInt(1000), big.NewInt(1e18))
+
+// Config holds test network configuration
+type Config struct {
+	Validators     int           // Validating full nodes (default 1)
+	FullNodes      int           // Full nodes that follow the chain without validating
+	Accounts       int           // Wallets funded at genesis (default 2)
+	AccountBalance *big.Int      // Genesis balance of each account (default 1000 tokens)
+	ChainID        uint64        // Default wallet.DefaultChainID
+	BlockFinality  int           // Blocks needed for finality (default 2)
+	Timeout        time.Duration // How long Wait methods wait (default 30s)
+	DevMode        bool          // Seal a block per transaction and serve the dev RPC methods; use with one validator
+}
+
+// Network is a test network running in-process, over an in-memory
+// transport and databases. Every node knows every validator from genesis.
+type Network struct {
+	FullNodes []*FullNode // Validators first
+	LiteNodes []*LiteNode
+	Miners    []*Miner
+	Accounts  []*wallet.Wallet // Funded at genesis
+
+	t         testing.TB
+	config    Config
+	transport *network.MemoryTransport
+	stopped   bool
+}
+
+// NewNetwork creates and starts a test network. It fails the test if a
+// node cannot be started.
+func NewNetwork(t testing.TB, config Config) *Network {
+	t.Helper()
+
+	if config.Validators <= 0 {
+		config.Validators = defaultValidators
+	}
+	if config.Accounts <= 0 {
+		config.Accounts = defaultAccounts
+	}
+	if config.AccountBalance == nil {
+		config.AccountBalance = defaultAccountBalance
+	}
+	if config.ChainID == 0 {
+		config.ChainID = wallet.DefaultChainID
+	}
+	if config.BlockFinality <= 0 {
+		config.BlockFinality = defaultBlockFinality
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout
+	}
+
+	n := &Network{
+		t:         t,
+		config:    config,
+		transport: network.NewMemoryTransport(),
+	}
+	t.Cleanup(n.Stop)
+
+	// Fund the accounts at genesis
+	alloc := make(map[[20]byte]*big.Int, config.Accounts)
+	for i := 0; i < config.Accounts; i++ {
+		w := n.newWallet()
+		addr, err := blockchain.ParseAddress(w.Address(), false)
+		if err != nil {
+			t.Fatalf("account %d: %v", i, err)
+		}
+		alloc[addr] = new(big.Int).Set(config.AccountBalance)
+		n.Accounts = append(n.Accounts, w)
+	}
+
+	for i := 0; i < config.Validators+config.FullNodes; i++ {
+		node, err := newFullNode(n, i, i < config.Validators, alloc)
+		if err != nil {
+			t.Fatalf("full node %d: %v", i, err)
+		}
+		n.FullNodes = append(n.FullNodes, node)
+	}
+
+	// Every node must know the validators before its engine selects the
+	// first validator set
+	for _, v := range n.Validators() {
+		for _, node := range n.FullNodes {
+			if err := node.PoS.RegisterValidator(v.ValidatorAddress(), validatorStake, &v.validatorKey.PublicKey); err != nil {
+				t.Fatalf("full node %d: failed to register validator %d: %v", node.Index, v.Index, err)
+			}
+		}
+	}
+
+	for _, node := range n.FullNodes {
+		if err := node.start(); err != nil {
+			t.Fatalf("full node %d: %v", node.Index, err)
+		}
+	}
+	return n
+}
+
+// Validators returns the validating full nodes
+func (n *Network) Validators() []*FullNode {
+	return n.FullNodes[:n.config.Validators]
+}
+
+// Transport returns the in-memory transport the network runs over
+func (n *Network) Transport() *network.MemoryTransport {
+	return n.transport
+}
+
+// Stop stops every miner and node. It is called when the test ends.
+func (n *Network) Stop() {
+	if n.stopped {
+		return
+	}
+	n.stopped = true
+
+	for _, m := range n.Miners {
+		m.Stop()
+	}
+	for _, lite := range n.LiteNodes {
+		lite.stop()
+	}
+	for i := len(n.FullNodes) - 1; i >= 0; i-- {
+		n.FullNodes[i].stop()
+	}
+}
+
+// Helper functions
+
+// newWallet creates a wallet for the network's chain in its own temporary
+// directory
+func (n *Network) newWallet() *wallet.Wallet {
+	n.t.Helper()
+
+	w, err := wallet.CreateNew(n.t.TempDir(), "")
+	if err != nil {
+		n.t.Fatalf("failed to create wallet: %v", err)
+	}
+	w.SetChainID(n.config.ChainID)
+	return w
+}
+
+// p2pAddr returns the address full node index listens on for peers
+func p2pAddr(index int) string {
+	return fmt.Sprintf("127.0.0.1:%d", firstP2PPort+index)
+}
@@ -0,0 +1,25 @@
+package testutil
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestNetworkTransfer(t *testing.T) {
+	n := NewNetwork(t, Config{Validators: 3, FullNodes: 1})
+	n.WaitForHeight(3)
+
+	sender := n.AddLiteNode(n.Validators()[0], n.Accounts[0])
+	recipient := n.AddLiteNode(n.FullNodes[3], nil)
+	amount := big.NewInt(12345)
+	receipt := sender.WaitForReceipt(sender.Transfer(recipient.Address(), amount))
+	if !receipt.Success {
+		t.Fatalf("transfer failed: %s", receipt.FailureReason)
+	}
+
+	n.WaitForBalance(recipient.Address(), amount)
+	n.WaitForFinality(receipt.BlockNumber)
+	n.WaitForSync()
+	n.RequireSameChain()
+	n.RequireLiteHeaders(sender)
+}
@@ -0,0 +1,338 @@
+// Package testutil - Full and lite nodes of a test network
+package testutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/chainsync"
+	"chaincore/internal/consensus"
+	"chaincore/internal/liteclient"
+	"chaincore/internal/mining"
+	"chaincore/internal/network"
+	"chaincore/internal/rpc"
+	"chaincore/internal/storage"
+	"chaincore/internal/wallet"
+)
+
+// Node parameters. Rewards and caps are large enough that no test runs into
+// them; shares are cheap to find, so miners spend no noticeable CPU.
+var (
+	validatorStake  = new(big.Int).Mul(big.NewInt(32), big.NewInt(1e18))
+	blockReward     = new(big.Int).Mul(big.NewInt(2), big.NewInt(1e18))
+	miningRewardCap = new(big.Int).Mul(big.NewInt(1e6), big.NewInt(1e18))
+)
+
+// shareDifficulty is the fixed difficulty of mining shares
+const shareDifficulty = 16
+
+// FullNode is a full node of a test network. Its components are exported
+// for tests to inspect and drive directly.
+type FullNode struct {
+	Index  int
+	Chain  *blockchain.Blockchain
+	PoS    *consensus.PoSEngine
+	Mining *mining.Distributor
+	P2P    *network.P2PNetwork
+	Syncer *chainsync.Syncer
+	RPC    *rpc.Server
+	Client *liteclient.Client // Calls the node's RPC server
+	RPCURL string
+
+	validatorKey *ecdsa.PrivateKey // Nil unless the node validates
+	db           *storage.LevelDB
+	stops        []func() // Stop functions of the started components, in start order
+}
+
+// newFullNode creates a full node wired as cmd/fullnode wires it, without
+// starting it
+func newFullNode(n *Network, index int, validator bool, alloc map[[20]byte]*big.Int) (*FullNode, error) {
+	db, err := storage.NewMemoryLevelDB()
+	if err != nil {
+		return nil, err
+	}
+	node := &FullNode{Index: index, db: db}
+	if err := node.init(n, validator, alloc); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return node, nil
+}
+
+// init creates the node's components on its database
+func (f *FullNode) init(n *Network, validator bool, alloc map[[20]byte]*big.Int) error {
+	chain, err := blockchain.NewBlockchain(f.db, blockchain.Config{
+		ChainID:            n.config.ChainID,
+		BlockTime:          1,
+		MaxBlockSize:       2 * 1024 * 1024,
+		MinGasPrice:        1,
+		ValidatorMinStake:  validatorStake,
+		MinShareDifficulty: big.NewInt(shareDifficulty),
+		GenesisAlloc:       alloc,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create blockchain: %w", err)
+	}
+	f.Chain = chain
+
+	f.PoS, err = consensus.NewPoSEngine(chain, consensus.PoSConfig{
+		MinValidators:  1,
+		BlockFinality:  n.config.BlockFinality,
+		RewardPerBlock: blockReward,
+		MinStake:       validatorStake,
+		DevMode:        n.config.DevMode,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create PoS engine: %w", err)
+	}
+	if validator {
+		f.validatorKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return err
+		}
+		f.PoS.AddSigner(consensus.NewLocalSigner(f.validatorKey))
+	}
+
+	f.Mining = mining.NewDistributor(chain, mining.Config{
+		Enabled:            true,
+		TargetShareTime:    1,
+		MaxSharesPerMinute: 600,
+		SessionRewardCap:   miningRewardCap,
+		DailyAddressCap:    miningRewardCap,
+		MinDifficulty:      big.NewInt(shareDifficulty),
+		MaxDifficulty:      big.NewInt(shareDifficulty),
+	})
+
+	p2pConfig := network.Config{
+		Port:         firstP2PPort + f.Index,
+		MaxPeers:     50,
+		NodeType:     network.FullNode,
+		EnableRelay:  true,
+		Capabilities: network.CapServesHistory,
+		Transport:    n.transport,
+	}
+	if f.Index > 0 {
+		p2pConfig.BootstrapNodes = []string{p2pAddr(0)}
+	}
+	f.P2P, err = network.NewP2PNetwork(p2pConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create P2P network: %w", err)
+	}
+
+	// Blocks and votes travel between nodes as in production
+	f.PoS.SetShareSource(f.Mining.TakeBlockShares)
+	f.PoS.SetVoteBroadcaster(func(vote *consensus.Vote) {
+		if err := f.P2P.BroadcastVote(consensus.EncodeVote(vote)); err != nil {
+			log.Printf("Node %d: failed to gossip vote for block %d: %v", f.Index, vote.Height, err)
+		}
+	})
+	if err := f.P2P.RegisterHandler(network.MsgValidatorVote, func(msg *network.Message) error {
+		vote, err := consensus.DecodeVote(msg.Payload)
+		if err != nil {
+			return err
+		}
+		return f.PoS.ReceiveVote(vote)
+	}); err != nil {
+		return fmt.Errorf("failed to register vote handler: %w", err)
+	}
+
+	f.Syncer, err = chainsync.NewSyncer(chain, f.P2P, chainsync.Config{
+		PollInterval:   200 * time.Millisecond,
+		RequestTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create syncer: %w", err)
+	}
+	f.PoS.SetBlockBroadcaster(f.Syncer.AnnounceBlock)
+
+	f.RPC, err = rpc.NewServer(chain, f.PoS, f.Mining, rpc.Config{
+		MaxConnections:     100,
+		EnableWebSocket:    true,
+		EnableMiningAPI:    true,
+		EnableValidatorAPI: true,
+		RateLimitPerSecond: 1000,
+		EnableDevAPI:       n.config.DevMode,
+		WSAllowedOrigins:   []string{"*"},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create RPC server: %w", err)
+	}
+	listener, err := n.transport.Listen("127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	f.RPC.SetListener(listener)
+	f.RPC.SetSyncProgress(f.Syncer.Progress)
+	f.RPC.SetHealth(f.Syncer.Health)
+	f.RPC.SetNetwork(f.P2P)
+	f.RPCURL = "http://" + listener.Addr().String()
+
+	f.Client, err = newClient(n, f.RPCURL)
+	return err
+}
+
+// start starts the node's components in the order cmd/fullnode does
+func (f *FullNode) start() error {
+	if err := f.P2P.Start(); err != nil {
+		return fmt.Errorf("failed to start P2P network: %w", err)
+	}
+	f.stops = append(f.stops, f.P2P.Stop)
+
+	f.Syncer.Start()
+	f.stops = append(f.stops, f.Syncer.Stop)
+
+	if err := f.PoS.Start(); err != nil {
+		return fmt.Errorf("failed to start PoS engine: %w", err)
+	}
+	f.stops = append(f.stops, f.PoS.Stop)
+
+	if err := f.Mining.Start(); err != nil {
+		return fmt.Errorf("failed to start mining distributor: %w", err)
+	}
+	f.stops = append(f.stops, f.Mining.Stop)
+
+	if err := f.RPC.Start(); err != nil {
+		return fmt.Errorf("failed to start RPC server: %w", err)
+	}
+	f.stops = append(f.stops, f.RPC.Stop)
+	return nil
+}
+
+// stop stops the started components in reverse order and closes the
+// database
+func (f *FullNode) stop() {
+	for i := len(f.stops) - 1; i >= 0; i-- {
+		f.stops[i]()
+	}
+	f.stops = nil
+	f.Client.Stop()
+	f.db.Close()
+}
+
+// IsValidator reports whether the node validates
+func (f *FullNode) IsValidator() bool {
+	return f.validatorKey != nil
+}
+
+// ValidatorAddress returns the node's validator address, or the zero
+// address if it does not validate
+func (f *FullNode) ValidatorAddress() [20]byte {
+	return f.PoS.LocalAddress()
+}
+
+// Height returns the height of the node's head block
+func (f *FullNode) Height() uint64 {
+	return f.Chain.GetCurrentBlock().Header.Height
+}
+
+// LiteNode is a lite node of a test network: a lite client with a header
+// store, and a wallet whose transactions it sends
+type LiteNode struct {
+	Client  *liteclient.Client
+	Headers *liteclient.HeaderStore
+	Wallet  *wallet.Wallet
+	Full    *FullNode // The full node it calls
+
+	network *Network
+}
+
+// AddLiteNode starts a lite node that calls full. Its wallet is account,
+// typically one of the network's funded Accounts, or a new empty wallet if
+// account is nil. The wallet takes nonces and gas prices from the lite
+// node, so an account should be used by one lite node at a time.
+func (n *Network) AddLiteNode(full *FullNode, account *wallet.Wallet) *LiteNode {
+	n.t.Helper()
+
+	client, err := newClient(n, full.RPCURL)
+	if err != nil {
+		n.t.Fatalf("lite node: %v", err)
+	}
+	db, err := storage.NewMemoryLevelDB()
+	if err != nil {
+		n.t.Fatalf("lite node: %v", err)
+	}
+	headers, err := liteclient.NewHeaderStore(db, liteclient.DefaultCheckpointInterval)
+	if err != nil {
+		db.Close()
+		n.t.Fatalf("lite node: failed to open header store: %v", err)
+	}
+	client.SetHeaderStore(headers)
+	if err := client.Start(); err != nil {
+		headers.Close()
+		n.t.Fatalf("lite node: failed to reach full node %d: %v", full.Index, err)
+	}
+
+	if account == nil {
+		account = n.newWallet()
+	}
+	account.SetTxParamsSource(client)
+
+	lite := &LiteNode{
+		Client:  client,
+		Headers: headers,
+		Wallet:  account,
+		Full:    full,
+		network: n,
+	}
+	n.LiteNodes = append(n.LiteNodes, lite)
+	return lite
+}
+
+// Address returns the address of the lite node's wallet
+func (l *LiteNode) Address() string {
+	return l.Wallet.Address()
+}
+
+// Transfer sends amount from the lite node's wallet to an address and
+// returns the transaction hash. It fails the test if the full node refuses
+// the transaction.
+func (l *LiteNode) Transfer(to string, amount *big.Int) string {
+	t := l.network.t
+	t.Helper()
+
+	tx, err := l.Wallet.CreateTransaction(to, amount.String(), "")
+	if err != nil {
+		t.Fatalf("failed to create transaction: %v", err)
+	}
+	hash, err := l.Client.SendTransaction(tx)
+	if err != nil {
+		l.Wallet.ResetNonce()
+		t.Fatalf("failed to send transaction: %v", err)
+	}
+	return hash
+}
+
+// SyncHeaders brings the lite node's verified header chain up to the full
+// node's head, failing the test if the headers do not verify
+func (l *LiteNode) SyncHeaders() {
+	t := l.network.t
+	t.Helper()
+
+	if err := l.Client.SyncHeaders(); err != nil {
+		t.Fatalf("lite node failed to sync headers: %v", err)
+	}
+}
+
+// stop closes the lite node's connections and header store
+func (l *LiteNode) stop() {
+	l.Client.Stop()
+	l.Headers.Close()
+}
+
+// Helper functions
+
+// newClient creates a lite client that calls url over the network's
+// transport
+func newClient(n *Network, url string) (*liteclient.Client, error) {
+	return liteclient.NewClient(liteclient.Config{
+		RPCEndpoints:   []string{url},
+		TimeoutSeconds: 10,
+		Transport:      liteclient.TransportConfig{Dial: n.transport.DialContext},
+	}, nil)
+}
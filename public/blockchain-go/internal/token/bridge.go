@@ -0,0 +1,980 @@
+// Package token - Burn-to-mint bridge verified against a foreign chain
+package token
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/storage"
+)
+
+// Bridge networks
+const (
+	NetworkEthereum = "ethereum"
+	NetworkTron     = "tron"
+)
+
+// Bridge defaults and limits
+const (
+	defaultBridgeInterval = 15 * time.Second
+	defaultTokenDecimals  = 6 // USDT's, on both networks
+	bridgeRPCTimeout      = 30 * time.Second
+	bridgeLogRange        = 1000 // Foreign blocks per eth_getLogs request
+	MaxDepositsPerPage    = 500
+)
+
+// defaultConfirmations are the confirmations a deposit needs on each
+// network when the config leaves Confirmations zero: Ethereum's customary
+// twelve, and Tron's nineteen, the depth at which its blocks solidify
+var defaultConfirmations = map[string]uint64{
+	NetworkEthereum: 12,
+	NetworkTron:     19,
+}
+
+// Deposit statuses
+const (
+	DepositPending  = "pending"  // Verified and stored, not yet minted
+	DepositSent     = "sent"     // Mint transaction sent, not yet executed
+	DepositMinted   = "minted"   // Minted to the recipient on chain
+	DepositUnmapped = "unmapped" // Sent by a contract with no mapped recipient; minted once one is mapped
+	DepositFailed   = "failed"   // Refused by the token manager or the chain; tried again when submitted again
+)
+
+// Bridge storage keys. Deposits and their mint transactions are keyed by
+// foreign block number and log index, recipient mappings by foreign
+// address; the progress key holds the next foreign block to scan.
+var (
+	depositKeyPrefix   = []byte("bridge:dep:")
+	mintTxKeyPrefix    = []byte("bridge:mint-tx:")
+	bridgeMapKeyPrefix = []byte("bridge:map:")
+	bridgeProgressKey  = []byte("bridge:next")
+)
+
+// ErrUnverifiedDeposit is returned for a submitted transaction that is not
+// a deposit the bridge can verify, or not yet
+var ErrUnverifiedDeposit = errors.New("deposit not verified")
+
+// tronAddressPrefix is the version byte of Tron addresses
+const tronAddressPrefix = 0x41
+
+// base58Alphabet is the Bitcoin alphabet Tron addresses are encoded in
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// BridgeConfig configures a Bridge
+type BridgeConfig struct {
+	Network        string        // NetworkEthereum or NetworkTron
+	RPCURL         string        // JSON-RPC endpoint of a foreign node
+	Token          string        // Contract address of the bridged token
+	DepositAddress string        // Foreign address deposits are sent to
+	Confirmations  uint64        // Blocks a deposit's block needs, itself included (default per network)
+	StartBlock     uint64        // Foreign block the first scan starts at (default the confirmed head)
+	TokenDecimals  uint8         // Decimals of the bridged token (default 6)
+	Interval       time.Duration // How often the foreign chain is polled (default 15s)
+}
+
+// BridgeDeposit is a verified deposit on the foreign chain, stored with its
+// receipt as proof and keyed by block and log index so it mints once
+type BridgeDeposit struct {
+	Network       string          `json:"network"`
+	TxHash        string          `json:"txHash"`
+	LogIndex      uint64          `json:"logIndex"`
+	BlockNumber   uint64          `json:"blockNumber"`
+	BlockHash     string          `json:"blockHash"`
+	From          string          `json:"from"`   // Foreign sender
+	Amount        string          `json:"amount"` // In the token's units
+	Confirmations uint64          `json:"confirmations"`
+	Proof         json.RawMessage `json:"proof"` // Receipt the deposit was verified against
+	VerifiedAt    int64           `json:"verifiedAt"`
+	Status        string          `json:"status"`
+	Recipient     string          `json:"recipient,omitempty"`
+	Minted        string          `json:"minted,omitempty"`    // Wei
+	Operation     string          `json:"operation,omitempty"` // ID of the mint operation
+	MintTx        string          `json:"mintTx,omitempty"`    // Hash of the mint transaction
+	Error         string          `json:"error,omitempty"`     // Why the mint was refused
+}
+
+// MintSigner signs the bridge's mint transactions with the chain's bridge
+// authority key; *wallet.Wallet is one
+type MintSigner interface {
+	// Address returns the checksummed address mints are sent from
+	Address() string
+	// SignTransaction signs tx for chainID, filling in From, Signature,
+	// Hash and ChainID
+	SignTransaction(tx *blockchain.Transaction, chainID uint64) ([]byte, error)
+}
+
+// Bridge mints for deposits verified on a foreign chain: confirmed Transfer
+// logs to the deposit address, minted by the chain's bridge authority
+type Bridge struct {
+	tokens  *TokenManager
+	chain   *blockchain.Blockchain
+	signer  MintSigner
+	db      storage.Database
+	config  BridgeConfig
+	client  *foreignClient
+	token   [20]byte
+	deposit [20]byte
+	author  [20]byte // The bridge authority, recorded as the author of mint operations
+	next    uint64   // Next foreign block to scan; zero before the first scan
+	stopCh  chan struct{}
+	mu      sync.Mutex // Serializes verification and minting
+}
+
+// NewBridge creates a bridge pricing deposits through tokens and minting
+// them on chain with transactions signer signs, resuming the scan where a
+// previous run stopped. The signer must be the chain's bridge authority.
+func NewBridge(db storage.Database, tokens *TokenManager, chain *blockchain.Blockchain, signer MintSigner, config BridgeConfig) (*Bridge, error) {
+	author, err := blockchain.ParseAddress(signer.Address(), false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bridge signer address: %w", err)
+	}
+	if author != chain.Config().BridgeAuthority {
+		return nil, fmt.Errorf("bridge signer %s is not the chain's bridge authority", signer.Address())
+	}
+
+	if _, known := defaultConfirmations[config.Network]; !known {
+		return nil, fmt.Errorf("unknown bridge network %q", config.Network)
+	}
+	if config.RPCURL == "" {
+		return nil, errors.New("bridge needs a foreign RPC endpoint")
+	}
+	if config.Confirmations == 0 {
+		config.Confirmations = defaultConfirmations[config.Network]
+	}
+	if config.TokenDecimals == 0 {
+		config.TokenDecimals = defaultTokenDecimals
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaultBridgeInterval
+	}
+
+	b := &Bridge{
+		tokens: tokens,
+		chain:  chain,
+		signer: signer,
+		db:     db,
+		config: config,
+		client: &foreignClient{url: config.RPCURL, http: &http.Client{Timeout: bridgeRPCTimeout}},
+		author: author,
+		next:   config.StartBlock,
+		stopCh: make(chan struct{}),
+	}
+	if b.token, err = parseForeignAddress(config.Network, config.Token); err != nil {
+		return nil, fmt.Errorf("invalid bridge token: %w", err)
+	}
+	if b.deposit, err = parseForeignAddress(config.Network, config.DepositAddress); err != nil {
+		return nil, fmt.Errorf("invalid bridge deposit address: %w", err)
+	}
+	if err := b.loadProgress(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Start begins following the foreign chain
+func (b *Bridge) Start() {
+	go b.run()
+}
+
+// Stop ends following the foreign chain
+func (b *Bridge) Stop() {
+	select {
+	case <-b.stopCh:
+	default:
+		close(b.stopCh)
+	}
+}
+
+// Config returns the bridge's configuration, with defaults applied
+func (b *Bridge) Config() BridgeConfig {
+	return b.config
+}
+
+// ScannedBlock returns the last foreign block scanned, zero before the
+// first scan
+func (b *Bridge) ScannedBlock() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.next == 0 {
+		return 0
+	}
+	return b.next - 1
+}
+
+// Deposits returns up to limit stored deposits after skipping offset,
+// newest first, and the number stored
+func (b *Bridge) Deposits(offset, limit uint64) ([]*BridgeDeposit, uint64, error) {
+	if limit == 0 || limit > MaxDepositsPerPage {
+		limit = MaxDepositsPerPage
+	}
+
+	it := b.db.NewIterator(depositKeyPrefix)
+	defer it.Release()
+
+	// Keys sort oldest first; collect them all and page from the end
+	all := make([][]byte, 0)
+	for it.Next() {
+		all = append(all, append([]byte(nil), it.Value()...))
+	}
+	if err := it.Error(); err != nil {
+		return nil, 0, err
+	}
+
+	page := make([]*BridgeDeposit, 0)
+	for i := uint64(len(all)); i > 0 && uint64(len(page)) < limit; i-- {
+		if uint64(len(all))-i < offset {
+			continue
+		}
+		var deposit BridgeDeposit
+		if err := json.Unmarshal(all[i-1], &deposit); err != nil {
+			return nil, 0, err
+		}
+		page = append(page, &deposit)
+	}
+	return page, uint64(len(all)), nil
+}
+
+// SubmitDeposit verifies the deposits in a foreign transaction and mints
+// those not minted yet, without waiting for the scan to reach it. It
+// returns the transaction's deposits, or ErrUnverifiedDeposit if it has
+// none the bridge can verify now.
+func (b *Bridge) SubmitDeposit(ctx context.Context, txHash string) ([]*BridgeDeposit, error) {
+	hash, err := parseHash(txHash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction hash: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	head, err := b.client.blockNumber(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deposits, err := b.processTx(ctx, hash, head)
+	if err != nil {
+		return nil, err
+	}
+	if len(deposits) == 0 {
+		return nil, fmt.Errorf("%w: no transfer to the deposit address", ErrUnverifiedDeposit)
+	}
+	return deposits, nil
+}
+
+// MapRecipient sets the address deposits from a foreign sender mint to,
+// and mints the sender's unmapped deposits to it, returning them
+func (b *Bridge) MapRecipient(foreign string, recipient [20]byte) ([]*BridgeDeposit, error) {
+	sender, err := parseForeignAddress(b.config.Network, foreign)
+	if err != nil {
+		return nil, fmt.Errorf("invalid foreign address: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.db.Put(bridgeMapKey(sender), recipient[:]); err != nil {
+		return nil, err
+	}
+	logger.Info("Bridge recipient mapped", "from", b.formatAddress(sender), "recipient", blockchain.ChecksumAddress(recipient))
+
+	it := b.db.NewIterator(depositKeyPrefix)
+	waiting := make([]*BridgeDeposit, 0)
+	for it.Next() {
+		deposit := new(BridgeDeposit)
+		if err := json.Unmarshal(it.Value(), deposit); err != nil {
+			it.Release()
+			return nil, err
+		}
+		if deposit.Status == DepositUnmapped && deposit.From == b.formatAddress(sender) {
+			waiting = append(waiting, deposit)
+		}
+	}
+	it.Release()
+	if err := it.Error(); err != nil {
+		return nil, err
+	}
+
+	for _, deposit := range waiting {
+		if err := b.mint(deposit, recipient); err != nil {
+			return nil, err
+		}
+	}
+	return waiting, nil
+}
+
+// Helper functions
+
+func (b *Bridge) run() {
+	ticker := time.NewTicker(b.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := b.scan(); err != nil {
+			logger.Warn("Bridge scan stopped", "network", b.config.Network, "block", b.next, "err", err)
+		}
+		select {
+		case <-b.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scan verifies the deposits in foreign blocks confirmed since the last
+// scan, bridgeLogRange blocks at a time. A failed range is scanned again on
+// the next run; deposits already stored are not minted twice.
+func (b *Bridge) scan() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-b.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	head, err := b.client.blockNumber(ctx)
+	if err != nil {
+		return err
+	}
+	if head+1 < b.config.Confirmations {
+		return nil
+	}
+	confirmed := head + 1 - b.config.Confirmations
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.confirmMints(); err != nil {
+		return err
+	}
+	if b.next == 0 {
+		b.next = confirmed
+		logger.Info("Bridge scan starting at the confirmed head", "network", b.config.Network, "block", confirmed)
+	}
+	for b.next <= confirmed {
+		to := min(b.next+bridgeLogRange-1, confirmed)
+		hashes, err := b.client.transferTxs(ctx, b.token, b.deposit, b.next, to)
+		if err != nil {
+			return err
+		}
+		for _, hash := range hashes {
+			if _, err := b.processTx(ctx, hash, head); err != nil {
+				return err
+			}
+		}
+		b.next = to + 1
+		if err := b.saveProgress(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processTx verifies a foreign transaction against the chain's head and
+// records and mints each deposit in it. Callers must hold b.mu.
+func (b *Bridge) processTx(ctx context.Context, hash [32]byte, head uint64) ([]*BridgeDeposit, error) {
+	receipt, raw, err := b.client.receipt(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if receipt == nil {
+		return nil, fmt.Errorf("%w: transaction not found", ErrUnverifiedDeposit)
+	}
+	if status, err := parseQuantity(receipt.Status); err != nil || status != 1 {
+		return nil, fmt.Errorf("%w: transaction failed", ErrUnverifiedDeposit)
+	}
+	number, err := parseQuantity(receipt.BlockNumber)
+	if err != nil {
+		return nil, fmt.Errorf("invalid receipt block number: %w", err)
+	}
+	if number > head || head-number+1 < b.config.Confirmations {
+		return nil, fmt.Errorf("%w: block %d has fewer than %d confirmations", ErrUnverifiedDeposit, number, b.config.Confirmations)
+	}
+	blockHash, err := parseHash(receipt.BlockHash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid receipt block hash: %w", err)
+	}
+	canonical, err := b.client.blockHash(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+	if canonical != blockHash {
+		return nil, fmt.Errorf("%w: receipt is not in the canonical block %d", ErrUnverifiedDeposit, number)
+	}
+
+	deposits := make([]*BridgeDeposit, 0)
+	for _, l := range receipt.Logs {
+		from, amount, ok := b.transferTo(l)
+		if !ok {
+			continue
+		}
+		index, err := parseQuantity(l.LogIndex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log index: %w", err)
+		}
+		deposit := &BridgeDeposit{
+			Network:       b.config.Network,
+			TxHash:        hexString(hash[:]),
+			LogIndex:      index,
+			BlockNumber:   number,
+			BlockHash:     hexString(blockHash[:]),
+			From:          b.formatAddress(from),
+			Amount:        amount.String(),
+			Confirmations: head - number + 1,
+			Proof:         raw,
+			VerifiedAt:    time.Now().Unix(),
+			Status:        DepositPending,
+		}
+		if err := b.record(ctx, deposit, from); err != nil {
+			return nil, err
+		}
+		deposits = append(deposits, deposit)
+	}
+	return deposits, nil
+}
+
+// record stores a verified deposit and mints it, unless it was stored
+// before with a status other than pending or failed, which is then
+// returned in its place. Callers must hold b.mu.
+func (b *Bridge) record(ctx context.Context, deposit *BridgeDeposit, from [20]byte) error {
+	key := depositKey(deposit.BlockNumber, deposit.LogIndex)
+	stored, err := b.loadDeposit(key)
+	switch {
+	case err == nil && (stored.Status == DepositSent || stored.Status == DepositMinted || stored.Status == DepositUnmapped):
+		*deposit = *stored
+		return nil
+	case err != nil && !errors.Is(err, storage.ErrNotFound):
+		return err
+	}
+	if err := b.saveDeposit(deposit); err != nil {
+		return err
+	}
+
+	recipient, mapped, err := b.recipient(ctx, from)
+	if err != nil {
+		return err
+	}
+	if !mapped {
+		deposit.Status = DepositUnmapped
+		logger.Warn("Bridge deposit from a contract waits for a mapped recipient", "tx", deposit.TxHash, "from", deposit.From, "amount", deposit.Amount)
+		return b.saveDeposit(deposit)
+	}
+	return b.mint(deposit, recipient)
+}
+
+// recipient returns the address a sender's deposits mint to, and false for
+// a contract with no mapped recipient. Callers must hold b.mu.
+func (b *Bridge) recipient(ctx context.Context, from [20]byte) ([20]byte, bool, error) {
+	data, err := b.db.Get(bridgeMapKey(from))
+	if err == nil && len(data) == 20 {
+		return [20]byte(data), true, nil
+	}
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return [20]byte{}, false, err
+	}
+	contract, err := b.client.isContract(ctx, from)
+	if err != nil {
+		return [20]byte{}, false, err
+	}
+	return from, !contract, nil
+}
+
+// mint journals a stored deposit's mint to recipient, sends its mint
+// transaction and stores the outcome. A refused mint is recorded as failed
+// rather than returned. Callers must hold b.mu.
+func (b *Bridge) mint(deposit *BridgeDeposit, recipient [20]byte) error {
+	amount, ok := new(big.Int).SetString(deposit.Amount, 10)
+	if !ok {
+		return fmt.Errorf("corrupt bridge deposit amount %q", deposit.Amount)
+	}
+	txHash, err := parseHash(deposit.TxHash)
+	if err != nil {
+		return fmt.Errorf("corrupt bridge deposit hash: %w", err)
+	}
+
+	// The mint transaction is sent once the chain's pool accepts it, and
+	// stored with the deposit in the batch journaling the mint
+	deposit.Recipient = blockchain.ChecksumAddress(recipient)
+	_, err = b.tokens.mintForDeposit(scaleDecimals(amount, b.config.TokenDecimals), recipient, txHash, b.author,
+		func(op *Operation, batch storage.Batch) error {
+			tx, err := b.sendMint(deposit, recipient, op.Amount)
+			if err != nil {
+				return err
+			}
+			deposit.Status = DepositSent
+			deposit.Error = ""
+			deposit.Minted = op.Amount.String()
+			deposit.Operation = hexString(op.ID[:])
+			deposit.MintTx = hexString(tx.Hash[:])
+			data, err := json.Marshal(deposit)
+			if err != nil {
+				return err
+			}
+			if err := batch.Put(depositKey(deposit.BlockNumber, deposit.LogIndex), data); err != nil {
+				return err
+			}
+			encoded, err := json.Marshal(tx)
+			if err != nil {
+				return err
+			}
+			return batch.Put(mintTxKey(deposit.BlockNumber, deposit.LogIndex), encoded)
+		})
+	if err != nil {
+		b.fail(deposit, err)
+		return b.saveDeposit(deposit)
+	}
+	logger.Info("Bridge mint sent", "tx", deposit.TxHash, "amount", deposit.Amount,
+		"recipient", deposit.Recipient, "minted", deposit.Minted, "mintTx", deposit.MintTx)
+	return nil
+}
+
+// sendMint signs a transaction minting amount to recipient for a deposit
+// and adds it to the chain's pool. Callers must hold b.mu.
+func (b *Bridge) sendMint(deposit *BridgeDeposit, recipient [20]byte, amount *big.Int) (*blockchain.Transaction, error) {
+	foreignTx, err := parseHash(deposit.TxHash)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt bridge deposit hash: %w", err)
+	}
+	foreignBlock, err := parseHash(deposit.BlockHash)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt bridge deposit block hash: %w", err)
+	}
+	data := blockchain.EncodeBridgeMint(&blockchain.BridgeMint{
+		ForeignTx:    foreignTx,
+		LogIndex:     deposit.LogIndex,
+		ForeignBlock: foreignBlock,
+		Recipient:    recipient,
+		Amount:       amount,
+	})
+	tx := &blockchain.Transaction{
+		Nonce:    b.chain.GetPendingNonce(b.author),
+		To:       blockchain.BridgeMintAddress,
+		Value:    big.NewInt(0),
+		GasLimit: blockchain.IntrinsicGas(data),
+		GasPrice: b.chain.GasPriceAdvisory().MinGasPrice,
+		Data:     data,
+	}
+	if _, err := b.signer.SignTransaction(tx, b.chain.ChainID()); err != nil {
+		return nil, fmt.Errorf("failed to sign mint: %w", err)
+	}
+	if err := b.chain.AddTransaction(context.Background(), tx); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// confirmMints checks the deposits whose mints were sent: those the chain
+// has minted are stored as minted, a mint whose nonce was spent without
+// minting fails the deposit, and a mint that dropped out of the pool is
+// sent again as it was. Callers must hold b.mu.
+func (b *Bridge) confirmMints() error {
+	it := b.db.NewIterator(depositKeyPrefix)
+	sent := make([]*BridgeDeposit, 0)
+	for it.Next() {
+		deposit := new(BridgeDeposit)
+		if err := json.Unmarshal(it.Value(), deposit); err != nil {
+			it.Release()
+			return err
+		}
+		if deposit.Status == DepositSent {
+			sent = append(sent, deposit)
+		}
+	}
+	it.Release()
+	if err := it.Error(); err != nil {
+		return err
+	}
+
+	nonce := b.chain.GetNonce(b.author)
+	for _, deposit := range sent {
+		if b.chain.BridgeMinted(depositID(deposit)) {
+			deposit.Status = DepositMinted
+			logger.Info("Bridge deposit minted", "tx", deposit.TxHash, "recipient", deposit.Recipient, "minted", deposit.Minted)
+			if err := b.saveDeposit(deposit); err != nil {
+				return err
+			}
+			continue
+		}
+
+		data, err := b.db.Get(mintTxKey(deposit.BlockNumber, deposit.LogIndex))
+		if err != nil {
+			return err
+		}
+		var tx blockchain.Transaction
+		if err := json.Unmarshal(data, &tx); err != nil {
+			return err
+		}
+		if nonce > tx.Nonce {
+			b.fail(deposit, errors.New("mint transaction executed without minting"))
+			if err := b.saveDeposit(deposit); err != nil {
+				return err
+			}
+			continue
+		}
+		if b.chain.GetPendingTransaction(tx.Hash) == nil {
+			if err := b.chain.AddTransaction(context.Background(), &tx); err != nil && !errors.Is(err, blockchain.ErrTxKnown) {
+				logger.Warn("Failed to resend bridge mint", "tx", deposit.TxHash, "mintTx", deposit.MintTx, "err", err)
+			}
+		}
+	}
+	return nil
+}
+
+// fail records why a deposit could not be minted
+func (b *Bridge) fail(deposit *BridgeDeposit, err error) {
+	deposit.Status = DepositFailed
+	deposit.Error = err.Error()
+	deposit.Minted = ""
+	deposit.Operation = ""
+	deposit.MintTx = ""
+	logger.Error("Bridge deposit could not be minted", "tx", deposit.TxHash, "amount", deposit.Amount, "err", err)
+}
+
+// transferTo decodes a log as a Transfer of the bridged token to the
+// deposit address
+func (b *Bridge) transferTo(l foreignLog) (from [20]byte, amount *big.Int, ok bool) {
+	address, err := parseHexAddress(l.Address)
+	if err != nil || address != b.token || l.Removed || len(l.Topics) != 3 {
+		return from, nil, false
+	}
+	topics := make([][32]byte, len(l.Topics))
+	for i, topic := range l.Topics {
+		if topics[i], err = parseHash(topic); err != nil {
+			return from, nil, false
+		}
+	}
+	if topics[0] != blockchain.TopicTransfer || topics[2] != blockchain.AddressTopic(b.deposit) {
+		return from, nil, false
+	}
+	data, err := hex.DecodeString(strings.TrimPrefix(l.Data, "0x"))
+	if err != nil || len(data) != 32 {
+		return from, nil, false
+	}
+	amount = new(big.Int).SetBytes(data)
+	if amount.Sign() == 0 {
+		return from, nil, false
+	}
+	copy(from[:], topics[1][12:])
+	return from, amount, true
+}
+
+// formatAddress encodes a foreign address as the network displays it
+func (b *Bridge) formatAddress(addr [20]byte) string {
+	if b.config.Network == NetworkTron {
+		return encodeTronAddress(addr)
+	}
+	return blockchain.ChecksumAddress(addr)
+}
+
+func (b *Bridge) loadDeposit(key []byte) (*BridgeDeposit, error) {
+	data, err := b.db.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	var deposit BridgeDeposit
+	if err := json.Unmarshal(data, &deposit); err != nil {
+		return nil, err
+	}
+	return &deposit, nil
+}
+
+func (b *Bridge) saveDeposit(deposit *BridgeDeposit) error {
+	data, err := json.Marshal(deposit)
+	if err != nil {
+		return err
+	}
+	return b.db.Put(depositKey(deposit.BlockNumber, deposit.LogIndex), data)
+}
+
+// loadProgress restores the scan position
+func (b *Bridge) loadProgress() error {
+	data, err := b.db.Get(bridgeProgressKey)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return nil
+	case err != nil:
+		return err
+	case len(data) != 8:
+		return errors.New("corrupt bridge progress")
+	}
+	b.next = binary.BigEndian.Uint64(data)
+	return nil
+}
+
+func (b *Bridge) saveProgress() error {
+	return b.db.Put(bridgeProgressKey, binary.BigEndian.AppendUint64(nil, b.next))
+}
+
+func depositKey(block, logIndex uint64) []byte {
+	key := binary.BigEndian.AppendUint64(append([]byte(nil), depositKeyPrefix...), block)
+	return binary.BigEndian.AppendUint64(key, logIndex)
+}
+
+func mintTxKey(block, logIndex uint64) []byte {
+	key := binary.BigEndian.AppendUint64(append([]byte(nil), mintTxKeyPrefix...), block)
+	return binary.BigEndian.AppendUint64(key, logIndex)
+}
+
+// depositID identifies a deposit in the chain's bridge mints
+func depositID(deposit *BridgeDeposit) [32]byte {
+	txHash, _ := parseHash(deposit.TxHash) // Checked before its mint was sent
+	return blockchain.BridgeDepositID(txHash, deposit.LogIndex)
+}
+
+func bridgeMapKey(foreign [20]byte) []byte {
+	return append(append([]byte(nil), bridgeMapKeyPrefix...), foreign[:]...)
+}
+
+// scaleDecimals converts an amount of a token with decimals to 18 decimals
+func scaleDecimals(amount *big.Int, decimals uint8) *big.Int {
+	if decimals > 18 {
+		return new(big.Int).Div(amount, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals-18)), nil))
+	}
+	return new(big.Int).Mul(amount, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(18-decimals)), nil))
+}
+
+// parseForeignAddress parses a configured foreign address: hex on either
+// network, or base58 on Tron
+func parseForeignAddress(network, s string) ([20]byte, error) {
+	if network == NetworkTron && strings.HasPrefix(s, "T") {
+		return decodeTronAddress(s)
+	}
+	return parseHexAddress(s)
+}
+
+// parseHexAddress parses a hex address, allowing Tron's 0x41 prefix
+func parseHexAddress(s string) ([20]byte, error) {
+	var addr [20]byte
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return addr, err
+	}
+	if len(b) == 21 && b[0] == tronAddressPrefix {
+		b = b[1:]
+	}
+	if len(b) != 20 {
+		return addr, fmt.Errorf("address %q is not 20 bytes", s)
+	}
+	copy(addr[:], b)
+	return addr, nil
+}
+
+func parseHash(s string) ([32]byte, error) {
+	var hash [32]byte
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return hash, err
+	}
+	if len(b) != 32 {
+		return hash, fmt.Errorf("hash %q is not 32 bytes", s)
+	}
+	copy(hash[:], b)
+	return hash, nil
+}
+
+func parseQuantity(s string) (uint64, error) {
+	return strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 64)
+}
+
+// decodeTronAddress decodes a base58check Tron address
+func decodeTronAddress(s string) ([20]byte, error) {
+	var addr [20]byte
+	n := new(big.Int)
+	for _, c := range s {
+		digit := strings.IndexRune(base58Alphabet, c)
+		if digit < 0 {
+			return addr, fmt.Errorf("invalid base58 character %q", c)
+		}
+		n.Mul(n, big.NewInt(58))
+		n.Add(n, big.NewInt(int64(digit)))
+	}
+	decoded := n.Bytes()
+	if len(decoded) != 25 || decoded[0] != tronAddressPrefix {
+		return addr, fmt.Errorf("%q is not a Tron address", s)
+	}
+	checksum := sha256.Sum256(decoded[:21])
+	checksum = sha256.Sum256(checksum[:])
+	if !bytes.Equal(checksum[:4], decoded[21:]) {
+		return addr, fmt.Errorf("bad checksum in Tron address %q", s)
+	}
+	copy(addr[:], decoded[1:21])
+	return addr, nil
+}
+
+// encodeTronAddress encodes an address in Tron's base58check form
+func encodeTronAddress(addr [20]byte) string {
+	payload := append([]byte{tronAddressPrefix}, addr[:]...)
+	checksum := sha256.Sum256(payload)
+	checksum = sha256.Sum256(checksum[:])
+	n := new(big.Int).SetBytes(append(payload, checksum[:4]...))
+
+	var encoded []byte
+	mod := new(big.Int)
+	for n.Sign() > 0 {
+		n.DivMod(n, big.NewInt(58), mod)
+		encoded = append(encoded, base58Alphabet[mod.Int64()])
+	}
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+	return string(encoded)
+}
+
+// foreignClient calls a foreign node's Ethereum-style JSON-RPC API
+type foreignClient struct {
+	url  string
+	http *http.Client
+}
+
+// foreignLog is a log as foreign nodes return it
+type foreignLog struct {
+	Address         string   `json:"address"`
+	Topics          []string `json:"topics"`
+	Data            string   `json:"data"`
+	TransactionHash string   `json:"transactionHash"`
+	LogIndex        string   `json:"logIndex"`
+	Removed         bool     `json:"removed"`
+}
+
+// foreignReceipt is the part of a transaction receipt the bridge checks
+type foreignReceipt struct {
+	BlockHash   string       `json:"blockHash"`
+	BlockNumber string       `json:"blockNumber"`
+	Status      string       `json:"status"`
+	Logs        []foreignLog `json:"logs"`
+}
+
+func (c *foreignClient) call(ctx context.Context, method string, params []interface{}, result interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: status %s", method, resp.Status)
+	}
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	if response.Error != nil {
+		return fmt.Errorf("%s: %s", method, response.Error.Message)
+	}
+	if err := json.Unmarshal(response.Result, result); err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	return nil
+}
+
+func (c *foreignClient) blockNumber(ctx context.Context) (uint64, error) {
+	var number string
+	if err := c.call(ctx, "eth_blockNumber", nil, &number); err != nil {
+		return 0, err
+	}
+	return parseQuantity(number)
+}
+
+func (c *foreignClient) blockHash(ctx context.Context, number uint64) ([32]byte, error) {
+	var block *struct {
+		Hash string `json:"hash"`
+	}
+	if err := c.call(ctx, "eth_getBlockByNumber", []interface{}{"0x" + strconv.FormatUint(number, 16), false}, &block); err != nil {
+		return [32]byte{}, err
+	}
+	if block == nil {
+		return [32]byte{}, fmt.Errorf("foreign block %d not found", number)
+	}
+	return parseHash(block.Hash)
+}
+
+// receipt returns a transaction's receipt and its JSON, or nil if the
+// transaction is unknown
+func (c *foreignClient) receipt(ctx context.Context, hash [32]byte) (*foreignReceipt, json.RawMessage, error) {
+	var raw json.RawMessage
+	if err := c.call(ctx, "eth_getTransactionReceipt", []interface{}{hexString(hash[:])}, &raw); err != nil {
+		return nil, nil, err
+	}
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil, nil
+	}
+	receipt := new(foreignReceipt)
+	if err := json.Unmarshal(raw, receipt); err != nil {
+		return nil, nil, fmt.Errorf("invalid receipt: %w", err)
+	}
+	return receipt, raw, nil
+}
+
+// transferTxs returns the transactions with Transfer logs of token to
+// address in a block range, in block order
+func (c *foreignClient) transferTxs(ctx context.Context, token, address [20]byte, from, to uint64) ([][32]byte, error) {
+	transfer := blockchain.TopicTransfer
+	recipient := blockchain.AddressTopic(address)
+	filter := map[string]interface{}{
+		"fromBlock": "0x" + strconv.FormatUint(from, 16),
+		"toBlock":   "0x" + strconv.FormatUint(to, 16),
+		"address":   hexString(token[:]),
+		"topics":    []interface{}{hexString(transfer[:]), nil, hexString(recipient[:])},
+	}
+	var logs []foreignLog
+	if err := c.call(ctx, "eth_getLogs", []interface{}{filter}, &logs); err != nil {
+		return nil, err
+	}
+
+	hashes := make([][32]byte, 0)
+	seen := make(map[[32]byte]bool)
+	for _, l := range logs {
+		hash, err := parseHash(l.TransactionHash)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log transaction hash: %w", err)
+		}
+		if !seen[hash] {
+			seen[hash] = true
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes, nil
+}
+
+// isContract reports whether an address holds code
+func (c *foreignClient) isContract(ctx context.Context, addr [20]byte) (bool, error) {
+	var code string
+	if err := c.call(ctx, "eth_getCode", []interface{}{hexString(addr[:]), "latest"}, &code); err != nil {
+		return false, err
+	}
+	return strings.TrimPrefix(code, "0x") != "", nil
+}
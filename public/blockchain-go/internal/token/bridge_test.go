@@ -0,0 +1,180 @@
+package token
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/genesis"
+	"chaincore/internal/storage"
+	"chaincore/internal/wallet"
+)
+
+// foreignNode serves the JSON-RPC methods the bridge calls, for a chain
+// with one confirmed USDT deposit
+type foreignNode struct {
+	head    uint64
+	receipt map[string]interface{}
+	log     map[string]interface{}
+}
+
+func (f *foreignNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Method string
+		Params []json.RawMessage
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	var result interface{}
+	switch req.Method {
+	case "eth_blockNumber":
+		result = fmt.Sprintf("0x%x", f.head)
+	case "eth_getLogs":
+		result = []interface{}{f.log}
+	case "eth_getTransactionReceipt":
+		result = f.receipt
+	case "eth_getBlockByNumber":
+		result = map[string]interface{}{"hash": f.receipt["blockHash"]}
+	case "eth_getCode":
+		result = "0x"
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "result": result})
+}
+
+func TestBridgeMintsOnChain(t *testing.T) {
+	usdt, depositAddr, sender := [20]byte{1}, [20]byte{2}, [20]byte{3}
+	word := func(b []byte) string { return "0x" + hex.EncodeToString(b) }
+	topic, from, to := blockchain.TopicTransfer, blockchain.AddressTopic(sender), blockchain.AddressTopic(depositAddr)
+	txHash := "0x" + strings.Repeat("a1", 32)
+	log := map[string]interface{}{
+		"address":         word(usdt[:]),
+		"topics":          []string{word(topic[:]), word(from[:]), word(to[:])},
+		"data":            word(big.NewInt(2_000_000).FillBytes(make([]byte, 32))), // 2 USDT
+		"transactionHash": txHash,
+		"logIndex":        "0x0",
+	}
+	foreign := &foreignNode{head: 100, log: log, receipt: map[string]interface{}{
+		"blockHash":   "0x" + strings.Repeat("32", 32),
+		"blockNumber": "0x32",
+		"status":      "0x1",
+		"logs":        []interface{}{log},
+	}}
+	server := httptest.NewServer(foreign)
+	defer server.Close()
+
+	signer, err := wallet.CreateNew(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := wallet.CreateNew(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authority, _ := blockchain.ParseAddress(signer.Address(), false)
+	otherAddr, _ := blockchain.ParseAddress(other.Address(), false)
+	db, _ := storage.NewMemoryLevelDB()
+	defer db.Close()
+	chain, err := blockchain.NewBlockchain(db, blockchain.Config{
+		ChainID:           1,
+		MinGasPrice:       1,
+		ValidatorMinStake: big.NewInt(1),
+		BridgeAuthority:   authority,
+		GenesisAlloc:      map[[20]byte]*big.Int{authority: big.NewInt(1e18), otherAddr: big.NewInt(1e18)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := NewTokenManager(db, genesis.DefaultGenesisConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens.SetPrice(big.NewFloat(0.5))
+	bridge, err := NewBridge(db, tokens, chain, signer, BridgeConfig{
+		Network:        NetworkEthereum,
+		RPCURL:         server.URL,
+		Token:          word(usdt[:]),
+		DepositAddress: word(depositAddr[:]),
+		StartBlock:     40,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The deposit's mint is sent to the pool, and nothing is credited yet
+	if err := bridge.scan(); err != nil {
+		t.Fatal(err)
+	}
+	deposits, _, _ := bridge.Deposits(0, 1)
+	if len(deposits) != 1 || deposits[0].Status != DepositSent || deposits[0].MintTx == "" {
+		t.Fatalf("deposit after scan: %+v", deposits)
+	}
+	if balance := chain.GetBalance(sender); balance.Sign() != 0 {
+		t.Fatalf("credited before any block: %s", balance)
+	}
+	supply := chain.GetSupply().Total
+
+	// Every node executing the block credits the mint
+	head := chain.GetCurrentBlock()
+	block := &blockchain.Block{Header: blockchain.BlockHeader{
+		Height:    head.Header.Height + 1,
+		PrevHash:  head.Hash(),
+		Timestamp: head.Header.Timestamp + 12,
+		GasLimit:  30_000_000,
+	}}
+	if err := chain.FillBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	if len(block.Transactions) != 1 {
+		t.Fatalf("block has %d transactions", len(block.Transactions))
+	}
+	if err := chain.InsertBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	minted, _ := new(big.Int).SetString("4000000000000000000", 10)
+	if balance := chain.GetBalance(sender); balance.Cmp(minted) != 0 {
+		t.Fatalf("recipient balance %s, want %s", balance, minted)
+	}
+	if total := chain.GetSupply().Total; new(big.Int).Sub(total, supply).Cmp(minted) != 0 {
+		t.Fatalf("supply grew from %s to %s", supply, total)
+	}
+
+	// The next scan confirms the deposit without minting it again
+	if err := bridge.scan(); err != nil {
+		t.Fatal(err)
+	}
+	deposits, _, _ = bridge.Deposits(0, 1)
+	if deposits[0].Status != DepositMinted {
+		t.Fatalf("deposit after its block: %+v", deposits[0])
+	}
+	if pending := chain.GetPendingNonce(authority); pending != 1 {
+		t.Fatalf("authority pending nonce %d", pending)
+	}
+
+	// The chain records the foreign block each deposit was minted for
+	deposit := deposits[0]
+	recorded, err := chain.GetStorageAt(blockchain.BridgeMintAddress, depositID(deposit), chain.GetCurrentBlock().Header.Height)
+	if err != nil || hexString(recorded[:]) != deposit.BlockHash {
+		t.Fatalf("recorded foreign block %x, want %s: %v", recorded, deposit.BlockHash, err)
+	}
+
+	// A deposit mints once, even if reported in another foreign block, and
+	// only the authority mints
+	if _, err := bridge.sendMint(deposit, sender, minted); !errors.Is(err, blockchain.ErrInvalidBridgeMint) {
+		t.Fatalf("second mint of a deposit: %v", err)
+	}
+	deposit.BlockHash = "0x" + strings.Repeat("33", 32)
+	if _, err := bridge.sendMint(deposit, sender, minted); !errors.Is(err, blockchain.ErrInvalidBridgeMint) {
+		t.Fatalf("mint of a deposit for another block: %v", err)
+	}
+	bridge.signer, bridge.author = other, otherAddr
+	deposit.LogIndex++
+	if _, err := bridge.sendMint(deposit, sender, minted); !errors.Is(err, blockchain.ErrInvalidBridgeMint) {
+		t.Fatalf("mint by another account: %v", err)
+	}
+}
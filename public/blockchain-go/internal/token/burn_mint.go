@@ -3,13 +3,16 @@ package token
 
 import (
 	"crypto/sha256"
-	"encoding/hex"
+	"encoding/binary"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"math/big"
 	"sync"
 	"time"
 
 	"chaincore/internal/genesis"
+	"chaincore/internal/storage"
 )
 
 // OperationType defines the type of token operation
@@ -20,12 +23,23 @@ const (
 	Mint
 )
 
-// Operation represents a burn or mint operation
+// Token storage keys. Operations are keyed by journal index, and indexed
+// by wallet and by type with empty entries ending in the journal index.
+var (
+	operationKeyPrefix       = []byte("token:op:")
+	operationWalletKeyPrefix = []byte("token:op-wallet:")
+	operationTypeKeyPrefix   = []byte("token:op-type:")
+	tokenPriceKey            = []byte("token:price")
+)
+
+// Operation represents a burn or mint operation, an entry of the
+// append-only journal the supply counters are replayed from
 type Operation struct {
+	Index         uint64 // Position in the journal, from 1
 	ID            [32]byte
 	Type          OperationType
 	Amount        *big.Int
-	USDTAmount    *big.Int // For burn-to-mint
+	USDTAmount    *big.Int // For burn-to-mint, in 18-decimal units
 	WalletAddress [20]byte
 	TxHash        [32]byte
 	CreatedBy     [20]byte
@@ -33,76 +47,105 @@ type Operation struct {
 	Status        string // pending, confirmed, failed
 }
 
+// OperationFilter selects journal entries. Zero values match all.
+type OperationFilter struct {
+	Type   *OperationType
+	Wallet *[20]byte
+	From   time.Time
+	To     time.Time
+}
+
 // TokenManager handles all token operations
 type TokenManager struct {
+	db             storage.Database
 	config         *genesis.GenesisConfig
 	currentPrice   *big.Float
 	totalSupply    *big.Int
 	circulatingSupply *big.Int
-	burnedTotal    *big.Int
-	operations     []Operation
+	burnedTotal    *big.Int // GYDS burned
+	usdtBurned     *big.Int // USDT deposited through the bridge, in 18-decimal units
+	operations     uint64   // Journal length
 	mu             sync.RWMutex
 }
 
-// NewTokenManager creates a new token manager
-func NewTokenManager(config *genesis.GenesisConfig) *TokenManager {
-	return &TokenManager{
+// NewTokenManager creates a token manager journaling to db, recovering
+// the operations, supply counters and price of previous runs
+func NewTokenManager(db storage.Database, config *genesis.GenesisConfig) (*TokenManager, error) {
+	tm := &TokenManager{
+		db:                db,
 		config:            config,
 		currentPrice:      big.NewFloat(config.InitialPrice),
 		totalSupply:       new(big.Int).Set(config.InitialSupply),
 		circulatingSupply: big.NewInt(0),
 		burnedTotal:       big.NewInt(0),
-		operations:        make([]Operation, 0),
+		usdtBurned:        big.NewInt(0),
 	}
+	if err := tm.load(); err != nil {
+		return nil, err
+	}
+	return tm, nil
 }
 
-// BurnUSDTForMint burns USDT and mints equivalent GYDS
-func (tm *TokenManager) BurnUSDTForMint(usdtAmount *big.Int, recipientAddress [20]byte, createdBy [20]byte) (*Operation, *big.Int, error) {
+// mintForDeposit records a bridge deposit of usdtAmount, in 18-decimal
+// units, as burned and mints its value in GYDS at the current price to the
+// recipient. Both operations carry the foreign transaction's hash. Only the
+// bridge calls it, once it has verified the deposit; onMint adds the
+// deposit's new status to the batch journaling the operations.
+func (tm *TokenManager) mintForDeposit(usdtAmount *big.Int, recipientAddress [20]byte, foreignTx [32]byte, createdBy [20]byte, onMint func(op *Operation, batch storage.Batch) error) (*Operation, error) {
 	tm.mu.Lock()
 	defer tm.mu.Unlock()
 
 	if usdtAmount.Cmp(big.NewInt(0)) <= 0 {
-		return nil, nil, errors.New("amount must be positive")
+		return nil, errors.New("amount must be positive")
 	}
 
 	// Calculate GYDS to mint: amount / price
 	usdtFloat := new(big.Float).SetInt(usdtAmount)
 	gydsFloat := new(big.Float).Quo(usdtFloat, tm.currentPrice)
-	
+
 	gydsToMint := new(big.Int)
 	gydsFloat.Int(gydsToMint)
+	if gydsToMint.Sign() <= 0 {
+		return nil, errors.New("deposit too small to mint")
+	}
+
+	// Check against max supply
+	newCirculating := new(big.Int).Add(tm.circulatingSupply, gydsToMint)
+	if newCirculating.Cmp(tm.totalSupply) > 0 {
+		return nil, errors.New("would exceed max supply")
+	}
 
 	// Create burn operation
 	burnOp := Operation{
-		ID:            tm.generateOperationID(),
 		Type:          Burn,
 		Amount:        new(big.Int).Set(usdtAmount),
 		USDTAmount:    new(big.Int).Set(usdtAmount),
 		WalletAddress: genesis.BurnAddress(),
-		TxHash:        tm.generateTxHash(),
+		TxHash:        foreignTx,
 		CreatedBy:     createdBy,
 		CreatedAt:     time.Now(),
 		Status:        "confirmed",
 	}
-	tm.operations = append(tm.operations, burnOp)
-	tm.burnedTotal.Add(tm.burnedTotal, usdtAmount)
 
 	// Create mint operation
 	mintOp := Operation{
-		ID:            tm.generateOperationID(),
 		Type:          Mint,
 		Amount:        gydsToMint,
 		USDTAmount:    new(big.Int).Set(usdtAmount),
 		WalletAddress: recipientAddress,
-		TxHash:        tm.generateTxHash(),
+		TxHash:        foreignTx,
 		CreatedBy:     createdBy,
 		CreatedAt:     time.Now(),
 		Status:        "confirmed",
 	}
-	tm.operations = append(tm.operations, mintOp)
-	tm.circulatingSupply.Add(tm.circulatingSupply, gydsToMint)
 
-	return &mintOp, gydsToMint, nil
+	err := tm.journal(func(batch storage.Batch) error {
+		return onMint(&mintOp, batch)
+	}, &burnOp, &mintOp)
+	if err != nil {
+		return nil, err
+	}
+	return &mintOp, nil
 }
 
 // DirectMint mints tokens to an address (admin only)
@@ -121,7 +164,6 @@ func (tm *TokenManager) DirectMint(amount *big.Int, recipientAddress [20]byte, c
 	}
 
 	op := Operation{
-		ID:            tm.generateOperationID(),
 		Type:          Mint,
 		Amount:        new(big.Int).Set(amount),
 		USDTAmount:    big.NewInt(0),
@@ -132,9 +174,9 @@ func (tm *TokenManager) DirectMint(amount *big.Int, recipientAddress [20]byte, c
 		Status:        "confirmed",
 	}
 
-	tm.operations = append(tm.operations, op)
-	tm.circulatingSupply.Add(tm.circulatingSupply, amount)
-
+	if err := tm.journal(nil, &op); err != nil {
+		return nil, err
+	}
 	return &op, nil
 }
 
@@ -148,7 +190,6 @@ func (tm *TokenManager) BurnTokens(amount *big.Int, fromAddress [20]byte, create
 	}
 
 	op := Operation{
-		ID:            tm.generateOperationID(),
 		Type:          Burn,
 		Amount:        new(big.Int).Set(amount),
 		WalletAddress: fromAddress,
@@ -158,9 +199,9 @@ func (tm *TokenManager) BurnTokens(amount *big.Int, fromAddress [20]byte, create
 		Status:        "confirmed",
 	}
 
-	tm.operations = append(tm.operations, op)
-	tm.circulatingSupply.Sub(tm.circulatingSupply, amount)
-
+	if err := tm.journal(nil, &op); err != nil {
+		return nil, err
+	}
 	return &op, nil
 }
 
@@ -180,6 +221,13 @@ func (tm *TokenManager) SetPrice(newPrice *big.Float) error {
 		return errors.New("price must be positive")
 	}
 
+	data, err := newPrice.GobEncode()
+	if err != nil {
+		return err
+	}
+	if err := tm.db.Put(tokenPriceKey, data); err != nil {
+		return err
+	}
 	tm.currentPrice = new(big.Float).Set(newPrice)
 	return nil
 }
@@ -195,33 +243,218 @@ func (tm *TokenManager) GetStats() (totalSupply, circulatingSupply, burnedTotal
 		new(big.Float).Set(tm.currentPrice)
 }
 
-// GetOperations returns recent operations
-func (tm *TokenManager) GetOperations(limit int) []Operation {
+// GetUSDTBurned returns the USDT deposited through the bridge, in
+// 18-decimal units
+func (tm *TokenManager) GetUSDTBurned() *big.Int {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	return new(big.Int).Set(tm.usdtBurned)
+}
+
+// GetOperations returns up to limit journal entries matching the filter
+// after skipping offset of them, oldest first, and the number matching.
+// Entries are read from the database: by index when unfiltered, otherwise
+// by walking the wallet or type index, or the journal for time filters
+// alone, and reading only the entries that must be checked or returned.
+func (tm *TokenManager) GetOperations(filter OperationFilter, offset, limit int) ([]Operation, int, error) {
 	tm.mu.RLock()
 	defer tm.mu.RUnlock()
 
-	if limit <= 0 || limit > len(tm.operations) {
-		limit = len(tm.operations)
+	result := make([]Operation, 0)
+	timed := !filter.From.IsZero() || !filter.To.IsZero()
+	if filter.Type == nil && filter.Wallet == nil && !timed {
+		for index := uint64(offset) + 1; index <= tm.operations && len(result) < limit; index++ {
+			op, err := tm.loadOperation(index)
+			if err != nil {
+				return nil, 0, err
+			}
+			result = append(result, *op)
+		}
+		return result, int(tm.operations), nil
 	}
 
-	// Return most recent operations
-	start := len(tm.operations) - limit
-	if start < 0 {
-		start = 0
+	prefix := operationKeyPrefix
+	switch {
+	case filter.Wallet != nil:
+		prefix = operationWalletKey(*filter.Wallet, 0)
+	case filter.Type != nil:
+		prefix = operationTypeKey(*filter.Type, 0)
+	}
+	prefix = prefix[:len(prefix)-8]
+	// Entries the index selects match unless other filters remain
+	checked := timed || (filter.Wallet != nil && filter.Type != nil)
+
+	it := tm.db.NewIterator(prefix)
+	defer it.Release()
+	matched := 0
+	for it.Next() {
+		if !checked && (matched < offset || len(result) >= limit) {
+			matched++
+			continue
+		}
+		op, err := tm.loadOperation(binary.BigEndian.Uint64(it.Key()[len(prefix):]))
+		if err != nil {
+			return nil, 0, err
+		}
+		if !filter.matches(op) {
+			continue
+		}
+		if matched >= offset && len(result) < limit {
+			result = append(result, *op)
+		}
+		matched++
+	}
+	if err := it.Error(); err != nil {
+		return nil, 0, err
 	}
+	return result, matched, nil
+}
 
-	result := make([]Operation, limit)
-	copy(result, tm.operations[start:])
-	return result
+// Helper functions
+
+// journal writes operations to the journal, in one batch with whatever
+// extra adds to it, and then applies them. Callers must hold tm.mu.
+func (tm *TokenManager) journal(extra func(batch storage.Batch) error, ops ...*Operation) error {
+	batch := tm.db.NewBatch()
+	for i, op := range ops {
+		op.Index = tm.operations + uint64(i) + 1
+		op.ID = operationID(op)
+		data, err := json.Marshal(op)
+		if err != nil {
+			return err
+		}
+		if err := batch.Put(operationKey(op.Index), data); err != nil {
+			return err
+		}
+		if err := batch.Put(operationWalletKey(op.WalletAddress, op.Index), nil); err != nil {
+			return err
+		}
+		if err := batch.Put(operationTypeKey(op.Type, op.Index), nil); err != nil {
+			return err
+		}
+	}
+	if extra != nil {
+		if err := extra(batch); err != nil {
+			return err
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return fmt.Errorf("failed to journal token operation: %w", err)
+	}
+
+	for _, op := range ops {
+		tm.apply(op)
+	}
+	tm.operations += uint64(len(ops))
+	return nil
 }
 
-func (tm *TokenManager) generateOperationID() [32]byte {
-	data := make([]byte, 40)
-	copy(data[:8], big.NewInt(time.Now().UnixNano()).Bytes())
-	copy(data[8:], []byte(hex.EncodeToString(tm.burnedTotal.Bytes())))
+// apply updates the supply counters for an operation. Callers must hold
+// tm.mu.
+func (tm *TokenManager) apply(op *Operation) {
+	switch {
+	case op.Type == Mint:
+		tm.circulatingSupply.Add(tm.circulatingSupply, op.Amount)
+	case op.USDTAmount != nil && op.USDTAmount.Sign() > 0:
+		// USDT taken in for a bridge mint
+		tm.usdtBurned.Add(tm.usdtBurned, op.Amount)
+	default:
+		tm.circulatingSupply.Sub(tm.circulatingSupply, op.Amount)
+		tm.burnedTotal.Add(tm.burnedTotal, op.Amount)
+	}
+}
+
+// load restores the price and replays the journal
+func (tm *TokenManager) load() error {
+	data, err := tm.db.Get(tokenPriceKey)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+	case err != nil:
+		return err
+	default:
+		price := new(big.Float)
+		if err := price.GobDecode(data); err != nil {
+			return fmt.Errorf("corrupt token price: %w", err)
+		}
+		tm.currentPrice = price
+	}
+
+	it := tm.db.NewIterator(operationKeyPrefix)
+	defer it.Release()
+	for it.Next() {
+		var op Operation
+		if err := json.Unmarshal(it.Value(), &op); err != nil {
+			return fmt.Errorf("corrupt token operation: %w", err)
+		}
+		if op.Index != tm.operations+1 || op.ID != operationID(&op) {
+			return fmt.Errorf("token operation journal is inconsistent at entry %d", tm.operations+1)
+		}
+		tm.apply(&op)
+		tm.operations++
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	if tm.operations > 0 {
+		logger.Info("Token operations replayed", "operations", tm.operations,
+			"circulating", tm.circulatingSupply.String(), "burned", tm.burnedTotal.String(), "usdtBurned", tm.usdtBurned.String())
+	}
+	return nil
+}
+
+// operationID hashes an operation's contents, its index included
+func operationID(op *Operation) [32]byte {
+	data := binary.BigEndian.AppendUint64(nil, op.Index)
+	data = append(data, byte(op.Type))
+	data = append(data, op.WalletAddress[:]...)
+	data = append(data, op.TxHash[:]...)
+	data = append(data, op.CreatedBy[:]...)
+	data = binary.BigEndian.AppendUint64(data, uint64(op.CreatedAt.UnixNano()))
+	data = append(data, op.Amount.Bytes()...)
 	return sha256.Sum256(data)
 }
 
+// loadOperation reads a journal entry. Callers must hold tm.mu.
+func (tm *TokenManager) loadOperation(index uint64) (*Operation, error) {
+	data, err := tm.db.Get(operationKey(index))
+	if err != nil {
+		return nil, fmt.Errorf("token operation %d: %w", index, err)
+	}
+	var op Operation
+	if err := json.Unmarshal(data, &op); err != nil {
+		return nil, fmt.Errorf("corrupt token operation %d: %w", index, err)
+	}
+	return &op, nil
+}
+
+// matches reports whether an operation passes the filter
+func (f *OperationFilter) matches(op *Operation) bool {
+	if f.Type != nil && op.Type != *f.Type {
+		return false
+	}
+	if f.Wallet != nil && op.WalletAddress != *f.Wallet {
+		return false
+	}
+	if !f.From.IsZero() && op.CreatedAt.Before(f.From) {
+		return false
+	}
+	return f.To.IsZero() || op.CreatedAt.Before(f.To)
+}
+
+func operationKey(index uint64) []byte {
+	return binary.BigEndian.AppendUint64(append([]byte(nil), operationKeyPrefix...), index)
+}
+
+func operationWalletKey(wallet [20]byte, index uint64) []byte {
+	key := append(append([]byte(nil), operationWalletKeyPrefix...), wallet[:]...)
+	return binary.BigEndian.AppendUint64(key, index)
+}
+
+func operationTypeKey(kind OperationType, index uint64) []byte {
+	key := append(append([]byte(nil), operationTypeKeyPrefix...), byte(kind))
+	return binary.BigEndian.AppendUint64(key, index)
+}
+
 func (tm *TokenManager) generateTxHash() [32]byte {
 	data := make([]byte, 64)
 	copy(data[:8], big.NewInt(time.Now().UnixNano()).Bytes())
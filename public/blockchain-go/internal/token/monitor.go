@@ -0,0 +1,604 @@
+// Package token - Reserved wallet activity monitoring
+package token
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/genesis"
+	"chaincore/internal/logging"
+	"chaincore/internal/storage"
+)
+
+// logger is the token module's logger
+var logger = logging.New("token")
+
+// Monitor defaults and limits
+const (
+	defaultMonitorInterval = 5 * time.Second
+	webhookTimeout         = 10 * time.Second
+	webhookAttempts        = 3
+	reorgRescanDepth       = 256 // Blocks scanned again after a reorganization
+	MaxMovementsPerPage    = 500
+)
+
+// Vesting check results recorded with each movement
+const (
+	VestingOK        = "ok"        // The balance after the block covers the locked amount
+	VestingViolated  = "violated"  // The balance after the block is below the locked amount
+	VestingBlocked   = "blocked"   // The chain rejected the transaction for spending unvested funds
+	VestingUnchecked = "unchecked" // The state at the block is no longer available
+)
+
+// Monitor event types delivered to webhooks
+const (
+	EventMovement = "reserved_wallet_movement"
+	EventReverted = "reserved_wallet_movement_reverted"
+)
+
+// Monitor storage keys. Movements are keyed by block height and transaction
+// index; the progress key holds the next height to scan and the hash of the
+// block before it.
+var (
+	movementKeyPrefix  = []byte("reserved:mv:")
+	monitorProgressKey = []byte("reserved:head")
+)
+
+// MonitorConfig configures a ReservedWalletMonitor
+type MonitorConfig struct {
+	Wallets       []string      // Reserved wallet names to watch (default: every wallet with vesting)
+	Interval      time.Duration // How often the chain is checked for new blocks (default 5s)
+	WebhookURLs   []string      // Endpoints every event is POSTed to
+	WebhookSecret string        // Signs webhook bodies with HMAC-SHA256 when set
+}
+
+// ReservedMovement is a transaction sent from a watched reserved wallet
+type ReservedMovement struct {
+	Wallet        string `json:"wallet"`
+	From          string `json:"from"`
+	To            string `json:"to"`
+	Value         string `json:"value"` // Wei
+	TxHash        string `json:"txHash"`
+	TxIndex       uint64 `json:"txIndex"`
+	BlockHeight   uint64 `json:"blockHeight"`
+	BlockHash     string `json:"blockHash"`
+	Timestamp     uint64 `json:"timestamp"`
+	Success       bool   `json:"success"`
+	FailureReason string `json:"failureReason,omitempty"`
+	Locked        string `json:"locked"`                 // Unvested amount at the block
+	BalanceAfter  string `json:"balanceAfter,omitempty"` // Empty when unchecked
+	Vesting       string `json:"vesting"`
+	Alert         bool   `json:"alert"` // Vesting violated or blocked
+}
+
+// MonitorEvent is the body POSTed to webhooks
+type MonitorEvent struct {
+	Type     string            `json:"type"`
+	Movement *ReservedMovement `json:"movement"`
+}
+
+// ReservedWalletStatus summarizes a watched wallet for the transparency
+// report
+type ReservedWalletStatus struct {
+	Name          string `json:"name"`
+	Address       string `json:"address"`
+	Allocation    string `json:"allocation"`
+	VestingMonths uint32 `json:"vestingMonths"`
+	Locked        string `json:"locked"` // At the head block
+	Balance       string `json:"balance"`
+}
+
+// TransparencyReport lists the watched wallets and a page of their
+// movements, newest first
+type TransparencyReport struct {
+	Wallets        []ReservedWalletStatus `json:"wallets"`
+	Movements      []*ReservedMovement    `json:"movements"`
+	TotalMovements uint64                 `json:"totalMovements"`
+	Offset         uint64                 `json:"offset"`
+	ScannedHeight  uint64                 `json:"scannedHeight"`
+}
+
+// ReservedWalletMonitor follows the chain and records every transaction
+// sent from a watched reserved wallet. Each movement is checked against the
+// wallet's vesting schedule, logged, stored for the transparency report and
+// POSTed to the configured webhooks. Transactions the chain rejected for
+// spending unvested funds are recorded too and raise an alert, as does a
+// balance found below the locked amount.
+//
+// The balance check needs the state after the movement's block: archive
+// nodes keep it, other nodes only while the block is the head, so movements
+// found while catching up are left unchecked there.
+type ReservedWalletMonitor struct {
+	chain     *blockchain.Blockchain
+	db        storage.Database
+	vesting   *Vesting
+	config    MonitorConfig
+	wallets   []genesis.ReservedWallet
+	watched   map[[20]byte]*genesis.ReservedWallet
+	next      uint64   // Next height to scan
+	lastHash  [32]byte // Hash of the block at next-1
+	total     uint64
+	client    *http.Client
+	webhookCh chan []byte
+	stopCh    chan struct{}
+	mu        sync.RWMutex
+}
+
+// NewReservedWalletMonitor creates a monitor for the reserved wallets of a
+// genesis configuration, resuming from where a previous run stopped
+func NewReservedWalletMonitor(chain *blockchain.Blockchain, config *genesis.GenesisConfig, monitorConfig MonitorConfig) (*ReservedWalletMonitor, error) {
+	if monitorConfig.Interval <= 0 {
+		monitorConfig.Interval = defaultMonitorInterval
+	}
+
+	m := &ReservedWalletMonitor{
+		chain:     chain,
+		db:        chain.Database(),
+		vesting:   NewVesting(config),
+		config:    monitorConfig,
+		watched:   make(map[[20]byte]*genesis.ReservedWallet),
+		next:      1,
+		client:    &http.Client{Timeout: webhookTimeout},
+		webhookCh: make(chan []byte, 256),
+		stopCh:    make(chan struct{}),
+	}
+
+	names := make(map[string]bool, len(monitorConfig.Wallets))
+	for _, name := range monitorConfig.Wallets {
+		names[name] = true
+	}
+	for _, wallet := range config.ReservedWallets {
+		if (len(names) == 0 && wallet.VestingMonths > 0) || names[wallet.Name] {
+			m.wallets = append(m.wallets, wallet)
+			delete(names, wallet.Name)
+		}
+	}
+	for name := range names {
+		return nil, fmt.Errorf("unknown reserved wallet %q", name)
+	}
+	for i := range m.wallets {
+		m.watched[m.wallets[i].Address] = &m.wallets[i]
+	}
+
+	if err := m.loadProgress(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Start begins following the chain and delivering webhooks
+func (m *ReservedWalletMonitor) Start() {
+	go m.run()
+	if len(m.config.WebhookURLs) > 0 {
+		go m.deliverWebhooks()
+	}
+}
+
+// Stop ends monitoring. Webhooks not yet delivered are dropped.
+func (m *ReservedWalletMonitor) Stop() {
+	select {
+	case <-m.stopCh:
+	default:
+		close(m.stopCh)
+	}
+}
+
+// Report returns the transparency report with up to limit movements after
+// skipping offset, newest first
+func (m *ReservedWalletMonitor) Report(offset, limit uint64) (*TransparencyReport, error) {
+	if limit == 0 || limit > MaxMovementsPerPage {
+		limit = MaxMovementsPerPage
+	}
+
+	m.mu.RLock()
+	report := &TransparencyReport{
+		Wallets:        make([]ReservedWalletStatus, 0, len(m.wallets)),
+		Movements:      make([]*ReservedMovement, 0),
+		TotalMovements: m.total,
+		Offset:         offset,
+		ScannedHeight:  m.next - 1,
+	}
+	m.mu.RUnlock()
+
+	head := m.chain.GetCurrentBlock()
+	for _, wallet := range m.wallets {
+		status := ReservedWalletStatus{
+			Name:          wallet.Name,
+			Address:       blockchain.ChecksumAddress(wallet.Address),
+			Allocation:    "0",
+			VestingMonths: wallet.VestingMonths,
+			Locked:        m.vesting.Locked(wallet.Address, head.Header.Timestamp).String(),
+			Balance:       m.chain.GetBalance(wallet.Address).String(),
+		}
+		if wallet.Allocation != nil {
+			status.Allocation = wallet.Allocation.String()
+		}
+		report.Wallets = append(report.Wallets, status)
+	}
+
+	movements, err := m.movements(offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	report.Movements = movements
+	return report, nil
+}
+
+// Helper functions
+
+func (m *ReservedWalletMonitor) run() {
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.scan(); err != nil {
+			logger.Warn("Reserved wallet monitor stopped", "height", m.next, "err", err)
+		}
+		select {
+		case <-m.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scan records the movements in blocks added since the last scan. If the
+// block the last scan ended on is no longer canonical, movements in the
+// blocks since reorgRescanDepth before it are checked again first. Only the
+// run goroutine moves the scan position; the lock is taken per block so
+// reports are served while the monitor catches up.
+func (m *ReservedWalletMonitor) scan() error {
+	// A chain restored from a snapshot has no blocks before it
+	base := m.chain.BaseHeight()
+	if m.next < base {
+		logger.Info("Reserved wallet monitor: earlier blocks are not stored, scanning from the first stored", "height", base)
+		m.mu.Lock()
+		m.next, m.lastHash = base, [32]byte{}
+		m.mu.Unlock()
+	}
+
+	if m.next > 1 && m.next > base {
+		block, err := m.chain.GetBlock(m.next - 1)
+		if err != nil || block.Hash() != m.lastHash {
+			m.mu.Lock()
+			err := m.rewind(base)
+			m.mu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	head := m.chain.GetCurrentBlock().Header.Height
+	for m.next <= head {
+		select {
+		case <-m.stopCh:
+			return nil
+		default:
+		}
+		block, err := m.chain.GetBlock(m.next)
+		if err != nil {
+			return err
+		}
+
+		m.mu.Lock()
+		err = m.scanBlock(block)
+		if err == nil {
+			m.next++
+			m.lastHash = block.Hash()
+			err = m.saveProgress()
+		}
+		m.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanBlock records the movements in a block. Callers must hold m.mu.
+func (m *ReservedWalletMonitor) scanBlock(block *blockchain.Block) error {
+	blockHash := block.Hash()
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		wallet, watched := m.watched[tx.From]
+		if !watched {
+			continue
+		}
+
+		txHash := tx.Hash
+		if txHash == ([32]byte{}) {
+			txHash = tx.ComputeHash()
+		}
+		key := movementKey(block.Header.Height, uint64(i))
+		if existing, err := m.loadMovement(key); err == nil && existing.TxHash == hexString(txHash[:]) {
+			continue
+		}
+
+		value := big.NewInt(0)
+		if tx.Value != nil {
+			value = tx.Value
+		}
+		locked := m.vesting.Locked(tx.From, block.Header.Timestamp)
+		movement := &ReservedMovement{
+			Wallet:      wallet.Name,
+			From:        blockchain.ChecksumAddress(tx.From),
+			To:          blockchain.ChecksumAddress(tx.To),
+			Value:       value.String(),
+			TxHash:      hexString(txHash[:]),
+			TxIndex:     uint64(i),
+			BlockHeight: block.Header.Height,
+			BlockHash:   hexString(blockHash[:]),
+			Timestamp:   block.Header.Timestamp,
+			Success:     true,
+			Locked:      locked.String(),
+			Vesting:     VestingUnchecked,
+		}
+		if receipt, err := m.chain.GetReceipt(txHash); err == nil {
+			movement.Success = receipt.Status == blockchain.ReceiptStatusSuccessful
+			movement.FailureReason = receipt.FailureReason
+		}
+		m.checkVesting(movement, tx.From, locked)
+
+		data, err := json.Marshal(movement)
+		if err != nil {
+			return err
+		}
+		if err := m.db.Put(key, data); err != nil {
+			return err
+		}
+		m.total++
+		m.emit(EventMovement, movement)
+	}
+	return nil
+}
+
+// checkVesting fills in the vesting result of a movement. Callers must hold
+// m.mu.
+func (m *ReservedWalletMonitor) checkVesting(movement *ReservedMovement, addr [20]byte, locked *big.Int) {
+	if movement.FailureReason == blockchain.FailureUnvestedFunds {
+		movement.Vesting = VestingBlocked
+		movement.Alert = true
+		return
+	}
+	balance, err := m.chain.GetBalanceAt(addr, movement.BlockHeight)
+	if err != nil {
+		return
+	}
+	movement.BalanceAfter = balance.String()
+	movement.Vesting = VestingOK
+	if balance.Cmp(locked) < 0 {
+		movement.Vesting = VestingViolated
+		movement.Alert = true
+	}
+}
+
+// rewind drops the movements of blocks that are no longer canonical and
+// moves the scan back so their replacements are scanned, but not below the
+// chain's base. Callers must hold m.mu.
+func (m *ReservedWalletMonitor) rewind(base uint64) error {
+	from := uint64(1)
+	if m.next > reorgRescanDepth+1 {
+		from = m.next - reorgRescanDepth
+	}
+	if from < base {
+		from = base
+	}
+	logger.Info("Reserved wallet monitor: chain reorganized, rescanning", "height", m.next, "from", from)
+
+	it := m.db.NewIterator(movementKeyPrefix)
+	defer it.Release()
+
+	batch := m.db.NewBatch()
+	reverted := make([]*ReservedMovement, 0)
+	for it.Next() {
+		var movement ReservedMovement
+		if err := json.Unmarshal(it.Value(), &movement); err != nil {
+			return err
+		}
+		if movement.BlockHeight < from {
+			continue
+		}
+		block, err := m.chain.GetBlock(movement.BlockHeight)
+		if err == nil {
+			if hash := block.Hash(); hexString(hash[:]) == movement.BlockHash {
+				continue
+			}
+		}
+		if err := batch.Delete(append([]byte(nil), it.Key()...)); err != nil {
+			return err
+		}
+		reverted = append(reverted, &movement)
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+
+	for _, movement := range reverted {
+		m.total--
+		m.emit(EventReverted, movement)
+	}
+	m.next = from
+	m.lastHash = [32]byte{}
+	if from > 1 && from > base {
+		block, err := m.chain.GetBlock(from - 1)
+		if err != nil {
+			return err
+		}
+		m.lastHash = block.Hash()
+	}
+	return m.saveProgress()
+}
+
+// emit logs an event and queues it for the webhooks. Callers must hold
+// m.mu.
+func (m *ReservedWalletMonitor) emit(eventType string, movement *ReservedMovement) {
+	switch {
+	case eventType == EventReverted:
+		logger.Warn("Reserved wallet movement was reverted", "wallet", movement.Wallet, "tx", movement.TxHash, "height", movement.BlockHeight)
+	case movement.Alert:
+		logger.Error("ALERT: reserved wallet sent funds against its vesting schedule", "wallet", movement.Wallet,
+			"value", movement.Value, "to", movement.To, "height", movement.BlockHeight, "vesting", movement.Vesting, "locked", movement.Locked)
+	default:
+		logger.Info("Reserved wallet sent funds", "wallet", movement.Wallet,
+			"value", movement.Value, "to", movement.To, "height", movement.BlockHeight, "tx", movement.TxHash)
+	}
+
+	if len(m.config.WebhookURLs) == 0 {
+		return
+	}
+	body, err := json.Marshal(MonitorEvent{Type: eventType, Movement: movement})
+	if err != nil {
+		return
+	}
+	select {
+	case m.webhookCh <- body:
+	default:
+		logger.Warn("Reserved wallet monitor: webhook queue full, dropping event", "tx", movement.TxHash)
+	}
+}
+
+// deliverWebhooks POSTs queued events to every webhook URL in order
+func (m *ReservedWalletMonitor) deliverWebhooks() {
+	for {
+		select {
+		case <-m.stopCh:
+			return
+		case body := <-m.webhookCh:
+			for _, url := range m.config.WebhookURLs {
+				if err := m.postWebhook(url, body); err != nil {
+					logger.Warn("Reserved wallet webhook failed", "url", url, "err", err)
+				}
+			}
+		}
+	}
+}
+
+// postWebhook delivers one event, retrying with backoff. When a secret is
+// set the body's HMAC-SHA256 is sent in X-Signature so receivers can
+// authenticate it.
+func (m *ReservedWalletMonitor) postWebhook(url string, body []byte) error {
+	var lastErr error
+	for attempt := 0; attempt < webhookAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-m.stopCh:
+				return lastErr
+			case <-time.After(time.Duration(attempt) * time.Second):
+			}
+		}
+
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if m.config.WebhookSecret != "" {
+			mac := hmac.New(sha256.New, []byte(m.config.WebhookSecret))
+			mac.Write(body)
+			req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		}
+
+		resp, err := m.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		lastErr = fmt.Errorf("status %s", resp.Status)
+	}
+	return lastErr
+}
+
+// movements returns stored movements newest first
+func (m *ReservedWalletMonitor) movements(offset, limit uint64) ([]*ReservedMovement, error) {
+	it := m.db.NewIterator(movementKeyPrefix)
+	defer it.Release()
+
+	// Keys sort oldest first; collect them all and page from the end
+	all := make([][]byte, 0)
+	for it.Next() {
+		all = append(all, append([]byte(nil), it.Value()...))
+	}
+	if err := it.Error(); err != nil {
+		return nil, err
+	}
+
+	page := make([]*ReservedMovement, 0, limit)
+	for i := uint64(len(all)); i > 0 && uint64(len(page)) < limit; i-- {
+		if uint64(len(all))-i < offset {
+			continue
+		}
+		var movement ReservedMovement
+		if err := json.Unmarshal(all[i-1], &movement); err != nil {
+			return nil, err
+		}
+		page = append(page, &movement)
+	}
+	return page, nil
+}
+
+func (m *ReservedWalletMonitor) loadMovement(key []byte) (*ReservedMovement, error) {
+	data, err := m.db.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	var movement ReservedMovement
+	if err := json.Unmarshal(data, &movement); err != nil {
+		return nil, err
+	}
+	return &movement, nil
+}
+
+// loadProgress restores the scan position and counts the stored movements
+func (m *ReservedWalletMonitor) loadProgress() error {
+	data, err := m.db.Get(monitorProgressKey)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+	case err != nil:
+		return err
+	case len(data) != 8+32:
+		return errors.New("corrupt reserved wallet monitor progress")
+	default:
+		m.next = binary.BigEndian.Uint64(data[:8])
+		copy(m.lastHash[:], data[8:])
+	}
+
+	it := m.db.NewIterator(movementKeyPrefix)
+	defer it.Release()
+	for it.Next() {
+		m.total++
+	}
+	return it.Error()
+}
+
+func (m *ReservedWalletMonitor) saveProgress() error {
+	data := binary.BigEndian.AppendUint64(make([]byte, 0, 8+32), m.next)
+	return m.db.Put(monitorProgressKey, append(data, m.lastHash[:]...))
+}
+
+func movementKey(height, index uint64) []byte {
+	key := binary.BigEndian.AppendUint64(append([]byte(nil), movementKeyPrefix...), height)
+	return binary.BigEndian.AppendUint64(key, index)
+}
+
+func hexString(b []byte) string {
+	return "0x" + hex.EncodeToString(b)
+}
@@ -0,0 +1,90 @@
+// Package token - Vesting of reserved wallet allocations
+package token
+
+import (
+	"math/big"
+
+	"chaincore/internal/genesis"
+)
+
+// VestingMonth is the length of one vesting period in seconds. Periods are
+// fixed at 30 days so release depends only on block timestamps.
+const VestingMonth = 30 * 24 * 60 * 60
+
+// VestingSchedule releases an allocation linearly, one equal part per month
+// after Start
+type VestingSchedule struct {
+	Address [20]byte
+	Total   *big.Int
+	Start   uint64 // Unix seconds
+	Months  uint32
+}
+
+// Vesting enforces the vesting schedules of reserved wallets
+type Vesting struct {
+	schedules map[[20]byte]VestingSchedule
+}
+
+// NewVesting creates schedules for every reserved wallet with vesting,
+// starting at the genesis timestamp
+func NewVesting(config *genesis.GenesisConfig) *Vesting {
+	v := &Vesting{
+		schedules: make(map[[20]byte]VestingSchedule),
+	}
+	for _, wallet := range config.ReservedWallets {
+		if wallet.VestingMonths == 0 || wallet.Allocation == nil {
+			continue
+		}
+		v.schedules[wallet.Address] = VestingSchedule{
+			Address: wallet.Address,
+			Total:   new(big.Int).Set(wallet.Allocation),
+			Start:   config.Timestamp,
+			Months:  wallet.VestingMonths,
+		}
+	}
+	return v
+}
+
+// Schedule returns the vesting schedule of an address, if any
+func (v *Vesting) Schedule(addr [20]byte) (VestingSchedule, bool) {
+	s, exists := v.schedules[addr]
+	return s, exists
+}
+
+// Locked returns the unvested amount an address must keep at the given
+// block timestamp
+func (v *Vesting) Locked(addr [20]byte, timestamp uint64) *big.Int {
+	s, exists := v.schedules[addr]
+	if !exists {
+		return big.NewInt(0)
+	}
+	return s.Unvested(timestamp)
+}
+
+// TotalLocked returns the unvested amount of every schedule at the given
+// block timestamp
+func (v *Vesting) TotalLocked(timestamp uint64) *big.Int {
+	total := big.NewInt(0)
+	for _, s := range v.schedules {
+		total.Add(total, s.Unvested(timestamp))
+	}
+	return total
+}
+
+// Unvested returns the part of the allocation still locked at timestamp
+func (s VestingSchedule) Unvested(timestamp uint64) *big.Int {
+	elapsed := s.ElapsedMonths(timestamp)
+	if elapsed >= uint64(s.Months) {
+		return big.NewInt(0)
+	}
+	remaining := new(big.Int).Mul(s.Total, new(big.Int).SetUint64(uint64(s.Months)-elapsed))
+	return remaining.Div(remaining, big.NewInt(int64(s.Months)))
+}
+
+// ElapsedMonths returns the number of whole vesting months since Start
+func (s VestingSchedule) ElapsedMonths(timestamp uint64) uint64 {
+	if timestamp <= s.Start {
+		return 0
+	}
+	return (timestamp - s.Start) / VestingMonth
+}
@@ -0,0 +1,424 @@
+// Package wallet - Encrypted backup bundles of a wallet's data directory
+package wallet
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupVersion is the version of the bundle format
+const backupVersion = 1
+
+// backupManifestName is the archive member listing the bundled files
+const backupManifestName = "manifest.json"
+
+// Backup entry kinds
+const (
+	BackupKeystore    = "keystore"
+	BackupAddressBook = "addressbook"
+	BackupHistory     = "history"
+	BackupSettings    = "settings"
+)
+
+// backupDataFiles are the data directory files bundled besides keystores
+var backupDataFiles = map[string]string{
+	"addressbook.json": BackupAddressBook,
+	"history.json":     BackupHistory,
+	"settings.json":    BackupSettings,
+}
+
+// ErrInvalidBackup is returned for a bundle that is not a backup or whose
+// contents do not match its manifest
+var ErrInvalidBackup = errors.New("invalid backup bundle")
+
+// ConflictPolicy decides what Restore does with a file that already exists
+// with different contents
+type ConflictPolicy string
+
+// Conflict policies
+const (
+	ConflictKeep    ConflictPolicy = "keep"    // Keep the existing file
+	ConflictReplace ConflictPolicy = "replace" // Move the existing file aside and restore the bundled one
+	ConflictRename  ConflictPolicy = "rename"  // Restore the bundled file under a new name
+)
+
+// Restore actions
+const (
+	RestoreWritten   = "written"   // The file did not exist
+	RestoreUnchanged = "unchanged" // The existing file was identical
+	RestoreKept      = "kept"      // The existing file differed and was kept
+	RestoreReplaced  = "replaced"  // The existing file was moved aside
+	RestoreRenamed   = "renamed"   // The bundled file was written under a new name
+)
+
+// BackupEntry describes a file of a backup bundle
+type BackupEntry struct {
+	Kind    string    `json:"kind"`
+	Path    string    `json:"path"` // Slash-separated, relative to the data directory
+	Size    int64     `json:"size"`
+	SHA256  string    `json:"sha256"`
+	ModTime time.Time `json:"modTime"`
+	Address string    `json:"address,omitempty"` // Keystores only, if known without the password
+}
+
+// BackupManifest lists the files of a backup bundle
+type BackupManifest struct {
+	Version   int           `json:"version"`
+	CreatedAt time.Time     `json:"createdAt"`
+	Entries   []BackupEntry `json:"entries"`
+}
+
+// Backup is a backup bundle's manifest and files: keystores and wallet
+// data files, zipped and encrypted under the backup password as keystores are
+type Backup struct {
+	Manifest BackupManifest
+	files    map[string][]byte // By entry path
+}
+
+// RestoreResult reports what Restore did with one entry
+type RestoreResult struct {
+	Entry    BackupEntry
+	Action   string
+	Path     string // Where the bundled file is, or the kept file for RestoreKept
+	Previous string // Where the existing file was moved, for RestoreReplaced
+}
+
+// backupFileJSON is the bundle file
+type backupFileJSON struct {
+	Version   int            `json:"version"`
+	CreatedAt time.Time      `json:"createdAt"`
+	Crypto    keystoreCrypto `json:"crypto"`
+}
+
+// CollectBackup gathers the files of dataDir to back up, and the keystore
+// files at keystores
+func CollectBackup(dataDir string, keystores []string) (*Backup, error) {
+	b := &Backup{
+		Manifest: BackupManifest{Version: backupVersion, CreatedAt: time.Now().UTC()},
+		files:    make(map[string][]byte),
+	}
+
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case entry.Type().IsRegular() && strings.HasSuffix(name, ".key"):
+			err = b.add(BackupKeystore, name, filepath.Join(dataDir, name))
+		case entry.Type().IsRegular() && backupDataFiles[name] != "":
+			err = b.add(backupDataFiles[name], name, filepath.Join(dataDir, name))
+		case entry.IsDir() && name == "keystore":
+			err = b.addDir(filepath.Join(dataDir, name), name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, file := range keystores {
+		if err := b.add(BackupKeystore, path.Join("keystore", filepath.Base(file)), file); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(b.Manifest.Entries) == 0 {
+		return nil, fmt.Errorf("nothing to back up in %s", dataDir)
+	}
+	sort.Slice(b.Manifest.Entries, func(i, j int) bool {
+		return b.Manifest.Entries[i].Path < b.Manifest.Entries[j].Path
+	})
+	return b, nil
+}
+
+// Write encrypts the bundle with password and writes it to file
+func (b *Backup) Write(file string, password string) error {
+	if password == "" {
+		return errors.New("password must not be empty")
+	}
+	archive, err := b.archive()
+	if err != nil {
+		return err
+	}
+	crypto, err := encryptSecret(archive, password)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(&backupFileJSON{
+		Version:   b.Manifest.Version,
+		CreatedAt: b.Manifest.CreatedAt,
+		Crypto:    *crypto,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(file, data)
+}
+
+// OpenBackup decrypts the bundle file and checks its files against the
+// manifest
+func OpenBackup(file string, password string) (*Backup, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var bundle backupFileJSON
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if bundle.Version != backupVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidBackup, bundle.Version)
+	}
+	archive, err := decryptSecret(&bundle.Crypto, password)
+	if err != nil {
+		return nil, err
+	}
+	return readBackupArchive(archive)
+}
+
+// Restore writes the bundled files under dataDir, resolving conflicts with
+// existing files by policy; keystores are never overwritten in place. It
+// stops at the first file it cannot write;
+// the results of the files handled before are returned with the error.
+func (b *Backup) Restore(dataDir string, policy ConflictPolicy) ([]RestoreResult, error) {
+	switch policy {
+	case ConflictKeep, ConflictReplace, ConflictRename:
+	default:
+		return nil, fmt.Errorf("unknown conflict policy %q", policy)
+	}
+
+	// Files moved aside or renamed share one suffix per restore
+	suffix := time.Now().UTC().Format("20060102-150405")
+	results := make([]RestoreResult, 0, len(b.Manifest.Entries))
+	for _, entry := range b.Manifest.Entries {
+		result, err := b.restoreEntry(dataDir, entry, policy, suffix)
+		if err != nil {
+			return results, fmt.Errorf("%s: %w", entry.Path, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Helper functions
+
+// add reads a file into the bundle as the entry at name
+func (b *Backup) add(kind, name, file string) error {
+	if _, ok := b.files[name]; ok {
+		return fmt.Errorf("two files would be bundled as %s", name)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(data)
+	entry := BackupEntry{
+		Kind:    kind,
+		Path:    name,
+		Size:    int64(len(data)),
+		SHA256:  hex.EncodeToString(sum[:]),
+		ModTime: info.ModTime().UTC(),
+	}
+	if kind == BackupKeystore {
+		entry.Address = keystoreAddress(file, data)
+	}
+	b.Manifest.Entries = append(b.Manifest.Entries, entry)
+	b.files[name] = data
+	return nil
+}
+
+// addDir adds the regular files of a keystore directory
+func (b *Backup) addDir(dir, name string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if err := b.add(BackupKeystore, path.Join(name, entry.Name()), filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archive returns the zip archive of the manifest and files
+func (b *Backup) archive() ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	manifest, err := json.MarshalIndent(&b.Manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	members := append([]BackupEntry{{Path: backupManifestName, ModTime: b.Manifest.CreatedAt}}, b.Manifest.Entries...)
+	for _, entry := range members {
+		data := b.files[entry.Path]
+		if entry.Path == backupManifestName {
+			data = manifest
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     entry.Path,
+			Method:   zip.Deflate,
+			Modified: entry.ModTime,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// readBackupArchive reads a decrypted archive and checks every manifest
+// entry against its file
+func readBackupArchive(archive []byte) (*Backup, error) {
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	members := make(map[string][]byte, len(zr.File))
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidBackup, f.Name, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidBackup, f.Name, err)
+		}
+		members[f.Name] = data
+	}
+
+	manifest, ok := members[backupManifestName]
+	if !ok {
+		return nil, fmt.Errorf("%w: no manifest", ErrInvalidBackup)
+	}
+	b := &Backup{files: make(map[string][]byte)}
+	if err := json.Unmarshal(manifest, &b.Manifest); err != nil {
+		return nil, fmt.Errorf("%w: manifest: %v", ErrInvalidBackup, err)
+	}
+	for _, entry := range b.Manifest.Entries {
+		if !validBackupPath(entry.Path) {
+			return nil, fmt.Errorf("%w: unsafe path %q", ErrInvalidBackup, entry.Path)
+		}
+		data, ok := members[entry.Path]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is missing", ErrInvalidBackup, entry.Path)
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != entry.SHA256 {
+			return nil, fmt.Errorf("%w: %s does not match its hash", ErrInvalidBackup, entry.Path)
+		}
+		b.files[entry.Path] = data
+	}
+	return b, nil
+}
+
+// restoreEntry restores one file
+func (b *Backup) restoreEntry(dataDir string, entry BackupEntry, policy ConflictPolicy, suffix string) (RestoreResult, error) {
+	data := b.files[entry.Path]
+	target := filepath.Join(dataDir, filepath.FromSlash(entry.Path))
+	result := RestoreResult{Entry: entry, Path: target}
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return result, err
+	}
+
+	existing, err := os.ReadFile(target)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		result.Action = RestoreWritten
+		return result, writeFileAtomic(target, data)
+	case err != nil:
+		return result, err
+	case bytes.Equal(existing, data):
+		result.Action = RestoreUnchanged
+		return result, nil
+	}
+
+	switch policy {
+	case ConflictReplace:
+		result.Action = RestoreReplaced
+		result.Previous = target + ".before-restore-" + suffix
+		if err := os.Rename(target, result.Previous); err != nil {
+			return result, err
+		}
+		return result, writeFileAtomic(target, data)
+	case ConflictRename:
+		ext := filepath.Ext(target)
+		result.Action = RestoreRenamed
+		result.Path = strings.TrimSuffix(target, ext) + ".restored-" + suffix + ext
+		return result, writeFileAtomic(result.Path, data)
+	default:
+		result.Action = RestoreKept
+		return result, nil
+	}
+}
+
+// keystoreAddress returns the address of a key file where it is known
+// without the password: recorded in a keystore, or derived from an
+// unencrypted key
+func keystoreAddress(file string, data []byte) string {
+	if isKeystore(data) {
+		ks, err := parseKeystore(data)
+		if err != nil || ks.Address == "" {
+			return ""
+		}
+		return "0x" + strings.TrimPrefix(ks.Address, "0x")
+	}
+	if w, err := Load(file, ""); err == nil {
+		return w.Address()
+	}
+	return ""
+}
+
+// validBackupPath reports whether an entry path stays inside the data
+// directory it is restored to
+func validBackupPath(p string) bool {
+	if p == "" || p == backupManifestName || path.IsAbs(p) || strings.Contains(p, `\`) {
+		return false
+	}
+	clean := path.Clean(p)
+	return clean == p && clean != ".." && !strings.HasPrefix(clean, "../")
+}
+
+// writeFileAtomic writes a private file alongside file and renames it into
+// place, so an existing file is never left half-written
+func writeFileAtomic(file string, data []byte) error {
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+// Package wallet - EIP-155 transaction signing
+package wallet
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/sha3"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/secp256k1"
+)
+
+// SignTransaction signs tx as an EIP-155 legacy transaction for chainID and
+// returns its raw encoding, ready for eth_sendRawTransaction. From,
+// Signature, Hash, Version and ChainID are filled in on tx.
+func (w *Wallet) SignTransaction(tx *blockchain.Transaction, chainID uint64) ([]byte, error) {
+	if w.keyType != KeyTypeSecp256k1 {
+		return nil, ErrLegacyKey
+	}
+
+	tx.Version, tx.ChainID = blockchain.LegacyTxType, chainID
+	sigHash := keccak256(signingPayload(tx, chainID))
+
+	var sig []byte
+	var err error
+	if w.signer != nil {
+		sig, err = w.signExternal(tx, chainID, sigHash)
+	} else {
+		sig, err = secp256k1.Sign(sigHash, w.secpKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if tx.From, err = blockchain.ParseAddress(w.address, false); err != nil {
+		return nil, err
+	}
+	copy(tx.Signature[:], sig)
+	raw, err := tx.EncodeRaw()
+	if err != nil {
+		return nil, err
+	}
+	copy(tx.Hash[:], keccak256(raw))
+	return raw, nil
+}
+
+// Helper functions
+
+// signExternal has the wallet's signer sign tx and checks that the
+// signature recovers to the wallet's address
+func (w *Wallet) signExternal(tx *blockchain.Transaction, chainID uint64, sigHash []byte) ([]byte, error) {
+	sig, err := w.signer.SignTx(tx, chainID)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := secp256k1.RecoverAddress(sigHash, sig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature from external signer: %w", err)
+	}
+	if blockchain.ChecksumAddress(signer) != w.address {
+		return nil, fmt.Errorf("external signer signed as %s, not %s", blockchain.ChecksumAddress(signer), w.address)
+	}
+	return sig, nil
+}
+
+// signingPayload is the EIP-155 signing payload of tx for chainID:
+// rlp([nonce, gasPrice, gas, to, value, data, chainId, 0, 0])
+func signingPayload(tx *blockchain.Transaction, chainID uint64) []byte {
+	legacy := *tx
+	legacy.Version, legacy.ChainID = blockchain.LegacyTxType, chainID
+	payload, _ := legacy.SigningPayload() // Legacy payloads always encode
+	return payload
+}
+
+func trimLeadingZeros(b []byte) []byte {
+	for len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+	}
+	return b
+}
+
+func keccak256(data []byte) []byte {
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write(data)
+	return hasher.Sum(nil)
+}
@@ -0,0 +1,371 @@
+// Package wallet - Hierarchical deterministic accounts (BIP-32/BIP-44)
+package wallet
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/secp256k1"
+)
+
+// HDPath is the BIP-44 path of Ethereum-compatible accounts; account i of
+// an HD wallet is at HDPath/i
+const HDPath = "m/44'/60'/0'/0"
+
+// hardenedOffset is added to a BIP-32 child index for hardened derivation
+const hardenedOffset = 0x80000000
+
+// HD wallet errors
+var (
+	ErrNotHD          = errors.New("wallet has no mnemonic; only HD wallets have derived accounts")
+	ErrUnknownAccount = errors.New("account has not been derived")
+)
+
+// Account is an account of an HD wallet
+type Account struct {
+	Index   uint32 `json:"index"`
+	Path    string `json:"path"`
+	Address string `json:"address"`
+}
+
+// keystoreHD is the HD section of a keystore file, which is otherwise a V3
+// keystore of account 0; the mnemonic is encrypted with the same password
+type keystoreHD struct {
+	Mnemonic keystoreCrypto `json:"mnemonic"`
+	Path     string         `json:"path"`
+	Accounts []uint32       `json:"accounts"` // Derived account indexes, in derivation order
+}
+
+// hdKeys holds the mnemonic and derived accounts of an HD wallet
+type hdKeys struct {
+	mnemonic string
+	seed     []byte
+	accounts []uint32
+	wallets  map[uint32]*Wallet // Accounts other than 0, once used
+}
+
+// extendedKey is a BIP-32 extended private key
+type extendedKey struct {
+	key       []byte
+	chainCode []byte
+}
+
+// CreateHD creates an HD wallet from a new mnemonic of the given number of
+// words and saves it to dataDir/wallet.key, encrypted with password. The
+// mnemonic is available from Mnemonic for the user to write down.
+func CreateHD(dataDir string, password string, words int) (*Wallet, error) {
+	mnemonic, err := NewMnemonic(words)
+	if err != nil {
+		return nil, err
+	}
+	return RestoreHD(dataDir, mnemonic, password)
+}
+
+// RestoreHD recreates the HD wallet of a mnemonic and saves it to
+// dataDir/wallet.key, encrypted with password. Only account 0 is restored;
+// others are derived again with AddAccount.
+func RestoreHD(dataDir string, mnemonic string, password string) (*Wallet, error) {
+	if password == "" {
+		return nil, errors.New("HD wallets must be encrypted with a password")
+	}
+	mnemonic, err := NormalizeMnemonic(mnemonic)
+	if err != nil {
+		return nil, err
+	}
+	keyPath := filepath.Join(dataDir, "wallet.key")
+	if _, err := os.Stat(keyPath); err == nil {
+		return nil, fmt.Errorf("%s already exists", keyPath)
+	}
+
+	wallet, err := newHDWallet(mnemonic, []uint32{0})
+	if err != nil {
+		return nil, err
+	}
+	if err := wallet.saveToFile(keyPath, password); err != nil {
+		return nil, err
+	}
+	wallet.path = keyPath
+	return wallet, nil
+}
+
+// IsHD reports whether the wallet was created from a mnemonic
+func (w *Wallet) IsHD() bool {
+	return w.hd != nil
+}
+
+// Mnemonic returns the mnemonic of an HD wallet
+func (w *Wallet) Mnemonic() (string, error) {
+	if w.hd == nil {
+		return "", ErrNotHD
+	}
+	return w.hd.mnemonic, nil
+}
+
+// Accounts lists the derived accounts of an HD wallet, in derivation order.
+// Other wallets have only their own address, as account 0 with no path.
+func (w *Wallet) Accounts() ([]Account, error) {
+	if w.hd == nil {
+		return []Account{{Address: w.address}}, nil
+	}
+
+	w.mu.Lock()
+	indexes := append([]uint32(nil), w.hd.accounts...)
+	w.mu.Unlock()
+
+	accounts := make([]Account, 0, len(indexes))
+	for _, index := range indexes {
+		account, err := w.hd.account(index)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+// DeriveAccount derives the account after the highest one derived so far
+// and records it in the key file
+func (w *Wallet) DeriveAccount() (Account, error) {
+	if w.hd == nil {
+		return Account{}, ErrNotHD
+	}
+
+	w.mu.Lock()
+	var next uint32
+	for _, index := range w.hd.accounts {
+		if index >= next {
+			next = index + 1
+		}
+	}
+	w.mu.Unlock()
+	return w.AddAccount(next)
+}
+
+// AddAccount derives the account at index and records it in the key file.
+// Adding an account already derived returns it unchanged.
+func (w *Wallet) AddAccount(index uint32) (Account, error) {
+	if w.hd == nil {
+		return Account{}, ErrNotHD
+	}
+	if index >= hardenedOffset {
+		return Account{}, fmt.Errorf("account index %d is out of range", index)
+	}
+	account, err := w.hd.account(index)
+	if err != nil {
+		return Account{}, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, known := range w.hd.accounts {
+		if known == index {
+			return account, nil
+		}
+	}
+	accounts := append(append([]uint32(nil), w.hd.accounts...), index)
+	if w.path != "" {
+		if err := saveAccounts(w.path, accounts); err != nil {
+			return Account{}, err
+		}
+	}
+	w.hd.accounts = accounts
+	return account, nil
+}
+
+// Account returns a wallet signing for a derived account. It shares the
+// chain ID and transaction parameter source of w and tracks its own nonces;
+// the same wallet is returned for every call with an index.
+func (w *Wallet) Account(index uint32) (*Wallet, error) {
+	if index == 0 {
+		return w, nil
+	}
+	if w.hd == nil {
+		return nil, ErrNotHD
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if account, ok := w.hd.wallets[index]; ok {
+		return account, nil
+	}
+	derived := false
+	for _, known := range w.hd.accounts {
+		derived = derived || known == index
+	}
+	if !derived {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownAccount, index)
+	}
+
+	key, err := deriveAccountKey(w.hd.seed, index)
+	if err != nil {
+		return nil, err
+	}
+	account, err := newSecp256k1Wallet(key)
+	if err != nil {
+		return nil, err
+	}
+	account.chainID = w.chainID
+	account.txSource = w.txSource
+	w.hd.wallets[index] = account
+	return account, nil
+}
+
+// Helper functions
+
+// newHDWallet creates the wallet of account 0 of a normalized mnemonic
+func newHDWallet(mnemonic string, accounts []uint32) (*Wallet, error) {
+	seed := mnemonicSeed(mnemonic)
+	key, err := deriveAccountKey(seed, 0)
+	if err != nil {
+		return nil, err
+	}
+	wallet, err := newSecp256k1Wallet(key)
+	if err != nil {
+		return nil, err
+	}
+	wallet.hd = &hdKeys{
+		mnemonic: mnemonic,
+		seed:     seed,
+		accounts: accounts,
+		wallets:  make(map[uint32]*Wallet),
+	}
+	return wallet, nil
+}
+
+// loadHD restores the HD state of a wallet loaded from a keystore with an
+// HD section, checking that the mnemonic belongs to the keystore's key
+func loadHD(wallet *Wallet, section *keystoreHD, password string) error {
+	if section.Path != HDPath {
+		return fmt.Errorf("%w: unsupported derivation path %q", ErrInvalidKeystore, section.Path)
+	}
+	secret, err := decryptSecret(&section.Mnemonic, password)
+	if err != nil {
+		return err
+	}
+	mnemonic, err := NormalizeMnemonic(string(secret))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidKeystore, err)
+	}
+
+	accounts := section.Accounts
+	if len(accounts) == 0 {
+		accounts = []uint32{0}
+	}
+	hd, err := newHDWallet(mnemonic, accounts)
+	if err != nil {
+		return err
+	}
+	if hd.address != wallet.address {
+		return fmt.Errorf("%w: mnemonic does not belong to %s", ErrInvalidKeystore, wallet.address)
+	}
+	wallet.hd = hd.hd
+	return nil
+}
+
+// encrypt returns the HD section of the wallet's keystore
+func (hd *hdKeys) encrypt(password string) (*keystoreHD, error) {
+	mnemonic, err := encryptSecret([]byte(hd.mnemonic), password)
+	if err != nil {
+		return nil, err
+	}
+	return &keystoreHD{
+		Mnemonic: *mnemonic,
+		Path:     HDPath,
+		Accounts: hd.accounts,
+	}, nil
+}
+
+// account describes the account at index
+func (hd *hdKeys) account(index uint32) (Account, error) {
+	key, err := deriveAccountKey(hd.seed, index)
+	if err != nil {
+		return Account{}, err
+	}
+	pub, err := secp256k1.PublicKey(key)
+	if err != nil {
+		return Account{}, err
+	}
+	return Account{
+		Index:   index,
+		Path:    fmt.Sprintf("%s/%d", HDPath, index),
+		Address: blockchain.ChecksumAddress(secp256k1.PubkeyToAddress(pub)),
+	}, nil
+}
+
+// saveAccounts records the derived account indexes in the HD section of
+// the keystore at path, leaving its encrypted parts as they are
+func saveAccounts(path string, accounts []uint32) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	ks, err := parseKeystore(data)
+	if err != nil {
+		return err
+	}
+	if ks.HD == nil {
+		return fmt.Errorf("%w: %s has no HD section", ErrInvalidKeystore, path)
+	}
+	ks.HD.Accounts = accounts
+	return writeKeystore(path, ks)
+}
+
+// deriveAccountKey derives the private key of account index at HDPath
+func deriveAccountKey(seed []byte, index uint32) ([]byte, error) {
+	key, err := masterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+	path := []uint32{44 + hardenedOffset, 60 + hardenedOffset, hardenedOffset, 0, index}
+	for _, child := range path {
+		if key, err = key.child(child); err != nil {
+			return nil, err
+		}
+	}
+	return key.key, nil
+}
+
+// masterKey derives the BIP-32 master key of a seed
+func masterKey(seed []byte) (*extendedKey, error) {
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+	if _, err := secp256k1.PublicKey(sum[:32]); err != nil {
+		return nil, fmt.Errorf("unusable seed: %w", err)
+	}
+	return &extendedKey{key: sum[:32], chainCode: sum[32:]}, nil
+}
+
+// child derives the private child key at index. Indexes from
+// hardenedOffset up are hardened. An index whose key is invalid, which
+// happens with probability below 2^-127, is an error rather than skipped.
+func (k *extendedKey) child(index uint32) (*extendedKey, error) {
+	data := make([]byte, 0, 37)
+	if index >= hardenedOffset {
+		data = append(append(data, 0), k.key...)
+	} else {
+		pub, err := secp256k1.PublicKey(k.key)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, secp256k1.CompressPublicKey(pub)...)
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+	key, err := secp256k1.AddPrivateKeys(k.key, sum[:32])
+	if err != nil {
+		return nil, fmt.Errorf("child key %d: %w", index, err)
+	}
+	return &extendedKey{key: key, chainCode: sum[32:]}, nil
+}
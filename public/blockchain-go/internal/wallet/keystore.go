@@ -0,0 +1,309 @@
+// Package wallet - Password-encrypted key files
+package wallet
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/secp256k1"
+)
+
+// Keystore key derivation parameters, the same as geth's standard ones, so
+// unlocking takes around a second and 256 MB of memory
+const (
+	keystoreVersion = 3
+	scryptN         = 1 << 18
+	scryptR         = 8
+	scryptP         = 1
+	scryptDKLen     = 32
+)
+
+// Keystore errors
+var (
+	ErrPasswordRequired = errors.New("wallet file is encrypted; a password is required")
+	ErrWrongPassword    = errors.New("could not decrypt wallet file with the given password")
+	ErrInvalidKeystore  = errors.New("invalid keystore file")
+)
+
+// keystoreJSON is a V3 keystore file, as geth and MetaMask write: scrypt or
+// PBKDF2, AES-128-CTR and a Keccak-256 MAC
+type keystoreJSON struct {
+	Address string         `json:"address"`
+	Crypto  keystoreCrypto `json:"crypto"`
+	HD      *keystoreHD    `json:"hd,omitempty"` // HD wallets only; ignored by other V3 tools
+	ID      string         `json:"id"`
+	Version int            `json:"version"`
+}
+
+type keystoreCrypto struct {
+	Cipher       string          `json:"cipher"`
+	CipherText   string          `json:"ciphertext"`
+	CipherParams cipherParams    `json:"cipherparams"`
+	KDF          string          `json:"kdf"`
+	KDFParams    json.RawMessage `json:"kdfparams"`
+	MAC          string          `json:"mac"`
+}
+
+type cipherParams struct {
+	IV string `json:"iv"`
+}
+
+type scryptParams struct {
+	DKLen int    `json:"dklen"`
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	Salt  string `json:"salt"`
+}
+
+type pbkdf2Params struct {
+	DKLen int    `json:"dklen"`
+	C     int    `json:"c"`
+	PRF   string `json:"prf"`
+	Salt  string `json:"salt"`
+}
+
+// Encrypt replaces the unencrypted secp256k1 key file at path with a
+// keystore file encrypted with password. Legacy P-256 wallets must be
+// migrated first.
+func Encrypt(path string, password string) (*Wallet, error) {
+	if password == "" {
+		return nil, errors.New("password must not be empty")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if isKeystore(data) {
+		return nil, errors.New("wallet file is already encrypted")
+	}
+
+	w, err := Load(path, "")
+	if err != nil {
+		return nil, err
+	}
+	if w.keyType != KeyTypeSecp256k1 {
+		return nil, ErrLegacyKey
+	}
+
+	if err := w.saveToFile(path, password); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Helper functions
+
+// isKeystore reports whether a key file holds a JSON keystore rather than
+// a raw key
+func isKeystore(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+}
+
+// writeKeystore writes a keystore file alongside path and renames it into
+// place, so an existing key file is never left half-written
+func writeKeystore(path string, ks *keystoreJSON) error {
+	data, err := json.MarshalIndent(ks, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// encryptKey encrypts a secp256k1 private key into a V3 keystore
+func encryptKey(key []byte, address [20]byte, password string) (*keystoreJSON, error) {
+	crypto, err := encryptSecret(key, password)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	// Random (version 4) UUID
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return &keystoreJSON{
+		Address: hex.EncodeToString(address[:]),
+		Crypto:  *crypto,
+		ID:      fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:]),
+		Version: keystoreVersion,
+	}, nil
+}
+
+// parseKeystore decodes a V3 keystore file
+func parseKeystore(data []byte) (*keystoreJSON, error) {
+	var ks keystoreJSON
+	if err := json.Unmarshal(data, &ks); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKeystore, err)
+	}
+	if ks.Version != keystoreVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidKeystore, ks.Version)
+	}
+	return &ks, nil
+}
+
+// decryptKey returns the secp256k1 private key in a V3 keystore
+func decryptKey(ks *keystoreJSON, password string) ([]byte, error) {
+	key, err := decryptSecret(&ks.Crypto, password)
+	if err != nil {
+		return nil, err
+	}
+
+	// The address is informational, but one that does not match the key
+	// means the file was tampered with or mixed up
+	if ks.Address != "" {
+		pub, err := secp256k1.PublicKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidKeystore, err)
+		}
+		addr := secp256k1.PubkeyToAddress(pub)
+		if !strings.EqualFold(strings.TrimPrefix(ks.Address, "0x"), hex.EncodeToString(addr[:])) {
+			return nil, fmt.Errorf("%w: key belongs to %s, not %s", ErrInvalidKeystore, blockchain.ChecksumAddress(addr), ks.Address)
+		}
+	}
+	return key, nil
+}
+
+// encryptSecret encrypts a secret with AES-128-CTR under a key derived from
+// password with scrypt
+func encryptSecret(secret []byte, password string) (*keystoreCrypto, error) {
+	salt := make([]byte, 32)
+	iv := make([]byte, aes.BlockSize)
+	for _, b := range [][]byte{salt, iv} {
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+	}
+
+	derived, err := scrypt.Key([]byte(password), salt, scryptN, scryptR, scryptP, scryptDKLen)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := aesCTR(derived[:16], iv, secret)
+	if err != nil {
+		return nil, err
+	}
+	params, err := json.Marshal(scryptParams{
+		DKLen: scryptDKLen,
+		N:     scryptN,
+		R:     scryptR,
+		P:     scryptP,
+		Salt:  hex.EncodeToString(salt),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &keystoreCrypto{
+		Cipher:       "aes-128-ctr",
+		CipherText:   hex.EncodeToString(ciphertext),
+		CipherParams: cipherParams{IV: hex.EncodeToString(iv)},
+		KDF:          "scrypt",
+		KDFParams:    params,
+		MAC:          hex.EncodeToString(keystoreMAC(derived, ciphertext)),
+	}, nil
+}
+
+// decryptSecret checks the MAC of an encrypted secret and decrypts it
+func decryptSecret(c *keystoreCrypto, password string) ([]byte, error) {
+	if c.Cipher != "aes-128-ctr" {
+		return nil, fmt.Errorf("%w: unsupported cipher %q", ErrInvalidKeystore, c.Cipher)
+	}
+	ciphertext, err := hex.DecodeString(c.CipherText)
+	if err != nil {
+		return nil, fmt.Errorf("%w: ciphertext: %v", ErrInvalidKeystore, err)
+	}
+	iv, err := hex.DecodeString(c.CipherParams.IV)
+	if err != nil || len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("%w: iv", ErrInvalidKeystore)
+	}
+	mac, err := hex.DecodeString(c.MAC)
+	if err != nil {
+		return nil, fmt.Errorf("%w: mac: %v", ErrInvalidKeystore, err)
+	}
+
+	derived, err := deriveKeystoreKey(c.KDF, c.KDFParams, password)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(keystoreMAC(derived, ciphertext), mac) {
+		return nil, ErrWrongPassword
+	}
+	return aesCTR(derived[:16], iv, ciphertext)
+}
+
+// deriveKeystoreKey derives the 32-byte encryption and MAC key from a
+// password with the keystore's KDF
+func deriveKeystoreKey(kdf string, raw json.RawMessage, password string) ([]byte, error) {
+	switch kdf {
+	case "scrypt":
+		var params scryptParams
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return nil, fmt.Errorf("%w: kdfparams: %v", ErrInvalidKeystore, err)
+		}
+		salt, err := hex.DecodeString(params.Salt)
+		if err != nil || params.DKLen < 32 {
+			return nil, fmt.Errorf("%w: kdfparams", ErrInvalidKeystore)
+		}
+		derived, err := scrypt.Key([]byte(password), salt, params.N, params.R, params.P, params.DKLen)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidKeystore, err)
+		}
+		return derived, nil
+	case "pbkdf2":
+		var params pbkdf2Params
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return nil, fmt.Errorf("%w: kdfparams: %v", ErrInvalidKeystore, err)
+		}
+		if params.PRF != "hmac-sha256" {
+			return nil, fmt.Errorf("%w: unsupported prf %q", ErrInvalidKeystore, params.PRF)
+		}
+		salt, err := hex.DecodeString(params.Salt)
+		if err != nil || params.DKLen < 32 || params.C <= 0 {
+			return nil, fmt.Errorf("%w: kdfparams", ErrInvalidKeystore)
+		}
+		return pbkdf2.Key([]byte(password), salt, params.C, params.DKLen, sha256.New), nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported kdf %q", ErrInvalidKeystore, kdf)
+	}
+}
+
+// keystoreMAC is the Keccak-256 hash of the second half of the derived key
+// and the ciphertext
+func keystoreMAC(derived, ciphertext []byte) []byte {
+	return keccak256(append(append([]byte(nil), derived[16:32]...), ciphertext...))
+}
+
+func aesCTR(key, iv, in []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(in))
+	cipher.NewCTR(block, iv).XORKeyStream(out, in)
+	return out, nil
+}
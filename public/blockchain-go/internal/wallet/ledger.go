@@ -0,0 +1,242 @@
+// Package wallet - Ledger hardware wallets over USB HID
+package wallet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/secp256k1"
+)
+
+// Ledger USB and HID framing constants
+const (
+	ledgerVendorID   = 0x2c97
+	ledgerChannel    = 0x0101
+	ledgerTag        = 0x05
+	ledgerReportSize = 64
+	ledgerChunkSize  = 255 // Largest APDU payload
+)
+
+// Ledger Ethereum app commands and status words
+const (
+	ledgerCLA          = 0xe0
+	ledgerGetAddress   = 0x02
+	ledgerSignTx       = 0x04
+	ledgerStatusOK     = 0x9000
+	ledgerRejected     = 0x6985
+	ledgerInvalidData  = 0x6a80
+	ledgerLocked       = 0x5515
+	ledgerAppNotOpen   = 0x6d00
+	ledgerCLANotOpen   = 0x6e00
+	ledgerNoAppRunning = 0x6511
+)
+
+// ledger signs with a Ledger device running the Ethereum app
+type ledger struct {
+	device  *os.File // hidraw node
+	path    []uint32
+	address string
+}
+
+// openLedger opens the first Ledger's APDU interface and reads the address
+// at path, showing it on the device for approval if confirm is set
+func openLedger(path []uint32, confirm bool) (*ledger, error) {
+	node, err := findHIDDevice(ledgerVendorID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: is a Ledger connected and unlocked?", err)
+	}
+	device, err := os.OpenFile(node, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Ledger at %s: %w", node, err)
+	}
+	l := &ledger{device: device, path: path}
+
+	// Reply: pubkey length, pubkey, address length, address as hex
+	var p1 byte
+	if confirm {
+		p1 = 0x01
+	}
+	reply, err := l.exchange(ledgerGetAddress, p1, 0x00, encodeLedgerPath(path))
+	if err != nil {
+		device.Close()
+		return nil, err
+	}
+	if len(reply) < 1 || len(reply) < 1+int(reply[0])+1 {
+		device.Close()
+		return nil, errors.New("malformed address reply from Ledger")
+	}
+	rest := reply[1+int(reply[0]):]
+	if len(rest) < 41 || rest[0] != 40 {
+		device.Close()
+		return nil, errors.New("malformed address reply from Ledger")
+	}
+	addr, err := blockchain.ParseAddress("0x"+string(rest[1:41]), false)
+	if err != nil {
+		device.Close()
+		return nil, fmt.Errorf("malformed address reply from Ledger: %w", err)
+	}
+	l.address = blockchain.ChecksumAddress(addr)
+	return l, nil
+}
+
+// Address returns the address of the Ledger account
+func (l *ledger) Address() string {
+	return l.address
+}
+
+// SignTx sends the signing payload of tx to the Ledger, which shows it and
+// signs once the user approves it
+func (l *ledger) SignTx(tx *blockchain.Transaction, chainID uint64) ([]byte, error) {
+	payload := signingPayload(tx, chainID)
+	data := append(encodeLedgerPath(l.path), payload...)
+
+	// Reply to the last chunk: v, r, s
+	var reply []byte
+	for p1 := byte(0x00); len(data) > 0; p1 = 0x80 {
+		n := len(data)
+		if n > ledgerChunkSize {
+			n = ledgerChunkSize
+		}
+		var err error
+		if reply, err = l.exchange(ledgerSignTx, p1, 0x00, data[:n]); err != nil {
+			return nil, err
+		}
+		data = data[n:]
+	}
+	if len(reply) != 65 {
+		return nil, errors.New("malformed signature reply from Ledger")
+	}
+
+	// The app returns only the low byte of the EIP-155 v, so the recovery
+	// id is found by trying both against the device's address
+	sig := append(append([]byte(nil), reply[1:65]...), 0)
+	hash := keccak256(payload)
+	for _, id := range []byte{0, 1} {
+		sig[64] = id
+		if addr, err := secp256k1.RecoverAddress(hash, sig); err == nil && blockchain.ChecksumAddress(addr) == l.address {
+			return sig, nil
+		}
+	}
+	return nil, fmt.Errorf("Ledger signature does not match %s", l.address)
+}
+
+// Close closes the HID device
+func (l *ledger) Close() error {
+	return l.device.Close()
+}
+
+// Helper functions
+
+// exchange sends one APDU and returns the reply without its status word
+func (l *ledger) exchange(ins, p1, p2 byte, data []byte) ([]byte, error) {
+	apdu := append([]byte{ledgerCLA, ins, p1, p2, byte(len(data))}, data...)
+
+	// Every report carries the channel, tag and a sequence number; the first
+	// also carries the APDU length. Linux expects a leading report ID of 0.
+	payload := binary.BigEndian.AppendUint16(nil, uint16(len(apdu)))
+	payload = append(payload, apdu...)
+	for seq := 0; len(payload) > 0; seq++ {
+		report := make([]byte, 1+ledgerReportSize)
+		binary.BigEndian.PutUint16(report[1:], ledgerChannel)
+		report[3] = ledgerTag
+		binary.BigEndian.PutUint16(report[4:], uint16(seq))
+		payload = payload[copy(report[6:], payload):]
+		if _, err := l.device.Write(report); err != nil {
+			return nil, fmt.Errorf("failed to write to Ledger: %w", err)
+		}
+	}
+
+	// Not every hidraw node supports deadlines; without one the read waits
+	// for the user however long they take
+	l.device.SetReadDeadline(time.Now().Add(hardwareTimeout))
+	var reply []byte
+	total := -1
+	for seq := 0; total < 0 || len(reply) < total; seq++ {
+		report := make([]byte, ledgerReportSize)
+		n, err := l.device.Read(report)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read from Ledger: %w", err)
+		}
+		if n < 7 || binary.BigEndian.Uint16(report) != ledgerChannel || report[2] != ledgerTag || int(binary.BigEndian.Uint16(report[3:])) != seq {
+			return nil, errors.New("malformed reply from Ledger")
+		}
+		chunk := report[5:n]
+		if seq == 0 {
+			total = int(binary.BigEndian.Uint16(chunk))
+			chunk = chunk[2:]
+		}
+		reply = append(reply, chunk...)
+	}
+	reply = reply[:total]
+	if len(reply) < 2 {
+		return nil, errors.New("malformed reply from Ledger")
+	}
+
+	status := binary.BigEndian.Uint16(reply[len(reply)-2:])
+	switch status {
+	case ledgerStatusOK:
+		return reply[:len(reply)-2], nil
+	case ledgerRejected:
+		return nil, ErrHardwareRejected
+	case ledgerLocked:
+		return nil, errors.New("Ledger is locked; unlock it and open the Ethereum app")
+	case ledgerAppNotOpen, ledgerCLANotOpen, ledgerNoAppRunning:
+		return nil, errors.New("open the Ethereum app on the Ledger")
+	case ledgerInvalidData:
+		return nil, errors.New("Ledger refused the transaction; enable blind signing in the Ethereum app to sign transactions with data")
+	default:
+		return nil, fmt.Errorf("Ledger returned status %#04x", status)
+	}
+}
+
+// encodeLedgerPath encodes a derivation path as its length and big-endian
+// indexes
+func encodeLedgerPath(path []uint32) []byte {
+	data := []byte{byte(len(path))}
+	for _, index := range path {
+		data = binary.BigEndian.AppendUint32(data, index)
+	}
+	return data
+}
+
+// findHIDDevice returns the hidraw node of the first interface 0 of a USB
+// device from vendor. Ledger devices also expose a FIDO interface, which
+// does not speak APDUs.
+func findHIDDevice(vendor uint16) (string, error) {
+	nodes, _ := filepath.Glob("/sys/class/hidraw/hidraw*")
+	for _, node := range nodes {
+		uevent, err := os.ReadFile(filepath.Join(node, "device", "uevent"))
+		if err != nil {
+			continue
+		}
+		// HID_ID=<bus>:<vendor>:<product>, bus 0003 being USB
+		var id string
+		for _, line := range strings.Split(string(uevent), "\n") {
+			if strings.HasPrefix(line, "HID_ID=") {
+				id = strings.TrimPrefix(line, "HID_ID=")
+			}
+		}
+		parts := strings.Split(id, ":")
+		if len(parts) != 3 || parts[0] != "0003" {
+			continue
+		}
+		if v, err := strconv.ParseUint(parts[1], 16, 32); err != nil || uint16(v) != vendor {
+			continue
+		}
+
+		// The HID device sits under its USB interface, e.g. 1-2:1.0
+		device, err := filepath.EvalSymlinks(filepath.Join(node, "device"))
+		if err != nil || !strings.HasSuffix(filepath.Base(filepath.Dir(device)), ".0") {
+			continue
+		}
+		return filepath.Join("/dev", filepath.Base(node)), nil
+	}
+	return "", ErrNoHardwareWallet
+}
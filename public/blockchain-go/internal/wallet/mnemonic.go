@@ -0,0 +1,114 @@
+// Package wallet - BIP-39 mnemonic phrases
+package wallet
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// DefaultMnemonicWords is the length of mnemonics created for new wallets
+const DefaultMnemonicWords = 12
+
+// ErrInvalidMnemonic is returned for phrases with unknown words, a wrong
+// length or a wrong checksum
+var ErrInvalidMnemonic = errors.New("invalid mnemonic")
+
+// bip39Words and bip39Index map between wordlist indexes and words
+var (
+	bip39Words = strings.Fields(bip39English)
+	bip39Index = func() map[string]int {
+		index := make(map[string]int, len(bip39Words))
+		for i, word := range bip39Words {
+			index[word] = i
+		}
+		return index
+	}()
+)
+
+// NewMnemonic returns a random BIP-39 mnemonic of 12, 15, 18, 21 or 24
+// words
+func NewMnemonic(words int) (string, error) {
+	if words < 12 || words > 24 || words%3 != 0 {
+		return "", fmt.Errorf("mnemonic must have 12, 15, 18, 21 or 24 words, not %d", words)
+	}
+	entropy := make([]byte, words*4/3)
+	if _, err := rand.Read(entropy); err != nil {
+		return "", err
+	}
+	return entropyToMnemonic(entropy), nil
+}
+
+// NormalizeMnemonic lowercases a phrase and separates its words with single
+// spaces, and checks its words and checksum
+func NormalizeMnemonic(mnemonic string) (string, error) {
+	words := strings.Fields(strings.ToLower(mnemonic))
+	normalized := strings.Join(words, " ")
+	if _, err := mnemonicEntropy(normalized); err != nil {
+		return "", err
+	}
+	return normalized, nil
+}
+
+// Helper functions
+
+// entropyToMnemonic encodes entropy and its SHA-256 checksum, one bit per
+// 32 bits of entropy, as 11-bit word indexes
+func entropyToMnemonic(entropy []byte) string {
+	checksumBits := uint(len(entropy) * 8 / 32)
+	hash := sha256.Sum256(entropy)
+
+	bits := new(big.Int).SetBytes(entropy)
+	bits.Lsh(bits, checksumBits)
+	bits.Or(bits, big.NewInt(int64(hash[0]>>(8-checksumBits))))
+
+	count := (len(entropy)*8 + int(checksumBits)) / 11
+	words := make([]string, count)
+	mask := big.NewInt(2047)
+	for i := count - 1; i >= 0; i-- {
+		words[i] = bip39Words[new(big.Int).And(bits, mask).Int64()]
+		bits.Rsh(bits, 11)
+	}
+	return strings.Join(words, " ")
+}
+
+// mnemonicEntropy decodes a normalized mnemonic and verifies its checksum
+func mnemonicEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Split(mnemonic, " ")
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, fmt.Errorf("%w: %d words", ErrInvalidMnemonic, len(words))
+	}
+
+	bits := new(big.Int)
+	for _, word := range words {
+		index, ok := bip39Index[word]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown word %q", ErrInvalidMnemonic, word)
+		}
+		bits.Lsh(bits, 11)
+		bits.Or(bits, big.NewInt(int64(index)))
+	}
+
+	checksumBits := uint(len(words) / 3)
+	checksum := new(big.Int).And(bits, big.NewInt(int64(1)<<checksumBits-1)).Int64()
+	bits.Rsh(bits, checksumBits)
+	entropy := bits.FillBytes(make([]byte, len(words)*4/3))
+
+	hash := sha256.Sum256(entropy)
+	if int64(hash[0]>>(8-checksumBits)) != checksum {
+		return nil, fmt.Errorf("%w: checksum does not match", ErrInvalidMnemonic)
+	}
+	return entropy, nil
+}
+
+// mnemonicSeed derives the 64-byte BIP-39 seed of a normalized mnemonic.
+// BIP-39 passphrases are not supported, so the salt is just "mnemonic".
+func mnemonicSeed(mnemonic string) []byte {
+	return pbkdf2.Key([]byte(mnemonic), []byte("mnemonic"), 2048, 64, sha512.New)
+}
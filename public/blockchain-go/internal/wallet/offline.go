@@ -0,0 +1,226 @@
+// Package wallet - Air-gapped signing payloads for cold wallets
+package wallet
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+
+	"chaincore/internal/blockchain"
+)
+
+// Payload prefixes identify the payload kind when scanned from a QR code
+const (
+	UnsignedPayloadPrefix = "gyds-unsigned:"
+	SignedPayloadPrefix   = "gyds-signed:"
+	payloadVersion        = 1
+)
+
+// UnsignedPayload is a transaction built on an online machine and carried
+// to an air-gapped machine for signing
+type UnsignedPayload struct {
+	Version   int    `json:"version"`
+	ChainID   uint64 `json:"chainId"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Value     string `json:"value"`
+	Nonce     uint64 `json:"nonce"`
+	GasLimit  uint64 `json:"gasLimit"`
+	GasPrice  string `json:"gasPrice"`
+	Data      string `json:"data,omitempty"` // Hex-encoded payload, e.g. a deposit memo
+	CreatedAt int64  `json:"createdAt"`
+}
+
+// SignedPayload is a signed transaction carried back to an online machine
+// for broadcast
+type SignedPayload struct {
+	Version     int                    `json:"version"`
+	ChainID     uint64                 `json:"chainId"`
+	Transaction map[string]interface{} `json:"transaction"`
+	SignedAt    int64                  `json:"signedAt"`
+}
+
+// NewUnsignedPayload builds an unsigned transfer payload
+func NewUnsignedPayload(chainID uint64, from, to, amount string, nonce uint64) (*UnsignedPayload, error) {
+	fromAddr, err := blockchain.ParseAddress(from, false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender address: %w", err)
+	}
+	toAddr, err := blockchain.ParseAddress(to, false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient address: %w", err)
+	}
+
+	value, ok := new(big.Int).SetString(amount, 10)
+	if !ok || value.Sign() < 0 {
+		return nil, errors.New("invalid amount")
+	}
+
+	return &UnsignedPayload{
+		Version:   payloadVersion,
+		ChainID:   chainID,
+		From:      blockchain.ChecksumAddress(fromAddr),
+		To:        blockchain.ChecksumAddress(toAddr),
+		Value:     value.String(),
+		Nonce:     nonce,
+		GasLimit:  21000,
+		GasPrice:  "1000000000",
+		CreatedAt: time.Now().Unix(),
+	}, nil
+}
+
+// SetMemo attaches a UTF-8 memo as the transaction data and raises the gas
+// limit to cover it
+func (p *UnsignedPayload) SetMemo(memo string) error {
+	data, gasLimit, err := memoData(memo)
+	if err != nil {
+		return err
+	}
+	p.Data = data
+	p.GasLimit = gasLimit
+	return nil
+}
+
+// SignOffline signs an unsigned payload. It never touches the network, so it
+// is safe to run on an air-gapped machine.
+func (w *Wallet) SignOffline(p *UnsignedPayload) (*SignedPayload, error) {
+	if p.Version != payloadVersion {
+		return nil, fmt.Errorf("unsupported payload version %d", p.Version)
+	}
+	if !strings.EqualFold(p.From, w.address) {
+		return nil, fmt.Errorf("payload sender %s does not match wallet %s", p.From, w.address)
+	}
+
+	tx, err := p.transaction()
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]interface{}{
+		"from":     w.address,
+		"to":       p.To,
+		"value":    p.Value,
+		"nonce":    p.Nonce,
+		"gasLimit": p.GasLimit,
+		"gasPrice": p.GasPrice,
+	}
+	if p.Data != "" {
+		fields["data"] = p.Data
+	}
+
+	if err := w.signInto(fields, tx, p.ChainID); err != nil {
+		return nil, err
+	}
+
+	return &SignedPayload{
+		Version:     payloadVersion,
+		ChainID:     p.ChainID,
+		Transaction: fields,
+		SignedAt:    time.Now().Unix(),
+	}, nil
+}
+
+// EncodePayload encodes a payload as a prefixed, QR-friendly string
+func EncodePayload(prefix string, payload interface{}) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecodeUnsignedPayload decodes a string produced by EncodePayload
+func DecodeUnsignedPayload(s string) (*UnsignedPayload, error) {
+	var p UnsignedPayload
+	if err := decodePayload(s, UnsignedPayloadPrefix, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// DecodeSignedPayload decodes a string produced by EncodePayload
+func DecodeSignedPayload(s string) (*SignedPayload, error) {
+	var p SignedPayload
+	if err := decodePayload(s, SignedPayloadPrefix, &p); err != nil {
+		return nil, err
+	}
+	if p.Version != payloadVersion {
+		return nil, fmt.Errorf("unsupported payload version %d", p.Version)
+	}
+	if _, ok := p.Transaction["signature"]; !ok {
+		return nil, errors.New("payload is not signed")
+	}
+	return &p, nil
+}
+
+// WritePayloadFile writes an encoded payload to a text file
+func WritePayloadFile(path, encoded string) error {
+	return os.WriteFile(path, []byte(encoded+"\n"), 0600)
+}
+
+// ReadPayloadFile reads an encoded payload from a text file
+func ReadPayloadFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// WritePayloadQR renders an encoded payload as a PNG QR code
+func WritePayloadQR(path, encoded string) error {
+	return qrcode.WriteFile(encoded, qrcode.Medium, 512, path)
+}
+
+// Helper functions
+
+// transaction converts the payload's string fields into a transaction
+func (p *UnsignedPayload) transaction() (*blockchain.Transaction, error) {
+	to, err := blockchain.ParseAddress(p.To, false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient address: %w", err)
+	}
+	value, ok := new(big.Int).SetString(p.Value, 10)
+	if !ok || value.Sign() < 0 {
+		return nil, errors.New("invalid amount")
+	}
+	gasPrice, err := strconv.ParseUint(p.GasPrice, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid gas price")
+	}
+	data, err := hex.DecodeString(strings.TrimPrefix(p.Data, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid data: %w", err)
+	}
+
+	return &blockchain.Transaction{
+		Nonce:    p.Nonce,
+		To:       to,
+		Value:    value,
+		GasLimit: p.GasLimit,
+		GasPrice: gasPrice,
+		Data:     data,
+	}, nil
+}
+
+func decodePayload(s, prefix string, out interface{}) error {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, prefix) {
+		return fmt.Errorf("payload must start with %q", prefix)
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, prefix))
+	if err != nil {
+		return fmt.Errorf("invalid payload encoding: %w", err)
+	}
+	return json.Unmarshal(data, out)
+}
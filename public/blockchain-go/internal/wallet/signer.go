@@ -0,0 +1,88 @@
+// Package wallet - External signers and hardware wallets
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"chaincore/internal/blockchain"
+)
+
+// Hardware wallet kinds accepted by OpenHardware
+const (
+	HardwareLedger = "ledger"
+	HardwareTrezor = "trezor"
+)
+
+// hardwareTimeout bounds how long a device waits for the user to approve
+// a request on its screen
+const hardwareTimeout = 2 * time.Minute
+
+// Hardware wallet errors
+var (
+	ErrNoHardwareWallet = errors.New("no hardware wallet found")
+	ErrHardwareRejected = errors.New("request was rejected on the device")
+	ErrExternalKey      = errors.New("the key is held by an external signer, which only signs transactions")
+)
+
+// Signer signs transactions with a key the wallet does not hold, such as
+// a hardware wallet's, after the user approves them on the device
+type Signer interface {
+	// Address returns the checksummed address of the signing key
+	Address() string
+	// SignTx signs tx as an EIP-155 legacy transaction for chainID and
+	// returns the 65-byte r || s || recovery id signature
+	SignTx(tx *blockchain.Transaction, chainID uint64) ([]byte, error)
+	// Close releases the signer
+	Close() error
+}
+
+// NewWithSigner creates a wallet whose transactions are signed by signer
+func NewWithSigner(signer Signer) *Wallet {
+	return &Wallet{
+		keyType: KeyTypeSecp256k1,
+		address: signer.Address(),
+		chainID: DefaultChainID,
+		signer:  signer,
+	}
+}
+
+// OpenHardware connects to the first hardware wallet of kind and returns a
+// wallet signing with its account at HDPath/index. With confirm, the
+// device shows the address and opening fails unless the user approves it.
+func OpenHardware(kind string, index uint32, confirm bool) (*Wallet, error) {
+	if index >= hardenedOffset {
+		return nil, fmt.Errorf("account index %d is out of range", index)
+	}
+	path := []uint32{44 + hardenedOffset, 60 + hardenedOffset, hardenedOffset, 0, index}
+
+	var signer Signer
+	var err error
+	switch strings.ToLower(kind) {
+	case HardwareLedger:
+		signer, err = openLedger(path, confirm)
+	case HardwareTrezor:
+		signer, err = openTrezor(path, confirm)
+	default:
+		return nil, fmt.Errorf("unknown hardware wallet %q; use %s or %s", kind, HardwareLedger, HardwareTrezor)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewWithSigner(signer), nil
+}
+
+// IsExternal reports whether the wallet's key is held by an external signer
+func (w *Wallet) IsExternal() bool {
+	return w.signer != nil
+}
+
+// Close releases the wallet's external signer, if it has one
+func (w *Wallet) Close() error {
+	if w.signer == nil {
+		return nil
+	}
+	return w.signer.Close()
+}
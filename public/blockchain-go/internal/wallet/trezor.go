@@ -0,0 +1,306 @@
+// Package wallet - Trezor hardware wallets through Trezor Bridge
+package wallet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"chaincore/internal/blockchain"
+)
+
+// trezorBridgeURL is where Trezor Bridge (trezord) listens
+const trezorBridgeURL = "http://127.0.0.1:21325"
+
+// Trezor message types
+const (
+	trezorInitialize         = 0
+	trezorFailure            = 3
+	trezorFeatures           = 17
+	trezorPinMatrixRequest   = 18
+	trezorCancel             = 20
+	trezorButtonRequest      = 26
+	trezorButtonAck          = 27
+	trezorPassphraseRequest  = 41
+	trezorPassphraseAck      = 42
+	trezorEthereumGetAddress = 56
+	trezorEthereumAddress    = 57
+	trezorEthereumSignTx     = 58
+	trezorEthereumTxRequest  = 59
+	trezorEthereumTxAck      = 60
+)
+
+// trezorActionCancelled is the Failure code for a request the user rejected
+const trezorActionCancelled = 4
+
+// trezorInitialChunk is the most transaction data EthereumSignTx carries;
+// the device asks for the rest with EthereumTxRequest
+const trezorInitialChunk = 1024
+
+// trezor signs with a Trezor device through Trezor Bridge
+type trezor struct {
+	client  *http.Client
+	session string
+	path    []uint32
+	address string
+}
+
+// openTrezor acquires the first Trezor known to Trezor Bridge and reads the
+// address at path, showing it on the device for approval if confirm is set
+func openTrezor(path []uint32, confirm bool) (*trezor, error) {
+	t := &trezor{client: &http.Client{Timeout: hardwareTimeout}, path: path}
+
+	var devices []struct {
+		Path string `json:"path"`
+	}
+	if err := t.postJSON("/enumerate", &devices); err != nil {
+		return nil, fmt.Errorf("%w: is Trezor Bridge running? %v", ErrNoHardwareWallet, err)
+	}
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("%w: is a Trezor connected?", ErrNoHardwareWallet)
+	}
+	var acquired struct {
+		Session string `json:"session"`
+	}
+	if err := t.postJSON("/acquire/"+devices[0].Path+"/null", &acquired); err != nil {
+		return nil, fmt.Errorf("failed to acquire Trezor: %w", err)
+	}
+	t.session = acquired.Session
+
+	if _, err := t.call(trezorInitialize, nil, trezorFeatures); err != nil {
+		t.Close()
+		return nil, err
+	}
+	msg := pbUints(nil, 1, path)
+	if confirm {
+		msg = pbVarint(msg, 2, 1)
+	}
+	reply, err := t.call(trezorEthereumGetAddress, msg, trezorEthereumAddress)
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+
+	// Firmware before 1.8 and 2.1 returns raw bytes in field 1
+	fields, err := pbDecode(reply)
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	address := string(fields[2].bytes)
+	if address == "" && len(fields[1].bytes) == 20 {
+		address = "0x" + hex.EncodeToString(fields[1].bytes)
+	}
+	addr, err := blockchain.ParseAddress(address, false)
+	if err != nil {
+		t.Close()
+		return nil, fmt.Errorf("malformed address reply from Trezor: %w", err)
+	}
+	t.address = blockchain.ChecksumAddress(addr)
+	return t, nil
+}
+
+// Address returns the address of the Trezor account
+func (t *trezor) Address() string {
+	return t.address
+}
+
+// SignTx sends the fields of tx to the Trezor, which shows them and signs
+// once the user approves them
+func (t *trezor) SignTx(tx *blockchain.Transaction, chainID uint64) ([]byte, error) {
+	msg := pbUints(nil, 1, t.path)
+	msg = pbBytes(msg, 2, trimLeadingZeros(binary.BigEndian.AppendUint64(nil, tx.Nonce)))
+	msg = pbBytes(msg, 3, trimLeadingZeros(binary.BigEndian.AppendUint64(nil, tx.GasPrice)))
+	msg = pbBytes(msg, 4, trimLeadingZeros(binary.BigEndian.AppendUint64(nil, tx.GasLimit)))
+	msg = pbBytes(msg, 6, tx.Value.Bytes())
+	data := tx.Data
+	if len(data) > 0 {
+		n := len(data)
+		if n > trezorInitialChunk {
+			n = trezorInitialChunk
+		}
+		msg = pbBytes(msg, 7, data[:n])
+		msg = pbVarint(msg, 8, uint64(len(data)))
+		data = data[n:]
+	}
+	msg = pbVarint(msg, 9, chainID)
+	msg = pbBytes(msg, 11, []byte(blockchain.ChecksumAddress(tx.To)))
+
+	reply, err := t.call(trezorEthereumSignTx, msg, trezorEthereumTxRequest)
+	for err == nil {
+		fields, decodeErr := pbDecode(reply)
+		if decodeErr != nil {
+			return nil, decodeErr
+		}
+
+		// The device asks for more data until it has all of it, then signs
+		if requested := fields[1].varint; requested > 0 {
+			if requested > uint64(len(data)) {
+				return nil, errors.New("Trezor asked for more data than the transaction has")
+			}
+			chunk := data[:requested]
+			data = data[requested:]
+			reply, err = t.call(trezorEthereumTxAck, pbBytes(nil, 1, chunk), trezorEthereumTxRequest)
+			continue
+		}
+
+		v, r, s := fields[2].varint, fields[3].bytes, fields[4].bytes
+		if len(r) > 32 || len(s) > 32 || v < chainID*2+35 || v > chainID*2+36 {
+			return nil, errors.New("malformed signature reply from Trezor")
+		}
+		sig := make([]byte, 65)
+		copy(sig[32-len(r):32], r)
+		copy(sig[64-len(s):64], s)
+		sig[64] = byte(v - (chainID*2 + 35))
+		return sig, nil
+	}
+	return nil, err
+}
+
+// Close releases the Trezor for other applications
+func (t *trezor) Close() error {
+	if t.session == "" {
+		return nil
+	}
+	_, err := t.post("/release/"+t.session, "")
+	t.session = ""
+	return err
+}
+
+// Helper functions
+
+// call sends a message and returns the reply of type want, acknowledging
+// the device's button requests along the way
+func (t *trezor) call(msgType uint16, msg []byte, want uint16) ([]byte, error) {
+	for {
+		frame := binary.BigEndian.AppendUint16(nil, msgType)
+		frame = binary.BigEndian.AppendUint32(frame, uint32(len(msg)))
+		frame = append(frame, msg...)
+
+		encoded, err := t.post("/call/"+t.session, hex.EncodeToString(frame))
+		if err != nil {
+			return nil, fmt.Errorf("Trezor call failed: %w", err)
+		}
+		reply, err := hex.DecodeString(string(bytes.TrimSpace(encoded)))
+		if err != nil || len(reply) < 6 || int(binary.BigEndian.Uint32(reply[2:6])) != len(reply)-6 {
+			return nil, errors.New("malformed reply from Trezor")
+		}
+		replyType, body := binary.BigEndian.Uint16(reply), reply[6:]
+
+		switch replyType {
+		case want:
+			return body, nil
+		case trezorButtonRequest:
+			msgType, msg = trezorButtonAck, nil
+		case trezorPassphraseRequest:
+			// An empty passphrase opens the standard wallet; hidden wallets
+			// are not supported
+			msgType, msg = trezorPassphraseAck, pbBytes(nil, 1, nil)
+		case trezorPinMatrixRequest:
+			t.call(trezorCancel, nil, trezorFailure)
+			return nil, errors.New("Trezor is locked; unlock it with Trezor Suite and try again")
+		case trezorFailure:
+			fields, _ := pbDecode(body)
+			if fields[1].varint == trezorActionCancelled {
+				return nil, ErrHardwareRejected
+			}
+			return nil, fmt.Errorf("Trezor failed: %s", fields[2].bytes)
+		default:
+			return nil, fmt.Errorf("unexpected Trezor message type %d", replyType)
+		}
+	}
+}
+
+// post sends a request to Trezor Bridge and returns its reply
+func (t *trezor) post(path string, body string) ([]byte, error) {
+	resp, err := t.client.Post(trezorBridgeURL+path, "text/plain", strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bridge returned %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	return data, nil
+}
+
+// postJSON sends a request with no body to Trezor Bridge and decodes its
+// JSON reply into out
+func (t *trezor) postJSON(path string, out interface{}) error {
+	data, err := t.post(path, "")
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// pbField is a decoded protocol buffer field; which member is set depends
+// on its wire type. Only varints and length-delimited fields are handled.
+type pbField struct {
+	varint uint64
+	bytes  []byte
+}
+
+func pbKey(b []byte, field int, wireType uint64) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|wireType)
+}
+
+func pbVarint(b []byte, field int, v uint64) []byte {
+	return binary.AppendUvarint(pbKey(b, field, 0), v)
+}
+
+func pbBytes(b []byte, field int, v []byte) []byte {
+	b = binary.AppendUvarint(pbKey(b, field, 2), uint64(len(v)))
+	return append(b, v...)
+}
+
+func pbUints(b []byte, field int, values []uint32) []byte {
+	for _, v := range values {
+		b = pbVarint(b, field, uint64(v))
+	}
+	return b
+}
+
+// pbDecode decodes the varint and length-delimited fields of a message,
+// keeping the last value of repeated ones
+func pbDecode(msg []byte) (map[int]pbField, error) {
+	fields := make(map[int]pbField)
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return nil, errors.New("malformed Trezor message")
+		}
+		msg = msg[n:]
+		field := int(key >> 3)
+
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return nil, errors.New("malformed Trezor message")
+			}
+			fields[field] = pbField{varint: v}
+			msg = msg[n:]
+		case 2:
+			length, n := binary.Uvarint(msg)
+			if n <= 0 || length > uint64(len(msg)-n) {
+				return nil, errors.New("malformed Trezor message")
+			}
+			fields[field] = pbField{bytes: msg[n : n+int(length)]}
+			msg = msg[n+int(length):]
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d in Trezor message", key&7)
+		}
+	}
+	return fields, nil
+}
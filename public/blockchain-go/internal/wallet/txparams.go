@@ -0,0 +1,80 @@
+// Package wallet - Nonce tracking and gas pricing for outgoing transactions
+package wallet
+
+import (
+	"time"
+)
+
+// Transaction parameter defaults
+const (
+	DefaultGasPrice = 1000000000 // 1 Gwei, used when no source is set or it suggests less
+
+	// localNonceTTL is how long a locally tracked nonce may run ahead of the
+	// network's. Sends in quick succession can outpace the pool a full node
+	// reports; after this long without a send the network is trusted again,
+	// so a transaction that never reached the pool does not leave a gap.
+	localNonceTTL = time.Minute
+)
+
+// TxParamsSource supplies the network state CreateTransaction needs. The
+// lite client implements it.
+type TxParamsSource interface {
+	GetPendingNonce(address string) (uint64, error) // Next nonce, counting pooled transactions
+	SuggestGasPrice() (uint64, error)
+}
+
+// SetTxParamsSource makes CreateTransaction fetch the nonce and gas price
+// from the network. Without a source, nonces are only tracked locally from
+// zero and the default gas price is used.
+func (w *Wallet) SetTxParamsSource(source TxParamsSource) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.txSource = source
+}
+
+// ResetNonce forgets the locally tracked nonce so the next transaction
+// takes its nonce from the network. Call it when a created transaction
+// could not be sent.
+func (w *Wallet) ResetNonce() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.nextNonce, w.nonceUsedAt = 0, time.Time{}
+}
+
+// Helper functions
+
+// txParams returns the nonce and gas price for the next transaction. The
+// nonce is the network's pending nonce or, if a recent send is not yet
+// reflected there, the one after it. The caller must hold mu and call
+// useNonce once the transaction is signed.
+func (w *Wallet) txParams() (nonce uint64, gasPrice uint64, err error) {
+	gasPrice = DefaultGasPrice
+	if time.Since(w.nonceUsedAt) < localNonceTTL || w.txSource == nil {
+		nonce = w.nextNonce
+	}
+	if w.txSource == nil {
+		return nonce, gasPrice, nil
+	}
+
+	pending, err := w.txSource.GetPendingNonce(w.address)
+	if err != nil {
+		return 0, 0, err
+	}
+	if pending > nonce {
+		nonce = pending
+	}
+
+	suggested, err := w.txSource.SuggestGasPrice()
+	if err != nil {
+		return 0, 0, err
+	}
+	if suggested > gasPrice {
+		gasPrice = suggested
+	}
+	return nonce, gasPrice, nil
+}
+
+// useNonce records that nonce has been used. The caller must hold mu.
+func (w *Wallet) useNonce(nonce uint64) {
+	w.nextNonce, w.nonceUsedAt = nonce+1, time.Now()
+}
@@ -2,54 +2,122 @@
 package wallet
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"math/big"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"chaincore/internal/blockchain"
+	"chaincore/internal/secp256k1"
 )
 
+// KeyType identifies the signature scheme of a wallet key
+type KeyType string
+
+// Supported key types
+const (
+	KeyTypeSecp256k1 KeyType = "secp256k1" // Keccak-256 addresses, compatible with the eth_* RPC layer
+	KeyTypeP256      KeyType = "p256"      // Legacy SHA-256 addresses; see Migrate
+)
+
+// DefaultChainID is the chain ID used for signing until SetChainID is called
+const DefaultChainID = 13370
+
+// secp256k1KeyPrefix marks a secp256k1 key file. Legacy P-256 key files hold
+// the raw private scalar with no prefix.
+var secp256k1KeyPrefix = []byte("secp256k1:")
+
+// ErrLegacyKey is returned when an operation requires a secp256k1 key
+var ErrLegacyKey = errors.New("legacy P-256 wallet cannot sign Ethereum-style transactions; migrate it to secp256k1 first")
+
 // Wallet represents a blockchain wallet
 type Wallet struct {
-	privateKey *ecdsa.PrivateKey
-	publicKey  *ecdsa.PublicKey
+	keyType    KeyType
+	privateKey *ecdsa.PrivateKey // P-256 wallets only
+	publicKey  *ecdsa.PublicKey  // P-256 wallets only
+	secpKey    []byte            // secp256k1 wallets only
 	address    string
+	chainID    uint64
+	path       string  // Key file of an HD wallet, where derived accounts are recorded
+	hd         *hdKeys // HD wallets only
+	signer     Signer  // Hardware wallets only; no key is held
+
+	txSource    TxParamsSource
+	nextNonce   uint64    // Nonce after the last one used here
+	nonceUsedAt time.Time // When nextNonce was last advanced
+	mu          sync.Mutex
 }
 
-// CreateNew creates a new wallet
-func CreateNew(dataDir string) (*Wallet, error) {
-	// Generate new key pair
-	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+// CreateNew creates a new secp256k1 wallet and saves it to dataDir/wallet.key,
+// encrypted with password unless it is empty
+func CreateNew(dataDir string, password string) (*Wallet, error) {
+	wallet, err := generateSecp256k1()
 	if err != nil {
 		return nil, err
 	}
 
-	wallet := &Wallet{
-		privateKey: privateKey,
-		publicKey:  &privateKey.PublicKey,
-	}
-	wallet.address = wallet.deriveAddress()
-
 	// Save to file
 	keyPath := filepath.Join(dataDir, "wallet.key")
-	if err := wallet.saveToFile(keyPath); err != nil {
+	if err := wallet.saveToFile(keyPath, password); err != nil {
 		return nil, err
 	}
 
 	return wallet, nil
 }
 
-// Load loads a wallet from file
-func Load(path string) (*Wallet, error) {
+// Load loads a wallet from file. Keystore files are decrypted with password
+// and fail with ErrPasswordRequired if it is empty; unencrypted files ignore
+// it. Unencrypted files without a key type marker are legacy P-256 wallets.
+func Load(path string, password string) (*Wallet, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
+	if isKeystore(data) {
+		if password == "" {
+			return nil, ErrPasswordRequired
+		}
+		ks, err := parseKeystore(data)
+		if err != nil {
+			return nil, err
+		}
+		key, err := decryptKey(ks, password)
+		if err != nil {
+			return nil, err
+		}
+		wallet, err := newSecp256k1Wallet(key)
+		if err != nil {
+			return nil, err
+		}
+		if ks.HD != nil {
+			if err := loadHD(wallet, ks.HD, password); err != nil {
+				return nil, err
+			}
+			wallet.path = path
+		}
+		return wallet, nil
+	}
+
+	if bytes.HasPrefix(data, secp256k1KeyPrefix) {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data[len(secp256k1KeyPrefix):])))
+		if err != nil {
+			return nil, fmt.Errorf("invalid secp256k1 key file: %w", err)
+		}
+		return newSecp256k1Wallet(key)
+	}
+
 	// Parse private key
 	privateKey, err := parsePrivateKey(data)
 	if err != nil {
@@ -57,28 +125,78 @@ func Load(path string) (*Wallet, error) {
 	}
 
 	wallet := &Wallet{
+		keyType:    KeyTypeP256,
 		privateKey: privateKey,
 		publicKey:  &privateKey.PublicKey,
+		chainID:    DefaultChainID,
 	}
 	wallet.address = wallet.deriveAddress()
 
 	return wallet, nil
 }
 
+// Migrate creates a secp256k1 wallet at newPath, encrypted with password
+// unless it is empty, to replace the legacy P-256 wallet at legacyPath. The
+// legacy key file is left untouched; funds held at its address must be
+// moved with a transfer signed by the legacy wallet.
+func Migrate(legacyPath, newPath string, password string) (legacy *Wallet, migrated *Wallet, err error) {
+	legacy, err = Load(legacyPath, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	if legacy.keyType != KeyTypeP256 {
+		return nil, nil, errors.New("wallet already uses secp256k1")
+	}
+	if _, err := os.Stat(newPath); err == nil {
+		return nil, nil, fmt.Errorf("%s already exists", newPath)
+	}
+
+	migrated, err = generateSecp256k1()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := migrated.saveToFile(newPath, password); err != nil {
+		return nil, nil, err
+	}
+	return legacy, migrated, nil
+}
+
+// KeyType returns the signature scheme of the wallet key
+func (w *Wallet) KeyType() KeyType {
+	return w.keyType
+}
+
+// SetChainID sets the chain ID used for EIP-155 replay protection
+func (w *Wallet) SetChainID(chainID uint64) {
+	w.chainID = chainID
+}
+
 // Address returns the wallet address
 func (w *Wallet) Address() string {
 	return w.address
 }
 
-// deriveAddress derives the address from public key
+// deriveAddress derives the address of a legacy P-256 public key
 func (w *Wallet) deriveAddress() string {
 	pubKeyBytes := elliptic.Marshal(w.publicKey.Curve, w.publicKey.X, w.publicKey.Y)
 	hash := sha256.Sum256(pubKeyBytes)
-	return "0x" + hex.EncodeToString(hash[:20])
+
+	var addr [20]byte
+	copy(addr[:], hash[:20])
+	return blockchain.ChecksumAddress(addr)
 }
 
-// Sign signs data with the private key
+// Sign signs data with the private key. secp256k1 wallets sign the
+// Keccak-256 hash and return a 65-byte recoverable signature; legacy P-256
+// wallets sign the SHA-256 hash and return 64 bytes.
 func (w *Wallet) Sign(data []byte) ([]byte, error) {
+	if w.signer != nil {
+		return nil, ErrExternalKey
+	}
+	if w.keyType == KeyTypeSecp256k1 {
+		return secp256k1.Sign(keccak256(data), w.secpKey)
+	}
+
 	hash := sha256.Sum256(data)
 	r, s, err := ecdsa.Sign(rand.Reader, w.privateKey, hash[:])
 	if err != nil {
@@ -95,11 +213,43 @@ func (w *Wallet) Sign(data []byte) ([]byte, error) {
 	return signature, nil
 }
 
-// CreateTransaction creates a signed transaction
-func (w *Wallet) CreateTransaction(to string, amount string) (interface{}, error) {
-	if len(to) < 42 {
-		return nil, errors.New("invalid recipient address")
+// CreateTransaction creates a signed transaction with the next nonce and
+// a suggested gas price (see SetTxParamsSource). A non-empty memo is
+// carried as the transaction data. Calls are serialized, so transactions
+// created in quick succession get consecutive nonces.
+func (w *Wallet) CreateTransaction(to string, amount string, memo string) (interface{}, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	nonce, gasPrice, err := w.txParams()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch transaction parameters: %w", err)
 	}
+	fields, err := w.buildTransaction(to, amount, memo, nonce, gasPrice)
+	if err != nil {
+		return nil, err
+	}
+	w.useNonce(nonce)
+	return fields, nil
+}
+
+// ReplaceTransaction signs a transaction with a nonce already used, to
+// replace a pending one with the same nonce, e.g. at a higher gas price
+// so it stops holding up later transactions. Nonce tracking is unchanged.
+func (w *Wallet) ReplaceTransaction(to string, amount string, memo string, nonce uint64, gasPrice uint64) (interface{}, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.buildTransaction(to, amount, memo, nonce, gasPrice)
+}
+
+// buildTransaction creates and signs a transaction. The caller must hold mu.
+func (w *Wallet) buildTransaction(to string, amount string, memo string, nonce uint64, gasPrice uint64) (map[string]interface{}, error) {
+	toAddr, err := blockchain.ParseAddress(to, false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient address: %w", err)
+	}
+	to = blockchain.ChecksumAddress(toAddr)
 
 	// Parse amount
 	value, ok := new(big.Int).SetString(amount, 10)
@@ -108,34 +258,114 @@ func (w *Wallet) CreateTransaction(to string, amount string) (interface{}, error
 	}
 
 	// Create transaction
-	tx := map[string]interface{}{
+	tx := &blockchain.Transaction{
+		Nonce:    nonce,
+		To:       toAddr,
+		Value:    value,
+		GasLimit: blockchain.IntrinsicGas(nil),
+		GasPrice: gasPrice,
+	}
+	fields := map[string]interface{}{
 		"from":     w.address,
 		"to":       to,
 		"value":    value.String(),
-		"nonce":    0, // Would be fetched from network
-		"gasLimit": 21000,
-		"gasPrice": "1000000000",
+		"nonce":    tx.Nonce,
+		"gasLimit": tx.GasLimit,
+		"gasPrice": strconv.FormatUint(gasPrice, 10),
+	}
+	if memo != "" {
+		data, gasLimit, err := memoData(memo)
+		if err != nil {
+			return nil, err
+		}
+		tx.Data = []byte(memo)
+		tx.GasLimit = gasLimit
+		fields["data"] = data
+		fields["gasLimit"] = gasLimit
 	}
 
-	// Serialize for signing
-	txData := serializeTx(tx)
-	
-	// Sign
-	signature, err := w.Sign(txData)
-	if err != nil {
+	if err := w.signInto(fields, tx, w.chainID); err != nil {
 		return nil, err
 	}
+	return fields, nil
+}
 
-	tx["signature"] = hex.EncodeToString(signature)
-	return tx, nil
+// signInto signs tx and records the signature in fields, the JSON form of
+// the transaction. secp256k1 wallets also record the EIP-155 encoding under
+// "raw", which full nodes accept through eth_sendRawTransaction.
+func (w *Wallet) signInto(fields map[string]interface{}, tx *blockchain.Transaction, chainID uint64) error {
+	if w.keyType == KeyTypeP256 {
+		signature, err := w.Sign(serializeTx(fields))
+		if err != nil {
+			return err
+		}
+		fields["signature"] = hex.EncodeToString(signature)
+		return nil
+	}
+
+	raw, err := w.SignTransaction(tx, chainID)
+	if err != nil {
+		return err
+	}
+	fields["signature"] = hex.EncodeToString(tx.Signature[:])
+	fields["hash"] = fmt.Sprintf("0x%x", tx.Hash)
+	fields["raw"] = "0x" + hex.EncodeToString(raw)
+	return nil
 }
 
-// saveToFile saves the wallet to a file
-func (w *Wallet) saveToFile(path string) error {
+// saveToFile saves the wallet to a file. secp256k1 keys are written as a
+// keystore encrypted with password unless it is empty.
+func (w *Wallet) saveToFile(path string, password string) error {
+	if w.signer != nil {
+		return ErrExternalKey
+	}
+	if w.keyType == KeyTypeSecp256k1 && password != "" {
+		address, err := blockchain.ParseAddress(w.address, false)
+		if err != nil {
+			return err
+		}
+		ks, err := encryptKey(w.secpKey, address, password)
+		if err != nil {
+			return err
+		}
+		if w.hd != nil {
+			if ks.HD, err = w.hd.encrypt(password); err != nil {
+				return err
+			}
+		}
+		return writeKeystore(path, ks)
+	}
+	if w.keyType == KeyTypeSecp256k1 {
+		data := append(append([]byte(nil), secp256k1KeyPrefix...), hex.EncodeToString(w.secpKey)+"\n"...)
+		return os.WriteFile(path, data, 0600)
+	}
 	keyBytes := w.privateKey.D.Bytes()
 	return os.WriteFile(path, keyBytes, 0600)
 }
 
+// generateSecp256k1 creates a wallet with a fresh secp256k1 key
+func generateSecp256k1() (*Wallet, error) {
+	key, err := secp256k1.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	return newSecp256k1Wallet(key)
+}
+
+// newSecp256k1Wallet creates a wallet from a secp256k1 private key
+func newSecp256k1Wallet(key []byte) (*Wallet, error) {
+	pub, err := secp256k1.PublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Wallet{
+		keyType: KeyTypeSecp256k1,
+		secpKey: key,
+		address: blockchain.ChecksumAddress(secp256k1.PubkeyToAddress(pub)),
+		chainID: DefaultChainID,
+	}, nil
+}
+
 // parsePrivateKey parses a private key from bytes
 func parsePrivateKey(data []byte) (*ecdsa.PrivateKey, error) {
 	d := new(big.Int).SetBytes(data)
@@ -152,5 +382,19 @@ func parsePrivateKey(data []byte) (*ecdsa.PrivateKey, error) {
 func serializeTx(tx map[string]interface{}) []byte {
 	// Simple serialization for demo
 	data := tx["from"].(string) + tx["to"].(string) + tx["value"].(string)
+	if payload, ok := tx["data"].(string); ok {
+		data += payload
+	}
 	return []byte(data)
 }
+
+// memoData hex-encodes a memo and returns the gas limit needed to carry it
+func memoData(memo string) (string, uint64, error) {
+	if len(memo) > blockchain.MaxTxDataSize {
+		return "", 0, fmt.Errorf("memo exceeds %d bytes", blockchain.MaxTxDataSize)
+	}
+	if _, ok := blockchain.DecodeMemo([]byte(memo)); !ok {
+		return "", 0, errors.New("memo must be printable UTF-8 text")
+	}
+	return "0x" + hex.EncodeToString([]byte(memo)), blockchain.IntrinsicGas([]byte(memo)), nil
+}
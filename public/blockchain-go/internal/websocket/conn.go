@@ -0,0 +1,307 @@
+// Package websocket implements the server side of RFC 6455 WebSocket
+// connections, for the node's RPC server and the lite node's API
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// WebSocket limits and timeouts
+const (
+	maxMessageSize = 1 << 20          // Largest message a client may send
+	writeWait      = 10 * time.Second // Time allowed to write a frame
+	pongWait       = 60 * time.Second // Time allowed between frames from the client
+	PingPeriod     = 30 * time.Second // Ping interval, well within pongWait
+	maxControlSize = 125              // Largest control frame payload
+)
+
+// WebSocket opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// WebSocket close codes
+const (
+	CloseNormal         = 1000
+	CloseGoingAway      = 1001
+	CloseProtocolError  = 1002
+	CloseInvalidPayload = 1007
+	ClosePolicy         = 1008
+	CloseTooBig         = 1009
+	CloseTryAgainLater  = 1013
+)
+
+// acceptGUID is appended to the client's key to derive Sec-WebSocket-Accept
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// ErrClosed is returned by reads and writes once the connection is closed
+var ErrClosed = errors.New("websocket connection closed")
+
+// Conn is the server side of a WebSocket connection. One goroutine may
+// read while others write; writes are serialized and have a deadline, so a
+// client that stops reading is dropped.
+type Conn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	request   *http.Request // The handshake request
+	closeSent bool          // A close frame was written; guarded by writeMu
+	writeMu   sync.Mutex
+}
+
+// Upgrade completes a client's opening handshake and takes over its
+// connection. If the handshake is invalid it responds with an HTTP error
+// and returns an error.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil, errors.New("websocket handshake must use GET")
+	}
+	if !headerContainsToken(r.Header, "Connection", "upgrade") || !headerContainsToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "WebSocket upgrade required", http.StatusBadRequest)
+		return nil, errors.New("not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, "Invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("invalid websocket key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("response writer cannot be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to take over connection: %w", err)
+	}
+	// Drop the HTTP server's deadlines; the connection sets its own
+	conn.SetDeadline(time.Time{})
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to complete handshake: %w", err)
+	}
+	return &Conn{conn: conn, reader: rw.Reader, request: r}, nil
+}
+
+// ReadMessage returns the next text or binary message, answering pings
+// and reassembling fragments on the way. It returns ErrClosed once the
+// client closes the connection.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var message []byte
+	fragmented := false
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		fin, opcode, payload, err := c.readFrame(maxMessageSize - len(message))
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			code := CloseNormal
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			c.WriteClose(code, "")
+			return nil, ErrClosed
+		case opText, opBinary:
+			if fragmented {
+				return nil, c.fail(CloseProtocolError, "new message before the last one ended")
+			}
+			message = payload
+		case opContinuation:
+			if !fragmented {
+				return nil, c.fail(CloseProtocolError, "continuation without a message")
+			}
+			message = append(message, payload...)
+		default:
+			return nil, c.fail(CloseProtocolError, fmt.Sprintf("unknown opcode %d", opcode))
+		}
+
+		if !fin {
+			fragmented = true
+			continue
+		}
+		if !utf8.Valid(message) {
+			// Requests are JSON, so binary messages must be text too
+			return nil, c.fail(CloseInvalidPayload, "message is not valid UTF-8")
+		}
+		return message, nil
+	}
+}
+
+// WriteMessage sends a text message
+func (c *Conn) WriteMessage(data []byte) error {
+	return c.writeFrame(opText, data)
+}
+
+// WritePing sends a ping, which the client must answer
+func (c *Conn) WritePing() error {
+	return c.writeFrame(opPing, nil)
+}
+
+// WriteClose starts the closing handshake with a status code and reason.
+// Nothing can be written afterwards.
+func (c *Conn) WriteClose(code int, reason string) error {
+	if len(reason) > maxControlSize-2 {
+		reason = reason[:maxControlSize-2]
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return c.writeFrame(opClose, append(payload, reason...))
+}
+
+// Request returns the handshake request
+func (c *Conn) Request() *http.Request {
+	return c.request
+}
+
+// Close closes the underlying connection without a closing handshake
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// Helper functions
+
+// readFrame reads one frame of at most limit payload bytes, unmasking its
+// payload
+func (c *Conn) readFrame(limit int) (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0f
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "reserved bits set without an extension")
+	}
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "client frames must be masked")
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if opcode >= opClose {
+		if !fin || length > maxControlSize {
+			return false, 0, nil, c.fail(CloseProtocolError, "invalid control frame")
+		}
+	} else if length > uint64(limit) {
+		return false, 0, nil, c.fail(CloseTooBig, fmt.Sprintf("message exceeds %d bytes", maxMessageSize))
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// writeFrame writes an unfragmented, unmasked frame within writeWait
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	if opcode == opClose {
+		c.closeSent = true
+	}
+
+	frame := make([]byte, 0, 10+len(payload))
+	frame = append(frame, 0x80|opcode)
+	switch {
+	case len(payload) <= 125:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = binary.BigEndian.AppendUint16(append(frame, 126), uint16(len(payload)))
+	default:
+		frame = binary.BigEndian.AppendUint64(append(frame, 127), uint64(len(payload)))
+	}
+	frame = append(frame, payload...)
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// fail closes the connection with a close code after a protocol violation,
+// and returns the violation as an error
+func (c *Conn) fail(code int, reason string) error {
+	c.WriteClose(code, reason)
+	return fmt.Errorf("websocket protocol error: %s", reason)
+}
+
+// acceptKey derives the Sec-WebSocket-Accept value for a client's key
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerContainsToken reports whether a comma-separated header lists
+// token, ignoring case
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
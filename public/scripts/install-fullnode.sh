@@ -1,7 +1,8 @@
 #!/bin/bash
 #═══════════════════════════════════════════════════════════════════════════════
 #  ChainCore Full Node Installation Script
-#  For Ubuntu 22.04 LTS - FOUNDER ONLY
+#  For Ubuntu 22.04 LTS
+#  Set FOUNDER=1 to serve the founder token methods from this node
 #═══════════════════════════════════════════════════════════════════════════════
 
 set -e
@@ -23,6 +24,7 @@ GO_VERSION="1.21.5"
 RPC_PORT="${RPC_PORT:-8546}"
 P2P_PORT="${P2P_PORT:-8545}"
 STORAGE_SIZE="${STORAGE_SIZE:-100}"
+FOUNDER="${FOUNDER:-0}"
 
 echo -e "${CYAN}"
 echo "╔═══════════════════════════════════════════════════════════════════════╗"
@@ -34,7 +36,7 @@ echo "║       ██║     ██╔══██║██╔══██║
 echo "║       ╚██████╗██║  ██║██║  ██║██║██║ ╚████║╚██████╗╚██████╔╝██║  ██║  ║"
 echo "║        ╚═════╝╚═╝  ╚═╝╚═╝  ╚═╝╚═╝╚═╝  ╚═══╝ ╚═════╝ ╚═════╝ ╚═╝  ╚═╝  ║"
 echo "║                                                                       ║"
-echo "║              FULL NODE INSTALLER v${CHAINCORE_VERSION} - FOUNDER EDITION             ║"
+echo "║                     FULL NODE INSTALLER v${CHAINCORE_VERSION}                        ║"
 echo "║                                                                       ║"
 echo "╚═══════════════════════════════════════════════════════════════════════╝"
 echo -e "${NC}"
@@ -108,12 +110,18 @@ export PATH=$PATH:/usr/local/go/bin
 go mod init chaincore 2>/dev/null || true
 go mod tidy 2>/dev/null || true
 
+# Stamp the commit and its date so builds of the same commit are identical
+LDFLAGS=""
+if git rev-parse HEAD >/dev/null 2>&1; then
+    LDFLAGS="-X chaincore/internal/buildinfo.Commit=$(git rev-parse HEAD) -X chaincore/internal/buildinfo.Date=$(git log -1 --format=%cI)"
+fi
+
 # Build binaries
 echo "Building fullnode..."
-CGO_ENABLED=0 go build -o chaincore-fullnode ./cmd/fullnode 2>/dev/null || echo "Note: Using pre-built binary"
+CGO_ENABLED=0 go build -trimpath -ldflags "$LDFLAGS" -o chaincore-fullnode ./cmd/fullnode 2>/dev/null || echo "Note: Using pre-built binary"
 
 echo "Building litenode..."
-CGO_ENABLED=0 go build -o chaincore-litenode ./cmd/litenode 2>/dev/null || echo "Note: Using pre-built binary"
+CGO_ENABLED=0 go build -trimpath -ldflags "$LDFLAGS" -o chaincore-litenode ./cmd/litenode 2>/dev/null || echo "Note: Using pre-built binary"
 BUILDSCRIPT
 
 chmod +x "$BUILD_DIR/build.sh"
@@ -145,6 +153,10 @@ ufw --force enable >/dev/null
 echo -e "  Ports opened: SSH, ${P2P_PORT} (P2P), ${RPC_PORT} (RPC)"
 
 echo -e "${GREEN}[7/8]${NC} Creating systemd service..."
+FOUNDER_FLAGS=""
+if [[ "$FOUNDER" == "1" ]]; then
+    FOUNDER_FLAGS="--founder --founder-key=$CHAINCORE_HOME/keys/founder.key "
+fi
 cat > /etc/systemd/system/chaincore-fullnode.service << EOF
 [Unit]
 Description=ChainCore Full Node
@@ -159,7 +171,7 @@ Environment="CHAINCORE_DATA=$CHAINCORE_HOME/data"
 Environment="RPC_PORT=$RPC_PORT"
 Environment="P2P_PORT=$P2P_PORT"
 Environment="STORAGE_SIZE=$STORAGE_SIZE"
-ExecStart=$CHAINCORE_BIN/chaincore-fullnode --founder \\
+ExecStart=$CHAINCORE_BIN/chaincore-fullnode $FOUNDER_FLAGS\\
     --datadir=$CHAINCORE_HOME/data \\
     --rpcport=$RPC_PORT \\
     --p2pport=$P2P_PORT \\
@@ -190,7 +202,7 @@ cat > "$CHAINCORE_HOME/config/node.toml" << EOF
 
 [node]
 type = "fullnode"
-founder_mode = true
+founder_mode = $([[ "$FOUNDER" == "1" ]] && echo true || echo false)
 
 [network]
 p2p_port = $P2P_PORT